@@ -0,0 +1,192 @@
+// policy-inspect explains which parts of policy.json and registries.d apply to an image,
+// and checks that configuration for likely mistakes.
+//
+// Usage:
+//
+//	policy-inspect [options] lint
+//	policy-inspect [options] resolve IMAGE...
+//
+// IMAGE uses the transport:reference format, e.g. docker://quay.io/ns/repo:tag.
+// "lint" exits with status 1 if any issues were found; "resolve" exits with status 1
+// if any issues relevant to the images were found.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.podman.io/image/v5/pkg/policyinspect"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitIssues  = 1
+	exitFailure = 2
+)
+
+const usageMessage = `Usage:
+  policy-inspect [options] lint
+  policy-inspect [options] resolve IMAGE...
+
+Options:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run implements the command with args (not including the program name), and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("policy-inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usageMessage)
+		flags.PrintDefaults()
+	}
+	policyPath := flags.String("policy", "", "use `PATH` instead of the system policy.json")
+	registriesDirPath := flags.String("registries.d", "", "use registries.d configuration in `DIR`")
+	jsonOutput := flags.Bool("json", false, "format output as JSON")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitFailure
+	}
+
+	sys := &types.SystemContext{
+		SignaturePolicyPath: *policyPath,
+		RegistriesDirPath:   *registriesDirPath,
+	}
+	var (
+		foundIssues bool
+		err         error
+	)
+	switch flags.Arg(0) {
+	case "lint":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return exitFailure
+		}
+		foundIssues, err = lint(sys, stdout, *jsonOutput)
+	case "resolve":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return exitFailure
+		}
+		foundIssues, err = resolve(sys, flags.Args()[1:], stdout, *jsonOutput)
+	default:
+		flags.Usage()
+		return exitFailure
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	if foundIssues {
+		return exitIssues
+	}
+	return exitOK
+}
+
+// lint implements the "lint" subcommand, and returns true if any issues were found.
+func lint(sys *types.SystemContext, stdout io.Writer, jsonOutput bool) (bool, error) {
+	issues, err := policyinspect.Lint(sys)
+	if err != nil {
+		return false, err
+	}
+	if jsonOutput {
+		if err := writeJSON(stdout, issues); err != nil {
+			return false, err
+		}
+	} else {
+		writeIssues(stdout, issues)
+	}
+	return len(issues) != 0, nil
+}
+
+// resolve implements the "resolve" subcommand for images, and returns true if any issues were found.
+func resolve(sys *types.SystemContext, images []string, stdout io.Writer, jsonOutput bool) (bool, error) {
+	reports := []*policyinspect.Report{}
+	for _, image := range images {
+		ref, err := alltransports.ParseImageName(image)
+		if err != nil {
+			return false, err
+		}
+		report, err := policyinspect.Inspect(sys, ref)
+		if err != nil {
+			return false, fmt.Errorf("inspecting %s: %w", image, err)
+		}
+		reports = append(reports, report)
+	}
+
+	foundIssues := false
+	for _, report := range reports {
+		if len(report.Issues) != 0 {
+			foundIssues = true
+		}
+	}
+	if jsonOutput {
+		return foundIssues, writeJSON(stdout, reports)
+	}
+	for i, report := range reports {
+		if i != 0 {
+			fmt.Fprintln(stdout)
+		}
+		if err := writeReport(stdout, report); err != nil {
+			return false, err
+		}
+	}
+	return foundIssues, nil
+}
+
+// writeReport writes a human-readable version of report to stdout.
+func writeReport(stdout io.Writer, report *policyinspect.Report) error {
+	fmt.Fprintf(stdout, "Image: %s\n", report.Image)
+	fmt.Fprintf(stdout, "Policy scope: %s\n", report.Scope.String())
+	fmt.Fprintln(stdout, "Requirements:")
+	for i, req := range report.Requirements {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("formatting requirement %d: %w", i, err)
+		}
+		fmt.Fprintf(stdout, "  %d: %s\n", i, data)
+	}
+	if report.Lookaside != "" {
+		fmt.Fprintf(stdout, "Lookaside: %s\n", report.Lookaside)
+	}
+	if report.UseSigstoreAttachments != nil {
+		fmt.Fprintf(stdout, "Use sigstore attachments: %t\n", *report.UseSigstoreAttachments)
+	}
+	writeIssues(stdout, report.Issues)
+	return nil
+}
+
+// writeIssues writes a human-readable version of issues to stdout.
+func writeIssues(stdout io.Writer, issues []signature.PolicyLintIssue) {
+	if len(issues) == 0 {
+		fmt.Fprintln(stdout, "No issues found.")
+		return
+	}
+	fmt.Fprintln(stdout, "Issues:")
+	for _, issue := range issues {
+		fmt.Fprintf(stdout, "  [%s] %s\n", issue.Kind, issue.String())
+	}
+}
+
+// writeJSON writes v to stdout as indented JSON.
+func writeJSON(stdout io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", data)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/pkg/policyinspect"
+	"go.podman.io/image/v5/signature"
+)
+
+// writeTestConfig writes policyJSON and an empty registries.d, and returns the options to use them.
+func writeTestConfig(t *testing.T, policyJSON string) []string {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.json")
+	err := os.WriteFile(policyPath, []byte(policyJSON), 0o644)
+	require.NoError(t, err)
+	registriesDir := filepath.Join(dir, "registries.d")
+	err = os.Mkdir(registriesDir, 0o755)
+	require.NoError(t, err)
+	return []string{"--policy", policyPath, "--registries.d", registriesDir}
+}
+
+const testPolicy = `{
+	"default": [{"type": "reject"}],
+	"transports": {
+		"docker": {
+			"quay.io": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "../../signature/fixtures/public-key.gpg"}],
+			"quay.io/insecure": [{"type": "insecureAcceptAnything"}]
+		}
+	}
+}`
+
+func TestRunLint(t *testing.T) {
+	opts := writeTestConfig(t, testPolicy)
+
+	var stdout, stderr bytes.Buffer
+	res := run(append(opts, "lint"), &stdout, &stderr)
+	assert.Equal(t, exitIssues, res)
+	assert.Contains(t, stdout.String(), `[insecureUnderSignedScope] transport "docker" scope "quay.io/insecure"`)
+	assert.Empty(t, stderr.String())
+
+	stdout.Reset()
+	res = run(append(opts, "--json", "lint"), &stdout, &stderr)
+	assert.Equal(t, exitIssues, res)
+	var issues []signature.PolicyLintIssue
+	err := json.Unmarshal(stdout.Bytes(), &issues)
+	require.NoError(t, err)
+	require.Len(t, issues, 1)
+	assert.Equal(t, signature.PolicyLintInsecureUnderSignedScope, issues[0].Kind)
+
+	stdout.Reset()
+	opts = writeTestConfig(t, `{"default": [{"type": "reject"}]}`)
+	res = run(append(opts, "lint"), &stdout, &stderr)
+	assert.Equal(t, exitOK, res)
+	assert.Equal(t, "No issues found.\n", stdout.String())
+}
+
+func TestRunResolve(t *testing.T) {
+	opts := writeTestConfig(t, testPolicy)
+
+	var stdout, stderr bytes.Buffer
+	res := run(append(opts, "resolve", "docker://quay.io/ns/repo:latest", "docker://quay.io/insecure:latest"), &stdout, &stderr)
+	assert.Equal(t, exitIssues, res)
+	assert.Contains(t, stdout.String(), "Image: docker://quay.io/ns/repo:latest\nPolicy scope: transport \"docker\" scope \"quay.io\"\nRequirements:\n  0: {\"type\":\"signedBy\"")
+	assert.Contains(t, stdout.String(), "Image: docker://quay.io/insecure:latest\nPolicy scope: transport \"docker\" scope \"quay.io/insecure\"\n")
+	assert.Empty(t, stderr.String())
+
+	stdout.Reset()
+	res = run(append(opts, "--json", "resolve", "docker://quay.io/ns/repo:latest"), &stdout, &stderr)
+	assert.Equal(t, exitOK, res)
+	var reports []struct {
+		policyinspect.Report
+		Requirements []json.RawMessage `json:"requirements"`
+	}
+	err := json.Unmarshal(stdout.Bytes(), &reports)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "quay.io"}, reports[0].Scope)
+	assert.Len(t, reports[0].Requirements, 1)
+	assert.Empty(t, reports[0].Issues)
+}
+
+func TestRunErrors(t *testing.T) {
+	opts := writeTestConfig(t, testPolicy)
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"lint", "extra"},
+		{"resolve"},
+		{"--unknown-option", "lint"},
+		append(opts, "resolve", "this is not an image name"),
+		append(writeTestConfig(t, "this is not JSON"), "lint"),
+	} {
+		var stdout, stderr bytes.Buffer
+		res := run(args, &stdout, &stderr)
+		assert.Equal(t, exitFailure, res, "%#v", args)
+		assert.NotEmpty(t, stderr.String(), "%#v", args)
+	}
+
+	var stdout, stderr bytes.Buffer
+	res := run([]string{"--help"}, &stdout, &stderr)
+	assert.Equal(t, exitOK, res)
+	assert.Contains(t, stderr.String(), "Usage:")
+}
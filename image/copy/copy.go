@@ -158,6 +158,19 @@ type Options struct {
 	// to not indicate "nondistributable".
 	DownloadForeignLayers bool
 
+	// PreferLazyLayers asks the destination to register layers which support partial pulls as “lazy”, if it can,
+	// so that layer contents are provided by an external source when first accessed, instead of being
+	// fully pulled during the copy.
+	// Currently only the containers-storage: transport supports this, using the first Additional Layer Store
+	// configured for the overlay driver. The reference implementation in go.podman.io/storage/pkg/chunked/als
+	// has no filesystem server, so it fetches the whole layer from the source registry when the layer is first used.
+	PreferLazyLayers bool
+
+	// CopyReferrers copies artifacts which refer to the copied manifests using the OCI “subject” field (“referrers”,
+	// e.g. SBOMs and attestations), if the source transport supports reading them.
+	// Copying fails if the destination transport does not support storing them.
+	CopyReferrers bool
+
 	// Contains slice of OptionCompressionVariant, where copy will ensure that for each platform
 	// in the manifest list, a variant with the requested compression will exist.
 	// Invalid when copying a non-multi-architecture image. That will probably
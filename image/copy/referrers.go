@@ -0,0 +1,65 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/transports"
+)
+
+// sourceReferrers returns referrers (SBOMs, attestations, …) of the source manifest manifestBlob, if requested by c.options.CopyReferrers,
+// and verifies that they can be stored (to avoid copying a large image when we can tell in advance that it would ultimately fail).
+func (c *copier) sourceReferrers(ctx context.Context, manifestBlob []byte) ([]private.Referrer, error) {
+	if !c.options.CopyReferrers {
+		return nil, nil
+	}
+	referrersSource, ok := c.rawSource.(private.ReferrersSource)
+	if !ok {
+		logrus.Debugf("Source %s does not support referrers, not copying any", transports.ImageName(c.rawSource.Reference()))
+		return nil, nil
+	}
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	if err != nil {
+		return nil, fmt.Errorf("computing digest of source image's manifest: %w", err)
+	}
+	c.Printf("Getting image source referrers\n")
+	refs, err := referrersSource.GetReferrers(ctx, &manifestDigest)
+	if err != nil {
+		return nil, fmt.Errorf("reading referrers of %s: %w", manifestDigest, err)
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if _, ok := c.dest.(private.ReferrersDestination); !ok {
+		return nil, fmt.Errorf("Can not copy referrers to %s: destination does not support referrers", transports.ImageName(c.dest.Reference()))
+	}
+	return refs, nil
+}
+
+// putReferrers stores refs, which refer to the source manifest, for the manifest with destManifestDigest,
+// identified by targetInstance.
+func (c *copier) putReferrers(ctx context.Context, refs []private.Referrer, srcManifestBlob []byte, destManifestDigest digest.Digest, targetInstance *digest.Digest) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	matches, err := manifest.MatchesDigest(srcManifestBlob, destManifestDigest)
+	if err != nil {
+		return fmt.Errorf("computing digest of source image's manifest: %w", err)
+	}
+	if !matches {
+		return fmt.Errorf("Internal error: referrers can not be copied because the manifest was modified to %s", destManifestDigest)
+	}
+	referrersDest, ok := c.dest.(private.ReferrersDestination)
+	if !ok { // Should have been rejected by sourceReferrers
+		return fmt.Errorf("Internal error: destination %s does not support referrers", transports.ImageName(c.dest.Reference()))
+	}
+	c.Printf("Storing referrers\n")
+	if err := referrersDest.PutReferrers(ctx, refs, targetInstance); err != nil {
+		return fmt.Errorf("writing referrers: %w", err)
+	}
+	return nil
+}
@@ -120,6 +120,10 @@ func (c *copier) copySingleImage(ctx context.Context, unparsedImage *image.Unpar
 	if err != nil {
 		return copySingleImageResult{}, err
 	}
+	refs, err := c.sourceReferrers(ctx, src.ManifestBlob)
+	if err != nil {
+		return copySingleImageResult{}, err
+	}
 
 	// Determine if we're allowed to modify the manifest.
 	// If we can, set to the empty string. If we can't, set to the reason why.
@@ -128,6 +132,9 @@ func (c *copier) copySingleImage(ctx context.Context, unparsedImage *image.Unpar
 	if len(sigs) > 0 {
 		cannotModifyManifestReason = "Would invalidate signatures"
 	}
+	if len(refs) > 0 {
+		cannotModifyManifestReason = "Would invalidate referrers"
+	}
 	if destIsDigestedReference {
 		cannotModifyManifestReason = "Destination specifies a digest"
 	}
@@ -213,7 +220,7 @@ func (c *copier) copySingleImage(ctx context.Context, unparsedImage *image.Unpar
 
 	// If enabled, fetch and compare the destination's manifest. And as an optimization skip updating the destination iff equal
 	if c.options.OptimizeDestinationImageAlreadyExists {
-		shouldUpdateSigs := len(sigs) > 0 || len(c.signers) != 0 // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
+		shouldUpdateSigs := len(sigs) > 0 || len(c.signers) != 0 || len(refs) > 0 // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
 		noPendingManifestUpdates := ic.noPendingManifestUpdates()
 
 		logrus.Debugf("Checking if we can skip copying: has signatures=%t, OCI encryption=%t, no manifest updates=%t, compression match required for reusing blobs=%t", shouldUpdateSigs, destRequiresOciEncryption, noPendingManifestUpdates, opts.requireCompressionFormatMatch)
@@ -312,6 +319,9 @@ func (c *copier) copySingleImage(ctx context.Context, unparsedImage *image.Unpar
 			return copySingleImageResult{}, fmt.Errorf("writing signatures: %w", err)
 		}
 	}
+	if err := c.putReferrers(ctx, refs, src.ManifestBlob, wipResult.manifestDigest, targetInstance); err != nil {
+		return copySingleImageResult{}, err
+	}
 	wipResult.compressionAlgorithms = compressionAlgos
 	res := wipResult // We are done
 	return res, nil
@@ -813,6 +823,8 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 				Cache:      ic.c.blobInfoCache,
 				EmptyLayer: emptyLayer,
 				LayerIndex: layerIndex,
+				SrcRef:     srcRef,
+				PreferLazy: ic.c.options.PreferLazyLayers,
 			})
 			if err == nil {
 				if srcInfo.Size != -1 {
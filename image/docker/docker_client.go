@@ -50,6 +50,7 @@ const (
 	blobsPath               = "/v2/%s/blobs/%s"
 	blobUploadPath          = "/v2/%s/blobs/uploads/"
 	extensionsSignaturePath = "/extensions/v2/%s/signatures/%s"
+	referrersPath           = "/v2/%s/referrers/%s"
 
 	minimumTokenLifetimeSeconds = 60
 
@@ -90,6 +91,7 @@ type bearerToken struct {
 	err            error // nil if the token was successfully obtained (but may be expired); an error if the next lock holder _must_ obtain a new token.
 	token          string
 	expirationTime time.Time
+	refreshToken   string // A refresh token returned by the server along with token, if any; only used to update c.auth.IdentityToken.
 }
 
 // dockerClient is configuration for dealing with a single container registry.
@@ -103,7 +105,8 @@ type dockerClient struct {
 	// by detectProperties(). Callers can edit tlsClientConfig.InsecureSkipVerify in the meantime.
 	tlsClientConfig *tls.Config
 	// The following members are not set by newDockerClient and must be set by callers if needed.
-	auth                   types.DockerAuthConfig
+	auth                   types.DockerAuthConfig     // auth.IdentityToken can only be accessed with authLock held after the client starts being used.
+	authLocation           config.CredentialsLocation // The location of auth, for updating it using config.SetIdentityToken.
 	registryToken          string
 	signatureBase          lookasideStorageBase
 	useSigstoreAttachments bool
@@ -120,8 +123,10 @@ type dockerClient struct {
 	supportsSignatures bool
 
 	// Private state for setupRequestAuth
+	authLock       sync.Mutex // Protects auth.IdentityToken, which is updated if the registry rotates refresh tokens.
 	tokenCacheLock sync.Mutex // Protects tokenCache.
 	tokenCache     map[string]*bearerToken
+	diskTokenCache *diskTokenCache // nil if tokens are not cached on disk.
 	// Private state for detectProperties:
 	detectPropertiesOnce  sync.Once // detectPropertiesOnce is used to execute detectProperties() at most once.
 	detectPropertiesError error     // detectPropertiesError caches the initial error.
@@ -205,7 +210,7 @@ func dockerCertDir(sys *types.SystemContext, hostPort string) (string, error) {
 // signatureBase is always set in the return value
 // The caller must call .Close() on the returned client when done.
 func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, registryConfig *registryConfiguration, write bool, actions string) (*dockerClient, error) {
-	auth, err := config.GetCredentialsForRef(sys, ref.ref)
+	auth, authLocation, err := config.GetCredentialsAndLocationForRef(sys, ref.ref)
 	if err != nil {
 		return nil, fmt.Errorf("getting username and password: %w", err)
 	}
@@ -221,6 +226,7 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, regis
 		return nil, err
 	}
 	client.auth = auth
+	client.authLocation = authLocation
 	if sys != nil {
 		client.registryToken = sys.DockerBearerRegistryToken
 	}
@@ -299,6 +305,7 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 		userAgent:        userAgent,
 		tlsClientConfig:  tlsClientConfig,
 		tokenCache:       map[string]*bearerToken{},
+		diskTokenCache:   newDiskTokenCache(sys),
 		reportedWarnings: set.New[string](),
 	}, nil
 }
@@ -823,10 +830,10 @@ func (c *dockerClient) obtainBearerToken(ctx context.Context, challenge challeng
 		return token.token, nil // We have a usable token already.
 	}
 
-	if c.auth.IdentityToken != "" {
-		err = c.getBearerTokenOAuth2(ctx, token, challenge, scopes)
+	if c.diskTokenCache != nil {
+		err = c.getBearerTokenUsingDiskCache(ctx, c.diskTokenCache, token, challenge, scopes)
 	} else {
-		err = c.getBearerToken(ctx, token, challenge, scopes)
+		err = c.getBearerTokenFromRegistry(ctx, token, challenge, scopes)
 	}
 	token.err = err
 	if token.err != nil {
@@ -835,11 +842,51 @@ func (c *dockerClient) obtainBearerToken(ctx context.Context, challenge challeng
 	return token.token, nil
 }
 
+// getBearerTokenFromRegistry obtains a new "Authorization: Bearer" token for challenge and scopes from the registry,
+// and writes it into dest.
+func (c *dockerClient) getBearerTokenFromRegistry(ctx context.Context, dest *bearerToken, challenge challenge,
+	scopes []authScope,
+) error {
+	if identityToken := c.identityToken(); identityToken != "" {
+		return c.getBearerTokenOAuth2(ctx, dest, challenge, scopes, identityToken)
+	}
+	return c.getBearerToken(ctx, dest, challenge, scopes)
+}
+
+// identityToken returns the current value of c.auth.IdentityToken.
+func (c *dockerClient) identityToken() string {
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+	return c.auth.IdentityToken
+}
+
+// updateIdentityToken replaces c.auth.IdentityToken, if it is still oldToken, with newToken,
+// and records newToken in the credential storage it was originally read from, if any.
+func (c *dockerClient) updateIdentityToken(oldToken, newToken string) {
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+	if c.auth.IdentityToken != oldToken {
+		return // Another goroutine has already rotated the token.
+	}
+	c.auth.IdentityToken = newToken
+	if c.authLocation == (config.CredentialsLocation{}) {
+		logrus.Debugf("Registry %s returned a new refresh token, using it only in this process", c.registry)
+		return
+	}
+	desc, err := config.SetIdentityToken(c.authLocation, newToken)
+	if err != nil {
+		logrus.Warnf("Error recording a new refresh token returned by registry %s: %v", c.registry, err)
+		return
+	}
+	logrus.Debugf("Recorded a new refresh token returned by registry %s in %s", c.registry, desc)
+}
+
 // getBearerTokenOAuth2 obtains an "Authorization: Bearer" token using a pre-existing identity token per
 // https://github.com/distribution/distribution/blob/main/docs/spec/auth/oauth.md for challenge and scopes,
 // and writes it into dest.
+// If the server rotates the refresh token, c.auth.IdentityToken is updated, and so are the stored credentials.
 func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, dest *bearerToken, challenge challenge,
-	scopes []authScope,
+	scopes []authScope, identityToken string,
 ) error {
 	realm, ok := challenge.Parameters["realm"]
 	if !ok {
@@ -864,7 +911,7 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, dest *bearerTok
 		}
 	}
 	params.Add("grant_type", "refresh_token")
-	params.Add("refresh_token", c.auth.IdentityToken)
+	params.Add("refresh_token", identityToken)
 	params.Add("client_id", "containers/image")
 
 	authReq.Body = io.NopCloser(strings.NewReader(params.Encode()))
@@ -880,7 +927,13 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, dest *bearerTok
 		return err
 	}
 
-	return dest.readFromHTTPResponseBody(res)
+	if err := dest.readFromHTTPResponseBody(res); err != nil {
+		return err
+	}
+	if dest.refreshToken != "" && dest.refreshToken != identityToken {
+		c.updateIdentityToken(identityToken, dest.refreshToken)
+	}
+	return nil
 }
 
 // getBearerToken obtains an "Authorization: Bearer" token using a GET request, per
@@ -945,6 +998,7 @@ func (bt *bearerToken) readFromHTTPResponseBody(res *http.Response) error {
 	var token struct {
 		Token          string    `json:"token"`
 		AccessToken    string    `json:"access_token"`
+		RefreshToken   string    `json:"refresh_token"`
 		ExpiresIn      int       `json:"expires_in"`
 		IssuedAt       time.Time `json:"issued_at"`
 		expirationTime time.Time
@@ -962,6 +1016,7 @@ func (bt *bearerToken) readFromHTTPResponseBody(res *http.Response) error {
 	if bt.token == "" {
 		bt.token = token.AccessToken
 	}
+	bt.refreshToken = token.RefreshToken
 
 	if token.ExpiresIn < minimumTokenLifetimeSeconds {
 		token.ExpiresIn = minimumTokenLifetimeSeconds
@@ -1276,6 +1331,69 @@ func (c *dockerClient) getExtensionsSignatures(ctx context.Context, ref dockerRe
 	return &parsedBody, nil
 }
 
+// getReferrersIndex returns descriptors of referrers of manifestDigest in ref, using the OCI referrers API if the registry
+// supports it, or the referrers tag schema otherwise; usingTagSchema is true in the latter case.
+func (c *dockerClient) getReferrersIndex(ctx context.Context, ref dockerReference, manifestDigest digest.Digest) (descriptors []imgspecv1.Descriptor, usingTagSchema bool, err error) {
+	if err := manifestDigest.Validate(); err != nil { // Make sure manifestDigest.String() does not contain any unexpected characters
+		return nil, false, err
+	}
+	path := fmt.Sprintf(referrersPath, reference.Path(ref.ref), manifestDigest)
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("fetching referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxReferrersListBodySize)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+		}
+		index, err := manifest.OCI1IndexFromManifest(body)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), err)
+		}
+		return index.Manifests, false, nil
+	case http.StatusNotFound:
+		// The registry does not support the referrers API; fall back to the tag schema.
+	default:
+		return nil, false, fmt.Errorf("fetching referrers of %s in %s: %w", manifestDigest, ref.ref.Name(), registryHTTPResponseToError(res))
+	}
+
+	tag, err := referrersTag(manifestDigest)
+	if err != nil {
+		return nil, false, err
+	}
+	logrus.Debugf("Referrers API not supported, looking for referrers in tag %s", tag)
+	indexBlob, mimeType, err := c.fetchManifest(ctx, ref, tag)
+	if err != nil {
+		if isManifestUnknownError(err) {
+			logrus.Debugf("Fetching referrers tag failed, assuming it does not exist: %v", err)
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	if mimeType != imgspecv1.MediaTypeImageIndex {
+		return nil, false, fmt.Errorf("unexpected MIME type for referrers tag %s in %s: %q", tag, ref.ref.Name(), mimeType)
+	}
+	index, err := manifest.OCI1IndexFromManifest(indexBlob)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing referrers tag %s in %s: %w", tag, ref.ref.Name(), err)
+	}
+	return index.Manifests, true, nil
+}
+
+// referrersTag returns a tag used by the referrers tag schema for the specified digest.
+func referrersTag(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil { // Make sure d.String() doesn’t contain any unexpected characters
+		return "", err
+	}
+	return strings.Replace(d.String(), ":", "-", 1), nil
+}
+
 // sigstoreAttachmentTag returns a sigstore attachment tag for the specified digest.
 func sigstoreAttachmentTag(d digest.Digest) (string, error) {
 	if err := d.Validate(); err != nil { // Make sure d.String() doesn’t contain any unexpected characters
@@ -15,9 +15,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/internal/useragent"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/docker/config"
 	"go.podman.io/image/v5/types"
 )
 
@@ -539,3 +544,104 @@ func TestResolveRequestURLWithNamespaceProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBearerTokenOAuth2RefreshTokenRotation(t *testing.T) {
+	var expectedRefreshToken string
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		assert.Equal(t, expectedRefreshToken, r.PostForm.Get("refresh_token"))
+		assert.Equal(t, "test-service", r.PostForm.Get("service"))
+		assert.Equal(t, []string{"repository:ns/repo:pull", "repository:ns/other:pull"}, r.PostForm["scope"])
+		fmt.Fprintf(w, `{"access_token":"access%d","refresh_token":"refresh%d","expires_in":3600}`, requests, requests+1)
+	}))
+	defer s.Close()
+	ch := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": s.URL + "/token", "service": "test-service"}}
+
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	err := os.WriteFile(authFile, []byte(`{"auths":{"registry.example.com":{"auth":"Og==","identitytoken":"refresh1"}}}`), 0o600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{AuthFilePath: authFile}
+
+	ref, err := reference.ParseNamed("registry.example.com/ns/repo")
+	require.NoError(t, err)
+	for i := 1; i <= 2; i++ {
+		auth, authLocation, err := config.GetCredentialsAndLocationForRef(sys, ref)
+		require.NoError(t, err)
+		c := &dockerClient{
+			sys:          sys,
+			registry:     "registry.example.com",
+			userAgent:    "test",
+			auth:         auth,
+			authLocation: authLocation,
+			scope:        authScope{resourceType: "repository", remoteName: "ns/repo", actions: "pull"},
+			client:       s.Client(),
+			tokenCache:   map[string]*bearerToken{},
+		}
+		expectedRefreshToken = fmt.Sprintf("refresh%d", i)
+		token, err := c.obtainBearerToken(context.Background(), ch, &authScope{resourceType: "repository", remoteName: "ns/other", actions: "pull"})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("access%d", i), token)
+		assert.Equal(t, fmt.Sprintf("refresh%d", i+1), c.identityToken())
+	}
+	auth, err := config.GetCredentials(sys, "registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{IdentityToken: "refresh3"}, auth)
+}
+
+func TestGetReferrersIndex(t *testing.T) {
+	subject := digest.FromString("subject")
+	referrer := imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       digest.FromString("referrer"),
+		Size:         42,
+		ArtifactType: "application/spdx+json",
+	}
+	indexBlob, err := manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{referrer}, nil).Serialize()
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name                   string
+		referrersAPI, tagIndex bool
+		expected               []imgspecv1.Descriptor
+		expectedTagSchema      bool
+	}{
+		{"referrers API", true, false, []imgspecv1.Descriptor{referrer}, false},
+		{"tag schema", false, true, []imgspecv1.Descriptor{referrer}, true},
+		{"tag schema, no referrers", false, false, nil, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/v2/":
+					w.WriteHeader(http.StatusOK)
+				case r.URL.Path == "/v2/repo/referrers/"+subject.String() && c.referrersAPI:
+					w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+					_, _ = w.Write(indexBlob)
+				case r.URL.Path == "/v2/repo/manifests/sha256-"+subject.Encoded() && c.tagIndex:
+					w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+					_, _ = w.Write(indexBlob)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer s.Close()
+			registry := strings.TrimPrefix(s.URL, "http://")
+			sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+			client, err := newDockerClient(sys, registry, registry)
+			require.NoError(t, err)
+			named, err := reference.ParseNormalizedNamed(registry + "/repo:latest")
+			require.NoError(t, err)
+			ref, err := newReference(named, false)
+			require.NoError(t, err)
+
+			descriptors, usingTagSchema, err := client.getReferrersIndex(context.Background(), ref, subject)
+			require.NoError(t, err)
+			assert.Equal(t, c.expectedTagSchema, usingTagSchema)
+			assert.Equal(t, c.expected, descriptors)
+		})
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"go.podman.io/image/v5/internal/iolimits"
 	"go.podman.io/image/v5/internal/private"
 	"go.podman.io/image/v5/internal/putblobdigest"
+	"go.podman.io/image/v5/internal/referrers"
 	"go.podman.io/image/v5/internal/set"
 	"go.podman.io/image/v5/internal/signature"
 	"go.podman.io/image/v5/internal/streamdigest"
@@ -835,6 +836,72 @@ func (d *dockerImageDestination) putBlobBytesAsOCI(ctx context.Context, contents
 	}, nil
 }
 
+// PutReferrers writes referrers (e.g. SBOMs or attestations) of the manifest with instanceDigest,
+// or of the primary manifest if instanceDigest is nil; the Subject of every referrer must refer to that manifest.
+// MUST be called after PutManifest.
+func (d *dockerImageDestination) PutReferrers(ctx context.Context, refs []private.Referrer, instanceDigest *digest.Digest) error {
+	if instanceDigest == nil {
+		if d.manifestDigest == "" {
+			// This shouldn’t happen, ImageDestination users are required to call PutManifest before PutReferrers
+			return errors.New("Unknown manifest digest, can't add referrers")
+		}
+		instanceDigest = &d.manifestDigest
+	}
+
+	added := []imgspecv1.Descriptor{}
+	for _, r := range refs {
+		r, err := referrers.Validate(r, *instanceDigest)
+		if err != nil {
+			return err
+		}
+		m, _, err := referrers.Parse(r.Manifest)
+		if err != nil {
+			return err
+		}
+		for i, blob := range referrers.BlobDescriptors(m) {
+			// We don’t benefit from a real BlobInfoCache here because we never try to reuse/mount referrer blobs.
+			if _, err := d.PutBlobWithOptions(ctx, bytes.NewReader(r.Blobs[blob.Digest]), manifest.BlobInfoFromOCI1Descriptor(blob), private.PutBlobOptions{
+				Cache:      none.NoCache,
+				IsConfig:   i == 0,
+				EmptyLayer: false,
+				LayerIndex: nil,
+			}); err != nil {
+				return fmt.Errorf("writing blob %s of referrer %s: %w", blob.Digest.String(), r.Descriptor.Digest.String(), err)
+			}
+		}
+		logrus.Debugf("Uploading referrer manifest %s", r.Descriptor.Digest.String())
+		if err := d.uploadManifest(ctx, r.Manifest, r.Descriptor.Digest.String()); err != nil {
+			return err
+		}
+		added = append(added, r.Descriptor)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	existing, usingTagSchema, err := d.c.getReferrersIndex(ctx, d.ref, *instanceDigest)
+	if err != nil {
+		return err
+	}
+	if !usingTagSchema {
+		return nil // The registry tracks referrers itself.
+	}
+	index := manifest.OCI1IndexFromComponents(slices.DeleteFunc(slices.Clone(existing), func(e imgspecv1.Descriptor) bool {
+		return slices.ContainsFunc(added, func(a imgspecv1.Descriptor) bool { return a.Digest == e.Digest })
+	}), nil)
+	index.Manifests = append(index.Manifests, added...)
+	indexBlob, err := index.Serialize()
+	if err != nil {
+		return err
+	}
+	tag, err := referrersTag(*instanceDigest)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Updating referrers tag %s", tag)
+	return d.uploadManifest(ctx, indexBlob, tag)
+}
+
 // deleteOneSignature deletes a signature from sigURL, if it exists.
 // If it successfully determines that the signature does not exist, returns (true, nil)
 // NOTE: Keep this in sync with docs/signature-protocols.md!
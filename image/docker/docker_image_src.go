@@ -23,12 +23,14 @@ import (
 	"github.com/docker/distribution/registry/api/errcode"
 	v2 "github.com/docker/distribution/registry/api/v2"
 	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/internal/imagesource/impl"
 	"go.podman.io/image/v5/internal/imagesource/stubs"
 	"go.podman.io/image/v5/internal/iolimits"
 	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/internal/referrers"
 	"go.podman.io/image/v5/internal/signature"
 	"go.podman.io/image/v5/manifest"
 	"go.podman.io/image/v5/pkg/blobinfocache/none"
@@ -192,7 +194,7 @@ func newImageSourceAttempt(ctx context.Context, sys *types.SystemContext, logica
 			endpoint.ref.ref.String(): {
 				Username:      endpoint.client.auth.Username,
 				Password:      endpoint.client.auth.Password,
-				IdentityToken: endpoint.client.auth.IdentityToken,
+				IdentityToken: endpoint.client.identityToken(),
 			},
 		}
 		acfD, err := json.Marshal(acf)
@@ -962,6 +964,56 @@ func (s *dockerImageSource) appendSignaturesFromSigstoreAttachments(ctx context.
 	return nil
 }
 
+// GetReferrers returns the referrers (e.g. SBOMs or attestations) of the manifest with instanceDigest,
+// or of the primary manifest if instanceDigest is nil.  It may use a remote (= slow) service.
+func (s *dockerImageSource) GetReferrers(ctx context.Context, instanceDigest *digest.Digest) ([]private.Referrer, error) {
+	manifestDigest, err := s.manifestDigest(ctx, instanceDigest)
+	if err != nil {
+		return nil, err
+	}
+	descriptors, _, err := s.c.getReferrersIndex(ctx, s.physicalRef, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	res := []private.Referrer{}
+	for _, desc := range descriptors {
+		if desc.MediaType != imgspecv1.MediaTypeImageManifest {
+			logrus.Debugf("Ignoring referrer %s with unsupported MIME type %q", desc.Digest.String(), desc.MediaType)
+			continue
+		}
+		if err := desc.Digest.Validate(); err != nil { // Make sure desc.Digest.String() does not contain any unexpected characters
+			return nil, fmt.Errorf("invalid referrer digest %q: %w", desc.Digest.String(), err)
+		}
+		logrus.Debugf("Fetching referrer %s", desc.Digest.String())
+		manifestBlob, _, err := s.c.fetchManifest(ctx, s.physicalRef, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		m, _, err := referrers.Parse(manifestBlob)
+		if err != nil {
+			return nil, fmt.Errorf("referrer %s: %w", desc.Digest.String(), err)
+		}
+		blobs := map[digest.Digest][]byte{}
+		for _, blob := range referrers.BlobDescriptors(m) {
+			if _, ok := blobs[blob.Digest]; ok {
+				continue
+			}
+			// We don’t benefit from a real BlobInfoCache here because we never try to reuse/mount referrer blobs.
+			contents, err := s.c.getOCIDescriptorContents(ctx, s.physicalRef, blob, iolimits.MaxReferrerBlobSize, none.NoCache)
+			if err != nil {
+				return nil, err
+			}
+			blobs[blob.Digest] = contents
+		}
+		r, err := referrers.Validate(private.Referrer{Descriptor: desc, Manifest: manifestBlob, Blobs: blobs}, manifestDigest)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
 // deleteImage deletes the named image from the registry, if supported.
 func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerReference) error {
 	if ref.isUnknownDigest {
@@ -0,0 +1,289 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/internal/iolimits"
+	"go.podman.io/image/v5/internal/set"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/compression"
+)
+
+// legacyLayer is a single layer (“image”) subdirectory of a legacy archive, as created by (docker save)
+// before Docker 1.10, which does not contain manifest.json.
+type legacyLayer struct {
+	id        string
+	version   []byte // Contents of legacyVersionFileName, or nil if missing
+	config    []byte // Contents of legacyConfigFileName, or nil if missing
+	parent    string // From config
+	layerPath string // Path of the physical layer tarball (with a symlink at legacyLayerFileName resolved), or "" if missing
+	diffID    digest.Digest
+}
+
+// legacyIgnoredConfigFields are fields of a legacy layer config which are not a part of image configs.
+// Based on github.com/docker/docker/image/v1.MakeConfigFromV1Config.
+var legacyIgnoredConfigFields = []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"}
+
+// loadLegacyManifest fills r.Manifest and r.legacyConfigs by synthesizing a manifest.json item and a config
+// for every image in a legacy archive.
+func (r *Reader) loadLegacyManifest() error {
+	layers, repositories, err := r.readLegacyMetadata()
+	if err != nil {
+		return err
+	}
+	if len(layers) == 0 {
+		return errors.New("no legacy layer directories found")
+	}
+	for _, l := range layers {
+		if l.config == nil {
+			return fmt.Errorf("legacy layer %q has no %s file", l.id, legacyConfigFileName)
+		}
+		if l.version == nil {
+			return fmt.Errorf("legacy layer %q has no %s file", l.id, legacyVersionFileName)
+		}
+		if v := strings.TrimSpace(string(l.version)); v != "1.0" {
+			return fmt.Errorf("legacy layer %q has unsupported version %q", l.id, v)
+		}
+		if l.layerPath == "" {
+			return fmt.Errorf("legacy layer %q has no %s file", l.id, legacyLayerFileName)
+		}
+		var parsed struct {
+			ID     string `json:"id"`
+			Parent string `json:"parent,omitempty"`
+		}
+		if err := json.Unmarshal(l.config, &parsed); err != nil {
+			return fmt.Errorf("decoding config of legacy layer %q: %w", l.id, err)
+		}
+		if parsed.ID != l.id {
+			return fmt.Errorf("legacy layer %q has a config with ID %q", l.id, parsed.ID)
+		}
+		l.parent = parsed.Parent
+	}
+	if err := r.computeLegacyDiffIDs(layers); err != nil {
+		return err
+	}
+
+	// Images are identified by their top layers.
+	repoTags := map[string][]string{}
+	for repo, tags := range repositories {
+		for tag, id := range tags {
+			repoTags[id] = append(repoTags[id], repo+":"+tag)
+		}
+	}
+	if len(repoTags) == 0 {
+		// Without tags, every layer which is not a parent of another one is an image.
+		parents := set.New[string]()
+		for _, l := range layers {
+			parents.Add(l.parent)
+		}
+		for id := range layers {
+			if !parents.Contains(id) {
+				repoTags[id] = nil
+			}
+		}
+	}
+
+	r.legacyConfigs = map[string][]byte{}
+	r.Manifest = []ManifestItem{}
+	for _, topID := range slices.Sorted(maps.Keys(repoTags)) {
+		chain := []*legacyLayer{}
+		for id := topID; id != ""; {
+			l, ok := layers[id]
+			if !ok {
+				return fmt.Errorf("legacy layer %q not found", id)
+			}
+			if len(chain) >= len(layers) {
+				return fmt.Errorf("legacy layer %q has a parent loop", topID)
+			}
+			chain = append(chain, l)
+			id = l.parent
+		}
+		slices.Reverse(chain)
+
+		config, err := legacyImageConfig(chain)
+		if err != nil {
+			return err
+		}
+		configPath := digest.Canonical.FromBytes(config).Encoded() + ".json"
+		r.legacyConfigs[configPath] = config
+		item := ManifestItem{
+			Config:   configPath,
+			RepoTags: repoTags[topID],
+			Layers:   []string{},
+		}
+		slices.Sort(item.RepoTags)
+		for _, l := range chain {
+			item.Layers = append(item.Layers, l.layerPath)
+		}
+		r.Manifest = append(r.Manifest, item)
+	}
+	return nil
+}
+
+// readLegacyMetadata returns the layer subdirectories of a legacy archive, indexed by ID, and contents of its
+// repositories file, if any.
+func (r *Reader) readLegacyMetadata() (map[string]*legacyLayer, map[string]map[string]string, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	layers := map[string]*legacyLayer{}
+	var repositories map[string]map[string]string
+	t := tar.NewReader(f)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		name := path.Clean(h.Name)
+		if name == legacyRepositoriesFileName {
+			b, err := iolimits.ReadAtMost(t, iolimits.MaxTarFileManifestSize)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading %s: %w", legacyRepositoriesFileName, err)
+			}
+			if err := json.Unmarshal(b, &repositories); err != nil {
+				return nil, nil, fmt.Errorf("decoding %s: %w", legacyRepositoriesFileName, err)
+			}
+			continue
+		}
+		dir, file := path.Split(name)
+		dir = path.Clean(dir)
+		if dir == "." || strings.Contains(dir, "/") || strings.HasPrefix(dir, "..") ||
+			(file != legacyVersionFileName && file != legacyConfigFileName && file != legacyLayerFileName) {
+			continue
+		}
+		l, ok := layers[dir]
+		if !ok {
+			l = &legacyLayer{id: dir}
+			layers[dir] = l
+		}
+		switch file {
+		case legacyVersionFileName:
+			if l.version, err = iolimits.ReadAtMost(t, iolimits.MaxTarFileManifestSize); err != nil {
+				return nil, nil, fmt.Errorf("reading %q: %w", name, err)
+			}
+		case legacyConfigFileName:
+			if l.config, err = iolimits.ReadAtMost(t, iolimits.MaxConfigBodySize); err != nil {
+				return nil, nil, fmt.Errorf("reading %q: %w", name, err)
+			}
+		case legacyLayerFileName:
+			switch h.Typeflag {
+			case tar.TypeReg:
+				l.layerPath = name
+			case tar.TypeSymlink:
+				// As in openTarComponent, we follow only one symlink.
+				l.layerPath = path.Join(dir, h.Linkname)
+			default:
+				return nil, nil, fmt.Errorf("legacy layer %q is not a regular file", name)
+			}
+		}
+	}
+	return layers, repositories, nil
+}
+
+// computeLegacyDiffIDs sets diffID of all layers by reading the (possibly compressed) layer tarballs.
+func (r *Reader) computeLegacyDiffIDs(layers map[string]*legacyLayer) error {
+	pending := map[string][]*legacyLayer{}
+	for _, l := range layers {
+		pending[l.layerPath] = append(pending[l.layerPath], l)
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	t := tar.NewReader(f)
+	for len(pending) != 0 {
+		h, err := t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(h.Name)
+		users, ok := pending[name]
+		if !ok {
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			return fmt.Errorf("legacy layer tarball %q is not a regular file", name)
+		}
+		uncompressed, _, err := compression.AutoDecompress(t)
+		if err != nil {
+			return fmt.Errorf("auto-decompressing %q: %w", name, err)
+		}
+		digester := digest.Canonical.Digester()
+		_, err = io.Copy(digester.Hash(), uncompressed)
+		uncompressed.Close()
+		if err != nil {
+			return fmt.Errorf("reading %q: %w", name, err)
+		}
+		for _, l := range users {
+			l.diffID = digester.Digest()
+		}
+		delete(pending, name)
+	}
+	if len(pending) != 0 {
+		return fmt.Errorf("legacy layer tarballs are missing: %s", strings.Join(slices.Sorted(maps.Keys(pending)), ", "))
+	}
+	return nil
+}
+
+// legacyImageConfig returns an image config for chain, ordered from the base layer to the top layer,
+// based on the config of the top layer.
+// Based on github.com/docker/docker/image/v1.MakeConfigFromV1Config.
+func legacyImageConfig(chain []*legacyLayer) ([]byte, error) {
+	top := chain[len(chain)-1]
+	var config map[string]*json.RawMessage
+	if err := json.Unmarshal(top.config, &config); err != nil {
+		return nil, fmt.Errorf("decoding config of legacy layer %q: %w", top.id, err)
+	}
+	for _, field := range legacyIgnoredConfigFields {
+		delete(config, field)
+	}
+
+	rootFS := manifest.Schema2RootFS{
+		Type:    "layers",
+		DiffIDs: []digest.Digest{},
+	}
+	history := []manifest.Schema2History{}
+	for _, l := range chain {
+		var v1 manifest.Schema2V1Image
+		if err := json.Unmarshal(l.config, &v1); err != nil {
+			return nil, fmt.Errorf("decoding config of legacy layer %q: %w", l.id, err)
+		}
+		rootFS.DiffIDs = append(rootFS.DiffIDs, l.diffID)
+		history = append(history, manifest.Schema2History{
+			Created:   v1.Created,
+			Author:    v1.Author,
+			CreatedBy: strings.Join(v1.ContainerConfig.Cmd, " "),
+			Comment:   v1.Comment,
+		})
+	}
+	for key, value := range map[string]any{"rootfs": rootFS, "history": history} {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		raw := json.RawMessage(b)
+		config[key] = &raw
+	}
+	return json.Marshal(config)
+}
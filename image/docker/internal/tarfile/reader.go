@@ -23,6 +23,8 @@ type Reader struct {
 	path          string         // "" if the archive has already been closed.
 	removeOnClose bool           // Remove file on close if true
 	Manifest      []ManifestItem // Guaranteed to exist after the archive is created.
+	// Configs synthesized for a legacy archive without manifest.json, indexed by the path used in Manifest; nil for other archives.
+	legacyConfigs map[string][]byte
 }
 
 // NewReaderFromFile returns a Reader for the specified path.
@@ -111,14 +113,20 @@ func newReader(path string, removeOnClose bool) (*Reader, error) {
 	// removes the need to synchronize the access/creation of the data if the archive is later
 	// used from multiple goroutines to access different images.
 
-	// FIXME? Do we need to deal with the legacy format?
 	bytes, err := r.readTarComponent(manifestFileName, iolimits.MaxTarFileManifestSize)
-	if err != nil {
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(bytes, &r.Manifest); err != nil {
+			return nil, fmt.Errorf("decoding tar manifest.json: %w", err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// Archives created by (docker save) before Docker 1.10 only contain per-layer metadata.
+		if err := r.loadLegacyManifest(); err != nil {
+			return nil, fmt.Errorf("tar archive has no manifest.json, and loading it in the legacy format failed: %w", err)
+		}
+	default:
 		return nil, err
 	}
-	if err := json.Unmarshal(bytes, &r.Manifest); err != nil {
-		return nil, fmt.Errorf("decoding tar manifest.json: %w", err)
-	}
 
 	succeeded = true
 	return &r, nil
@@ -260,6 +268,9 @@ func findTarComponent(inputFile io.Reader, componentPath string) (*tar.Reader, *
 // readTarComponent returns full contents of componentPath.
 // It is safe to call this method from multiple goroutines simultaneously.
 func (r *Reader) readTarComponent(path string, limit int) ([]byte, error) {
+	if config, ok := r.legacyConfigs[path]; ok {
+		return config, nil
+	}
 	file, err := r.openTarComponent(path)
 	if err != nil {
 		return nil, fmt.Errorf("loading tar component %q: %w", path, err)
@@ -0,0 +1,140 @@
+package tarfile
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/types"
+)
+
+// layerTarball returns an uncompressed layer tarball containing a single file with contents.
+func layerTarball(t *testing.T, contents string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, Size: int64(len(contents))}))
+	_, err := tw.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestNewReaderLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	const baseID = "1111111111111111111111111111111111111111111111111111111111111111"
+	const topID = "2222222222222222222222222222222222222222222222222222222222222222"
+	baseLayer := layerTarball(t, "base")
+	topLayer := layerTarball(t, "top")
+	var compressedTopLayer bytes.Buffer
+	gzw := gzip.NewWriter(&compressedTopLayer)
+	_, err := gzw.Write(topLayer)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	addFile := func(name string, contents []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(contents))}))
+		_, err := tw.Write(contents)
+		require.NoError(t, err)
+	}
+	addFile(baseID+"/VERSION", []byte("1.0"))
+	addFile(baseID+"/json", []byte(`{"id":"`+baseID+`","created":"2015-01-01T00:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file"]},"os":"linux"}`))
+	addFile(baseID+"/layer.tar", baseLayer)
+	addFile(topID+"/VERSION", []byte("1.0\n"))
+	addFile(topID+"/json", []byte(`{"id":"`+topID+`","parent":"`+baseID+`","created":"2015-01-02T00:00:00Z","author":"someone",`+
+		`"container_config":{"Cmd":["/bin/sh","-c","touch top"]},"config":{"Cmd":["/bin/sh"]},"architecture":"amd64","os":"linux","Size":3}`))
+	addFile("top.tar.gz", compressedTopLayer.Bytes())
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: topID + "/layer.tar", Linkname: "../top.tar.gz"}))
+	addFile("repositories", []byte(`{"example.com/repo":{"latest":"`+topID+`","v1":"`+topID+`"}}`))
+	require.NoError(t, tw.Close())
+
+	reader, err := NewReaderFromStream(nil, &archive)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.Len(t, reader.Manifest, 1)
+	assert.Equal(t, []string{"example.com/repo:latest", "example.com/repo:v1"}, reader.Manifest[0].RepoTags)
+	assert.Equal(t, []string{baseID + "/layer.tar", "top.tar.gz"}, reader.Manifest[0].Layers)
+
+	ref, err := reference.ParseNormalizedNamed("example.com/repo:v1")
+	require.NoError(t, err)
+	src := NewSource(reader, false, "transport name", ref.(reference.NamedTagged), -1)
+	defer src.Close()
+	manifestBlob, mimeType, err := src.GetManifest(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, mimeType)
+	m, err := manifest.Schema2FromManifest(manifestBlob)
+	require.NoError(t, err)
+	baseDiffID := digest.FromBytes(baseLayer)
+	topDiffID := digest.FromBytes(topLayer)
+	require.Len(t, m.LayersDescriptors, 2)
+	assert.Equal(t, baseDiffID, m.LayersDescriptors[0].Digest)
+	assert.Equal(t, int64(len(baseLayer)), m.LayersDescriptors[0].Size)
+	assert.Equal(t, topDiffID, m.LayersDescriptors[1].Digest)
+	assert.Equal(t, int64(len(topLayer)), m.LayersDescriptors[1].Size)
+
+	cache := memory.New()
+	configStream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: m.ConfigDescriptor.Digest, Size: -1}, cache)
+	require.NoError(t, err)
+	configBlob, err := io.ReadAll(configStream)
+	require.NoError(t, err)
+	assert.Equal(t, m.ConfigDescriptor.Digest, digest.FromBytes(configBlob))
+	var config map[string]any
+	require.NoError(t, json.Unmarshal(configBlob, &config))
+	for _, removed := range []string{"id", "parent", "Size"} {
+		assert.NotContains(t, config, removed)
+	}
+	var parsedConfig manifest.Schema2Image
+	require.NoError(t, json.Unmarshal(configBlob, &parsedConfig))
+	assert.Equal(t, "amd64", parsedConfig.Architecture)
+	assert.Equal(t, "someone", parsedConfig.Author)
+	assert.Equal(t, []digest.Digest{baseDiffID, topDiffID}, parsedConfig.RootFS.DiffIDs)
+	require.Len(t, parsedConfig.History, 2)
+	assert.Equal(t, "/bin/sh -c #(nop) ADD file", parsedConfig.History[0].CreatedBy)
+	assert.Equal(t, "/bin/sh -c touch top", parsedConfig.History[1].CreatedBy)
+	assert.Equal(t, "someone", parsedConfig.History[1].Author)
+
+	layerStream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: topDiffID, Size: -1}, cache)
+	require.NoError(t, err)
+	layerContents, err := io.ReadAll(layerStream)
+	require.NoError(t, err)
+	require.NoError(t, layerStream.Close())
+	assert.Equal(t, topLayer, layerContents)
+}
+
+func TestNewReaderLegacyFormatErrors(t *testing.T) {
+	const id = "1111111111111111111111111111111111111111111111111111111111111111"
+	for _, c := range []struct {
+		name  string
+		files map[string]string
+	}{
+		{"empty", map[string]string{}},
+		{"missing VERSION", map[string]string{id + "/json": `{"id":"` + id + `"}`, id + "/layer.tar": ""}},
+		{"unknown VERSION", map[string]string{id + "/VERSION": "2.0", id + "/json": `{"id":"` + id + `"}`, id + "/layer.tar": ""}},
+		{"missing json", map[string]string{id + "/VERSION": "1.0", id + "/layer.tar": ""}},
+		{"missing layer.tar", map[string]string{id + "/VERSION": "1.0", id + "/json": `{"id":"` + id + `"}`}},
+		{"ID mismatch", map[string]string{id + "/VERSION": "1.0", id + "/json": `{"id":"other"}`, id + "/layer.tar": ""}},
+		{"missing parent", map[string]string{id + "/VERSION": "1.0", id + "/json": `{"id":"` + id + `","parent":"missing"}`, id + "/layer.tar": ""}},
+	} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		for name, contents := range c.files {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(contents))}), c.name)
+			_, err := tw.Write([]byte(contents))
+			require.NoError(t, err, c.name)
+		}
+		require.NoError(t, tw.Close(), c.name)
+		_, err := NewReaderFromStream(nil, &archive)
+		assert.Error(t, err, c.name)
+	}
+}
@@ -63,6 +63,22 @@ func SignatureStorageBaseURL(sys *types.SystemContext, ref types.ImageReference,
 	return config.lookasideStorageBaseURL(dr, write)
 }
 
+// SigstoreAttachmentsEnabled reads configuration to determine whether sigstore signatures are read from, and written to,
+// sigstore attachments for ref.
+// Warning: This function only exposes configuration in registries.d.
+func SigstoreAttachmentsEnabled(sys *types.SystemContext, ref types.ImageReference) (bool, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return false, errors.New("ref must be a dockerReference")
+	}
+	config, err := loadRegistryConfiguration(sys)
+	if err != nil {
+		return false, err
+	}
+
+	return config.useSigstoreAttachments(dr), nil
+}
+
 // loadRegistryConfiguration returns a registryConfiguration appropriate for sys.
 func loadRegistryConfiguration(sys *types.SystemContext) (*registryConfiguration, error) {
 	registriesFiles := configfile.File{
@@ -72,6 +72,35 @@ func TestSignatureStorageBaseURL(t *testing.T) {
 	}
 }
 
+func TestSigstoreAttachmentsEnabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpDir, "config.yaml"), []byte(`docker:
+  example.com:
+    use-sigstore-attachments: true
+  example.com/not-this:
+    use-sigstore-attachments: false
+`), 0o600)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		dir, ref string
+		expected bool
+	}{
+		{t.TempDir(), "//example.com/my/project", false},
+		{tmpDir, "//example.com/my/project", true},
+		{tmpDir, "//example.com/not-this/project", false},
+		{tmpDir, "//other.example.com/my/project", false},
+	} {
+		res, err := SigstoreAttachmentsEnabled(&types.SystemContext{RegistriesDirPath: c.dir}, dockerRefFromString(t, c.ref))
+		require.NoError(t, err, c.ref)
+		assert.Equal(t, c.expected, res, c.ref)
+	}
+
+	// Error reading configuration directory (/dev/null is not a directory)
+	_, err = SigstoreAttachmentsEnabled(&types.SystemContext{RegistriesDirPath: "/dev/null"}, dockerRefFromString(t, "//busybox"))
+	assert.Error(t, err)
+}
+
 func TestLoadRegistryConfiguration(t *testing.T) {
 	type testcase struct {
 		setup              func(t *testing.T) *types.SystemContext
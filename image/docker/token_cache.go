@@ -0,0 +1,171 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/lockfile"
+)
+
+// diskTokenCacheMinimumRemainingLifetime is the minimum remaining lifetime of a token loaded from
+// the on-disk cache; tokens closer to expiration than this are treated as already expired,
+// so that they don’t expire while we are still making requests with them.
+const diskTokenCacheMinimumRemainingLifetime = 10 * time.Second
+
+// diskTokenCache is a bearer token cache stored in a directory, shared across all processes
+// that use the same directory.
+//
+// Each entry is stored in a separate file, protected by a separate lock file, so that
+// processes obtaining tokens for unrelated scopes don’t block each other.
+type diskTokenCache struct {
+	dir string
+}
+
+// diskTokenCacheKey identifies a single entry of diskTokenCache.
+// It is never stored as is, only its digest is used, so that the file names don’t leak the credentials.
+type diskTokenCacheKey struct {
+	Registry    string   `json:"registry"`
+	Realm       string   `json:"realm"`
+	Service     string   `json:"service"`
+	Scopes      []string `json:"scopes"`
+	Credentials string   `json:"credentials"` // A digest of the credentials used to obtain the token, if any
+}
+
+// diskTokenCacheEntry is the on-disk format of a single cached token.
+type diskTokenCacheEntry struct {
+	Token          string    `json:"token"`
+	ExpirationTime time.Time `json:"expiration_time"`
+}
+
+// newDiskTokenCache returns a diskTokenCache configured by sys, or nil if the on-disk cache is not enabled.
+func newDiskTokenCache(sys *types.SystemContext) *diskTokenCache {
+	if sys == nil || sys.DockerBearerTokenCacheDir == "" {
+		return nil
+	}
+	return &diskTokenCache{dir: sys.DockerBearerTokenCacheDir}
+}
+
+// entryPathPrefix returns the path prefix (without an extension) of files used for key.
+func (dc *diskTokenCache) entryPathPrefix(key diskTokenCacheKey) (string, error) {
+	keyBytes, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	keyDigest := sha256.Sum256(keyBytes)
+	return filepath.Join(dc.dir, hex.EncodeToString(keyDigest[:])), nil
+}
+
+// lock returns a lock for key, and the path of the file containing key’s entry.
+func (dc *diskTokenCache) lock(key diskTokenCacheKey) (*lockfile.LockFile, string, error) {
+	prefix, err := dc.entryPathPrefix(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(dc.dir, 0o700); err != nil {
+		return nil, "", err
+	}
+	lock, err := lockfile.GetLockFile(prefix + ".lock")
+	if err != nil {
+		return nil, "", err
+	}
+	return lock, prefix + ".json", nil
+}
+
+// load reads a token from path, and returns it if it is usable.
+// The caller must hold the lock returned by dc.lock().
+func (dc *diskTokenCache) load(path string) (diskTokenCacheEntry, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Debugf("Error reading cached bearer token %q: %v", path, err)
+		}
+		return diskTokenCacheEntry{}, false
+	}
+	var entry diskTokenCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		logrus.Debugf("Error parsing cached bearer token %q: %v", path, err)
+		return diskTokenCacheEntry{}, false
+	}
+	if entry.Token == "" || time.Now().Add(diskTokenCacheMinimumRemainingLifetime).After(entry.ExpirationTime) {
+		return diskTokenCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// store records entry in path.
+// The caller must hold the lock returned by dc.lock().
+func (dc *diskTokenCache) store(path string, entry diskTokenCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(path, data, 0o600)
+}
+
+// diskTokenCacheKey returns a key identifying a token for challenge and scopes, obtained using c.auth.
+func (c *dockerClient) diskTokenCacheKey(challenge challenge, scopes []authScope) diskTokenCacheKey {
+	key := diskTokenCacheKey{
+		Registry: c.registry,
+		Realm:    challenge.Parameters["realm"],
+		Service:  challenge.Parameters["service"],
+		Scopes:   []string{},
+	}
+	for _, scope := range scopes {
+		if scope.resourceType != "" && scope.remoteName != "" && scope.actions != "" {
+			key.Scopes = append(key.Scopes, fmt.Sprintf("%s:%s:%s", scope.resourceType, scope.remoteName, scope.actions))
+		}
+	}
+	identityToken := c.identityToken()
+	if c.auth.Username != "" || c.auth.Password != "" || identityToken != "" {
+		credentials, err := json.Marshal([]string{c.auth.Username, c.auth.Password, identityToken})
+		if err == nil {
+			credentialsDigest := sha256.Sum256(credentials)
+			key.Credentials = hex.EncodeToString(credentialsDigest[:])
+		}
+	}
+	return key
+}
+
+// getBearerTokenUsingDiskCache obtains an "Authorization: Bearer" token from cache, if it contains a usable one,
+// or from the registry, in which case it is recorded in cache. In both cases the token is written into dest.
+func (c *dockerClient) getBearerTokenUsingDiskCache(ctx context.Context, cache *diskTokenCache, dest *bearerToken, challenge challenge,
+	scopes []authScope,
+) error {
+	lock, path, err := cache.lock(c.diskTokenCacheKey(challenge, scopes))
+	if err != nil {
+		logrus.Debugf("Not using the bearer token cache in %q: %v", cache.dir, err)
+		return c.getBearerTokenFromRegistry(ctx, dest, challenge, scopes)
+	}
+	// Hold the lock while talking to the registry, so that concurrent processes wait for us and reuse the token
+	// instead of all asking for their own.
+	lock.Lock()
+	defer lock.Unlock()
+
+	if entry, ok := cache.load(path); ok {
+		logrus.Debugf("Using a cached bearer token from %q", path)
+		dest.token = entry.Token
+		dest.expirationTime = entry.ExpirationTime
+		return nil
+	}
+
+	if err := c.getBearerTokenFromRegistry(ctx, dest, challenge, scopes); err != nil {
+		return err
+	}
+	if err := cache.store(path, diskTokenCacheEntry{
+		Token:          dest.token,
+		ExpirationTime: dest.expirationTime,
+	}); err != nil {
+		// Failing to cache the token is not fatal, we have a usable token anyway.
+		logrus.Debugf("Error caching bearer token in %q: %v", path, err)
+	}
+	return nil
+}
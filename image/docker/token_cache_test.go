@@ -0,0 +1,100 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/types"
+	"golang.org/x/sync/semaphore"
+)
+
+// newTokenCacheTestClient returns a dockerClient which uses a token cache in cacheDir, if not "".
+func newTokenCacheTestClient(s *httptest.Server, cacheDir string, auth types.DockerAuthConfig) *dockerClient {
+	return &dockerClient{
+		registry:       "registry.example.com",
+		userAgent:      "test",
+		auth:           auth,
+		scope:          authScope{resourceType: "repository", remoteName: "ns/repo", actions: "pull"},
+		client:         s.Client(),
+		tokenCache:     map[string]*bearerToken{},
+		diskTokenCache: newDiskTokenCache(&types.SystemContext{DockerBearerTokenCacheDir: cacheDir}),
+	}
+}
+
+func TestDiskTokenCache(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		fmt.Fprintf(w, `{"token":"token%d","expires_in":3600}`, n)
+	}))
+	defer s.Close()
+	ch := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": s.URL + "/token", "service": "test"}}
+
+	cacheDir := filepath.Join(t.TempDir(), "tokens")
+
+	// Separate clients, simulating separate processes, share the token.
+	for range 2 {
+		c := newTokenCacheTestClient(s, cacheDir, types.DockerAuthConfig{})
+		token, err := c.obtainBearerToken(context.Background(), ch, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "token1", token)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".json" {
+			fi, err := e.Info()
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+		}
+	}
+
+	// Different scopes and different credentials use separate tokens.
+	c := newTokenCacheTestClient(s, cacheDir, types.DockerAuthConfig{})
+	token, err := c.obtainBearerToken(context.Background(), ch, &authScope{resourceType: "repository", remoteName: "ns/other", actions: "pull"})
+	require.NoError(t, err)
+	assert.Equal(t, "token2", token)
+	c = newTokenCacheTestClient(s, cacheDir, types.DockerAuthConfig{Username: "user", Password: "pass"})
+	token, err = c.obtainBearerToken(context.Background(), ch, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "token3", token)
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Without the on-disk cache, every client obtains a new token.
+	c = newTokenCacheTestClient(s, "", types.DockerAuthConfig{})
+	assert.Nil(t, c.diskTokenCache)
+	token, err = c.obtainBearerToken(context.Background(), ch, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "token4", token)
+}
+
+func TestDiskTokenCacheExpiration(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		// An issued_at far in the past, so that the token is expired immediately.
+		fmt.Fprintf(w, `{"token":"token%d","expires_in":3600,"issued_at":"2018-01-01T10:00:02+00:00"}`, n)
+	}))
+	defer s.Close()
+	ch := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": s.URL + "/token", "service": "test"}}
+
+	cacheDir := t.TempDir()
+	for i := range 2 {
+		c := newTokenCacheTestClient(s, cacheDir, types.DockerAuthConfig{})
+		dest := &bearerToken{lock: semaphore.NewWeighted(1)}
+		err := c.getBearerTokenUsingDiskCache(context.Background(), c.diskTokenCache, dest, ch, []authScope{c.scope})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("token%d", i+1), dest.token)
+	}
+	assert.Equal(t, int32(2), requests.Load())
+}
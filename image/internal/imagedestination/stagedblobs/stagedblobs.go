@@ -0,0 +1,131 @@
+// Package stagedblobs stores blobs in a local directory, for image destinations which
+// only process the image when it is committed.
+package stagedblobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/internal/imagedestination/impl"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/internal/putblobdigest"
+	"go.podman.io/image/v5/pkg/compression"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/archive"
+)
+
+// Dir stores blobs, named by their digests, in a directory.
+type Dir struct {
+	path string
+}
+
+// New returns a Dir storing blobs in path, which must already exist.
+func New(path string) *Dir {
+	return &Dir{path: path}
+}
+
+// BlobPath returns a path for storing a blob with blobDigest.
+func (d *Dir) BlobPath(blobDigest digest.Digest) (string, error) {
+	if err := blobDigest.Validate(); err != nil { // digest.Digest.Encoded() panics on failure, and could possibly result in unexpected paths, so validate explicitly.
+		return "", err
+	}
+	return filepath.Join(d.path, blobDigest.Algorithm().String()+"-"+blobDigest.Encoded()), nil
+}
+
+// PutBlob implements private.ImageDestination.PutBlobWithOptions, by storing the contents of stream in d.
+func (d *Dir) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo) (private.UploadedBlob, error) {
+	blobFile, err := os.CreateTemp(d.path, "put-blob")
+	if err != nil {
+		return private.UploadedBlob{}, err
+	}
+	succeeded := false
+	explicitClosed := false
+	defer func() {
+		if !explicitClosed {
+			blobFile.Close()
+		}
+		if !succeeded {
+			os.Remove(blobFile.Name())
+		}
+	}()
+
+	digester, stream := putblobdigest.DigestIfUnknown(stream, inputInfo)
+	// TODO: This can take quite some time, and should ideally be cancellable using ctx.Done().
+	size, err := io.Copy(blobFile, stream)
+	if err != nil {
+		return private.UploadedBlob{}, err
+	}
+	blobDigest := digester.Digest()
+	if inputInfo.Size != -1 && size != inputInfo.Size {
+		return private.UploadedBlob{}, fmt.Errorf("Size mismatch when copying %s, expected %d, got %d", blobDigest, inputInfo.Size, size)
+	}
+	blobPath, err := d.BlobPath(blobDigest)
+	if err != nil {
+		return private.UploadedBlob{}, err
+	}
+	blobFile.Close()
+	explicitClosed = true
+	if err := os.Rename(blobFile.Name(), blobPath); err != nil {
+		return private.UploadedBlob{}, err
+	}
+	succeeded = true
+	return private.UploadedBlob{Digest: blobDigest, Size: size}, nil
+}
+
+// TryReusingBlob implements private.ImageDestination.TryReusingBlobWithOptions, by looking for a blob already stored in d.
+func (d *Dir) TryReusingBlob(info types.BlobInfo, options private.TryReusingBlobOptions) (bool, private.ReusedBlob, error) {
+	if !impl.OriginalCandidateMatchesTryReusingBlobOptions(options) {
+		return false, private.ReusedBlob{}, nil
+	}
+	if info.Digest == "" {
+		return false, private.ReusedBlob{}, errors.New("Can not check for a blob with unknown digest")
+	}
+	blobPath, err := d.BlobPath(info.Digest)
+	if err != nil {
+		return false, private.ReusedBlob{}, err
+	}
+	fi, err := os.Stat(blobPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, private.ReusedBlob{}, nil
+		}
+		return false, private.ReusedBlob{}, err
+	}
+	return true, private.ReusedBlob{Digest: info.Digest, Size: fi.Size()}, nil
+}
+
+// ApplyLayer applies the stored (possibly compressed) layer described by layerInfo to rootfsPath,
+// handling whiteouts and opaque directories.
+func (d *Dir) ApplyLayer(rootfsPath string, layerInfo types.BlobInfo) error {
+	path, err := d.BlobPath(layerInfo.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening layer %s: %w", layerInfo.Digest, err)
+	}
+	defer f.Close()
+	uncompressed, _, err := compression.AutoDecompress(f)
+	if err != nil {
+		return fmt.Errorf("auto-decompressing layer %s: %w", layerInfo.Digest, err)
+	}
+	defer uncompressed.Close()
+
+	unprivileged := os.Geteuid() != 0
+	logrus.Debugf("Applying layer %s to %s", layerInfo.Digest, rootfsPath)
+	if _, err := archive.ApplyUncompressedLayer(rootfsPath, uncompressed, &archive.TarOptions{
+		// Without privileges, file ownership can not be preserved, and device nodes can not be created.
+		IgnoreChownErrors: unprivileged,
+		InUserNS:          unprivileged,
+	}); err != nil {
+		return fmt.Errorf("applying layer %s: %w", layerInfo.Digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package stagedblobs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/types"
+)
+
+func TestBlobPath(t *testing.T) {
+	dir := t.TempDir()
+	d := New(dir)
+	blobDigest := digest.FromString("contents")
+	path, err := d.BlobPath(blobDigest)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "sha256-"+blobDigest.Encoded()), path)
+
+	_, err = d.BlobPath("sha256:../../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestPutBlobAndTryReusingBlob(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	d := New(dir)
+	contents := []byte("contents")
+	blobDigest := digest.FromBytes(contents)
+
+	reused, _, err := d.TryReusingBlob(types.BlobInfo{Digest: blobDigest, Size: -1}, private.TryReusingBlobOptions{})
+	require.NoError(t, err)
+	assert.False(t, reused)
+	_, _, err = d.TryReusingBlob(types.BlobInfo{Size: -1}, private.TryReusingBlobOptions{})
+	assert.Error(t, err)
+
+	// Size mismatch
+	_, err = d.PutBlob(ctx, bytes.NewReader(contents), types.BlobInfo{Size: 1})
+	assert.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	uploaded, err := d.PutBlob(ctx, bytes.NewReader(contents), types.BlobInfo{Size: -1})
+	require.NoError(t, err)
+	assert.Equal(t, private.UploadedBlob{Digest: blobDigest, Size: int64(len(contents))}, uploaded)
+	path, err := d.BlobPath(blobDigest)
+	require.NoError(t, err)
+	stored, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, contents, stored)
+
+	reused, reusedInfo, err := d.TryReusingBlob(types.BlobInfo{Digest: blobDigest, Size: -1}, private.TryReusingBlobOptions{})
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Equal(t, private.ReusedBlob{Digest: blobDigest, Size: int64(len(contents))}, reusedInfo)
+}
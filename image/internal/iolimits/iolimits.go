@@ -39,6 +39,12 @@ const (
 	// MaxTarFileManifestSize is the maximum allowed size of a (docker save)-like manifest (which may contain multiple images)
 	// The limit of 1 MB is considered to be greatly sufficient.
 	MaxTarFileManifestSize = megaByte
+	// MaxReferrersListBodySize is the maximum allowed size of a list of referrers (an OCI image index).
+	// The limit of 4 MB is considered to be greatly sufficient.
+	MaxReferrersListBodySize = 4 * megaByte
+	// MaxReferrerBlobSize is the maximum allowed size of a blob of a referrer artifact (e.g. an SBOM or an attestation).
+	// SBOMs of large images can be fairly large, so the limit is higher than for signatures.
+	MaxReferrerBlobSize = 32 * megaByte
 )
 
 // ReadAtMost reads from reader and errors out if the specified limit (in bytes) is exceeded.
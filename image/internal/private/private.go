@@ -35,6 +35,20 @@ type ImageSource interface {
 	ImageSourceInternalOnly
 }
 
+// Referrer is an artifact (e.g. an SBOM or an attestation) attached to an image manifest
+// using the “subject” field of an OCI image manifest, as used by the OCI referrers API.
+type Referrer struct {
+	Descriptor imgspecv1.Descriptor     // Describes Manifest, including ArtifactType and Annotations, as in a referrers API response.
+	Manifest   []byte                   // An OCI image manifest with Subject set.
+	Blobs      map[digest.Digest][]byte // Contents of the config and all layers of Manifest.
+}
+
+// ReferrersSource is an optional extension of ImageSource, for transports which can return referrers of images.
+type ReferrersSource interface {
+	// GetReferrers returns the referrers of the manifest with instanceDigest, or of the primary manifest if instanceDigest is nil.
+	GetReferrers(ctx context.Context, instanceDigest *digest.Digest) ([]Referrer, error)
+}
+
 // ImageDestinationInternalOnly is the part of private.ImageDestination that is not
 // a part of types.ImageDestination.
 type ImageDestinationInternalOnly interface {
@@ -93,6 +107,14 @@ type ImageDestination interface {
 	ImageDestinationInternalOnly
 }
 
+// ReferrersDestination is an optional extension of ImageDestination, for transports which can store referrers of images.
+type ReferrersDestination interface {
+	// PutReferrers writes referrers of the manifest with instanceDigest, or of the primary manifest if instanceDigest is nil;
+	// the Subject of every referrer must refer to that manifest.
+	// MUST be called after PutManifest.
+	PutReferrers(ctx context.Context, referrers []Referrer, instanceDigest *digest.Digest) error
+}
+
 // UploadedBlob is information about a blob written to a destination.
 // It is the subset of types.BlobInfo fields the transport is responsible for setting; all fields must be provided.
 type UploadedBlob struct {
@@ -120,6 +142,8 @@ type PutBlobPartialOptions struct {
 	Cache      blobinfocache.BlobInfoCache2 // Cache to use and/or update.
 	EmptyLayer bool                         // True if the blob is an "empty"/"throwaway" layer, and may not necessarily be physically represented.
 	LayerIndex int                          // A zero-based index of the layer within the image (PutBlobPartial is only called with layer-like blobs, not configs)
+	SrcRef     reference.Named              // A reference to the source image that contains the input blob, or nil if not known.
+	PreferLazy bool                         // If set, the destination should, if possible, register the layer so that its contents are fetched only when first accessed.
 }
 
 // TryReusingBlobOptions are used in TryReusingBlobWithOptions.
@@ -0,0 +1,75 @@
+// Package referrers contains helpers for handling artifacts which refer to image manifests
+// using the “subject” field of OCI image manifests (“referrers”).
+package referrers
+
+import (
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+)
+
+// Parse parses manifestBlob as a referrer manifest, and returns the parsed manifest
+// and a descriptor for it, as it would appear in a referrers API response.
+func Parse(manifestBlob []byte) (*manifest.OCI1, imgspecv1.Descriptor, error) {
+	if mimeType := manifest.GuessMIMEType(manifestBlob); mimeType != imgspecv1.MediaTypeImageManifest {
+		return nil, imgspecv1.Descriptor{}, fmt.Errorf("referrer manifest has unsupported MIME type %q", mimeType)
+	}
+	m, err := manifest.OCI1FromManifest(manifestBlob)
+	if err != nil {
+		return nil, imgspecv1.Descriptor{}, fmt.Errorf("parsing referrer manifest: %w", err)
+	}
+	if m.Subject == nil {
+		return nil, imgspecv1.Descriptor{}, fmt.Errorf("referrer manifest has no subject")
+	}
+	// As specified for the referrers API, the artifact type falls back to the config media type.
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	return m, imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       digest.FromBytes(manifestBlob),
+		Size:         int64(len(manifestBlob)),
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}, nil
+}
+
+// BlobDescriptors returns descriptors of all blobs referenced by m, which must be included in a Referrer.
+func BlobDescriptors(m *manifest.OCI1) []imgspecv1.Descriptor {
+	res := make([]imgspecv1.Descriptor, 0, 1+len(m.Layers))
+	res = append(res, m.Config)
+	return append(res, m.Layers...)
+}
+
+// Validate checks that r is a consistent referrer of the manifest with digest subject.
+// On success, it returns r with Descriptor computed from the manifest.
+func Validate(r private.Referrer, subject digest.Digest) (private.Referrer, error) {
+	m, desc, err := Parse(r.Manifest)
+	if err != nil {
+		return private.Referrer{}, err
+	}
+	if r.Descriptor.Digest != "" && r.Descriptor.Digest != desc.Digest {
+		return private.Referrer{}, fmt.Errorf("referrer manifest digest mismatch, expected %s, got %s", r.Descriptor.Digest, desc.Digest)
+	}
+	if m.Subject.Digest != subject {
+		return private.Referrer{}, fmt.Errorf("referrer %s refers to %s, not %s", desc.Digest, m.Subject.Digest, subject)
+	}
+	for _, blob := range BlobDescriptors(m) {
+		if err := blob.Digest.Validate(); err != nil {
+			return private.Referrer{}, fmt.Errorf("invalid blob digest %q in referrer %s: %w", blob.Digest, desc.Digest, err)
+		}
+		contents, ok := r.Blobs[blob.Digest]
+		if !ok {
+			return private.Referrer{}, fmt.Errorf("contents of blob %s of referrer %s are missing", blob.Digest, desc.Digest)
+		}
+		if int64(len(contents)) != blob.Size || blob.Digest.Algorithm().FromBytes(contents) != blob.Digest {
+			return private.Referrer{}, fmt.Errorf("contents of blob %s of referrer %s do not match the descriptor", blob.Digest, desc.Digest)
+		}
+	}
+	r.Descriptor = desc
+	return r, nil
+}
@@ -0,0 +1,68 @@
+package referrers
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+)
+
+func TestValidate(t *testing.T) {
+	subject := digest.FromString("subject")
+	config := []byte("{}")
+	layer := []byte("payload")
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: "application/vnd.example.config+json",
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}})
+	m.Annotations = map[string]string{"a": "b"}
+	m.Subject = &imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: subject, Size: 1}
+	manifestBlob, err := m.Serialize()
+	require.NoError(t, err)
+	blobs := map[digest.Digest][]byte{
+		digest.FromBytes(config): config,
+		digest.FromBytes(layer):  layer,
+	}
+
+	r, err := Validate(private.Referrer{Manifest: manifestBlob, Blobs: blobs}, subject)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.Descriptor{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       digest.FromBytes(manifestBlob),
+		Size:         int64(len(manifestBlob)),
+		ArtifactType: "application/vnd.example.config+json", // Falls back to the config media type
+		Annotations:  map[string]string{"a": "b"},
+	}, r.Descriptor)
+
+	// Wrong subject
+	_, err = Validate(private.Referrer{Manifest: manifestBlob, Blobs: blobs}, digest.FromString("other"))
+	assert.Error(t, err)
+	// Descriptor digest mismatch
+	_, err = Validate(private.Referrer{Descriptor: imgspecv1.Descriptor{Digest: digest.FromString("other")}, Manifest: manifestBlob, Blobs: blobs}, subject)
+	assert.Error(t, err)
+	// Missing blob
+	_, err = Validate(private.Referrer{Manifest: manifestBlob, Blobs: map[digest.Digest][]byte{digest.FromBytes(config): config}}, subject)
+	assert.Error(t, err)
+	// Blob contents mismatch
+	_, err = Validate(private.Referrer{Manifest: manifestBlob, Blobs: map[digest.Digest][]byte{
+		digest.FromBytes(config): config,
+		digest.FromBytes(layer):  []byte("modified"),
+	}}, subject)
+	assert.Error(t, err)
+
+	// No subject
+	m.Subject = nil
+	noSubject, err := m.Serialize()
+	require.NoError(t, err)
+	_, err = Validate(private.Referrer{Manifest: noSubject, Blobs: blobs}, subject)
+	assert.Error(t, err)
+}
@@ -17,6 +17,9 @@ const (
 	SigstoreCertificateAnnotationKey = "dev.sigstore.cosign/certificate"
 	// from sigstore/cosign/pkg/oci/static.ChainAnnotationKey
 	SigstoreIntermediateCertificateChainAnnotationKey = "dev.sigstore.cosign/chain"
+	// A Rekor inclusion proof for the entry in SigstoreSETAnnotationKey, including a signed checkpoint.
+	// This is not defined by cosign; the format follows github.com/sigstore/rekor/pkg/generated/models.InclusionProof.
+	SigstoreRekorInclusionProofAnnotationKey = "io.containers.sigstore/rekor-inclusion-proof"
 )
 
 // Sigstore is a github.com/cosign/cosign signature.
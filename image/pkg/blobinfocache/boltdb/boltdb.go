@@ -17,6 +17,7 @@ import (
 	"go.podman.io/image/v5/pkg/blobinfocache/internal/prioritize"
 	"go.podman.io/image/v5/types"
 	"go.podman.io/storage/pkg/fileutils"
+	"go.podman.io/storage/pkg/lockfile"
 )
 
 var (
@@ -156,10 +157,23 @@ func (bdc *cache) view(fn func(tx *bolt.Tx) error) (retErr error) {
 	return db.View(fn)
 }
 
+// writeLock returns the inter-process lock held by writers of the database at path.
+// The BoltDB file lock can not be used for that purpose because compaction replaces the file;
+// a writer waiting on the lock of the replaced file would then only update the replaced file.
+func writeLock(path string) (*lockfile.LockFile, error) {
+	return lockfile.GetLockFile(path + ".lock")
+}
+
 // update returns runs the specified fn within a read-write transaction on the database.
 func (bdc *cache) update(fn func(tx *bolt.Tx) error) (retErr error) {
 	lockPath(bdc.path)
 	defer unlockPath(bdc.path)
+	lock, err := writeLock(bdc.path)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
 	db, err := bolt.Open(bdc.path, 0o600, nil)
 	if err != nil {
 		return err
@@ -23,4 +23,8 @@ func TestNew(t *testing.T) {
 	test.GenericCache(t, newTestCache)
 }
 
+func TestMaintenance(t *testing.T) {
+	test.GenericMaintenance(t, newTestCache)
+}
+
 // FIXME: Tests for the various corner cases / failure cases of boltDBCache should be added here.
@@ -0,0 +1,451 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	bolt "go.etcd.io/bbolt"
+	"go.podman.io/image/v5/pkg/blobinfocache/internal/maintain"
+	"go.podman.io/image/v5/pkg/blobinfocache/maintenance"
+	"go.podman.io/storage/pkg/fileutils"
+)
+
+// A compile-time check that cache implements maintenance.Cache.
+var _ maintenance.Cache = (*cache)(nil)
+
+// export delivers the full contents of the cache to sink, within a transaction.
+func (bdc *cache) export(tx *bolt.Tx, sink maintain.ContentsSink) error {
+	if b := tx.Bucket(uncompressedDigestBucket); b != nil {
+		if err := b.ForEach(func(k, v []byte) error {
+			return sink.UncompressedDigest(maintenance.UncompressedDigestPair{
+				Digest:             digest.Digest(k),
+				UncompressedDigest: digest.Digest(v),
+			})
+		}); err != nil {
+			return fmt.Errorf("reading uncompressed digests: %w", err)
+		}
+	}
+
+	if b := tx.Bucket(uncompressedDigestByTOCBucket); b != nil {
+		if err := b.ForEach(func(k, v []byte) error {
+			return sink.TOCUncompressedDigest(maintenance.TOCUncompressedDigestPair{
+				TOCDigest:          digest.Digest(k),
+				UncompressedDigest: digest.Digest(v),
+			})
+		}); err != nil {
+			return fmt.Errorf("reading TOC digests: %w", err)
+		}
+	}
+
+	if b := tx.Bucket(digestCompressorBucket); b != nil {
+		specificVariantBucket := tx.Bucket(digestSpecificVariantCompressorBucket)
+		if err := b.ForEach(func(k, v []byte) error {
+			record := maintenance.CompressorRecord{
+				Digest:     digest.Digest(k),
+				Compressor: string(v),
+			}
+			if specificVariantBucket != nil {
+				if svData := specificVariantBucket.Get(k); svData != nil {
+					compressorBytes, annotationBytes, ok := bytes.Cut(svData, []byte{0})
+					if !ok {
+						return fmt.Errorf("invalid specific variant compressor data for %q", string(k))
+					}
+					record.SpecificVariantCompressor = string(compressorBytes)
+					if err := json.Unmarshal(annotationBytes, &record.SpecificVariantAnnotations); err != nil {
+						return err
+					}
+				}
+			}
+			return sink.Compressor(record)
+		}); err != nil {
+			return fmt.Errorf("reading compressors: %w", err)
+		}
+	}
+
+	if b := tx.Bucket(knownLocationsBucket); b != nil {
+		// knownLocationsBucket is nested as transport → scope → digest → location → time.
+		if err := forEachBucket(b, func(transport []byte, transportBucket *bolt.Bucket) error {
+			return forEachBucket(transportBucket, func(scope []byte, scopeBucket *bolt.Bucket) error {
+				return forEachBucket(scopeBucket, func(d []byte, digestBucket *bolt.Bucket) error {
+					return digestBucket.ForEach(func(location, timeBytes []byte) error {
+						l := maintenance.KnownLocation{
+							Transport: string(transport),
+							Scope:     string(scope),
+							Digest:    digest.Digest(d),
+							Location:  string(location),
+						}
+						if err := l.LastSeen.UnmarshalBinary(timeBytes); err != nil {
+							return err
+						}
+						return sink.KnownLocation(l)
+					})
+				})
+			})
+		}); err != nil {
+			return fmt.Errorf("reading known locations: %w", err)
+		}
+	}
+	return nil
+}
+
+// forEachBucket calls fn for every nested bucket of b.
+func forEachBucket(b *bolt.Bucket, fn func(name []byte, nested *bolt.Bucket) error) error {
+	return b.ForEachBucket(func(name []byte) error {
+		return fn(name, b.Bucket(name))
+	})
+}
+
+// contents returns the full contents of the cache, within a transaction.
+func (bdc *cache) contents(tx *bolt.Tx) (*maintenance.Contents, error) {
+	builder := maintain.NewContentsBuilder()
+	if err := bdc.export(tx, builder); err != nil {
+		return nil, err
+	}
+	return builder.Contents, nil
+}
+
+// Export returns the full contents of the cache.
+func (bdc *cache) Export() (*maintenance.Contents, error) {
+	builder := maintain.NewContentsBuilder()
+	if err := bdc.view(func(tx *bolt.Tx) error {
+		return bdc.export(tx, builder)
+	}); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return builder.Contents, nil
+}
+
+// ExportTo writes the full contents of the cache to w, in the JSON format of maintenance.Contents.
+func (bdc *cache) ExportTo(w io.Writer) error {
+	encoder := maintain.NewContentsEncoder(w)
+	if err := bdc.view(func(tx *bolt.Tx) error {
+		return bdc.export(tx, encoder)
+	}); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Import merges contents into the cache. Known locations which already exist keep the more recent LastSeen value.
+// WARNING: The cache must only contain LOCALLY VERIFIED data; only import contents exported from a trusted cache.
+func (bdc *cache) Import(contents *maintenance.Contents) error {
+	if err := maintain.ValidateContents(contents); err != nil {
+		return err
+	}
+	return bdc.update(func(tx *bolt.Tx) error {
+		if len(contents.UncompressedDigests) != 0 {
+			b, err := tx.CreateBucketIfNotExists(uncompressedDigestBucket)
+			if err != nil {
+				return err
+			}
+			for _, p := range contents.UncompressedDigests {
+				key := []byte(p.Digest.String())
+				if previous := b.Get(key); previous != nil && string(previous) != p.UncompressedDigest.String() {
+					if err := deleteDigestByUncompressed(tx, digest.Digest(previous), p.Digest); err != nil {
+						return err
+					}
+				}
+				if err := b.Put(key, []byte(p.UncompressedDigest.String())); err != nil {
+					return err
+				}
+				byUncompressed, err := tx.CreateBucketIfNotExists(digestByUncompressedBucket)
+				if err != nil {
+					return err
+				}
+				byUncompressed, err = byUncompressed.CreateBucketIfNotExists([]byte(p.UncompressedDigest.String()))
+				if err != nil {
+					return err
+				}
+				if err := byUncompressed.Put(key, []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(contents.TOCUncompressedDigests) != 0 {
+			b, err := tx.CreateBucketIfNotExists(uncompressedDigestByTOCBucket)
+			if err != nil {
+				return err
+			}
+			for _, p := range contents.TOCUncompressedDigests {
+				if err := b.Put([]byte(p.TOCDigest.String()), []byte(p.UncompressedDigest.String())); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(contents.Compressors) != 0 {
+			b, err := tx.CreateBucketIfNotExists(digestCompressorBucket)
+			if err != nil {
+				return err
+			}
+			for _, c := range contents.Compressors {
+				key := []byte(c.Digest.String())
+				if err := b.Put(key, []byte(c.Compressor)); err != nil {
+					return err
+				}
+				// A specific variant recorded earlier may not match the imported compressor.
+				if err := deleteKey(tx, [][]byte{digestSpecificVariantCompressorBucket}, key); err != nil {
+					return err
+				}
+				if c.SpecificVariantCompressor != "" {
+					svb, err := tx.CreateBucketIfNotExists(digestSpecificVariantCompressorBucket)
+					if err != nil {
+						return err
+					}
+					annotations, err := json.Marshal(c.SpecificVariantAnnotations)
+					if err != nil {
+						return err
+					}
+					data := []byte(c.SpecificVariantCompressor)
+					data = append(data, 0)
+					data = append(data, annotations...)
+					if err := svb.Put(key, data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, l := range contents.KnownLocations {
+			b, err := tx.CreateBucketIfNotExists(knownLocationsBucket)
+			if err != nil {
+				return err
+			}
+			for _, name := range []string{l.Transport, l.Scope, l.Digest.String()} {
+				b, err = b.CreateBucketIfNotExists([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+			if previousBytes := b.Get([]byte(l.Location)); previousBytes != nil {
+				var previous time.Time
+				if err := previous.UnmarshalBinary(previousBytes); err == nil && !previous.Before(l.LastSeen) {
+					continue
+				}
+			}
+			value, err := l.LastSeen.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(l.Location), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteDigestByUncompressed removes anyDigest from the set of digests for uncompressed in digestByUncompressedBucket.
+func deleteDigestByUncompressed(tx *bolt.Tx, uncompressed, anyDigest digest.Digest) error {
+	b := tx.Bucket(digestByUncompressedBucket)
+	if b == nil {
+		return nil
+	}
+	set := b.Bucket([]byte(uncompressed.String()))
+	if set == nil {
+		return nil
+	}
+	if err := set.Delete([]byte(anyDigest.String())); err != nil {
+		return err
+	}
+	if k, _ := set.Cursor().First(); k == nil {
+		return b.DeleteBucket([]byte(uncompressed.String()))
+	}
+	return nil
+}
+
+// deleteKey deletes key from a nested bucket identified by path, if it exists, and then deletes
+// any buckets along path which became empty.
+func deleteKey(tx *bolt.Tx, path [][]byte, key []byte) error {
+	buckets := make([]*bolt.Bucket, 0, len(path))
+	b := tx.Bucket(path[0])
+	for i := 0; b != nil; i++ {
+		buckets = append(buckets, b)
+		if i+1 == len(path) {
+			break
+		}
+		b = b.Bucket(path[i+1])
+	}
+	if len(buckets) != len(path) {
+		return nil // Does not exist
+	}
+	if err := buckets[len(buckets)-1].Delete(key); err != nil {
+		return err
+	}
+	for i := len(buckets) - 1; i >= 0; i-- {
+		if k, _ := buckets[i].Cursor().First(); k != nil {
+			break
+		}
+		var err error
+		if i == 0 {
+			err = tx.DeleteBucket(path[0])
+		} else {
+			err = buckets[i-1].DeleteBucket(path[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes data selected by options, and returns statistics about the removed data.
+// BoltDB does not shrink the database file; the freed space is reused for future records.
+// If options.MaxSize is set, the database file is compacted as necessary.
+func (bdc *cache) Prune(options maintenance.PruneOptions) (maintenance.PruneStats, error) {
+	stats, err := bdc.prune(func(contents *maintenance.Contents) *maintenance.Contents {
+		return maintain.ToPrune(contents, options)
+	})
+	if err != nil {
+		return maintenance.PruneStats{}, err
+	}
+	if options.MaxSize > 0 {
+		// Compact first, the data removed above, or removed earlier, might be enough.
+		if err := bdc.compact(); err != nil {
+			return stats, err
+		}
+		sizeStats, err := maintain.PruneToSize(options.MaxSize, bdc.fileSize, func(keepFraction float64) (maintenance.PruneStats, error) {
+			stats, err := bdc.prune(func(contents *maintenance.Contents) *maintenance.Contents {
+				return maintain.ToPruneFraction(contents, keepFraction)
+			})
+			if err != nil {
+				return stats, err
+			}
+			return stats, bdc.compact()
+		})
+		stats = maintain.AddStats(stats, sizeStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// fileSize returns the size of the database file, or 0 if it does not exist.
+func (bdc *cache) fileSize() (int64, error) {
+	fi, err := os.Stat(bdc.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// compact rewrites the database file to release the space of removed data.
+// The write lock is held until the compacted file replaces the original, so no concurrent updates are lost;
+// concurrent readers may still read the replaced file.
+func (bdc *cache) compact() (retErr error) {
+	if err := fileutils.Lexists(bdc.path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	lockPath(bdc.path)
+	defer unlockPath(bdc.path)
+	lock, err := writeLock(bdc.path)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	src, err := bolt.Open(bdc.path, 0o600, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := src.Close(); retErr == nil && err != nil {
+			retErr = err
+		}
+	}()
+
+	tmpPath := bdc.path + ".compact"
+	dst, err := bolt.Open(tmpPath, 0o600, nil)
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if err := bolt.Compact(dst, src, 0); err != nil {
+		dst.Close()
+		return fmt.Errorf("compacting blob info cache at %q: %w", bdc.path, err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, bdc.path); err != nil {
+		return err
+	}
+	succeeded = true
+	return nil
+}
+
+// prune removes the data returned by selectToPrune for the full contents of the cache, and returns statistics about the removed data.
+func (bdc *cache) prune(selectToPrune func(contents *maintenance.Contents) *maintenance.Contents) (maintenance.PruneStats, error) {
+	var stats maintenance.PruneStats
+	err := bdc.update(func(tx *bolt.Tx) error {
+		contents, err := bdc.contents(tx)
+		if err != nil {
+			return err
+		}
+		toPrune := selectToPrune(contents)
+		for _, l := range toPrune.KnownLocations {
+			if err := deleteKey(tx, [][]byte{knownLocationsBucket, []byte(l.Transport), []byte(l.Scope), []byte(l.Digest.String())}, []byte(l.Location)); err != nil {
+				return err
+			}
+		}
+		for _, p := range toPrune.UncompressedDigests {
+			if err := deleteKey(tx, [][]byte{uncompressedDigestBucket}, []byte(p.Digest.String())); err != nil {
+				return err
+			}
+			if err := deleteDigestByUncompressed(tx, p.UncompressedDigest, p.Digest); err != nil {
+				return err
+			}
+		}
+		for _, p := range toPrune.TOCUncompressedDigests {
+			if err := deleteKey(tx, [][]byte{uncompressedDigestByTOCBucket}, []byte(p.TOCDigest.String())); err != nil {
+				return err
+			}
+		}
+		for _, c := range toPrune.Compressors {
+			if err := deleteKey(tx, [][]byte{digestCompressorBucket}, []byte(c.Digest.String())); err != nil {
+				return err
+			}
+			if err := deleteKey(tx, [][]byte{digestSpecificVariantCompressorBucket}, []byte(c.Digest.String())); err != nil {
+				return err
+			}
+		}
+		stats = maintain.Stats(toPrune)
+		return nil
+	})
+	if err != nil {
+		return maintenance.PruneStats{}, err
+	}
+	return stats, nil
+}
+
+// DigestInfo returns everything the cache knows about d.
+func (bdc *cache) DigestInfo(d digest.Digest) (*maintenance.DigestInfo, error) {
+	// Known locations are keyed by transport and scope first, so this needs to read
+	// the whole database anyway.
+	contents, err := bdc.Export()
+	if err != nil {
+		return nil, err
+	}
+	return maintain.DigestInfo(contents, d), nil
+}
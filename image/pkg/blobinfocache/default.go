@@ -8,6 +8,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.podman.io/image/v5/internal/rootless"
 	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/pkg/blobinfocache/remote"
 	"go.podman.io/image/v5/pkg/blobinfocache/sqlite"
 	"go.podman.io/image/v5/types"
 )
@@ -50,6 +51,21 @@ func blobInfoCacheDir(sys *types.SystemContext, euid int) (string, error) {
 
 // DefaultCache returns the default BlobInfoCache implementation appropriate for sys.
 func DefaultCache(sys *types.SystemContext) types.BlobInfoCache {
+	local := defaultLocalCache(sys)
+	if sys == nil || sys.BlobInfoCacheURL == "" {
+		return local
+	}
+	cache, err := remote.New(sys.BlobInfoCacheURL, local)
+	if err != nil {
+		logrus.Warnf("Error setting up a shared blob info cache, using only the local cache: %v", err)
+		return local
+	}
+	logrus.Debugf("Using shared blob info cache at %s", sys.BlobInfoCacheURL)
+	return cache
+}
+
+// defaultLocalCache returns the default local BlobInfoCache implementation appropriate for sys.
+func defaultLocalCache(sys *types.SystemContext) types.BlobInfoCache {
 	dir, err := blobInfoCacheDir(sys, rootless.GetRootlessEUID())
 	if err != nil {
 		logrus.Debugf("Error determining a location for %s, using a memory-only cache", blobInfoCacheFilename)
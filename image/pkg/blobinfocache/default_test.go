@@ -11,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/pkg/blobinfocache/remote"
 	"go.podman.io/image/v5/pkg/blobinfocache/sqlite"
 	"go.podman.io/image/v5/types"
 )
@@ -108,6 +109,15 @@ func TestDefaultCache(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, sqliteCache, c)
 
+	// Shared cache
+	c = DefaultCache(&types.SystemContext{BlobInfoCacheDir: normalDir, BlobInfoCacheURL: "https://cache.example.com"})
+	remoteCache, err := remote.New("https://cache.example.com", sqliteCache)
+	require.NoError(t, err)
+	assert.IsType(t, remoteCache, c)
+	// Invalid shared cache URL
+	c = DefaultCache(&types.SystemContext{BlobInfoCacheDir: normalDir, BlobInfoCacheURL: "unix:///cache.sock"})
+	assert.Equal(t, sqliteCache, c)
+
 	// Error running blobInfoCacheDir:
 	// Use t.Setenv() just as a way to set up cleanup to original values; then os.Unsetenv() to test a situation where the values are not set.
 	t.Setenv("HOME", "")
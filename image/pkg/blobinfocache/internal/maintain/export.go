@@ -0,0 +1,148 @@
+package maintain
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"go.podman.io/image/v5/pkg/blobinfocache/maintenance"
+)
+
+// ContentsSink receives the records of a cache one at a time, while the cache is being exported.
+// Records are delivered in the order of the fields of maintenance.Contents: all uncompressed digests,
+// then all TOC digests, then all compressors, then all known locations.
+type ContentsSink interface {
+	UncompressedDigest(p maintenance.UncompressedDigestPair) error
+	TOCUncompressedDigest(p maintenance.TOCUncompressedDigestPair) error
+	Compressor(c maintenance.CompressorRecord) error
+	KnownLocation(l maintenance.KnownLocation) error
+}
+
+// ContentsBuilder is a ContentsSink which collects the records into Contents.
+type ContentsBuilder struct {
+	Contents *maintenance.Contents
+}
+
+// NewContentsBuilder returns a ContentsBuilder with empty (not nil) Contents.
+func NewContentsBuilder() *ContentsBuilder {
+	return &ContentsBuilder{Contents: &maintenance.Contents{
+		UncompressedDigests:    []maintenance.UncompressedDigestPair{},
+		TOCUncompressedDigests: []maintenance.TOCUncompressedDigestPair{},
+		Compressors:            []maintenance.CompressorRecord{},
+		KnownLocations:         []maintenance.KnownLocation{},
+	}}
+}
+
+func (b *ContentsBuilder) UncompressedDigest(p maintenance.UncompressedDigestPair) error {
+	b.Contents.UncompressedDigests = append(b.Contents.UncompressedDigests, p)
+	return nil
+}
+
+func (b *ContentsBuilder) TOCUncompressedDigest(p maintenance.TOCUncompressedDigestPair) error {
+	b.Contents.TOCUncompressedDigests = append(b.Contents.TOCUncompressedDigests, p)
+	return nil
+}
+
+func (b *ContentsBuilder) Compressor(c maintenance.CompressorRecord) error {
+	b.Contents.Compressors = append(b.Contents.Compressors, c)
+	return nil
+}
+
+func (b *ContentsBuilder) KnownLocation(l maintenance.KnownLocation) error {
+	b.Contents.KnownLocations = append(b.Contents.KnownLocations, l)
+	return nil
+}
+
+// contentsSections are the JSON keys of the fields of maintenance.Contents, in order.
+var contentsSections = []string{"uncompressedDigests", "tocUncompressedDigests", "compressors", "knownLocations"}
+
+// ContentsEncoder is a ContentsSink which writes the records to a writer, in the JSON format of maintenance.Contents,
+// without holding all of them in memory.
+type ContentsEncoder struct {
+	w       *bufio.Writer
+	section int  // Index into contentsSections of the section being written, -1 before the first one
+	empty   bool // No record was written in the current section yet
+}
+
+// NewContentsEncoder returns a ContentsEncoder writing to w. The caller must call Close after the last record.
+func NewContentsEncoder(w io.Writer) *ContentsEncoder {
+	return &ContentsEncoder{w: bufio.NewWriter(w), section: -1}
+}
+
+// nextSection finishes the current section, if any, and starts the next one.
+func (e *ContentsEncoder) nextSection() error {
+	sep := "],"
+	if e.section == -1 {
+		sep = "{"
+	}
+	e.section++
+	key, err := json.Marshal(contentsSections[e.section])
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.WriteString(sep); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(key); err != nil {
+		return err
+	}
+	if _, err := e.w.WriteString(":["); err != nil {
+		return err
+	}
+	e.empty = true
+	return nil
+}
+
+// record writes record as a part of section.
+func (e *ContentsEncoder) record(section int, record any) error {
+	if section < e.section {
+		return errors.New("internal error: blob info cache records exported out of order")
+	}
+	for e.section < section {
+		if err := e.nextSection(); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if !e.empty {
+		if err := e.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	e.empty = false
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *ContentsEncoder) UncompressedDigest(p maintenance.UncompressedDigestPair) error {
+	return e.record(0, p)
+}
+
+func (e *ContentsEncoder) TOCUncompressedDigest(p maintenance.TOCUncompressedDigestPair) error {
+	return e.record(1, p)
+}
+
+func (e *ContentsEncoder) Compressor(c maintenance.CompressorRecord) error {
+	return e.record(2, c)
+}
+
+func (e *ContentsEncoder) KnownLocation(l maintenance.KnownLocation) error {
+	return e.record(3, l)
+}
+
+// Close writes any sections which had no records, terminates the JSON document, and flushes the output.
+func (e *ContentsEncoder) Close() error {
+	for e.section < len(contentsSections)-1 {
+		if err := e.nextSection(); err != nil {
+			return err
+		}
+	}
+	if _, err := e.w.WriteString("]}"); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
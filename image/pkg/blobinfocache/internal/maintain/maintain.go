@@ -0,0 +1,237 @@
+// Package maintain implements backend-independent parts of the pkg/blobinfocache/maintenance API,
+// operating on the full contents of a cache.
+package maintain
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	digest "github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/pkg/blobinfocache/maintenance"
+)
+
+// ToPrune returns the subset of contents which should be removed according to options.
+// options.MaxSize is not handled here; see PruneToSize.
+func ToPrune(contents *maintenance.Contents, options maintenance.PruneOptions) *maintenance.Contents {
+	res := &maintenance.Contents{}
+	kept := []maintenance.KnownLocation{}
+	for _, l := range locationsByRecency(contents) {
+		if (!options.LastSeenBefore.IsZero() && l.LastSeen.Before(options.LastSeenBefore)) ||
+			(options.MaxKnownLocations > 0 && len(kept) >= options.MaxKnownLocations) {
+			res.KnownLocations = append(res.KnownLocations, l)
+		} else {
+			kept = append(kept, l)
+		}
+	}
+	if options.RemoveUnreferencedDigests {
+		addUnreferencedDigests(res, contents, kept)
+	}
+	return res
+}
+
+// ToPruneFraction returns the subset of contents which should be removed to keep only the most recently seen keepFraction
+// of known locations (but removing at least one, if any exist), and digest data which is not referenced by the kept locations.
+func ToPruneFraction(contents *maintenance.Contents, keepFraction float64) *maintenance.Contents {
+	locations := locationsByRecency(contents)
+	keep := 0
+	if len(locations) > 0 {
+		keep = min(max(int(float64(len(locations))*keepFraction), 0), len(locations)-1)
+	}
+	res := &maintenance.Contents{KnownLocations: locations[keep:]}
+	addUnreferencedDigests(res, contents, locations[:keep])
+	return res
+}
+
+// locationsByRecency returns the known locations in contents, most recently seen first.
+func locationsByRecency(contents *maintenance.Contents) []maintenance.KnownLocation {
+	locations := slices.Clone(contents.KnownLocations)
+	slices.SortStableFunc(locations, func(a, b maintenance.KnownLocation) int {
+		return b.LastSeen.Compare(a.LastSeen)
+	})
+	return locations
+}
+
+// addUnreferencedDigests adds to res the uncompressed-digest and compressor data in contents about blobs which have
+// no location in kept, not even via a digest with the same uncompressed digest.
+func addUnreferencedDigests(res, contents *maintenance.Contents, kept []maintenance.KnownLocation) {
+	uncompressed := map[digest.Digest]digest.Digest{}
+	for _, p := range contents.UncompressedDigests {
+		uncompressed[p.Digest] = p.UncompressedDigest
+	}
+	// referenced contains located digests, and the uncompressed digests of located digests;
+	// any blob with an uncompressed digest in this set can be substituted for a located blob.
+	referenced := map[digest.Digest]struct{}{}
+	for _, l := range kept {
+		referenced[l.Digest] = struct{}{}
+		if u, ok := uncompressed[l.Digest]; ok {
+			referenced[u] = struct{}{}
+		}
+	}
+	isReferenced := func(d digest.Digest) bool {
+		if _, ok := referenced[d]; ok {
+			return true
+		}
+		if u, ok := uncompressed[d]; ok {
+			_, ok := referenced[u]
+			return ok
+		}
+		return false
+	}
+
+	for _, p := range contents.UncompressedDigests {
+		if _, ok := referenced[p.UncompressedDigest]; !ok {
+			res.UncompressedDigests = append(res.UncompressedDigests, p)
+		}
+	}
+	for _, p := range contents.TOCUncompressedDigests {
+		if _, ok := referenced[p.UncompressedDigest]; !ok {
+			res.TOCUncompressedDigests = append(res.TOCUncompressedDigests, p)
+		}
+	}
+	for _, c := range contents.Compressors {
+		if !isReferenced(c.Digest) {
+			res.Compressors = append(res.Compressors, c)
+		}
+	}
+}
+
+// PruneToSize implements maintenance.PruneOptions.MaxSize: it calls prune, which must remove
+// ToPruneFraction(contents, keepFraction) from the cache and compact it, until size returns at most maxSize,
+// or until there is nothing left to remove. It returns the total statistics of the removed data.
+func PruneToSize(maxSize int64, size func() (int64, error), prune func(keepFraction float64) (maintenance.PruneStats, error)) (maintenance.PruneStats, error) {
+	res := maintenance.PruneStats{}
+	for {
+		current, err := size()
+		if err != nil {
+			return res, err
+		}
+		if current <= maxSize {
+			return res, nil
+		}
+		// Known locations are the bulk of the data in a typical cache; assume that the size is proportional to their number.
+		stats, err := prune(float64(maxSize) / float64(current))
+		if err != nil {
+			return res, err
+		}
+		if stats == (maintenance.PruneStats{}) {
+			return res, nil
+		}
+		res = AddStats(res, stats)
+	}
+}
+
+// AddStats returns the sum of a and b.
+func AddStats(a, b maintenance.PruneStats) maintenance.PruneStats {
+	return maintenance.PruneStats{
+		RemovedKnownLocations: a.RemovedKnownLocations + b.RemovedKnownLocations,
+		RemovedDigestRecords:  a.RemovedDigestRecords + b.RemovedDigestRecords,
+	}
+}
+
+// Stats returns maintenance.PruneStats for removing toPrune.
+func Stats(toPrune *maintenance.Contents) maintenance.PruneStats {
+	return maintenance.PruneStats{
+		RemovedKnownLocations: len(toPrune.KnownLocations),
+		RemovedDigestRecords:  len(toPrune.UncompressedDigests) + len(toPrune.TOCUncompressedDigests) + len(toPrune.Compressors),
+	}
+}
+
+// DigestInfo returns a maintenance.DigestInfo for d from contents.
+func DigestInfo(contents *maintenance.Contents, d digest.Digest) *maintenance.DigestInfo {
+	res := &maintenance.DigestInfo{
+		Digest:         d,
+		RelatedDigests: []digest.Digest{},
+		Locations:      []maintenance.KnownLocation{},
+	}
+	for _, p := range contents.UncompressedDigests {
+		if p.Digest == d {
+			res.UncompressedDigest = p.UncompressedDigest
+			break
+		}
+	}
+	if res.UncompressedDigest == "" {
+		// A record as UncompressedDigest implies that d must refer to an uncompressed digest,
+		// matching the behavior of BlobInfoCache.UncompressedDigest.
+		for _, p := range contents.UncompressedDigests {
+			if p.UncompressedDigest == d {
+				res.UncompressedDigest = d
+				break
+			}
+		}
+	}
+	for _, p := range contents.TOCUncompressedDigests {
+		if p.TOCDigest == d {
+			res.UncompressedDigestForTOC = p.UncompressedDigest
+			break
+		}
+	}
+	for i := range contents.Compressors {
+		if contents.Compressors[i].Digest == d {
+			c := contents.Compressors[i]
+			res.Compressor = &c
+			break
+		}
+	}
+
+	relevant := map[digest.Digest]struct{}{d: {}}
+	if res.UncompressedDigest != "" {
+		if res.UncompressedDigest != d {
+			res.RelatedDigests = append(res.RelatedDigests, res.UncompressedDigest)
+		}
+		for _, p := range contents.UncompressedDigests {
+			if p.UncompressedDigest == res.UncompressedDigest && p.Digest != d && p.Digest != res.UncompressedDigest {
+				res.RelatedDigests = append(res.RelatedDigests, p.Digest)
+			}
+		}
+		for _, r := range res.RelatedDigests {
+			relevant[r] = struct{}{}
+		}
+	}
+	for _, l := range contents.KnownLocations {
+		if _, ok := relevant[l.Digest]; ok {
+			res.Locations = append(res.Locations, l)
+		}
+	}
+	slices.SortStableFunc(res.Locations, func(a, b maintenance.KnownLocation) int {
+		return cmp.Or(b.LastSeen.Compare(a.LastSeen), cmp.Compare(a.Digest, b.Digest))
+	})
+	return res
+}
+
+// ValidateContents returns an error if contents contains invalid data which should not be imported.
+func ValidateContents(contents *maintenance.Contents) error {
+	for _, p := range contents.UncompressedDigests {
+		if err := p.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %w", p.Digest, err)
+		}
+		if err := p.UncompressedDigest.Validate(); err != nil {
+			return fmt.Errorf("invalid uncompressed digest %q for %q: %w", p.UncompressedDigest, p.Digest, err)
+		}
+	}
+	for _, p := range contents.TOCUncompressedDigests {
+		if err := p.TOCDigest.Validate(); err != nil {
+			return fmt.Errorf("invalid TOC digest %q: %w", p.TOCDigest, err)
+		}
+		if err := p.UncompressedDigest.Validate(); err != nil {
+			return fmt.Errorf("invalid uncompressed digest %q for TOC %q: %w", p.UncompressedDigest, p.TOCDigest, err)
+		}
+	}
+	for _, c := range contents.Compressors {
+		if err := c.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %w", c.Digest, err)
+		}
+		if c.Compressor == "" {
+			return fmt.Errorf("missing compressor for %q", c.Digest)
+		}
+	}
+	for _, l := range contents.KnownLocations {
+		if err := l.Digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %w", l.Digest, err)
+		}
+		if l.Transport == "" {
+			return fmt.Errorf("missing transport for known location of %q", l.Digest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/blobinfocache"
+	"go.podman.io/image/v5/internal/testing/mocks"
+	"go.podman.io/image/v5/pkg/blobinfocache/maintenance"
+	"go.podman.io/image/v5/types"
+)
+
+// GenericMaintenance runs an implementation-independent set of tests of the maintenance.Cache API, given a
+// newTestCache, which can be called repeatedly and always returns a fresh cache instance
+func GenericMaintenance(t *testing.T, newTestCache func(t *testing.T) blobinfocache.BlobInfoCache2) {
+	subs := []struct {
+		name string
+		fn   func(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache)
+	}{
+		{"ExportImport", testMaintenanceExportImport},
+		{"ExportTo", testMaintenanceExportTo},
+		{"DigestInfo", testMaintenanceDigestInfo},
+		{"Prune", testMaintenancePrune},
+		{"PruneToSize", testMaintenancePruneToSize},
+	}
+
+	for _, s := range subs {
+		t.Run(s.name, func(t *testing.T) {
+			cache := newTestCache(t)
+			mc, err := maintenance.ForCache(cache)
+			require.NoError(t, err)
+			s.fn(t, cache, mc)
+		})
+	}
+}
+
+// normalizeLocations returns locations with LastSeen in UTC, so that they can be compared using assert.Equal.
+func normalizeLocations(locations []maintenance.KnownLocation) []maintenance.KnownLocation {
+	res := make([]maintenance.KnownLocation, 0, len(locations))
+	for _, l := range locations {
+		l.LastSeen = l.LastSeen.UTC()
+		res = append(res, l)
+	}
+	return res
+}
+
+// testMaintenanceContents returns test data for the maintenance API.
+func testMaintenanceContents() *maintenance.Contents {
+	baseTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &maintenance.Contents{
+		UncompressedDigests: []maintenance.UncompressedDigestPair{
+			{Digest: digestCompressedA, UncompressedDigest: digestUncompressed},
+			{Digest: digestCompressedB, UncompressedDigest: digestUncompressed},
+			{Digest: digestGzip, UncompressedDigest: digestFilteringUncompressed},
+		},
+		TOCUncompressedDigests: []maintenance.TOCUncompressedDigestPair{
+			{TOCDigest: digestZstdChunked, UncompressedDigest: digestFilteringUncompressed},
+		},
+		Compressors: []maintenance.CompressorRecord{
+			{Digest: digestCompressedA, Compressor: compressorNameA},
+			{Digest: digestCompressedB, Compressor: compressorNameB, SpecificVariantCompressor: "zstd:chunked",
+				SpecificVariantAnnotations: map[string]string{"a": "b"}},
+			{Digest: digestGzip, Compressor: compressorNameA},
+		},
+		KnownLocations: []maintenance.KnownLocation{
+			{Transport: "t", Scope: "A", Digest: digestCompressedA, Location: "A1", LastSeen: baseTime},
+			{Transport: "t", Scope: "B", Digest: digestCompressedB, Location: "B1", LastSeen: baseTime.Add(2 * time.Hour)},
+			{Transport: "t", Scope: "B", Digest: digestUncompressed, Location: "U1", LastSeen: baseTime.Add(time.Hour)},
+			{Transport: "t", Scope: "A", Digest: digestGzip, Location: "G1", LastSeen: baseTime.Add(-time.Hour)},
+		},
+	}
+}
+
+func testMaintenanceExportImport(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache) {
+	contents, err := mc.Export()
+	require.NoError(t, err)
+	assert.Empty(t, contents.UncompressedDigests)
+	assert.Empty(t, contents.KnownLocations)
+
+	imported := testMaintenanceContents()
+	err = mc.Import(imported)
+	require.NoError(t, err)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, imported.UncompressedDigests, contents.UncompressedDigests)
+	assert.ElementsMatch(t, imported.TOCUncompressedDigests, contents.TOCUncompressedDigests)
+	assert.ElementsMatch(t, imported.Compressors, contents.Compressors)
+	assert.ElementsMatch(t, imported.KnownLocations, normalizeLocations(contents.KnownLocations))
+
+	// Imported data is visible through the ordinary API
+	assert.Equal(t, digestUncompressed, cache.UncompressedDigest(digestCompressedB))
+	assert.Equal(t, digestFilteringUncompressed, cache.UncompressedDigestForTOC(digestZstdChunked))
+
+	// Importing older known locations does not overwrite newer ones.
+	older := imported.KnownLocations[0]
+	older.LastSeen = older.LastSeen.Add(-24 * time.Hour)
+	newer := imported.KnownLocations[1]
+	newer.LastSeen = newer.LastSeen.Add(24 * time.Hour)
+	err = mc.Import(&maintenance.Contents{KnownLocations: []maintenance.KnownLocation{older, newer}})
+	require.NoError(t, err)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []maintenance.KnownLocation{
+		imported.KnownLocations[0], newer, imported.KnownLocations[2], imported.KnownLocations[3],
+	}, normalizeLocations(contents.KnownLocations))
+
+	// Recorded known locations are exported.
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	before := time.Now()
+	cache.RecordKnownLocation(transport, types.BICTransportScope{Opaque: "C"}, digestUnknownLocation, types.BICLocationReference{Opaque: "L"})
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	found := false
+	for _, l := range contents.KnownLocations {
+		if l.Digest == digestUnknownLocation {
+			assert.Equal(t, "==BlobInfocache transport mock", l.Transport)
+			assert.Equal(t, "C", l.Scope)
+			assert.Equal(t, "L", l.Location)
+			assert.False(t, l.LastSeen.Before(before.Truncate(time.Second)))
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	// Importing a compressor without a specific variant removes a previously recorded one.
+	err = mc.Import(&maintenance.Contents{Compressors: []maintenance.CompressorRecord{
+		{Digest: digestCompressedB, Compressor: compressorNameA},
+	}})
+	require.NoError(t, err)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.Contains(t, contents.Compressors, maintenance.CompressorRecord{Digest: digestCompressedB, Compressor: compressorNameA})
+
+	// Invalid data is rejected
+	err = mc.Import(&maintenance.Contents{UncompressedDigests: []maintenance.UncompressedDigestPair{
+		{Digest: "this is invalid", UncompressedDigest: digestUncompressed},
+	}})
+	assert.Error(t, err)
+}
+
+func testMaintenanceExportTo(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache) {
+	for _, c := range []struct {
+		name     string
+		contents *maintenance.Contents
+	}{
+		{"empty", &maintenance.Contents{}},
+		{"locations only", &maintenance.Contents{KnownLocations: testMaintenanceContents().KnownLocations}},
+		{"full", testMaintenanceContents()},
+	} {
+		err := mc.Import(c.contents)
+		require.NoError(t, err, c.name)
+		contents, err := mc.Export()
+		require.NoError(t, err, c.name)
+		expected, err := json.Marshal(contents)
+		require.NoError(t, err, c.name)
+
+		var buf bytes.Buffer
+		err = mc.ExportTo(&buf)
+		require.NoError(t, err, c.name)
+		assert.JSONEq(t, string(expected), buf.String(), c.name)
+	}
+}
+
+func testMaintenanceDigestInfo(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache) {
+	info, err := mc.DigestInfo(digestUnknown)
+	require.NoError(t, err)
+	assert.Equal(t, &maintenance.DigestInfo{
+		Digest:         digestUnknown,
+		RelatedDigests: []digest.Digest{},
+		Locations:      []maintenance.KnownLocation{},
+	}, info)
+
+	imported := testMaintenanceContents()
+	err = mc.Import(imported)
+	require.NoError(t, err)
+
+	info, err = mc.DigestInfo(digestCompressedA)
+	require.NoError(t, err)
+	assert.Equal(t, digestCompressedA, info.Digest)
+	assert.Equal(t, digestUncompressed, info.UncompressedDigest)
+	assert.Equal(t, &imported.Compressors[0], info.Compressor)
+	assert.ElementsMatch(t, []digest.Digest{digestUncompressed, digestCompressedB}, info.RelatedDigests)
+	// Most recently seen first
+	assert.Equal(t, []maintenance.KnownLocation{
+		imported.KnownLocations[1], imported.KnownLocations[2], imported.KnownLocations[0],
+	}, normalizeLocations(info.Locations))
+
+	info, err = mc.DigestInfo(digestZstdChunked)
+	require.NoError(t, err)
+	assert.Equal(t, digestFilteringUncompressed, info.UncompressedDigestForTOC)
+	assert.Empty(t, info.Locations)
+}
+
+func testMaintenancePrune(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache) {
+	imported := testMaintenanceContents()
+	err := mc.Import(imported)
+	require.NoError(t, err)
+
+	// Nothing to do
+	stats, err := mc.Prune(maintenance.PruneOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{}, stats)
+
+	// By age
+	stats, err = mc.Prune(maintenance.PruneOptions{LastSeenBefore: imported.KnownLocations[0].LastSeen})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{RemovedKnownLocations: 1}, stats)
+	contents, err := mc.Export()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, imported.KnownLocations[:3], normalizeLocations(contents.KnownLocations))
+	assert.Len(t, contents.UncompressedDigests, 3)
+
+	// Unreferenced digest data for digestGzip, which has no remaining location
+	stats, err = mc.Prune(maintenance.PruneOptions{RemoveUnreferencedDigests: true})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{RemovedDigestRecords: 3}, stats)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, imported.UncompressedDigests[:2], contents.UncompressedDigests)
+	assert.Empty(t, contents.TOCUncompressedDigests)
+	assert.ElementsMatch(t, imported.Compressors[:2], contents.Compressors)
+	assert.Equal(t, digest.Digest(""), cache.UncompressedDigest(digestGzip))
+
+	// By count, keeping the most recently seen
+	stats, err = mc.Prune(maintenance.PruneOptions{MaxKnownLocations: 1, RemoveUnreferencedDigests: true})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{RemovedKnownLocations: 2}, stats)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.Equal(t, []maintenance.KnownLocation{imported.KnownLocations[1]}, normalizeLocations(contents.KnownLocations))
+	// digestCompressedA is still substitutable for the remaining digestCompressedB.
+	assert.ElementsMatch(t, imported.UncompressedDigests[:2], contents.UncompressedDigests)
+	assert.Equal(t, digestUncompressed, cache.UncompressedDigest(digestCompressedA))
+
+	// Everything
+	stats, err = mc.Prune(maintenance.PruneOptions{LastSeenBefore: time.Now(), RemoveUnreferencedDigests: true})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{RemovedKnownLocations: 1, RemovedDigestRecords: 4}, stats)
+	contents, err = mc.Export()
+	require.NoError(t, err)
+	assert.Empty(t, contents.UncompressedDigests)
+	assert.Empty(t, contents.TOCUncompressedDigests)
+	assert.Empty(t, contents.Compressors)
+	assert.Empty(t, contents.KnownLocations)
+	// The cache is still usable
+	cache.RecordDigestUncompressedPair(digestCompressedA, digestUncompressed)
+	assert.Equal(t, digestUncompressed, cache.UncompressedDigest(digestCompressedA))
+}
+
+func testMaintenancePruneToSize(t *testing.T, cache blobinfocache.BlobInfoCache2, mc maintenance.Cache) {
+	const locations = 2000
+	baseTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	contents := &maintenance.Contents{}
+	for i := range locations {
+		contents.KnownLocations = append(contents.KnownLocations, maintenance.KnownLocation{
+			Transport: "docker",
+			Scope:     "example.com/repo",
+			Digest:    digest.FromString(fmt.Sprint(i)),
+			Location:  fmt.Sprintf("%0200d", i),
+			LastSeen:  baseTime.Add(time.Duration(i) * time.Second),
+		})
+	}
+	err := mc.Import(contents)
+	require.NoError(t, err)
+
+	// Large enough
+	stats, err := mc.Prune(maintenance.PruneOptions{MaxSize: 1 << 40})
+	require.NoError(t, err)
+	assert.Equal(t, maintenance.PruneStats{}, stats)
+
+	// Only the most recently seen locations are kept
+	stats, err = mc.Prune(maintenance.PruneOptions{MaxSize: 128 * 1024})
+	require.NoError(t, err)
+	assert.Greater(t, stats.RemovedKnownLocations, 0)
+	assert.Less(t, stats.RemovedKnownLocations, locations)
+	exported, err := mc.Export()
+	require.NoError(t, err)
+	assert.Len(t, exported.KnownLocations, locations-stats.RemovedKnownLocations)
+	for _, l := range exported.KnownLocations {
+		assert.False(t, l.LastSeen.Before(baseTime.Add(time.Duration(stats.RemovedKnownLocations)*time.Second)), l.Location)
+	}
+
+	// Too small to contain anything
+	_, err = mc.Prune(maintenance.PruneOptions{MaxSize: 1})
+	require.NoError(t, err)
+	exported, err = mc.Export()
+	require.NoError(t, err)
+	assert.Empty(t, exported.KnownLocations)
+	// The cache is still usable
+	cache.RecordDigestUncompressedPair(digestCompressedA, digestUncompressed)
+	assert.Equal(t, digestUncompressed, cache.UncompressedDigest(digestCompressedA))
+}
@@ -0,0 +1,122 @@
+// Package maintenance defines an API to inspect, prune, export and import the contents of persistent
+// blob info caches, as implemented by pkg/blobinfocache/sqlite and pkg/blobinfocache/boltdb.
+//
+// Blob info caches only record hints; removing any of the data is always safe, it may only make
+// future copies less efficient.
+package maintenance
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/types"
+)
+
+// KnownLocation is a single known location of a blob, as recorded by types.BlobInfoCache.RecordKnownLocation.
+type KnownLocation struct {
+	Transport string        `json:"transport"` // types.ImageTransport.Name()
+	Scope     string        `json:"scope"`     // types.BICTransportScope.Opaque
+	Digest    digest.Digest `json:"digest"`
+	Location  string        `json:"location"` // types.BICLocationReference.Opaque
+	// LastSeen is the time the location was most recently recorded.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// UncompressedDigestPair records that the uncompressed version of a blob with Digest has UncompressedDigest.
+type UncompressedDigestPair struct {
+	Digest             digest.Digest `json:"digest"`
+	UncompressedDigest digest.Digest `json:"uncompressedDigest"`
+}
+
+// TOCUncompressedDigestPair records that a blob with a TOC digest TOCDigest has UncompressedDigest.
+type TOCUncompressedDigestPair struct {
+	TOCDigest          digest.Digest `json:"tocDigest"`
+	UncompressedDigest digest.Digest `json:"uncompressedDigest"`
+}
+
+// CompressorRecord records the compression used for a blob with Digest.
+type CompressorRecord struct {
+	Digest digest.Digest `json:"digest"`
+	// Compressor is a compression algorithm name, or "uncompressed" (blobinfocache.Uncompressed).
+	Compressor string `json:"compressor"`
+	// SpecificVariantCompressor and SpecificVariantAnnotations describe a specific variant of Compressor
+	// (e.g. zstd:chunked), if known.
+	SpecificVariantCompressor  string            `json:"specificVariantCompressor,omitempty"`
+	SpecificVariantAnnotations map[string]string `json:"specificVariantAnnotations,omitempty"`
+}
+
+// Contents is the full contents of a blob info cache. It can be serialized as JSON.
+type Contents struct {
+	UncompressedDigests    []UncompressedDigestPair    `json:"uncompressedDigests"`
+	TOCUncompressedDigests []TOCUncompressedDigestPair `json:"tocUncompressedDigests"`
+	Compressors            []CompressorRecord          `json:"compressors"`
+	KnownLocations         []KnownLocation             `json:"knownLocations"`
+}
+
+// DigestInfo is everything a blob info cache knows about a single digest, primarily intended to
+// help debug why a blob was, or was not, reused.
+type DigestInfo struct {
+	Digest digest.Digest `json:"digest"`
+	// UncompressedDigest is the uncompressed digest of the blob, if known.
+	UncompressedDigest digest.Digest `json:"uncompressedDigest,omitempty"`
+	// UncompressedDigestForTOC is the uncompressed digest of a blob with TOC digest Digest, if known.
+	UncompressedDigestForTOC digest.Digest `json:"uncompressedDigestForTOC,omitempty"`
+	// Compressor is the compression of the blob, if known.
+	Compressor *CompressorRecord `json:"compressor,omitempty"`
+	// RelatedDigests are other digests with the same UncompressedDigest, which may be substituted for Digest.
+	// This includes UncompressedDigest itself, if it differs from Digest.
+	RelatedDigests []digest.Digest `json:"relatedDigests"`
+	// Locations are known locations of Digest and of RelatedDigests, most recently seen first.
+	Locations []KnownLocation `json:"locations"`
+}
+
+// PruneOptions specifies what data to remove from a cache. The zero value removes nothing.
+type PruneOptions struct {
+	// LastSeenBefore, if not zero, removes known locations which were last recorded before this time.
+	LastSeenBefore time.Time
+	// MaxKnownLocations, if positive, limits the number of known locations, removing the least recently seen ones.
+	// This is a count of records, not a size; use MaxSize to limit the size of the cache on disk.
+	MaxKnownLocations int
+	// RemoveUnreferencedDigests removes uncompressed-digest and compressor data about blobs which have no known location,
+	// not even via a digest with the same uncompressed digest, after other pruning is done.
+	RemoveUnreferencedDigests bool
+	// MaxSize, if positive, limits the size of the cache on disk, in bytes. After other pruning is done, the least recently seen
+	// known locations, and digest data which they no longer reference, are removed, and the cache is compacted,
+	// until the cache is at most MaxSize bytes large, or there is no more data to remove.
+	MaxSize int64
+}
+
+// PruneStats describes the data removed by Cache.Prune.
+type PruneStats struct {
+	RemovedKnownLocations int `json:"removedKnownLocations"`
+	// RemovedDigestRecords counts removed uncompressed digest, TOC and compressor records.
+	RemovedDigestRecords int `json:"removedDigestRecords"`
+}
+
+// Cache is a persistent blob info cache which supports maintenance operations.
+type Cache interface {
+	// Prune removes data selected by options, and returns statistics about the removed data.
+	// Implementations may also compact the underlying storage.
+	Prune(options PruneOptions) (PruneStats, error)
+	// Export returns the full contents of the cache.
+	Export() (*Contents, error)
+	// ExportTo writes the full contents of the cache to w, in the JSON format of Contents.
+	// Unlike Export, it does not hold all of the contents in memory.
+	ExportTo(w io.Writer) error
+	// Import merges contents into the cache. Known locations which already exist keep the more recent LastSeen value.
+	// WARNING: The cache must only contain LOCALLY VERIFIED data; only import contents exported from a trusted cache.
+	Import(contents *Contents) error
+	// DigestInfo returns everything the cache knows about d.
+	DigestInfo(d digest.Digest) (*DigestInfo, error)
+}
+
+// ForCache returns the maintenance interface of c, or an error if c does not support maintenance operations.
+func ForCache(c types.BlobInfoCache) (Cache, error) {
+	mc, ok := c.(Cache)
+	if !ok {
+		return nil, fmt.Errorf("blob info cache %T does not support maintenance operations", c)
+	}
+	return mc, nil
+}
@@ -0,0 +1,34 @@
+package maintenance
+
+import (
+	"io"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/types"
+)
+
+// maintainableCache is a minimal types.BlobInfoCache which also implements Cache.
+type maintainableCache struct {
+	types.BlobInfoCache
+}
+
+func (maintainableCache) Prune(options PruneOptions) (PruneStats, error) { return PruneStats{}, nil }
+func (maintainableCache) Export() (*Contents, error)                     { return &Contents{}, nil }
+func (maintainableCache) ExportTo(w io.Writer) error                     { return nil }
+func (maintainableCache) Import(contents *Contents) error                { return nil }
+func (maintainableCache) DigestInfo(d digest.Digest) (*DigestInfo, error) {
+	return &DigestInfo{Digest: d}, nil
+}
+
+func TestForCache(t *testing.T) {
+	c := maintainableCache{BlobInfoCache: none.NoCache}
+	mc, err := ForCache(c)
+	assert.NoError(t, err)
+	assert.Equal(t, c, mc)
+
+	_, err = ForCache(none.NoCache)
+	assert.Error(t, err)
+}
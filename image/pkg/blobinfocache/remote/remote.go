@@ -0,0 +1,567 @@
+// Package remote implements a BlobInfoCache shared between hosts, backed by a simple HTTP key/value service,
+// falling back to a local BlobInfoCache when the service is unavailable.
+//
+// The service must implement the following operations, where KEY is path-escaped:
+//
+//	GET    /v1/kv/KEY           returns the value of KEY, or 404 if it does not exist
+//	PUT    /v1/kv/KEY           sets the value of KEY to the request body
+//	DELETE /v1/kv/KEY           removes KEY, if it exists
+//	GET    /v1/kv/?prefix=P     returns a JSON object mapping all keys starting with P to their values
+//
+// Server is a reference implementation of the service.
+//
+// WARNING: All data in the shared cache is trusted by all of its users, the same as data recorded
+// locally; an attacker able to write to the service can cause unexpected blobs to be substituted.
+// Only use a service restricted to trusted clients.
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/internal/blobinfocache"
+	"go.podman.io/image/v5/pkg/blobinfocache/internal/prioritize"
+	"go.podman.io/image/v5/types"
+)
+
+const (
+	// kvPathPrefix is the path of the key/value API, relative to the service root.
+	kvPathPrefix = "/v1/kv/"
+	// requestTimeout is the maximum time an individual request to the service may take.
+	// This is short, because the cache is only an optimization; we would rather fall back to the local cache.
+	requestTimeout = 5 * time.Second
+	// defaultRetryInterval is the time after a failure for which we don’t try to contact the service.
+	defaultRetryInterval = 1 * time.Minute
+	// maxResponseSize is the maximum size of a response we are willing to read.
+	maxResponseSize = 16 * 1024 * 1024
+)
+
+// Key prefixes. Components within the keys are escaped using url.PathEscape, so they never contain "/".
+const (
+	// uncompressedPrefix + digest → uncompressed digest
+	uncompressedPrefix = "uncompressed/"
+	// byUncompressedPrefix + uncompressed digest + "/" + digest → "": the set of digests with that uncompressed digest
+	byUncompressedPrefix = "by-uncompressed/"
+	// tocPrefix + TOC digest → uncompressed digest
+	tocPrefix = "toc/"
+	// compressorPrefix + digest → JSON-encoded compressorValue
+	compressorPrefix = "compressor/"
+	// locationPrefix + transport + "/" + scope + "/" + digest + "/" + location → time.RFC3339Nano time it was last recorded
+	locationPrefix = "location/"
+)
+
+// errNotFound is returned by cache.get if the key does not exist.
+var errNotFound = errors.New("key not found")
+
+// compressorValue is the value stored for compressorPrefix keys.
+type compressorValue struct {
+	BaseVariantCompressor      string            `json:"baseVariantCompressor"`
+	SpecificVariantCompressor  string            `json:"specificVariantCompressor,omitempty"`
+	SpecificVariantAnnotations map[string]string `json:"specificVariantAnnotations,omitempty"`
+}
+
+// cache is a BlobInfoCache implementation which uses a shared key/value service, with a local fallback.
+//
+// All records are written to both the service and the local cache, so that the local cache remains useful
+// while the service is unavailable.
+type cache struct {
+	baseURL       *url.URL
+	client        *http.Client
+	local         blobinfocache.BlobInfoCache2
+	retryInterval time.Duration
+
+	mutex sync.Mutex
+	// The following fields can only be accessed with mutex held.
+	unavailableUntil time.Time // If not zero, don’t contact the service before this time.
+}
+
+// New returns a BlobInfoCache implementation which uses the key/value service at serverURL,
+// and local (typically the result of blobinfocache.DefaultCache) when the service is unavailable.
+//
+// Most users should set types.SystemContext.BlobInfoCacheURL and call blobinfocache.DefaultCache instead.
+func New(serverURL string, local types.BlobInfoCache) (types.BlobInfoCache, error) {
+	return new2(serverURL, blobinfocache.FromBlobInfoCache(local))
+}
+
+func new2(serverURL string, local blobinfocache.BlobInfoCache2) (*cache, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing blob info cache URL %q: %w", serverURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported blob info cache URL %q: scheme must be http or https", serverURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &cache{
+		baseURL:       u,
+		client:        &http.Client{Timeout: requestTimeout},
+		local:         local,
+		retryInterval: defaultRetryInterval,
+	}, nil
+}
+
+// Open() sets up the cache for future accesses, potentially acquiring costly state. Each Open() must be paired with a Close().
+// Note that public callers may call the types.BlobInfoCache operations without Open()/Close().
+func (c *cache) Open() {
+	c.local.Open()
+}
+
+// Close destroys state created by Open().
+func (c *cache) Close() {
+	c.local.Close()
+}
+
+// available returns true if the service should be contacted.
+func (c *cache) available() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.unavailableUntil.IsZero() || !time.Now().Before(c.unavailableUntil)
+}
+
+// markUnavailable records that the service failed with err, and should not be contacted for a while.
+func (c *cache) markUnavailable(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.unavailableUntil.IsZero() || !time.Now().Before(c.unavailableUntil) {
+		logrus.Warnf("Shared blob info cache at %s is unavailable, using the local cache: %v", c.baseURL.Redacted(), err)
+	}
+	c.unavailableUntil = time.Now().Add(c.retryInterval)
+}
+
+// markAvailable records that the service responded successfully.
+func (c *cache) markAvailable() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.unavailableUntil = time.Time{}
+}
+
+// kvURL returns an URL for key, which may be empty.
+func (c *cache) kvURL(key string) *url.URL {
+	u := *c.baseURL
+	u.Path += kvPathPrefix + key
+	u.RawPath = c.baseURL.EscapedPath() + kvPathPrefix + url.PathEscape(key)
+	return &u
+}
+
+// do performs a request with method for u, and returns the response body, or errNotFound.
+func (c *cache) do(method string, u *url.URL, body []byte) ([]byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, fmt.Errorf("%s %s: unexpected HTTP status %s", method, u.Redacted(), res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, fmt.Errorf("%s %s: response too large", method, u.Redacted())
+	}
+	return data, nil
+}
+
+// get returns the value of key, or errNotFound.
+func (c *cache) get(key string) (string, error) {
+	data, err := c.do(http.MethodGet, c.kvURL(key), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// put sets key to value.
+func (c *cache) put(key, value string) error {
+	_, err := c.do(http.MethodPut, c.kvURL(key), []byte(value))
+	return err
+}
+
+// delete removes key.
+func (c *cache) delete(key string) error {
+	_, err := c.do(http.MethodDelete, c.kvURL(key), nil)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	return err
+}
+
+// list returns all keys starting with prefix, and their values.
+func (c *cache) list(prefix string) (map[string]string, error) {
+	u := c.kvURL("")
+	u.RawQuery = url.Values{"prefix": {prefix}}.Encode()
+	data, err := c.do(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]string{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("parsing key listing: %w", err)
+	}
+	return res, nil
+}
+
+// withService calls fn if the service is available, and returns true if it succeeded.
+// On failure, the service is marked as unavailable.
+func (c *cache) withService(fn func() error) bool {
+	if !c.available() {
+		return false
+	}
+	if err := fn(); err != nil {
+		c.markUnavailable(err)
+		return false
+	}
+	c.markAvailable()
+	return true
+}
+
+// locationKeyPrefix returns the key prefix of all locations of (transport, scope, blobDigest).
+func locationKeyPrefix(transport string, scope types.BICTransportScope, blobDigest digest.Digest) string {
+	return locationPrefix + url.PathEscape(transport) + "/" + url.PathEscape(scope.Opaque) + "/" + url.PathEscape(blobDigest.String()) + "/"
+}
+
+// getDigest returns the digest value of key, or "" if it does not exist.
+func (c *cache) getDigest(key string) (digest.Digest, error) {
+	value, err := c.get(key)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	d, err := digest.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest value for %q: %w", key, err)
+	}
+	return d, nil
+}
+
+// uncompressedDigest implements types.BlobInfoCache.UncompressedDigest using the service.
+func (c *cache) uncompressedDigest(anyDigest digest.Digest) (digest.Digest, error) {
+	d, err := c.getDigest(uncompressedPrefix + url.PathEscape(anyDigest.String()))
+	if err != nil || d != "" {
+		return d, err
+	}
+	// Presence in byUncompressedPrefix implies that anyDigest must already refer to an uncompressed digest.
+	// This way we don't have to waste storage space with trivial (uncompressed, uncompressed) mappings
+	// when we already record a (compressed, uncompressed) pair.
+	others, err := c.list(byUncompressedPrefix + url.PathEscape(anyDigest.String()) + "/")
+	if err != nil {
+		return "", err
+	}
+	if len(others) != 0 {
+		return anyDigest, nil
+	}
+	return "", nil
+}
+
+// UncompressedDigest returns an uncompressed digest corresponding to anyDigest.
+// May return anyDigest if it is known to be uncompressed.
+// Returns "" if nothing is known about the digest (it may be compressed or uncompressed).
+func (c *cache) UncompressedDigest(anyDigest digest.Digest) digest.Digest {
+	var res digest.Digest
+	if c.withService(func() error {
+		var err error
+		res, err = c.uncompressedDigest(anyDigest)
+		return err
+	}) && res != "" {
+		return res
+	}
+	return c.local.UncompressedDigest(anyDigest)
+}
+
+// RecordDigestUncompressedPair records that the uncompressed version of anyDigest is uncompressed.
+// It’s allowed for anyDigest == uncompressed.
+// WARNING: Only call this for LOCALLY VERIFIED data; don’t record a digest pair just because some remote author claims so (e.g.
+// because a manifest/config pair exists); otherwise the cache could be poisoned and allow substituting unexpected blobs.
+// (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
+func (c *cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
+	c.local.RecordDigestUncompressedPair(anyDigest, uncompressed)
+	c.withService(func() error {
+		key := uncompressedPrefix + url.PathEscape(anyDigest.String())
+		previous, err := c.getDigest(key)
+		if err != nil {
+			return err
+		}
+		if previous != "" && previous != uncompressed {
+			logrus.Warnf("Uncompressed digest for blob %s previously recorded as %s, now %s", anyDigest, previous, uncompressed)
+		}
+		if err := c.put(key, uncompressed.String()); err != nil {
+			return err
+		}
+		return c.put(byUncompressedPrefix+url.PathEscape(uncompressed.String())+"/"+url.PathEscape(anyDigest.String()), "")
+	})
+}
+
+// UncompressedDigestForTOC returns an uncompressed digest corresponding to anyDigest.
+// Returns "" if the uncompressed digest is unknown.
+func (c *cache) UncompressedDigestForTOC(tocDigest digest.Digest) digest.Digest {
+	var res digest.Digest
+	if c.withService(func() error {
+		var err error
+		res, err = c.getDigest(tocPrefix + url.PathEscape(tocDigest.String()))
+		return err
+	}) && res != "" {
+		return res
+	}
+	return c.local.UncompressedDigestForTOC(tocDigest)
+}
+
+// RecordTOCUncompressedPair records that the tocDigest corresponds to uncompressed.
+// WARNING: Only call this for LOCALLY VERIFIED data; don’t record a digest pair just because some remote author claims so (e.g.
+// because a manifest/config pair exists); otherwise the cache could be poisoned and allow substituting unexpected blobs.
+// (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
+func (c *cache) RecordTOCUncompressedPair(tocDigest digest.Digest, uncompressed digest.Digest) {
+	c.local.RecordTOCUncompressedPair(tocDigest, uncompressed)
+	c.withService(func() error {
+		key := tocPrefix + url.PathEscape(tocDigest.String())
+		previous, err := c.getDigest(key)
+		if err != nil {
+			return err
+		}
+		if previous != "" && previous != uncompressed {
+			logrus.Warnf("Uncompressed digest for blob with TOC %q previously recorded as %q, now %q", tocDigest, previous, uncompressed)
+		}
+		return c.put(key, uncompressed.String())
+	})
+}
+
+// RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope) scope,
+// and can be reused given the opaque location data.
+func (c *cache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, blobDigest digest.Digest, location types.BICLocationReference) {
+	c.local.RecordKnownLocation(transport, scope, blobDigest, location)
+	c.withService(func() error {
+		// Possibly overwriting an older entry.
+		return c.put(locationKeyPrefix(transport.Name(), scope, blobDigest)+url.PathEscape(location.Opaque), time.Now().UTC().Format(time.RFC3339Nano))
+	})
+}
+
+// compressorData returns the compressor data for anyDigest from the service.
+func (c *cache) compressorData(anyDigest digest.Digest) (blobinfocache.DigestCompressorData, error) {
+	res := blobinfocache.DigestCompressorData{
+		BaseVariantCompressor:      blobinfocache.UnknownCompression,
+		SpecificVariantCompressor:  blobinfocache.UnknownCompression,
+		SpecificVariantAnnotations: nil,
+	}
+	value, err := c.get(compressorPrefix + url.PathEscape(anyDigest.String()))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return res, nil
+		}
+		return res, err
+	}
+	var v compressorValue
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return res, fmt.Errorf("parsing compressor data for %q: %w", anyDigest, err)
+	}
+	res.BaseVariantCompressor = v.BaseVariantCompressor
+	if v.SpecificVariantCompressor != "" {
+		res.SpecificVariantCompressor = v.SpecificVariantCompressor
+		res.SpecificVariantAnnotations = v.SpecificVariantAnnotations
+	}
+	return res, nil
+}
+
+// RecordDigestCompressorData records data for the blob with the specified digest.
+// WARNING: Only call this with LOCALLY VERIFIED data:
+//   - don’t record a compressor for a digest just because some remote author claims so
+//     (e.g. because a manifest says so);
+//   - don’t record the non-base variant or annotations if we are not _sure_ that the base variant
+//     and the blob’s digest match the non-base variant’s annotations (e.g. because we saw them
+//     in a manifest)
+//
+// otherwise the cache could be poisoned and cause us to make incorrect edits to type
+// information in a manifest.
+func (c *cache) RecordDigestCompressorData(anyDigest digest.Digest, data blobinfocache.DigestCompressorData) {
+	c.local.RecordDigestCompressorData(anyDigest, data)
+	c.withService(func() error {
+		key := compressorPrefix + url.PathEscape(anyDigest.String())
+		previous, err := c.compressorData(anyDigest)
+		if err != nil {
+			return err
+		}
+		if previous.BaseVariantCompressor != blobinfocache.UnknownCompression {
+			if previous.BaseVariantCompressor != data.BaseVariantCompressor {
+				logrus.Warnf("Base compressor for blob with digest %s previously recorded as %s, now %s", anyDigest, previous.BaseVariantCompressor, data.BaseVariantCompressor)
+			} else if previous.SpecificVariantCompressor != blobinfocache.UnknownCompression && data.SpecificVariantCompressor != blobinfocache.UnknownCompression &&
+				previous.SpecificVariantCompressor != data.SpecificVariantCompressor {
+				logrus.Warnf("Specific compressor for blob with digest %s previously recorded as %s, now %s", anyDigest, previous.SpecificVariantCompressor, data.SpecificVariantCompressor)
+			}
+			// Preserve specific variant information if the incoming data does not have it.
+			if data.BaseVariantCompressor != blobinfocache.UnknownCompression && data.SpecificVariantCompressor == blobinfocache.UnknownCompression &&
+				previous.SpecificVariantCompressor != blobinfocache.UnknownCompression {
+				data.SpecificVariantCompressor = previous.SpecificVariantCompressor
+				data.SpecificVariantAnnotations = previous.SpecificVariantAnnotations
+			}
+		}
+		if data.BaseVariantCompressor == blobinfocache.UnknownCompression {
+			return c.delete(key)
+		}
+		v := compressorValue{BaseVariantCompressor: data.BaseVariantCompressor}
+		if data.SpecificVariantCompressor != blobinfocache.UnknownCompression {
+			v.SpecificVariantCompressor = data.SpecificVariantCompressor
+			v.SpecificVariantAnnotations = data.SpecificVariantAnnotations
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.put(key, string(value))
+	})
+}
+
+// appendReplacementCandidates creates prioritize.CandidateWithTime values for (transport, scope, digest),
+// and returns the result of appending them to candidates.
+// v2Options is not nil if the caller is CandidateLocations2: this allows including candidates with unknown location, and filters out candidates
+// with unknown compression.
+func (c *cache) appendReplacementCandidates(candidates []prioritize.CandidateWithTime, transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest,
+	v2Options *blobinfocache.CandidateLocations2Options,
+) ([]prioritize.CandidateWithTime, error) {
+	compressionData := blobinfocache.DigestCompressorData{
+		BaseVariantCompressor:      blobinfocache.UnknownCompression,
+		SpecificVariantCompressor:  blobinfocache.UnknownCompression,
+		SpecificVariantAnnotations: nil,
+	}
+	if v2Options != nil {
+		var err error
+		compressionData, err = c.compressorData(digest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	template := prioritize.CandidateTemplateWithCompression(v2Options, digest, compressionData)
+	if template == nil {
+		return candidates, nil
+	}
+
+	prefix := locationKeyPrefix(transport.Name(), scope, digest)
+	locations, err := c.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range locations {
+		location, err := url.PathUnescape(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid location key %q: %w", key, err)
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time for location key %q: %w", key, err)
+		}
+		candidates = append(candidates, template.CandidateWithLocation(types.BICLocationReference{Opaque: location}, t))
+	}
+	if len(locations) == 0 && v2Options != nil {
+		candidates = append(candidates, template.CandidateWithUnknownLocation())
+	}
+	return candidates, nil
+}
+
+// CandidateLocations2 returns a prioritized, limited, number of blobs and their locations (if known)
+// that could possibly be reused within the specified (transport scope) (if they still
+// exist, which is not guaranteed).
+func (c *cache) CandidateLocations2(transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest, options blobinfocache.CandidateLocations2Options) []blobinfocache.BICReplacementCandidate2 {
+	res, ok := c.candidateLocations(transport, scope, digest, options.CanSubstitute, &options)
+	if ok && len(res) != 0 {
+		return res
+	}
+	if localRes := c.local.CandidateLocations2(transport, scope, digest, options); len(localRes) != 0 || !ok {
+		return localRes
+	}
+	return res
+}
+
+// candidateLocations implements CandidateLocations / CandidateLocations2 using the service.
+// It returns false if the service is unavailable.
+// v2Options is not nil if the caller is CandidateLocations2.
+func (c *cache) candidateLocations(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool,
+	v2Options *blobinfocache.CandidateLocations2Options,
+) ([]blobinfocache.BICReplacementCandidate2, bool) {
+	var res []prioritize.CandidateWithTime
+	var uncompressedDigest digest.Digest // = ""
+	if !c.withService(func() error {
+		res = []prioritize.CandidateWithTime{}
+		var err error
+		res, err = c.appendReplacementCandidates(res, transport, scope, primaryDigest, v2Options)
+		if err != nil {
+			return err
+		}
+		if canSubstitute {
+			uncompressedDigest, err = c.uncompressedDigest(primaryDigest)
+			if err != nil {
+				return err
+			}
+			if uncompressedDigest != "" {
+				prefix := byUncompressedPrefix + url.PathEscape(uncompressedDigest.String()) + "/"
+				others, err := c.list(prefix)
+				if err != nil {
+					return err
+				}
+				for key := range others {
+					otherDigestString, err := url.PathUnescape(strings.TrimPrefix(key, prefix))
+					if err != nil {
+						return fmt.Errorf("invalid digest key %q: %w", key, err)
+					}
+					otherDigest, err := digest.Parse(otherDigestString)
+					if err != nil {
+						return fmt.Errorf("invalid digest key %q: %w", key, err)
+					}
+					if otherDigest != primaryDigest && otherDigest != uncompressedDigest {
+						res, err = c.appendReplacementCandidates(res, transport, scope, otherDigest, v2Options)
+						if err != nil {
+							return err
+						}
+					}
+				}
+				if uncompressedDigest != primaryDigest {
+					res, err = c.appendReplacementCandidates(res, transport, scope, uncompressedDigest, v2Options)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}) {
+		return nil, false
+	}
+	return prioritize.DestructivelyPrioritizeReplacementCandidates(res, primaryDigest, uncompressedDigest), true
+}
+
+// CandidateLocations returns a prioritized, limited, number of blobs and their locations that could possibly be reused
+// within the specified (transport scope) (if they still exist, which is not guaranteed).
+//
+// If !canSubstitute, the returned candidates will match the submitted digest exactly; if canSubstitute,
+// data from previous RecordDigestUncompressedPair calls is used to also look up variants of the blob which have the same
+// uncompressed digest.
+func (c *cache) CandidateLocations(transport types.ImageTransport, scope types.BICTransportScope, digest digest.Digest, canSubstitute bool) []types.BICReplacementCandidate {
+	res, ok := c.candidateLocations(transport, scope, digest, canSubstitute, nil)
+	if ok && len(res) != 0 {
+		return blobinfocache.CandidateLocationsFromV2(res)
+	}
+	if localRes := c.local.CandidateLocations(transport, scope, digest, canSubstitute); len(localRes) != 0 || !ok {
+		return localRes
+	}
+	return blobinfocache.CandidateLocationsFromV2(res)
+}
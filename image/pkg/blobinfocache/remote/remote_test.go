@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/blobinfocache"
+	"go.podman.io/image/v5/internal/testing/mocks"
+	"go.podman.io/image/v5/pkg/blobinfocache/internal/test"
+	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/types"
+)
+
+var _ blobinfocache.BlobInfoCache2 = &cache{}
+
+const (
+	digestCompressed   = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	digestUncompressed = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+)
+
+func newTestCache(t *testing.T) blobinfocache.BlobInfoCache2 {
+	// Also test that the service does not need to be at the root of the server.
+	mux := http.NewServeMux()
+	mux.Handle("/some/path/", http.StripPrefix("/some/path", NewServer()))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	// Use a no-op local cache, so that the generic tests exercise the service.
+	cache, err := new2(server.URL+"/some/path/", blobinfocache.FromBlobInfoCache(none.NoCache))
+	require.NoError(t, err)
+	return cache
+}
+
+func TestNew(t *testing.T) {
+	test.GenericCache(t, newTestCache)
+
+	for _, u := range []string{
+		"",
+		"unix:///run/cache.sock",
+		"://",
+	} {
+		_, err := New(u, memory.New())
+		assert.Error(t, err, u)
+	}
+}
+
+func TestSharing(t *testing.T) {
+	server := httptest.NewServer(NewServer())
+	defer server.Close()
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scope := types.BICTransportScope{Opaque: "registry.example/a b/c"}
+	location := types.BICLocationReference{Opaque: "some/location%2F?#"}
+
+	c1, err := New(server.URL, memory.New())
+	require.NoError(t, err)
+	c1.RecordDigestUncompressedPair(digestCompressed, digestUncompressed)
+	c1.RecordKnownLocation(transport, scope, digestUncompressed, location)
+
+	// A separate cache, with a separate local cache, sees the data.
+	c2, err := New(server.URL, memory.New())
+	require.NoError(t, err)
+	assert.Equal(t, digestUncompressed, c2.UncompressedDigest(digestCompressed))
+	assert.Equal(t, digestUncompressed, c2.UncompressedDigest(digestUncompressed))
+	assert.Equal(t, []types.BICReplacementCandidate{
+		{Digest: digestUncompressed, Location: location},
+	}, c2.CandidateLocations(transport, scope, digestCompressed, true))
+}
+
+func TestFallback(t *testing.T) {
+	s := NewServer()
+	server := httptest.NewServer(s)
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scope := types.BICTransportScope{Opaque: "A"}
+	location := types.BICLocationReference{Opaque: "L"}
+
+	local := memory.New()
+	c, err := new2(server.URL, blobinfocache.FromBlobInfoCache(local))
+	require.NoError(t, err)
+
+	// Data is recorded both in the service and locally.
+	c.RecordDigestUncompressedPair(digestCompressed, digestUncompressed)
+	assert.Equal(t, digestUncompressed, local.UncompressedDigest(digestCompressed))
+	assert.Len(t, s.values, 2)
+
+	// With the service down, the local cache is used.
+	server.Close()
+	assert.Equal(t, digestUncompressed, c.UncompressedDigest(digestCompressed))
+	assert.False(t, c.available())
+	c.RecordKnownLocation(transport, scope, digestCompressed, location)
+	assert.Equal(t, []types.BICReplacementCandidate{
+		{Digest: digestCompressed, Location: location},
+	}, c.CandidateLocations(transport, scope, digestCompressed, false))
+
+	// The service is retried after retryInterval.
+	server = httptest.NewUnstartedServer(s)
+	server.Start()
+	defer server.Close()
+	c, err = new2(server.URL, blobinfocache.FromBlobInfoCache(memory.New()))
+	require.NoError(t, err)
+	c.retryInterval = time.Hour
+	c.markUnavailable(assert.AnError)
+	assert.Equal(t, digest.Digest(""), c.UncompressedDigest(digestCompressed))
+	c.mutex.Lock()
+	c.unavailableUntil = time.Now().Add(-time.Second)
+	c.mutex.Unlock()
+	assert.Equal(t, digestUncompressed, c.UncompressedDigest(digestCompressed))
+	assert.True(t, c.available())
+}
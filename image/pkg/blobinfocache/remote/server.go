@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxValueSize is the maximum size of a value accepted by Server.
+const maxValueSize = 1024 * 1024
+
+// Server is a reference implementation of the key/value service used by the shared blob info cache,
+// keeping all data in memory. It is primarily intended for tests, and as an example for
+// implementing the protocol on top of a real key/value store.
+//
+// Server does not implement any access control; data written by any client is trusted by all other clients.
+type Server struct {
+	mux *http.ServeMux
+
+	mutex sync.Mutex
+	// The following fields can only be accessed with mutex held.
+	values map[string]string
+}
+
+// NewServer returns a new, empty, Server.
+func NewServer() *Server {
+	s := &Server{
+		mux:    http.NewServeMux(),
+		values: map[string]string{},
+	}
+	s.mux.HandleFunc("GET "+kvPathPrefix+"{key...}", s.get)
+	s.mux.HandleFunc("PUT "+kvPathPrefix+"{key...}", s.put)
+	s.mux.HandleFunc("DELETE "+kvPathPrefix+"{key...}", s.delete)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// get handles a GET request for a single key, or, if the key is empty, a listing of a prefix.
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		s.list(w, r)
+		return
+	}
+
+	s.mutex.Lock()
+	value, ok := s.values[key]
+	s.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.WriteString(w, value)
+}
+
+// list handles a listing of all keys with the "prefix" query parameter.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	res := map[string]string{}
+	s.mutex.Lock()
+	for k, v := range s.values {
+		if strings.HasPrefix(k, prefix) {
+			res[k] = v
+		}
+	}
+	s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// put handles a PUT request for a single key.
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	value, err := io.ReadAll(io.LimitReader(r.Body, maxValueSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(value) > maxValueSize {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	s.mutex.Lock()
+	s.values[key] = string(value)
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// delete handles a DELETE request for a single key. Deleting a nonexistent key is not an error.
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	delete(s.values, key)
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package remote
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(NewServer())
+	defer server.Close()
+
+	request := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		data, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(data)
+	}
+
+	status, _ := request(http.MethodGet, "/v1/kv/a%2Fb/c", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = request(http.MethodPut, "/v1/kv/a%2Fb/c", "value1")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(http.MethodPut, "/v1/kv/a%2Fb/d", "value2")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(http.MethodPut, "/v1/kv/b", "value3")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, body := request(http.MethodGet, "/v1/kv/a%2Fb/c", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "value1", body)
+
+	status, body = request(http.MethodGet, "/v1/kv/?prefix=a%2Fb%2F", "")
+	assert.Equal(t, http.StatusOK, status)
+	var listing map[string]string
+	err := json.Unmarshal([]byte(body), &listing)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a/b/c": "value1", "a/b/d": "value2"}, listing)
+
+	status, _ = request(http.MethodDelete, "/v1/kv/a%2Fb/c", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(http.MethodGet, "/v1/kv/a%2Fb/c", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = request(http.MethodDelete, "/v1/kv/a%2Fb/c", "")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	// Missing key
+	status, _ = request(http.MethodPut, "/v1/kv/", "value")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Too large
+	status, _ = request(http.MethodPut, "/v1/kv/large", strings.Repeat("x", maxValueSize+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+}
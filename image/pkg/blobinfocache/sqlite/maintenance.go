@@ -0,0 +1,283 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/image/v5/pkg/blobinfocache/internal/maintain"
+	"go.podman.io/image/v5/pkg/blobinfocache/maintenance"
+)
+
+// A compile-time check that cache implements maintenance.Cache.
+var _ maintenance.Cache = (*cache)(nil)
+
+// export delivers the full contents of the cache to sink, within a transaction.
+func (sqc *cache) export(tx *sql.Tx, sink maintain.ContentsSink) error {
+	if err := queryRows(tx, "SELECT anyDigest, uncompressedDigest FROM DigestUncompressedPairs", func(rows *sql.Rows) error {
+		var anyDigest, uncompressedDigest string
+		if err := rows.Scan(&anyDigest, &uncompressedDigest); err != nil {
+			return err
+		}
+		return sink.UncompressedDigest(maintenance.UncompressedDigestPair{
+			Digest:             digest.Digest(anyDigest),
+			UncompressedDigest: digest.Digest(uncompressedDigest),
+		})
+	}); err != nil {
+		return fmt.Errorf("reading uncompressed digests: %w", err)
+	}
+
+	if err := queryRows(tx, "SELECT tocDigest, uncompressedDigest FROM DigestTOCUncompressedPairs", func(rows *sql.Rows) error {
+		var tocDigest, uncompressedDigest string
+		if err := rows.Scan(&tocDigest, &uncompressedDigest); err != nil {
+			return err
+		}
+		return sink.TOCUncompressedDigest(maintenance.TOCUncompressedDigestPair{
+			TOCDigest:          digest.Digest(tocDigest),
+			UncompressedDigest: digest.Digest(uncompressedDigest),
+		})
+	}); err != nil {
+		return fmt.Errorf("reading TOC digests: %w", err)
+	}
+
+	if err := queryRows(tx, "SELECT digest, compressor, specificVariantCompressor, specificVariantAnnotations "+
+		"FROM DigestCompressors LEFT JOIN DigestSpecificVariantCompressors USING (digest)", func(rows *sql.Rows) error {
+		var d, compressor string
+		var specificVariantCompressor sql.NullString
+		var annotationBytes []byte
+		if err := rows.Scan(&d, &compressor, &specificVariantCompressor, &annotationBytes); err != nil {
+			return err
+		}
+		record := maintenance.CompressorRecord{
+			Digest:     digest.Digest(d),
+			Compressor: compressor,
+		}
+		if specificVariantCompressor.Valid && annotationBytes != nil {
+			record.SpecificVariantCompressor = specificVariantCompressor.String
+			if err := json.Unmarshal(annotationBytes, &record.SpecificVariantAnnotations); err != nil {
+				return err
+			}
+		}
+		return sink.Compressor(record)
+	}); err != nil {
+		return fmt.Errorf("reading compressors: %w", err)
+	}
+
+	if err := queryRows(tx, "SELECT transport, scope, digest, location, time FROM KnownLocations", func(rows *sql.Rows) error {
+		var l maintenance.KnownLocation
+		var d string
+		if err := rows.Scan(&l.Transport, &l.Scope, &d, &l.Location, &l.LastSeen); err != nil {
+			return err
+		}
+		l.Digest = digest.Digest(d)
+		return sink.KnownLocation(l)
+	}); err != nil {
+		return fmt.Errorf("reading known locations: %w", err)
+	}
+	return nil
+}
+
+// contents returns the full contents of the cache, within a transaction.
+func (sqc *cache) contents(tx *sql.Tx) (*maintenance.Contents, error) {
+	builder := maintain.NewContentsBuilder()
+	if err := sqc.export(tx, builder); err != nil {
+		return nil, err
+	}
+	return builder.Contents, nil
+}
+
+// queryRows executes query, and calls fn for every returned row.
+func queryRows(tx *sql.Tx, query string, fn func(rows *sql.Rows) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Export returns the full contents of the cache.
+func (sqc *cache) Export() (*maintenance.Contents, error) {
+	return transaction(sqc, sqc.contents)
+}
+
+// ExportTo writes the full contents of the cache to w, in the JSON format of maintenance.Contents.
+func (sqc *cache) ExportTo(w io.Writer) error {
+	_, err := transaction(sqc, func(tx *sql.Tx) (void, error) {
+		encoder := maintain.NewContentsEncoder(w)
+		if err := sqc.export(tx, encoder); err != nil {
+			return void{}, err
+		}
+		return void{}, encoder.Close()
+	})
+	return err
+}
+
+// Import merges contents into the cache. Known locations which already exist keep the more recent LastSeen value.
+// WARNING: The cache must only contain LOCALLY VERIFIED data; only import contents exported from a trusted cache.
+func (sqc *cache) Import(contents *maintenance.Contents) error {
+	if err := maintain.ValidateContents(contents); err != nil {
+		return err
+	}
+	_, err := transaction(sqc, func(tx *sql.Tx) (void, error) {
+		for _, p := range contents.UncompressedDigests {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO DigestUncompressedPairs(anyDigest, uncompressedDigest) VALUES (?, ?)",
+				p.Digest.String(), p.UncompressedDigest.String()); err != nil {
+				return void{}, fmt.Errorf("importing uncompressed digest %q for %q: %w", p.UncompressedDigest, p.Digest, err)
+			}
+		}
+		for _, p := range contents.TOCUncompressedDigests {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO DigestTOCUncompressedPairs(tocDigest, uncompressedDigest) VALUES (?, ?)",
+				p.TOCDigest.String(), p.UncompressedDigest.String()); err != nil {
+				return void{}, fmt.Errorf("importing uncompressed digest %q for TOC %q: %w", p.UncompressedDigest, p.TOCDigest, err)
+			}
+		}
+		for _, c := range contents.Compressors {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO DigestCompressors(digest, compressor) VALUES (?, ?)",
+				c.Digest.String(), c.Compressor); err != nil {
+				return void{}, fmt.Errorf("importing compressor %q for %q: %w", c.Compressor, c.Digest, err)
+			}
+			// A specific variant recorded earlier may not match the imported compressor.
+			if _, err := tx.Exec("DELETE FROM DigestSpecificVariantCompressors WHERE digest = ?", c.Digest.String()); err != nil {
+				return void{}, fmt.Errorf("deleting specific variant compressor for %q: %w", c.Digest, err)
+			}
+			if c.SpecificVariantCompressor != "" {
+				annotations, err := json.Marshal(c.SpecificVariantAnnotations)
+				if err != nil {
+					return void{}, err
+				}
+				if _, err := tx.Exec("INSERT OR REPLACE INTO DigestSpecificVariantCompressors(digest, specificVariantCompressor, specificVariantAnnotations) VALUES (?, ?, ?)",
+					c.Digest.String(), c.SpecificVariantCompressor, annotations); err != nil {
+					return void{}, fmt.Errorf("importing specific variant compressor %q for %q: %w", c.SpecificVariantCompressor, c.Digest, err)
+				}
+			}
+		}
+		for _, l := range contents.KnownLocations {
+			previous, found, err := querySingleValue[time.Time](tx, "SELECT time FROM KnownLocations WHERE transport = ? AND scope = ? AND digest = ? AND location = ?",
+				l.Transport, l.Scope, l.Digest.String(), l.Location)
+			if err != nil {
+				return void{}, fmt.Errorf("looking up known location %q for (%q, %q, %q): %w", l.Location, l.Transport, l.Scope, l.Digest, err)
+			}
+			if found && !previous.Before(l.LastSeen) {
+				continue
+			}
+			if _, err := tx.Exec("INSERT OR REPLACE INTO KnownLocations(transport, scope, digest, location, time) VALUES (?, ?, ?, ?, ?)",
+				l.Transport, l.Scope, l.Digest.String(), l.Location, l.LastSeen); err != nil {
+				return void{}, fmt.Errorf("importing known location %q for (%q, %q, %q): %w", l.Location, l.Transport, l.Scope, l.Digest, err)
+			}
+		}
+		return void{}, nil
+	})
+	return err
+}
+
+// Prune removes data selected by options, and returns statistics about the removed data.
+// If any data was removed, the database file is compacted.
+func (sqc *cache) Prune(options maintenance.PruneOptions) (maintenance.PruneStats, error) {
+	stats, err := sqc.prune(func(contents *maintenance.Contents) *maintenance.Contents {
+		return maintain.ToPrune(contents, options)
+	})
+	if err != nil {
+		return maintenance.PruneStats{}, err
+	}
+	if options.MaxSize > 0 {
+		sizeStats, err := maintain.PruneToSize(options.MaxSize, sqc.fileSize, func(keepFraction float64) (maintenance.PruneStats, error) {
+			return sqc.prune(func(contents *maintenance.Contents) *maintenance.Contents {
+				return maintain.ToPruneFraction(contents, keepFraction)
+			})
+		})
+		stats = maintain.AddStats(stats, sizeStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// fileSize returns the size of the database file.
+func (sqc *cache) fileSize() (int64, error) {
+	fi, err := os.Stat(sqc.path)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// prune removes the data returned by selectToPrune for the full contents of the cache, and returns statistics about the removed data.
+// If any data was removed, the database file is compacted.
+func (sqc *cache) prune(selectToPrune func(contents *maintenance.Contents) *maintenance.Contents) (_ maintenance.PruneStats, retErr error) {
+	stats, err := transaction(sqc, func(tx *sql.Tx) (maintenance.PruneStats, error) {
+		contents, err := sqc.contents(tx)
+		if err != nil {
+			return maintenance.PruneStats{}, err
+		}
+		toPrune := selectToPrune(contents)
+		for _, l := range toPrune.KnownLocations {
+			if _, err := tx.Exec("DELETE FROM KnownLocations WHERE transport = ? AND scope = ? AND digest = ? AND location = ?",
+				l.Transport, l.Scope, l.Digest.String(), l.Location); err != nil {
+				return maintenance.PruneStats{}, fmt.Errorf("deleting known location %q for (%q, %q, %q): %w", l.Location, l.Transport, l.Scope, l.Digest, err)
+			}
+		}
+		for _, p := range toPrune.UncompressedDigests {
+			if _, err := tx.Exec("DELETE FROM DigestUncompressedPairs WHERE anyDigest = ?", p.Digest.String()); err != nil {
+				return maintenance.PruneStats{}, fmt.Errorf("deleting uncompressed digest for %q: %w", p.Digest, err)
+			}
+		}
+		for _, p := range toPrune.TOCUncompressedDigests {
+			if _, err := tx.Exec("DELETE FROM DigestTOCUncompressedPairs WHERE tocDigest = ?", p.TOCDigest.String()); err != nil {
+				return maintenance.PruneStats{}, fmt.Errorf("deleting uncompressed digest for TOC %q: %w", p.TOCDigest, err)
+			}
+		}
+		for _, c := range toPrune.Compressors {
+			if _, err := tx.Exec("DELETE FROM DigestCompressors WHERE digest = ?", c.Digest.String()); err != nil {
+				return maintenance.PruneStats{}, fmt.Errorf("deleting compressor for %q: %w", c.Digest, err)
+			}
+			if _, err := tx.Exec("DELETE FROM DigestSpecificVariantCompressors WHERE digest = ?", c.Digest.String()); err != nil {
+				return maintenance.PruneStats{}, fmt.Errorf("deleting specific variant compressor for %q: %w", c.Digest, err)
+			}
+		}
+		return maintain.Stats(toPrune), nil
+	})
+	if err != nil {
+		return maintenance.PruneStats{}, err
+	}
+	if stats == (maintenance.PruneStats{}) {
+		return stats, nil
+	}
+
+	// SQLite does not shrink the file on DELETE; VACUUM can’t run inside a transaction.
+	db, closeDB, err := sqc.openDB()
+	if err != nil {
+		return maintenance.PruneStats{}, err
+	}
+	defer func() {
+		closeErr := closeDB()
+		if retErr == nil {
+			retErr = closeErr
+		}
+	}()
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return maintenance.PruneStats{}, fmt.Errorf("compacting blob info cache at %q: %w", sqc.path, err)
+	}
+	return stats, nil
+}
+
+// DigestInfo returns everything the cache knows about d.
+func (sqc *cache) DigestInfo(d digest.Digest) (*maintenance.DigestInfo, error) {
+	// This reads the whole database; that’s acceptable for a debugging aid, and it
+	// allows sharing the logic with other implementations.
+	contents, err := sqc.Export()
+	if err != nil {
+		return nil, err
+	}
+	return maintain.DigestInfo(contents, d), nil
+}
@@ -152,21 +152,24 @@ func (sqc *cache) Close() {
 
 type void struct{} // So that we don’t have to write struct{}{} all over the place
 
+// openDB returns a *sql.DB for sqc, and a function to call when the caller is done with it.
+func (sqc *cache) openDB() (*sql.DB, func() error, error) {
+	sqc.lock.Lock()
+	defer sqc.lock.Unlock()
+
+	if sqc.db != nil {
+		return sqc.db, func() error { return nil }, nil
+	}
+	db, err := rawOpen(sqc.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening blob info cache at %q: %w", sqc.path, err)
+	}
+	return db, db.Close, nil
+}
+
 // transaction calls fn within a read-write transaction in sqc.
 func transaction[T any](sqc *cache, fn func(tx *sql.Tx) (T, error)) (_ T, retErr error) {
-	db, closeDB, err := func() (*sql.DB, func() error, error) { // A scope for defer
-		sqc.lock.Lock()
-		defer sqc.lock.Unlock()
-
-		if sqc.db != nil {
-			return sqc.db, func() error { return nil }, nil
-		}
-		db, err := rawOpen(sqc.path)
-		if err != nil {
-			return nil, nil, fmt.Errorf("opening blob info cache at %q: %w", sqc.path, err)
-		}
-		return db, db.Close, nil
-	}()
+	db, closeDB, err := sqc.openDB()
 	if err != nil {
 		var zeroRes T // A zero value of T
 		return zeroRes, err
@@ -22,4 +22,8 @@ func TestNew(t *testing.T) {
 	test.GenericCache(t, newTestCache)
 }
 
+func TestMaintenance(t *testing.T) {
+	test.GenericMaintenance(t, newTestCache)
+}
+
 // FIXME: Tests for the various corner cases / failure cases of sqlite.cache should be added here.
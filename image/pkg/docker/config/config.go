@@ -52,6 +52,11 @@ var (
 	ErrNotSupported = errors.New("not supported")
 )
 
+// IdentityTokenUsername is a special username which, when passed to SetCredentials,
+// causes the password to be stored as an identity token (an OAuth2 refresh token).
+// This follows the convention used by Docker credential helpers.
+const IdentityTokenUsername = "<token>"
+
 // authPath combines a path to a file with container registry credentials,
 // along with expected properties of that path (currently just whether it's
 // legacy format or not).
@@ -189,18 +194,42 @@ func GetCredentialsForRef(sys *types.SystemContext, ref reference.Named) (types.
 	return getCredentialsWithHomeDir(sys, ref.Name(), homedir.Get())
 }
 
+// CredentialsLocation identifies the entry which contained credentials returned by GetCredentialsAndLocationForRef,
+// so that an identity token can be updated in exactly that entry using SetIdentityToken.
+// The zero value means that the credentials can’t be updated (e.g. if they were provided in
+// sys.DockerAuthConfig, or if none were found).
+type CredentialsLocation struct {
+	authFile authPath // If authFile.path != "", the auth file containing the entry.
+	key      string   // The key of the entry in authFile, exactly as found in the file; or the registry, if helper != "".
+	helper   string   // If not "", the credential helper containing the credentials for registry key.
+}
+
+// GetCredentialsAndLocationForRef is like GetCredentialsForRef, but it also returns
+// the location of the entry which contained the credentials.
+func GetCredentialsAndLocationForRef(sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, CredentialsLocation, error) {
+	return getCredentialsAndLocationWithHomeDir(sys, ref.Name(), homedir.Get())
+}
+
 // getCredentialsWithHomeDir is an internal implementation detail of
 // GetCredentialsForRef and GetCredentials. It exists only to allow testing it
 // with an artificial home directory.
 func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, error) {
+	creds, _, err := getCredentialsAndLocationWithHomeDir(sys, key, homeDir)
+	return creds, err
+}
+
+// getCredentialsAndLocationWithHomeDir is an internal implementation detail of
+// GetCredentialsAndLocationForRef and getCredentialsWithHomeDir. It exists only to allow testing it
+// with an artificial home directory.
+func getCredentialsAndLocationWithHomeDir(sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, CredentialsLocation, error) {
 	_, err := validateKey(key)
 	if err != nil {
-		return types.DockerAuthConfig{}, err
+		return types.DockerAuthConfig{}, CredentialsLocation{}, err
 	}
 
 	if sys != nil && sys.DockerAuthConfig != nil {
 		logrus.Debugf("Returning credentials for %s from DockerAuthConfig", key)
-		return *sys.DockerAuthConfig, nil
+		return *sys.DockerAuthConfig, CredentialsLocation{}, nil
 	}
 
 	var registry string // We compute this once because it is used in several places.
@@ -211,23 +240,23 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 	}
 
 	// Anonymous function to query credentials from auth files.
-	getCredentialsFromAuthFiles := func() (types.DockerAuthConfig, string, error) {
+	getCredentialsFromAuthFiles := func() (types.DockerAuthConfig, CredentialsLocation, string, error) {
 		for _, path := range getAuthFilePaths(sys, homeDir) {
-			creds, err := findCredentialsInFile(key, registry, path)
+			creds, location, err := findCredentialsInFile(key, registry, path)
 			if err != nil {
-				return types.DockerAuthConfig{}, "", err
+				return types.DockerAuthConfig{}, CredentialsLocation{}, "", err
 			}
 
 			if creds != (types.DockerAuthConfig{}) {
-				return creds, path.path, nil
+				return creds, location, path.path, nil
 			}
 		}
-		return types.DockerAuthConfig{}, "", nil
+		return types.DockerAuthConfig{}, CredentialsLocation{}, "", nil
 	}
 
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
-		return types.DockerAuthConfig{}, err
+		return types.DockerAuthConfig{}, CredentialsLocation{}, err
 	}
 
 	var multiErr []error
@@ -235,6 +264,7 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 		var (
 			creds          types.DockerAuthConfig
 			helperKey      string
+			location       CredentialsLocation
 			credHelperPath string
 			err            error
 		)
@@ -242,12 +272,13 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 		// Special-case the built-in helper for auth files.
 		case sysregistriesv2.AuthenticationFileHelper:
 			helperKey = key
-			creds, credHelperPath, err = getCredentialsFromAuthFiles()
+			creds, location, credHelperPath, err = getCredentialsFromAuthFiles()
 		// External helpers.
 		default:
 			// This intentionally uses "registry", not "key"; we don't support namespaced
 			// credentials in helpers, but a "registry" is a valid parent of "key".
 			helperKey = registry
+			location = CredentialsLocation{key: registry, helper: helper}
 			creds, err = getCredsFromCredHelper(helper, registry)
 		}
 		if err != nil {
@@ -261,15 +292,15 @@ func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (t
 				msg = fmt.Sprintf("%s in file %s", msg, credHelperPath)
 			}
 			logrus.Debug(msg)
-			return creds, nil
+			return creds, location, nil
 		}
 	}
 	if multiErr != nil {
-		return types.DockerAuthConfig{}, multierr.Format("errors looking up credentials:\n\t* ", "\nt* ", "\n", multiErr)
+		return types.DockerAuthConfig{}, CredentialsLocation{}, multierr.Format("errors looking up credentials:\n\t* ", "\nt* ", "\n", multiErr)
 	}
 
 	logrus.Debugf("No credentials for %s found", key)
-	return types.DockerAuthConfig{}, nil
+	return types.DockerAuthConfig{}, CredentialsLocation{}, nil
 }
 
 // GetAuthentication returns the registry credentials matching key, appropriate for
@@ -300,6 +331,7 @@ func getAuthenticationWithHomeDir(sys *types.SystemContext, key, homeDir string)
 
 // SetCredentials stores the username and password in a location
 // appropriate for sys and the users’ configuration.
+// If username is IdentityTokenUsername, password is stored as an identity token.
 // A valid key is a repository, a namespace within a registry, or a registry hostname;
 // using forms other than just a registry may fail depending on configuration.
 // Returns a human-readable description of the location that was updated.
@@ -330,9 +362,7 @@ func SetCredentials(sys *types.SystemContext, key, username, password string) (s
 					}
 					return false, desc, nil
 				}
-				creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-				newCreds := dockerAuthConfig{Auth: creds}
-				fileContents.AuthConfigs[key] = newCreds
+				fileContents.AuthConfigs[key] = newDockerAuthConfig(username, password)
 				return true, "", nil
 			})
 		// External helpers.
@@ -354,6 +384,47 @@ func SetCredentials(sys *types.SystemContext, key, username, password string) (s
 	return "", multierr.Format("Errors storing credentials\n\t* ", "\n\t* ", "\n", multiErr)
 }
 
+// SetIdentityToken records identityToken in the entry at location, as returned by GetCredentialsAndLocationForRef,
+// e.g. after the registry has rotated the previous token.
+// Returns a human-readable description of the location that was updated.
+// NOTE: The return value is only intended to be read by humans; its form is not an API,
+// it may change (or new forms can be added) any time.
+func SetIdentityToken(location CredentialsLocation, identityToken string) (string, error) {
+	switch {
+	case location.helper != "":
+		return setCredsInCredHelper(location.helper, location.key, IdentityTokenUsername, identityToken)
+	case location.authFile.path != "":
+		if location.authFile.legacyFormat {
+			return "", fmt.Errorf("writes to %s using legacy format are not supported", location.authFile.path)
+		}
+		return modifyConfigJSONAtPath(location.authFile.path, func(fileContents *dockerConfigFile) (bool, string, error) {
+			conf, ok := fileContents.AuthConfigs[location.key]
+			if !ok {
+				return false, "", fmt.Errorf("credentials for %s not found", location.key)
+			}
+			conf.IdentityToken = identityToken
+			fileContents.AuthConfigs[location.key] = conf
+			return true, "", nil
+		})
+	default:
+		return "", errors.New("credentials can't be updated")
+	}
+}
+
+// newDockerAuthConfig returns a dockerAuthConfig for username and password.
+// If username is IdentityTokenUsername, password is recorded as an identity token.
+func newDockerAuthConfig(username, password string) dockerAuthConfig {
+	if username == IdentityTokenUsername {
+		// Docker records an empty password along with the identity token; we don’t know the username,
+		// so record an empty one as well.
+		return dockerAuthConfig{
+			Auth:          base64.StdEncoding.EncodeToString([]byte(":")),
+			IdentityToken: password,
+		}
+	}
+	return dockerAuthConfig{Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password))}
+}
+
 func unsupportedNamespaceErr(helper string) error {
 	return fmt.Errorf("namespaced key is not supported for credential helper %s", helper)
 }
@@ -680,8 +751,16 @@ func modifyDockerConfigJSON(sys *types.SystemContext, editor func(fileContents *
 	if sys == nil || sys.DockerCompatAuthFilePath == "" {
 		return "", errors.New("internal error: modifyDockerConfigJSON called with DockerCompatAuthFilePath not set")
 	}
-	path := sys.DockerCompatAuthFilePath
+	return modifyConfigJSONAtPath(sys.DockerCompatAuthFilePath, editor)
+}
 
+// modifyConfigJSONAtPath calls editor on the contents of the non-legacy auth file or docker config.json at path,
+// and writes it back if editor returns true, preserving fields it does not understand.
+// Returns a human-readable description of the file, to be returned by SetCredentials.
+//
+// The editor may also return a human-readable description of the updated location; if it is "",
+// the file itself is used.
+func modifyConfigJSONAtPath(path string, editor func(fileContents *dockerConfigFile) (bool, string, error)) (string, error) {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return "", err
@@ -798,10 +877,11 @@ func deleteCredsFromCredHelper(credHelper, registry string) error {
 
 // findCredentialsInFile looks for credentials matching "key"
 // (which is "registry" or a namespace in "registry") in "path".
-func findCredentialsInFile(key, registry string, path authPath) (types.DockerAuthConfig, error) {
+// It returns the credentials, and the location they were found in.
+func findCredentialsInFile(key, registry string, path authPath) (types.DockerAuthConfig, CredentialsLocation, error) {
 	fileContents, err := path.parse()
 	if err != nil {
-		return types.DockerAuthConfig{}, fmt.Errorf("reading JSON file %q: %w", path.path, err)
+		return types.DockerAuthConfig{}, CredentialsLocation{}, fmt.Errorf("reading JSON file %q: %w", path.path, err)
 	}
 
 	// First try cred helpers. They should always be normalized.
@@ -809,7 +889,8 @@ func findCredentialsInFile(key, registry string, path authPath) (types.DockerAut
 	// credentials in helpers.
 	if ch, exists := fileContents.CredHelpers[registry]; exists {
 		logrus.Debugf("Looking up in credential helper %s based on credHelpers entry in %s", ch, path.path)
-		return getCredsFromCredHelper(ch, registry)
+		creds, err := getCredsFromCredHelper(ch, registry)
+		return creds, CredentialsLocation{key: registry, helper: ch}, err
 	}
 
 	// Support sub-registry namespaces in auth.
@@ -820,7 +901,8 @@ func findCredentialsInFile(key, registry string, path authPath) (types.DockerAut
 	// keys we prefer exact matches as well.
 	for key := range authKeyLookupOrder(key, registry, path.legacyFormat) {
 		if val, exists := fileContents.AuthConfigs[key]; exists {
-			return decodeDockerAuth(path.path, key, val)
+			creds, err := decodeDockerAuth(path.path, key, val)
+			return creds, CredentialsLocation{authFile: path, key: key}, err
 		}
 	}
 
@@ -835,14 +917,15 @@ func findCredentialsInFile(key, registry string, path authPath) (types.DockerAut
 	registry = normalizeRegistry(registry)
 	for k, v := range fileContents.AuthConfigs {
 		if normalizeAuthFileKey(k, path.legacyFormat) == registry {
-			return decodeDockerAuth(path.path, k, v)
+			creds, err := decodeDockerAuth(path.path, k, v)
+			return creds, CredentialsLocation{authFile: path, key: k}, err
 		}
 	}
 
 	// Only log this if we found nothing; getCredentialsWithHomeDir logs the
 	// source of found data.
 	logrus.Debugf("No credentials matching %s found in %s", key, path.path)
-	return types.DockerAuthConfig{}, nil
+	return types.DockerAuthConfig{}, CredentialsLocation{}, nil
 }
 
 // authKeyLookupOrder returns a sequence for lookup keys matching (key or registry)
@@ -871,6 +871,69 @@ func TestRemoveAuthentication(t *testing.T) {
 	}
 }
 
+// TestSetIdentityToken verifies that credentials can be updated using the location returned by GetCredentialsAndLocationForRef.
+func TestSetIdentityToken(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "auth.json")
+	err := os.WriteFile(tmpFile, []byte(`{"auths":{"quay.io/ns":{"auth":"dXNlcjpwYXNz"},"quay.io":{"auth":"b3RoZXI6cGFzcw=="}}}`), 0o600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{AuthFilePath: tmpFile}
+
+	ref, err := reference.ParseNamed("quay.io/ns/repo")
+	require.NoError(t, err)
+	auth, location, err := GetCredentialsAndLocationForRef(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "pass"}, auth)
+	assert.Equal(t, CredentialsLocation{authFile: newAuthPathDefault(tmpFile), key: "quay.io/ns"}, location)
+
+	_, err = SetIdentityToken(location, "identity")
+	require.NoError(t, err)
+	auth, location2, err := GetCredentialsAndLocationForRef(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "pass", IdentityToken: "identity"}, auth)
+	assert.Equal(t, location, location2)
+
+	// The other entry is not affected
+	auth, err = GetCredentials(sys, "quay.io")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "other", Password: "pass"}, auth)
+
+	// An entry with a legacy key, in a file which is not the default for writes, is updated in place.
+	dockerConfig := t.TempDir()
+	runtimeDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	legacyFile := filepath.Join(dockerConfig, "config.json")
+	err = os.WriteFile(legacyFile, []byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"Og==","identitytoken":"old"}},"other":"preserved"}`), 0o600)
+	require.NoError(t, err)
+	ref, err = reference.ParseNormalizedNamed("busybox")
+	require.NoError(t, err)
+	auth, location, err = getCredentialsAndLocationWithHomeDir(nil, ref.Name(), t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{IdentityToken: "old"}, auth)
+	_, err = SetIdentityToken(location, "new")
+	require.NoError(t, err)
+	contents, err := os.ReadFile(legacyFile)
+	require.NoError(t, err)
+	var raw map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(contents, &raw))
+	assert.JSONEq(t, `{"https://index.docker.io/v1/":{"auth":"Og==","identitytoken":"new"}}`, string(raw["auths"]))
+	assert.JSONEq(t, `"preserved"`, string(raw["other"]))
+	_, err = os.Stat(filepath.Join(runtimeDir, xdgRuntimeDirPath))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Credentials provided in SystemContext can't be updated
+	_, location, err = GetCredentialsAndLocationForRef(&types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{IdentityToken: "identity"}}, ref)
+	require.NoError(t, err)
+	assert.Equal(t, CredentialsLocation{}, location)
+	_, err = SetIdentityToken(location, "identity")
+	assert.Error(t, err)
+	// No credentials
+	_, location, err = GetCredentialsAndLocationForRef(&types.SystemContext{AuthFilePath: filepath.Join(t.TempDir(), "missing.json")}, ref)
+	require.NoError(t, err)
+	assert.Equal(t, CredentialsLocation{}, location)
+}
+
 // TestSetCredentialsInteroperability verifies that our config files can be consumed by Docker.
 func TestSetCredentialsInteroperability(t *testing.T) {
 	const testUser = "some-user"
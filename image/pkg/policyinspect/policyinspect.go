@@ -0,0 +1,139 @@
+// Package policyinspect explains which parts of the signature verification configuration
+// (policy.json and registries.d) apply to an image, without accessing the image,
+// and finds likely mistakes in that configuration.
+package policyinspect
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"go.podman.io/image/v5/docker"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports"
+	"go.podman.io/image/v5/types"
+)
+
+// LintSigstoreAttachmentsDisabled is a policy section requiring sigstore signatures for a registry
+// for which registries.d does not enable use-sigstore-attachments, so the signatures will not be found.
+const LintSigstoreAttachmentsDisabled signature.PolicyLintIssueKind = "sigstoreAttachmentsDisabled"
+
+// Report describes the configuration which applies to a single image.
+type Report struct {
+	// Image is the evaluated image reference, in the transport:reference format.
+	Image string `json:"image"`
+	// Scope identifies the policy section which applies to the image.
+	Scope signature.PolicyScopeMatch `json:"scope"`
+	// Requirements are the requirements of the policy section, in order.
+	Requirements signature.PolicyRequirements `json:"requirements"`
+	// Lookaside is the lookaside storage URL used to read signatures, from registries.d, if any.
+	// Only set for the docker transport.
+	Lookaside string `json:"lookaside,omitempty"`
+	// UseSigstoreAttachments is the use-sigstore-attachments value from registries.d.
+	// Only set for the docker transport.
+	UseSigstoreAttachments *bool `json:"useSigstoreAttachments,omitempty"`
+	// Issues are likely mistakes in the configuration relevant to the image.
+	Issues []signature.PolicyLintIssue `json:"issues"`
+}
+
+// Inspect returns a Report about the configuration which applies to ref.
+// The policy is loaded using signature.DefaultPolicy(sys), and registries.d is loaded
+// using sys, so sys.SignaturePolicyPath and sys.RegistriesDirPath can be used to inspect non-default configuration.
+func Inspect(sys *types.SystemContext, ref types.ImageReference) (*Report, error) {
+	policy, err := signature.DefaultPolicy(sys)
+	if err != nil {
+		return nil, err
+	}
+	return inspectWithPolicy(sys, policy, ref)
+}
+
+// inspectWithPolicy is Inspect, using an already loaded policy.
+func inspectWithPolicy(sys *types.SystemContext, policy *signature.Policy, ref types.ImageReference) (*Report, error) {
+	reqs, scope := policy.RequirementsForImageRef(ref)
+	report := &Report{
+		Image:        transports.ImageName(ref),
+		Scope:        scope,
+		Requirements: reqs,
+		Issues:       []signature.PolicyLintIssue{},
+	}
+	for _, issue := range signature.LintPolicy(policy) {
+		if issue.Scope == scope {
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	if ref.Transport().Name() == docker.Transport.Name() {
+		lookaside, err := docker.SignatureStorageBaseURL(sys, ref, false)
+		if err != nil {
+			return nil, err
+		}
+		if lookaside != nil {
+			report.Lookaside = lookaside.Redacted()
+		}
+		useAttachments, err := docker.SigstoreAttachmentsEnabled(sys, ref)
+		if err != nil {
+			return nil, err
+		}
+		report.UseSigstoreAttachments = &useAttachments
+		if !useAttachments {
+			report.Issues = append(report.Issues, sigstoreAttachmentsIssues(scope, reqs)...)
+		}
+	}
+	return report, nil
+}
+
+// Lint checks the policy loaded using signature.DefaultPolicy(sys), and the registries.d configuration
+// loaded using sys, for likely mistakes. See signature.LintPolicy for details.
+//
+// In addition, it reports docker scopes which require sigstore signatures without enabling
+// use-sigstore-attachments in registries.d. That check is only done for scopes which identify
+// a specific repository or namespace; wildcard and hostname-only scopes are not checked.
+func Lint(sys *types.SystemContext) ([]signature.PolicyLintIssue, error) {
+	policy, err := signature.DefaultPolicy(sys)
+	if err != nil {
+		return nil, err
+	}
+	return lintWithPolicy(sys, policy)
+}
+
+// lintWithPolicy is Lint, using an already loaded policy.
+func lintWithPolicy(sys *types.SystemContext, policy *signature.Policy) ([]signature.PolicyLintIssue, error) {
+	issues := signature.LintPolicy(policy)
+
+	dockerScopes := policy.Transports[docker.Transport.Name()]
+	for _, scopeName := range slices.Sorted(maps.Keys(dockerScopes)) {
+		if !strings.Contains(scopeName, "/") || strings.Contains(scopeName, "*") {
+			continue
+		}
+		ref, err := docker.ParseReference("//" + scopeName)
+		if err != nil {
+			continue // Not a valid reference, so registries.d lookups are not meaningful.
+		}
+		useAttachments, err := docker.SigstoreAttachmentsEnabled(sys, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !useAttachments {
+			scope := signature.PolicyScopeMatch{Transport: docker.Transport.Name(), Scope: scopeName}
+			issues = append(issues, sigstoreAttachmentsIssues(scope, dockerScopes[scopeName])...)
+		}
+	}
+	return issues, nil
+}
+
+// sigstoreAttachmentsIssues returns issues for all sigstoreSigned requirements in reqs, which apply to scope
+// where sigstore attachments are not enabled.
+func sigstoreAttachmentsIssues(scope signature.PolicyScopeMatch, reqs signature.PolicyRequirements) []signature.PolicyLintIssue {
+	issues := []signature.PolicyLintIssue{}
+	for i, req := range reqs {
+		if req.RequirementType() == "sigstoreSigned" {
+			issues = append(issues, signature.PolicyLintIssue{
+				Kind:        LintSigstoreAttachmentsDisabled,
+				Scope:       scope,
+				Requirement: i,
+				Message:     "requires sigstore signatures, but use-sigstore-attachments is not enabled in registries.d, so no signatures will be found",
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,125 @@
+package policyinspect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/signature"
+	"go.podman.io/image/v5/transports/alltransports"
+	"go.podman.io/image/v5/types"
+)
+
+// newTestSystemContext returns a SystemContext using policyJSON and a registries.d directory
+// containing a single registriesYAML file.
+func newTestSystemContext(t *testing.T, policyJSON, registriesYAML string) *types.SystemContext {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.json")
+	err := os.WriteFile(policyPath, []byte(policyJSON), 0o644)
+	require.NoError(t, err)
+	registriesDir := filepath.Join(dir, "registries.d")
+	err = os.Mkdir(registriesDir, 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(registriesDir, "test.yaml"), []byte(registriesYAML), 0o644)
+	require.NoError(t, err)
+	return &types.SystemContext{
+		SignaturePolicyPath: policyPath,
+		RegistriesDirPath:   registriesDir,
+	}
+}
+
+const testPolicy = `{
+	"default": [{"type": "reject"}],
+	"transports": {
+		"docker": {
+			"quay.io/signed": [{"type": "sigstoreSigned", "keyPath": "../../signature/fixtures/cosign.pub", "signedIdentity": {"type": "matchRepository"}}],
+			"quay.io/signed/insecure": [{"type": "insecureAcceptAnything"}],
+			"registry.example.com/attached": [{"type": "sigstoreSigned", "keyPath": "../../signature/fixtures/cosign.pub", "signedIdentity": {"type": "matchRepository"}}]
+		},
+		"dir": {
+			"": [{"type": "insecureAcceptAnything"}]
+		}
+	}
+}`
+
+const testRegistriesD = `docker:
+  quay.io:
+    lookaside: https://lookaside.example.com/quay
+  registry.example.com:
+    use-sigstore-attachments: true
+`
+
+func TestInspect(t *testing.T) {
+	sys := newTestSystemContext(t, testPolicy, testRegistriesD)
+
+	ref, err := alltransports.ParseImageName("docker://quay.io/signed/image:latest")
+	require.NoError(t, err)
+	report, err := Inspect(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, "docker://quay.io/signed/image:latest", report.Image)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "quay.io/signed"}, report.Scope)
+	assert.Len(t, report.Requirements, 1)
+	assert.Equal(t, "https://lookaside.example.com/quay/signed/image", report.Lookaside)
+	require.NotNil(t, report.UseSigstoreAttachments)
+	assert.False(t, *report.UseSigstoreAttachments)
+	require.Len(t, report.Issues, 1)
+	assert.Equal(t, LintSigstoreAttachmentsDisabled, report.Issues[0].Kind)
+	assert.Equal(t, 0, report.Issues[0].Requirement)
+
+	ref, err = alltransports.ParseImageName("docker://quay.io/signed/insecure:latest")
+	require.NoError(t, err)
+	report, err = Inspect(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "quay.io/signed/insecure"}, report.Scope)
+	require.Len(t, report.Issues, 1)
+	assert.Equal(t, signature.PolicyLintInsecureUnderSignedScope, report.Issues[0].Kind)
+
+	ref, err = alltransports.ParseImageName("docker://registry.example.com/attached:latest")
+	require.NoError(t, err)
+	report, err = Inspect(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "registry.example.com/attached"}, report.Scope)
+	assert.NotEmpty(t, report.Lookaside) // The built-in default, which depends on the user running the test
+	require.NotNil(t, report.UseSigstoreAttachments)
+	assert.True(t, *report.UseSigstoreAttachments)
+	assert.Empty(t, report.Issues)
+
+	ref, err = alltransports.ParseImageName("docker://docker.io/library/busybox:latest")
+	require.NoError(t, err)
+	report, err = Inspect(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, signature.PolicyScopeMatch{Default: true}, report.Scope)
+	assert.Empty(t, report.Issues)
+
+	// Non-docker transports don’t use registries.d
+	ref, err = alltransports.ParseImageName("dir:/var/lib/image")
+	require.NoError(t, err)
+	report, err = Inspect(sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "dir", Scope: ""}, report.Scope)
+	assert.Empty(t, report.Lookaside)
+	assert.Nil(t, report.UseSigstoreAttachments)
+
+	// Invalid policy
+	sys = newTestSystemContext(t, "this is not JSON", testRegistriesD)
+	_, err = Inspect(sys, ref)
+	assert.Error(t, err)
+}
+
+func TestLint(t *testing.T) {
+	sys := newTestSystemContext(t, testPolicy, testRegistriesD)
+	issues, err := Lint(sys)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	assert.Equal(t, signature.PolicyLintInsecureUnderSignedScope, issues[0].Kind)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "quay.io/signed/insecure"}, issues[0].Scope)
+	assert.Equal(t, LintSigstoreAttachmentsDisabled, issues[1].Kind)
+	assert.Equal(t, signature.PolicyScopeMatch{Transport: "docker", Scope: "quay.io/signed"}, issues[1].Scope)
+
+	// Invalid registries.d
+	sys = newTestSystemContext(t, testPolicy, "this: is: not: YAML")
+	_, err = Lint(sys)
+	assert.Error(t, err)
+}
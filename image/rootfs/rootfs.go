@@ -0,0 +1,363 @@
+// Package rootfs produces the root filesystem of an image, i.e. the result of applying all of its layers in order,
+// without using a graph driver, and without requiring any privileges.
+package rootfs
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/image"
+	"go.podman.io/image/v5/internal/set"
+	"go.podman.io/image/v5/internal/tmpdir"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/none"
+	"go.podman.io/image/v5/pkg/compression"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/idtools"
+)
+
+// Options allows supplying non-default configuration modifying the behavior of Tar and Extract.
+type Options struct {
+	// UIDMap and GIDMap, if not empty, map user and group IDs of files in the image (as seen inside a container)
+	// to the IDs recorded in the output (as seen on the host).
+	UIDMap []idtools.IDMap
+	GIDMap []idtools.IDMap
+}
+
+// Tar writes the root filesystem of the image in src to w, as a single uncompressed tar stream.
+// If instanceDigest is not nil, it selects an instance of a multi-platform image; otherwise, if the image is
+// a multi-platform image, an instance is chosen based on sys.
+// The output does not contain any whiteouts; files removed or replaced in upper layers are omitted.
+// Layers are downloaded to a temporary directory first, so that every layer is only read from src once.
+func Tar(ctx context.Context, sys *types.SystemContext, src types.ImageSource, instanceDigest *digest.Digest, w io.Writer, options *Options) error {
+	if options == nil {
+		options = &Options{}
+	}
+	layers, err := layerInfos(ctx, sys, src, instanceDigest)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := tmpdir.MkDirBigFileTemp(sys, "rootfs")
+	if err != nil {
+		return fmt.Errorf("creating temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	paths := make([]string, 0, len(layers))
+	for i, layer := range layers {
+		layerPath := filepath.Join(tmpDir, strconv.Itoa(i))
+		if err := fetchLayer(ctx, src, layer, layerPath); err != nil {
+			return err
+		}
+		paths = append(paths, layerPath)
+	}
+	selections, err := selectEntries(paths)
+	if err != nil {
+		return err
+	}
+
+	var idMappings *idtools.IDMappings
+	if len(options.UIDMap) != 0 || len(options.GIDMap) != 0 {
+		idMappings = idtools.NewIDMappingsFromMaps(options.UIDMap, options.GIDMap)
+	}
+	tw := tar.NewWriter(w)
+	for i, layerPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeSelectedEntries(tw, layerPath, selections[i], idMappings); err != nil {
+			return fmt.Errorf("writing contents of layer %s: %w", layers[i].Digest, err)
+		}
+	}
+	return tw.Close()
+}
+
+// Extract extracts the root filesystem of the image in src into dest, which is created if it does not exist.
+// instanceDigest and sys are used to choose an instance of a multi-platform image, as in Tar.
+// Without privileges, file ownership is not preserved, and device nodes are not created.
+func Extract(ctx context.Context, sys *types.SystemContext, src types.ImageSource, instanceDigest *digest.Digest, dest string, options *Options) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	tarErr := make(chan error, 1)
+	go func() {
+		err := Tar(ctx, sys, src, instanceDigest, pipeWriter, options)
+		pipeWriter.CloseWithError(err) // CloseWithError(nil) is equivalent to Close()
+		tarErr <- err
+	}()
+
+	unprivileged := os.Geteuid() != 0
+	err := archive.UntarUncompressed(pipeReader, dest, &archive.TarOptions{
+		IgnoreChownErrors: unprivileged,
+		InUserNS:          unprivileged,
+	})
+	if err == nil {
+		// Consume any padding after the end of the archive, so that Tar can finish writing.
+		_, err = io.Copy(io.Discard, pipeReader)
+	}
+	pipeReader.CloseWithError(err) // Unblocks Tar if we have failed
+	if err := <-tarErr; err != nil {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("extracting root filesystem to %q: %w", dest, err)
+	}
+	return nil
+}
+
+// layerInfos returns the layers of the image in src, choosing an instance of a multi-platform image if necessary.
+func layerInfos(ctx context.Context, sys *types.SystemContext, src types.ImageSource, instanceDigest *digest.Digest) ([]types.BlobInfo, error) {
+	unparsed := image.UnparsedInstance(src, instanceDigest)
+	manifestBlob, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		if instanceDigest != nil {
+			return nil, fmt.Errorf("instance %s is itself a multi-platform image", instanceDigest.String())
+		}
+		list, err := manifest.ListFromBlob(manifestBlob, mimeType)
+		if err != nil {
+			return nil, fmt.Errorf("parsing primary manifest as list: %w", err)
+		}
+		instance, err := list.ChooseInstance(sys)
+		if err != nil {
+			return nil, fmt.Errorf("choosing an image from manifest list: %w", err)
+		}
+		logrus.Debugf("Using instance %s of a multi-platform image", instance.String())
+		unparsed = image.UnparsedInstance(src, &instance)
+	}
+	img, err := image.FromUnparsedImage(ctx, sys, unparsed)
+	if err != nil {
+		return nil, err
+	}
+	return img.LayerInfos(), nil
+}
+
+// fetchLayer reads the (possibly compressed) layer described by layer from src, verifies its digest,
+// and stores its uncompressed contents at dest.
+func fetchLayer(ctx context.Context, src types.ImageSource, layer types.BlobInfo, dest string) (retErr error) {
+	if err := layer.Digest.Validate(); err != nil { // digest.Digest.Verifier() panics on failure, so validate explicitly.
+		return fmt.Errorf("invalid layer digest %q: %w", layer.Digest.String(), err)
+	}
+	stream, _, err := src.GetBlob(ctx, layer, none.NoCache)
+	if err != nil {
+		return fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+	}
+	defer stream.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	logrus.Debugf("Downloading layer %s", layer.Digest)
+	verifier := layer.Digest.Verifier()
+	verified := io.TeeReader(stream, verifier)
+	uncompressed, _, err := compression.AutoDecompress(verified)
+	if err != nil {
+		return fmt.Errorf("auto-decompressing layer %s: %w", layer.Digest, err)
+	}
+	defer uncompressed.Close()
+	// TODO: This can take quite some time, and should ideally be cancellable using ctx.Done().
+	if _, err := io.Copy(f, uncompressed); err != nil {
+		return fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+	}
+	// The decompressor might not have consumed all of the input.
+	if _, err := io.Copy(io.Discard, verified); err != nil {
+		return fmt.Errorf("reading layer %s: %w", layer.Digest, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("layer %s does not match its digest", layer.Digest)
+	}
+	return nil
+}
+
+// layerSelection describes the entries of a layer which are a part of the root filesystem.
+type layerSelection struct {
+	visible *set.Set[string] // Names (as returned by entryName) of entries which are not removed or replaced by upper layers
+	// replacedTargets maps names of hard link targets which are removed or replaced by upper layers, but which are still
+	// referenced by visible hard links, to the name of one of those links, which is written instead of the target.
+	replacedTargets map[string]string
+}
+
+// entryName returns a canonical form of name, a path in a tar archive, which can not refer outside of the root directory.
+// The root directory itself is ".".
+func entryName(name string) string {
+	res := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if res == "" {
+		return "."
+	}
+	return res
+}
+
+// selectEntries reads the uncompressed layers at paths, ordered from the base layer to the top layer,
+// and returns a layerSelection for each of them.
+func selectEntries(paths []string) ([]layerSelection, error) {
+	hidden := set.New[string]()      // Entries removed or replaced by upper layers
+	hiddenBelow := set.New[string]() // Entries whose contents from lower layers are removed or replaced by upper layers
+	isHidden := func(name string) bool {
+		if hidden.Contains(name) {
+			return true
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if hiddenBelow.Contains(dir) {
+				return true
+			}
+		}
+		return hiddenBelow.Contains(".") && name != "."
+	}
+
+	res := make([]layerSelection, len(paths))
+	for i := len(paths) - 1; i >= 0; i-- {
+		selection := layerSelection{
+			visible:         set.New[string](),
+			replacedTargets: map[string]string{},
+		}
+		// Whiteouts and entries only affect lower layers, so collect them separately until the whole layer is processed.
+		layerHidden := set.New[string]()
+		layerHiddenBelow := set.New[string]()
+		layerEntries := set.New[string]()
+		visibleLinks := map[string]string{} // Name → target
+		if err := readLayerHeaders(paths[i], func(h *tar.Header) error {
+			name := entryName(h.Name)
+			dir, base := path.Dir(name), path.Base(name)
+			switch {
+			case base == archive.WhiteoutOpaqueDir:
+				layerHiddenBelow.Add(dir)
+				return nil
+			case strings.HasPrefix(base, archive.WhiteoutMetaPrefix):
+				return nil // Other AUFS metadata, ignored as in storage/pkg/archive.UnpackLayer
+			case strings.HasPrefix(base, archive.WhiteoutPrefix):
+				removed := path.Join(dir, strings.TrimPrefix(base, archive.WhiteoutPrefix))
+				layerHidden.Add(removed)
+				layerHiddenBelow.Add(removed)
+				return nil
+			}
+			layerEntries.Add(name)
+			if isHidden(name) {
+				return nil
+			}
+			selection.visible.Add(name)
+			layerHidden.Add(name)
+			if h.Typeflag != tar.TypeDir {
+				layerHiddenBelow.Add(name)
+			}
+			if h.Typeflag == tar.TypeLink {
+				visibleLinks[name] = entryName(h.Linkname)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		for name, target := range visibleLinks {
+			if selection.visible.Contains(target) {
+				continue
+			}
+			if !layerEntries.Contains(target) {
+				return nil, fmt.Errorf("hard link %q refers to %q, which is not a part of the same layer", name, target)
+			}
+			if existing, ok := selection.replacedTargets[target]; !ok || name < existing { // Choose deterministically
+				selection.replacedTargets[target] = name
+			}
+		}
+		hidden.AddSeq(layerHidden.All())
+		hiddenBelow.AddSeq(layerHiddenBelow.All())
+		res[i] = selection
+	}
+	return res, nil
+}
+
+// readLayerHeaders calls fn for every entry of the uncompressed layer at layerPath.
+func readLayerHeaders(layerPath string, fn func(h *tar.Header) error) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+}
+
+// writeSelectedEntries writes the entries of the uncompressed layer at layerPath, as described by selection, to tw,
+// mapping user and group IDs using idMappings, if not nil.
+func writeSelectedEntries(tw *tar.Writer, layerPath string, selection layerSelection, idMappings *idtools.IDMappings) error {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := entryName(h.Name)
+		if replacement, ok := selection.replacedTargets[name]; ok && !selection.visible.Contains(name) {
+			h.Name = replacement
+		} else {
+			if !selection.visible.Contains(name) {
+				continue
+			}
+			h.Name = name
+			if h.Typeflag == tar.TypeLink {
+				target := entryName(h.Linkname)
+				if replacement, ok := selection.replacedTargets[target]; ok {
+					if replacement == name {
+						continue // Already written instead of the target
+					}
+					target = replacement
+				}
+				h.Linkname = target
+			}
+		}
+		if h.Typeflag == tar.TypeDir && !strings.HasSuffix(h.Name, "/") {
+			h.Name += "/"
+		}
+		if idMappings != nil {
+			pair, err := idMappings.ToHost(idtools.IDPair{UID: h.Uid, GID: h.Gid})
+			if err != nil {
+				return fmt.Errorf("mapping IDs of %q: %w", h.Name, err)
+			}
+			h.Uid, h.Gid = pair.UID, pair.GID
+			// The names may refer to different IDs on the consumer’s system.
+			h.Uname, h.Gname = "", ""
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
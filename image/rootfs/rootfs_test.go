@@ -0,0 +1,153 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/tarball"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/idtools"
+)
+
+// testImageSource returns an image source with two layers, exercising whiteouts, opaque directories and hard links.
+func testImageSource(t *testing.T) types.ImageSource {
+	dir := t.TempDir()
+	layers := [][]*tar.Header{
+		{
+			{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0o755},
+			{Typeflag: tar.TypeReg, Name: "dir/kept", Mode: 0o644, Size: int64(len("kept")), Uid: 1000, Gid: 1000},
+			{Typeflag: tar.TypeReg, Name: "dir/removed", Mode: 0o644, Size: int64(len("removed"))},
+			{Typeflag: tar.TypeDir, Name: "opaque/", Mode: 0o755},
+			{Typeflag: tar.TypeReg, Name: "opaque/hidden", Mode: 0o644, Size: int64(len("hidden"))},
+			{Typeflag: tar.TypeDir, Name: "links/", Mode: 0o755},
+			{Typeflag: tar.TypeReg, Name: "links/target", Mode: 0o644, Size: int64(len("linked"))},
+			{Typeflag: tar.TypeLink, Name: "links/b", Linkname: "links/target"},
+			{Typeflag: tar.TypeLink, Name: "links/a", Linkname: "links/target"},
+		},
+		{
+			{Typeflag: tar.TypeReg, Name: "dir/.wh.removed"},
+			{Typeflag: tar.TypeReg, Name: "dir/kept", Mode: 0o644, Size: int64(len("replaced")), Uid: 1000, Gid: 1000},
+			{Typeflag: tar.TypeDir, Name: "opaque/", Mode: 0o700},
+			{Typeflag: tar.TypeReg, Name: "opaque/.wh..wh..opq"},
+			{Typeflag: tar.TypeReg, Name: "opaque/new", Mode: 0o644, Size: int64(len("new"))},
+			{Typeflag: tar.TypeReg, Name: "links/.wh.target"},
+		},
+	}
+	contents := map[string]string{
+		"dir/kept": "kept", "dir/removed": "removed", "opaque/hidden": "hidden", "links/target": "linked", "opaque/new": "new",
+	}
+	filenames := []string{}
+	for i, headers := range layers {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, h := range headers {
+			require.NoError(t, tw.WriteHeader(h))
+			c := contents[h.Name]
+			if i == 1 && h.Name == "dir/kept" {
+				c = "replaced"
+			}
+			if h.Size != 0 {
+				_, err := tw.Write([]byte(c))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, tw.Close())
+		filename := filepath.Join(dir, "layer"+strconv.Itoa(i)+".tar")
+		require.NoError(t, os.WriteFile(filename, buf.Bytes(), 0o644))
+		filenames = append(filenames, filename)
+	}
+	ref, err := tarball.NewReference(filenames, nil)
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { src.Close() })
+	return src
+}
+
+type testEntry struct {
+	typeflag byte
+	linkname string
+	contents string
+	uid      int
+	mode     int64
+}
+
+func TestTar(t *testing.T) {
+	src := testImageSource(t)
+	for _, c := range []struct {
+		options *Options
+		rootUID int
+		uid     int
+	}{
+		{nil, 0, 1000},
+		{&Options{
+			UIDMap: []idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			GIDMap: []idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		}, 100000, 101000},
+	} {
+		var buf bytes.Buffer
+		err := Tar(context.Background(), nil, src, nil, &buf, c.options)
+		require.NoError(t, err)
+
+		names := []string{}
+		entries := map[string]testEntry{}
+		tr := tar.NewReader(&buf)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			contents, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			names = append(names, h.Name)
+			entries[h.Name] = testEntry{h.Typeflag, h.Linkname, string(contents), h.Uid, h.Mode}
+		}
+		assert.Equal(t, []string{"dir/", "links/", "links/a", "links/b", "dir/kept", "opaque/", "opaque/new"}, names)
+		assert.Equal(t, testEntry{typeflag: tar.TypeReg, contents: "linked", uid: c.rootUID, mode: 0o644}, entries["links/a"])
+		assert.Equal(t, testEntry{typeflag: tar.TypeLink, linkname: "links/a", uid: c.rootUID}, entries["links/b"])
+		assert.Equal(t, testEntry{typeflag: tar.TypeReg, contents: "replaced", uid: c.uid, mode: 0o644}, entries["dir/kept"])
+		assert.Equal(t, int64(0o700), entries["opaque/"].mode)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	src := testImageSource(t)
+	dest := filepath.Join(t.TempDir(), "rootfs")
+	err := Extract(context.Background(), nil, src, nil, dest, nil)
+	require.NoError(t, err)
+
+	for path, expected := range map[string]string{
+		"dir/kept":   "replaced",
+		"opaque/new": "new",
+		"links/a":    "linked",
+		"links/b":    "linked",
+	} {
+		contents, err := os.ReadFile(filepath.Join(dest, path))
+		require.NoError(t, err, path)
+		assert.Equal(t, expected, string(contents), path)
+	}
+	for _, path := range []string{"dir/removed", "dir/.wh.removed", "opaque/hidden", "opaque/.wh..wh..opq", "links/target"} {
+		_, err := os.Lstat(filepath.Join(dest, path))
+		assert.ErrorIs(t, err, os.ErrNotExist, path)
+	}
+	a, err := os.Stat(filepath.Join(dest, "links/a"))
+	require.NoError(t, err)
+	b, err := os.Stat(filepath.Join(dest, "links/b"))
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(a, b))
+	if os.Geteuid() == 0 {
+		fi, err := os.Stat(filepath.Join(dest, "dir/kept"))
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1000), fi.Sys().(*syscall.Stat_t).Uid)
+	}
+}
@@ -0,0 +1,249 @@
+package sif
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/sylabs/sif/v2/pkg/sif"
+	"go.podman.io/image/v5/internal/imagedestination/impl"
+	"go.podman.io/image/v5/internal/imagedestination/stagedblobs"
+	"go.podman.io/image/v5/internal/imagedestination/stubs"
+	"go.podman.io/image/v5/internal/iolimits"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/internal/tmpdir"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/types"
+)
+
+// launchScript is the launch script conventionally used in SIF files created by Singularity.
+const launchScript = "#!/usr/bin/env run-singularity\n"
+
+type sifImageDestination struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	stubs.IgnoresOriginalOCIConfig
+	stubs.NoPutBlobPartialInitialize
+	stubs.NoSignaturesInitialize
+
+	ref      sifReference
+	workDir  string
+	blobs    *stagedblobs.Dir // Stored in workDir
+	manifest []byte           // or nil if not yet known
+}
+
+// newImageDestination returns an ImageDestination for writing a SIF file.
+// Blobs are stored in a temporary directory until the image is flattened and written to the SIF file on Commit.
+func newImageDestination(sys *types.SystemContext, ref sifReference) (private.ImageDestination, error) {
+	workDir, err := tmpdir.MkDirBigFileTemp(sys, "sif")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp directory: %w", err)
+	}
+	d := &sifImageDestination{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			SupportedManifestMIMETypes:     []string{imgspecv1.MediaTypeImageManifest, manifest.DockerV2Schema2MediaType},
+			DesiredLayerCompression:        types.PreserveOriginal,
+			AcceptsForeignLayerURLs:        false,
+			MustMatchRuntimeOS:             false,
+			IgnoresEmbeddedDockerReference: false, // N/A, DockerReference() returns nil.
+			HasThreadSafePutBlob:           true,
+		}),
+		NoPutBlobPartialInitialize: stubs.NoPutBlobPartial(ref),
+		NoSignaturesInitialize:     stubs.NoSignatures("Storing signatures for SIF files is not supported"),
+
+		ref:     ref,
+		workDir: workDir,
+		blobs:   stagedblobs.New(workDir),
+	}
+	d.Compat = impl.AddCompat(d)
+	return d, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *sifImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *sifImageDestination) Close() error {
+	return os.RemoveAll(d.workDir)
+}
+
+// PutBlobWithOptions writes contents of stream and returns data representing the result.
+// inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
+// inputInfo.Size is the expected length of stream, if known.
+// inputInfo.MediaType describes the blob format, if known.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlobWithOptions MUST 1) fail, and 2) delete any data stored so far.
+func (d *sifImageDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, options private.PutBlobOptions) (private.UploadedBlob, error) {
+	return d.blobs.PutBlob(ctx, stream, inputInfo)
+}
+
+// TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If the blob has been successfully reused, returns (true, info, nil).
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+func (d *sifImageDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, private.ReusedBlob, error) {
+	return d.blobs.TryReusingBlob(info, options)
+}
+
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when
+// the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated
+// by `manifest.Digest()`.
+// FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
+// If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
+// but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
+func (d *sifImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return errors.New(`Manifest lists are not supported by "sif:"`)
+	}
+	mimeType := manifest.GuessMIMEType(m)
+	if mimeType != imgspecv1.MediaTypeImageManifest && mimeType != manifest.DockerV2Schema2MediaType {
+		return types.ManifestTypeRejectedError{Err: fmt.Errorf("manifest type %q is not supported by the sif transport", mimeType)}
+	}
+	d.manifest = m
+	return nil
+}
+
+// CommitWithOptions marks the process of storing the image as successful and asks for the image to be persisted.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before CommitWithOptions() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without CommitWithOptions() (i.e. rollback is allowed but not guaranteed)
+func (d *sifImageDestination) CommitWithOptions(ctx context.Context, options private.CommitOptions) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: CommitWithOptions called without PutManifest")
+	}
+	m, err := manifest.FromBlob(d.manifest, manifest.GuessMIMEType(d.manifest))
+	if err != nil {
+		return fmt.Errorf("parsing manifest: %w", err)
+	}
+	config, err := d.ociConfig(m.ConfigInfo())
+	if err != nil {
+		return err
+	}
+
+	rootfsPath := filepath.Join(d.workDir, "rootfs")
+	if err := os.Mkdir(rootfsPath, 0o755); err != nil {
+		return err
+	}
+	for _, layer := range m.LayerInfos() {
+		if layer.EmptyLayer {
+			continue
+		}
+		if err := d.blobs.ApplyLayer(rootfsPath, layer.BlobInfo); err != nil {
+			return err
+		}
+	}
+	squashFSPath := filepath.Join(d.workDir, "rootfs.squashfs")
+	if err := createSquashFS(ctx, squashFSPath, rootfsPath); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(rootfsPath); err != nil {
+		return err
+	}
+	return writeSIF(d.ref.file, squashFSPath, config)
+}
+
+// ociConfig returns the image config described by configInfo, converted to the OCI format, along with its architecture.
+func (d *sifImageDestination) ociConfig(configInfo types.BlobInfo) (*imgspecv1.Image, error) {
+	if configInfo.Digest == "" {
+		return nil, errors.New("images without a config are not supported by the sif transport")
+	}
+	path, err := d.blobs.BlobPath(configInfo.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening config %s: %w", configInfo.Digest, err)
+	}
+	defer f.Close()
+	configBytes, err := iolimits.ReadAtMost(f, iolimits.MaxConfigBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", configInfo.Digest, err)
+	}
+	// The Docker schema2 config format is, for our purposes, a superset of the OCI format.
+	config := imgspecv1.Image{}
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", configInfo.Digest, err)
+	}
+	return &config, nil
+}
+
+// createSquashFS creates a squashfs image at squashFSPath with the contents of rootfsPath.
+func createSquashFS(ctx context.Context, squashFSPath, rootfsPath string) error {
+	args := []string{rootfsPath, squashFSPath, "-noappend", "-no-progress"}
+	if os.Geteuid() != 0 {
+		// The extracted files are owned by the current user; record them as owned by root, as in the original image.
+		args = append(args, "-all-root")
+	}
+	logrus.Debugf("Creating a squashfs image, command: mksquashfs %v ...", args)
+	cmd := exec.CommandContext(ctx, "mksquashfs", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("creating squashfs image: %w, output: %s", err, string(output))
+	}
+	logrus.Debugf("... finished creating a squashfs image")
+	return nil
+}
+
+// writeSIF writes a SIF file to path, with the squashfs image at squashFSPath as the primary partition,
+// and config as an OCI blob.
+func writeSIF(path, squashFSPath string, config *imgspecv1.Image) error {
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	squashFS, err := os.Open(squashFSPath)
+	if err != nil {
+		return err
+	}
+	defer squashFS.Close()
+
+	partition, err := sif.NewDescriptorInput(sif.DataPartition, squashFS,
+		sif.OptPartitionMetadata(sif.FsSquash, sif.PartPrimSys, config.Architecture))
+	if err != nil {
+		return err
+	}
+	configDescriptor, err := sif.NewDescriptorInput(sif.DataOCIBlob, bytes.NewReader(configBytes))
+	if err != nil {
+		return err
+	}
+	opts := []sif.CreateOpt{
+		sif.OptCreateWithLaunchScript(launchScript),
+		sif.OptCreateWithDescriptors(partition, configDescriptor),
+	}
+	if config.Created != nil {
+		opts = append(opts, sif.OptCreateWithTime(*config.Created))
+	}
+
+	// Write to a temporary file first, so that a failure does not leave a partial file at path.
+	tmpPath := path + ".tmp"
+	sifImage, err := sif.CreateContainerAtPath(tmpPath, opts...)
+	if err != nil {
+		return fmt.Errorf("creating SIF file: %w", err)
+	}
+	if err := sifImage.UnloadContainer(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing SIF file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
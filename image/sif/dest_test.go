@@ -0,0 +1,185 @@
+package sif
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sylabs/sif/v2/pkg/sif"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/types"
+)
+
+type testTarEntry struct {
+	name     string
+	typeflag byte
+	contents string
+}
+
+// makeTestLayer returns an uncompressed layer tarball with entries.
+func makeTestLayer(t *testing.T, entries []testTarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		mode := int64(0o644)
+		if e.typeflag == tar.TypeDir {
+			mode = 0o755
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: e.typeflag, Name: e.name, Mode: mode, Size: int64(len(e.contents))}))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// putTestImage writes an image with two layers to dest, and returns the config.
+func putTestImage(t *testing.T, dest types.ImageDestination) imgspecv1.Image {
+	ctx := context.Background()
+	cache := memory.New()
+	layers := [][]byte{
+		makeTestLayer(t, []testTarEntry{
+			{"dir/", tar.TypeDir, ""},
+			{"dir/kept", tar.TypeReg, "kept"},
+			{"dir/removed", tar.TypeReg, "removed"},
+			{"opaque/", tar.TypeDir, ""},
+			{"opaque/hidden", tar.TypeReg, "hidden"},
+		}),
+		makeTestLayer(t, []testTarEntry{
+			{"dir/.wh.removed", tar.TypeReg, ""},
+			{"opaque/", tar.TypeDir, ""},
+			{"opaque/.wh..wh..opq", tar.TypeReg, ""},
+			{"opaque/new", tar.TypeReg, "new"},
+		}),
+	}
+	config := imgspecv1.Image{
+		Platform: imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+		Config:   imgspecv1.ImageConfig{Cmd: []string{"/bin/sh"}, Env: []string{"A=B"}},
+		RootFS:   imgspecv1.RootFS{Type: "layers"},
+	}
+	layerDescriptors := []imgspecv1.Descriptor{}
+	for _, layer := range layers {
+		info, err := dest.PutBlob(ctx, bytes.NewReader(layer), types.BlobInfo{Size: int64(len(layer))}, cache, false)
+		require.NoError(t, err)
+		layerDescriptors = append(layerDescriptors, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: info.Digest, Size: info.Size})
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, info.Digest)
+	}
+	configBytes, err := json.Marshal(config)
+	require.NoError(t, err)
+	configInfo, err := dest.PutBlob(ctx, bytes.NewReader(configBytes), types.BlobInfo{Size: int64(len(configBytes))}, cache, true)
+	require.NoError(t, err)
+	manifestBytes, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configInfo.Digest,
+		Size:      configInfo.Size,
+	}, layerDescriptors).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(ctx, manifestBytes, nil))
+	return config
+}
+
+func TestDestinationApplyLayer(t *testing.T) {
+	ref, _ := refToTempFile(t)
+	rawDest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer rawDest.Close()
+	dest, ok := rawDest.(*sifImageDestination)
+	require.True(t, ok)
+	putTestImage(t, dest)
+
+	m, err := manifest.FromBlob(dest.manifest, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	config, err := dest.ociConfig(m.ConfigInfo())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh"}, config.Config.Cmd)
+	rootfs := t.TempDir()
+	for _, layer := range m.LayerInfos() {
+		require.NoError(t, dest.blobs.ApplyLayer(rootfs, layer.BlobInfo))
+	}
+
+	contents, err := os.ReadFile(filepath.Join(rootfs, "dir/kept"))
+	require.NoError(t, err)
+	assert.Equal(t, "kept", string(contents))
+	contents, err = os.ReadFile(filepath.Join(rootfs, "opaque/new"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(contents))
+	for _, removed := range []string{"dir/removed", "dir/.wh.removed", "opaque/hidden", "opaque/.wh..wh..opq"} {
+		_, err := os.Lstat(filepath.Join(rootfs, removed))
+		assert.ErrorIs(t, err, os.ErrNotExist, removed)
+	}
+}
+
+func TestDestinationCommit(t *testing.T) {
+	if _, err := exec.LookPath("mksquashfs"); err != nil {
+		t.Skip("mksquashfs is not available")
+	}
+	ref, path := refToTempFile(t)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	config := putTestImage(t, dest)
+	require.NoError(t, dest.Commit(context.Background(), nil))
+
+	sifImage, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	require.NoError(t, err)
+	defer func() {
+		_ = sifImage.UnloadContainer()
+	}()
+	assert.Equal(t, "amd64", sifImage.PrimaryArch())
+	partition, err := sifImage.GetDescriptor(sif.WithPartitionType(sif.PartPrimSys))
+	require.NoError(t, err)
+	fsType, _, _, err := partition.PartitionMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, sif.FsSquash, fsType)
+	configDescriptor, err := sifImage.GetDescriptor(sif.WithDataType(sif.DataOCIBlob))
+	require.NoError(t, err)
+	configBytes, err := configDescriptor.GetData()
+	require.NoError(t, err)
+	var storedConfig imgspecv1.Image
+	require.NoError(t, json.Unmarshal(configBytes, &storedConfig))
+	assert.Equal(t, config, storedConfig)
+	h, err := configDescriptor.OCIBlobDigest()
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(configBytes).Encoded(), h.Hex)
+}
+
+func TestWriteSIF(t *testing.T) {
+	dir := t.TempDir()
+	squashFSPath := filepath.Join(dir, "rootfs.squashfs")
+	require.NoError(t, os.WriteFile(squashFSPath, []byte("not really a squashfs image"), 0o600))
+	config := &imgspecv1.Image{Platform: imgspecv1.Platform{Architecture: "arm64", OS: "linux"}}
+	path := filepath.Join(dir, "out.sif")
+	require.NoError(t, writeSIF(path, squashFSPath, config))
+
+	sifImage, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	require.NoError(t, err)
+	defer func() {
+		_ = sifImage.UnloadContainer()
+	}()
+	assert.Equal(t, "arm64", sifImage.PrimaryArch())
+	assert.Equal(t, launchScript, sifImage.LaunchScript())
+	partition, err := sifImage.GetDescriptor(sif.WithPartitionType(sif.PartPrimSys))
+	require.NoError(t, err)
+	data, err := partition.GetData()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("not really a squashfs image"), data)
+	configDescriptor, err := sifImage.GetDescriptor(sif.WithDataType(sif.DataOCIBlob))
+	require.NoError(t, err)
+	configBytes, err := configDescriptor.GetData()
+	require.NoError(t, err)
+	var storedConfig imgspecv1.Image
+	require.NoError(t, json.Unmarshal(configBytes, &storedConfig))
+	assert.Equal(t, *config, storedConfig)
+	_, err = os.Stat(path + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
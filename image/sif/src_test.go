@@ -2,4 +2,7 @@ package sif
 
 import "go.podman.io/image/v5/internal/private"
 
-var _ private.ImageSource = (*sifImageSource)(nil)
+var (
+	_ private.ImageSource      = (*sifImageSource)(nil)
+	_ private.ImageDestination = (*sifImageDestination)(nil)
+)
@@ -0,0 +1,281 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// UntrustedRekorInclusionProof is a parsed content of the sigstore-signature Rekor inclusion proof annotation.
+// This corresponds to github.com/sigstore/rekor/pkg/generated/models.InclusionProof, but we impose a stricter decoder.
+type UntrustedRekorInclusionProof struct {
+	LogIndex   int64    // The index of the entry within the tree; this may differ from the log index in the SET for sharded logs.
+	RootHash   string   // Hex-encoded
+	TreeSize   int64    // The size of the tree at the time the proof was created.
+	Hashes     []string // Hex-encoded, ordered from the leaf to the root
+	Checkpoint string   // A signed note, containing the tree size and root hash
+}
+
+// A compile-time check that UntrustedRekorInclusionProof implements json.Unmarshaler
+var _ json.Unmarshaler = (*UntrustedRekorInclusionProof)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (p *UntrustedRekorInclusionProof) UnmarshalJSON(data []byte) error {
+	return JSONFormatToInvalidSignatureError(p.strictUnmarshalJSON(data))
+}
+
+// strictUnmarshalJSON is UnmarshalJSON, except that it may return the internal JSONFormatError error type.
+// Splitting it into a separate function allows us to do the JSONFormatError → InvalidSignatureError in a single place, the caller.
+func (p *UntrustedRekorInclusionProof) strictUnmarshalJSON(data []byte) error {
+	return ParanoidUnmarshalJSONObjectExactFields(data, map[string]any{
+		"logIndex":   &p.LogIndex,
+		"rootHash":   &p.RootHash,
+		"treeSize":   &p.TreeSize,
+		"hashes":     &p.Hashes,
+		"checkpoint": &p.Checkpoint,
+	})
+}
+
+// A compile-time check that UntrustedRekorInclusionProof and *UntrustedRekorInclusionProof implements json.Marshaler
+var (
+	_ json.Marshaler = UntrustedRekorInclusionProof{}
+	_ json.Marshaler = (*UntrustedRekorInclusionProof)(nil)
+)
+
+// MarshalJSON implements the json.Marshaler interface.
+func (p UntrustedRekorInclusionProof) MarshalJSON() ([]byte, error) {
+	hashes := p.Hashes
+	if hashes == nil {
+		hashes = []string{} // A single-entry tree has an empty proof; the field must still be present.
+	}
+	return json.Marshal(map[string]any{
+		"logIndex":   p.LogIndex,
+		"rootHash":   p.RootHash,
+		"treeSize":   p.TreeSize,
+		"hashes":     hashes,
+		"checkpoint": p.Checkpoint,
+	})
+}
+
+// VerifyRekorInclusionProof verifies that unverifiedInclusionProof proves that the entry recorded in unverifiedRekorSET
+// is included in a Rekor log, at a tree state recorded by a checkpoint signed by one of publicKeys,
+// with an origin matching expectedOrigin (see rekorCheckpointOriginMatches).
+//
+// This only uses data provided by the caller, it does not contact the Rekor server.
+// The caller is expected to have also verified unverifiedRekorSET using VerifyRekorSET, to ensure the entry
+// matches the signature.
+func VerifyRekorInclusionProof(publicKeys []*ecdsa.PublicKey, expectedOrigin string, unverifiedRekorSET []byte, unverifiedInclusionProof []byte) error {
+	rekorPayload, err := verifyRekorSETSignature(publicKeys, unverifiedRekorSET)
+	if err != nil {
+		return err
+	}
+
+	var untrustedProof UntrustedRekorInclusionProof
+	if err := json.Unmarshal(unverifiedInclusionProof, &untrustedProof); err != nil {
+		return NewInvalidSignatureError(fmt.Sprintf("parsing Rekor inclusion proof: %v", err))
+	}
+	if untrustedProof.LogIndex < 0 || untrustedProof.TreeSize <= 0 || untrustedProof.LogIndex >= untrustedProof.TreeSize {
+		return NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof: log index %d, tree size %d", untrustedProof.LogIndex, untrustedProof.TreeSize))
+	}
+	untrustedRootHash, err := hex.DecodeString(untrustedProof.RootHash)
+	if err != nil {
+		return NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof root hash: %v", err))
+	}
+	untrustedHashes := make([][]byte, 0, len(untrustedProof.Hashes))
+	for i, h := range untrustedProof.Hashes {
+		hash, err := hex.DecodeString(h)
+		if err != nil {
+			return NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof hash %d: %v", i, err))
+		}
+		if len(hash) != sha256.Size {
+			return NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof hash %d: unexpected length %d", i, len(hash)))
+		}
+		untrustedHashes = append(untrustedHashes, hash)
+	}
+
+	// == Verify the checkpoint, and that it matches the proof
+	checkpoint, err := verifyRekorCheckpoint(publicKeys, untrustedProof.Checkpoint)
+	if err != nil {
+		return err
+	}
+	if !rekorCheckpointOriginMatches(checkpoint.origin, expectedOrigin) {
+		return NewInvalidSignatureError(fmt.Sprintf("Rekor checkpoint origin %q does not match expected origin %q", checkpoint.origin, expectedOrigin))
+	}
+	if checkpoint.treeSize != uint64(untrustedProof.TreeSize) {
+		return NewInvalidSignatureError(fmt.Sprintf("Rekor checkpoint tree size %d does not match inclusion proof tree size %d", checkpoint.treeSize, untrustedProof.TreeSize))
+	}
+	if !bytes.Equal(checkpoint.rootHash, untrustedRootHash) {
+		return NewInvalidSignatureError("Rekor checkpoint root hash does not match inclusion proof root hash")
+	}
+
+	// == Verify the inclusion proof against the checkpoint
+	leafHash := rfc6962LeafHash(rekorPayload.Body)
+	computedRoot, err := rfc6962RootFromInclusionProof(uint64(untrustedProof.LogIndex), checkpoint.treeSize, leafHash, untrustedHashes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computedRoot, checkpoint.rootHash) {
+		return NewInvalidSignatureError("Rekor inclusion proof does not match the checkpoint root hash")
+	}
+	return nil
+}
+
+// rekorCheckpoint is the verified contents of a Rekor checkpoint.
+type rekorCheckpoint struct {
+	origin   string
+	treeSize uint64
+	rootHash []byte
+}
+
+// verifyRekorCheckpoint verifies that unverifiedCheckpoint, a signed note in the format of
+// https://github.com/transparency-dev/formats/blob/main/log/README.md , is signed by one of publicKeys, and returns its contents.
+func verifyRekorCheckpoint(publicKeys []*ecdsa.PublicKey, unverifiedCheckpoint string) (rekorCheckpoint, error) {
+	// == Split the note text from the signatures
+	untrustedText, untrustedSignatures, ok := strings.Cut(unverifiedCheckpoint, "\n\n")
+	if !ok {
+		return rekorCheckpoint{}, NewInvalidSignatureError("invalid Rekor checkpoint: missing signatures")
+	}
+	untrustedText += "\n" // The signed text includes the final newline.
+
+	// == Verify signatures
+	keyHints := make([]uint32, len(publicKeys))
+	for i, pk := range publicKeys {
+		hint, err := rekorKeyHint(pk)
+		if err != nil {
+			return rekorCheckpoint{}, err
+		}
+		keyHints[i] = hint
+	}
+	textHash := sha256.Sum256([]byte(untrustedText))
+	verified := false
+	for line := range strings.SplitSeq(strings.TrimSuffix(untrustedSignatures, "\n"), "\n") {
+		untrustedSigBase64, ok := strings.CutPrefix(line, "— ")
+		if !ok {
+			return rekorCheckpoint{}, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor checkpoint signature line %q", line))
+		}
+		// The signer name may contain spaces; the signature is the last field.
+		if i := strings.LastIndexByte(untrustedSigBase64, ' '); i != -1 {
+			untrustedSigBase64 = untrustedSigBase64[i+1:]
+		}
+		untrustedSig, err := base64.StdEncoding.DecodeString(untrustedSigBase64)
+		if err != nil || len(untrustedSig) < 5 {
+			return rekorCheckpoint{}, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor checkpoint signature line %q", line))
+		}
+		untrustedHint := binary.BigEndian.Uint32(untrustedSig[:4])
+		for i, pk := range publicKeys {
+			if keyHints[i] == untrustedHint && ecdsa.VerifyASN1(pk, textHash[:], untrustedSig[4:]) {
+				verified = true
+				break
+			}
+		}
+		if verified {
+			break
+		}
+	}
+	if !verified {
+		return rekorCheckpoint{}, NewInvalidSignatureError("cryptographic signature verification of Rekor checkpoint failed")
+	}
+
+	// == Parse the verified text
+	lines := strings.Split(strings.TrimSuffix(untrustedText, "\n"), "\n")
+	if len(lines) < 3 {
+		return rekorCheckpoint{}, NewInvalidSignatureError("invalid Rekor checkpoint: too few lines")
+	}
+	treeSize, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return rekorCheckpoint{}, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor checkpoint tree size: %v", err))
+	}
+	rootHash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return rekorCheckpoint{}, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor checkpoint root hash: %v", err))
+	}
+	if len(rootHash) != sha256.Size {
+		return rekorCheckpoint{}, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor checkpoint root hash length %d", len(rootHash)))
+	}
+	return rekorCheckpoint{
+		origin:   lines[0],
+		treeSize: treeSize,
+		rootHash: rootHash,
+	}, nil
+}
+
+// rekorCheckpointOriginMatches returns true if origin, the origin line of a verified Rekor checkpoint, matches expectedOrigin.
+// Rekor uses origins of the form "rekor.sigstore.dev - 1193050959916656506", the log name followed by the ID of the tree
+// of a shard; expectedOrigin may be a complete origin, or only a log name, which matches the checkpoints of all shards of that log.
+func rekorCheckpointOriginMatches(origin, expectedOrigin string) bool {
+	if expectedOrigin == "" {
+		return false
+	}
+	if origin == expectedOrigin {
+		return true
+	}
+	if strings.Contains(expectedOrigin, " - ") {
+		return false
+	}
+	treeID, ok := strings.CutPrefix(origin, expectedOrigin+" - ")
+	return ok && treeID != "" && !strings.Contains(treeID, " ")
+}
+
+// rekorKeyHint returns the key hint used by Rekor in checkpoint signatures for publicKey.
+func rekorKeyHint(publicKey *ecdsa.PublicKey) (uint32, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return 0, fmt.Errorf("marshaling Rekor public key: %w", err)
+	}
+	digest := sha256.Sum256(der)
+	return binary.BigEndian.Uint32(digest[:4]), nil
+}
+
+// rfc6962LeafHash returns the RFC 6962 Merkle tree hash of a leaf with data.
+func rfc6962LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// rfc6962NodeHash returns the RFC 6962 Merkle tree hash of an interior node with the specified children.
+func rfc6962NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// rfc6962RootFromInclusionProof returns the root hash of a tree of treeSize entries, computed from an inclusion proof
+// for a leaf with leafHash at index.
+// This follows RFC 9162, section 2.1.3.2.
+func rfc6962RootFromInclusionProof(index, treeSize uint64, leafHash []byte, proof [][]byte) ([]byte, error) {
+	if index >= treeSize {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof: index %d beyond tree size %d", index, treeSize))
+	}
+	// The proof consists of "inner" hashes on the path from the leaf to the root of the smallest perfect subtree
+	// containing both index and treeSize-1, followed by "border" hashes of the left siblings on the right border of the tree.
+	inner := bits.Len64(index ^ (treeSize - 1))
+	border := bits.OnesCount64(index >> inner)
+	if len(proof) != inner+border {
+		return nil, NewInvalidSignatureError(fmt.Sprintf("invalid Rekor inclusion proof: expected %d hashes, got %d", inner+border, len(proof)))
+	}
+	res := leafHash
+	for i, h := range proof[:inner] {
+		if (index>>i)&1 == 0 {
+			res = rfc6962NodeHash(res, h)
+		} else {
+			res = rfc6962NodeHash(h, res)
+		}
+	}
+	for _, h := range proof[inner:] {
+		res = rfc6962NodeHash(h, res)
+	}
+	return res, nil
+}
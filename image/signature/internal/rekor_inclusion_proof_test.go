@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRekorLog is a minimal in-memory transparency log, used to create test data.
+type testRekorLog struct {
+	key    *ecdsa.PrivateKey
+	origin string
+	bodies [][]byte
+}
+
+func newTestRekorLog(t *testing.T, entries int) *testRekorLog {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	l := &testRekorLog{key: key, origin: "test.example.com - 1234"}
+	for i := range entries {
+		l.bodies = append(l.bodies, fmt.Appendf(nil, `{"entry":%d}`, i))
+	}
+	return l
+}
+
+// treeHash returns the RFC 6962 MTH of bodies.
+func (l *testRekorLog) treeHash(bodies [][]byte) []byte {
+	if len(bodies) == 1 {
+		return rfc6962LeafHash(bodies[0])
+	}
+	k := testLargestPowerOfTwoBelow(len(bodies))
+	return rfc6962NodeHash(l.treeHash(bodies[:k]), l.treeHash(bodies[k:]))
+}
+
+// path returns the RFC 6962 PATH for entry index in bodies.
+func (l *testRekorLog) path(index int, bodies [][]byte) [][]byte {
+	if len(bodies) == 1 {
+		return nil
+	}
+	k := testLargestPowerOfTwoBelow(len(bodies))
+	if index < k {
+		return append(l.path(index, bodies[:k]), l.treeHash(bodies[k:]))
+	}
+	return append(l.path(index-k, bodies[k:]), l.treeHash(bodies[:k]))
+}
+
+func testLargestPowerOfTwoBelow(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// set returns a SET for entry index.
+func (l *testRekorLog) set(t *testing.T, index int) []byte {
+	payload, err := json.Marshal(UntrustedRekorPayload{
+		Body:           l.bodies[index],
+		IntegratedTime: 1700000000,
+		LogIndex:       int64(index) + 1000, // Deliberately different from the index within the tree
+		LogID:          "test-log",
+	})
+	require.NoError(t, err)
+	canonical, err := jsoncanonicalizer.Transform(payload)
+	require.NoError(t, err)
+	hash := sha256.Sum256(canonical)
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, hash[:])
+	require.NoError(t, err)
+	res, err := json.Marshal(UntrustedRekorSET{
+		UntrustedSignedEntryTimestamp: sig,
+		UntrustedPayload:              payload,
+	})
+	require.NoError(t, err)
+	return res
+}
+
+// checkpoint returns a checkpoint for a tree of size entries, signed by key.
+func (l *testRekorLog) checkpoint(t *testing.T, key *ecdsa.PrivateKey, size int, rootHash []byte) string {
+	text := fmt.Sprintf("%s\n%d\n%s\nTimestamp: 1700000000\n", l.origin, size, base64.StdEncoding.EncodeToString(rootHash))
+	hash := sha256.Sum256([]byte(text))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	hint, err := rekorKeyHint(&key.PublicKey)
+	require.NoError(t, err)
+	sigWithHint := binary.BigEndian.AppendUint32(nil, hint)
+	sigWithHint = append(sigWithHint, sig...)
+	return fmt.Sprintf("%s\n— test.example.com %s\n", text, base64.StdEncoding.EncodeToString(sigWithHint))
+}
+
+// inclusionProof returns an inclusion proof for entry index, in a tree of size entries.
+func (l *testRekorLog) inclusionProof(t *testing.T, index, size int) UntrustedRekorInclusionProof {
+	rootHash := l.treeHash(l.bodies[:size])
+	hashes := []string{}
+	for _, h := range l.path(index, l.bodies[:size]) {
+		hashes = append(hashes, hex.EncodeToString(h))
+	}
+	return UntrustedRekorInclusionProof{
+		LogIndex:   int64(index),
+		RootHash:   hex.EncodeToString(rootHash),
+		TreeSize:   int64(size),
+		Hashes:     hashes,
+		Checkpoint: l.checkpoint(t, l.key, size, rootHash),
+	}
+}
+
+func TestUntrustedRekorInclusionProofUnmarshalJSON(t *testing.T) {
+	var p UntrustedRekorInclusionProof
+	err := p.UnmarshalJSON([]byte("&"))
+	assert.Error(t, err)
+
+	valid := UntrustedRekorInclusionProof{
+		LogIndex:   1,
+		RootHash:   "abcd",
+		TreeSize:   2,
+		Hashes:     []string{"0123"},
+		Checkpoint: "checkpoint",
+	}
+	validJSON, err := json.Marshal(valid)
+	require.NoError(t, err)
+	err = json.Unmarshal(validJSON, &p)
+	require.NoError(t, err)
+	assert.Equal(t, valid, p)
+
+	// An empty proof is marshaled as an empty array
+	empty, err := json.Marshal(UntrustedRekorInclusionProof{TreeSize: 1})
+	require.NoError(t, err)
+	assert.Contains(t, string(empty), `"hashes":[]`)
+
+	// Various ways to corrupt the JSON
+	breakFns := []func(mSA){
+		func(v mSA) { delete(v, "logIndex") },
+		func(v mSA) { delete(v, "rootHash") },
+		func(v mSA) { delete(v, "treeSize") },
+		func(v mSA) { delete(v, "hashes") },
+		func(v mSA) { delete(v, "checkpoint") },
+		func(v mSA) { v["unexpected"] = 1 },
+		func(v mSA) { v["logIndex"] = "1" },
+		func(v mSA) { v["hashes"] = "0123" },
+	}
+	for _, fn := range breakFns {
+		var tmp mSA
+		err := json.Unmarshal(validJSON, &tmp)
+		require.NoError(t, err)
+		fn(tmp)
+		testJSON, err := json.Marshal(tmp)
+		require.NoError(t, err)
+		var p UntrustedRekorInclusionProof
+		err = json.Unmarshal(testJSON, &p)
+		assert.Error(t, err, string(testJSON))
+	}
+}
+
+func TestRFC6962RootFromInclusionProof(t *testing.T) {
+	l := newTestRekorLog(t, 9)
+	for size := 1; size <= len(l.bodies); size++ {
+		expectedRoot := l.treeHash(l.bodies[:size])
+		for index := range size {
+			proof := l.path(index, l.bodies[:size])
+			root, err := rfc6962RootFromInclusionProof(uint64(index), uint64(size), rfc6962LeafHash(l.bodies[index]), proof)
+			require.NoError(t, err, "%d/%d", index, size)
+			assert.Equal(t, expectedRoot, root, "%d/%d", index, size)
+
+			// A proof with an unexpected number of hashes is rejected
+			_, err = rfc6962RootFromInclusionProof(uint64(index), uint64(size), rfc6962LeafHash(l.bodies[index]), append(proof, expectedRoot))
+			assert.Error(t, err, "%d/%d", index, size)
+		}
+	}
+
+	_, err := rfc6962RootFromInclusionProof(2, 2, rfc6962LeafHash(l.bodies[0]), nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyRekorInclusionProof(t *testing.T) {
+	const testOrigin = "test.example.com"
+	l := newTestRekorLog(t, 7)
+	publicKeys := []*ecdsa.PublicKey{&l.key.PublicKey}
+
+	// Success
+	for _, c := range []struct{ index, size int }{{0, 1}, {3, 7}, {6, 7}, {2, 5}} {
+		proofBytes, err := json.Marshal(l.inclusionProof(t, c.index, c.size))
+		require.NoError(t, err)
+		err = VerifyRekorInclusionProof(publicKeys, testOrigin, l.set(t, c.index), proofBytes)
+		assert.NoError(t, err, "%d/%d", c.index, c.size)
+	}
+
+	// Success with multiple keys
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	validProof := l.inclusionProof(t, 3, 7)
+	validProofBytes, err := json.Marshal(validProof)
+	require.NoError(t, err)
+	validSET := l.set(t, 3)
+	err = VerifyRekorInclusionProof([]*ecdsa.PublicKey{&otherKey.PublicKey, &l.key.PublicKey}, testOrigin, validSET, validProofBytes)
+	assert.NoError(t, err)
+
+	// Unknown key
+	err = VerifyRekorInclusionProof([]*ecdsa.PublicKey{&otherKey.PublicKey}, testOrigin, validSET, validProofBytes)
+	assert.Error(t, err)
+
+	// Invalid proof JSON
+	err = VerifyRekorInclusionProof(publicKeys, testOrigin, validSET, []byte("&"))
+	assert.Error(t, err)
+
+	// A proof for a different entry
+	err = VerifyRekorInclusionProof(publicKeys, testOrigin, l.set(t, 2), validProofBytes)
+	assert.Error(t, err)
+
+	// Origins
+	for _, c := range []struct {
+		origin string
+		valid  bool
+	}{
+		{"test.example.com - 1234", true},
+		{"test.example.com", true},
+		{"test.example.com - 1", false},
+		{"other.example.com", false},
+		{"test.example", false},
+		{"", false},
+	} {
+		err = VerifyRekorInclusionProof(publicKeys, c.origin, validSET, validProofBytes)
+		if c.valid {
+			assert.NoError(t, err, c.origin)
+		} else {
+			assert.Error(t, err, c.origin)
+		}
+	}
+
+	for _, fn := range []func(p *UntrustedRekorInclusionProof){
+		// Invalid index or size
+		func(p *UntrustedRekorInclusionProof) { p.LogIndex = -1 },
+		func(p *UntrustedRekorInclusionProof) { p.LogIndex = 7 },
+		func(p *UntrustedRekorInclusionProof) { p.TreeSize = 0 },
+		// Index does not match the hashes
+		func(p *UntrustedRekorInclusionProof) { p.LogIndex = 2 },
+		// Tree size does not match the checkpoint
+		func(p *UntrustedRekorInclusionProof) { p.TreeSize = 6 },
+		// Invalid hashes
+		func(p *UntrustedRekorInclusionProof) { p.RootHash = "this is not hex" },
+		func(p *UntrustedRekorInclusionProof) { p.Hashes[0] = "this is not hex" },
+		func(p *UntrustedRekorInclusionProof) { p.Hashes[0] = "0123" },
+		func(p *UntrustedRekorInclusionProof) {
+			p.Hashes[0] = hex.EncodeToString(rfc6962LeafHash([]byte("modified")))
+		},
+		// Root hash does not match the checkpoint
+		func(p *UntrustedRekorInclusionProof) {
+			p.RootHash = hex.EncodeToString(rfc6962LeafHash([]byte("modified")))
+		},
+		// Checkpoint of a different log, signed by the same key
+		func(p *UntrustedRekorInclusionProof) {
+			other := *l
+			other.origin = "other.example.com - 1234"
+			p.Checkpoint = other.checkpoint(t, l.key, 7, l.treeHash(l.bodies[:7]))
+		},
+		// Checkpoint signed by an unknown key
+		func(p *UntrustedRekorInclusionProof) {
+			p.Checkpoint = l.checkpoint(t, otherKey, 7, l.treeHash(l.bodies[:7]))
+		},
+		// Checkpoint for a different tree (consistently modifying the proof root hash)
+		func(p *UntrustedRekorInclusionProof) {
+			root := l.treeHash(l.bodies[:6])
+			p.RootHash = hex.EncodeToString(root)
+			p.Checkpoint = l.checkpoint(t, l.key, 7, root)
+		},
+		// Corrupt checkpoints
+		func(p *UntrustedRekorInclusionProof) { p.Checkpoint = "" },
+		func(p *UntrustedRekorInclusionProof) { p.Checkpoint = "test.example.com - 1234\n7\n\n" },
+		func(p *UntrustedRekorInclusionProof) { p.Checkpoint = "test.example.com - 1234\n7\n\n- no signature\n" },
+		func(p *UntrustedRekorInclusionProof) { p.Checkpoint = "test.example.com - 1234\n7\n\n— name !!!\n" },
+		func(p *UntrustedRekorInclusionProof) { p.Checkpoint = "x" + p.Checkpoint },
+	} {
+		proof := validProof
+		proof.Hashes = append([]string{}, validProof.Hashes...)
+		fn(&proof)
+		proofBytes, err := json.Marshal(proof)
+		require.NoError(t, err)
+		err = VerifyRekorInclusionProof(publicKeys, testOrigin, validSET, proofBytes)
+		assert.Error(t, err, "%#v", proof)
+	}
+}
+
+func TestVerifyRekorCheckpoint(t *testing.T) {
+	l := newTestRekorLog(t, 3)
+	root := l.treeHash(l.bodies)
+	checkpoint := l.checkpoint(t, l.key, 3, root)
+
+	res, err := verifyRekorCheckpoint([]*ecdsa.PublicKey{&l.key.PublicKey}, checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, rekorCheckpoint{origin: "test.example.com - 1234", treeSize: 3, rootHash: root}, res)
+
+	// A checkpoint with an additional, unrecognized, signature
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherCheckpoint := l.checkpoint(t, otherKey, 3, root)
+	_, otherSignatures, _ := strings.Cut(otherCheckpoint, "\n\n")
+	_, err = verifyRekorCheckpoint([]*ecdsa.PublicKey{&l.key.PublicKey}, checkpoint+otherSignatures)
+	assert.NoError(t, err)
+	_, err = verifyRekorCheckpoint([]*ecdsa.PublicKey{&l.key.PublicKey}, otherCheckpoint)
+	assert.Error(t, err)
+
+	// Invalid contents, even if validly signed
+	for _, text := range []string{
+		"origin\n3\n",
+		"origin\nnot-a-number\n" + base64.StdEncoding.EncodeToString(root) + "\n",
+		"origin\n3\n!!!\n",
+		"origin\n3\n" + base64.StdEncoding.EncodeToString([]byte("short")) + "\n",
+	} {
+		hash := sha256.Sum256([]byte(text))
+		sig, err := ecdsa.SignASN1(rand.Reader, l.key, hash[:])
+		require.NoError(t, err)
+		hint, err := rekorKeyHint(&l.key.PublicKey)
+		require.NoError(t, err)
+		sigWithHint := append(binary.BigEndian.AppendUint32(nil, hint), sig...)
+		_, err = verifyRekorCheckpoint([]*ecdsa.PublicKey{&l.key.PublicKey}, fmt.Sprintf("%s\n— name %s\n", text, base64.StdEncoding.EncodeToString(sigWithHint)))
+		assert.Error(t, err, text)
+	}
+}
@@ -99,23 +99,23 @@ func (p UntrustedRekorPayload) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// VerifyRekorSET verifies that unverifiedRekorSET is correctly signed by publicKey and matches the rest of the data.
-// Returns bundle upload time on success.
-func VerifyRekorSET(publicKeys []*ecdsa.PublicKey, unverifiedRekorSET []byte, unverifiedKeyOrCertBytes []byte, unverifiedBase64Signature string, unverifiedPayloadBytes []byte) (time.Time, error) {
+// verifyRekorSETSignature verifies that unverifiedRekorSET is correctly signed by one of publicKeys, and returns the parsed SET payload.
+// It does not check that the payload matches any other data.
+func verifyRekorSETSignature(publicKeys []*ecdsa.PublicKey, unverifiedRekorSET []byte) (UntrustedRekorPayload, error) {
 	// FIXME: Should the publicKey parameter hard-code ecdsa?
 
 	// == Parse SET bytes
 	var untrustedSET UntrustedRekorSET
 	// Sadly. we need to parse and transform untrusted data before verifying a cryptographic signature...
 	if err := json.Unmarshal(unverifiedRekorSET, &untrustedSET); err != nil {
-		return time.Time{}, NewInvalidSignatureError(err.Error())
+		return UntrustedRekorPayload{}, NewInvalidSignatureError(err.Error())
 	}
 	// == Verify SET signature
 	// Cosign unmarshals and re-marshals UntrustedPayload; that seems unnecessary,
 	// assuming jsoncanonicalizer is designed to operate on untrusted data.
 	untrustedSETPayloadCanonicalBytes, err := jsoncanonicalizer.Transform(untrustedSET.UntrustedPayload)
 	if err != nil {
-		return time.Time{}, NewInvalidSignatureError(fmt.Sprintf("canonicalizing Rekor SET JSON: %v", err))
+		return UntrustedRekorPayload{}, NewInvalidSignatureError(fmt.Sprintf("canonicalizing Rekor SET JSON: %v", err))
 	}
 	untrustedSETPayloadHash := sha256.Sum256(untrustedSETPayloadCanonicalBytes)
 	publicKeymatched := false
@@ -126,7 +126,7 @@ func VerifyRekorSET(publicKeys []*ecdsa.PublicKey, unverifiedRekorSET []byte, un
 		}
 	}
 	if !publicKeymatched {
-		return time.Time{}, NewInvalidSignatureError("cryptographic signature verification of Rekor SET failed")
+		return UntrustedRekorPayload{}, NewInvalidSignatureError("cryptographic signature verification of Rekor SET failed")
 	}
 
 	// == Parse SET payload
@@ -136,7 +136,17 @@ func VerifyRekorSET(publicKeys []*ecdsa.PublicKey, unverifiedRekorSET []byte, un
 	// of the SET payload.
 	var rekorPayload UntrustedRekorPayload
 	if err := json.Unmarshal(untrustedSETPayloadCanonicalBytes, &rekorPayload); err != nil {
-		return time.Time{}, NewInvalidSignatureError(fmt.Sprintf("parsing Rekor SET payload: %v", err.Error()))
+		return UntrustedRekorPayload{}, NewInvalidSignatureError(fmt.Sprintf("parsing Rekor SET payload: %v", err.Error()))
+	}
+	return rekorPayload, nil
+}
+
+// VerifyRekorSET verifies that unverifiedRekorSET is correctly signed by publicKey and matches the rest of the data.
+// Returns bundle upload time on success.
+func VerifyRekorSET(publicKeys []*ecdsa.PublicKey, unverifiedRekorSET []byte, unverifiedKeyOrCertBytes []byte, unverifiedBase64Signature string, unverifiedPayloadBytes []byte) (time.Time, error) {
+	rekorPayload, err := verifyRekorSETSignature(publicKeys, unverifiedRekorSET)
+	if err != nil {
+		return time.Time{}, err
 	}
 	// FIXME: Consider being much more strict about decoding JSON.
 	var hashedRekord RekorHashedrekord
@@ -132,6 +132,32 @@ func PRSigstoreSignedWithRekorPublicKeyDatas(rekorPublickeyDatas [][]byte) PRSig
 	}
 }
 
+// PRSigstoreSignedWithRekorInclusionProofRequired specifies a value for the "rekorInclusionProofRequired" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithRekorInclusionProofRequired(required bool) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.rekorInclusionProofRequiredSet {
+			return InvalidPolicyFormatError(`"rekorInclusionProofRequired" already specified`)
+		}
+		pr.RekorInclusionProofRequired = required
+		pr.rekorInclusionProofRequiredSet = true
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithRekorCheckpointOrigin specifies a value for the "rekorCheckpointOrigin" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithRekorCheckpointOrigin(origin string) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.RekorCheckpointOrigin != "" {
+			return InvalidPolicyFormatError(`"rekorCheckpointOrigin" already specified`)
+		}
+		if origin == "" {
+			return InvalidPolicyFormatError(`"rekorCheckpointOrigin" can not be empty`)
+		}
+		pr.RekorCheckpointOrigin = origin
+		return nil
+	}
+}
+
 // PRSigstoreSignedWithSignedIdentity specifies a value for the "signedIdentity" field when calling NewPRSigstoreSigned.
 func PRSigstoreSignedWithSignedIdentity(signedIdentity PolicyReferenceMatch) PRSigstoreSignedOption {
 	return func(pr *prSigstoreSigned) error {
@@ -199,6 +225,15 @@ func newPRSigstoreSigned(options ...PRSigstoreSignedOption) (*prSigstoreSigned,
 	if res.PKI != nil && rekorSources > 0 {
 		return nil, InvalidPolicyFormatError("rekorPublickeyPath, rekorPublicKeyPaths, rekorPublickeyData and rekorPublicKeyDatas are not supported for pki")
 	}
+	if res.RekorInclusionProofRequired && rekorSources == 0 {
+		return nil, InvalidPolicyFormatError("At least one of rekorPublickeyPath, rekorPublicKeyPaths, rekorPublickeyData and rekorPublicKeyDatas must be specified if rekorInclusionProofRequired is used")
+	}
+	if res.RekorInclusionProofRequired && res.RekorCheckpointOrigin == "" {
+		return nil, InvalidPolicyFormatError("rekorCheckpointOrigin must be specified if rekorInclusionProofRequired is used")
+	}
+	if !res.RekorInclusionProofRequired && res.RekorCheckpointOrigin != "" {
+		return nil, InvalidPolicyFormatError("rekorCheckpointOrigin can only be used with rekorInclusionProofRequired")
+	}
 
 	if res.SignedIdentity == nil {
 		return nil, InvalidPolicyFormatError("signedIdentity not specified")
@@ -237,6 +272,7 @@ func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
 	var tmp prSigstoreSigned
 	var gotKeyPath, gotKeyPaths, gotKeyData, gotKeyDatas, gotFulcio, gotPKI bool
 	var gotRekorPublicKeyPath, gotRekorPublicKeyPaths, gotRekorPublicKeyData, gotRekorPublicKeyDatas bool
+	var gotRekorInclusionProofRequired, gotRekorCheckpointOrigin bool
 	var fulcio prSigstoreSignedFulcio
 	var pki prSigstoreSignedPKI
 	var signedIdentity json.RawMessage
@@ -271,6 +307,12 @@ func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
 		case "rekorPublicKeyDatas":
 			gotRekorPublicKeyDatas = true
 			return &tmp.RekorPublicKeyDatas
+		case "rekorInclusionProofRequired":
+			gotRekorInclusionProofRequired = true
+			return &tmp.RekorInclusionProofRequired
+		case "rekorCheckpointOrigin":
+			gotRekorCheckpointOrigin = true
+			return &tmp.RekorCheckpointOrigin
 		case "pki":
 			gotPKI = true
 			return &pki
@@ -324,6 +366,12 @@ func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
 	if gotRekorPublicKeyDatas {
 		opts = append(opts, PRSigstoreSignedWithRekorPublicKeyDatas(tmp.RekorPublicKeyDatas))
 	}
+	if gotRekorInclusionProofRequired {
+		opts = append(opts, PRSigstoreSignedWithRekorInclusionProofRequired(tmp.RekorInclusionProofRequired))
+	}
+	if gotRekorCheckpointOrigin {
+		opts = append(opts, PRSigstoreSignedWithRekorCheckpointOrigin(tmp.RekorCheckpointOrigin))
+	}
 	if gotPKI {
 		opts = append(opts, PRSigstoreSignedWithPKI(&pki))
 	}
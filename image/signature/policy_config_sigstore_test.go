@@ -189,6 +189,19 @@ func TestNewPRSigstoreSigned(t *testing.T) {
 					RekorPublicKeyDatas: [][]byte{testRekorKeyData, testKeyData},
 				},
 			},
+			{
+				rekorOptions: []PRSigstoreSignedOption{
+					PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+					PRSigstoreSignedWithRekorInclusionProofRequired(true),
+					PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+				},
+				rekorExpected: prSigstoreSigned{
+					RekorPublicKeyPath:             testRekorKeyPath,
+					RekorInclusionProofRequired:    true,
+					RekorCheckpointOrigin:          "rekor.example.com",
+					rekorInclusionProofRequiredSet: true,
+				},
+			},
 		} {
 			if (c.requiresRekor == rekorRequired && len(c2.rekorOptions) == 0) ||
 				(c.requiresRekor == rekorForbidden && len(c2.rekorOptions) != 0) {
@@ -201,6 +214,9 @@ func TestNewPRSigstoreSigned(t *testing.T) {
 			expected.RekorPublicKeyPaths = c2.rekorExpected.RekorPublicKeyPaths
 			expected.RekorPublicKeyData = c2.rekorExpected.RekorPublicKeyData
 			expected.RekorPublicKeyDatas = c2.rekorExpected.RekorPublicKeyDatas
+			expected.RekorInclusionProofRequired = c2.rekorExpected.RekorInclusionProofRequired
+			expected.RekorCheckpointOrigin = c2.rekorExpected.RekorCheckpointOrigin
+			expected.rekorInclusionProofRequiredSet = c2.rekorExpected.rekorInclusionProofRequiredSet
 			assert.Equal(t, &expected, pr)
 		}
 	}
@@ -363,6 +379,54 @@ func TestNewPRSigstoreSigned(t *testing.T) {
 			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
 			PRSigstoreSignedWithSignedIdentity(testIdentity),
 		},
+		{ // rekorInclusionProofRequired without Rekor
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // Duplicate rekorInclusionProofRequired
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // Duplicate rekorInclusionProofRequired, first set to false
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(false),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // rekorInclusionProofRequired without rekorCheckpointOrigin
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // rekorCheckpointOrigin without rekorInclusionProofRequired
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // Empty rekorCheckpointOrigin
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithRekorCheckpointOrigin(""),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
+		{ // Duplicate rekorCheckpointOrigin
+			PRSigstoreSignedWithKeyPath(testKeyPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testRekorKeyPath),
+			PRSigstoreSignedWithRekorInclusionProofRequired(true),
+			PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+			PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+			PRSigstoreSignedWithSignedIdentity(testIdentity),
+		},
 		{ // Missing signedIdentity
 			PRSigstoreSignedWithKeyPath(testKeyPath),
 		},
@@ -507,6 +571,20 @@ func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
 			func(v mSA) { v["rekorPublicKeyDatas"] = 1 },
 			func(v mSA) { v["rekorPublicKeyDatas"] = mSA{} },
 			func(v mSA) { v["rekorPublicKeyDatas"] = [][]byte{} },
+			// "rekorInclusionProofRequired" without a Rekor public key
+			func(v mSA) { v["rekorInclusionProofRequired"] = true },
+			// Invalid "rekorInclusionProofRequired" field
+			func(v mSA) { v["rekorPublicKeyData"] = []byte("a"); v["rekorInclusionProofRequired"] = 1 },
+			// "rekorInclusionProofRequired" without "rekorCheckpointOrigin"
+			func(v mSA) { v["rekorPublicKeyData"] = []byte("a"); v["rekorInclusionProofRequired"] = true },
+			// "rekorCheckpointOrigin" without "rekorInclusionProofRequired"
+			func(v mSA) { v["rekorPublicKeyData"] = []byte("a"); v["rekorCheckpointOrigin"] = "rekor.example.com" },
+			// Invalid "rekorCheckpointOrigin" field
+			func(v mSA) {
+				v["rekorPublicKeyData"] = []byte("a")
+				v["rekorInclusionProofRequired"] = true
+				v["rekorCheckpointOrigin"] = 1
+			},
 			// Invalid "signedIdentity" field
 			func(v mSA) { v["signedIdentity"] = "this is invalid" },
 			// "signedIdentity" an explicit nil
@@ -606,6 +684,21 @@ func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
 		otherJSONParser: newPolicyRequirementFromJSON,
 		duplicateFields: []string{"type", "keyPath", "rekorPublicKeyDatas", "signedIdentity"},
 	}.run(t)
+	// Test rekorInclusionProofRequired duplicate fields
+	policyJSONUmarshallerTests[PolicyRequirement]{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (PolicyRequirement, error) {
+			return NewPRSigstoreSigned(
+				PRSigstoreSignedWithKeyPath("/foo/bar"),
+				PRSigstoreSignedWithRekorPublicKeyPath("/foo/rekor"),
+				PRSigstoreSignedWithRekorInclusionProofRequired(true),
+				PRSigstoreSignedWithRekorCheckpointOrigin("rekor.example.com"),
+				PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()),
+			)
+		},
+		otherJSONParser: newPolicyRequirementFromJSON,
+		duplicateFields: []string{"type", "keyPath", "rekorPublicKeyPath", "rekorInclusionProofRequired", "rekorCheckpointOrigin", "signedIdentity"},
+	}.run(t)
 	// Test pki and pki-specific duplicate fields
 	testPKI, err := NewPRSigstoreSignedPKI(
 		PRSigstoreSignedPKIWithCARootsPath("fixtures/pki_root_crts.pem"),
@@ -69,6 +69,15 @@ type PolicyRequirement interface {
 	// verifiesSignatures returns true if and only if the requirement performs cryptographic
 	// signature verification on the entire contents of the image before allowing it.
 	verifiesSignatures() bool
+
+	// RequirementType returns the value of the "type" field of the requirement in policy.json,
+	// e.g. "signedBy".
+	RequirementType() string
+}
+
+// RequirementType implements PolicyRequirement.RequirementType.
+func (pr prCommon) RequirementType() string {
+	return string(pr.Type)
 }
 
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
@@ -146,33 +155,41 @@ func (pc *PolicyContext) RequireSignatureVerification(val bool) {
 
 // requirementsForImageRef selects the appropriate requirements for ref.
 func (pc *PolicyContext) requirementsForImageRef(ref types.ImageReference) PolicyRequirements {
+	reqs, _ := pc.Policy.RequirementsForImageRef(ref)
+	return reqs
+}
+
+// RequirementsForImageRef selects the appropriate requirements for ref, and returns them along with
+// a description of the policy section they come from.
+// This only describes the policy; use PolicyContext.IsRunningImageAllowed to actually evaluate it.
+func (p *Policy) RequirementsForImageRef(ref types.ImageReference) (PolicyRequirements, PolicyScopeMatch) {
 	// Do we have a PolicyTransportScopes for this transport?
 	transportName := ref.Transport().Name()
-	if transportScopes, ok := pc.Policy.Transports[transportName]; ok {
+	if transportScopes, ok := p.Transports[transportName]; ok {
 		// Look for a full match.
 		identity := ref.PolicyConfigurationIdentity()
 		if req, ok := transportScopes[identity]; ok {
 			logrus.Debugf(` Using transport %q policy section %q`, transportName, identity)
-			return req
+			return req, PolicyScopeMatch{Transport: transportName, Scope: identity}
 		}
 
 		// Look for a match of the possible parent namespaces.
 		for _, name := range ref.PolicyConfigurationNamespaces() {
 			if req, ok := transportScopes[name]; ok {
 				logrus.Debugf(` Using transport %q specific policy section %q`, transportName, name)
-				return req
+				return req, PolicyScopeMatch{Transport: transportName, Scope: name}
 			}
 		}
 
 		// Look for a default match for the transport.
 		if req, ok := transportScopes[""]; ok {
 			logrus.Debugf(` Using transport %q policy section ""`, transportName)
-			return req
+			return req, PolicyScopeMatch{Transport: transportName, Scope: ""}
 		}
 	}
 
 	logrus.Debugf(" Using default policy section")
-	return pc.Policy.Default
+	return p.Default, PolicyScopeMatch{Default: true}
 }
 
 // GetSignaturesWithAcceptedAuthor returns those signatures from an image
@@ -284,7 +301,16 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, publicImage
 	image := unparsedimage.FromPublic(publicImage)
 
 	logrus.Debugf("IsRunningImageAllowed for image %s", policyIdentityLogName(image.Reference()))
-	reqs := pc.requirementsForImageRef(image.Reference())
+	return pc.isRunningImageAllowed(ctx, image, nil)
+}
+
+// isRunningImageAllowed is the implementation of IsRunningImageAllowed and EvaluateWithTrace.
+// If trace is not nil, it is filled with details of the evaluation.
+func (pc *PolicyContext) isRunningImageAllowed(ctx context.Context, image private.UnparsedImage, trace *PolicyEvaluationTrace) (bool, error) {
+	reqs, scope := pc.Policy.RequirementsForImageRef(image.Reference())
+	if trace != nil {
+		trace.Scope = scope
+	}
 
 	if len(reqs) == 0 {
 		return false, PolicyRequirementError("List of verification policy requirements must not be empty")
@@ -292,8 +318,28 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, publicImage
 
 	wasSignatureVerified := false
 	for reqNumber, req := range reqs {
+		var reqTrace *PolicyRequirementTrace // = nil
+		if trace != nil {
+			trace.Requirements = append(trace.Requirements, PolicyRequirementTrace{
+				Type:               req.RequirementType(),
+				VerifiesSignatures: req.verifiesSignatures(),
+			})
+			reqTrace = &trace.Requirements[len(trace.Requirements)-1]
+		}
 		// FIXME: supply state
-		allowed, err := req.isRunningImageAllowed(ctx, image)
+		var allowed bool
+		var err error
+		if tracingReq, ok := req.(policyRequirementWithSignatureTrace); ok && reqTrace != nil {
+			allowed, err = tracingReq.isRunningImageAllowedWithTrace(ctx, image, reqTrace)
+		} else {
+			allowed, err = req.isRunningImageAllowed(ctx, image)
+		}
+		if reqTrace != nil {
+			reqTrace.Allowed = allowed
+			if err != nil {
+				reqTrace.Reason = err.Error()
+			}
+		}
 		if !allowed {
 			logrus.Debugf("Requirement %d: denied, done", reqNumber)
 			return false, err
@@ -305,6 +351,9 @@ func (pc *PolicyContext) IsRunningImageAllowed(ctx context.Context, publicImage
 	}
 
 	if pc.requireSigned && !wasSignatureVerified {
+		if trace != nil {
+			trace.SignatureVerificationRequiredButMissing = true
+		}
 		return false, PolicyRequirementError(fmt.Sprintf("No signature verification policy found for image %s", policyIdentityLogName(image.Reference())))
 	}
 
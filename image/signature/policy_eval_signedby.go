@@ -80,6 +80,12 @@ func (pr *prSignedBy) isSignatureAuthorAccepted(ctx context.Context, image priva
 }
 
 func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image private.UnparsedImage) (bool, error) {
+	return pr.isRunningImageAllowedWithTrace(ctx, image, nil)
+}
+
+// isRunningImageAllowedWithTrace implements policyRequirementWithSignatureTrace.
+// trace may be nil.
+func (pr *prSignedBy) isRunningImageAllowedWithTrace(ctx context.Context, image private.UnparsedImage, trace *PolicyRequirementTrace) (bool, error) {
 	// FIXME: Use image.UntrustedSignatures, use that to improve error messages
 	// (needs tests!)
 	sigs, err := image.Signatures(ctx)
@@ -87,10 +93,11 @@ func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image private.U
 		return false, err
 	}
 	var rejections []error
-	for _, s := range sigs {
+	for sigNumber, s := range sigs {
 		var reason error
 		switch res, _, err := pr.isSignatureAuthorAccepted(ctx, image, s); res {
 		case sarAccepted:
+			trace.recordSignature(sigNumber, true, nil)
 			// One accepted signature is enough.
 			return true, nil
 		case sarRejected:
@@ -101,6 +108,7 @@ func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image private.U
 		default:
 			reason = fmt.Errorf(`Internal error: Unexpected signature verification result %q`, string(res))
 		}
+		trace.recordSignature(sigNumber, false, reason)
 		rejections = append(rejections, reason)
 	}
 	var summary error
@@ -344,6 +344,24 @@ func (pr *prSigstoreSigned) isSignatureAccepted(ctx context.Context, image priva
 		publicKeys = []crypto.PublicKey{pk}
 	}
 
+	if pr.RekorInclusionProofRequired {
+		if trustRoot.rekorPublicKeys == nil { // newPRSigstoreSigned rejects such combinations.
+			return sarRejected, errors.New("Internal inconsistency: Rekor inclusion proof required without a Rekor public key")
+		}
+		// The SET has already been verified to match the signature above.
+		untrustedSET, ok := untrustedAnnotations[signature.SigstoreSETAnnotationKey]
+		if !ok {
+			return sarRejected, fmt.Errorf("missing %s annotation", signature.SigstoreSETAnnotationKey)
+		}
+		untrustedInclusionProof, ok := untrustedAnnotations[signature.SigstoreRekorInclusionProofAnnotationKey]
+		if !ok {
+			return sarRejected, fmt.Errorf("missing %s annotation", signature.SigstoreRekorInclusionProofAnnotationKey)
+		}
+		if err := internal.VerifyRekorInclusionProof(trustRoot.rekorPublicKeys, pr.RekorCheckpointOrigin, []byte(untrustedSET), []byte(untrustedInclusionProof)); err != nil {
+			return sarRejected, err
+		}
+	}
+
 	if len(publicKeys) == 0 {
 		// Coverage: This should never happen, we ensured that trustRoot.publicKeys is non-empty if set,
 		// and we have already excluded the possibility in the switch above.
@@ -382,6 +400,12 @@ func (pr *prSigstoreSigned) isSignatureAccepted(ctx context.Context, image priva
 }
 
 func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image private.UnparsedImage) (bool, error) {
+	return pr.isRunningImageAllowedWithTrace(ctx, image, nil)
+}
+
+// isRunningImageAllowedWithTrace implements policyRequirementWithSignatureTrace.
+// trace may be nil.
+func (pr *prSigstoreSigned) isRunningImageAllowedWithTrace(ctx context.Context, image private.UnparsedImage, trace *PolicyRequirementTrace) (bool, error) {
 	sigs, err := image.UntrustedSignatures(ctx)
 	if err != nil {
 		return false, err
@@ -389,20 +413,23 @@ func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image pri
 	var rejections []error
 	foundNonSigstoreSignatures := 0
 	foundSigstoreNonAttachments := 0
-	for _, s := range sigs {
+	for sigNumber, s := range sigs {
 		sigstoreSig, ok := s.(signature.Sigstore)
 		if !ok {
 			foundNonSigstoreSignatures++
+			trace.recordSignature(sigNumber, false, errors.New("not a sigstore signature"))
 			continue
 		}
 		if sigstoreSig.UntrustedMIMEType() != signature.SigstoreSignatureMIMEType {
 			foundSigstoreNonAttachments++
+			trace.recordSignature(sigNumber, false, fmt.Errorf("not a sigstore signature (MIME type %q)", sigstoreSig.UntrustedMIMEType()))
 			continue
 		}
 
 		var reason error
 		switch res, err := pr.isSignatureAccepted(ctx, image, sigstoreSig); res {
 		case sarAccepted:
+			trace.recordSignature(sigNumber, true, nil)
 			// One accepted signature is enough.
 			return true, nil
 		case sarRejected:
@@ -413,6 +440,7 @@ func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image pri
 		default:
 			reason = fmt.Errorf(`Internal error: Unexpected signature verification result %q`, string(res))
 		}
+		trace.recordSignature(sigNumber, false, reason)
 		rejections = append(rejections, reason)
 	}
 	var summary error
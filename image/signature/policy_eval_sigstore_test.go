@@ -4,14 +4,26 @@ package signature
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"os"
 	"testing"
 
+	"github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.podman.io/image/v5/internal/signature"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/signature/internal"
 )
 
 func TestPRSigstoreSignedFulcioPrepareTrustRoot(t *testing.T) {
@@ -1062,3 +1074,166 @@ func TestPRSigstoreSignedVerifiesSignatures(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, pr.verifiesSignatures())
 }
+
+// testSigstoreSignatureWithRekorInclusionProof returns a key-based sigstore signature for the manifest in
+// fixtures/dir-img-cosign-valid, with a SET and an inclusion proof created by a test Rekor key, and the PEM-formatted public keys.
+func testSigstoreSignatureWithRekorInclusionProof(t *testing.T) (sig signature.Sigstore, publicKeyPEM, rekorPublicKeyPEM []byte) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	publicKeyPEM, err = cryptoutils.MarshalPublicKeyToPEM(&signingKey.PublicKey)
+	require.NoError(t, err)
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rekorPublicKeyPEM, err = cryptoutils.MarshalPublicKeyToPEM(&rekorKey.PublicKey)
+	require.NoError(t, err)
+	signWithRekorKey := func(data []byte) []byte {
+		hash := sha256.Sum256(data)
+		sig, err := ecdsa.SignASN1(rand.Reader, rekorKey, hash[:])
+		require.NoError(t, err)
+		return sig
+	}
+
+	// The signature itself
+	m, err := os.ReadFile("fixtures/dir-img-cosign-valid/manifest.json")
+	require.NoError(t, err)
+	manifestDigest, err := manifest.Digest(m)
+	require.NoError(t, err)
+	payload, err := json.Marshal(internal.NewUntrustedSigstorePayload(manifestDigest, "192.168.64.2:5000/cosign-signed-single-sample"))
+	require.NoError(t, err)
+	payloadHash := sha256.Sum256(payload)
+	signatureBytes, err := ecdsa.SignASN1(rand.Reader, signingKey, payloadHash[:])
+	require.NoError(t, err)
+
+	// The Rekor entry and SET
+	spec, err := json.Marshal(internal.RekorHashedrekordV001Schema{
+		Data: &internal.RekorHashedrekordV001SchemaData{
+			Hash: &internal.RekorHashedrekordV001SchemaDataHash{
+				Algorithm: stringPointer(internal.RekorHashedrekordV001SchemaDataHashAlgorithmSha256),
+				Value:     stringPointer(hex.EncodeToString(payloadHash[:])),
+			},
+		},
+		Signature: &internal.RekorHashedrekordV001SchemaSignature{
+			Content:   signatureBytes,
+			PublicKey: &internal.RekorHashedrekordV001SchemaSignaturePublicKey{Content: publicKeyPEM},
+		},
+	})
+	require.NoError(t, err)
+	body, err := json.Marshal(internal.RekorHashedrekord{
+		APIVersion: stringPointer(internal.RekorHashedRekordV001APIVersion),
+		Spec:       spec,
+	})
+	require.NoError(t, err)
+	setPayload, err := json.Marshal(internal.UntrustedRekorPayload{
+		Body:           body,
+		IntegratedTime: 1700000000,
+		LogIndex:       1,
+		LogID:          "test-log",
+	})
+	require.NoError(t, err)
+	canonicalSETPayload, err := jsoncanonicalizer.Transform(setPayload)
+	require.NoError(t, err)
+	set, err := json.Marshal(internal.UntrustedRekorSET{
+		UntrustedSignedEntryTimestamp: signWithRekorKey(canonicalSETPayload),
+		UntrustedPayload:              setPayload,
+	})
+	require.NoError(t, err)
+
+	// A two-entry tree, with our entry second, and a checkpoint
+	leafHash := func(data []byte) []byte {
+		h := sha256.Sum256(append([]byte{0}, data...))
+		return h[:]
+	}
+	otherLeaf := leafHash([]byte("other entry"))
+	rootHashArray := sha256.Sum256(append(append([]byte{1}, otherLeaf...), leafHash(body)...))
+	rootHash := rootHashArray[:]
+	checkpointText := fmt.Sprintf("test.example.com - 1\n2\n%s\n", base64.StdEncoding.EncodeToString(rootHash))
+	rekorKeyDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	require.NoError(t, err)
+	rekorKeyHash := sha256.Sum256(rekorKeyDER)
+	checkpointSig := append(rekorKeyHash[:4:4], signWithRekorKey([]byte(checkpointText))...)
+	inclusionProof, err := json.Marshal(internal.UntrustedRekorInclusionProof{
+		LogIndex:   1,
+		RootHash:   hex.EncodeToString(rootHash),
+		TreeSize:   2,
+		Hashes:     []string{hex.EncodeToString(otherLeaf)},
+		Checkpoint: fmt.Sprintf("%s\n— test.example.com %s\n", checkpointText, base64.StdEncoding.EncodeToString(checkpointSig)),
+	})
+	require.NoError(t, err)
+
+	return signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payload, map[string]string{
+		signature.SigstoreSignatureAnnotationKey:           base64.StdEncoding.EncodeToString(signatureBytes),
+		signature.SigstoreSETAnnotationKey:                 string(set),
+		signature.SigstoreRekorInclusionProofAnnotationKey: string(inclusionProof),
+	}), publicKeyPEM, rekorPublicKeyPEM
+}
+
+// stringPointer is a helper to create *string fields in JSON data.
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestPRSigstoreSignedRekorInclusionProof(t *testing.T) {
+	image := dirImageMock(t, "fixtures/dir-img-cosign-valid", "192.168.64.2:5000/cosign-signed-single-sample")
+	sig, publicKeyPEM, rekorPublicKeyPEM := testSigstoreSignatureWithRekorInclusionProof(t)
+
+	newPRWithOrigin := func(inclusionProofRequired bool, origin string) *prSigstoreSigned {
+		opts := []PRSigstoreSignedOption{
+			PRSigstoreSignedWithKeyData(publicKeyPEM),
+			PRSigstoreSignedWithRekorPublicKeyData(rekorPublicKeyPEM),
+			PRSigstoreSignedWithRekorInclusionProofRequired(inclusionProofRequired),
+			PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepository()),
+		}
+		if inclusionProofRequired {
+			opts = append(opts, PRSigstoreSignedWithRekorCheckpointOrigin(origin))
+		}
+		pr, err := newPRSigstoreSigned(opts...)
+		require.NoError(t, err)
+		return pr
+	}
+	newPR := func(inclusionProofRequired bool) *prSigstoreSigned {
+		return newPRWithOrigin(inclusionProofRequired, "test.example.com")
+	}
+	withoutProofAnnotation := func(sig signature.Sigstore) signature.Sigstore {
+		annotations := sig.UntrustedAnnotations()
+		delete(annotations, signature.SigstoreRekorInclusionProofAnnotationKey)
+		return signature.SigstoreFromComponents(sig.UntrustedMIMEType(), sig.UntrustedPayload(), annotations)
+	}
+
+	// Success
+	sar, err := newPR(true).isSignatureAccepted(context.Background(), image, sig)
+	require.NoError(t, err)
+	assert.Equal(t, sarAccepted, sar)
+
+	// The proof is not checked if not required
+	sar, err = newPR(false).isSignatureAccepted(context.Background(), image, withoutProofAnnotation(sig))
+	require.NoError(t, err)
+	assert.Equal(t, sarAccepted, sar)
+	sar, err = newPR(false).isSignatureAccepted(context.Background(), image,
+		sigstoreSignatureWithModifiedAnnotation(sig, signature.SigstoreRekorInclusionProofAnnotationKey, "this is invalid"))
+	require.NoError(t, err)
+	assert.Equal(t, sarAccepted, sar)
+
+	// Missing proof
+	sar, err = newPR(true).isSignatureAccepted(context.Background(), image, withoutProofAnnotation(sig))
+	assert.Error(t, err)
+	assert.Equal(t, sarRejected, sar)
+
+	// Invalid proof
+	sar, err = newPR(true).isSignatureAccepted(context.Background(), image,
+		sigstoreSignatureWithModifiedAnnotation(sig, signature.SigstoreRekorInclusionProofAnnotationKey, "this is invalid"))
+	assert.Error(t, err)
+	assert.Equal(t, sarRejected, sar)
+
+	// A checkpoint with an unexpected origin
+	sar, err = newPRWithOrigin(true, "other.example.com").isSignatureAccepted(context.Background(), image, sig)
+	assert.Error(t, err)
+	assert.Equal(t, sarRejected, sar)
+
+	// A proof from a different log
+	otherSig, _, _ := testSigstoreSignatureWithRekorInclusionProof(t)
+	sar, err = newPR(true).isSignatureAccepted(context.Background(), image,
+		sigstoreSignatureWithModifiedAnnotation(sig, signature.SigstoreRekorInclusionProofAnnotationKey,
+			otherSig.UntrustedAnnotations()[signature.SigstoreRekorInclusionProofAnnotationKey]))
+	assert.Error(t, err)
+	assert.Equal(t, sarRejected, sar)
+}
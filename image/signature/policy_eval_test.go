@@ -23,6 +23,24 @@ func TestPolicyRequirementError(t *testing.T) {
 	assert.Equal(t, s, err.Error())
 }
 
+func TestPolicyRequirementType(t *testing.T) {
+	signedBy, err := NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "/dev/null", NewPRMMatchRepository())
+	require.NoError(t, err)
+	sigstoreSigned, err := NewPRSigstoreSignedKeyPath("/dev/null", NewPRMMatchRepository())
+	require.NoError(t, err)
+	for _, c := range []struct {
+		req      PolicyRequirement
+		expected string
+	}{
+		{NewPRInsecureAcceptAnything(), "insecureAcceptAnything"},
+		{NewPRReject(), "reject"},
+		{signedBy, "signedBy"},
+		{sigstoreSigned, "sigstoreSigned"},
+	} {
+		assert.Equal(t, c.expected, c.req.RequirementType())
+	}
+}
+
 func TestPolicyContextChangeState(t *testing.T) {
 	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{NewPRReject()}})
 	require.NoError(t, err)
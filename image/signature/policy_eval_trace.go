@@ -0,0 +1,121 @@
+// This defines an API for explaining policy evaluation decisions.
+
+package signature
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/internal/unparsedimage"
+	"go.podman.io/image/v5/types"
+)
+
+// PolicyEvaluationTrace describes how PolicyContext.EvaluateWithTrace reached its decision about an image.
+// It is intended to be shown to users, e.g. to explain why an image was rejected;
+// it should not be used to make any further decisions.
+type PolicyEvaluationTrace struct {
+	// Image is a human-readable description of the evaluated image identity.
+	Image string `json:"image"`
+	// Scope identifies the policy section which was used for the image.
+	Scope PolicyScopeMatch `json:"scope"`
+	// Requirements contains the evaluated requirements of the policy section, in order.
+	// Evaluation stops at the first requirement which rejects the image, so requirements after that one are not included.
+	Requirements []PolicyRequirementTrace `json:"requirements"`
+	// SignatureVerificationRequiredButMissing is true if all requirements allowed the image, but
+	// PolicyContext.RequireSignatureVerification was set and none of the requirements verified a signature.
+	SignatureVerificationRequiredButMissing bool `json:"signatureVerificationRequiredButMissing,omitempty"`
+	// Allowed is the overall decision, the same as the return value of IsRunningImageAllowed.
+	Allowed bool `json:"allowed"`
+	// Reason is the error text explaining why the image was not allowed, if !Allowed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// PolicyScopeMatch identifies a policy section which applies to an image.
+type PolicyScopeMatch struct {
+	// Default is true if the image does not match any scope in Policy.Transports, and Policy.Default is used.
+	Default bool `json:"default,omitempty"`
+	// Transport is the transport name in Policy.Transports, if !Default.
+	Transport string `json:"transport,omitempty"`
+	// Scope is the matching scope within Policy.Transports[Transport], if !Default.
+	// The transport-wide default scope is represented as "".
+	Scope string `json:"scope,omitempty"`
+}
+
+// PolicyRequirementTrace describes evaluation of a single PolicyRequirement.
+type PolicyRequirementTrace struct {
+	// Type is the "type" value of the requirement in policy.json.
+	Type string `json:"type"`
+	// VerifiesSignatures is true if the requirement performs cryptographic signature verification.
+	VerifiesSignatures bool `json:"verifiesSignatures"`
+	// Signatures contains the signatures considered by the requirement, in the order they were evaluated,
+	// if the requirement verifies signatures.
+	// Evaluation stops at the first accepted signature, so signatures after that one are not included.
+	Signatures []PolicySignatureTrace `json:"signatures,omitempty"`
+	// Allowed is true if the requirement allowed the image.
+	Allowed bool `json:"allowed"`
+	// Reason is the error text explaining why the requirement rejected the image, if !Allowed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// PolicySignatureTrace describes evaluation of a single signature by a PolicyRequirement.
+type PolicySignatureTrace struct {
+	// Index is the index of the signature among all signatures of the image.
+	Index int `json:"index"`
+	// Accepted is true if the signature satisfied the requirement.
+	Accepted bool `json:"accepted"`
+	// Reason is the error text explaining why the signature was not accepted or not considered, if !Accepted.
+	Reason string `json:"reason,omitempty"`
+}
+
+// policyRequirementWithSignatureTrace is implemented by PolicyRequirement values which can describe their
+// evaluation of individual signatures.
+type policyRequirementWithSignatureTrace interface {
+	// isRunningImageAllowedWithTrace is the same as isRunningImageAllowed, but it also records
+	// the signatures it evaluated in trace.Signatures.
+	isRunningImageAllowedWithTrace(ctx context.Context, image private.UnparsedImage, trace *PolicyRequirementTrace) (bool, error)
+}
+
+// recordSignature appends an entry about signature number index to trace, if trace is not nil.
+func (trace *PolicyRequirementTrace) recordSignature(index int, accepted bool, reason error) {
+	if trace == nil {
+		return
+	}
+	entry := PolicySignatureTrace{Index: index, Accepted: accepted}
+	if reason != nil {
+		entry.Reason = reason.Error()
+	}
+	trace.Signatures = append(trace.Signatures, entry)
+}
+
+// EvaluateWithTrace evaluates the policy for an image the same way as IsRunningImageAllowed,
+// and returns a description of the evaluation, suitable for explaining the decision to users.
+// The returned error is the same as the one returned by IsRunningImageAllowed; the trace is non-nil
+// whenever the policy was actually evaluated, even if the image was rejected.
+// WARNING: This validates signatures and the manifest, but does not download or validate the
+// layers. Users must validate that the layers match their expected digests.
+func (pc *PolicyContext) EvaluateWithTrace(ctx context.Context, publicImage types.UnparsedImage) (trace *PolicyEvaluationTrace, finalErr error) {
+	if err := pc.changeState(pcReady, pcInUse); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := pc.changeState(pcInUse, pcReady); err != nil {
+			trace = nil
+			finalErr = err
+		}
+	}()
+
+	image := unparsedimage.FromPublic(publicImage)
+
+	logrus.Debugf("EvaluateWithTrace for image %s", policyIdentityLogName(image.Reference()))
+	trace = &PolicyEvaluationTrace{
+		Image:        policyIdentityLogName(image.Reference()),
+		Requirements: []PolicyRequirementTrace{},
+	}
+	allowed, err := pc.isRunningImageAllowed(ctx, image, trace)
+	trace.Allowed = allowed
+	if err != nil {
+		trace.Reason = err.Error()
+	}
+	return trace, err
+}
@@ -0,0 +1,115 @@
+package signature
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyContextEvaluateWithTrace(t *testing.T) {
+	pc, err := NewPolicyContext(&Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"docker.io/testing/manifest:latest": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact()),
+				},
+				"docker.io/testing/manifest:allowDeny": {
+					xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepository()),
+					NewPRReject(),
+					NewPRInsecureAcceptAnything(),
+				},
+				"docker.io/testing": {
+					NewPRInsecureAcceptAnything(),
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	// 1 invalid, 1 valid signature (in this order)
+	img := pcImageMock(t, "fixtures/dir-img-mixed", "testing/manifest:latest")
+	trace, err := pc.EvaluateWithTrace(context.Background(), img)
+	require.NoError(t, err)
+	assert.True(t, trace.Allowed)
+	assert.Empty(t, trace.Reason)
+	assert.Equal(t, "docker:docker.io/testing/manifest:latest", trace.Image)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "docker.io/testing/manifest:latest"}, trace.Scope)
+	require.Len(t, trace.Requirements, 1)
+	req := trace.Requirements[0]
+	assert.Equal(t, "signedBy", req.Type)
+	assert.True(t, req.VerifiesSignatures)
+	assert.True(t, req.Allowed)
+	require.Len(t, req.Signatures, 2)
+	assert.Equal(t, 0, req.Signatures[0].Index)
+	assert.False(t, req.Signatures[0].Accepted)
+	assert.NotEmpty(t, req.Signatures[0].Reason)
+	assert.Equal(t, PolicySignatureTrace{Index: 1, Accepted: true}, req.Signatures[1])
+
+	// Evaluation stops at the first rejecting requirement
+	img = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:allowDeny")
+	trace, err = pc.EvaluateWithTrace(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, trace.Allowed, err)
+	assert.Equal(t, err.Error(), trace.Reason)
+	require.Len(t, trace.Requirements, 2)
+	assert.True(t, trace.Requirements[0].Allowed)
+	assert.Equal(t, "reject", trace.Requirements[1].Type)
+	assert.False(t, trace.Requirements[1].VerifiesSignatures)
+	assert.False(t, trace.Requirements[1].Allowed)
+	assert.Equal(t, err.Error(), trace.Requirements[1].Reason)
+	assert.Empty(t, trace.Requirements[1].Signatures)
+
+	// No signatures
+	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")
+	trace, err = pc.EvaluateWithTrace(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, trace.Allowed, err)
+	require.Len(t, trace.Requirements, 1)
+	assert.False(t, trace.Requirements[0].Allowed)
+	assert.Empty(t, trace.Requirements[0].Signatures)
+
+	// A namespace match
+	img = pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:other")
+	trace, err = pc.EvaluateWithTrace(context.Background(), img)
+	require.NoError(t, err)
+	assert.True(t, trace.Allowed)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "docker.io/testing"}, trace.Scope)
+	require.Len(t, trace.Requirements, 1)
+	assert.Equal(t, PolicyRequirementTrace{Type: "insecureAcceptAnything", Allowed: true}, trace.Requirements[0])
+
+	// RequireSignatureVerification
+	pc.RequireSignatureVerification(true)
+	trace, err = pc.EvaluateWithTrace(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, trace.Allowed, err)
+	assert.True(t, trace.SignatureVerificationRequiredButMissing)
+	assert.True(t, trace.Requirements[0].Allowed)
+	pc.RequireSignatureVerification(false)
+
+	// Unexpected state (context already destroyed)
+	destroyedPC, err := NewPolicyContext(pc.Policy)
+	require.NoError(t, err)
+	err = destroyedPC.Destroy()
+	require.NoError(t, err)
+	trace, err = destroyedPC.EvaluateWithTrace(context.Background(), img)
+	assert.Error(t, err)
+	assert.Nil(t, trace)
+}
+
+func TestPolicyContextEvaluateWithTraceDefault(t *testing.T) {
+	pc, err := NewPolicyContext(&Policy{Default: PolicyRequirements{NewPRReject()}})
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	img := pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	trace, err := pc.EvaluateWithTrace(context.Background(), img)
+	assertRunningRejectedPolicyRequirement(t, trace.Allowed, err)
+	assert.Equal(t, PolicyScopeMatch{Default: true}, trace.Scope)
+}
@@ -0,0 +1,294 @@
+// This defines an API for finding likely mistakes in a Policy.
+
+package signature
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+)
+
+// PolicyLintIssueKind identifies a kind of a likely mistake found by LintPolicy.
+type PolicyLintIssueKind string
+
+const (
+	// PolicyLintInsecureUnderSignedScope is a scope containing "insecureAcceptAnything" and not verifying signatures,
+	// which overrides a broader scope that does verify signatures.
+	PolicyLintInsecureUnderSignedScope PolicyLintIssueKind = "insecureUnderSignedScope"
+	// PolicyLintShadowingScope is a scope not verifying signatures which overrides a broader scope that does verify signatures.
+	// Note that requirements of scopes are not combined; only the most specific scope applies to an image.
+	PolicyLintShadowingScope PolicyLintIssueKind = "shadowingScope"
+	// PolicyLintUnreadablePath is a path to a key or a certificate which can’t be read.
+	PolicyLintUnreadablePath PolicyLintIssueKind = "unreadablePath"
+	// PolicyLintInvalidCertificate is a certificate which can’t be parsed.
+	PolicyLintInvalidCertificate PolicyLintIssueKind = "invalidCertificate"
+	// PolicyLintExpiredCertificate is a certificate which has expired, or is not valid yet.
+	PolicyLintExpiredCertificate PolicyLintIssueKind = "expiredCertificate"
+)
+
+// PolicyLintIssue is a likely mistake in a Policy, found by LintPolicy.
+type PolicyLintIssue struct {
+	Kind PolicyLintIssueKind `json:"kind"`
+	// Scope identifies the policy section containing the issue.
+	Scope PolicyScopeMatch `json:"scope"`
+	// Requirement is the index of the relevant requirement within the policy section, or -1 if the issue concerns the section as a whole.
+	Requirement int `json:"requirement"`
+	// Message is a human-readable description of the issue.
+	Message string `json:"message"`
+}
+
+// String returns a human-readable description of the issue, including its location.
+func (issue PolicyLintIssue) String() string {
+	location := issue.Scope.String()
+	if issue.Requirement >= 0 {
+		location = fmt.Sprintf("%s, requirement %d", location, issue.Requirement)
+	}
+	return fmt.Sprintf("%s: %s", location, issue.Message)
+}
+
+// String returns a human-readable description of the policy section.
+func (scope PolicyScopeMatch) String() string {
+	if scope.Default {
+		return `"default"`
+	}
+	return fmt.Sprintf("transport %q scope %q", scope.Transport, scope.Scope)
+}
+
+// LintPolicy checks policy for likely mistakes, in particular:
+//   - narrower scopes which do not verify signatures, overriding broader scopes which do
+//   - key and certificate files which can’t be read
+//   - certificates which are invalid or expired
+//
+// The issues are returned in a deterministic order. An empty return value does not mean that the policy is
+// correct or secure, only that no mistakes were detected.
+func LintPolicy(policy *Policy) []PolicyLintIssue {
+	return lintPolicy(policy, time.Now())
+}
+
+// lintPolicy is LintPolicy, evaluating certificate validity at now.
+func lintPolicy(policy *Policy, now time.Time) []PolicyLintIssue {
+	issues := []PolicyLintIssue{}
+	issues = append(issues, lintPolicyRequirements(PolicyScopeMatch{Default: true}, policy.Default, now)...)
+	for _, transportName := range slices.Sorted(maps.Keys(policy.Transports)) {
+		transportScopes := policy.Transports[transportName]
+		for _, scopeName := range slices.Sorted(maps.Keys(transportScopes)) {
+			scope := PolicyScopeMatch{Transport: transportName, Scope: scopeName}
+			reqs := transportScopes[scopeName]
+			parentScope, parentReqs := broaderPolicyScope(policy, transportName, scopeName)
+			if issue, ok := lintScopeOverride(scope, reqs, parentScope, parentReqs); ok {
+				issues = append(issues, issue)
+			}
+			issues = append(issues, lintPolicyRequirements(scope, reqs, now)...)
+		}
+	}
+	return issues
+}
+
+// broaderPolicyScope returns the policy section which would apply to images in scopeName of transportName,
+// if scopeName were not present in the policy.
+func broaderPolicyScope(policy *Policy, transportName, scopeName string) (PolicyScopeMatch, PolicyRequirements) {
+	if scopeName != "" {
+		transportScopes := policy.Transports[transportName]
+		best := ""
+		found := false
+		for candidate := range transportScopes {
+			if candidate == "" || !isBroaderPolicyScope(candidate, scopeName) {
+				continue
+			}
+			if !found || isMoreSpecificPolicyScope(candidate, best) {
+				best = candidate
+				found = true
+			}
+		}
+		if !found {
+			_, found = transportScopes[""]
+		}
+		if found {
+			return PolicyScopeMatch{Transport: transportName, Scope: best}, transportScopes[best]
+		}
+	}
+	return PolicyScopeMatch{Default: true}, policy.Default
+}
+
+// isBroaderPolicyScope returns true if scope parent contains scope child.
+// This follows the conventions of PolicyConfigurationNamespaces of transports in this repository:
+// parent namespaces are prefixes of child namespaces, separated by '/', '@', or ':' (only after a repository path,
+// a ':' after a host name starts a port number), and parent namespaces may be wildcards of the form *.example.com,
+// matching hosts in the child.
+func isBroaderPolicyScope(parent, child string) bool {
+	if parent == child {
+		return false
+	}
+	if wildcardSuffix, ok := strings.CutPrefix(parent, "*"); ok && strings.HasPrefix(wildcardSuffix, ".") {
+		childHost, _, _ := strings.Cut(child, "/")
+		childHost = strings.TrimPrefix(childHost, "*")
+		return childHost != wildcardSuffix && strings.HasSuffix(childHost, wildcardSuffix)
+	}
+	if len(child) <= len(parent) || !strings.HasPrefix(child, parent) {
+		return false
+	}
+	switch child[len(parent)] {
+	case '/', '@':
+		return true
+	case ':':
+		return strings.ContainsRune(parent, '/')
+	default:
+		return false
+	}
+}
+
+// isMoreSpecificPolicyScope returns true if a is more specific than b, assuming they both contain the same scope.
+// Wildcard scopes are matched only after all non-wildcard scopes.
+func isMoreSpecificPolicyScope(a, b string) bool {
+	aWildcard, bWildcard := strings.HasPrefix(a, "*."), strings.HasPrefix(b, "*.")
+	if aWildcard != bWildcard {
+		return !aWildcard
+	}
+	return len(a) > len(b)
+}
+
+// lintScopeOverride checks whether reqs for scope override signature verification required by parentReqs for parentScope.
+func lintScopeOverride(scope PolicyScopeMatch, reqs PolicyRequirements, parentScope PolicyScopeMatch, parentReqs PolicyRequirements) (PolicyLintIssue, bool) {
+	if !policyRequirementsVerifySignatures(parentReqs) || policyRequirementsVerifySignatures(reqs) {
+		return PolicyLintIssue{}, false
+	}
+	insecure := false
+	allReject := len(reqs) != 0
+	for _, req := range reqs {
+		switch req.(type) {
+		case *prInsecureAcceptAnything:
+			insecure = true
+			allReject = false
+		case *prReject:
+		default:
+			allReject = false
+		}
+	}
+	switch {
+	case insecure:
+		return PolicyLintIssue{
+			Kind:        PolicyLintInsecureUnderSignedScope,
+			Scope:       scope,
+			Requirement: -1,
+			Message:     fmt.Sprintf("accepts images without verifying signatures, overriding the signature requirements of %s", parentScope.String()),
+		}, true
+	case allReject:
+		return PolicyLintIssue{}, false // Rejecting everything is stricter than the parent scope; that’s presumably intentional.
+	default:
+		return PolicyLintIssue{
+			Kind:        PolicyLintShadowingScope,
+			Scope:       scope,
+			Requirement: -1,
+			Message:     fmt.Sprintf("does not verify signatures, and overrides (does not add to) the signature requirements of %s", parentScope.String()),
+		}, true
+	}
+}
+
+// policyRequirementsVerifySignatures returns true if at least one of reqs verifies signatures.
+func policyRequirementsVerifySignatures(reqs PolicyRequirements) bool {
+	for _, req := range reqs {
+		if req.verifiesSignatures() {
+			return true
+		}
+	}
+	return false
+}
+
+// lintPolicyRequirements checks reqs, which are the requirements for scope, for unusable keys and certificates.
+func lintPolicyRequirements(scope PolicyScopeMatch, reqs PolicyRequirements, now time.Time) []PolicyLintIssue {
+	issues := []PolicyLintIssue{}
+	for reqNumber, req := range reqs {
+		newIssue := func(kind PolicyLintIssueKind, format string, a ...any) {
+			issues = append(issues, PolicyLintIssue{
+				Kind:        kind,
+				Scope:       scope,
+				Requirement: reqNumber,
+				Message:     fmt.Sprintf(format, a...),
+			})
+		}
+		// readPath returns the contents of path, or nil if it can’t be read.
+		readPath := func(field, path string) []byte {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				newIssue(PolicyLintUnreadablePath, "%s %q can’t be read: %v", field, path, err)
+				return nil
+			}
+			return data
+		}
+		checkCertificates := func(field string, pemData []byte) {
+			certs, err := cryptoutils.UnmarshalCertificatesFromPEM(pemData)
+			if err != nil {
+				newIssue(PolicyLintInvalidCertificate, "%s contains invalid certificates: %v", field, err)
+				return
+			}
+			if len(certs) == 0 {
+				newIssue(PolicyLintInvalidCertificate, "%s does not contain any certificates", field)
+				return
+			}
+			for _, cert := range certs {
+				switch {
+				case now.After(cert.NotAfter):
+					newIssue(PolicyLintExpiredCertificate, "%s contains a certificate for %q which expired at %s", field, cert.Subject.String(), cert.NotAfter.Format(time.RFC3339))
+				case now.Before(cert.NotBefore):
+					newIssue(PolicyLintExpiredCertificate, "%s contains a certificate for %q which is not valid before %s", field, cert.Subject.String(), cert.NotBefore.Format(time.RFC3339))
+				}
+			}
+		}
+		checkCertificatesPath := func(field, path string) {
+			if data := readPath(field, path); data != nil {
+				checkCertificates(field, data)
+			}
+		}
+
+		switch r := req.(type) {
+		case *prSignedBy:
+			if r.KeyPath != "" {
+				readPath("keyPath", r.KeyPath)
+			}
+			for _, path := range r.KeyPaths {
+				readPath("keyPaths", path)
+			}
+		case *prSigstoreSigned:
+			if r.KeyPath != "" {
+				readPath("keyPath", r.KeyPath)
+			}
+			for _, path := range r.KeyPaths {
+				readPath("keyPaths", path)
+			}
+			if r.RekorPublicKeyPath != "" {
+				readPath("rekorPublicKeyPath", r.RekorPublicKeyPath)
+			}
+			for _, path := range r.RekorPublicKeyPaths {
+				readPath("rekorPublicKeyPaths", path)
+			}
+			if f, ok := r.Fulcio.(*prSigstoreSignedFulcio); ok && f != nil {
+				if f.CAPath != "" {
+					checkCertificatesPath("fulcio.caPath", f.CAPath)
+				}
+				if f.CAData != nil {
+					checkCertificates("fulcio.caData", f.CAData)
+				}
+			}
+			if p, ok := r.PKI.(*prSigstoreSignedPKI); ok && p != nil {
+				if p.CARootsPath != "" {
+					checkCertificatesPath("pki.caRootsPath", p.CARootsPath)
+				}
+				if p.CARootsData != nil {
+					checkCertificates("pki.caRootsData", p.CARootsData)
+				}
+				if p.CAIntermediatesPath != "" {
+					checkCertificatesPath("pki.caIntermediatesPath", p.CAIntermediatesPath)
+				}
+				if p.CAIntermediatesData != nil {
+					checkCertificates("pki.caIntermediatesData", p.CAIntermediatesData)
+				}
+			}
+		}
+	}
+	return issues
+}
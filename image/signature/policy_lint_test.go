@@ -0,0 +1,129 @@
+package signature
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsBroaderPolicyScope(t *testing.T) {
+	for _, c := range []struct {
+		parent, child string
+		expected      bool
+	}{
+		{"docker.io", "docker.io/library/busybox", true},
+		{"docker.io/library", "docker.io/library/busybox", true},
+		{"docker.io/library/busybox", "docker.io/library/busybox:latest", true},
+		{"docker.io/library/busybox", "docker.io/library/busybox@sha256:0000000000000000000000000000000000000000000000000000000000000000", true},
+		{"docker.io/library/busybox", "docker.io/library/busybox", false},
+		{"docker.io/library/busybox", "docker.io/library/busybox2", false},
+		{"docker.io/library/busybox:latest", "docker.io/library/busybox", false},
+		{"docker.io", "docker.io:5000/busybox", false},
+		{"localhost", "localhost:5000", false},
+		{"localhost:5000", "localhost:5000/busybox", true},
+		{"localhost:5000/busybox", "localhost:5000/busybox:latest", true},
+		{"*.io", "docker.io/library/busybox", true},
+		{"*.io", "*.docker.io", true},
+		{"*.docker.io", "*.io", false},
+		{"*.io", "*.io", false},
+		{"*.docker.io", "docker.io/library", false},
+		{"/var/lib", "/var/lib/image", true},
+		{"/var/lib", "/var/library", false},
+	} {
+		assert.Equal(t, c.expected, isBroaderPolicyScope(c.parent, c.child), "%q vs. %q", c.parent, c.child)
+	}
+}
+
+func TestLintPolicy(t *testing.T) {
+	signed := xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchRepoDigestOrExact())
+	fulcio, err := NewPRSigstoreSignedFulcio(
+		PRSigstoreSignedFulcioWithCAPath("fixtures/fulcio_v1.crt.pem"),
+		PRSigstoreSignedFulcioWithOIDCIssuer("https://github.com/login/oauth"),
+		PRSigstoreSignedFulcioWithSubjectEmail("mitr@redhat.com"),
+	)
+	require.NoError(t, err)
+	sigstoreFulcio := xNewPRSigstoreSigned(
+		PRSigstoreSignedWithFulcio(fulcio),
+		PRSigstoreSignedWithRekorPublicKeyPath("fixtures/this/does/not/exist"),
+		PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepository()),
+	)
+
+	policy := &Policy{
+		Default: PolicyRequirements{NewPRReject()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"":                          {NewPRInsecureAcceptAnything()},
+				"quay.io":                   {signed},
+				"quay.io/ns":                {NewPRReject()},
+				"quay.io/ns/insecure":       {NewPRInsecureAcceptAnything()}, // Overrides quay.io/ns, not quay.io
+				"quay.io/ns3/insecure":      {NewPRInsecureAcceptAnything()},
+				"quay.io/ns2/baselayer":     {xNewPRSignedBaseLayer(NewPRMMatchRepository())},
+				"quay.io/ns2/signed":        {sigstoreFulcio},
+				"*.example.com":             {signed},
+				"registry.example.com/repo": {NewPRInsecureAcceptAnything()},
+			},
+		},
+	}
+
+	// Everything is valid now.
+	issues := lintPolicy(policy, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	kinds := []PolicyLintIssueKind{}
+	for _, issue := range issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	assert.Equal(t, []PolicyLintIssueKind{
+		PolicyLintShadowingScope,           // quay.io/ns2/baselayer overrides quay.io
+		PolicyLintUnreadablePath,           // quay.io/ns2/signed rekorPublicKeyPath
+		PolicyLintInsecureUnderSignedScope, // quay.io/ns3/insecure overrides quay.io
+		PolicyLintInsecureUnderSignedScope, // registry.example.com/repo overrides *.example.com
+	}, kinds)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "quay.io/ns2/baselayer"}, issues[0].Scope)
+	assert.Equal(t, -1, issues[0].Requirement)
+	assert.Contains(t, issues[0].Message, `transport "docker" scope "quay.io"`)
+	assert.Equal(t, PolicyLintIssue{
+		Kind:        PolicyLintUnreadablePath,
+		Scope:       PolicyScopeMatch{Transport: "docker", Scope: "quay.io/ns2/signed"},
+		Requirement: 0,
+		Message:     issues[1].Message,
+	}, issues[1])
+	assert.Contains(t, issues[1].Message, "rekorPublicKeyPath")
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "quay.io/ns3/insecure"}, issues[2].Scope)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "registry.example.com/repo"}, issues[3].Scope)
+	assert.Contains(t, issues[3].Message, `transport "docker" scope "*.example.com"`)
+
+	// The Fulcio certificate expires in 2031.
+	issues = lintPolicy(policy, time.Date(2032, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Len(t, issues, 5)
+	assert.Equal(t, PolicyLintExpiredCertificate, issues[2].Kind)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker", Scope: "quay.io/ns2/signed"}, issues[2].Scope)
+	assert.Contains(t, issues[2].String(), `transport "docker" scope "quay.io/ns2/signed", requirement 0: fulcio.caPath`)
+
+	// Invalid certificates
+	pki, err := NewPRSigstoreSignedPKI(
+		PRSigstoreSignedPKIWithCARootsData([]byte("this is not a certificate")),
+		PRSigstoreSignedPKIWithCAIntermediatesPath("fixtures/pki_intermediate_crts.pem"),
+		PRSigstoreSignedPKIWithSubjectEmail("qiwan@redhat.com"),
+	)
+	require.NoError(t, err)
+	issues = lintPolicy(&Policy{
+		Default: PolicyRequirements{xNewPRSigstoreSigned(PRSigstoreSignedWithPKI(pki), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepository()))},
+	}, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Len(t, issues, 1)
+	assert.Equal(t, PolicyLintInvalidCertificate, issues[0].Kind)
+	assert.Equal(t, PolicyScopeMatch{Default: true}, issues[0].Scope)
+
+	// A transport-wide scope overriding the default
+	issues = LintPolicy(&Policy{
+		Default: PolicyRequirements{signed},
+		Transports: map[string]PolicyTransportScopes{
+			"docker-daemon": {"": {NewPRInsecureAcceptAnything()}},
+			"oci":           {"": {NewPRReject()}},
+		},
+	})
+	require.Len(t, issues, 1)
+	assert.Equal(t, PolicyLintInsecureUnderSignedScope, issues[0].Kind)
+	assert.Equal(t, PolicyScopeMatch{Transport: "docker-daemon", Scope: ""}, issues[0].Scope)
+	assert.Contains(t, issues[0].String(), `"default"`)
+}
@@ -140,6 +140,17 @@ type prSigstoreSigned struct {
 	// If Fulcio is used, one of RekorPublicKeyPath, RekorPublicKeyPaths, RekorPublicKeyData and RekorPublicKeyDatas must be specified as well;
 	// otherwise it is optional (and Rekor inclusion is not required if a Rekor public key is not specified).
 	RekorPublicKeyDatas [][]byte `json:"rekorPublicKeyDatas,omitempty"`
+	// RekorInclusionProofRequired, if true, requires signatures to contain a Rekor inclusion proof and a checkpoint signed by one of the
+	// Rekor public keys, in addition to the signed entry timestamp. The proof is verified offline, using only data in the signature.
+	// One of RekorPublicKeyPath, RekorPublicKeyPaths, RekorPublicKeyData and RekorPublicKeyDatas must be specified if this is set.
+	RekorInclusionProofRequired bool `json:"rekorInclusionProofRequired,omitempty"`
+	// RekorCheckpointOrigin is the origin of the Rekor log which must have signed the checkpoint of the inclusion proof,
+	// either complete (e.g. "rekor.sigstore.dev - 1193050959916656506"), or only the log name (e.g. "rekor.sigstore.dev"),
+	// accepting checkpoints of all shards of the log.
+	// This must be specified if, and only if, RekorInclusionProofRequired is set.
+	RekorCheckpointOrigin string `json:"rekorCheckpointOrigin,omitempty"`
+	// rekorInclusionProofRequiredSet is true if PRSigstoreSignedWithRekorInclusionProofRequired was used, to detect duplicates.
+	rekorInclusionProofRequiredSet bool
 
 	// PKI specifies which PKI-generated certificates are accepted. Exactly one of KeyPath, KeyPaths, KeyData, KeyDatas, Fulcio, and PKI must be specified.
 	PKI PRSigstoreSignedPKI `json:"pki,omitempty"`
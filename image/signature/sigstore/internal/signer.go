@@ -29,7 +29,8 @@ type SigstoreSigner struct {
 	FulcioGeneratedCertificateChain []byte // Or nil
 
 	// Rekor state
-	RekorUploader func(ctx context.Context, keyOrCertBytes []byte, signatureBytes []byte, payloadBytes []byte) ([]byte, error) // Or nil
+	// RekorUploader returns a SET, and an inclusion proof (or nil, if the server did not provide one).
+	RekorUploader func(ctx context.Context, keyOrCertBytes []byte, signatureBytes []byte, payloadBytes []byte) ([]byte, []byte, error) // Or nil
 }
 
 // ProgressMessage returns a human-readable sentence that makes sense to write before starting to create a single signature.
@@ -66,13 +67,14 @@ func (s *SigstoreSigner) SignImageManifest(ctx context.Context, m []byte, docker
 		return nil, fmt.Errorf("creating signature: %w", err)
 	}
 	base64Signature := base64.StdEncoding.EncodeToString(signatureBytes)
-	var rekorSETBytes []byte // = nil
+	var rekorSETBytes, rekorInclusionProofBytes []byte // = nil
 	if s.RekorUploader != nil {
-		set, err := s.RekorUploader(ctx, s.SigningKeyOrCert, signatureBytes, payloadBytes)
+		set, inclusionProof, err := s.RekorUploader(ctx, s.SigningKeyOrCert, signatureBytes, payloadBytes)
 		if err != nil {
 			return nil, err
 		}
 		rekorSETBytes = set
+		rekorInclusionProofBytes = inclusionProof
 	}
 
 	annotations := map[string]string{
@@ -87,6 +89,9 @@ func (s *SigstoreSigner) SignImageManifest(ctx context.Context, m []byte, docker
 	if rekorSETBytes != nil {
 		annotations[signature.SigstoreSETAnnotationKey] = string(rekorSETBytes)
 	}
+	if rekorInclusionProofBytes != nil {
+		annotations[signature.SigstoreRekorInclusionProofAnnotationKey] = string(rekorInclusionProofBytes)
+	}
 	return signature.SigstoreFromComponents(signature.SigstoreSignatureMIMEType, payloadBytes, annotations), nil
 }
 
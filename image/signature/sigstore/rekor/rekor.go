@@ -98,6 +98,25 @@ func rekorEntryToSET(entry *rekorLogEntryAnon) (internal.UntrustedRekorSET, erro
 	}, nil
 }
 
+// rekorEntryToInclusionProof converts the inclusion proof in a Rekor log entry into the format used in sigstore signatures.
+// It returns nil if the entry does not contain a complete inclusion proof.
+func rekorEntryToInclusionProof(entry *rekorLogEntryAnon) *internal.UntrustedRekorInclusionProof {
+	if entry.Verification == nil || entry.Verification.InclusionProof == nil {
+		return nil
+	}
+	proof := entry.Verification.InclusionProof
+	if proof.Checkpoint == nil || proof.LogIndex == nil || proof.RootHash == nil || proof.TreeSize == nil {
+		return nil
+	}
+	return &internal.UntrustedRekorInclusionProof{
+		LogIndex:   *proof.LogIndex,
+		RootHash:   *proof.RootHash,
+		TreeSize:   *proof.TreeSize,
+		Hashes:     proof.Hashes,
+		Checkpoint: *proof.Checkpoint,
+	}
+}
+
 // uploadEntry ensures proposedEntry exists in Rekor (usually uploading it), and returns the resulting log entry.
 func (r *rekorClient) uploadEntry(ctx context.Context, proposedEntry rekorProposedEntry) (rekorLogEntry, error) {
 	logrus.Debugf("Calling Rekor's CreateLogEntry")
@@ -134,8 +153,9 @@ func stringPointer(s string) *string {
 }
 
 // uploadKeyOrCert integrates this code into sigstore/internal.Signer.
-// Given components of the created signature, it returns a SET that should be added to the signature.
-func (r *rekorClient) uploadKeyOrCert(ctx context.Context, keyOrCertBytes []byte, signatureBytes []byte, payloadBytes []byte) ([]byte, error) {
+// Given components of the created signature, it returns a SET that should be added to the signature,
+// and an inclusion proof, if the server provided one (or nil).
+func (r *rekorClient) uploadKeyOrCert(ctx context.Context, keyOrCertBytes []byte, signatureBytes []byte, payloadBytes []byte) ([]byte, []byte, error) {
 	payloadHash := sha256.Sum256(payloadBytes) // Consistent with cosign.
 	hashedRekordSpec, err := json.Marshal(internal.RekorHashedrekordV001Schema{
 		Data: &internal.RekorHashedrekordV001SchemaData{
@@ -152,7 +172,7 @@ func (r *rekorClient) uploadKeyOrCert(ctx context.Context, keyOrCertBytes []byte
 		},
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	proposedEntry := internal.RekorHashedrekord{
 		APIVersion: stringPointer(internal.RekorHashedRekordV001APIVersion),
@@ -161,11 +181,11 @@ func (r *rekorClient) uploadKeyOrCert(ctx context.Context, keyOrCertBytes []byte
 
 	uploadedPayload, err := r.uploadEntry(ctx, &proposedEntry)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(uploadedPayload) != 1 {
-		return nil, fmt.Errorf("expected 1 Rekor entry, got %d", len(uploadedPayload))
+		return nil, nil, fmt.Errorf("expected 1 Rekor entry, got %d", len(uploadedPayload))
 	}
 	var storedEntry *rekorLogEntryAnon
 	// This “loop” extracts the single value from the uploadedPayload map.
@@ -176,11 +196,20 @@ func (r *rekorClient) uploadKeyOrCert(ctx context.Context, keyOrCertBytes []byte
 
 	rekorBundle, err := rekorEntryToSET(storedEntry)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	rekorSETBytes, err := json.Marshal(rekorBundle)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	var inclusionProofBytes []byte // = nil
+	if inclusionProof := rekorEntryToInclusionProof(storedEntry); inclusionProof != nil {
+		inclusionProofBytes, err = json.Marshal(inclusionProof)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		logrus.Debugf("Rekor did not return an inclusion proof")
 	}
-	return rekorSETBytes, nil
+	return rekorSETBytes, inclusionProofBytes, nil
 }
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -104,7 +106,7 @@ func Test_rekorUploadKeyOrCert(t *testing.T) {
 			currentTime := time.Now()
 
 			// with go 1.24 this should use t.Context()
-			got, err := cl.uploadKeyOrCert(context.Background(), tt.args.keyOrCertBytes, signatureBytes, tt.args.payloadBytes)
+			got, gotInclusionProof, err := cl.uploadKeyOrCert(context.Background(), tt.args.keyOrCertBytes, signatureBytes, tt.args.payloadBytes)
 			if tt.wantErr != "" {
 				assert.ErrorContains(t, err, tt.wantErr)
 				return
@@ -119,6 +121,16 @@ func Test_rekorUploadKeyOrCert(t *testing.T) {
 			// time.Now() has nanosecond precision while the rekor time is constructed of the unix seconds.
 			// That is why we can only compare the full unix seconds here.
 			assert.GreaterOrEqual(t, tm.Unix(), currentTime.Unix(), "time: %s after rekor time: %s", currentTime, tm)
+
+			// Rekor servers provide inclusion proofs since v1.1; if one was returned, it must be valid.
+			if gotInclusionProof != nil {
+				// The origin depends on the server configuration; this only checks that the proof is consistent with it.
+				var proof internal.UntrustedRekorInclusionProof
+				require.NoError(t, json.Unmarshal(gotInclusionProof, &proof))
+				origin, _, _ := strings.Cut(proof.Checkpoint, "\n")
+				err = internal.VerifyRekorInclusionProof(rekorKeysECDSA, origin, got, gotInclusionProof)
+				require.NoError(t, err)
+			}
 		})
 	}
 }
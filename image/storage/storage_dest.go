@@ -19,10 +19,12 @@ import (
 	digest "github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/docker"
 	"go.podman.io/image/v5/docker/reference"
 	"go.podman.io/image/v5/internal/image"
 	"go.podman.io/image/v5/internal/imagedestination/impl"
 	"go.podman.io/image/v5/internal/imagedestination/stubs"
+	"go.podman.io/image/v5/internal/imagesource"
 	srcImpl "go.podman.io/image/v5/internal/imagesource/impl"
 	srcStubs "go.podman.io/image/v5/internal/imagesource/stubs"
 	"go.podman.io/image/v5/internal/private"
@@ -36,6 +38,7 @@ import (
 	graphdriver "go.podman.io/storage/drivers"
 	"go.podman.io/storage/pkg/archive"
 	"go.podman.io/storage/pkg/chunked"
+	"go.podman.io/storage/pkg/chunked/als"
 	"go.podman.io/storage/pkg/chunked/toc"
 	"go.podman.io/storage/pkg/ioutils"
 )
@@ -67,6 +70,11 @@ type storageImageDestination struct {
 	signatures            []byte                   // Signature contents, temporary
 	signatureses          map[digest.Digest][]byte // Instance signature contents, temporary
 	metadata              storageImageMetadata     // Metadata contents being built
+	streamLayers          bool                     // Apply ordinary layers directly to staged layers, if the graph driver supports that
+	layerVerification     types.LayerVerificationMode
+
+	// Referrers (SBOMs, attestations, …) to record, indexed by subject manifest digest, temporary
+	referrers map[digest.Digest][]private.Referrer
 
 	// Mapping from layer (by index) to the associated ID in the storage.
 	// It's protected *implicitly* since `commitLayer()`, at any given
@@ -141,6 +149,10 @@ type addedLayerInfo struct {
 // newImageDestination sets us up to write a new image, caching blobs in a temporary directory until
 // it's time to Commit() the image
 func newImageDestination(sys *types.SystemContext, imageRef storageReference) (*storageImageDestination, error) {
+	streamLayers, err := streamLayersEnabled(imageRef.transport.store.PullOptions())
+	if err != nil {
+		return nil, err
+	}
 	directory, err := tmpdir.MkDirBigFileTemp(sys, "storage")
 	if err != nil {
 		return nil, fmt.Errorf("creating a temporary directory: %w", err)
@@ -166,11 +178,13 @@ func newImageDestination(sys *types.SystemContext, imageRef storageReference) (*
 		imageRef:     imageRef,
 		directory:    directory,
 		signatureses: make(map[digest.Digest][]byte),
+		referrers:    make(map[digest.Digest][]private.Referrer),
 		metadata: storageImageMetadata{
 			SignatureSizes:  []int{},
 			SignaturesSizes: make(map[digest.Digest][]int),
 		},
 		indexToStorageID: make(map[int]string),
+		streamLayers:     streamLayers,
 		lockProtected: storageImageDestinationLockProtected{
 			indexToAddedLayerInfo: make(map[int]addedLayerInfo),
 
@@ -184,6 +198,9 @@ func newImageDestination(sys *types.SystemContext, imageRef storageReference) (*
 			fileSizes:              make(map[digest.Digest]int64),
 		},
 	}
+	if sys != nil {
+		dest.layerVerification = sys.StorageLayerVerification
+	}
 	dest.Compat = impl.AddCompat(dest)
 	return dest, nil
 }
@@ -230,9 +247,21 @@ func (s *storageImageDestination) NoteOriginalOCIConfig(ociConfig *imgspecv1.Ima
 // to any other readers for download using the supplied digest.
 // If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlob MUST 1) fail, and 2) delete any data stored so far.
 func (s *storageImageDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, blobinfo types.BlobInfo, options private.PutBlobOptions) (private.UploadedBlob, error) {
-	info, err := s.putBlobToPendingFile(stream, blobinfo, &options)
-	if err != nil {
-		return info, err
+	var info private.UploadedBlob
+	staged := false
+	if s.streamLayers && !options.IsConfig && options.LayerIndex != nil {
+		i, ok, err := s.putBlobToStagedLayer(stream, blobinfo, &options)
+		if err != nil {
+			return i, err
+		}
+		info, staged = i, ok
+	}
+	if !staged {
+		i, err := s.putBlobToPendingFile(stream, blobinfo, &options)
+		if err != nil {
+			return i, err
+		}
+		info = i
 	}
 
 	if options.IsConfig {
@@ -327,6 +356,64 @@ func (s *storageImageDestination) putBlobToPendingFile(stream io.Reader, blobinf
 	}, nil
 }
 
+// putBlobToStagedLayer implements ImageDestination.PutBlobWithOptions for a layer at options.LayerIndex,
+// applying stream directly to a staged layer in the graph driver, without storing it in a temporary file first.
+// If the graph driver does not support that, it returns (_, false, nil) without consuming stream,
+// and the caller should use putBlobToPendingFile instead.
+// On failure, the staged layer is removed.
+// The caller must arrange the blob to be eventually committed using s.commitLayer().
+func (s *storageImageDestination) putBlobToStagedLayer(stream io.Reader, blobinfo types.BlobInfo, options *private.PutBlobOptions) (private.UploadedBlob, bool, error) {
+	if blobinfo.Digest != "" {
+		if err := blobinfo.Digest.Validate(); err != nil {
+			return private.UploadedBlob{}, false, fmt.Errorf("invalid digest %#v: %w", blobinfo.Digest.String(), err)
+		}
+	}
+
+	differ := newStreamingDiffer(stream, blobinfo)
+	defer differ.Close()
+	out, err := s.imageRef.transport.store.PrepareStagedLayer(nil, differ)
+	if err != nil {
+		if !differ.consumed {
+			logrus.Debugf("Not streaming layer %q to a staged layer, falling back to a temporary file: %v", blobinfo.Digest.String(), err)
+			return private.UploadedBlob{}, false, nil
+		}
+		return private.UploadedBlob{}, false, fmt.Errorf("staging a layer: %w", err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			_ = s.imageRef.transport.store.CleanupStagedLayer(out)
+		}
+	}()
+
+	// Determine blob properties, and fail if information that we were given about the blob
+	// is known to be incorrect.
+	blobSize := blobinfo.Size
+	if blobSize < 0 {
+		blobSize = differ.compressedSize
+	} else if blobinfo.Size != differ.compressedSize {
+		return private.UploadedBlob{}, false, ErrBlobSizeMismatch
+	}
+	blobDigest := out.CompressedDigest
+	diffID := out.UncompressedDigest
+
+	// Record information about the blob.
+	// The DiffID is verified against the config in commitLayer, the same way as for layers stored in temporary files.
+	s.lock.Lock()
+	s.lockProtected.blobDiffIDs[blobDigest] = diffID
+	s.lockProtected.indexToDiffID[*options.LayerIndex] = diffID
+	s.lockProtected.diffOutputs[*options.LayerIndex] = out
+	s.lock.Unlock()
+	// This is safe because we have just computed diffID, and blobDigest was either computed
+	// by us, or validated by the caller (usually copy.digestingReader).
+	options.Cache.RecordDigestUncompressedPair(blobDigest, diffID)
+	succeeded = true
+	return private.UploadedBlob{
+		Digest: blobDigest,
+		Size:   blobSize,
+	}, true, nil
+}
+
 type zstdFetcher struct {
 	chunkAccessor private.BlobChunkAccessor
 	ctx           context.Context
@@ -420,6 +507,23 @@ func (s *storageImageDestination) PutBlobPartial(ctx context.Context, chunkAcces
 		}
 	}()
 
+	// Layers from the additional layer store are identified only by TOC, so they can’t be used with strict layer verification.
+	if options.PreferLazy && options.SrcRef != nil && inputTOCDigest != nil && s.layerVerification != types.LayerVerificationStrict {
+		registered, err := s.putBlobToAdditionalLayerStore(ctx, &fetcher, srcInfo, options)
+		if err != nil {
+			return private.UploadedBlob{}, err
+		}
+		if registered {
+			return private.UploadedBlob{
+				Digest: srcInfo.Digest,
+				Size:   srcInfo.Size,
+			}, s.queueOrCommit(options.LayerIndex, addedLayerInfo{
+				digest:     srcInfo.Digest,
+				emptyLayer: options.EmptyLayer,
+			})
+		}
+	}
+
 	differ, err := chunked.NewDiffer(ctx, s.imageRef.transport.store, srcInfo.Digest, srcInfo.Size, srcInfo.Annotations, &fetcher) //nolint:staticcheck // SA4023: golangci-lint reports this line as the origin of the value below.
 	if err != nil {                                                                                                                //nolint:staticcheck // SA4023: on non-Linux, this is always true.
 		return private.UploadedBlob{}, err
@@ -477,6 +581,11 @@ func (s *storageImageDestination) PutBlobPartial(ctx context.Context, chunkAcces
 				options.Cache.RecordDigestUncompressedPair(out.CompressedDigest, out.UncompressedDigest)
 			}
 		} else {
+			// With strict verification, we need the uncompressed digest; a non-partial pull will compute it.
+			if s.layerVerification == types.LayerVerificationStrict {
+				return private.NewErrFallbackToOrdinaryLayerDownload(
+					fmt.Errorf("partially-pulled layer %q has no uncompressed digest, which is required for strict layer verification", srcInfo.Digest.String()))
+			}
 			// Sanity-check the defined rules for indexToTOCDigest.
 			if inputTOCDigest == nil {
 				return fmt.Errorf("internal error: PrepareStagedLayer returned a TOC-only identity for layer %q with no TOC digest", srcInfo.Digest.String())
@@ -504,6 +613,86 @@ func (s *storageImageDestination) PutBlobPartial(ctx context.Context, chunkAcces
 		})
 }
 
+func init() {
+	als.SetSourceOpener(func(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64) (chunked.ImageSourceSeekable, func() error, error) {
+		return openLazyLayerSource(ctx, ref, blobDigest, blobSize)
+	})
+}
+
+// openLazyLayerSource returns a source of the blob of a layer registered by putBlobToAdditionalLayerStore, to be fetched
+// when the layer is first used (possibly by another process), and a function to close the source.
+// It is a variable so that tests can replace it.
+var openLazyLayerSource = func(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64) (chunked.ImageSourceSeekable, func() error, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	srcRef, err := docker.NewReference(named)
+	if err != nil {
+		return nil, nil, err
+	}
+	src, err := srcRef.NewImageSource(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateSrc := imagesource.FromPublic(src)
+	if !privateSrc.SupportsGetBlobAt() {
+		if err := src.Close(); err != nil {
+			logrus.Debugf("Error closing %s: %v", named.String(), err)
+		}
+		return nil, nil, fmt.Errorf("%s does not support partial pulls", named.String())
+	}
+	return &zstdFetcher{
+		chunkAccessor: privateSrc,
+		ctx:           ctx,
+		blobInfo:      types.BlobInfo{Digest: blobDigest, Size: blobSize},
+	}, src.Close, nil
+}
+
+// putBlobToAdditionalLayerStore tries to register a partial-pull-capable layer, accessible via fetcher, as a lazily-pulled layer
+// in the first Additional Layer Store configured for the overlay driver, using the reference implementation in c/storage/pkg/chunked/als.
+// It returns (false, nil) if that is not possible, and the caller should pull the layer in some other way.
+func (s *storageImageDestination) putBlobToAdditionalLayerStore(ctx context.Context, fetcher *zstdFetcher, srcInfo types.BlobInfo, options private.PutBlobPartialOptions) (bool, error) {
+	store := s.imageRef.transport.store
+	if driver := store.GraphDriverName(); driver != "overlay" && driver != "overlay2" {
+		logrus.Debugf("Not pulling layer %q lazily: graph driver %q does not support additional layer stores", srcInfo.Digest, driver)
+		return false, nil
+	}
+	if srcInfo.Size == -1 {
+		logrus.Debugf("Not pulling layer %q lazily: size is unknown", srcInfo.Digest)
+		return false, nil
+	}
+	layerStore, err := als.FromGraphOptions(store.GraphOptions())
+	if err != nil {
+		return false, fmt.Errorf("finding an additional layer store: %w", err)
+	}
+	if layerStore == nil {
+		logrus.Debugf("Not pulling layer %q lazily: no additional layer store is configured", srcInfo.Digest)
+		return false, nil
+	}
+
+	ref := options.SrcRef.String()
+	tocDigest, err := layerStore.Prepare(ctx, ref, srcInfo.Digest, srcInfo.Size, srcInfo.Annotations, fetcher)
+	if err != nil {
+		if errors.Is(err, als.ErrUnsupportedLayer) {
+			logrus.Debugf("Not pulling layer %q lazily: %v", srcInfo.Digest, err)
+			return false, nil
+		}
+		return false, fmt.Errorf("preparing layer %q in the additional layer store: %w", srcInfo.Digest, err)
+	}
+	// The rest of the layer is fetched when the graph driver first uses it, see openLazyLayerSource.
+	aLayer, err := store.LookupAdditionalLayer(tocDigest, ref)
+	if err != nil {
+		return false, fmt.Errorf("looking up layer %q with TOC digest %q in the additional layer store: %w", srcInfo.Digest, tocDigest, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lockProtected.indexToTOCDigest[options.LayerIndex] = tocDigest
+	s.lockProtected.indexToAdditionalLayer[options.LayerIndex] = aLayer
+	return true, nil
+}
+
 // TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
 // (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
 // info.Digest must not be empty.
@@ -562,7 +751,8 @@ func (s *storageImageDestination) tryReusingBlobAsPending(blobDigest digest.Dige
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if options.SrcRef != nil && useTOCDigest {
+	// Layers from the additional layer store are identified only by TOC, so they can’t be used with strict layer verification.
+	if options.SrcRef != nil && useTOCDigest && s.layerVerification != types.LayerVerificationStrict {
 		// Check if we have the layer in the underlying additional layer store.
 		aLayer, err := s.imageRef.transport.store.LookupAdditionalLayer(options.TOCDigest, options.SrcRef.String())
 		if err != nil && !errors.Is(err, storage.ErrLayerUnknown) {
@@ -674,6 +864,10 @@ func (s *storageImageDestination) tryReusingBlobAsPending(blobDigest digest.Dige
 				// and to maximize image reuse.
 				uncompressedDigest = layers[0].UncompressedDigest
 			}
+			if uncompressedDigest == "" && s.layerVerification == types.LayerVerificationStrict {
+				logrus.Debugf("Not reusing layer with TOC digest %q: uncompressed digest is unknown, which is not allowed with strict layer verification", options.TOCDigest)
+				return false, private.ReusedBlob{}, nil
+			}
 			if uncompressedDigest != "" {
 				s.lockProtected.indexToDiffID[*options.LayerIndex] = uncompressedDigest
 			}
@@ -1011,6 +1205,12 @@ func (s *storageImageDestination) commitLayer(index int, info addedLayerInfo, si
 
 	// Ensure that we always see the same “view” of a layer, as identified by the layer’s uncompressed digest,
 	// unless the user has explicitly opted out of this in storage.conf: see the more detailed explanation in PutBlobPartial.
+	// With strict verification, the opt-out is not allowed, and every layer must match a DiffID value in config.
+	strict := s.layerVerification == types.LayerVerificationStrict
+	if trusted.diffID == "" && strict {
+		return false, fmt.Errorf("layer %d (blob %s) has no known uncompressed digest, which is required for strict layer verification",
+			index, trusted.logString())
+	}
 	if trusted.diffID != "" {
 		untrustedDiffID, err := s.untrustedLayerDiffID(index)
 		if err != nil {
@@ -1031,6 +1231,10 @@ func (s *storageImageDestination) commitLayer(index int, info addedLayerInfo, si
 					return false, fmt.Errorf("internal error: layer %d for blob %s was identified by TOC, but we don't have a DiffID in config",
 						index, trusted.logString())
 				}
+				if strict {
+					return false, fmt.Errorf("layer %d (blob %s) can't be verified, which is required for strict layer verification: %w",
+						index, trusted.logString(), err)
+				}
 				// else a schema1 image or a non-TOC layer with no ambiguity, let it through
 			default:
 				return false, err
@@ -1567,6 +1771,11 @@ func (s *storageImageDestination) CommitWithOptions(ctx context.Context, options
 			return err
 		}
 	}
+	referrersBigData, err := s.referrersBigData(intendedID)
+	if err != nil {
+		return err
+	}
+	imgOptions.BigData = append(imgOptions.BigData, referrersBigData...)
 	oldNames := []string{}
 	img, err := s.imageRef.transport.store.CreateImage(intendedID, nil, lastLayer, "", imgOptions)
 	if err != nil {
@@ -1643,6 +1852,15 @@ func (s *storageImageDestination) CommitWithOptions(ctx context.Context, options
 	}
 
 	commitSucceeded = true
+
+	// Make room for the new image, if the store is configured to remove least recently used images.
+	// This does nothing unless a configured limit is exceeded, and it only uses the recorded sizes of layers.
+	removed, err := s.imageRef.transport.store.PruneImages(&storage.PruneImagesOptions{Keep: []string{img.ID}})
+	if err != nil {
+		logrus.Warnf("Removing least recently used images: %v", err)
+	} else if len(removed) != 0 {
+		logrus.Debugf("Removed least recently used images %v", removed)
+	}
 	return nil
 }
 
@@ -0,0 +1,179 @@
+//go:build !containers_image_storage_stub
+
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/vbatts/tar-split/archive/tar"
+	"github.com/vbatts/tar-split/tar/asm"
+	tsStorage "github.com/vbatts/tar-split/tar/storage"
+	"go.podman.io/image/v5/internal/putblobdigest"
+	"go.podman.io/image/v5/types"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chrootarchive"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/tarlog"
+)
+
+// streamLayersPullOption is the name of the c/storage pull option which enables applying ordinary
+// (not partially-pulled) layers directly to a staged layer, without storing the blob in a temporary file first.
+const streamLayersPullOption = "stream_layers"
+
+// streamLayersEnabled returns the value of streamLayersPullOption in pullOptions, defaulting to false.
+func streamLayersEnabled(pullOptions map[string]string) (bool, error) {
+	value, ok := pullOptions[streamLayersPullOption]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for pull option %q: %w", value, streamLayersPullOption, err)
+	}
+	return enabled, nil
+}
+
+// errStreamingUnsupportedFormat is returned by streamingDiffer.ApplyDiff if the graph driver
+// requires an output format streamingDiffer can’t produce. It is returned before consuming any input.
+var errStreamingUnsupportedFormat = errors.New("streaming a layer is only supported for the directory output format")
+
+// streamingDiffer is a graphdriver.Differ which applies an ordinary layer blob, read sequentially from a stream,
+// into a staging directory, while computing the values needed to commit the layer later
+// (digests, tar-split metadata, and the set of used UIDs/GIDs).
+type streamingDiffer struct {
+	stream   io.Reader
+	blobInfo types.BlobInfo
+
+	// The following fields are set by ApplyDiff.
+	consumed       bool  // ApplyDiff has started reading from stream; it is not possible to fall back to another approach.
+	compressedSize int64 // Only valid if ApplyDiff succeeded.
+}
+
+// newStreamingDiffer returns a streamingDiffer for a blob described by blobInfo, with contents in stream.
+// blobInfo.Digest, if set, must have already been validated; as with PutBlobWithOptions,
+// the caller is responsible for verifying that stream matches blobInfo.Digest.
+func newStreamingDiffer(stream io.Reader, blobInfo types.BlobInfo) *streamingDiffer {
+	return &streamingDiffer{
+		stream:   stream,
+		blobInfo: blobInfo,
+	}
+}
+
+// ApplyDiff implements graphdriver.Differ.
+func (d *streamingDiffer) ApplyDiff(dest string, options *archive.TarOptions, differOpts *graphdriver.DifferOptions) (_ graphdriver.DriverWithDifferOutput, retErr error) {
+	stagingDirectory := ""
+	if differOpts != nil {
+		if differOpts.Format != graphdriver.DifferOutputFormatDir {
+			return graphdriver.DriverWithDifferOutput{}, errStreamingUnsupportedFormat
+		}
+		stagingDirectory = differOpts.StagingDirectory
+	}
+	d.consumed = true
+
+	tarSplit, err := os.CreateTemp(stagingDirectory, ".tar-split")
+	if err != nil {
+		return graphdriver.DriverWithDifferOutput{}, fmt.Errorf("creating a temporary tar-split file: %w", err)
+	}
+	// Unlink the file immediately so that only the open fd refers to it.
+	_ = os.Remove(tarSplit.Name())
+	defer func() {
+		if retErr != nil {
+			tarSplit.Close()
+		}
+	}()
+	tarSplitWriter := bufio.NewWriter(tarSplit)
+
+	compressedCounter := ioutils.NewWriteCounter(io.Discard)
+	stream := io.TeeReader(d.stream, compressedCounter)
+	blobDigester, stream := putblobdigest.DigestIfUnknown(stream, d.blobInfo)
+	diffIDDigester := digest.Canonical.Digester()
+	uidLog := make(map[uint32]struct{})
+	gidLog := make(map[uint32]struct{})
+	var uncompressedCounter *ioutils.WriteCounter
+
+	if err := func() (retErr error) { // A scope for defer
+		decompressed, err := archive.DecompressStream(stream)
+		if err != nil {
+			return fmt.Errorf("setting up to decompress blob: %w", err)
+		}
+		defer decompressed.Close()
+		idLogger, err := tarlog.NewLogger(func(h *tar.Header) {
+			if !strings.HasPrefix(path.Base(h.Name), archive.WhiteoutPrefix) {
+				uidLog[uint32(h.Uid)] = struct{}{}
+				gidLog[uint32(h.Gid)] = struct{}{}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		defer idLogger.Close() // This must happen before uidLog and gidLog is consumed.
+		uncompressedCounter = ioutils.NewWriteCounter(io.MultiWriter(diffIDDigester.Hash(), idLogger))
+
+		payload, done, err := asm.NewInputTarStreamWithDone(io.TeeReader(decompressed, uncompressedCounter),
+			tsStorage.NewJSONPacker(tarSplitWriter), tsStorage.NewDiscardFilePutter())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			payload.Close()
+			if doneErr := <-done; doneErr != nil && retErr == nil {
+				retErr = doneErr
+			}
+		}()
+
+		// TODO: This can take quite some time, and should ideally be cancellable using context.Context.
+		if err := chrootarchive.UntarUncompressed(payload, dest, options); err != nil {
+			return fmt.Errorf("applying layer: %w", err)
+		}
+		// Fully consume the payload; it may contain trailing zero padding, and we need all of that
+		// recorded in tar-split and in the DiffID.
+		if _, err := io.Copy(io.Discard, payload); err != nil {
+			return fmt.Errorf("reading layer: %w", err)
+		}
+		return nil
+	}(); err != nil {
+		return graphdriver.DriverWithDifferOutput{}, err
+	}
+	// Consume any data after the end of the compressed stream, so that the caller can validate the blob digest.
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return graphdriver.DriverWithDifferOutput{}, fmt.Errorf("reading blob: %w", err)
+	}
+	if err := tarSplitWriter.Flush(); err != nil {
+		return graphdriver.DriverWithDifferOutput{}, fmt.Errorf("writing tar-split: %w", err)
+	}
+
+	d.compressedSize = compressedCounter.Count
+	uids := make([]uint32, 0, len(uidLog))
+	for uid := range uidLog {
+		uids = append(uids, uid)
+	}
+	slices.Sort(uids)
+	gids := make([]uint32, 0, len(gidLog))
+	for gid := range gidLog {
+		gids = append(gids, gid)
+	}
+	slices.Sort(gids)
+	return graphdriver.DriverWithDifferOutput{
+		Size:               uncompressedCounter.Count,
+		UIDs:               uids,
+		GIDs:               gids,
+		UncompressedDigest: diffIDDigester.Digest(),
+		CompressedDigest:   blobDigester.Digest(),
+		TarSplit:           tarSplit,
+	}, nil
+}
+
+// Close implements graphdriver.Differ.
+func (d *streamingDiffer) Close() error {
+	return nil
+}
@@ -1,11 +1,20 @@
 package storage
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vbatts/tar-split/tar/asm"
+	tsStorage "github.com/vbatts/tar-split/tar/storage"
+	"go.podman.io/image/v5/types"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/pkg/archive"
 )
 
 func TestLayerID(t *testing.T) {
@@ -103,3 +112,71 @@ func TestLayerID(t *testing.T) {
 		assert.Equal(t, c.expected, res)
 	}
 }
+
+func TestStreamingDiffer(t *testing.T) {
+	ensureTestCanCreateImages(t)
+
+	layer := makeLayer(t, archive.Gzip)
+	blobInfo := types.BlobInfo{Digest: layer.compressedDigest, Size: layer.compressedSize}
+
+	// Unsupported output format: the stream is not consumed
+	d := newStreamingDiffer(bytes.NewReader(layer.data), blobInfo)
+	_, err := d.ApplyDiff(t.TempDir(), &archive.TarOptions{}, &graphdriver.DifferOptions{Format: graphdriver.DifferOutputFormatFlat})
+	assert.ErrorIs(t, err, errStreamingUnsupportedFormat)
+	assert.False(t, d.consumed)
+
+	// Success
+	dest := t.TempDir()
+	d = newStreamingDiffer(bytes.NewReader(layer.data), blobInfo)
+	out, err := d.ApplyDiff(dest, &archive.TarOptions{}, &graphdriver.DifferOptions{
+		Format:           graphdriver.DifferOutputFormatDir,
+		StagingDirectory: t.TempDir(),
+	})
+	require.NoError(t, err)
+	defer out.TarSplit.Close()
+	assert.True(t, d.consumed)
+	assert.Equal(t, layer.compressedSize, d.compressedSize)
+	assert.Equal(t, layer.compressedDigest, out.CompressedDigest)
+	assert.Equal(t, layer.uncompressedDigest, out.UncompressedDigest)
+	assert.Equal(t, layer.uncompressedSize, out.Size)
+	assert.Equal(t, []uint32{0}, out.UIDs)
+	assert.Equal(t, []uint32{0}, out.GIDs)
+	fi, err := os.Stat(filepath.Join(dest, "random-single-file"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(layerSize), fi.Size())
+	// The tar-split data, with the applied files, reproduces the original layer.
+	_, err = out.TarSplit.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	tarStream := asm.NewOutputTarStream(tsStorage.NewPathFileGetter(dest), tsStorage.NewJSONUnpacker(out.TarSplit))
+	defer tarStream.Close()
+	reassembled, err := digest.Canonical.FromReader(tarStream)
+	require.NoError(t, err)
+	assert.Equal(t, layer.uncompressedDigest, reassembled)
+
+	// Truncated input
+	d = newStreamingDiffer(bytes.NewReader(layer.data[:len(layer.data)/2]), blobInfo)
+	_, err = d.ApplyDiff(t.TempDir(), &archive.TarOptions{}, &graphdriver.DifferOptions{Format: graphdriver.DifferOutputFormatDir})
+	assert.Error(t, err)
+	assert.True(t, d.consumed)
+}
+
+func TestStreamLayersEnabled(t *testing.T) {
+	for _, c := range []struct {
+		options  map[string]string
+		expected bool
+	}{
+		{nil, false},
+		{map[string]string{}, false},
+		{map[string]string{streamLayersPullOption: "true"}, true},
+		{map[string]string{streamLayersPullOption: "TRUE"}, true},
+		{map[string]string{streamLayersPullOption: "1"}, true},
+		{map[string]string{streamLayersPullOption: "false"}, false},
+	} {
+		res, err := streamLayersEnabled(c.options)
+		require.NoError(t, err, c.options)
+		assert.Equal(t, c.expected, res, c.options)
+	}
+
+	_, err := streamLayersEnabled(map[string]string{streamLayersPullOption: "yes please"})
+	assert.Error(t, err)
+}
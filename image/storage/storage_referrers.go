@@ -0,0 +1,189 @@
+//go:build !containers_image_storage_stub
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/internal/referrers"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage"
+)
+
+var (
+	_ private.ReferrersSource      = (*storageImageSource)(nil)
+	_ private.ReferrersDestination = (*storageImageDestination)(nil)
+)
+
+// referrersBigDataKey returns a key suitable for recording the referrers of the manifest with the specified digest using storage.Store.ImageBigData and related functions.
+func referrersBigDataKey(digest digest.Digest) (string, error) {
+	if err := digest.Validate(); err != nil { // digest.Digest.Encoded() panics on failure, so validate explicitly.
+		return "", err
+	}
+	return "referrers-" + digest.Encoded(), nil
+}
+
+// storedReferrer is the representation of a private.Referrer stored, as a JSON array, in the referrersBigDataKey item of an image.
+type storedReferrer struct {
+	Manifest []byte                   `json:"manifest"`
+	Blobs    map[digest.Digest][]byte `json:"blobs,omitempty"`
+}
+
+// readReferrers returns the referrers of subject stored in image imageID, or nil if there are none.
+func readReferrers(store storage.Store, imageID string, subject digest.Digest) ([]private.Referrer, error) {
+	key, err := referrersBigDataKey(subject)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.ImageBigData(imageID, key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading referrers of %s: %w", subject, err)
+	}
+	var stored []storedReferrer
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("decoding referrers of %s: %w", subject, err)
+	}
+	res := make([]private.Referrer, 0, len(stored))
+	for _, s := range stored {
+		r, err := referrers.Validate(private.Referrer{Manifest: s.Manifest, Blobs: s.Blobs}, subject)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// mergeReferrers returns the union of existing and added, with entries in added replacing entries in existing
+// with the same manifest digest.
+func mergeReferrers(existing, added []private.Referrer) []private.Referrer {
+	res := slices.DeleteFunc(slices.Clone(existing), func(e private.Referrer) bool {
+		return slices.ContainsFunc(added, func(a private.Referrer) bool {
+			return a.Descriptor.Digest == e.Descriptor.Digest
+		})
+	})
+	return append(res, added...)
+}
+
+// encodeReferrers returns the stored representation of referrers.
+func encodeReferrers(referrers []private.Referrer) ([]byte, error) {
+	stored := make([]storedReferrer, 0, len(referrers))
+	for _, r := range referrers {
+		stored = append(stored, storedReferrer{Manifest: r.Manifest, Blobs: r.Blobs})
+	}
+	return json.Marshal(stored)
+}
+
+// GetReferrers returns the referrers of the manifest with instanceDigest, or of the primary manifest if instanceDigest is nil.
+func (s *storageImageSource) GetReferrers(ctx context.Context, instanceDigest *digest.Digest) ([]private.Referrer, error) {
+	var subject digest.Digest
+	if instanceDigest != nil {
+		subject = *instanceDigest
+	} else {
+		manifestBlob, _, err := s.GetManifest(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		subject, err = manifest.Digest(manifestBlob)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return readReferrers(s.imageRef.transport.store, s.image.ID, subject)
+}
+
+// PutReferrers writes referrers of the manifest with instanceDigest, or of the primary manifest if instanceDigest is nil;
+// the Subject of every referrer must refer to that manifest.
+// MUST be called after PutManifest.
+func (s *storageImageDestination) PutReferrers(ctx context.Context, refs []private.Referrer, instanceDigest *digest.Digest) error {
+	if s.manifest == nil {
+		return errors.New("Unable to store referrers without a manifest")
+	}
+	subject := s.manifestDigest
+	if instanceDigest != nil {
+		subject = *instanceDigest
+	}
+	validated := make([]private.Referrer, 0, len(refs))
+	for _, r := range refs {
+		v, err := referrers.Validate(r, subject)
+		if err != nil {
+			return err
+		}
+		validated = append(validated, v)
+	}
+	s.referrers[subject] = mergeReferrers(s.referrers[subject], validated)
+	return nil
+}
+
+// referrersBigData returns big data items recording s.referrers for an image with intendedID,
+// merged with any referrers already recorded for that image.
+func (s *storageImageDestination) referrersBigData(intendedID string) ([]storage.ImageBigDataOption, error) {
+	res := []storage.ImageBigDataOption{}
+	for subject, added := range s.referrers {
+		key, err := referrersBigDataKey(subject)
+		if err != nil {
+			return nil, err
+		}
+		existing := []private.Referrer{}
+		if _, err := s.imageRef.transport.store.Image(intendedID); err == nil {
+			existing, err = readReferrers(s.imageRef.transport.store, intendedID, subject)
+			if err != nil {
+				return nil, err
+			}
+		}
+		data, err := encodeReferrers(mergeReferrers(existing, added))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, storage.ImageBigDataOption{
+			Key:    key,
+			Data:   data,
+			Digest: digest.Canonical.FromBytes(data),
+		})
+	}
+	return res, nil
+}
+
+// ListReferrers returns descriptors of the artifacts (e.g. SBOMs or attestations) attached to the image identified by ref
+// in containers-storage, in the form of an OCI referrers API response.
+// If ref identifies a specific manifest by digest, referrers of that manifest are returned; otherwise, referrers
+// of the image's primary manifest are returned.
+// If artifactType is not empty, only referrers with that artifact type are returned.
+func ListReferrers(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, artifactType string) (*imgspecv1.Index, error) {
+	rawSrc, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer rawSrc.Close()
+	src, ok := rawSrc.(*storageImageSource)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a %s reference", ref.StringWithinTransport(), Transport.Name())
+	}
+	refs, err := src.GetReferrers(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := &imgspecv1.Index{
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{},
+	}
+	res.SchemaVersion = 2
+	for _, r := range refs {
+		if artifactType == "" || r.Descriptor.ArtifactType == artifactType {
+			res.Manifests = append(res.Manifests, r.Descriptor)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,136 @@
+//go:build !containers_image_storage_stub
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/storage/pkg/archive"
+)
+
+// makeReferrer returns a referrer of subject with artifactType and a single layer with contents.
+func makeReferrer(t *testing.T, subject digest.Digest, artifactType string, contents string) private.Referrer {
+	config := []byte("{}")
+	layer := []byte(contents)
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeEmptyJSON,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}})
+	m.ArtifactType = artifactType
+	m.Subject = &imgspecv1.Descriptor{
+		MediaType: manifest.DockerV2Schema2MediaType,
+		Digest:    subject,
+		Size:      1, // Not validated
+	}
+	manifestBlob, err := m.Serialize()
+	require.NoError(t, err)
+	return private.Referrer{
+		Manifest: manifestBlob,
+		Blobs: map[digest.Digest][]byte{
+			digest.FromBytes(config): config,
+			digest.FromBytes(layer):  layer,
+		},
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	ensureTestCanCreateImages(t)
+
+	newStore(t)
+	cache := memory.New()
+	ctx := context.Background()
+
+	ref, err := Transport.ParseReference("test")
+	require.NoError(t, err)
+
+	layer := makeLayer(t, archive.Uncompressed)
+	config := configForLayers(t, []testBlob{layer})
+	dest, unparsedToplevel := createUncommittedImageDest(t, nil, ref, cache, []testBlob{layer}, &config)
+	manifestBlob, _, err := unparsedToplevel.Manifest(ctx)
+	require.NoError(t, err)
+	subject, err := manifest.Digest(manifestBlob)
+	require.NoError(t, err)
+
+	referrersDest, ok := dest.(private.ReferrersDestination)
+	require.True(t, ok)
+	sbom := makeReferrer(t, subject, "application/spdx+json", "sbom")
+	require.NoError(t, referrersDest.PutReferrers(ctx, []private.Referrer{sbom}, nil))
+	// Referrers of other manifests are rejected
+	err = referrersDest.PutReferrers(ctx, []private.Referrer{makeReferrer(t, digest.FromString("other"), "application/spdx+json", "sbom")}, nil)
+	assert.Error(t, err)
+	// Referrers with missing blobs are rejected
+	incomplete := makeReferrer(t, subject, "application/spdx+json", "incomplete")
+	delete(incomplete.Blobs, digest.FromString("incomplete"))
+	err = referrersDest.PutReferrers(ctx, []private.Referrer{incomplete}, nil)
+	assert.Error(t, err)
+	require.NoError(t, dest.Commit(ctx, unparsedToplevel))
+	require.NoError(t, dest.Close())
+
+	index, err := ListReferrers(ctx, nil, ref, "")
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, index.Manifests[0].MediaType)
+	assert.Equal(t, digest.FromBytes(sbom.Manifest), index.Manifests[0].Digest)
+	assert.Equal(t, "application/spdx+json", index.Manifests[0].ArtifactType)
+
+	// Committing the same image again adds referrers.
+	dest, unparsedToplevel = createUncommittedImageDest(t, nil, ref, cache, []testBlob{layer}, &config)
+	referrersDest, ok = dest.(private.ReferrersDestination)
+	require.True(t, ok)
+	attestation := makeReferrer(t, subject, "application/vnd.in-toto+json", "attestation")
+	require.NoError(t, referrersDest.PutReferrers(ctx, []private.Referrer{attestation, sbom}, nil))
+	require.NoError(t, dest.Commit(ctx, unparsedToplevel))
+	require.NoError(t, dest.Close())
+
+	index, err = ListReferrers(ctx, nil, ref, "")
+	require.NoError(t, err)
+	assert.Len(t, index.Manifests, 2)
+	index, err = ListReferrers(ctx, nil, ref, "application/vnd.in-toto+json")
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, digest.FromBytes(attestation.Manifest), index.Manifests[0].Digest)
+	index, err = ListReferrers(ctx, nil, ref, "application/unknown")
+	require.NoError(t, err)
+	assert.Empty(t, index.Manifests)
+
+	src, err := ref.NewImageSource(ctx, nil)
+	require.NoError(t, err)
+	defer src.Close()
+	referrersSource, ok := src.(private.ReferrersSource)
+	require.True(t, ok)
+	refs, err := referrersSource.GetReferrers(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+	for _, r := range refs {
+		switch r.Descriptor.Digest {
+		case digest.FromBytes(sbom.Manifest):
+			assert.Equal(t, sbom.Manifest, r.Manifest)
+			assert.Equal(t, sbom.Blobs, r.Blobs)
+		case digest.FromBytes(attestation.Manifest):
+			assert.Equal(t, attestation.Manifest, r.Manifest)
+			assert.Equal(t, attestation.Blobs, r.Blobs)
+		default:
+			t.Errorf("Unexpected referrer %s", r.Descriptor.Digest)
+		}
+	}
+	refs, err = referrersSource.GetReferrers(ctx, &subject)
+	require.NoError(t, err)
+	assert.Len(t, refs, 2)
+	otherDigest := digest.FromString("other")
+	refs, err = referrersSource.GetReferrers(ctx, &otherDigest)
+	require.NoError(t, err)
+	assert.Empty(t, refs)
+}
@@ -8,11 +8,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -21,9 +23,12 @@ import (
 	"time"
 
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/docker/reference"
+	"go.podman.io/image/v5/internal/blobinfocache"
 	imanifest "go.podman.io/image/v5/internal/manifest"
 	"go.podman.io/image/v5/internal/private"
 	"go.podman.io/image/v5/manifest"
@@ -31,6 +36,9 @@ import (
 	"go.podman.io/image/v5/types"
 	"go.podman.io/storage"
 	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chunked"
+	"go.podman.io/storage/pkg/chunked/compressor"
+	"go.podman.io/storage/pkg/chunked/toc"
 	"go.podman.io/storage/pkg/idtools"
 	"go.podman.io/storage/pkg/ioutils"
 	"go.podman.io/storage/pkg/reexec"
@@ -353,10 +361,10 @@ func configForLayers(t *testing.T, layers []testBlob) testBlob {
 	}
 }
 
-func createUncommittedImageDest(t *testing.T, ref types.ImageReference, cache types.BlobInfoCache,
+func createUncommittedImageDest(t *testing.T, sys *types.SystemContext, ref types.ImageReference, cache types.BlobInfoCache,
 	layers []testBlob, config *testBlob,
 ) (types.ImageDestination, types.UnparsedImage) {
-	dest, err := ref.NewImageDestination(context.Background(), nil)
+	dest, err := ref.NewImageDestination(context.Background(), sys)
 	require.NoError(t, err)
 
 	layerDescriptors := []manifest.Schema2Descriptor{}
@@ -388,7 +396,7 @@ func createUncommittedImageDest(t *testing.T, ref types.ImageReference, cache ty
 func createImage(t *testing.T, ref types.ImageReference, cache types.BlobInfoCache,
 	layers []testBlob, config *testBlob,
 ) {
-	dest, unparsedToplevel := createUncommittedImageDest(t, ref, cache, layers, config)
+	dest, unparsedToplevel := createUncommittedImageDest(t, nil, ref, cache, layers, config)
 	err := dest.Commit(context.Background(), unparsedToplevel)
 	require.NoError(t, err)
 	err = dest.Close()
@@ -592,7 +600,7 @@ func TestDuplicateID(t *testing.T) {
 
 	createImage(t, ref, cache, []testBlob{makeLayer(t, archive.Gzip)}, nil)
 
-	dest, unparsedToplevel := createUncommittedImageDest(t, ref, cache,
+	dest, unparsedToplevel := createUncommittedImageDest(t, nil, ref, cache,
 		[]testBlob{makeLayer(t, archive.Gzip)}, nil)
 	err = dest.Commit(context.Background(), unparsedToplevel)
 	require.Error(t, err)
@@ -612,7 +620,7 @@ func TestDuplicateNameID(t *testing.T) {
 
 	createImage(t, ref, cache, []testBlob{makeLayer(t, archive.Gzip)}, nil)
 
-	dest, unparsedToplevel := createUncommittedImageDest(t, ref, cache,
+	dest, unparsedToplevel := createUncommittedImageDest(t, nil, ref, cache,
 		[]testBlob{makeLayer(t, archive.Gzip)}, nil)
 	err = dest.Commit(context.Background(), unparsedToplevel)
 	require.Error(t, err)
@@ -621,6 +629,190 @@ func TestDuplicateNameID(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestStrictLayerVerification(t *testing.T) {
+	ensureTestCanCreateImages(t)
+
+	newStore(t)
+	cache := memory.New()
+	strict := &types.SystemContext{StorageLayerVerification: types.LayerVerificationStrict}
+
+	ref, err := Transport.ParseReference("test")
+	require.NoError(t, err)
+
+	// Layers matching DiffID values in config are accepted.
+	layers := []testBlob{makeLayer(t, archive.Gzip)}
+	config := configForLayers(t, layers)
+	dest, unparsedToplevel := createUncommittedImageDest(t, strict, ref, cache, layers, &config)
+	err = dest.Commit(context.Background(), unparsedToplevel)
+	require.NoError(t, err)
+	err = dest.Close()
+	require.NoError(t, err)
+
+	// A config without DiffID values is accepted only in the relaxed mode.
+	for _, c := range []struct {
+		sys     *types.SystemContext
+		success bool
+	}{
+		{nil, true},
+		{&types.SystemContext{}, true},
+		{strict, false},
+	} {
+		layers := []testBlob{makeLayer(t, archive.Gzip)}
+		config := configForLayers(t, nil)
+		dest, unparsedToplevel := createUncommittedImageDest(t, c.sys, ref, cache, layers, &config)
+		err = dest.Commit(context.Background(), unparsedToplevel)
+		if c.success {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, "strict layer verification")
+		}
+		err = dest.Close()
+		require.NoError(t, err)
+	}
+}
+
+// bytesChunkAccessor is a private.BlobChunkAccessor for a blob in memory.
+type bytesChunkAccessor []byte
+
+func (b bytesChunkAccessor) GetBlobAt(ctx context.Context, info types.BlobInfo, chunks []private.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
+	streams := make(chan io.ReadCloser)
+	errs := make(chan error)
+	go func() {
+		defer close(streams)
+		defer close(errs)
+		for _, c := range chunks {
+			end := uint64(len(b))
+			if c.Length != math.MaxUint64 && c.Offset+c.Length < end {
+				end = c.Offset + c.Length
+			}
+			streams <- io.NopCloser(bytes.NewReader(b[c.Offset:end]))
+		}
+	}()
+	return streams, errs, nil
+}
+
+func TestPutBlobPartialPreferLazy(t *testing.T) {
+	ensureTestCanCreateImages(t)
+
+	wd := t.TempDir()
+	alsRoot := filepath.Join(wd, "als")
+	require.NoError(t, os.Mkdir(alsRoot, 0o700))
+	store, err := storage.GetStore(storage.StoreOptions{
+		RunRoot:            filepath.Join(wd, "run"),
+		GraphRoot:          filepath.Join(wd, "root"),
+		GraphDriverName:    "overlay",
+		GraphDriverOptions: []string{"overlay.additionallayerstore=" + alsRoot + ":ref"},
+	})
+	if err != nil {
+		t.Skipf("overlay is not usable: %v", err)
+	}
+	t.Cleanup(func() { _, _ = store.Shutdown(true) })
+	Transport.SetStore(store)
+
+	var tarBuffer bytes.Buffer
+	tw := tar.NewWriter(&tarBuffer)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, Size: 8}))
+	_, err = tw.Write([]byte("contents"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	diffID := digest.Canonical.FromBytes(tarBuffer.Bytes())
+	var blobBuffer bytes.Buffer
+	annotations := map[string]string{}
+	w, err := compressor.ZstdCompressor(&blobBuffer, annotations, nil)
+	require.NoError(t, err)
+	_, err = io.Copy(w, &tarBuffer)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	layer := testBlob{
+		uncompressedDigest: diffID,
+		compressedDigest:   digest.Canonical.FromBytes(blobBuffer.Bytes()),
+		compressedSize:     int64(blobBuffer.Len()),
+		data:               blobBuffer.Bytes(),
+	}
+	tocDigest, err := toc.GetTOCDigest(annotations)
+	require.NoError(t, err)
+	require.NotNil(t, tocDigest)
+
+	ref, err := Transport.ParseReference("test")
+	require.NoError(t, err)
+	srcRef, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	privateDest, ok := dest.(private.ImageDestination)
+	require.True(t, ok)
+	err = privateDest.NoteOriginalOCIConfig(&imgspecv1.Image{
+		RootFS: imgspecv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{diffID}},
+	}, nil)
+	require.NoError(t, err)
+	uploaded, err := privateDest.PutBlobPartial(context.Background(), bytesChunkAccessor(layer.data), types.BlobInfo{
+		Digest:      layer.compressedDigest,
+		Size:        layer.compressedSize,
+		Annotations: annotations,
+	}, private.PutBlobPartialOptions{
+		Cache:      blobinfocache.FromBlobInfoCache(memory.New()),
+		LayerIndex: 0,
+		SrcRef:     srcRef,
+		PreferLazy: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, layer.compressedDigest, uploaded.Digest)
+
+	// The layer has been prepared in the additional layer store, but its contents are only created on first use.
+	alsLayerPath := filepath.Join(alsRoot, base64.StdEncoding.EncodeToString([]byte(srcRef.String())), tocDigest.String())
+	_, err = os.Stat(filepath.Join(alsLayerPath, "info"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(alsLayerPath, "diff"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	config := configForLayers(t, []testBlob{layer})
+	configDescriptor := config.storeBlob(t, dest, memory.New(), manifest.DockerV2Schema2ConfigMediaType, true)
+	layerDescriptor := manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2LayerMediaType,
+		Size:      layer.compressedSize,
+		Digest:    layer.compressedDigest,
+	}
+	manifestBytes, err := manifest.Schema2FromComponents(configDescriptor, []manifest.Schema2Descriptor{layerDescriptor}).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(context.Background(), manifestBytes, nil))
+	err = dest.Commit(context.Background(), &unparsedImage{
+		manifestBytes: manifestBytes,
+		manifestType:  manifest.DockerV2Schema2MediaType,
+	})
+	require.NoError(t, err)
+
+	layers, err := store.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	assert.Equal(t, *tocDigest, layers[0].TOCDigest)
+	assert.Equal(t, layer.compressedDigest, layers[0].CompressedDigest)
+	_, err = os.Stat(filepath.Join(alsLayerPath, "diff"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Mounting a layer on top of it fetches the rest of the blob from the source image.
+	savedOpenLazyLayerSource := openLazyLayerSource
+	t.Cleanup(func() { openLazyLayerSource = savedOpenLazyLayerSource })
+	openedRef := ""
+	openLazyLayerSource = func(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64) (chunked.ImageSourceSeekable, func() error, error) {
+		openedRef = ref
+		return &zstdFetcher{
+			chunkAccessor: bytesChunkAccessor(layer.data),
+			ctx:           ctx,
+			blobInfo:      types.BlobInfo{Digest: blobDigest, Size: blobSize},
+		}, func() error { return nil }, nil
+	}
+	child, err := store.CreateLayer("", layers[0].ID, nil, "", true, nil)
+	require.NoError(t, err)
+	mountPoint, err := store.Mount(child.ID, "")
+	require.NoError(t, err)
+	defer func() { _, _ = store.Unmount(child.ID, true) }()
+	assert.Equal(t, srcRef.String(), openedRef)
+	contents, err := os.ReadFile(filepath.Join(mountPoint, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("contents"), contents)
+}
+
 func TestNamespaces(t *testing.T) {
 	newStore(t)
 
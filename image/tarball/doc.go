@@ -1,6 +1,12 @@
 // Package tarball provides a way to generate images using one or more layer
 // tarballs and an optional template configuration.
 //
+// Images can also be exported to a directory, as separate layer tarballs
+// (layer-1.tar, layer-2.tar.gz, …) along with config.json and manifest.json,
+// or, if types.SystemContext.TarballFlatten is set, as a single rootfs.tar
+// containing the image’s root filesystem along with config.json.
+// A directory with separate layer tarballs can be read back as an image.
+//
 // An example:
 //
 //	package main
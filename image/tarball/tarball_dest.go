@@ -0,0 +1,307 @@
+package tarball
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/image/v5/internal/imagedestination/impl"
+	"go.podman.io/image/v5/internal/imagedestination/stagedblobs"
+	"go.podman.io/image/v5/internal/imagedestination/stubs"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/compression"
+	compressionTypes "go.podman.io/image/v5/pkg/compression/types"
+	"go.podman.io/image/v5/types"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/idtools"
+)
+
+const (
+	// configFileName is the name of the image config in an exported directory.
+	configFileName = "config.json"
+	// manifestFileName is the name of the image manifest in an exported directory; it is not written when flattening.
+	manifestFileName = "manifest.json"
+	// rootfsFileName is the name of the flattened root filesystem tarball, without a compression extension.
+	rootfsFileName = "rootfs.tar"
+	// stagingDirPrefix is the prefix of a temporary directory used for storing blobs until the image is committed.
+	stagingDirPrefix = ".tarball-blobs-"
+)
+
+// ErrNotTarballExportDir is returned when writing to a non-empty directory which does not contain an exported image.
+var ErrNotTarballExportDir = errors.New("not an exported tarball image directory, don't want to overwrite important data")
+
+// compressionExtensions maps compression algorithm names to file name extensions of exported layers.
+var compressionExtensions = map[string]string{
+	compressionTypes.Bzip2AlgorithmName: ".bz2",
+	compressionTypes.GzipAlgorithmName:  ".gz",
+	compressionTypes.XzAlgorithmName:    ".xz",
+	compressionTypes.ZstdAlgorithmName:  ".zst",
+}
+
+// layerFileName returns the name of the exported layer tarball with index (counting from 0), compressed as indicated by extension.
+func layerFileName(index int, extension string) string {
+	return fmt.Sprintf("layer-%d.tar%s", index+1, extension)
+}
+
+type tarballImageDestination struct {
+	impl.Compat
+	impl.PropertyMethodsInitialize
+	stubs.IgnoresOriginalOCIConfig
+	stubs.NoPutBlobPartialInitialize
+	stubs.NoSignaturesInitialize
+
+	ref        *tarballReference
+	path       string           // The directory to export the image to
+	stagingDir string           // A subdirectory of path, storing blobs until the image is committed
+	blobs      *stagedblobs.Dir // Stored in stagingDir
+	flatten    bool
+	manifest   []byte // or nil if not yet known
+}
+
+// newImageDestination returns an ImageDestination for exporting an image to a directory.
+func newImageDestination(sys *types.SystemContext, ref *tarballReference) (private.ImageDestination, error) {
+	if len(ref.filenames) != 1 || ref.filenames[0] == "-" {
+		return nil, fmt.Errorf(`"tarball:" destinations must be a single directory path, not %q`, ref.StringWithinTransport())
+	}
+	path := ref.filenames[0]
+
+	desiredLayerCompression := types.PreserveOriginal
+	flatten := false
+	if sys != nil {
+		if sys.TarballForceCompress {
+			desiredLayerCompression = types.Compress
+
+			if sys.TarballForceDecompress {
+				return nil, fmt.Errorf("Cannot compress and decompress at the same time")
+			}
+		}
+		if sys.TarballForceDecompress {
+			desiredLayerCompression = types.Decompress
+		}
+		flatten = sys.TarballFlatten
+	}
+
+	// Only overwrite existing directories if they contain a previously exported image.
+	entries, err := os.ReadDir(path)
+	switch {
+	case err == nil:
+		if len(entries) != 0 {
+			if _, err := os.Stat(filepath.Join(path, configFileName)); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil, ErrNotTarballExportDir
+				}
+				return nil, err
+			}
+			for _, e := range entries {
+				if err := os.RemoveAll(filepath.Join(path, e.Name())); err != nil {
+					return nil, fmt.Errorf("erasing contents in %q: %w", path, err)
+				}
+			}
+			logrus.Debugf("overwriting existing exported image directory %q", path)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, fmt.Errorf("unable to create directory %q: %w", path, err)
+		}
+	default:
+		return nil, err
+	}
+	// The staging directory is on the same filesystem as path, so that blobs can be linked into place.
+	stagingDir, err := os.MkdirTemp(path, stagingDirPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("creating temp directory: %w", err)
+	}
+
+	d := &tarballImageDestination{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			SupportedManifestMIMETypes:     []string{imgspecv1.MediaTypeImageManifest, manifest.DockerV2Schema2MediaType},
+			DesiredLayerCompression:        desiredLayerCompression,
+			AcceptsForeignLayerURLs:        false,
+			MustMatchRuntimeOS:             false,
+			IgnoresEmbeddedDockerReference: false, // N/A, DockerReference() returns nil.
+			HasThreadSafePutBlob:           true,
+		}),
+		NoPutBlobPartialInitialize: stubs.NoPutBlobPartial(ref),
+		NoSignaturesInitialize:     stubs.NoSignatures("Storing signatures for tarball exports is not supported"),
+
+		ref:        ref,
+		path:       path,
+		stagingDir: stagingDir,
+		blobs:      stagedblobs.New(stagingDir),
+		flatten:    flatten,
+	}
+	d.Compat = impl.AddCompat(d)
+	return d, nil
+}
+
+// Reference returns the reference used to set up this destination.  Note that this should directly correspond to user's intent,
+// e.g. it should use the public hostname instead of the result of resolving CNAMEs or following redirects.
+func (d *tarballImageDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+// Close removes resources associated with an initialized ImageDestination, if any.
+func (d *tarballImageDestination) Close() error {
+	return os.RemoveAll(d.stagingDir)
+}
+
+// PutBlobWithOptions writes contents of stream and returns data representing the result.
+// inputInfo.Digest can be optionally provided if known; if provided, and stream is read to the end without error, the digest MUST match the stream contents.
+// inputInfo.Size is the expected length of stream, if known.
+// inputInfo.MediaType describes the blob format, if known.
+// WARNING: The contents of stream are being verified on the fly.  Until stream.Read() returns io.EOF, the contents of the data SHOULD NOT be available
+// to any other readers for download using the supplied digest.
+// If stream.Read() at any time, ESPECIALLY at end of input, returns an error, PutBlobWithOptions MUST 1) fail, and 2) delete any data stored so far.
+func (d *tarballImageDestination) PutBlobWithOptions(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, options private.PutBlobOptions) (private.UploadedBlob, error) {
+	return d.blobs.PutBlob(ctx, stream, inputInfo)
+}
+
+// TryReusingBlobWithOptions checks whether the transport already contains, or can efficiently reuse, a blob, and if so, applies it to the current destination
+// (e.g. if the blob is a filesystem layer, this signifies that the changes it describes need to be applied again when composing a filesystem tree).
+// info.Digest must not be empty.
+// If the blob has been successfully reused, returns (true, info, nil).
+// If the transport can not reuse the requested blob, TryReusingBlob returns (false, {}, nil); it returns a non-nil error only on an unexpected failure.
+func (d *tarballImageDestination) TryReusingBlobWithOptions(ctx context.Context, info types.BlobInfo, options private.TryReusingBlobOptions) (bool, private.ReusedBlob, error) {
+	return d.blobs.TryReusingBlob(info, options)
+}
+
+// PutManifest writes manifest to the destination.
+// If instanceDigest is not nil, it contains a digest of the specific manifest instance to write the manifest for (when
+// the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// It is expected but not enforced that the instanceDigest, when specified, matches the digest of `manifest` as generated
+// by `manifest.Digest()`.
+// FIXME? This should also receive a MIME type if known, to differentiate between schema versions.
+// If the destination is in principle available, refuses this manifest type (e.g. it does not recognize the schema),
+// but may accept a different manifest type, the returned error must be an ManifestTypeRejectedError.
+func (d *tarballImageDestination) PutManifest(ctx context.Context, m []byte, instanceDigest *digest.Digest) error {
+	if instanceDigest != nil {
+		return fmt.Errorf("manifest lists are not supported by the %q transport", transportName)
+	}
+	mimeType := manifest.GuessMIMEType(m)
+	if mimeType != imgspecv1.MediaTypeImageManifest && mimeType != manifest.DockerV2Schema2MediaType {
+		return types.ManifestTypeRejectedError{Err: fmt.Errorf("manifest type %q is not supported by the %q transport", mimeType, transportName)}
+	}
+	d.manifest = m
+	return nil
+}
+
+// CommitWithOptions marks the process of storing the image as successful and asks for the image to be persisted.
+// WARNING: This does not have any transactional semantics:
+// - Uploaded data MAY be visible to others before CommitWithOptions() is called
+// - Uploaded data MAY be removed or MAY remain around if Close() is called without CommitWithOptions() (i.e. rollback is allowed but not guaranteed)
+func (d *tarballImageDestination) CommitWithOptions(ctx context.Context, options private.CommitOptions) error {
+	if d.manifest == nil {
+		return errors.New("Internal error: CommitWithOptions called without PutManifest")
+	}
+	m, err := manifest.FromBlob(d.manifest, manifest.GuessMIMEType(d.manifest))
+	if err != nil {
+		return fmt.Errorf("parsing manifest: %w", err)
+	}
+	configInfo := m.ConfigInfo()
+	if configInfo.Digest == "" {
+		return fmt.Errorf("images without a config are not supported by the %q transport", transportName)
+	}
+	configPath, err := d.blobs.BlobPath(configInfo.Digest)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(configPath, filepath.Join(d.path, configFileName)); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	if d.flatten {
+		return d.writeRootFS(m.LayerInfos())
+	}
+	for i, layer := range m.LayerInfos() {
+		if err := d.writeLayer(i, layer.BlobInfo); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(d.path, manifestFileName), d.manifest, 0o644)
+}
+
+// writeLayer exports the layer with index, described by layerInfo, keeping its compression.
+func (d *tarballImageDestination) writeLayer(index int, layerInfo types.BlobInfo) error {
+	blobPath, err := d.blobs.BlobPath(layerInfo.Digest)
+	if err != nil {
+		return err
+	}
+	extension, err := func() (string, error) { // A scope for defer
+		f, err := os.Open(blobPath)
+		if err != nil {
+			return "", fmt.Errorf("opening layer %s: %w", layerInfo.Digest, err)
+		}
+		defer f.Close()
+		format, decompressor, _, err := compression.DetectCompressionFormat(f)
+		if err != nil {
+			return "", fmt.Errorf("detecting compression of layer %s: %w", layerInfo.Digest, err)
+		}
+		if decompressor == nil {
+			return "", nil
+		}
+		extension, ok := compressionExtensions[format.BaseVariantName()]
+		if !ok {
+			return "", fmt.Errorf("layer %s uses unsupported compression %q", layerInfo.Digest, format.Name())
+		}
+		return extension, nil
+	}()
+	if err != nil {
+		return err
+	}
+	// Link, instead of renaming, because the same blob may be used for several layers.
+	if err := os.Link(blobPath, filepath.Join(d.path, layerFileName(index, extension))); err != nil {
+		return fmt.Errorf("writing layer %s: %w", layerInfo.Digest, err)
+	}
+	return nil
+}
+
+// writeRootFS exports a single tarball with the result of applying all of layers, in order.
+func (d *tarballImageDestination) writeRootFS(layers []manifest.LayerInfo) error {
+	rootfsPath := filepath.Join(d.stagingDir, "rootfs")
+	if err := os.Mkdir(rootfsPath, 0o755); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if layer.EmptyLayer {
+			continue
+		}
+		if err := d.blobs.ApplyLayer(rootfsPath, layer.BlobInfo); err != nil {
+			return err
+		}
+	}
+
+	tarOptions := &archive.TarOptions{Compression: archive.Uncompressed}
+	name := rootfsFileName
+	if d.DesiredLayerCompression() == types.Compress {
+		tarOptions.Compression = archive.Gzip
+		name += compressionExtensions[compressionTypes.GzipAlgorithmName]
+	}
+	if os.Geteuid() != 0 {
+		// The extracted files are owned by the current user; record them as owned by root, as in the original image.
+		tarOptions.ChownOpts = &idtools.IDPair{UID: 0, GID: 0}
+	}
+	rootfs, err := archive.TarWithOptions(rootfsPath, tarOptions)
+	if err != nil {
+		return fmt.Errorf("creating root filesystem tarball: %w", err)
+	}
+	defer rootfs.Close()
+	tmpFile, err := os.CreateTemp(d.stagingDir, "rootfs")
+	if err != nil {
+		return err
+	}
+	defer tmpFile.Close()
+	if _, err := io.Copy(tmpFile, rootfs); err != nil {
+		return fmt.Errorf("writing root filesystem tarball: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filepath.Join(d.path, name))
+}
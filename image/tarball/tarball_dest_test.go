@@ -0,0 +1,206 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/image/v5/internal/private"
+	"go.podman.io/image/v5/manifest"
+	"go.podman.io/image/v5/pkg/blobinfocache/memory"
+	"go.podman.io/image/v5/types"
+)
+
+var _ private.ImageDestination = (*tarballImageDestination)(nil)
+
+// makeTestLayer returns an uncompressed layer tarball with files, in order; a nil value adds a directory.
+func makeTestLayer(t *testing.T, files []string, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range files {
+		c, ok := contents[name]
+		if !ok {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0o755}))
+			continue
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(c))}))
+		_, err := tw.Write([]byte(c))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// putTestImage writes an image with an uncompressed and a gzip-compressed layer to dest,
+// and returns the layers (as written), the config and the manifest.
+func putTestImage(t *testing.T, dest types.ImageDestination) ([][]byte, []byte, []byte) {
+	ctx := context.Background()
+	cache := memory.New()
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	_, err := gzw.Write(makeTestLayer(t, []string{"dir/.wh.removed", "dir/new"}, map[string]string{"dir/.wh.removed": "", "dir/new": "new"}))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	layers := [][]byte{
+		makeTestLayer(t, []string{"dir/", "dir/kept", "dir/removed"}, map[string]string{"dir/kept": "kept", "dir/removed": "removed"}),
+		compressed.Bytes(),
+	}
+	layerDescriptors := []imgspecv1.Descriptor{}
+	for i, layer := range layers {
+		info, err := dest.PutBlob(ctx, bytes.NewReader(layer), types.BlobInfo{Size: int64(len(layer))}, cache, false)
+		require.NoError(t, err)
+		mediaType := imgspecv1.MediaTypeImageLayer
+		if i == 1 {
+			mediaType = imgspecv1.MediaTypeImageLayerGzip
+		}
+		layerDescriptors = append(layerDescriptors, imgspecv1.Descriptor{MediaType: mediaType, Digest: info.Digest, Size: info.Size})
+	}
+	config, err := json.Marshal(imgspecv1.Image{
+		Platform: imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+		Config:   imgspecv1.ImageConfig{Cmd: []string{"/bin/sh"}},
+	})
+	require.NoError(t, err)
+	configInfo, err := dest.PutBlob(ctx, bytes.NewReader(config), types.BlobInfo{Size: int64(len(config))}, cache, true)
+	require.NoError(t, err)
+	manifestBlob, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configInfo.Digest,
+		Size:      configInfo.Size,
+	}, layerDescriptors).Serialize()
+	require.NoError(t, err)
+	require.NoError(t, dest.PutManifest(ctx, manifestBlob, nil))
+	return layers, config, manifestBlob
+}
+
+// dirEntryNames returns names of all entries in dir.
+func dirEntryNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestTarballDestinationLayers(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "export")
+	ref, err := Transport.ParseReference(dir)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(ctx, nil)
+	require.NoError(t, err)
+	layers, config, manifestBlob := putTestImage(t, dest)
+	require.NoError(t, dest.Commit(ctx, nil))
+	require.NoError(t, dest.Close())
+
+	assert.ElementsMatch(t, []string{"config.json", "manifest.json", "layer-1.tar", "layer-2.tar.gz"}, dirEntryNames(t, dir))
+	for file, expected := range map[string][]byte{
+		"config.json":    config,
+		"manifest.json":  manifestBlob,
+		"layer-1.tar":    layers[0],
+		"layer-2.tar.gz": layers[1],
+	} {
+		contents, err := os.ReadFile(filepath.Join(dir, file))
+		require.NoError(t, err)
+		assert.Equal(t, expected, contents, file)
+	}
+
+	// The exported directory can be read back.
+	src, err := ref.NewImageSource(ctx, nil)
+	require.NoError(t, err)
+	defer src.Close()
+	readManifest, mimeType, err := src.GetManifest(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, readManifest)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+	m, err := manifest.OCI1FromManifest(readManifest)
+	require.NoError(t, err)
+	for i, layer := range m.Layers {
+		stream, size, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: -1}, memory.New())
+		require.NoError(t, err)
+		contents, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+		assert.Equal(t, layers[i], contents)
+		assert.Equal(t, int64(len(layers[i])), size)
+	}
+
+	// An existing export is overwritten.
+	dest, err = ref.NewImageDestination(ctx, nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	assert.Len(t, dirEntryNames(t, dir), 1) // Only the staging directory
+}
+
+func TestTarballDestinationFlatten(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ref, err := NewReference([]string{dir}, nil)
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(ctx, &types.SystemContext{TarballFlatten: true})
+	require.NoError(t, err)
+	_, config, _ := putTestImage(t, dest)
+	require.NoError(t, dest.Commit(ctx, nil))
+	require.NoError(t, dest.Close())
+
+	assert.ElementsMatch(t, []string{"config.json", "rootfs.tar"}, dirEntryNames(t, dir))
+	contents, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	assert.Equal(t, config, contents)
+
+	f, err := os.Open(filepath.Join(dir, "rootfs.tar"))
+	require.NoError(t, err)
+	defer f.Close()
+	files := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, 0, h.Uid, h.Name)
+		contents, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[filepath.Clean(h.Name)] = string(contents)
+	}
+	assert.Equal(t, map[string]string{"dir": "", "dir/kept": "kept", "dir/new": "new"}, files)
+}
+
+func TestNewTarballImageDestination(t *testing.T) {
+	ctx := context.Background()
+
+	// Directories which don't contain an exported image are not overwritten
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "important"), []byte("data"), 0o600))
+	ref, err := NewReference([]string{dir}, nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(ctx, nil)
+	assert.ErrorIs(t, err, ErrNotTarballExportDir)
+
+	// Only a single directory is supported
+	ref, err = NewReference([]string{dir, dir}, nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(ctx, nil)
+	assert.Error(t, err)
+	ref, err = NewReference([]string{"-"}, nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(ctx, nil)
+	assert.Error(t, err)
+
+	// Conflicting compression options
+	ref, err = NewReference([]string{t.TempDir()}, nil)
+	require.NoError(t, err)
+	_, err = ref.NewImageDestination(ctx, &types.SystemContext{TarballForceCompress: true, TarballForceDecompress: true})
+	assert.Error(t, err)
+}
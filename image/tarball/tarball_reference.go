@@ -70,13 +70,21 @@ func (r *tarballReference) NewImage(ctx context.Context, sys *types.SystemContex
 
 func (r *tarballReference) DeleteImage(ctx context.Context, sys *types.SystemContext) error {
 	for _, filename := range r.filenames {
-		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		remove := os.Remove
+		if fi, err := os.Stat(filename); err == nil && fi.IsDir() {
+			remove = os.RemoveAll // An image exported by a "tarball:" destination
+		}
+		if err := remove(filename); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("error removing %q: %w", filename, err)
 		}
 	}
 	return nil
 }
 
+// NewImageDestination returns a types.ImageDestination for this reference.
+// The caller must call .Close() on the returned ImageDestination.
+// The reference must be a single directory path; the image is exported to it as separate layer tarballs,
+// or as a single root filesystem tarball if sys.TarballFlatten is set.
 func (r *tarballReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
-	return nil, fmt.Errorf(`"tarball:" locations can only be read from, not written to`)
+	return newImageDestination(sys, r)
 }
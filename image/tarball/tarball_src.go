@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
 	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,6 +20,8 @@ import (
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"go.podman.io/image/v5/internal/imagesource/impl"
 	"go.podman.io/image/v5/internal/imagesource/stubs"
+	"go.podman.io/image/v5/internal/iolimits"
+	"go.podman.io/image/v5/manifest"
 	"go.podman.io/image/v5/pkg/compression"
 	compressionTypes "go.podman.io/image/v5/pkg/compression/types"
 	"go.podman.io/image/v5/types"
@@ -29,9 +34,10 @@ type tarballImageSource struct {
 	impl.DoesNotAffectLayerInfosForCopy
 	stubs.NoGetBlobAtInitialize
 
-	reference tarballReference
-	blobs     map[digest.Digest]tarballBlob
-	manifest  []byte
+	reference        tarballReference
+	blobs            map[digest.Digest]tarballBlob
+	manifest         []byte
+	manifestMIMEType string
 }
 
 // tarballBlob is a blob that tarballImagSource can return by GetBlob.
@@ -42,6 +48,12 @@ type tarballBlob struct {
 }
 
 func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	if len(r.filenames) == 1 && r.filenames[0] != "-" {
+		if fi, err := os.Stat(r.filenames[0]); err == nil && fi.IsDir() {
+			return r.newExportedImageSource(r.filenames[0])
+		}
+	}
+
 	// Pick up the layer comment from the configuration's history list, if one is set.
 	comment := "imported from tarball"
 	if len(r.config.History) > 0 && r.config.History[0].Comment != "" {
@@ -203,15 +215,91 @@ func (r *tarballReference) NewImageSource(ctx context.Context, sys *types.System
 		}),
 		NoGetBlobAtInitialize: stubs.NoGetBlobAt(r),
 
-		reference: *r,
-		blobs:     blobs,
-		manifest:  manifestBytes,
+		reference:        *r,
+		blobs:            blobs,
+		manifest:         manifestBytes,
+		manifestMIMEType: imgspecv1.MediaTypeImageManifest,
 	}
 	src.Compat = impl.AddCompat(src)
 
 	return src, nil
 }
 
+// newExportedImageSource returns an ImageSource for an image exported to path by a "tarball:" destination.
+// Values set using ConfigUpdate are not used for such images.
+func (r *tarballReference) newExportedImageSource(path string) (types.ImageSource, error) {
+	manifestFile, err := os.Open(filepath.Join(path, manifestFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%q does not contain an exported image with separate layers: %w", path, err)
+		}
+		return nil, err
+	}
+	defer manifestFile.Close()
+	manifestBytes, err := iolimits.ReadAtMost(manifestFile, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest in %q: %w", path, err)
+	}
+	mimeType := manifest.GuessMIMEType(manifestBytes)
+	m, err := manifest.FromBlob(manifestBytes, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest in %q: %w", path, err)
+	}
+
+	blobs := map[digest.Digest]tarballBlob{}
+	addBlob := func(blobDigest digest.Digest, filename string) error {
+		fileinfo, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		blobs[blobDigest] = tarballBlob{
+			filename: filename,
+			size:     fileinfo.Size(),
+		}
+		return nil
+	}
+	if err := addBlob(m.ConfigInfo().Digest, filepath.Join(path, configFileName)); err != nil {
+		return nil, err
+	}
+	for i, layer := range m.LayerInfos() {
+		filename, err := findLayerFile(path, i)
+		if err != nil {
+			return nil, err
+		}
+		if err := addBlob(layer.Digest, filename); err != nil {
+			return nil, err
+		}
+	}
+
+	src := &tarballImageSource{
+		PropertyMethodsInitialize: impl.PropertyMethods(impl.Properties{
+			HasThreadSafeGetBlob: false,
+		}),
+		NoGetBlobAtInitialize: stubs.NoGetBlobAt(r),
+
+		reference:        *r,
+		blobs:            blobs,
+		manifest:         manifestBytes,
+		manifestMIMEType: mimeType,
+	}
+	src.Compat = impl.AddCompat(src)
+	return src, nil
+}
+
+// findLayerFile returns the path of the layer tarball with index (counting from 0) in an image exported to path,
+// whichever compression it uses.
+func findLayerFile(path string, index int) (string, error) {
+	for _, extension := range append([]string{""}, slices.Sorted(maps.Values(compressionExtensions))...) {
+		filename := filepath.Join(path, layerFileName(index, extension))
+		if _, err := os.Lstat(filename); err == nil {
+			return filename, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("layer %d not found in %q", index+1, path)
+}
+
 func (is *tarballImageSource) Close() error {
 	return nil
 }
@@ -242,7 +330,7 @@ func (is *tarballImageSource) GetManifest(ctx context.Context, instanceDigest *d
 	if instanceDigest != nil {
 		return nil, "", fmt.Errorf("manifest lists are not supported by the %q transport", transportName)
 	}
-	return is.manifest, imgspecv1.MediaTypeImageManifest, nil
+	return is.manifest, is.manifestMIMEType, nil
 }
 
 func (is *tarballImageSource) Reference() types.ImageReference {
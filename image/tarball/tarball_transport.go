@@ -41,6 +41,10 @@ func (t *tarballTransport) ParseReference(reference string) (types.ImageReferenc
 		}
 		f, err := os.Open(filename)
 		if err != nil {
+			// A single path which does not exist yet may be a destination directory.
+			if len(filenames) == 1 && filename != "" && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
 			return nil, fmt.Errorf("error opening %q: %w", filename, err)
 		}
 		f.Close()
@@ -0,0 +1,24 @@
+package tarball
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportParseReference(t *testing.T) {
+	dir := t.TempDir()
+
+	// A single path which does not exist yet is accepted, as a possible destination.
+	_, err := Transport.ParseReference(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+
+	for _, ref := range []string{
+		"",
+		filepath.Join(dir, "missing1") + ":" + filepath.Join(dir, "missing2"),
+	} {
+		_, err := Transport.ParseReference(ref)
+		assert.Error(t, err, ref)
+	}
+}
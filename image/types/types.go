@@ -563,6 +563,22 @@ const (
 	ShortNameModeEnforcing
 )
 
+// LayerVerificationMode determines how strictly the uncompressed contents of layers written to
+// local storage are verified against the DiffID values in the image’s config.
+type LayerVerificationMode int
+
+const (
+	// LayerVerificationRelaxed verifies layer DiffID values where that is necessary to ensure the image
+	// contents are consistent (e.g. for partially-pulled layers), but accepts layers which can’t be verified,
+	// e.g. schema1 images which don’t have DiffID values, or partial pulls if the storage is configured
+	// not to compute uncompressed digests. This is the default.
+	LayerVerificationRelaxed LayerVerificationMode = iota
+	// LayerVerificationStrict requires the uncompressed digest of every layer, including partially-pulled
+	// and reused layers, to be known and to match the corresponding DiffID value in the config
+	// before the image is committed; images which can’t be verified this way are rejected.
+	LayerVerificationStrict
+)
+
 // SystemContext allows parameterizing access to implicitly-accessed resources,
 // like configuration files in /etc and users' login state in their home directory.
 // Various components can share the same field only if their semantics is exactly
@@ -616,6 +632,9 @@ type SystemContext struct {
 	VariantChoice string
 	// If not "", overrides the system's default directory containing a blob info cache.
 	BlobInfoCacheDir string
+	// If not "", the URL of a shared blob info cache service (see pkg/blobinfocache/remote), used in addition to
+	// the local blob info cache. All data provided by the service is trusted.
+	BlobInfoCacheURL string
 	// Additional tags when creating or copying a docker-archive.
 	DockerArchiveAdditionalTags []reference.NamedTagged
 	// If not "", overrides the temporary directory to use for storing big files
@@ -653,6 +672,10 @@ type SystemContext struct {
 	DockerAuthConfig *DockerAuthConfig
 	// if not "", the library uses this registry token to authenticate to the registry
 	DockerBearerRegistryToken string
+	// If not "", bearer tokens obtained from registries are cached in this directory, and shared with other
+	// processes using the same directory, instead of obtaining a new token in every process.
+	// The directory is created with 0700 permissions if it does not exist; the tokens are only readable by the owner.
+	DockerBearerTokenCacheDir string
 	// if not "", an User-Agent header is added to each request when contacting a registry.
 	DockerRegistryUserAgent string
 	// if true, a V1 ping attempt isn't done to give users a better error. Default is false.
@@ -690,12 +713,24 @@ type SystemContext struct {
 	// Used to skip TLS verification, off by default. To take effect DockerDaemonCertPath needs to be specified as well.
 	DockerDaemonInsecureSkipTLSVerify bool
 
+	// === containers-storage.Transport overrides ===
+	// How strictly layers written to storage are verified against the image’s config. Defaults to LayerVerificationRelaxed.
+	StorageLayerVerification LayerVerificationMode
+
 	// === dir.Transport overrides ===
 	// DirForceCompress compresses the image layers if set to true
 	DirForceCompress bool
 	// DirForceDecompress decompresses the image layers if set to true
 	DirForceDecompress bool
 
+	// === tarball.Transport overrides ===
+	// TarballForceCompress compresses the exported layers if set to true
+	TarballForceCompress bool
+	// TarballForceDecompress decompresses the exported layers if set to true
+	TarballForceDecompress bool
+	// TarballFlatten exports a single tarball with the image’s root filesystem, instead of separate layer tarballs, if set to true
+	TarballFlatten bool
+
 	// CompressionFormat is the format to use for the compression of the blobs
 	CompressionFormat *compression.Algorithm
 	// CompressionLevel specifies what compression level is used
@@ -5,11 +5,16 @@ import (
 	"io"
 	"os"
 
+	"github.com/docker/go-units"
 	"go.podman.io/storage"
 	"go.podman.io/storage/pkg/mflag"
 )
 
-var paramContainerDataFile = ""
+var (
+	paramContainerDataFile = ""
+	paramQuotaSize         = ""
+	paramQuotaInodes       = uint64(0)
+)
 
 func container(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
 	images, err := m.Images()
@@ -209,6 +214,61 @@ func containerParentOwners(flags *mflag.FlagSet, action string, m storage.Store,
 	return 0, nil
 }
 
+func setContainerQuota(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	container, err := m.Container(args[0])
+	if err != nil {
+		return 1, err
+	}
+	options := storage.DiskQuotaOptions{}
+	if paramQuotaSize != "" {
+		size, err := units.RAMInBytes(paramQuotaSize)
+		if err != nil {
+			return 1, fmt.Errorf("parsing size %q: %w", paramQuotaSize, err)
+		}
+		quotaSize := uint64(size)
+		options.Size = &quotaSize
+	}
+	if flags.IsSet("-inodes") || flags.IsSet("i") {
+		options.Inodes = &paramQuotaInodes
+	}
+	if err := m.SetContainerQuota(container.ID, &options); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func containerQuota(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	container, err := m.Container(args[0])
+	if err != nil {
+		return 1, err
+	}
+	quota, usage, err := m.ContainerQuota(container.ID)
+	if err != nil {
+		return 1, err
+	}
+	if jsonOutput {
+		return outputJSON(struct {
+			ID         string
+			Size       uint64
+			Inodes     uint64
+			Used       int64
+			InodesUsed int64
+		}{
+			ID:         container.ID,
+			Size:       quota.Size,
+			Inodes:     quota.Inodes,
+			Used:       usage.Size,
+			InodesUsed: usage.InodeCount,
+		})
+	}
+	fmt.Printf("ID: %s\n", container.ID)
+	fmt.Printf("Size limit: %d\n", quota.Size)
+	fmt.Printf("Inodes limit: %d\n", quota.Inodes)
+	fmt.Printf("Used: %d\n", usage.Size)
+	fmt.Printf("Inodes used: %d\n", usage.InodeCount)
+	return 0, nil
+}
+
 func init() {
 	commands = append(commands,
 		command{
@@ -287,6 +347,29 @@ func init() {
 			minArgs:     1,
 			maxArgs:     1,
 		},
+		command{
+			names:       []string{"set-container-quota", "setcontainerquota"},
+			optionsHelp: "[options [...]] containerNameOrID",
+			usage:       "Set, change or remove limits on the disk space used by a container",
+			action:      setContainerQuota,
+			minArgs:     1,
+			maxArgs:     1,
+			addFlags: func(flags *mflag.FlagSet, cmd *command) {
+				flags.StringVar(&paramQuotaSize, []string{"-size", "s"}, paramQuotaSize, "Maximum size of the container's layer")
+				flags.Uint64Var(&paramQuotaInodes, []string{"-inodes", "i"}, paramQuotaInodes, "Maximum number of inodes in the container's layer")
+			},
+		},
+		command{
+			names:       []string{"container-quota", "containerquota"},
+			optionsHelp: "[options [...]] containerNameOrID",
+			usage:       "Show the limits on, and usage of, the disk space used by a container",
+			action:      containerQuota,
+			minArgs:     1,
+			maxArgs:     1,
+			addFlags: func(flags *mflag.FlagSet, cmd *command) {
+				flags.BoolVar(&jsonOutput, []string{"-json", "j"}, jsonOutput, "Prefer JSON output")
+			},
+		},
 		command{
 			names:       []string{"container-parent-owners"},
 			optionsHelp: "[options [...]] containerNameOrID [...]",
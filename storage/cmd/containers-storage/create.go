@@ -142,6 +142,21 @@ func createLayer(flags *mflag.FlagSet, action string, m storage.Store, args []st
 	return 0, nil
 }
 
+func commitLayer(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	layer, err := m.CommitContainerLayer(args[0], &storage.CommitLayerOptions{ID: paramID, Names: paramNames})
+	if err != nil {
+		return 1, err
+	}
+	if jsonOutput {
+		return outputJSON(layer)
+	}
+	fmt.Printf("%s\n", layer.ID)
+	for _, name := range layer.Names {
+		fmt.Printf("\t%s\n", name)
+	}
+	return 0, nil
+}
+
 func importLayer(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
 	parent := ""
 	if len(args) > 0 {
@@ -309,6 +324,19 @@ func init() {
 			flags.StringVar(&paramSubGIDMap, []string{"-subgidmap"}, "", "subgid GID map for a group")
 		},
 	})
+	commands = append(commands, command{
+		names:       []string{"commit-layer", "commitlayer"},
+		optionsHelp: "[options [...]] containerNameOrID",
+		usage:       "Create a new layer with the contents of a container's layer",
+		minArgs:     1,
+		maxArgs:     1,
+		action:      commitLayer,
+		addFlags: func(flags *mflag.FlagSet, cmd *command) {
+			flags.Var(opts.NewListOptsRef(&paramNames, nil), []string{"-name", "n"}, "Layer name")
+			flags.StringVar(&paramID, []string{"-id", "i"}, "", "Layer ID")
+			flags.BoolVar(&jsonOutput, []string{"-json", "j"}, jsonOutput, "Prefer JSON output")
+		},
+	})
 	commands = append(commands, command{
 		names:       []string{"create-image", "createimage"},
 		optionsHelp: "[options [...]] topLayerNameOrID",
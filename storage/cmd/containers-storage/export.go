@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"go.podman.io/storage"
+	"go.podman.io/storage/internal/opts"
+	"go.podman.io/storage/pkg/mflag"
+)
+
+var (
+	exportFile       = ""
+	exportImages     []string
+	exportContainers = false
+	importFile       = ""
+)
+
+func exportStore(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	archive := io.Writer(os.Stdout)
+	if exportFile != "" {
+		f, err := os.Create(exportFile)
+		if err != nil {
+			return 1, err
+		}
+		archive = f
+		defer f.Close()
+	}
+	filter := storage.ExportFilter{
+		Images:     append(exportImages, args...),
+		Containers: exportContainers,
+	}
+	if err := m.Export(archive, &filter); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func importStore(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	archive := io.Reader(os.Stdin)
+	if importFile != "" {
+		f, err := os.Open(importFile)
+		if err != nil {
+			return 1, err
+		}
+		archive = f
+		defer f.Close()
+	}
+	if err := m.Import(archive); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func init() {
+	commands = append(commands, command{
+		names:       []string{"export"},
+		optionsHelp: "[options [...]] [imageNameOrID [...]]",
+		usage:       "Export images, their layers, and optionally containers",
+		minArgs:     0,
+		maxArgs:     -1,
+		action:      exportStore,
+		addFlags: func(flags *mflag.FlagSet, cmd *command) {
+			flags.StringVar(&exportFile, []string{"-file", "f"}, "", "Write to file instead of stdout")
+			flags.Var(opts.NewListOptsRef(&exportImages, nil), []string{"-image", "i"}, "Image to export")
+			flags.BoolVar(&exportContainers, []string{"-containers", "c"}, exportContainers, "Export containers based on the exported images")
+		},
+	})
+	commands = append(commands, command{
+		names:       []string{"import"},
+		optionsHelp: "[options [...]]",
+		usage:       "Import images, layers and containers written by export",
+		minArgs:     0,
+		maxArgs:     0,
+		action:      importStore,
+		addFlags: func(flags *mflag.FlagSet, cmd *command) {
+			flags.StringVar(&importFile, []string{"-file", "f"}, "", "Read from file instead of stdin")
+		},
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"go.podman.io/storage"
+	"go.podman.io/storage/internal/opts"
+	"go.podman.io/storage/pkg/mflag"
+)
+
+var (
+	migrateDriverOptions []string
+	migrateQuickCheck    = false
+	migrateWipe          = false
+)
+
+func migrateDriver(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	options := storage.MigrateOptions{
+		GraphDriverOptions: migrateDriverOptions,
+		WipeSource:         migrateWipe,
+	}
+	if !migrateQuickCheck {
+		options.Check = storage.CheckMost()
+	}
+	dest, err := m.MigrateGraphDriver(args[0], &options)
+	if err != nil {
+		return 1, err
+	}
+	if _, err := dest.Shutdown(false); err != nil {
+		fmt.Fprintf(os.Stderr, "shutdown: %v\n", err)
+	}
+	fmt.Fprintf(os.Stdout, "migrated from %s to %s\n", m.GraphDriverName(), dest.GraphDriverName())
+	return 0, nil
+}
+
+func init() {
+	commands = append(commands, command{
+		names:       []string{"migrate-driver"},
+		optionsHelp: "[options [...]] driver",
+		usage:       "Recreate layers, images and containers using another storage driver",
+		minArgs:     1,
+		maxArgs:     1,
+		action:      migrateDriver,
+		addFlags: func(flags *mflag.FlagSet, cmd *command) {
+			flags.Var(opts.NewListOptsRef(&migrateDriverOptions, nil), []string{"-driver-opt"}, "Set options for the new storage driver")
+			flags.BoolVar(&migrateQuickCheck, []string{"-quick", "q"}, migrateQuickCheck, "Only check the digests of layers and data items after migrating")
+			flags.BoolVar(&migrateWipe, []string{"-wipe"}, migrateWipe, "Remove layers, images and containers from the old storage driver after migrating")
+		},
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	units "github.com/docker/go-units"
+	"go.podman.io/storage"
+	"go.podman.io/storage/pkg/mflag"
+)
+
+var (
+	pruneMaxSize = ""
+	pruneMaxAge  = ""
+)
+
+func pruneImages(flags *mflag.FlagSet, action string, m storage.Store, args []string) (int, error) {
+	var options *storage.PruneImagesOptions
+	if pruneMaxSize != "" || pruneMaxAge != "" {
+		options = &storage.PruneImagesOptions{}
+		if pruneMaxSize != "" {
+			size, err := units.RAMInBytes(pruneMaxSize)
+			if err != nil {
+				return 1, fmt.Errorf("parsing maximum size %q: %w", pruneMaxSize, err)
+			}
+			options.MaxSize = size
+		}
+		if pruneMaxAge != "" {
+			age, err := time.ParseDuration(pruneMaxAge)
+			if err != nil {
+				return 1, fmt.Errorf("parsing maximum age %q: %w", pruneMaxAge, err)
+			}
+			options.MaxAge = age
+		}
+	}
+	removed, err := m.PruneImages(options)
+	if jsonOutput {
+		if err != nil {
+			_, err2 := outputJSON(err)
+			return 1, err2 // Note that err2 is usually nil
+		}
+		return outputJSON(removed)
+	}
+	for _, id := range removed {
+		fmt.Printf("%s\n", id)
+	}
+	if err != nil {
+		return 1, fmt.Errorf("%s: %+v", action, err)
+	}
+	return 0, nil
+}
+
+func init() {
+	commands = append(commands, command{
+		names:   []string{"prune-images"},
+		usage:   "Remove least recently used images",
+		minArgs: 0,
+		maxArgs: 0,
+		action:  pruneImages,
+		addFlags: func(flags *mflag.FlagSet, cmd *command) {
+			flags.StringVar(&pruneMaxSize, []string{"-max-size", "s"}, pruneMaxSize, "Disk space images may use")
+			flags.StringVar(&pruneMaxAge, []string{"-max-age", "a"}, pruneMaxAge, "Maximum time an image may remain unused")
+			flags.BoolVar(&jsonOutput, []string{"-json", "j"}, jsonOutput, "Prefer JSON output")
+		},
+	})
+}
@@ -151,6 +151,7 @@ type containerStore struct {
 	lockfile *lockfile.LockFile // Synchronizes readers vs. writers of the _filesystem data_, both cross-process and in-process.
 	dir      string
 	jsonPath [numContainerLocationIndex]string
+	backend  metadataBackend
 
 	inProcessLock sync.RWMutex // Can _only_ be obtained with lockfile held.
 	// The following fields can only be read/written with read/write ownership of inProcessLock, respectively.
@@ -465,16 +466,9 @@ func (r *containerStore) load(lockedForWriting bool) (bool, error) {
 		location := containerLocationFromIndex(locationIndex)
 		rpath := r.jsonPath[locationIndex]
 
-		data, err := os.ReadFile(rpath)
-		if err != nil && !os.IsNotExist(err) {
-			return false, err
-		}
-
 		locationContainers := []*Container{}
-		if len(data) != 0 {
-			if err := json.Unmarshal(data, &locationContainers); err != nil {
-				return false, fmt.Errorf("loading %q: %w", rpath, err)
-			}
+		if err := r.backend.read(rpath, &locationContainers); err != nil {
+			return false, err
 		}
 
 		for _, container := range locationContainers {
@@ -517,15 +511,17 @@ func (r *containerStore) load(lockedForWriting bool) (bool, error) {
 		if !lockedForWriting {
 			return true, errorToResolveBySaving
 		}
-		return false, r.save(modifiedLocations)
+		return false, r.save(modifiedLocations, nil)
 	}
 	return false, nil
 }
 
 // save saves the contents of the store to disk.
+// If modifiedIDs is not nil, it contains the IDs of all containers which were added,
+// modified or removed since the store was last loaded or saved.
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for reading (but usually holds it for writing in order to make the desired changes).
-func (r *containerStore) save(saveLocations containerLocations) error {
+func (r *containerStore) save(saveLocations containerLocations, modifiedIDs []string) error {
 	r.lockfile.AssertLockedForWriting()
 	// This must be done before we write the file, because the process could be terminated
 	// after the file is written but before the lock file is updated.
@@ -550,31 +546,29 @@ func (r *containerStore) save(saveLocations containerLocations) error {
 			}
 		}
 
-		jdata, err := json.Marshal(&subsetContainers)
-		if err != nil {
-			return err
-		}
-		var opts *ioutils.AtomicFileWriterOptions
-		if location == volatileContainerLocation {
-			opts = &ioutils.AtomicFileWriterOptions{
-				NoSync: true,
-			}
-		}
-		if err := ioutils.AtomicWriteFileWithOpts(rpath, jdata, 0o600, opts); err != nil {
+		if _, err := r.backend.write(rpath, subsetContainers, modifiedIDs, location == volatileContainerLocation); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// saveFor saves the contents of the store relevant for modifiedContainer to disk.
+// saveFor saves the contents of the store relevant for modifiedContainers to disk.
+// modifiedContainers must include all containers which were added, modified or removed
+// since the store was last loaded or saved.
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for reading (but usually holds it for writing in order to make the desired changes).
-func (r *containerStore) saveFor(modifiedContainer *Container) error {
-	return r.save(containerLocation(modifiedContainer))
+func (r *containerStore) saveFor(modifiedContainers ...*Container) error {
+	var saveLocations containerLocations
+	modifiedIDs := make([]string, 0, len(modifiedContainers))
+	for _, container := range modifiedContainers {
+		saveLocations |= containerLocation(container)
+		modifiedIDs = append(modifiedIDs, container.ID)
+	}
+	return r.save(saveLocations, modifiedIDs)
 }
 
-func newContainerStore(dir string, runDir string, transient bool) (rwContainerStore, error) {
+func newContainerStore(dir string, runDir string, transient bool, backend metadataBackend) (rwContainerStore, error) {
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return nil, err
 	}
@@ -596,6 +590,7 @@ func newContainerStore(dir string, runDir string, transient bool) (rwContainerSt
 			filepath.Join(dir, "containers.json"),
 			filepath.Join(volatileDir, "volatile-containers.json"),
 		},
+		backend: backend,
 
 		containers: []*Container{},
 		byid:       make(map[string]*Container),
@@ -614,6 +609,22 @@ func newContainerStore(dir string, runDir string, transient bool) (rwContainerSt
 	if _, err := cstore.load(true); err != nil {
 		return nil, err
 	}
+	// Move records to the configured backend, if necessary.
+	var migrateLocations containerLocations
+	for locationIndex := range numContainerLocationIndex {
+		needsMigration, err := backend.needsMigration(cstore.jsonPath[locationIndex])
+		if err != nil {
+			return nil, err
+		}
+		if needsMigration {
+			migrateLocations |= containerLocationFromIndex(locationIndex)
+		}
+	}
+	if migrateLocations != 0 {
+		if err := cstore.save(migrateLocations, nil); err != nil {
+			return nil, err
+		}
+	}
 	return &cstore, nil
 }
 
@@ -621,7 +632,7 @@ func newContainerStore(dir string, runDir string, transient bool) (rwContainerSt
 func (r *containerStore) lookup(id string) (*Container, bool) {
 	if container, ok := r.byid[id]; ok {
 		return container, ok
-	} else if container, ok := r.byname[id]; ok {
+	} else if container, ok := r.lookupName(id); ok {
 		return container, ok
 	} else if container, ok := r.bylayer[id]; ok {
 		return container, ok
@@ -633,6 +644,34 @@ func (r *containerStore) lookup(id string) (*Container, bool) {
 	return nil, false
 }
 
+// lookupName returns the container with name, using the index of the metadata backend if possible.
+// Requires startReading or startWriting.
+func (r *containerStore) lookupName(name string) (*Container, bool) {
+	var containers []*Container
+	for locationIndex := range numContainerLocationIndex {
+		ids, indexed, err := lookupMetadata(r.jsonPath[locationIndex], metadataKeyName, name)
+		if err != nil {
+			logrus.Debugf("Looking up container name %q: %v", name, err)
+		}
+		if err != nil || !indexed {
+			container, ok := r.byname[name]
+			return container, ok
+		}
+		location := containerLocationFromIndex(locationIndex)
+		for _, id := range ids {
+			// Containers with duplicate IDs in other locations are ignored by load(), ignore them here as well.
+			if container, ok := r.byid[id]; ok && containerLocation(container) == location {
+				containers = append(containers, container)
+			}
+		}
+	}
+	if len(containers) == 0 {
+		return nil, false
+	}
+	// Consistent with load(), if the name is not unique, the last container wins.
+	return containers[len(containers)-1], true
+}
+
 // Requires startWriting.
 func (r *containerStore) ClearFlag(id string, flag string) error {
 	container, ok := r.lookup(id)
@@ -775,14 +814,16 @@ func (r *containerStore) updateNames(id string, names []string, op updateNameOpe
 	for _, name := range oldNames {
 		delete(r.byname, name)
 	}
+	modifiedContainers := []*Container{container}
 	for _, name := range names {
 		if otherContainer, ok := r.byname[name]; ok {
 			r.removeName(otherContainer, name)
+			modifiedContainers = append(modifiedContainers, otherContainer)
 		}
 		r.byname[name] = container
 	}
 	container.Names = names
-	return r.saveFor(container)
+	return r.saveFor(modifiedContainers...)
 }
 
 // Requires startWriting.
@@ -18,6 +18,7 @@ static void set_name_btrfs_ioctl_vol_args_v2(struct btrfs_ioctl_vol_args_v2* btr
 import "C"
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io/fs"
 	"math"
@@ -459,6 +460,43 @@ func subvolLookupQgroup(path string) (uint64, error) {
 	return uint64(args.treeid), nil
 }
 
+// qgroupUsage returns the number of bytes used exclusively by the subvolume at path,
+// as accounted by its qgroup.
+func qgroupUsage(path string) (uint64, error) {
+	qgroupid, err := subvolLookupQgroup(path)
+	if err != nil {
+		return 0, err
+	}
+	dir, err := openDir(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_search_args
+	args.key.tree_id = C.BTRFS_QUOTA_TREE_OBJECTID
+	args.key.min_type = C.BTRFS_QGROUP_INFO_KEY
+	args.key.max_type = C.BTRFS_QGROUP_INFO_KEY
+	args.key.min_offset = C.__u64(qgroupid)
+	args.key.max_offset = C.__u64(qgroupid)
+	args.key.max_transid = C.__u64(math.MaxUint64)
+	args.key.nr_items = 1
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_TREE_SEARCH,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return 0, fmt.Errorf("failed to search qgroup for %s: %w", path, errno)
+	}
+	sh := (*C.struct_btrfs_ioctl_search_header)(unsafe.Pointer(&args.buf))
+	// struct btrfs_qgroup_info_item holds little-endian generation, referenced, referenced
+	// compressed, exclusive and exclusive compressed counters.
+	if args.key.nr_items == 0 || sh._type != C.BTRFS_QGROUP_INFO_KEY || sh.len < 40 {
+		return 0, fmt.Errorf("qgroup %d not found for %s", qgroupid, path)
+	}
+	item := C.GoBytes(unsafe.Add(unsafe.Pointer(&args.buf), C.sizeof_struct_btrfs_ioctl_search_header), C.int(sh.len))
+	return binary.LittleEndian.Uint64(item[24:32]), nil
+}
+
 func (d *Driver) subvolumesDir() string {
 	return path.Join(d.home, "subvolumes")
 }
@@ -475,6 +513,11 @@ func (d *Driver) quotasDirID(id string) string {
 	return path.Join(d.quotasDir(), id)
 }
 
+// CloneLayer creates a read-only layer as a snapshot of source, which contains all of its files.
+func (d *Driver) CloneLayer(id, parent, source string, opts *graphdriver.CreateOpts) error {
+	return d.Create(id, source, opts)
+}
+
 // CreateFromTemplate creates a layer with the same contents and parent as another layer.
 func (d *Driver) CreateFromTemplate(id, template string, templateIDMappings *idtools.IDMappings, parent string, parentIDMappings *idtools.IDMappings, opts *graphdriver.CreateOpts, readWrite bool) error {
 	return d.Create(id, template, opts)
@@ -606,6 +649,66 @@ func (d *Driver) setStorageSize(dir string, quota layerQuota) error {
 	return nil
 }
 
+// SetLayerQuota sets, changes or removes the size limit for the writable layer with the specified id,
+// using the qgroup of its subvolume.
+func (d *Driver) SetLayerQuota(id string, limits graphdriver.LayerQuota) error {
+	if limits.Inodes != 0 {
+		return fmt.Errorf("btrfs: limiting the number of inodes: %w", graphdriver.ErrNotSupported)
+	}
+	dir := d.subvolumesDirID(id)
+	if err := fileutils.Exists(dir); err != nil {
+		return err
+	}
+	if limits.Size == 0 {
+		if err := fileutils.Exists(d.quotasDirID(id)); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// A limit with all bits set clears the limit.
+		if err := subvolLimitQgroup(dir, math.MaxUint64); err != nil {
+			return err
+		}
+		return os.Remove(d.quotasDirID(id))
+	}
+	if err := d.setStorageSize(dir, layerQuota{size: limits.Size}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.quotasDir(), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(d.quotasDirID(id), []byte(strconv.FormatUint(limits.Size, 10)), 0o644)
+}
+
+// LayerQuota returns the size limit for the writable layer with the specified id, and its current
+// disk usage, which only counts inodes if quotas are not enabled.
+func (d *Driver) LayerQuota(id string) (graphdriver.LayerQuota, *directory.DiskUsage, error) {
+	dir := d.subvolumesDirID(id)
+	if err := fileutils.Exists(dir); err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	var limits graphdriver.LayerQuota
+	data, err := os.ReadFile(d.quotasDirID(id))
+	if err == nil {
+		if limits.Size, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return graphdriver.LayerQuota{}, nil, fmt.Errorf("parsing quota of layer %q: %w", id, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	d.updateQuotaStatus()
+	if !d.quotaEnabled {
+		usage, err := directory.Usage(dir)
+		return limits, usage, err
+	}
+	size, err := qgroupUsage(dir)
+	if err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	return limits, &directory.DiskUsage{Size: int64(size)}, nil
+}
+
 // Remove the filesystem with given id.
 func (d *Driver) Remove(id string) error {
 	dir := d.subvolumesDirID(id)
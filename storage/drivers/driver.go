@@ -73,6 +73,8 @@ var (
 	ErrIncompatibleFS = errors.New("backing file system is unsupported for this graph driver")
 	// ErrLayerUnknown returned when the specified layer is unknown by the driver.
 	ErrLayerUnknown = errors.New("unknown layer")
+	// ErrLayerInUse returned when an operation can't be done while the layer is mounted.
+	ErrLayerInUse = errors.New("layer is in use")
 )
 
 // CreateOpts contains optional arguments for Create() and CreateReadWrite()
@@ -349,6 +351,50 @@ type ApplyDiffStaging interface {
 	CommitStagedLayer(id string, commit *tempdir.StagedAddition) error
 }
 
+// LayerCloner is the interface for drivers which can create a layer containing a copy of
+// the changes in another layer, without producing and applying an archive of them.
+// This API is experimental and can be changed without bumping the major version number.
+type LayerCloner interface {
+	// CloneLayer creates a new read-only layer with the specified id and parent, whose
+	// contents are a copy of the changes in layer source, which must be a child of parent.
+	// It returns an error wrapping ErrNotSupported if source can't be cloned, before creating anything.
+	CloneLayer(id, parent, source string, opts *CreateOpts) error
+}
+
+// LayerQuota contains the limits on the disk space used by a writable layer.
+// A value of zero means no limit.
+type LayerQuota struct {
+	Size   uint64
+	Inodes uint64
+}
+
+// QuotaDriver is the interface for drivers which can limit the disk space used
+// by writable layers, and report how much of it they use without walking them.
+// This API is experimental and can be changed without bumping the major version number.
+type QuotaDriver interface {
+	// SetLayerQuota sets, changes or removes the limits for the writable layer with the specified id.
+	// It returns an error wrapping ErrNotSupported if the limits can't be enforced for the layer, and
+	// an error wrapping ErrLayerInUse if the change can only be made while the layer is not mounted.
+	SetLayerQuota(id string, quota LayerQuota) error
+	// LayerQuota returns the limits for the writable layer with the specified id, and its current disk usage.
+	LayerQuota(id string) (LayerQuota, *directory.DiskUsage, error)
+}
+
+// ObjectStoreDriver is the interface for drivers which can keep the regular files of
+// read-only layers in a content-addressed object store shared by all the layers.
+// The objects of a layer are released when it is removed.
+// This API is experimental and can be changed without bumping the major version number.
+type ObjectStoreDriver interface {
+	// AddLayerToObjectStore makes the regular files of the read-only layer with the
+	// specified id reference objects in the object store.  It does nothing if the
+	// driver is not configured to use an object store.
+	AddLayerToObjectStore(id string) error
+	// GarbageCollectObjects deletes the objects which are not referenced by any of
+	// the specified layers.  It does nothing if the driver is not configured to use
+	// an object store.
+	GarbageCollectObjects(layers []string) error
+}
+
 // Capabilities defines a list of capabilities a driver may implement.
 // These capabilities are not required; however, they do determine how a
 // graphdriver can be used.
@@ -397,6 +443,51 @@ type AdditionalLayerStoreDriver interface {
 	LookupAdditionalLayerByID(id string) (AdditionalLayer, error)
 }
 
+// AdditionalLayerMaterializer creates the contents of layers in additional layer stores which can’t
+// intercept accesses to the layer contents (e.g. because they are not served by a FUSE filesystem).
+// Graph drivers call it before the contents of such a layer are first used.
+// This API is experimental and can be changed without bumping the major version number.
+type AdditionalLayerMaterializer interface {
+	// Pending returns true if the layer at path is managed by this materializer, and its contents have not been created yet.
+	Pending(path string) bool
+	// Materialize creates the contents of the layer at path, if they have not been created yet.
+	Materialize(path string) error
+}
+
+var additionalLayerMaterializers []AdditionalLayerMaterializer
+
+// RegisterAdditionalLayerMaterializer registers m for use by graph drivers.
+// It is suitable for package’s init() sections.
+// This API is experimental and can be changed without bumping the major version number.
+func RegisterAdditionalLayerMaterializer(m AdditionalLayerMaterializer) {
+	additionalLayerMaterializers = append(additionalLayerMaterializers, m)
+}
+
+// AdditionalLayerPending returns true if the contents of the layer at path, in an additional layer store,
+// will be created by a registered AdditionalLayerMaterializer on first use.
+func AdditionalLayerPending(path string) bool {
+	for _, m := range additionalLayerMaterializers {
+		if m.Pending(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaterializeAdditionalLayer creates the contents of the layer at path, in an additional layer store,
+// if they are pending creation by a registered AdditionalLayerMaterializer.
+func MaterializeAdditionalLayer(path string) error {
+	for _, m := range additionalLayerMaterializers {
+		if m.Pending(path) {
+			if err := m.Materialize(path); err != nil {
+				return fmt.Errorf("materializing additional layer %q: %w", path, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
 // DiffGetterDriver is the interface for layered file system drivers that
 // provide a specialized function for getting file contents for tar-split.
 type DiffGetterDriver interface {
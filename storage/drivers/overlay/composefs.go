@@ -3,61 +3,60 @@
 package overlay
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 	"go.podman.io/storage/pkg/chunked/dump"
 	"go.podman.io/storage/pkg/fsverity"
+	"go.podman.io/storage/pkg/ioutils"
 	"go.podman.io/storage/pkg/loopback"
 	"golang.org/x/sys/unix"
 )
 
-var (
-	composeFsHelperOnce sync.Once
-	composeFsHelperPath string
-	composeFsHelperErr  error
-
-	// skipMountViaFile is used to avoid trying to mount EROFS directly via the file if we already know the current kernel
-	// does not support it.  Mounting directly via a file is supported from Linux 6.12.
-	skipMountViaFile atomic.Bool
-)
-
-func getComposeFsHelper() (string, error) {
-	composeFsHelperOnce.Do(func() {
-		composeFsHelperPath, composeFsHelperErr = exec.LookPath("mkcomposefs")
-	})
-	return composeFsHelperPath, composeFsHelperErr
-}
+// skipMountViaFile is used to avoid trying to mount EROFS directly via the file if we already know the current kernel
+// does not support it.  Mounting directly via a file is supported from Linux 6.12.
+var skipMountViaFile atomic.Bool
 
 func getComposefsBlob(dataDir string) string {
 	return filepath.Join(dataDir, "composefs.blob")
 }
 
-func generateComposeFsBlob(verityDigests map[string]string, toc any, composefsDir string) error {
-	if err := os.MkdirAll(composefsDir, 0o700); err != nil {
-		return err
-	}
+// getFlattenedLayerFile returns the path of the file holding the content of a layer and all of its parents, which
+// is present only if the composefs blob of the layer is flattened.
+func getFlattenedLayerFile(dataDir string) string {
+	return filepath.Join(dataDir, "flattened.json")
+}
 
-	dumpReader, err := dump.GenerateDump(toc, verityDigests)
+// readFlattenedLayer returns the content of a layer with a flattened composefs blob, and all of its parents.
+// If the blob of the layer is not flattened, it returns an error wrapping fs.ErrNotExist.
+func readFlattenedLayer(dataDir string) (*dump.FlattenedLayer, error) {
+	data, err := os.ReadFile(getFlattenedLayerFile(dataDir))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var flattened dump.FlattenedLayer
+	if err := json.Unmarshal(data, &flattened); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", getFlattenedLayerFile(dataDir), err)
 	}
+	return &flattened, nil
+}
 
-	destFile := getComposefsBlob(composefsDir)
-	writerJSON, err := getComposeFsHelper()
-	if err != nil {
-		return fmt.Errorf("failed to find mkcomposefs: %w", err)
+// writeComposeFsBlob creates the composefs blob in composefsDir with the content written by writeImage, and
+// tries to enable fs-verity for it.
+func writeComposeFsBlob(composefsDir string, writeImage func(io.Writer) error) error {
+	if err := os.MkdirAll(composefsDir, 0o700); err != nil {
+		return err
 	}
 
+	destFile := getComposefsBlob(composefsDir)
 	outFile, err := os.OpenFile(destFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
 		return err
@@ -74,20 +73,11 @@ func generateComposeFsBlob(verityDigests map[string]string, toc any, composefsDi
 		// a scope to close outFile before setting fsverity on the read-only fd.
 		defer outFile.Close()
 
-		errBuf := &bytes.Buffer{}
-		cmd := exec.Command(writerJSON, "--from-file", "-", "-")
-		cmd.Stderr = errBuf
-		cmd.Stdin = dumpReader
-		cmd.Stdout = outFile
-		if err := cmd.Run(); err != nil {
-			rErr := fmt.Errorf("failed to convert json to erofs: %w", err)
-			exitErr := &exec.ExitError{}
-			if errors.As(err, &exitErr) {
-				return fmt.Errorf("%w: %s", rErr, strings.TrimSpace(errBuf.String()))
-			}
-			return rErr
+		w := bufio.NewWriter(outFile)
+		if err := writeImage(w); err != nil {
+			return fmt.Errorf("failed to write the composefs image %s: %w", destFile, err)
 		}
-		return nil
+		return w.Flush()
 	}()
 	if err != nil {
 		return err
@@ -100,6 +90,31 @@ func generateComposeFsBlob(verityDigests map[string]string, toc any, composefsDi
 	return nil
 }
 
+// generateComposeFsBlob writes the composefs blob for a layer with the specified TOC to composefsDir.
+func generateComposeFsBlob(verityDigests map[string]string, toc any, composefsDir string) error {
+	return writeComposeFsBlob(composefsDir, func(w io.Writer) error {
+		return dump.GenerateComposefsImage(w, toc, verityDigests)
+	})
+}
+
+// generateFlattenedComposeFsBlob writes a composefs blob to composefsDir with the files of a layer with the specified
+// TOC and of all of its parents, whose content is in parent (nil for a base layer).  Mounting the blob on its own
+// with the diff directories of the layer and of its parents as data-only layers gives the content of the whole image.
+func generateFlattenedComposeFsBlob(verityDigests map[string]string, toc any, parent *dump.FlattenedLayer, composefsDir string) error {
+	flattened, err := dump.Flatten(parent, toc, verityDigests)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(flattened)
+	if err != nil {
+		return err
+	}
+	if err := writeComposeFsBlob(composefsDir, flattened.WriteImage); err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(getFlattenedLayerFile(composefsDir), data, 0o600)
+}
+
 /*
 typedef enum {
 	LCFS_EROFS_FLAGS_HAS_ACL = (1 << 0),
@@ -25,14 +25,17 @@ import (
 	"github.com/opencontainers/selinux/go-selinux/label"
 	"github.com/sirupsen/logrus"
 	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/drivers/copy"
 	"go.podman.io/storage/drivers/overlayutils"
 	"go.podman.io/storage/drivers/quota"
 	"go.podman.io/storage/internal/dedup"
 	"go.podman.io/storage/internal/driver"
+	"go.podman.io/storage/internal/objectstore"
 	"go.podman.io/storage/internal/staging_lockfile"
 	"go.podman.io/storage/internal/tempdir"
 	"go.podman.io/storage/pkg/archive"
 	"go.podman.io/storage/pkg/chrootarchive"
+	"go.podman.io/storage/pkg/chunked/dump"
 	"go.podman.io/storage/pkg/directory"
 	"go.podman.io/storage/pkg/fileutils"
 	"go.podman.io/storage/pkg/fsutils"
@@ -126,6 +129,8 @@ type overlayOptions struct {
 	ignoreChownErrors bool
 	forceMask         *os.FileMode
 	useComposefs      bool
+	composefsFlatten  bool
+	objectStore       objectstore.Method
 	syncMode          graphdriver.SyncMode
 }
 
@@ -137,6 +142,8 @@ type Driver struct {
 	imageStore       string
 	ctr              *graphdriver.RefCounter
 	quotaCtl         *quota.Control
+	loopbackQuotaCtl *quota.LoopbackControl
+	objects          *objectstore.Store
 	options          overlayOptions
 	naiveDiff        graphdriver.DiffDriver
 	supportsDType    bool
@@ -399,9 +406,6 @@ func Init(home string, options graphdriver.Options) (graphdriver.Driver, error)
 		if unshare.IsRootless() {
 			return nil, fmt.Errorf("composefs is not supported in user namespaces")
 		}
-		if _, err := getComposeFsHelper(); err != nil {
-			return nil, fmt.Errorf("composefs helper program not found: %w", err)
-		}
 	}
 
 	var usingMetacopy bool
@@ -475,16 +479,27 @@ func Init(home string, options graphdriver.Options) (graphdriver.Driver, error)
 	}
 
 	d.naiveDiff = graphdriver.NewNaiveDiffDriver(d, graphdriver.NewNaiveLayerIDMapUpdater(d))
-	if backingFs == "xfs" {
-		// Try to enable project quota support over xfs.
+	if backingFs == "xfs" || backingFs == "extfs" {
+		// Try to enable project quota support over xfs or ext4.
 		if d.quotaCtl, err = quota.NewControl(home); err == nil {
 			projectQuotaSupported = true
 		} else if opts.quota.Size > 0 || opts.quota.Inodes > 0 {
 			return nil, fmt.Errorf("storage options overlay.size and overlay.inodes not supported. Filesystem does not support Project Quota: %w", err)
 		}
 	} else if opts.quota.Size > 0 || opts.quota.Inodes > 0 {
-		// if xfs is not the backing fs then error out if the storage-opt overlay.size is used.
-		return nil, fmt.Errorf("storage option overlay.size and overlay.inodes only supported for backingFS XFS. Found %v", backingFs)
+		// if neither xfs nor ext4 is the backing fs then error out if the storage-opt overlay.size is used.
+		return nil, fmt.Errorf("storage option overlay.size and overlay.inodes only supported for backingFS XFS and ext4. Found %v", backingFs)
+	}
+	if d.quotaCtl == nil && !unshare.IsRootless() {
+		// Limits set on individual layers later can use loopback images instead.
+		if d.loopbackQuotaCtl, err = quota.NewLoopbackControl(path.Join(home, quota.LoopbackImagesDir), home); err != nil {
+			logrus.Debugf("Loopback quotas are not available: %v", err)
+		}
+	}
+	if opts.objectStore != 0 {
+		if d.objects, err = objectstore.New(path.Join(home, objectstore.DirName), opts.objectStore); err != nil {
+			return nil, fmt.Errorf("initializing the overlay object store: %w", err)
+		}
 	}
 
 	logrus.Debugf("backingFs=%s, projectQuotaSupported=%v, useNativeDiff=%v, usingMetacopy=%v", backingFs, projectQuotaSupported, !d.useNaiveDiff(), d.usingMetacopy)
@@ -587,6 +602,12 @@ func parseOptions(options []string) (*overlayOptions, error) {
 			if err != nil {
 				return nil, err
 			}
+		case "composefs_flatten":
+			logrus.Debugf("overlay: composefs_flatten=%s", val)
+			o.composefsFlatten, err = strconv.ParseBool(val)
+			if err != nil {
+				return nil, err
+			}
 		case "mount_program":
 			logrus.Debugf("overlay: mount_program=%s", val)
 			if val != "" {
@@ -640,6 +661,13 @@ func parseOptions(options []string) (*overlayOptions, error) {
 				return nil, fmt.Errorf("invalid mode for overlay driver: %q", val)
 			}
 			o.syncMode = mode
+		case "object_store":
+			logrus.Debugf("overlay: object_store=%s", val)
+			if val != "" {
+				if o.objectStore, err = objectstore.ParseMethod(val); err != nil {
+					return nil, err
+				}
+			}
 		default:
 			return nil, fmt.Errorf("unknown option %q (%q)", key, option)
 		}
@@ -996,6 +1024,74 @@ func (d *Driver) CreateFromTemplate(id, template string, templateIDMappings *idt
 	return d.Create(id, template, opts)
 }
 
+// CloneLayer creates a read-only layer whose contents are a copy of the diff directory of source,
+// including the overlay extended attributes of its files, using reflinks if the backing file system
+// supports them.
+func (d *Driver) CloneLayer(id, parent, source string, opts *graphdriver.CreateOpts) error {
+	if d.usingComposefs {
+		return fmt.Errorf("cloning layers when using composefs: %w", graphdriver.ErrNotSupported)
+	}
+	if unshare.IsRootless() {
+		// Whiteouts are device nodes, which can't be copied in a user namespace.
+		return fmt.Errorf("cloning layers in a user namespace: %w", graphdriver.ErrNotSupported)
+	}
+	if !d.isParent(source, parent) {
+		return fmt.Errorf("layer %q is not a child of %q: %w", source, parent, graphdriver.ErrNotSupported)
+	}
+	sourceDiff, err := d.getDiffPath(source)
+	if err != nil {
+		return err
+	}
+	if err := d.Create(id, parent, opts); err != nil {
+		return err
+	}
+	diff, err := d.getDiffPath(id)
+	if err != nil {
+		return err
+	}
+	if err := copy.DirCopy(sourceDiff, diff, copy.Content, true); err != nil {
+		return err
+	}
+	// DirCopy only copies trusted.overlay.opaque, but files copied up with metacopy, and directories
+	// renamed with redirect_dir, depend on other overlay attributes.
+	return copyOverlayXattrs(sourceDiff, diff)
+}
+
+// copyOverlayXattrs copies all trusted.overlay.* extended attributes of the files in srcDir
+// to the files with the same relative paths in dstDir.
+func copyOverlayXattrs(srcDir, dstDir string) error {
+	return filepath.WalkDir(srcDir, func(srcPath string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		xattrs, err := system.Llistxattr(srcPath)
+		if err != nil {
+			if errors.Is(err, system.ENOTSUP) {
+				return nil
+			}
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, relPath)
+		for _, key := range xattrs {
+			if !strings.HasPrefix(key, "trusted.overlay.") {
+				continue
+			}
+			value, err := system.Lgetxattr(srcPath, key)
+			if err != nil {
+				return err
+			}
+			if err := system.Lsetxattr(dstPath, key, value, 0); err != nil {
+				return fmt.Errorf("copying xattr %q of %q: %w", key, srcPath, err)
+			}
+		}
+		return nil
+	})
+}
+
 // CreateReadWrite creates a layer that is writable for use as a container
 // file system.
 func (d *Driver) CreateReadWrite(id, parent string, opts *graphdriver.CreateOpts) error {
@@ -1035,6 +1131,9 @@ func (d *Driver) getLayerPermissions(parent string, uidMaps, gidMaps []idtools.I
 	st := idtools.Stat{IDs: idPair, Mode: defaultPerms}
 
 	if parent != "" {
+		if err := d.materializeAdditionalLayers(parent); err != nil {
+			return idtools.IDPair{}, idtools.Stat{}, idtools.Stat{}, err
+		}
 		parentBase := d.dir(parent)
 		parentDiff := filepath.Join(parentBase, "diff")
 		if xSt, err := idtools.GetContainersOverrideXattr(parentDiff); err == nil {
@@ -1223,6 +1322,70 @@ func (d *Driver) parseStorageOpt(opts *graphdriver.CreateOpts, readOnly bool) (q
 	return res, nil
 }
 
+// SetLayerQuota sets, changes or removes the limits for the writable layer with the specified id.
+// It uses project quotas if the backing file system supports them, and loopback images otherwise.
+func (d *Driver) SetLayerQuota(id string, limits graphdriver.LayerQuota) error {
+	dir, _, inAdditionalStore := d.dir2(id, false)
+	if err := fileutils.Exists(dir); err != nil {
+		return err
+	}
+	if inAdditionalStore {
+		return fmt.Errorf("setting a quota for layer %q in an additional store: %w", id, graphdriver.ErrNotSupported)
+	}
+	q := quota.Quota{Size: limits.Size, Inodes: limits.Inodes}
+	switch {
+	case d.quotaCtl != nil:
+		return d.quotaCtl.SetQuota(dir, q)
+	case d.loopbackQuotaCtl != nil:
+		if q.Inodes != 0 {
+			return fmt.Errorf("setting a quota for layer %q: limiting the number of inodes without project quotas: %w", id, graphdriver.ErrNotSupported)
+		}
+		if err := d.loopbackQuotaCtl.SetQuota(dir, q); err != nil {
+			if errors.Is(err, quota.ErrInUse) {
+				return fmt.Errorf("setting a quota for layer %q: %w: %w", id, graphdriver.ErrLayerInUse, err)
+			}
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("setting a quota for layer %q: %w", id, graphdriver.ErrNotSupported)
+}
+
+// LayerQuota returns the limits for the writable layer with the specified id, and its current disk usage.
+func (d *Driver) LayerQuota(id string) (graphdriver.LayerQuota, *directory.DiskUsage, error) {
+	dir := d.dir(id)
+	if err := fileutils.Exists(dir); err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	var q quota.Quota
+	usage := &directory.DiskUsage{}
+	switch {
+	case d.quotaCtl != nil:
+		if err := d.quotaCtl.GetQuota(dir, &q); err != nil {
+			return graphdriver.LayerQuota{}, nil, err
+		}
+		if err := d.quotaCtl.GetDiskUsage(dir, usage); err != nil {
+			return graphdriver.LayerQuota{}, nil, err
+		}
+	case d.loopbackQuotaCtl != nil:
+		if err := d.loopbackQuotaCtl.GetQuota(dir, &q); err != nil {
+			return graphdriver.LayerQuota{}, nil, err
+		}
+		if q.Size == 0 {
+			// Without a limit, the layer's usage isn't tracked by a file system of its own.
+			var err error
+			if usage, err = d.ReadWriteDiskUsage(id); err != nil {
+				return graphdriver.LayerQuota{}, nil, err
+			}
+		} else if err := d.loopbackQuotaCtl.GetDiskUsage(dir, usage); err != nil {
+			return graphdriver.LayerQuota{}, nil, err
+		}
+	default:
+		return graphdriver.LayerQuota{}, nil, fmt.Errorf("reading the quota of layer %q: %w", id, graphdriver.ErrNotSupported)
+	}
+	return graphdriver.LayerQuota{Size: q.Size, Inodes: q.Inodes}, usage, nil
+}
+
 func (d *Driver) dir(id string) string {
 	p, _, _ := d.dir2(id, false)
 	return p
@@ -1343,6 +1506,13 @@ func (d *Driver) getLowerDirs(id string) ([]string, error) {
 // getLowerDiffPaths returns a list of lower diff paths for a layer id;
 // the paths have redirectDiffIfAdditionalLayer applied.
 func (d *Driver) getLowerDiffPaths(id string) ([]string, error) {
+	lowerLayerIDs, err := d.getLowerLayerIDs(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.materializeAdditionalLayers(lowerLayerIDs...); err != nil {
+		return nil, err
+	}
 	layers, err := d.getLowerDirs(id)
 	if err != nil {
 		return nil, err
@@ -1388,7 +1558,18 @@ func (d *Driver) optsAppendMappings(opts string, uidMaps, gidMaps []idtools.IDMa
 
 // Remove cleans the directories that are created for this id.
 func (d *Driver) Remove(id string) error {
-	return d.removeCommon(id, system.EnsureRemoveAll)
+	if err := d.removeCommon(id, system.EnsureRemoveAll); err != nil {
+		return err
+	}
+	return d.releaseObjects(id)
+}
+
+// releaseObjects releases the objects referenced by a layer which was deleted.
+func (d *Driver) releaseObjects(id string) error {
+	if d.objects == nil {
+		return nil
+	}
+	return d.objects.RemoveLayer(id)
 }
 
 func (d *Driver) removeCommon(id string, cleanup func(string) error) error {
@@ -1403,6 +1584,11 @@ func (d *Driver) removeCommon(id string, cleanup func(string) error) error {
 
 	d.releaseAdditionalLayerByID(id)
 
+	if d.loopbackQuotaCtl != nil {
+		if err := d.loopbackQuotaCtl.ClearQuota(dir); err != nil {
+			return err
+		}
+	}
 	if err := cleanup(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
@@ -1455,7 +1641,13 @@ func (d *Driver) DeferredRemove(id string) (tempdir.CleanupTempDirFunc, error) {
 	if err := d.removeCommon(id, t.StageDeletion); err != nil {
 		return t.Cleanup, fmt.Errorf("failed to add to stage directory: %w", err)
 	}
-	return t.Cleanup, nil
+	if d.objects == nil {
+		return t.Cleanup, nil
+	}
+	// The objects can be released only once the files of the layer are deleted.
+	return func() error {
+		return errors.Join(t.Cleanup(), d.releaseObjects(id))
+	}, nil
 }
 
 // Get creates and mounts the required file system for the given id and returns the mount path.
@@ -1468,6 +1660,9 @@ func (d *Driver) get(id string, disableShifting bool, options graphdriver.MountO
 	if err := fileutils.Exists(dir); err != nil {
 		return "", err
 	}
+	if err := d.materializeAdditionalLayers(id); err != nil {
+		return "", err
+	}
 	if _, err := redirectDiffIfAdditionalLayer(path.Join(dir, "diff"), true); err != nil {
 		return "", err
 	}
@@ -1559,6 +1754,9 @@ func (d *Driver) get(id string, disableShifting bool, options graphdriver.MountO
 	if len(lowerLayerIDs) > maxDepth {
 		return "", errors.New("max depth exceeded")
 	}
+	if err := d.materializeAdditionalLayers(lowerLayerIDs...); err != nil {
+		return "", err
+	}
 
 	// absLowers is the list of lowers as absolute paths.
 	absLowers := []string{}
@@ -1636,12 +1834,20 @@ func (d *Driver) get(id string, disableShifting bool, options graphdriver.MountO
 		return dest, nil
 	}
 
+	// isFlattenedComposefs reports whether the composefs blob of a layer already has the files of all its parents,
+	// so that only their data is needed.
+	isFlattenedComposefs := func(layerID string) bool {
+		return fileutils.Exists(getFlattenedLayerFile(d.getComposefsData(layerID))) == nil
+	}
+	flattened := false
+
 	diffDir := path.Join(dir, "diff")
 
 	if dest, err := maybeAddComposefsMount(id, 0, readWrite); err != nil {
 		return "", err
 	} else if dest != "" {
 		diffDir = dest
+		flattened = isFlattenedComposefs(id)
 	}
 
 	// For each lower, resolve its path, and append it and any additional diffN
@@ -1657,11 +1863,22 @@ func (d *Driver) get(id string, disableShifting bool, options graphdriver.MountO
 			permsKnown = true
 		}
 
+		if flattened {
+			composeFsPath, err := d.getDiffPath(lowerID)
+			if err != nil {
+				return "", err
+			}
+			composeFsLayers = append(composeFsLayers, composeFsPath)
+			skipIDMappingLayers[composeFsPath] = composeFsPath
+			continue
+		}
+
 		composefsMount, err := maybeAddComposefsMount(lowerID, i+1, readWrite)
 		if err != nil {
 			return "", err
 		}
 		if composefsMount != "" {
+			flattened = isFlattenedComposefs(lowerID)
 			if needsIDMapping {
 				if err := idmap.CreateIDMappedMount(composefsMount, composefsMount, idmappedMountProcessPid); err != nil {
 					return "", fmt.Errorf("create mapped mount for %q: %w", composefsMount, err)
@@ -2010,7 +2227,7 @@ func (d *Driver) ListLayers() ([]string, error) {
 	for _, entry := range entries {
 		id := entry.Name()
 		switch id {
-		case linkDir, stagingDir, tempDirName, quota.BackingFsBlockDeviceLink, mountProgramFlagFile:
+		case linkDir, stagingDir, tempDirName, quota.BackingFsBlockDeviceLink, quota.LoopbackImagesDir, objectstore.DirName, mountProgramFlagFile:
 			// expected, but not a layer. skip it
 			continue
 		default:
@@ -2302,7 +2519,16 @@ func (d *Driver) ApplyDiffFromStagingDirectory(id, parent string, diffOutput *gr
 	if d.usingComposefs {
 		toc := diffOutput.Artifacts[tocArtifact]
 		verityDigests := diffOutput.Artifacts[fsVerityDigestsArtifact].(map[string]string)
-		if err := generateComposeFsBlob(verityDigests, toc, d.getComposefsData(id)); err != nil {
+		flatten, flattenedParent, err := d.composefsFlattenParent(parent)
+		if err != nil {
+			return err
+		}
+		if flatten {
+			err = generateFlattenedComposeFsBlob(verityDigests, toc, flattenedParent, d.getComposefsData(id))
+		} else {
+			err = generateComposeFsBlob(verityDigests, toc, d.getComposefsData(id))
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -2313,6 +2539,52 @@ func (d *Driver) ApplyDiffFromStagingDirectory(id, parent string, diffOutput *gr
 	return os.Rename(stagingDirectory, diffPath)
 }
 
+// AddLayerToObjectStore makes the regular files of the read-only layer with the specified id
+// reference objects in the object store, if the driver is configured to use one.
+func (d *Driver) AddLayerToObjectStore(id string) error {
+	if d.objects == nil {
+		return nil
+	}
+	if _, _, inAdditionalStore := d.dir2(id, false); inAdditionalStore {
+		return nil
+	}
+	diff, err := d.getDiffPath(id)
+	if err != nil {
+		return err
+	}
+	return d.objects.AddLayer(id, diff)
+}
+
+// GarbageCollectObjects deletes the objects which are not referenced by any of the specified
+// layers, if the driver is configured to use an object store.
+func (d *Driver) GarbageCollectObjects(layers []string) error {
+	if d.objects == nil {
+		return nil
+	}
+	return d.objects.GarbageCollect(layers)
+}
+
+// composefsFlattenParent reports whether the composefs blob of a new layer with the specified parent must be
+// flattened, and if so, returns the content of the parent (nil for a base layer).  A layer is flattened only if
+// its parent is flattened too, so that the parent has the files of all of the layers below it.
+func (d *Driver) composefsFlattenParent(parent string) (bool, *dump.FlattenedLayer, error) {
+	if !d.options.composefsFlatten {
+		return false, nil, nil
+	}
+	if parent == "" {
+		return true, nil, nil
+	}
+	flattenedParent, err := readFlattenedLayer(d.getComposefsData(parent))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			logrus.Debugf("overlay: parent layer %s has no flattened composefs blob, not flattening the new layer", parent)
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	return true, flattenedParent, nil
+}
+
 // DifferTarget gets the location where files are stored for the layer.
 func (d *Driver) DifferTarget(id string) (string, error) {
 	return d.getDiffPath(id)
@@ -2425,6 +2697,9 @@ func (d *Driver) getComposefsData(id string) string {
 }
 
 func (d *Driver) getDiffPath(id string) (string, error) {
+	if err := d.materializeAdditionalLayers(id); err != nil {
+		return "", err
+	}
 	dir := d.dir(id)
 	return redirectDiffIfAdditionalLayer(path.Join(dir, "diff"), false)
 }
@@ -2632,12 +2907,13 @@ func nameWithSuffix(name string, number int) string {
 }
 
 func validateOneAdditionalLayerPath(target string) error {
-	for _, p := range []string{
-		filepath.Join(target, "diff"),
-		filepath.Join(target, "info"),
-		filepath.Join(target, "blob"),
-	} {
-		if err := fileutils.Exists(p); err != nil {
+	items := []string{"diff", "info", "blob"}
+	if graphdriver.AdditionalLayerPending(target) {
+		// diff and blob are created on first use, see materializeAdditionalLayers.
+		items = []string{"info"}
+	}
+	for _, item := range items {
+		if err := fileutils.Exists(filepath.Join(target, item)); err != nil {
 			return err
 		}
 	}
@@ -2662,6 +2938,24 @@ func (d *Driver) getAdditionalLayerPath(tocDigest digest.Digest, ref string) (st
 	return "", fmt.Errorf("additional layer (%q, %q) not found: %w", tocDigest, ref, graphdriver.ErrLayerUnknown)
 }
 
+// materializeAdditionalLayers creates the contents of the layers with ids which come from an additional layer store,
+// if they are pending creation on first use by a graphdriver.AdditionalLayerMaterializer.
+func (d *Driver) materializeAdditionalLayers(ids ...string) error {
+	for _, id := range ids {
+		al, err := d.getAdditionalLayerPathByID(id)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err := graphdriver.MaterializeAdditionalLayer(al); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *Driver) releaseAdditionalLayerByID(id string) {
 	if al, err := d.getAdditionalLayerPathByID(id); err == nil {
 		notifyReleaseAdditionalLayer(al)
@@ -2686,6 +2980,9 @@ func (al *additionalLayer) Info() (io.ReadCloser, error) {
 
 // Blob returns a reader of the raw contents of this layer.
 func (al *additionalLayer) Blob() (io.ReadCloser, error) {
+	if err := graphdriver.MaterializeAdditionalLayer(al.path); err != nil {
+		return nil, err
+	}
 	return os.Open(filepath.Join(al.path, "blob"))
 }
 
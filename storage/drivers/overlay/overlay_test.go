@@ -12,9 +12,11 @@ import (
 	graphdriver "go.podman.io/storage/drivers"
 	"go.podman.io/storage/drivers/graphtest"
 	"go.podman.io/storage/drivers/quota"
+	"go.podman.io/storage/internal/objectstore"
 	"go.podman.io/storage/pkg/archive"
 	"go.podman.io/storage/pkg/idtools"
 	"go.podman.io/storage/pkg/reexec"
+	"go.podman.io/storage/pkg/system"
 )
 
 const driverName = "overlay"
@@ -304,6 +306,21 @@ func Test_parseOptions(t *testing.T) {
 			options: []string{"use_composefs=notabool"},
 			wantErr: "invalid syntax",
 		},
+		{
+			name:    "composefs_flatten",
+			options: []string{"use_composefs=true", "composefs_flatten=true"},
+			want:    &overlayOptions{useComposefs: true, composefsFlatten: true},
+		},
+		{
+			name:    "object_store",
+			options: []string{"object_store=hardlink"},
+			want:    &overlayOptions{objectStore: objectstore.MethodHardlink},
+		},
+		{
+			name:    "object_store - invalid",
+			options: []string{"object_store=copy"},
+			wantErr: "invalid object store method",
+		},
 		{
 			name:    "skip_mount_home",
 			options: []string{"skip_mount_home=true"},
@@ -367,3 +384,27 @@ func Test_parseOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyOverlayXattrs(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(src, "renamed"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "renamed", "metacopy"), nil, 0o644))
+	if err := system.Lsetxattr(filepath.Join(src, "renamed"), "trusted.overlay.redirect", []byte("/original"), 0); err != nil {
+		t.Skipf("setting trusted xattrs is not supported: %v", err)
+	}
+	require.NoError(t, system.Lsetxattr(filepath.Join(src, "renamed", "metacopy"), "trusted.overlay.metacopy", nil, 0))
+	require.NoError(t, system.Lsetxattr(filepath.Join(src, "renamed", "metacopy"), "user.other", []byte("value"), 0))
+	require.NoError(t, os.Mkdir(filepath.Join(dst, "renamed"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "renamed", "metacopy"), nil, 0o644))
+
+	require.NoError(t, copyOverlayXattrs(src, dst))
+
+	redirect, err := system.Lgetxattr(filepath.Join(dst, "renamed"), "trusted.overlay.redirect")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("/original"), redirect)
+	xattrs, err := system.Llistxattr(filepath.Join(dst, "renamed", "metacopy"))
+	require.NoError(t, err)
+	assert.Contains(t, xattrs, "trusted.overlay.metacopy")
+	assert.NotContains(t, xattrs, "user.other")
+}
@@ -0,0 +1,422 @@
+//go:build linux
+
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moby/sys/mountinfo"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/drivers/copy"
+	"go.podman.io/storage/pkg/directory"
+	"go.podman.io/storage/pkg/loopback"
+	"go.podman.io/storage/pkg/mount"
+	"go.podman.io/storage/pkg/system"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	loopbackImageSuffix    = ".img"
+	loopbackImageBlockSize = 4096
+)
+
+// LoopbackControl - Context to be used by storage drivers which want to limit
+// the size of container dirs on file systems without project quota support.
+//
+// The contents of each limited directory are moved to an ext4 file system,
+// stored in a sparse image file and mounted on the directory.  The image is
+// grown or shrunk when the limit changes.
+type LoopbackControl struct {
+	imagesDir string
+	basePath  string
+	lock      sync.Mutex
+}
+
+// NewLoopbackControl - initialize loopback quota support for the
+// directories in basePath, storing images in imagesDir.
+//
+// Mounts don't survive a reboot, so images which were set up earlier are
+// mounted again on their directories.
+func NewLoopbackControl(imagesDir, basePath string) (*LoopbackControl, error) {
+	if os.Geteuid() != 0 {
+		return nil, errors.New("loopback quotas can only be used by root")
+	}
+	for _, tool := range []string{"mkfs.ext4", "resize2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("loopback quotas need %s: %w", tool, err)
+		}
+	}
+	q := LoopbackControl{
+		imagesDir: imagesDir,
+		basePath:  basePath,
+	}
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), loopbackImageSuffix)
+		if !ok {
+			continue
+		}
+		targetPath := filepath.Join(basePath, name)
+		image := filepath.Join(imagesDir, entry.Name())
+		if _, err := os.Stat(targetPath); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			// The directory was removed without clearing its quota.
+			logrus.Debugf("Removing loopback quota image %s without a directory", image)
+			if err := os.Remove(image); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := q.mountImage(image, targetPath); err != nil {
+			return nil, err
+		}
+	}
+	return &q, nil
+}
+
+// imagePath returns the path of the image used for targetPath.
+func (q *LoopbackControl) imagePath(targetPath string) (string, error) {
+	if filepath.Dir(targetPath) != filepath.Clean(q.basePath) {
+		return "", fmt.Errorf("%s is not a directory in %s", targetPath, q.basePath)
+	}
+	return filepath.Join(q.imagesDir, filepath.Base(targetPath)+loopbackImageSuffix), nil
+}
+
+// SetQuota - set, change or, if quota.Size is zero, remove the size limit of
+// targetPath, which must be a directory in the base path.
+// Setting the first limit or removing it moves the contents of the directory,
+// and decreasing it requires unmounting the image, so these return an error
+// wrapping ErrInUse while the directory is in use.  Limits can be increased
+// at any time.
+func (q *LoopbackControl) SetQuota(targetPath string, quota Quota) error {
+	if quota.Inodes != 0 {
+		return errors.New("loopback quotas can not limit the number of inodes")
+	}
+	image, err := q.imagePath(targetPath)
+	if err != nil {
+		return err
+	}
+	size := (quota.Size + loopbackImageBlockSize - 1) / loopbackImageBlockSize * loopbackImageBlockSize
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	logrus.Debugf("SetQuota path=%s, size=%d, image=%s", targetPath, size, image)
+	st, err := os.Stat(image)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if size == 0 {
+			return nil
+		}
+		return q.createImage(image, targetPath, size)
+	case err != nil:
+		return err
+	case size == 0:
+		return q.removeImage(image, targetPath)
+	case size > uint64(st.Size()):
+		return q.growImage(image, targetPath, size)
+	case size < uint64(st.Size()):
+		return q.shrinkImage(image, targetPath, size)
+	}
+	return nil
+}
+
+// GetQuota - get the size limit of targetPath, which is zero if none was set
+func (q *LoopbackControl) GetQuota(targetPath string, quota *Quota) error {
+	image, err := q.imagePath(targetPath)
+	if err != nil {
+		return err
+	}
+	*quota = Quota{}
+	st, err := os.Stat(image)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	quota.Size = uint64(st.Size())
+	return nil
+}
+
+// GetDiskUsage - get the current disk usage of targetPath, which must have a
+// size limit.  The returned error wraps fs.ErrNotExist if it has none.
+func (q *LoopbackControl) GetDiskUsage(targetPath string, usage *directory.DiskUsage) error {
+	image, err := q.imagePath(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(image); err != nil {
+		return err
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(targetPath, &st); err != nil {
+		return err
+	}
+	usage.Size = int64(st.Blocks-st.Bfree) * int64(st.Bsize) //nolint:unconvert
+	usage.InodeCount = int64(st.Files - st.Ffree)
+	return nil
+}
+
+// ClearQuota - unmount and remove the image used for targetPath, if any,
+// discarding its contents.  It must be called before removing targetPath.
+func (q *LoopbackControl) ClearQuota(targetPath string) error {
+	image, err := q.imagePath(targetPath)
+	if err != nil {
+		// No limit can have been set.
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, err := os.Stat(image); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := mount.Unmount(targetPath); err != nil {
+		return err
+	}
+	return os.Remove(image)
+}
+
+// mountImage attaches image to a loop device, and mounts it on targetPath
+// unless something is already mounted there.
+func (q *LoopbackControl) mountImage(image, targetPath string) error {
+	mounted, err := mount.Mounted(targetPath)
+	if err != nil || mounted {
+		return err
+	}
+	loop, err := loopback.AttachLoopDevice(image)
+	if err != nil {
+		return err
+	}
+	// The device is detached when it's unmounted, because it's set to autoclear.
+	defer loop.Close()
+	return unix.Mount(loop.Name(), targetPath, "ext4", 0, "")
+}
+
+// createImage creates an image with the specified size, moves the contents of
+// targetPath to it, and mounts it there.
+func (q *LoopbackControl) createImage(image, targetPath string, size uint64) (retErr error) {
+	mounts, err := mountinfo.GetMounts(mountinfo.PrefixFilter(targetPath))
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("%s: %w, its contents can't be moved to a loopback image", targetPath, ErrInUse)
+	}
+	if err := os.MkdirAll(q.imagesDir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(image, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			if err := os.Remove(image); err != nil {
+				logrus.Errorf("Removing loopback quota image %s: %v", image, err)
+			}
+		}
+	}()
+	err = f.Truncate(int64(size))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	if err := run("mkfs.ext4", "-q", "-F", "-m", "0", image); err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(q.imagesDir, "mount")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := q.mountImage(image, tmp); err != nil {
+		return err
+	}
+	defer func() {
+		if err := unix.Unmount(tmp, unix.MNT_DETACH); err != nil {
+			logrus.Errorf("Unmounting %s: %v", tmp, err)
+		}
+	}()
+	if err := os.Remove(filepath.Join(tmp, "lost+found")); err != nil {
+		return err
+	}
+	if err := copyTree(targetPath, tmp); err != nil {
+		return fmt.Errorf("copying %s to a loopback image: %w", targetPath, err)
+	}
+	if err := removeContents(targetPath); err != nil {
+		return err
+	}
+	return unix.Mount(tmp, targetPath, "", unix.MS_BIND, "")
+}
+
+// removeImage moves the contents of the image mounted on targetPath back to
+// the directory, and removes the image.
+func (q *LoopbackControl) removeImage(image, targetPath string) error {
+	tmp, err := os.MkdirTemp(q.imagesDir, "mount")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := q.mountImage(image, targetPath); err != nil {
+		return err
+	}
+	if err := unix.Mount(targetPath, tmp, "", unix.MS_BIND, ""); err != nil {
+		return err
+	}
+	defer func() {
+		if err := unix.Unmount(tmp, unix.MNT_DETACH); err != nil {
+			logrus.Errorf("Unmounting %s: %v", tmp, err)
+		}
+	}()
+	if err := unix.Unmount(targetPath, 0); err != nil {
+		if errors.Is(err, unix.EBUSY) {
+			err = fmt.Errorf("%w: %w", ErrInUse, err)
+		}
+		return fmt.Errorf("%s: its contents can't be moved out of a loopback image: %w", targetPath, err)
+	}
+	if err := copyTree(tmp, targetPath); err != nil {
+		if err2 := unix.Mount(tmp, targetPath, "", unix.MS_BIND, ""); err2 != nil {
+			err = errors.Join(err, err2)
+		}
+		return fmt.Errorf("copying a loopback image to %s: %w", targetPath, err)
+	}
+	return os.Remove(image)
+}
+
+// growImage increases the size of image, and of the file system it contains.
+// That's done after unmounting it from targetPath if it's not in use, and
+// while it's mounted otherwise.
+func (q *LoopbackControl) growImage(image, targetPath string, size uint64) error {
+	if err := unix.Unmount(targetPath, 0); err == nil || errors.Is(err, unix.EINVAL) {
+		// The file system was cleanly unmounted, so it doesn't need to be checked
+		// first, which would recreate lost+found.
+		err := os.Truncate(image, int64(size))
+		if err == nil {
+			err = run("resize2fs", "-f", image)
+		}
+		if err2 := q.mountImage(image, targetPath); err2 != nil {
+			err = errors.Join(err, err2)
+		}
+		return err
+	} else if !errors.Is(err, unix.EBUSY) {
+		return fmt.Errorf("unmounting %s: %w", targetPath, err)
+	}
+
+	f, err := os.OpenFile(image, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(size)); err != nil {
+		return err
+	}
+	loop := loopback.FindLoopDeviceFor(f)
+	if loop == nil {
+		return fmt.Errorf("no loop device found for %s", image)
+	}
+	defer loop.Close()
+	if err := loopback.SetCapacity(loop); err != nil {
+		return err
+	}
+	return run("resize2fs", loop.Name())
+}
+
+// shrinkImage decreases the size of the file system in image, and of the
+// image.  That requires unmounting it from targetPath.
+func (q *LoopbackControl) shrinkImage(image, targetPath string, size uint64) error {
+	if err := unix.Unmount(targetPath, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+		if errors.Is(err, unix.EBUSY) {
+			err = fmt.Errorf("%w: %w", ErrInUse, err)
+		}
+		return fmt.Errorf("%s: its size limit can't be decreased: %w", targetPath, err)
+	}
+	// As in growImage, the file system doesn't need to be checked first.
+	err := run("resize2fs", "-f", image, strconv.FormatUint(size/1024, 10)+"K")
+	if err == nil {
+		err = os.Truncate(image, int64(size))
+	}
+	if err2 := q.mountImage(image, targetPath); err2 != nil {
+		err = errors.Join(err, err2)
+	}
+	return err
+}
+
+// copyTree copies the contents of srcDir to dstDir, including all extended
+// attributes, e.g. ACLs and trusted.* attributes, which copy.DirCopy doesn't copy.
+func copyTree(srcDir, dstDir string) error {
+	if err := copy.DirCopy(srcDir, dstDir, copy.Content, false); err != nil {
+		return err
+	}
+	// Setting the attributes after DirCopy has set the file modes keeps ACLs intact.
+	return filepath.WalkDir(srcDir, func(srcPath string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		xattrs, err := system.Llistxattr(srcPath)
+		if err != nil {
+			if errors.Is(err, system.ENOTSUP) {
+				return nil
+			}
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, relPath)
+		for _, key := range xattrs {
+			value, err := system.Lgetxattr(srcPath, key)
+			if err != nil {
+				return err
+			}
+			if err := system.Lsetxattr(dstPath, key, value, 0); err != nil {
+				return fmt.Errorf("copying xattr %q of %s: %w", key, srcPath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// removeContents removes everything in dir, but not dir itself.
+func removeContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs a command, including its output in the returned error if it fails.
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s %s: %s: %w", name, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
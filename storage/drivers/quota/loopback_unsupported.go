@@ -0,0 +1,37 @@
+//go:build !linux
+
+package quota
+
+import (
+	"errors"
+
+	"go.podman.io/storage/pkg/directory"
+)
+
+// LoopbackControl - Context to be used by storage drivers which want to limit
+// the size of container dirs on file systems without project quota support.
+type LoopbackControl struct{}
+
+func NewLoopbackControl(imagesDir, basePath string) (*LoopbackControl, error) {
+	return nil, errors.New("loopback quotas are not supported on this platform")
+}
+
+// SetQuota - set, change or, if quota.Size is zero, remove the size limit of targetPath
+func (q *LoopbackControl) SetQuota(targetPath string, quota Quota) error {
+	return errors.New("loopback quotas are not supported on this platform")
+}
+
+// GetQuota - get the size limit of targetPath, which is zero if none was set
+func (q *LoopbackControl) GetQuota(targetPath string, quota *Quota) error {
+	return errors.New("loopback quotas are not supported on this platform")
+}
+
+// GetDiskUsage - get the current disk usage of targetPath, which must have a size limit
+func (q *LoopbackControl) GetDiskUsage(targetPath string, usage *directory.DiskUsage) error {
+	return errors.New("loopback quotas are not supported on this platform")
+}
+
+// ClearQuota - unmount and remove the image used for targetPath, if any
+func (q *LoopbackControl) ClearQuota(targetPath string) error {
+	return nil
+}
@@ -1,5 +1,14 @@
 package quota
 
+import "errors"
+
+// ErrInUse is returned when the limits of a directory can't be changed while it is in use.
+var ErrInUse = errors.New("directory is in use")
+
 // BackingFsBlockDeviceLink is the name of a file that we place in
 // the home directory of a driver that uses this package.
 const BackingFsBlockDeviceLink = "backingFsBlockDev"
+
+// LoopbackImagesDir is the name of a directory that we place in the home
+// directory of a driver that uses a LoopbackControl.
+const LoopbackImagesDir = "quota-images"
@@ -53,6 +53,7 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"math"
 	"os"
 	"path"
@@ -190,8 +191,9 @@ func NewControl(basePath string) (*Control, error) {
 }
 
 // SetQuota - assign a unique project id to directory and set the quota limits
-// for that project id.
-// targetPath must exist, must be a directory, and must be empty.
+// for that project id.  Limits of zero remove the limits.
+// targetPath must exist and must be a directory.  If it is not empty, the
+// project id is also assigned to the directories and regular files in it.
 func (q *Control) SetQuota(targetPath string, quota Quota) error {
 	var projectID uint32
 	value, ok := q.quotas.Load(targetPath)
@@ -201,24 +203,20 @@ func (q *Control) SetQuota(targetPath string, quota Quota) error {
 	if !ok {
 		projectID = q.nextProjectID
 
-		// The directory we are setting an ID on must be empty, as
-		// the ID will not be propagated to pre-existing subdirectories.
-		dents, err := os.ReadDir(targetPath)
-		if err != nil {
-			return fmt.Errorf("reading directory %s: %w", targetPath, err)
-		}
-		if len(dents) > 0 {
-			return fmt.Errorf("can only set project ID on empty directories, %s is not empty", targetPath)
-		}
-
 		//
 		// assign project id to new container directory
 		//
-		err = setProjectID(targetPath, projectID)
+		err := setProjectID(targetPath, projectID)
 		if err != nil {
 			return err
 		}
 
+		// The ID is only inherited by files created later, so
+		// assign it to the pre-existing contents as well.
+		if err := setProjectIDOnContents(targetPath, projectID); err != nil {
+			return err
+		}
+
 		q.quotas.Store(targetPath, projectID)
 		q.nextProjectID++
 	}
@@ -243,16 +241,12 @@ func (q *Control) setProjectQuota(projectID uint32, quota Quota) error {
 	d.d_id = C.__u32(projectID)
 	d.d_flags = C.FS_PROJ_QUOTA
 
-	if quota.Size > 0 {
-		d.d_fieldmask = d.d_fieldmask | C.FS_DQ_BHARD | C.FS_DQ_BSOFT
-		d.d_blk_hardlimit = C.__u64(quota.Size / 512)
-		d.d_blk_softlimit = d.d_blk_hardlimit
-	}
-	if quota.Inodes > 0 {
-		d.d_fieldmask = d.d_fieldmask | C.FS_DQ_IHARD | C.FS_DQ_ISOFT
-		d.d_ino_hardlimit = C.__u64(quota.Inodes)
-		d.d_ino_softlimit = d.d_ino_hardlimit
-	}
+	// Limits of zero remove any limits set earlier.
+	d.d_fieldmask = C.FS_DQ_BHARD | C.FS_DQ_BSOFT | C.FS_DQ_IHARD | C.FS_DQ_ISOFT
+	d.d_blk_hardlimit = C.__u64(quota.Size / 512)
+	d.d_blk_softlimit = d.d_blk_hardlimit
+	d.d_ino_hardlimit = C.__u64(quota.Inodes)
+	d.d_ino_softlimit = d.d_ino_hardlimit
 
 	cs := C.CString(q.backingFsBlockDev)
 	defer C.free(unsafe.Pointer(cs))
@@ -384,6 +378,56 @@ func setProjectID(targetPath string, projectID uint32) error {
 	return nil
 }
 
+// setProjectIDOnContents sets projectID on the directories and regular files
+// in targetPath, skipping file systems mounted in it.  Other files can't be
+// opened to set it, but they use no data blocks.
+func setProjectIDOnContents(targetPath string, projectID uint32) error {
+	var rootSt unix.Stat_t
+	if err := unix.Lstat(targetPath, &rootSt); err != nil {
+		return err
+	}
+	return filepath.WalkDir(targetPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == targetPath || (!d.IsDir() && !d.Type().IsRegular()) {
+			return nil
+		}
+		var st unix.Stat_t
+		if err := unix.Lstat(filePath, &st); err != nil {
+			return err
+		}
+		if st.Dev != rootSt.Dev {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fd, err := unix.Open(filePath, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+
+		var fsx C.struct_fsxattr
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), C.FS_IOC_FSGETXATTR,
+			uintptr(unsafe.Pointer(&fsx)))
+		if errno != 0 {
+			return fmt.Errorf("failed to get projid for %s: %w", filePath, errno)
+		}
+		fsx.fsx_projid = C.__u32(projectID)
+		if d.IsDir() {
+			fsx.fsx_xflags |= C.FS_XFLAG_PROJINHERIT
+		}
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, uintptr(fd), C.FS_IOC_FSSETXATTR,
+			uintptr(unsafe.Pointer(&fsx)))
+		if errno != 0 {
+			return fmt.Errorf("failed to set projid for %s: %w", filePath, errno)
+		}
+		return nil
+	})
+}
+
 // stripProjectInherit strips the project inherit flag from a directory.
 // Used on the top-level directory to ensure project IDs are only inherited for
 // files in directories we set quotas on - not the directories we want to set
@@ -4,6 +4,8 @@ package quota
 
 import (
 	"errors"
+
+	"go.podman.io/storage/pkg/directory"
 )
 
 // Quota limit params - currently we only control blocks hard limit
@@ -31,6 +33,11 @@ func (q *Control) GetQuota(targetPath string, quota *Quota) error {
 	return errors.New("filesystem does not support, or has not enabled quotas")
 }
 
+// GetDiskUsage - get the current disk usage of a directory that was configured with SetQuota
+func (q *Control) GetDiskUsage(targetPath string, usage *directory.DiskUsage) error {
+	return errors.New("filesystem does not support, or has not enabled quotas")
+}
+
 // ClearQuota removes the map entry in the quotas map for targetPath.
 // It does so to prevent the map leaking entries as directories are deleted.
 func (q *Control) ClearQuota(targetPath string) {}
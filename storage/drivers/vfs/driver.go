@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,13 +10,16 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/opencontainers/selinux/go-selinux/label"
 	"github.com/sirupsen/logrus"
 	"github.com/vbatts/tar-split/tar/storage"
 	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/drivers/quota"
 	"go.podman.io/storage/internal/dedup"
 	"go.podman.io/storage/internal/driver"
+	"go.podman.io/storage/internal/objectstore"
 	"go.podman.io/storage/internal/tempdir"
 	"go.podman.io/storage/pkg/archive"
 	"go.podman.io/storage/pkg/directory"
@@ -42,6 +46,7 @@ func Init(home string, options graphdriver.Options) (graphdriver.Driver, error)
 		imageStore: options.ImageStore,
 		syncMode:   graphdriver.SyncModeNone,
 	}
+	var objectStore objectstore.Method
 
 	if err := os.MkdirAll(filepath.Join(home, "dir"), 0o700); err != nil {
 		return nil, err
@@ -85,11 +90,31 @@ func Init(home string, options graphdriver.Options) (graphdriver.Driver, error)
 			default:
 				return nil, fmt.Errorf("invalid mode for vfs driver: %q", val)
 			}
+		case "object_store":
+			logrus.Debugf("vfs: object_store=%s", val)
+			if val != "" {
+				var err error
+				if objectStore, err = objectstore.ParseMethod(val); err != nil {
+					return nil, err
+				}
+			}
 		default:
 			return nil, fmt.Errorf("unknown option %q (%q)", key, option)
 		}
 	}
 
+	// Limits set on individual layers use loopback images, which are mounted again here if
+	// any exist; otherwise, loopback quotas are initialized when the first limit is set.
+	if _, err := d.loopbackQuota(false); err != nil {
+		logrus.Debugf("Loopback quotas are not available: %v", err)
+	}
+	var err error
+	if objectStore != 0 {
+		if d.objects, err = objectstore.New(filepath.Join(home, objectstore.DirName), objectStore); err != nil {
+			return nil, fmt.Errorf("initializing the vfs object store: %w", err)
+		}
+	}
+
 	d.updater = graphdriver.NewNaiveLayerIDMapUpdater(d)
 	d.naiveDiff = graphdriver.NewNaiveDiffDriver(d, d.updater)
 
@@ -109,6 +134,9 @@ type Driver struct {
 	naiveDiff         graphdriver.DiffDriver
 	updater           graphdriver.LayerIDMapUpdater
 	imageStore        string
+	loopbackQuotaLock sync.Mutex
+	loopbackQuotaCtl  *quota.LoopbackControl // Initialized by loopbackQuota.
+	objects           *objectstore.Store
 }
 
 func (d *Driver) String() string {
@@ -150,6 +178,11 @@ func (d *Driver) DiffGetter(id string) (graphdriver.FileGetCloser, error) {
 	return fileGetNilCloser{storage.NewPathFileGetter(p)}, nil
 }
 
+// CloneLayer creates a read-only layer as a copy of source, which contains all of its files.
+func (d *Driver) CloneLayer(id, parent, source string, opts *graphdriver.CreateOpts) error {
+	return d.Create(id, source, opts)
+}
+
 // CreateFromTemplate creates a layer with the same contents and parent as another layer.
 func (d *Driver) CreateFromTemplate(id, template string, templateIDMappings *idtools.IDMappings, parent string, parentIDMappings *idtools.IDMappings, opts *graphdriver.CreateOpts, readWrite bool) error {
 	if readWrite {
@@ -273,7 +306,65 @@ func (d *Driver) dir(id string) string {
 
 // Remove deletes the content from the directory for a given id.
 func (d *Driver) Remove(id string) error {
-	return system.EnsureRemoveAll(d.dir(id))
+	dir := d.dir(id)
+	if err := d.clearQuota(dir); err != nil {
+		return err
+	}
+	if err := system.EnsureRemoveAll(dir); err != nil {
+		return err
+	}
+	return d.releaseObjects(id)
+}
+
+// releaseObjects releases the objects referenced by a layer which was deleted.
+func (d *Driver) releaseObjects(id string) error {
+	if d.objects == nil {
+		return nil
+	}
+	return d.objects.RemoveLayer(id)
+}
+
+// loopbackQuota returns the LoopbackControl used for layer quotas, initializing it if needed.
+// Unless create is set, it is only initialized if quota images exist, possibly created by another
+// process; otherwise, nil is returned.
+func (d *Driver) loopbackQuota(create bool) (*quota.LoopbackControl, error) {
+	d.loopbackQuotaLock.Lock()
+	defer d.loopbackQuotaLock.Unlock()
+
+	if d.loopbackQuotaCtl != nil {
+		return d.loopbackQuotaCtl, nil
+	}
+	imagesDir := filepath.Join(d.home, quota.LoopbackImagesDir)
+	if !create {
+		if err := fileutils.Exists(imagesDir); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	ctl, err := quota.NewLoopbackControl(imagesDir, filepath.Join(d.home, "dir"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", graphdriver.ErrNotSupported, err)
+	}
+	d.loopbackQuotaCtl = ctl
+	return ctl, nil
+}
+
+// clearQuota removes the loopback image mounted on the layer directory dir, if any.
+func (d *Driver) clearQuota(dir string) error {
+	ctl, err := d.loopbackQuota(false)
+	if err != nil {
+		if errors.Is(err, graphdriver.ErrNotSupported) {
+			// No images can have been created.
+			return nil
+		}
+		return err
+	}
+	if ctl == nil {
+		return nil
+	}
+	return ctl.ClearQuota(dir)
 }
 
 func (d *Driver) GetTempDirRootDirs() []string {
@@ -306,10 +397,42 @@ func (d *Driver) DeferredRemove(id string) (tempdir.CleanupTempDirFunc, error) {
 	}
 
 	layerDir := d.dir(id)
+	if err := d.clearQuota(layerDir); err != nil {
+		return t.Cleanup, err
+	}
 	if err := t.StageDeletion(layerDir); err != nil {
 		return t.Cleanup, err
 	}
-	return t.Cleanup, nil
+	if d.objects == nil {
+		return t.Cleanup, nil
+	}
+	// The objects can be released only once the files of the layer are deleted.
+	return func() error {
+		return errors.Join(t.Cleanup(), d.releaseObjects(id))
+	}, nil
+}
+
+// AddLayerToObjectStore makes the regular files of the read-only layer with the specified id
+// reference objects in the object store, if the driver is configured to use one.
+func (d *Driver) AddLayerToObjectStore(id string) error {
+	if d.objects == nil {
+		return nil
+	}
+	dir := d.dir(id)
+	if dir != filepath.Join(d.home, "dir", filepath.Base(id)) {
+		// Layers in other stores are not ours to modify.
+		return nil
+	}
+	return d.objects.AddLayer(id, dir)
+}
+
+// GarbageCollectObjects deletes the objects which are not referenced by any of the specified
+// layers, if the driver is configured to use an object store.
+func (d *Driver) GarbageCollectObjects(layers []string) error {
+	if d.objects == nil {
+		return nil
+	}
+	return d.objects.GarbageCollect(layers)
 }
 
 // Get returns the directory for the given id.
@@ -344,6 +467,57 @@ func (d *Driver) ReadWriteDiskUsage(id string) (*directory.DiskUsage, error) {
 	return directory.Usage(d.dir(id))
 }
 
+// SetLayerQuota sets, changes or removes the size limit for the writable layer with the specified id,
+// using a loopback image.
+func (d *Driver) SetLayerQuota(id string, limits graphdriver.LayerQuota) error {
+	if limits.Inodes != 0 {
+		return fmt.Errorf("vfs: limiting the number of inodes: %w", graphdriver.ErrNotSupported)
+	}
+	dir := d.dir(id)
+	if err := fileutils.Exists(dir); err != nil {
+		return err
+	}
+	ctl, err := d.loopbackQuota(true)
+	if err != nil {
+		return fmt.Errorf("setting a quota for layer %q: %w", id, err)
+	}
+	if err := ctl.SetQuota(dir, quota.Quota{Size: limits.Size, Inodes: limits.Inodes}); err != nil {
+		if errors.Is(err, quota.ErrInUse) {
+			return fmt.Errorf("setting a quota for layer %q: %w: %w", id, graphdriver.ErrLayerInUse, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// LayerQuota returns the size limit for the writable layer with the specified id, and its current disk usage.
+func (d *Driver) LayerQuota(id string) (graphdriver.LayerQuota, *directory.DiskUsage, error) {
+	dir := d.dir(id)
+	if err := fileutils.Exists(dir); err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	ctl, err := d.loopbackQuota(false)
+	if err != nil {
+		return graphdriver.LayerQuota{}, nil, fmt.Errorf("reading the quota of layer %q: %w", id, err)
+	}
+	var q quota.Quota
+	if ctl != nil {
+		if err := ctl.GetQuota(dir, &q); err != nil {
+			return graphdriver.LayerQuota{}, nil, err
+		}
+	}
+	if q.Size == 0 {
+		// Without a limit, the layer's usage isn't tracked by a file system of its own.
+		usage, err := directory.Usage(dir)
+		return graphdriver.LayerQuota{}, usage, err
+	}
+	usage := &directory.DiskUsage{}
+	if err := ctl.GetDiskUsage(dir, usage); err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	return graphdriver.LayerQuota{Size: q.Size}, usage, nil
+}
+
 // Exists checks to see if the directory exists for the given id.
 func (d *Driver) Exists(id string) bool {
 	err := fileutils.Exists(d.dir(id))
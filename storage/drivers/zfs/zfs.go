@@ -266,6 +266,11 @@ func (d *Driver) mountPath(id string) string {
 	return path.Join(d.options.mountPath, "graph", getMountpoint(id))
 }
 
+// CloneLayer creates a read-only layer as a clone of a snapshot of source, which contains all of its files.
+func (d *Driver) CloneLayer(id, parent, source string, opts *graphdriver.CreateOpts) error {
+	return d.Create(id, source, opts)
+}
+
 // CreateFromTemplate creates a layer with the same contents and parent as another layer.
 func (d *Driver) CreateFromTemplate(id, template string, templateIDMappings *idtools.IDMappings, parent string, parentIDMappings *idtools.IDMappings, opts *graphdriver.CreateOpts, readWrite bool) error {
 	return d.Create(id, template, opts)
@@ -511,6 +516,29 @@ func (d *Driver) ReadWriteDiskUsage(id string) (*directory.DiskUsage, error) {
 	return directory.Usage(d.mountPath(id))
 }
 
+// SetLayerQuota sets, changes or removes the size limit for the writable layer with the specified id,
+// using the quota property of its dataset.
+func (d *Driver) SetLayerQuota(id string, limits graphdriver.LayerQuota) error {
+	if limits.Inodes != 0 {
+		return fmt.Errorf("zfs: limiting the number of inodes: %w", graphdriver.ErrNotSupported)
+	}
+	quota := "none"
+	if limits.Size != 0 {
+		quota = strconv.FormatUint(limits.Size, 10)
+	}
+	return setQuota(d.zfsPath(id), quota)
+}
+
+// LayerQuota returns the size limit for the writable layer with the specified id, and the space
+// used by its dataset.
+func (d *Driver) LayerQuota(id string) (graphdriver.LayerQuota, *directory.DiskUsage, error) {
+	dataset, err := zfs.GetDataset(d.zfsPath(id))
+	if err != nil {
+		return graphdriver.LayerQuota{}, nil, err
+	}
+	return graphdriver.LayerQuota{Size: dataset.Quota}, &directory.DiskUsage{Size: int64(dataset.Used)}, nil
+}
+
 // Exists checks to see if the cache entry exists for the given id.
 func (d *Driver) Exists(id string) bool {
 	d.Lock()
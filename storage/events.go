@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/lockfile"
+)
+
+// EventType describes the kind of a change reported by Store.Watch.
+type EventType string
+
+const (
+	// EventCreated is reported when an object is created.
+	EventCreated EventType = "created"
+	// EventDeleted is reported when an object is deleted.
+	EventDeleted EventType = "deleted"
+	// EventRenamed is reported when names of an object change.
+	EventRenamed EventType = "renamed"
+	// EventBigDataChanged is reported when a big data item of an object is set.
+	EventBigDataChanged EventType = "bigdata-changed"
+	// EventOverflow is reported when some events might have been lost, e.g. because the watcher has fallen
+	// behind the size-limited event journal, or the journal has been removed.
+	// Consumers should reread the state of the store.
+	EventOverflow EventType = "overflow"
+)
+
+// EventObjectType identifies the kind of object an Event refers to.
+type EventObjectType string
+
+const (
+	EventObjectLayer     EventObjectType = "layer"
+	EventObjectImage     EventObjectType = "image"
+	EventObjectContainer EventObjectType = "container"
+)
+
+// Event is a change to a Store, as reported by Store.Watch.
+type Event struct {
+	// Sequence is a number identifying the event, increasing with every recorded event. It is 0 for EventOverflow.
+	Sequence uint64 `json:"seq"`
+	// Time is the time when the event was recorded.
+	Time time.Time `json:"time"`
+	// Type is the kind of the change.
+	Type EventType `json:"type"`
+	// ObjectType is the kind of the changed object; it is not set for EventOverflow.
+	ObjectType EventObjectType `json:"object,omitempty"`
+	// ID is the ID of the changed object; it is not set for EventOverflow.
+	ID string `json:"id,omitempty"`
+	// Names are the names of the object after the change, for EventCreated and EventRenamed.
+	Names []string `json:"names,omitempty"`
+	// Key is the key of the big data item, for EventBigDataChanged.
+	Key string `json:"key,omitempty"`
+}
+
+const (
+	// eventJournalMaxSize is the size of the event journal which triggers discarding the older half of its events.
+	eventJournalMaxSize = 1024 * 1024
+	// eventPollInterval is how often Watch checks the event journal for changes.
+	eventPollInterval = 250 * time.Millisecond
+)
+
+// eventJournal is a size-limited on-disk record of recent events, shared by all processes using a store.
+// Writers append events while holding lockfile for writing, and record the write in the lock file,
+// so that watchers can cheaply check for new events using lockfile.ModifiedSince.
+type eventJournal struct {
+	lockfile *lockfile.LockFile
+	path     string
+
+	// The following fields can only be accessed with lockfile held for writing, and describe the journal
+	// as of lastWrite, to avoid rereading the journal for every recorded event.
+	lastWrite    lockfile.LastWrite
+	loaded       bool // lastSequence and size are only valid if loaded is true
+	lastSequence uint64
+	size         int64
+}
+
+// newEventJournal returns an eventJournal stored in dir, creating dir if necessary.
+func newEventJournal(dir string) (*eventJournal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := lockfile.GetLockFile(filepath.Join(dir, "events.lock"))
+	if err != nil {
+		return nil, err
+	}
+	j := &eventJournal{
+		lockfile: lock,
+		path:     filepath.Join(dir, "events.json"),
+	}
+	j.lockfile.RLock()
+	defer j.lockfile.Unlock()
+	j.lastWrite, err = j.lockfile.GetLastWrite()
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// readLocked returns all events in the journal, and its size.
+// The caller must hold j.lockfile (for reading or writing).
+func (j *eventJournal) readLocked() ([]Event, int64, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, nil
+		}
+		return nil, -1, err
+	}
+	events := []Event{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, eventJournalMaxSize)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Most likely a partially-written line after a crash; the following events are still usable.
+			logrus.Debugf("Ignoring invalid entry in event journal %q: %v", j.path, err)
+			continue
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, -1, fmt.Errorf("reading event journal %q: %w", j.path, err)
+	}
+	return events, int64(len(data)), nil
+}
+
+// record appends events to the journal, setting their Sequence and Time fields.
+func (j *eventJournal) record(events []Event) error {
+	j.lockfile.Lock()
+	defer j.lockfile.Unlock()
+
+	lastWrite, modified, err := j.lockfile.ModifiedSince(j.lastWrite)
+	if err != nil {
+		return err
+	}
+	var existing []Event // Only read if necessary
+	if modified || !j.loaded {
+		existing, j.size, err = j.readLocked()
+		if err != nil {
+			return err
+		}
+		j.lastSequence = 0
+		if len(existing) != 0 {
+			j.lastSequence = existing[len(existing)-1].Sequence
+		}
+		j.lastWrite = lastWrite
+		j.loaded = true
+	}
+
+	now := time.Now().UTC()
+	var newData bytes.Buffer
+	for i := range events {
+		events[i].Sequence = j.lastSequence + uint64(i) + 1
+		events[i].Time = now
+		line, err := json.Marshal(events[i])
+		if err != nil {
+			return err
+		}
+		newData.Write(line)
+		newData.WriteByte('\n')
+	}
+
+	if j.size+int64(newData.Len()) > eventJournalMaxSize {
+		if existing == nil {
+			if existing, _, err = j.readLocked(); err != nil {
+				return err
+			}
+		}
+		var data bytes.Buffer
+		for _, e := range existing[len(existing)/2:] {
+			line, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			data.Write(line)
+			data.WriteByte('\n')
+		}
+		data.Write(newData.Bytes())
+		if err := ioutils.AtomicWriteFile(j.path, data.Bytes(), 0o600); err != nil {
+			return err
+		}
+		j.size = int64(data.Len())
+	} else {
+		if err := appendToFile(j.path, newData.Bytes()); err != nil {
+			return err
+		}
+		j.size += int64(newData.Len())
+	}
+	j.lastSequence += uint64(len(events))
+	lw, err := j.lockfile.RecordWrite()
+	if err != nil {
+		j.loaded = false // Force a reload the next time
+		return err
+	}
+	j.lastWrite = lw
+	return nil
+}
+
+// appendToFile appends data to the file at path, creating it if necessary.
+func appendToFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// current returns the current state of the journal, to be used as a starting point for eventsSince.
+func (j *eventJournal) current() (lockfile.LastWrite, uint64, error) {
+	j.lockfile.RLock()
+	defer j.lockfile.Unlock()
+
+	lastWrite, err := j.lockfile.GetLastWrite()
+	if err != nil {
+		return lockfile.LastWrite{}, 0, err
+	}
+	events, _, err := j.readLocked()
+	if err != nil {
+		return lockfile.LastWrite{}, 0, err
+	}
+	lastSequence := uint64(0)
+	if len(events) != 0 {
+		lastSequence = events[len(events)-1].Sequence
+	}
+	return lastWrite, lastSequence, nil
+}
+
+// eventsSince returns events recorded after the one with lastSequence, if the journal has been modified since lastWrite,
+// and updated values of lastWrite and lastSequence.
+// If some events might have been lost, the returned events start with an EventOverflow event.
+func (j *eventJournal) eventsSince(lastWrite lockfile.LastWrite, lastSequence uint64) ([]Event, lockfile.LastWrite, uint64, error) {
+	j.lockfile.RLock()
+	defer j.lockfile.Unlock()
+
+	newLastWrite, modified, err := j.lockfile.ModifiedSince(lastWrite)
+	if err != nil {
+		return nil, lastWrite, lastSequence, err
+	}
+	if !modified {
+		return nil, lastWrite, lastSequence, nil
+	}
+	events, _, err := j.readLocked()
+	if err != nil {
+		return nil, lastWrite, lastSequence, err
+	}
+
+	res := []Event{}
+	switch {
+	case len(events) == 0:
+		if lastSequence != 0 { // The journal has been removed
+			res = append(res, Event{Type: EventOverflow, Time: time.Now().UTC()})
+		}
+		return res, newLastWrite, 0, nil
+	case events[len(events)-1].Sequence < lastSequence: // The journal has been removed and recreated
+		res = append(res, Event{Type: EventOverflow, Time: time.Now().UTC()})
+		lastSequence = 0
+	case events[0].Sequence > lastSequence+1: // Older events were discarded before we could read them
+		res = append(res, Event{Type: EventOverflow, Time: time.Now().UTC()})
+	}
+	for _, e := range events {
+		if e.Sequence > lastSequence {
+			res = append(res, e)
+		}
+	}
+	return res, newLastWrite, events[len(events)-1].Sequence, nil
+}
+
+// watch sends events recorded in the journal after it was called to the returned channel,
+// until ctx is canceled; the channel is then closed.
+func (j *eventJournal) watch(ctx context.Context) (<-chan Event, error) {
+	lastWrite, lastSequence, err := j.current()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Event, 64)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(eventPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			var events []Event
+			var err error
+			events, lastWrite, lastSequence, err = j.eventsSince(lastWrite, lastSequence)
+			if err != nil {
+				logrus.Debugf("Reading event journal %q: %v", j.path, err)
+				continue
+			}
+			for _, e := range events {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- e:
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Watch returns a channel reporting changes to layers, images and containers made after Watch was called,
+// by this or other processes using the same store.
+// The channel is closed when ctx is canceled.
+// Changes are detected by polling, and reported with a delay; they might be reported in a single batch.
+func (s *store) Watch(ctx context.Context) (<-chan Event, error) {
+	if s.eventJournal == nil {
+		return nil, errors.New("watching for changes is not supported by this store")
+	}
+	return s.eventJournal.watch(ctx)
+}
+
+// recordEvents adds events to the event journal, to be reported by Watch.
+// Failures are only logged, because the changes have already been made at this point.
+func (s *store) recordEvents(events ...Event) {
+	if s.eventJournal == nil || len(events) == 0 {
+		return
+	}
+	if err := s.eventJournal.record(events); err != nil {
+		logrus.Warnf("Recording storage events: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+// eventSummary is the subset of an Event which is predictable in tests.
+type eventSummary struct {
+	Type       EventType
+	ObjectType EventObjectType
+	ID         string
+	Names      []string
+	Key        string
+}
+
+// receiveEvents reads n events from ch, failing if they don't arrive in time.
+func receiveEvents(t *testing.T, ch <-chan Event, n int) []eventSummary {
+	res := []eventSummary{}
+	timeout := time.After(10 * time.Second)
+	var lastSequence uint64
+	for len(res) < n {
+		select {
+		case e, ok := <-ch:
+			require.True(t, ok, "channel closed unexpectedly")
+			assert.Greater(t, e.Sequence, lastSequence)
+			lastSequence = e.Sequence
+			res = append(res, eventSummary{Type: e.Type, ObjectType: e.ObjectType, ID: e.ID, Names: e.Names, Key: e.Key})
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for events", "received %#v", res)
+		}
+	}
+	return res
+}
+
+func TestStoreWatch(t *testing.T) {
+	reexec.Init()
+
+	store := newTestStore(t, StoreOptions{})
+	defer func() {
+		_, err := store.Shutdown(true)
+		require.NoError(t, err)
+		store.Free()
+	}()
+
+	// Events recorded before Watch is called are not reported.
+	_, err := store.CreateLayer("Base", "", []string{"base"}, "", false, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := store.Watch(ctx)
+	require.NoError(t, err)
+
+	_, err = store.CreateLayer("Layer", "", []string{"l1"}, "", false, nil)
+	require.NoError(t, err)
+	_, err = store.CreateImage("Image", []string{"i"}, "Layer", "", nil)
+	require.NoError(t, err)
+	err = store.AddNames("Image", []string{"i2"})
+	require.NoError(t, err)
+	err = store.SetImageBigData("Image", "key", []byte("data"), nil)
+	require.NoError(t, err)
+	_, err = store.CreateContainer("Container", []string{"c"}, "Image", "ContainerLayer", "", nil)
+	require.NoError(t, err)
+	err = store.SetContainerBigData("Container", "ckey", []byte("data"))
+	require.NoError(t, err)
+	err = store.DeleteContainer("Container")
+	require.NoError(t, err)
+	image, err := store.Image("Image")
+	require.NoError(t, err)
+	require.Len(t, image.MappedTopLayers, 1) // Created by CreateContainer, because the layer does not use the store's ID mappings
+	_, err = store.DeleteImage("Image", true)
+	require.NoError(t, err)
+
+	assert.Equal(t, []eventSummary{
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: "Layer", Names: []string{"l1"}},
+		{Type: EventCreated, ObjectType: EventObjectImage, ID: "Image", Names: []string{"i"}},
+		{Type: EventRenamed, ObjectType: EventObjectImage, ID: "Image", Names: []string{"i2", "i"}},
+		{Type: EventBigDataChanged, ObjectType: EventObjectImage, ID: "Image", Key: "key"},
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: image.MappedTopLayers[0]},
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: "ContainerLayer"},
+		{Type: EventCreated, ObjectType: EventObjectContainer, ID: "Container", Names: []string{"c"}},
+		{Type: EventBigDataChanged, ObjectType: EventObjectContainer, ID: "Container", Key: "ckey"},
+		{Type: EventDeleted, ObjectType: EventObjectContainer, ID: "Container"},
+		{Type: EventDeleted, ObjectType: EventObjectLayer, ID: "ContainerLayer"},
+		{Type: EventDeleted, ObjectType: EventObjectImage, ID: "Image"},
+		{Type: EventDeleted, ObjectType: EventObjectLayer, ID: image.MappedTopLayers[0]},
+		{Type: EventDeleted, ObjectType: EventObjectLayer, ID: "Layer"},
+	}, receiveEvents(t, ch, 13))
+
+	cancel()
+	for range ch { // Wait for the channel to be closed
+	}
+}
+
+func TestEventJournalOverflow(t *testing.T) {
+	j, err := newEventJournal(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, j.record([]Event{{Type: EventCreated, ObjectType: EventObjectLayer, ID: "1"}}))
+	lastWrite, lastSequence, err := j.current()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), lastSequence)
+
+	// No changes
+	events, newLastWrite, newLastSequence, err := j.eventsSince(lastWrite, lastSequence)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	assert.Equal(t, lastSequence, newLastSequence)
+	assert.Equal(t, lastWrite, newLastWrite)
+
+	// New events
+	require.NoError(t, j.record([]Event{
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: "2"},
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: "3"},
+		{Type: EventCreated, ObjectType: EventObjectLayer, ID: "4"},
+	}))
+	events, _, newLastSequence, err = j.eventsSince(lastWrite, lastSequence)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "2", events[0].ID)
+	assert.Equal(t, uint64(4), newLastSequence)
+
+	// Simulate discarding older events, before the watcher has read them.
+	allEvents, _, err := j.readLocked()
+	require.NoError(t, err)
+	data, err := json.Marshal(allEvents[3])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(j.path, append(data, '\n'), 0o600))
+	events, _, newLastSequence, err = j.eventsSince(lastWrite, lastSequence)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventOverflow, events[0].Type)
+	assert.Equal(t, "4", events[1].ID)
+	assert.Equal(t, uint64(4), newLastSequence)
+
+	// Sequence numbers continue after the last recorded event
+	require.NoError(t, j.record([]Event{{Type: EventDeleted, ObjectType: EventObjectLayer, ID: "4"}}))
+	allEvents, _, err = j.readLocked()
+	require.NoError(t, err)
+	require.Len(t, allEvents, 2)
+	assert.Equal(t, uint64(5), allEvents[1].Sequence)
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PruneImagesOptions is the policy used by Store.PruneImages.
+type PruneImagesOptions struct {
+	// MaxSize, if positive, is the disk space which layers and data of images may use, in bytes.
+	MaxSize int64
+	// MaxAge, if positive, is how long an image may remain unused.
+	MaxAge time.Duration
+	// Keep contains IDs of images which must not be removed, e.g. an image which was just pulled.
+	Keep []string
+}
+
+// errImageEvictionChanged is returned to skip removing an image which was pinned or used after it was selected.
+var errImageEvictionChanged = errors.New("image was pinned or used since it was selected for removal")
+
+// imageEvictionCandidate is an image which can be removed by Store.PruneImages.
+type imageEvictionCandidate struct {
+	id       string
+	lastUsed time.Time
+}
+
+// imagePinned returns true if image must not be removed by Store.PruneImages.
+func imagePinned(image *Image) bool {
+	pinned, _ := image.Flags[ImagePinnedFlag].(bool)
+	return pinned
+}
+
+// layerSize returns the disk space used by layer, as recorded when its contents were applied.
+// Unlike the disk usage reported by the storage driver, this does not require walking the layer.
+func layerSize(layer *Layer) int64 {
+	switch {
+	case (layer.UncompressedDigest != "" || layer.TOCDigest != "") && layer.UncompressedSize >= 0:
+		return layer.UncompressedSize
+	case layer.CompressedDigest != "" && layer.CompressedSize >= 0:
+		// The uncompressed size is not known, the compressed size is a lower bound.
+		return layer.CompressedSize
+	default:
+		return 0
+	}
+}
+
+// imageLastUsed returns the time image was last used, falling back to its creation date
+// for images recorded by older versions of the library.
+func imageLastUsed(image *Image) time.Time {
+	if image.LastUsed.IsZero() {
+		return image.Created
+	}
+	return image.LastUsed
+}
+
+func (s *store) PruneImages(options *PruneImagesOptions) ([]string, error) {
+	opts := PruneImagesOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxSize <= 0 && opts.MaxAge <= 0 {
+		opts.MaxSize = s.imageEviction.MaxSize
+		opts.MaxAge = s.imageEviction.MaxAge
+	}
+	return s.pruneImages(opts)
+}
+
+func (s *store) SetImagePinned(id string, pinned bool) error {
+	_, err := writeToImageStore(s, func() (struct{}, error) {
+		if !s.imageStore.Exists(id) {
+			return struct{}{}, fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
+		}
+		if pinned {
+			return struct{}{}, s.imageStore.SetFlag(id, ImagePinnedFlag, true)
+		}
+		return struct{}{}, s.imageStore.ClearFlag(id, ImagePinnedFlag)
+	})
+	return err
+}
+
+// pruneImages implements PruneImages, with the limits in options already set.
+func (s *store) pruneImages(options PruneImagesOptions) ([]string, error) {
+	if options.MaxSize <= 0 && options.MaxAge <= 0 {
+		return nil, nil
+	}
+	selected, err := s.selectImagesToEvict(options)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, candidate := range selected {
+		_, err := s.deleteImage(candidate.id, true, func(image *Image) error {
+			if imagePinned(image) || !imageLastUsed(image).Equal(candidate.lastUsed) {
+				return errImageEvictionChanged
+			}
+			return nil
+		})
+		if err != nil {
+			if errors.Is(err, errImageEvictionChanged) || errors.Is(err, ErrImageUsedByContainer) || errors.Is(err, ErrNotAnImage) {
+				logrus.Debugf("Not removing image %q: %v", candidate.id, err)
+				continue
+			}
+			return removed, fmt.Errorf("removing image %q: %w", candidate.id, err)
+		}
+		logrus.Debugf("Removed image %q, last used at %s", candidate.id, candidate.lastUsed)
+		removed = append(removed, candidate.id)
+	}
+	return removed, nil
+}
+
+// selectImagesToEvict returns the images which should be removed to follow options, in the order
+// in which they should be removed.
+func (s *store) selectImagesToEvict(options PruneImagesOptions) ([]imageEvictionCandidate, error) {
+	rlstore, err := s.getLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	if err := rlstore.startReading(); err != nil {
+		return nil, err
+	}
+	defer rlstore.stopReading()
+	if err := s.imageStore.startReading(); err != nil {
+		return nil, err
+	}
+	defer s.imageStore.stopReading()
+	if err := s.containerStore.startReading(); err != nil {
+		return nil, err
+	}
+	defer s.containerStore.stopReading()
+
+	images, err := s.imageStore.Images()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := s.containerStore.Containers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only layers in the read-write layer store are considered: layers in additional
+	// layer stores can not be removed, and don't use space we are responsible for.
+	parents := make(map[string]string)
+	layerSizes := make(map[string]int64)
+	layers, err := rlstore.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range layers {
+		parents[layers[i].ID] = layers[i].Parent
+		layerSizes[layers[i].ID] = layerSize(&layers[i])
+	}
+	// chain returns the IDs of the layers starting at each of top, and all of their parents.
+	chain := func(top ...string) []string {
+		var res []string
+		seen := make(map[string]struct{})
+		for _, layer := range top {
+			for layer != "" {
+				if _, ok := seen[layer]; ok {
+					break
+				}
+				parent, ok := parents[layer]
+				if !ok {
+					break
+				}
+				seen[layer] = struct{}{}
+				res = append(res, layer)
+				layer = parent
+			}
+		}
+		return res
+	}
+
+	// Layers used by containers are never removed, nor are images used by containers.
+	usedImages := make(map[string]struct{})
+	usedLayers := make(map[string]struct{})
+	for _, container := range containers {
+		usedImages[container.ImageID] = struct{}{}
+		for _, layer := range chain(container.LayerID) {
+			usedLayers[layer] = struct{}{}
+		}
+	}
+
+	// Compute the space used by all images, and how many images use each layer.
+	references := make(map[string]int)
+	imageLayers := make(map[string][]string)
+	imageDataSizes := make(map[string]int64)
+	var usage int64
+	for i := range images {
+		image := &images[i]
+		imageLayers[image.ID] = chain(append([]string{image.TopLayer}, image.MappedTopLayers...)...)
+		for _, layerID := range imageLayers[image.ID] {
+			references[layerID]++
+			if references[layerID] == 1 {
+				usage += layerSizes[layerID]
+			}
+		}
+		for _, n := range image.BigDataSizes {
+			imageDataSizes[image.ID] += n
+		}
+		usage += imageDataSizes[image.ID]
+	}
+
+	if options.MaxAge <= 0 && usage <= options.MaxSize {
+		return nil, nil // The size limit is not exceeded, and there is no age limit.
+	}
+
+	var candidates []imageEvictionCandidate
+	for i := range images {
+		image := &images[i]
+		if _, used := usedImages[image.ID]; used || slices.Contains(options.Keep, image.ID) || imagePinned(image) {
+			continue
+		}
+		candidates = append(candidates, imageEvictionCandidate{id: image.ID, lastUsed: imageLastUsed(image)})
+	}
+	slices.SortStableFunc(candidates, func(a, b imageEvictionCandidate) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+
+	var selected []imageEvictionCandidate
+	cutoff := time.Now().Add(-options.MaxAge)
+	for _, candidate := range candidates {
+		expired := options.MaxAge > 0 && candidate.lastUsed.Before(cutoff)
+		overBudget := options.MaxSize > 0 && usage > options.MaxSize
+		if !expired && !overBudget {
+			// Candidates are sorted, so no later candidate has expired either.
+			break
+		}
+		selected = append(selected, candidate)
+		for _, layerID := range imageLayers[candidate.id] {
+			references[layerID]--
+			if _, used := usedLayers[layerID]; !used && references[layerID] == 0 {
+				usage -= layerSizes[layerID]
+			}
+		}
+		usage -= imageDataSizes[candidate.id]
+	}
+	return selected, nil
+}
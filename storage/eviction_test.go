@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+// createTestImage creates an image with its own layer and 1000 bytes of data, last used at lastUsed.
+func createTestImage(t *testing.T, s Store, id string, lastUsed time.Time) {
+	_, err := s.CreateLayer(id+"-layer", "", nil, "", false, nil)
+	require.NoError(t, err)
+	_, err = s.CreateImage(id, nil, id+"-layer", "", &ImageOptions{
+		BigData: []ImageBigDataOption{{Key: "data", Data: bytes.Repeat([]byte{'x'}, 1000)}},
+	})
+	require.NoError(t, err)
+	setImageLastUsed(t, s, id, lastUsed)
+}
+
+// setImageLastUsed sets the LastUsed time of image id.
+func setImageLastUsed(t *testing.T, s Store, id string, lastUsed time.Time) {
+	is := s.(*store).imageStore
+	require.NoError(t, is.startWriting())
+	defer is.stopWriting()
+	image, ok := is.(*imageStore).lookup(id)
+	require.True(t, ok)
+	image.LastUsed = lastUsed
+	require.NoError(t, is.(*imageStore).Save())
+}
+
+func TestPruneImages(t *testing.T) {
+	reexec.Init()
+
+	s := newTestStore(t, StoreOptions{})
+	defer func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	}()
+
+	now := time.Now()
+	createTestImage(t, s, "Pinned", now.Add(-4*time.Hour))
+	require.NoError(t, s.SetImagePinned("Pinned", true))
+	createTestImage(t, s, "Used", now.Add(-3*time.Hour))
+	_, err := s.CreateContainer("Container", nil, "Used", "", "", nil)
+	require.NoError(t, err)
+	createTestImage(t, s, "Old", now.Add(-2*time.Hour))
+	createTestImage(t, s, "New", now.Add(-30*time.Minute))
+
+	// Creating a container records a use of the image.
+	image, err := s.Image("Used")
+	require.NoError(t, err)
+	assert.WithinDuration(t, now, image.LastUsed, time.Minute)
+
+	removed, err := s.PruneImages(nil)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	removed, err = s.PruneImages(&PruneImagesOptions{MaxSize: 3500})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Old"}, removed)
+	for _, id := range []string{"Pinned", "Used", "New"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	assert.False(t, s.Exists("Old-layer"))
+
+	removed, err = s.PruneImages(&PruneImagesOptions{MaxAge: time.Hour})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	removed, err = s.PruneImages(&PruneImagesOptions{MaxAge: 10 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"New"}, removed)
+
+	removed, err = s.PruneImages(&PruneImagesOptions{MaxSize: 1})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.True(t, s.Exists("Pinned"))
+	assert.True(t, s.Exists("Used"))
+}
+
+func TestImageEviction(t *testing.T) {
+	reexec.Init()
+
+	s := newTestStore(t, StoreOptions{
+		GraphRoot:            filepath.Join(t.TempDir(), "root"),
+		RunRoot:              filepath.Join(t.TempDir(), "run"),
+		ImageEvictionMaxSize: 2500,
+	})
+	defer func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	}()
+
+	now := time.Now()
+	createTestImage(t, s, "First", now.Add(-4*time.Hour))
+	createTestImage(t, s, "Second", now.Add(-3*time.Hour))
+	createTestImage(t, s, "Third", now.Add(-2*time.Hour))
+	createTestImage(t, s, "Pulled", now.Add(-5*time.Hour))
+	// Creating images does not remove any.
+	for _, id := range []string{"First", "Second", "Third", "Pulled"} {
+		assert.True(t, s.Exists(id), id)
+	}
+
+	// Without limits in the options, the configured ones are used, and images in Keep are never removed.
+	removed, err := s.PruneImages(&PruneImagesOptions{Keep: []string{"Pulled"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"First", "Second"}, removed)
+	assert.True(t, s.Exists("Third"))
+	assert.True(t, s.Exists("Pulled"))
+}
+
+func TestPruneImagesLayerSizes(t *testing.T) {
+	reexec.Init()
+
+	s := newTestStore(t, StoreOptions{})
+	defer func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	}()
+
+	var diff bytes.Buffer
+	tw := tar.NewWriter(&diff)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1000}))
+	_, err := tw.Write(bytes.Repeat([]byte{'x'}, 1000))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	layer, _, err := s.PutLayer("", "", nil, "", false, nil, &diff)
+	require.NoError(t, err)
+	_, err = s.CreateImage("Image", nil, layer.ID, "", nil)
+	require.NoError(t, err)
+
+	// The size recorded when the diff was applied is used.
+	require.Greater(t, layer.UncompressedSize, int64(1000))
+	removed, err := s.PruneImages(&PruneImagesOptions{MaxSize: layer.UncompressedSize})
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	removed, err = s.PruneImages(&PruneImagesOptions{MaxSize: layer.UncompressedSize - 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Image"}, removed)
+}
+
+func TestLayerSize(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		layer    Layer
+		expected int64
+	}{
+		{"empty", Layer{}, 0},
+		{"uncompressed", Layer{UncompressedDigest: "sha256:u", UncompressedSize: 10, CompressedDigest: "sha256:c", CompressedSize: 5}, 10},
+		{"TOC", Layer{TOCDigest: "sha256:t", UncompressedSize: 10, CompressedDigest: "sha256:c", CompressedSize: 5}, 10},
+		{"TOC, unknown size", Layer{TOCDigest: "sha256:t", UncompressedSize: -1, CompressedDigest: "sha256:c", CompressedSize: 5}, 5},
+		{"compressed only", Layer{CompressedDigest: "sha256:c", CompressedSize: 5}, 5},
+		{"uninitialized sizes", Layer{UncompressedSize: 10, CompressedSize: 5}, 0},
+	} {
+		assert.Equal(t, c.expected, layerSize(&c.layer), c.name)
+	}
+}
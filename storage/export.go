@@ -0,0 +1,562 @@
+package storage
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+	"go.podman.io/storage/internal/tempdir"
+	"go.podman.io/storage/pkg/archive"
+)
+
+// ExportFilter selects what Store.Export writes.
+type ExportFilter struct {
+	// Images lists the IDs or names of images to export.  If empty, all images are exported.
+	Images []string
+	// Containers causes containers based on exported images to be exported, including the contents
+	// of their read-write layers.  If Images is empty, containers which are not based on an image
+	// are exported as well.
+	Containers bool
+}
+
+const (
+	// exportArchiveHeader is the name of the first entry of an archive written by Store.Export.
+	exportArchiveHeader = "storage-archive.json"
+	// exportArchiveVersion is the version of the archive format written by Store.Export.
+	exportArchiveVersion = 1
+)
+
+// An archive written by Store.Export is a tar stream starting with an exportArchiveHeader entry.
+// It is followed by each exported layer, parents first, then each exported image, then each exported
+// container.  Each object is written as a "<kind>/<id>.json" entry containing its record, followed
+// for layers and containers by a "<kind>/<id>.tar" entry containing the uncompressed diff of the layer,
+// and by "<kind>/<id>/<index>" entries containing the items listed in the BigDataNames of the record.
+
+// exportHeader is the contents of the exportArchiveHeader entry.
+type exportHeader struct {
+	Version int `json:"version"`
+}
+
+// exportWriter writes entries of an archive.
+type exportWriter struct {
+	tw       *tar.Writer
+	tempRoot string           // The root for tempDir.
+	tempDir  *tempdir.TempDir // Created when first needed.
+}
+
+// writeFile writes an entry named name with contents data.
+func (ew *exportWriter) writeFile(name string, data []byte) error {
+	if err := ew.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+	}); err != nil {
+		return err
+	}
+	_, err := ew.tw.Write(data)
+	return err
+}
+
+// writeJSON writes an entry named name containing the JSON encoding of v.
+func (ew *exportWriter) writeJSON(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ew.writeFile(name, data)
+}
+
+// writeStream writes an entry named name with the contents of r, which must contain exactly size bytes.
+// If size is -1, the contents are first copied to a temporary file, because the size of an entry must be
+// known before it is written.
+func (ew *exportWriter) writeStream(name string, size int64, r io.Reader) error {
+	if size == -1 {
+		return ew.writeUnknownSizeStream(name, r)
+	}
+	if err := ew.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o600,
+		Size:     size,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(ew.tw, r, size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("contents of %q are shorter than the expected %d bytes", name, size)
+		}
+		return err
+	}
+	if n, err := io.CopyN(io.Discard, r, 1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	} else if n != 0 {
+		return fmt.Errorf("contents of %q are longer than the expected %d bytes", name, size)
+	}
+	return nil
+}
+
+// writeUnknownSizeStream writes an entry named name with the contents of r, using a temporary file.
+func (ew *exportWriter) writeUnknownSizeStream(name string, r io.Reader) error {
+	if ew.tempDir == nil {
+		tempDir, err := tempdir.NewTempDir(ew.tempRoot)
+		if err != nil {
+			return err
+		}
+		ew.tempDir = tempDir
+	}
+	staged, err := ew.tempDir.StageAddition()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(staged.Path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return ew.writeStream(name, size, f)
+}
+
+// cleanup removes the temporary files used by ew, if any.
+func (ew *exportWriter) cleanup() error {
+	if ew.tempDir == nil {
+		return nil
+	}
+	return ew.tempDir.Cleanup()
+}
+
+// writeExportDiff writes an entry named name with the uncompressed diff of layer id.
+func (s *store) writeExportDiff(ew *exportWriter, name, id string) error {
+	size, _, err := readAllLayerStores(s, func(store roLayerStore) (int64, bool, error) {
+		if store.Exists(id) {
+			size, err := store.uncompressedDiffSize(id)
+			return size, true, err
+		}
+		return -1, false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("determining the diff size of layer %q: %w", id, err)
+	}
+	uncompressed := archive.Uncompressed
+	rc, err := s.Diff("", id, &DiffOptions{Compression: &uncompressed})
+	if err != nil {
+		return fmt.Errorf("reading diff of layer %q: %w", id, err)
+	}
+	defer rc.Close()
+	return ew.writeStream(name, size, rc)
+}
+
+// exportSelection computes the layers, images and containers to export following filter.
+// Layers are returned parents first.
+func (s *store) exportSelection(filter *ExportFilter) ([]*Layer, []*Image, []*Container, error) {
+	var images []*Image
+	if filter == nil || len(filter.Images) == 0 {
+		all, err := s.Images()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range all {
+			images = append(images, &all[i])
+		}
+	} else {
+		for _, name := range filter.Images {
+			image, err := s.Image(name)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if !slices.ContainsFunc(images, func(i *Image) bool { return i.ID == image.ID }) {
+				images = append(images, image)
+			}
+		}
+	}
+
+	var layers []*Layer
+	exportedLayers := make(map[string]struct{})
+	exportedImages := make(map[string]struct{})
+	for _, image := range images {
+		exportedImages[image.ID] = struct{}{}
+		// Collect the image's layers top to bottom, and add the ones we don't have yet bottom to top.
+		// Mapped top layers are not exported: they are recreated when they are needed.
+		var chain []*Layer
+		for id := image.TopLayer; id != ""; {
+			if _, ok := exportedLayers[id]; ok {
+				break
+			}
+			layer, err := s.Layer(id)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("locating layer %q of image %q: %w", id, image.ID, err)
+			}
+			chain = append(chain, layer)
+			id = layer.Parent
+		}
+		for _, layer := range slices.Backward(chain) {
+			exportedLayers[layer.ID] = struct{}{}
+			layers = append(layers, layer)
+		}
+	}
+
+	var containers []*Container
+	if filter != nil && filter.Containers {
+		all, err := s.Containers()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for i := range all {
+			container := &all[i]
+			if _, ok := exportedImages[container.ImageID]; ok || (container.ImageID == "" && len(filter.Images) == 0) {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return layers, images, containers, nil
+}
+
+func (s *store) Export(w io.Writer, filter *ExportFilter) (retErr error) {
+	layers, images, containers, err := s.exportSelection(filter)
+	if err != nil {
+		return err
+	}
+
+	ew := &exportWriter{
+		tw: tar.NewWriter(w),
+		// Use the directory used by the layer store for temporary files, which removes them if we are interrupted.
+		tempRoot: filepath.Join(s.graphRoot, s.graphDriverName+"-layers", tempDirPath),
+	}
+	defer func() {
+		if err := ew.cleanup(); err != nil {
+			retErr = errors.Join(retErr, err)
+		}
+	}()
+	if err := ew.writeJSON(exportArchiveHeader, exportHeader{Version: exportArchiveVersion}); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := ew.writeJSON(path.Join("layers", layer.ID+".json"), layer); err != nil {
+			return err
+		}
+		if err := s.writeExportDiff(ew, path.Join("layers", layer.ID+".tar"), layer.ID); err != nil {
+			return err
+		}
+		for i, key := range layer.BigDataNames {
+			if err := func() error {
+				rc, err := s.LayerBigData(layer.ID, key)
+				if err != nil {
+					return fmt.Errorf("reading data item %q of layer %q: %w", key, layer.ID, err)
+				}
+				defer rc.Close()
+				size := int64(-1)
+				if f, ok := rc.(*os.File); ok {
+					fi, err := f.Stat()
+					if err != nil {
+						return err
+					}
+					size = fi.Size()
+				}
+				return ew.writeStream(path.Join("layers", layer.ID, strconv.Itoa(i)), size, rc)
+			}(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, image := range images {
+		if err := ew.writeJSON(path.Join("images", image.ID+".json"), image); err != nil {
+			return err
+		}
+		for i, key := range image.BigDataNames {
+			data, err := s.ImageBigData(image.ID, key)
+			if err != nil {
+				return fmt.Errorf("reading data item %q of image %q: %w", key, image.ID, err)
+			}
+			if err := ew.writeFile(path.Join("images", image.ID, strconv.Itoa(i)), data); err != nil {
+				return err
+			}
+		}
+	}
+	for _, container := range containers {
+		if err := ew.writeJSON(path.Join("containers", container.ID+".json"), container); err != nil {
+			return err
+		}
+		if err := s.writeExportDiff(ew, path.Join("containers", container.ID+".tar"), container.LayerID); err != nil {
+			return err
+		}
+		for i, key := range container.BigDataNames {
+			data, err := s.ContainerBigData(container.ID, key)
+			if err != nil {
+				return fmt.Errorf("reading data item %q of container %q: %w", key, container.ID, err)
+			}
+			if err := ew.writeFile(path.Join("containers", container.ID, strconv.Itoa(i)), data); err != nil {
+				return err
+			}
+		}
+	}
+	return ew.tw.Close()
+}
+
+// importState is the object whose entries Store.Import is currently reading.
+type importState struct {
+	kind      string // "layers", "images" or "containers"
+	id        string
+	bigData   []string // BigDataNames of the record
+	skipped   bool     // The object already existed, and its entries are ignored.
+	layer     *Layer   // Set for layers, until the layer is created
+	container *Container
+
+	bigDataDigests map[string]digest.Digest // Set for images
+}
+
+// parseImportEntry splits the name of an archive entry into the kind and ID of the object it belongs to,
+// and its suffix: ".json", ".tar", or the index of a data item.
+func parseImportEntry(name string) (kind, id, suffix string, err error) {
+	kind, rest, ok := strings.Cut(name, "/")
+	if !ok || (kind != "layers" && kind != "images" && kind != "containers") {
+		return "", "", "", fmt.Errorf("unexpected entry %q", name)
+	}
+	if id, index, ok := strings.Cut(rest, "/"); ok {
+		return kind, id, index, nil
+	}
+	for _, suffix := range []string{".json", ".tar"} {
+		if id, ok := strings.CutSuffix(rest, suffix); ok {
+			return kind, id, suffix, nil
+		}
+	}
+	return "", "", "", fmt.Errorf("unexpected entry %q", name)
+}
+
+func (s *store) Import(r io.Reader) error {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("reading archive header: %w", err)
+	}
+	if hdr.Name != exportArchiveHeader {
+		return fmt.Errorf("unexpected entry %q, expected %q", hdr.Name, exportArchiveHeader)
+	}
+	var header exportHeader
+	if err := json.NewDecoder(tr).Decode(&header); err != nil {
+		return fmt.Errorf("decoding archive header: %w", err)
+	}
+	if header.Version != exportArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+
+	var current importState
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		kind, id, suffix, err := parseImportEntry(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if suffix == ".json" {
+			if current, err = s.importRecord(kind, tr); err != nil {
+				return err
+			}
+			if current.id != id {
+				return fmt.Errorf("entry %q contains a record for %q", hdr.Name, current.id)
+			}
+			continue
+		}
+		if kind != current.kind || id != current.id {
+			return fmt.Errorf("unexpected entry %q, not preceded by its record", hdr.Name)
+		}
+		if current.skipped {
+			continue
+		}
+		if suffix == ".tar" {
+			if err := s.importDiff(&current, tr); err != nil {
+				return err
+			}
+			continue
+		}
+		index, err := strconv.Atoi(suffix)
+		if err != nil || index < 0 || index >= len(current.bigData) {
+			return fmt.Errorf("unexpected entry %q", hdr.Name)
+		}
+		if err := s.importBigData(&current, current.bigData[index], tr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importRecord reads the record of an object of kind, and creates the object if it is an image or a container.
+// Layers are created when their diff is read.
+func (s *store) importRecord(kind string, r io.Reader) (importState, error) {
+	switch kind {
+	case "layers":
+		var layer Layer
+		if err := json.NewDecoder(r).Decode(&layer); err != nil {
+			return importState{}, fmt.Errorf("decoding layer record: %w", err)
+		}
+		existing, err := s.Layer(layer.ID)
+		skipped := err == nil && existing.ID == layer.ID
+		return importState{kind: kind, id: layer.ID, bigData: layer.BigDataNames, skipped: skipped, layer: &layer}, nil
+	case "images":
+		var image Image
+		if err := json.NewDecoder(r).Decode(&image); err != nil {
+			return importState{}, fmt.Errorf("decoding image record: %w", err)
+		}
+		state := importState{kind: kind, id: image.ID, bigData: image.BigDataNames, bigDataDigests: image.BigDataDigests}
+		if existing, err := s.Image(image.ID); err == nil && existing.ID == image.ID {
+			state.skipped = true
+			return state, nil
+		}
+		if _, err := s.CreateImage(image.ID, image.Names, image.TopLayer, image.Metadata, &ImageOptions{
+			CreationDate: image.Created,
+			Digest:       image.Digest,
+			NamesHistory: image.NamesHistory,
+			Flags:        image.Flags,
+		}); err != nil {
+			return importState{}, fmt.Errorf("creating image %q: %w", image.ID, err)
+		}
+		return state, nil
+	case "containers":
+		var container Container
+		if err := json.NewDecoder(r).Decode(&container); err != nil {
+			return importState{}, fmt.Errorf("decoding container record: %w", err)
+		}
+		state := importState{kind: kind, id: container.ID, bigData: container.BigDataNames}
+		if existing, err := s.Container(container.ID); err == nil && existing.ID == container.ID {
+			state.skipped = true
+			return state, nil
+		}
+		if _, err := s.CreateContainer(container.ID, container.Names, container.ImageID, container.LayerID, container.Metadata, &ContainerOptions{
+			IDMappingOptions: IDMappingOptions{
+				HostUIDMapping: len(container.UIDMap) == 0,
+				HostGIDMapping: len(container.GIDMap) == 0,
+				UIDMap:         container.UIDMap,
+				GIDMap:         container.GIDMap,
+			},
+			Flags: container.Flags,
+		}); err != nil {
+			return importState{}, fmt.Errorf("creating container %q: %w", container.ID, err)
+		}
+		state.container = &container
+		return state, nil
+	}
+	return importState{}, fmt.Errorf("internal error: unexpected kind %q", kind)
+}
+
+// importDiff reads the diff of the object described by state.
+func (s *store) importDiff(state *importState, r io.Reader) error {
+	switch {
+	case state.layer != nil:
+		if err := s.recreateLayer(state.layer, r); err != nil {
+			return err
+		}
+		state.layer = nil
+	case state.container != nil:
+		if _, err := s.ApplyDiff(state.container.LayerID, r); err != nil {
+			return fmt.Errorf("applying diff to the layer of container %q: %w", state.container.ID, err)
+		}
+	default:
+		return fmt.Errorf("unexpected diff for %s %q", state.kind, state.id)
+	}
+	return nil
+}
+
+// recreateLayer creates a layer with the ID, names, ID mappings, digests and metadata of layer,
+// and the contents of diff, an uncompressed layer diff.
+func (s *store) recreateLayer(layer *Layer, diff io.Reader) error {
+	options := LayerOptions{
+		IDMappingOptions: LayerIDMappingOptions{
+			HostUIDMapping: len(layer.UIDMap) == 0,
+			HostGIDMapping: len(layer.GIDMap) == 0,
+			UIDMap:         layer.UIDMap,
+			GIDMap:         layer.GIDMap,
+		},
+		Flags: layer.Flags,
+	}
+	// The diff is not compressed, so PutLayer would use OriginalDigest as the uncompressed digest
+	// unless it is provided; verify it ourselves instead.
+	digester := digest.Canonical.Digester()
+	if layer.UncompressedDigest != "" {
+		options.UncompressedDigest = layer.UncompressedDigest
+		if layer.CompressedDigest != "" {
+			options.OriginalDigest = layer.CompressedDigest
+			options.OriginalSize = &layer.CompressedSize
+		}
+	}
+	tee := io.TeeReader(diff, digester.Hash())
+	if _, _, err := s.PutLayer(layer.ID, layer.Parent, layer.Names, layer.MountLabel, false, &options, tee); err != nil {
+		return fmt.Errorf("creating layer %q: %w", layer.ID, err)
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+	if layer.UncompressedDigest != "" && digester.Digest() != layer.UncompressedDigest {
+		err := fmt.Errorf("diff of layer %q has digest %q, expected %q", layer.ID, digester.Digest(), layer.UncompressedDigest)
+		if err2 := s.DeleteLayer(layer.ID); err2 != nil {
+			err = errors.Join(err, err2)
+		}
+		return err
+	}
+	// PutLayer records the digests of the diff it was given; restore the ones the layer was originally
+	// pulled with, including its TOC digest if it was identified by one.
+	if _, err := writeToLayerStore(s, func(rlstore rwLayerStore) (struct{}, error) {
+		return struct{}{}, rlstore.restoreDigests(layer.ID, layer)
+	}); err != nil {
+		return fmt.Errorf("restoring digests of layer %q: %w", layer.ID, err)
+	}
+	if layer.Metadata != "" {
+		if err := s.SetMetadata(layer.ID, layer.Metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importBigData reads the data item key of the object described by state.
+func (s *store) importBigData(state *importState, key string, r io.Reader) error {
+	switch state.kind {
+	case "layers":
+		return s.SetLayerBigData(state.id, key, r)
+	case "images":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		expected, ok := state.bigDataDigests[key]
+		if ok {
+			if err := expected.Validate(); err != nil {
+				return fmt.Errorf("data item %q of image %q: %w", key, state.id, err)
+			}
+			if actual := expected.Algorithm().FromBytes(data); actual != expected {
+				return fmt.Errorf("data item %q of image %q has digest %q, expected %q", key, state.id, actual, expected)
+			}
+		}
+		return s.SetImageBigData(state.id, key, data, func([]byte) (digest.Digest, error) {
+			return expected, nil
+		})
+	case "containers":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return s.SetContainerBigData(state.id, key, data)
+	}
+	return fmt.Errorf("internal error: unexpected kind %q", state.kind)
+}
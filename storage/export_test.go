@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+// generateTestDiff returns a gzip-compressed layer diff containing the files in input, as accepted by archive.Generate.
+func generateTestDiff(t *testing.T, input ...string) []byte {
+	r, err := archive.Generate(input...)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err = io.Copy(zw, r)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// shutdownTestStore shuts down s when the test completes.
+func shutdownTestStore(t *testing.T, s Store) {
+	t.Cleanup(func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	reexec.Init()
+
+	src := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, src)
+
+	_, _, err := src.PutLayer("Base", "", []string{"base"}, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "src\n")))
+	require.NoError(t, err)
+	_, _, err = src.PutLayer("Top", "Base", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "bin/true", "#!/bin/sh\n")))
+	require.NoError(t, err)
+	require.NoError(t, src.SetLayerBigData("Top", "layer-data", bytes.NewReader([]byte("layer data"))))
+	manifest := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(manifest)
+	_, err = src.CreateImage("Image", []string{"example.com/image:latest"}, "Top", "image metadata", &ImageOptions{
+		BigData: []ImageBigDataOption{
+			{Key: ImageDigestBigDataKey, Data: manifest, Digest: manifestDigest},
+			{Key: "config", Data: []byte(`{"config":{}}`)},
+		},
+	})
+	require.NoError(t, err)
+	_, err = src.CreateImage("Other", nil, "Base", "", nil)
+	require.NoError(t, err)
+	_, err = src.CreateContainer("Container", []string{"container"}, "Image", "", "container metadata", nil)
+	require.NoError(t, err)
+	container, err := src.Container("Container")
+	require.NoError(t, err)
+	_, err = src.ApplyDiff(container.LayerID, bytes.NewReader(generateTestDiff(t, "run/state", "running\n")))
+	require.NoError(t, err)
+	require.NoError(t, src.SetContainerBigData("Container", "container-data", []byte("container data")))
+
+	var archiveData bytes.Buffer
+	require.NoError(t, src.Export(&archiveData, &ExportFilter{Images: []string{"example.com/image:latest"}, Containers: true}))
+	// No temporary files are left behind.
+	tempFiles, err := filepath.Glob(filepath.Join(src.GraphRoot(), "export-*"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+	tempFiles, err = filepath.Glob(filepath.Join(src.GraphRoot(), src.GraphDriverName()+"-layers", tempDirPath, "*", "*"))
+	require.NoError(t, err)
+	assert.Empty(t, tempFiles)
+
+	dest := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, dest)
+	require.NoError(t, dest.Import(bytes.NewReader(archiveData.Bytes())))
+
+	for _, id := range []string{"Base", "Top"} {
+		srcLayer, err := src.Layer(id)
+		require.NoError(t, err)
+		destLayer, err := dest.Layer(id)
+		require.NoError(t, err)
+		assert.Equal(t, srcLayer.Names, destLayer.Names)
+		assert.Equal(t, srcLayer.Parent, destLayer.Parent)
+		assert.Equal(t, srcLayer.UncompressedDigest, destLayer.UncompressedDigest)
+		assert.Equal(t, srcLayer.CompressedDigest, destLayer.CompressedDigest)
+		assert.NotEqual(t, destLayer.CompressedDigest, destLayer.UncompressedDigest)
+	}
+	rc, err := dest.LayerBigData("Top", "layer-data")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("layer data"), data)
+
+	image, err := dest.Image("example.com/image:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "Image", image.ID)
+	assert.Equal(t, "image metadata", image.Metadata)
+	assert.Contains(t, image.Digests, manifestDigest)
+	data, err = dest.ImageBigData("Image", "config")
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"config":{}}`), data)
+	assert.False(t, dest.Exists("Other"), "image not selected by the filter")
+
+	destContainer, err := dest.Container("container")
+	require.NoError(t, err)
+	assert.Equal(t, "Container", destContainer.ID)
+	assert.Equal(t, container.LayerID, destContainer.LayerID)
+	assert.Equal(t, "Image", destContainer.ImageID)
+	assert.Equal(t, "container metadata", destContainer.Metadata)
+	data, err = dest.ContainerBigData("Container", "container-data")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("container data"), data)
+	changes, err := dest.Changes("", destContainer.LayerID)
+	require.NoError(t, err)
+	paths := []string{}
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+	assert.Contains(t, paths, "/run/state")
+
+	// Importing layers, images and containers again is a no-op.
+	archiveData.Reset()
+	require.NoError(t, src.Export(&archiveData, &ExportFilter{Containers: true}))
+	require.NoError(t, dest.Import(bytes.NewReader(archiveData.Bytes())))
+	assert.True(t, dest.Exists("Other"))
+}
+
+func TestExportImportTOCLayer(t *testing.T) {
+	reexec.Init()
+
+	src := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, src)
+
+	_, _, err := src.PutLayer("TOC", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "toc\n")))
+	require.NoError(t, err)
+	// A layer pulled partially is identified by its TOC digest, and its uncompressed digest is not known.
+	pulled := Layer{
+		CompressedDigest: digest.FromString("compressed"),
+		CompressedSize:   1234,
+		UncompressedSize: -1,
+		TOCDigest:        digest.FromString("toc"),
+		CompressionType:  archive.Zstd,
+	}
+	_, err = writeToLayerStore(src.(*store), func(rlstore rwLayerStore) (struct{}, error) {
+		return struct{}{}, rlstore.restoreDigests("TOC", &pulled)
+	})
+	require.NoError(t, err)
+	_, err = src.CreateImage("Image", nil, "TOC", "", nil)
+	require.NoError(t, err)
+
+	var archiveData bytes.Buffer
+	require.NoError(t, src.Export(&archiveData, nil))
+	dest := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, dest)
+	require.NoError(t, dest.Import(bytes.NewReader(archiveData.Bytes())))
+
+	layer, err := dest.Layer("TOC")
+	require.NoError(t, err)
+	assert.Equal(t, pulled.TOCDigest, layer.TOCDigest)
+	assert.Equal(t, pulled.CompressedDigest, layer.CompressedDigest)
+	assert.Equal(t, pulled.CompressedSize, layer.CompressedSize)
+	assert.Equal(t, digest.Digest(""), layer.UncompressedDigest)
+	assert.Equal(t, pulled.UncompressedSize, layer.UncompressedSize)
+	assert.Equal(t, pulled.CompressionType, layer.CompressionType)
+	layers, err := dest.LayersByTOCDigest(pulled.TOCDigest)
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	assert.Equal(t, "TOC", layers[0].ID)
+}
+
+func TestImportInvalid(t *testing.T) {
+	reexec.Init()
+
+	s := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, s)
+
+	err := s.Import(bytes.NewReader(nil))
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	ew := &exportWriter{tw: tar.NewWriter(&buf)}
+	require.NoError(t, ew.writeJSON(exportArchiveHeader, exportHeader{Version: exportArchiveVersion + 1}))
+	require.NoError(t, ew.tw.Close())
+	err = s.Import(bytes.NewReader(buf.Bytes()))
+	assert.ErrorContains(t, err, "unsupported archive version")
+
+	buf.Reset()
+	ew = &exportWriter{tw: tar.NewWriter(&buf)}
+	require.NoError(t, ew.writeJSON(exportArchiveHeader, exportHeader{Version: exportArchiveVersion}))
+	require.NoError(t, ew.writeFile("layers/Layer/0", []byte("data")))
+	require.NoError(t, ew.tw.Close())
+	err = s.Import(bytes.NewReader(buf.Bytes()))
+	assert.ErrorContains(t, err, "not preceded by its record")
+
+	buf.Reset()
+	ew = &exportWriter{tw: tar.NewWriter(&buf)}
+	require.NoError(t, ew.writeJSON(exportArchiveHeader, exportHeader{Version: exportArchiveVersion}))
+	require.NoError(t, ew.writeJSON("images/Image.json", Image{
+		ID:             "Image",
+		BigDataNames:   []string{"config"},
+		BigDataDigests: map[string]digest.Digest{"config": digest.FromString("other data")},
+	}))
+	require.NoError(t, ew.writeFile("images/Image/0", []byte("data")))
+	require.NoError(t, ew.tw.Close())
+	err = s.Import(bytes.NewReader(buf.Bytes()))
+	assert.ErrorContains(t, err, `data item "config" of image "Image" has digest`)
+}
@@ -14,6 +14,7 @@ require (
 	github.com/klauspost/compress v1.19.2
 	github.com/klauspost/pgzip v1.2.6
 	github.com/mattn/go-shellwords v1.0.14
+	github.com/mattn/go-sqlite3 v1.14.50
 	github.com/mistifyio/go-zfs/v4 v4.0.0
 	github.com/moby/sys/capability v0.4.0
 	github.com/moby/sys/mountinfo v0.7.2
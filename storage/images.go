@@ -27,8 +27,16 @@ const (
 	// ImageDigestBigDataKey is provided for compatibility with older
 	// versions of the image library.  It will be removed in the future.
 	ImageDigestBigDataKey = "manifest"
+	// ImagePinnedFlag is the name of an image flag which, if set to true,
+	// prevents Store.PruneImages from removing the image.  It can be set
+	// using ImageOptions.Flags or Store.SetImagePinned.
+	ImagePinnedFlag = "Pinned"
 )
 
+// imageLastUsedResolution is the resolution of Image.LastUsed; using an image
+// more frequently does not cause the store to be written again.
+const imageLastUsedResolution = time.Minute
+
 // An Image is a reference to a layer and an associated metadata string.
 type Image struct {
 	// ID is either one which was specified at create-time, or a random
@@ -90,6 +98,12 @@ type Image struct {
 	// is set before using it.
 	Created time.Time `json:"created"`
 
+	// LastUsed is the datestamp for when this image was last added to the
+	// store, mounted, or used to create a container, at a resolution of
+	// imageLastUsedResolution.  Older versions of the library did not track
+	// this information.
+	LastUsed time.Time `json:"last-used"`
+
 	// ReadOnly is true if this image resides in a read-only layer store.
 	ReadOnly bool `json:"-"`
 
@@ -154,6 +168,9 @@ type rwImageStore interface {
 	addMappedTopLayer(id, layer string) error
 	removeMappedTopLayer(id, layer string) error
 
+	// recordUse updates the LastUsed time of an image.
+	recordUse(id string) error
+
 	// Clean up unreferenced per-image data.
 	GarbageCollect() error
 
@@ -166,6 +183,7 @@ type imageStore struct {
 	// They are safe to access without any other locking.
 	lockfile *lockfile.LockFile // lockfile.IsReadWrite can be used to distinguish between read-write and read-only image stores.
 	dir      string
+	backend  metadataBackend
 
 	inProcessLock sync.RWMutex // Can _only_ be obtained with lockfile held.
 	// The following fields can only be read/written with read/write ownership of inProcessLock, respectively.
@@ -192,6 +210,7 @@ func copyImage(i *Image) *Image {
 		BigDataSizes:    copyMapPreferringNil(i.BigDataSizes),
 		BigDataDigests:  copyMapPreferringNil(i.BigDataDigests),
 		Created:         i.Created,
+		LastUsed:        i.LastUsed,
 		ReadOnly:        i.ReadOnly,
 		Flags:           copyMapPreferringNil(i.Flags),
 	}
@@ -501,17 +520,9 @@ func (i *Image) recomputeDigests() error {
 // If !lockedForWriting and this function fails, the return value indicates whether
 // retrying with lockedForWriting could succeed.
 func (r *imageStore) load(lockedForWriting bool) (bool, error) {
-	rpath := r.imagespath()
-	data, err := os.ReadFile(rpath)
-	if err != nil && !os.IsNotExist(err) {
-		return false, err
-	}
-
 	images := []*Image{}
-	if len(data) != 0 {
-		if err := json.Unmarshal(data, &images); err != nil {
-			return false, fmt.Errorf("loading %q: %w", rpath, err)
-		}
+	if err := r.backend.read(r.imagespath(), &images); err != nil {
+		return false, err
 	}
 	idlist := make([]string, 0, len(images))
 	ids := make(map[string]*Image)
@@ -564,18 +575,32 @@ func (r *imageStore) load(lockedForWriting bool) (bool, error) {
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for reading (but usually holds it for writing in order to make the desired changes).
 func (r *imageStore) Save() error {
+	return r.save(nil)
+}
+
+// saveFor saves the contents of the store relevant for modifiedImages to disk.
+// modifiedImages must include all images which were added, modified or removed
+// since the store was last loaded or saved.
+// The caller must hold r.lockfile locked for writing.
+// The caller must hold r.inProcessLock for reading (but usually holds it for writing in order to make the desired changes).
+func (r *imageStore) saveFor(modifiedImages ...*Image) error {
+	modifiedIDs := make([]string, 0, len(modifiedImages))
+	for _, image := range modifiedImages {
+		modifiedIDs = append(modifiedIDs, image.ID)
+	}
+	return r.save(modifiedIDs)
+}
+
+// save saves the contents of the store to disk.
+// If modifiedIDs is not nil, it contains the IDs of all images which were added,
+// modified or removed since the store was last loaded or saved.
+// The caller must hold r.lockfile locked for writing.
+// The caller must hold r.inProcessLock for reading (but usually holds it for writing in order to make the desired changes).
+func (r *imageStore) save(modifiedIDs []string) error {
 	if !r.lockfile.IsReadWrite() {
 		return fmt.Errorf("not allowed to modify the image store at %q: %w", r.imagespath(), ErrStoreIsReadOnly)
 	}
 	r.lockfile.AssertLockedForWriting()
-	rpath := r.imagespath()
-	if err := os.MkdirAll(filepath.Dir(rpath), 0o700); err != nil {
-		return err
-	}
-	jdata, err := json.Marshal(&r.images)
-	if err != nil {
-		return err
-	}
 	// This must be done before we write the file, because the process could be terminated
 	// after the file is written but before the lock file is updated.
 	lw, err := r.lockfile.RecordWrite()
@@ -583,13 +608,13 @@ func (r *imageStore) Save() error {
 		return err
 	}
 	r.lastWrite = lw
-	if err := ioutils.AtomicWriteFile(rpath, jdata, 0o600); err != nil {
+	if _, err := r.backend.write(r.imagespath(), r.images, modifiedIDs, false); err != nil {
 		return err
 	}
 	return nil
 }
 
-func newImageStore(dir string) (rwImageStore, error) {
+func newImageStore(dir string, backend metadataBackend) (rwImageStore, error) {
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return nil, err
 	}
@@ -600,6 +625,7 @@ func newImageStore(dir string) (rwImageStore, error) {
 	istore := imageStore{
 		lockfile: lockfile,
 		dir:      dir,
+		backend:  backend,
 
 		images:   []*Image{},
 		byid:     make(map[string]*Image),
@@ -617,10 +643,20 @@ func newImageStore(dir string) (rwImageStore, error) {
 	if _, err := istore.load(true); err != nil {
 		return nil, err
 	}
+	// Move records to the configured backend, if necessary.
+	needsMigration, err := backend.needsMigration(istore.imagespath())
+	if err != nil {
+		return nil, err
+	}
+	if needsMigration {
+		if err := istore.Save(); err != nil {
+			return nil, err
+		}
+	}
 	return &istore, nil
 }
 
-func newROImageStore(dir string) (roImageStore, error) {
+func newROImageStore(dir string, backend metadataBackend) (roImageStore, error) {
 	lockfile, err := lockfile.GetROLockFile(filepath.Join(dir, "images.lock"))
 	if err != nil {
 		return nil, err
@@ -628,6 +664,7 @@ func newROImageStore(dir string) (roImageStore, error) {
 	istore := imageStore{
 		lockfile: lockfile,
 		dir:      dir,
+		backend:  backend,
 
 		images:   []*Image{},
 		byid:     make(map[string]*Image),
@@ -652,7 +689,7 @@ func newROImageStore(dir string) (roImageStore, error) {
 func (r *imageStore) lookup(id string) (*Image, bool) {
 	if image, ok := r.byid[id]; ok {
 		return image, ok
-	} else if image, ok := r.byname[id]; ok {
+	} else if image, ok := r.lookupName(id); ok {
 		return image, ok
 	} else if longid, err := r.idindex.Get(id); err == nil {
 		image, ok := r.byid[longid]
@@ -661,6 +698,39 @@ func (r *imageStore) lookup(id string) (*Image, bool) {
 	return nil, false
 }
 
+// lookupIndexed returns the images which have key of kind, and true, if the records are indexed by the metadata backend.
+// Requires startReading or startWriting.
+func (r *imageStore) lookupIndexed(kind metadataKeyKind, key string) ([]*Image, bool, error) {
+	ids, indexed, err := lookupMetadata(r.imagespath(), kind, key)
+	if err != nil || !indexed {
+		return nil, false, err
+	}
+	var images []*Image
+	for _, id := range ids {
+		if image, ok := r.byid[id]; ok {
+			images = append(images, image)
+		}
+	}
+	return images, true, nil
+}
+
+// lookupName returns the image with name, using the index of the metadata backend if possible.
+// Requires startReading or startWriting.
+func (r *imageStore) lookupName(name string) (*Image, bool) {
+	images, indexed, err := r.lookupIndexed(metadataKeyName, name)
+	if err != nil {
+		logrus.Debugf("Looking up image name %q: %v", name, err)
+	} else if indexed {
+		if len(images) == 0 {
+			return nil, false
+		}
+		// Consistent with load(), if the name is not unique, the last image wins.
+		return images[len(images)-1], true
+	}
+	image, ok := r.byname[name]
+	return image, ok
+}
+
 // Requires startWriting.
 func (r *imageStore) ClearFlag(id string, flag string) error {
 	if !r.lockfile.IsReadWrite() {
@@ -671,7 +741,7 @@ func (r *imageStore) ClearFlag(id string, flag string) error {
 		return fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
 	}
 	delete(image.Flags, flag)
-	return r.Save()
+	return r.saveFor(image)
 }
 
 // Requires startWriting.
@@ -687,7 +757,7 @@ func (r *imageStore) SetFlag(id string, flag string, value any) error {
 		image.Flags = make(map[string]any)
 	}
 	image.Flags[flag] = value
-	return r.Save()
+	return r.saveFor(image)
 }
 
 // Requires startWriting.
@@ -724,6 +794,7 @@ func (r *imageStore) create(id string, names []string, layer string, options Ima
 		BigDataSizes:   make(map[string]int64),
 		BigDataDigests: make(map[string]digest.Digest),
 		Created:        options.CreationDate,
+		LastUsed:       time.Now().UTC(),
 		Flags:          newMapFrom(options.Flags),
 	}
 	if image.Created.IsZero() {
@@ -757,7 +828,7 @@ func (r *imageStore) create(id string, names []string, layer string, options Ima
 			}
 		}
 	}()
-	err = r.Save()
+	err = r.saveFor(image)
 	if err != nil {
 		return nil, err
 	}
@@ -777,7 +848,7 @@ func (r *imageStore) create(id string, names []string, layer string, options Ima
 func (r *imageStore) addMappedTopLayer(id, layer string) error {
 	if image, ok := r.lookup(id); ok {
 		image.MappedTopLayers = append(image.MappedTopLayers, layer)
-		return r.Save()
+		return r.saveFor(image)
 	}
 	return fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
 }
@@ -791,11 +862,28 @@ func (r *imageStore) removeMappedTopLayer(id, layer string) error {
 		if initialLen == len(image.MappedTopLayers) {
 			return nil
 		}
-		return r.Save()
+		return r.saveFor(image)
 	}
 	return fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
 }
 
+// Requires startWriting.
+func (r *imageStore) recordUse(id string) error {
+	if !r.lockfile.IsReadWrite() {
+		return fmt.Errorf("not allowed to modify image last-use times at %q: %w", r.imagespath(), ErrStoreIsReadOnly)
+	}
+	image, ok := r.lookup(id)
+	if !ok {
+		return fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
+	}
+	now := time.Now().UTC()
+	if now.Sub(image.LastUsed) < imageLastUsedResolution {
+		return nil
+	}
+	image.LastUsed = now
+	return r.saveFor(image)
+}
+
 // Requires startReading or startWriting.
 func (r *imageStore) Metadata(id string) (string, error) {
 	if image, ok := r.lookup(id); ok {
@@ -811,7 +899,7 @@ func (r *imageStore) SetMetadata(id, metadata string) error {
 	}
 	if image, ok := r.lookup(id); ok {
 		image.Metadata = metadata
-		return r.Save()
+		return r.saveFor(image)
 	}
 	return fmt.Errorf("locating image with ID %q: %w", id, ErrImageUnknown)
 }
@@ -843,15 +931,17 @@ func (r *imageStore) updateNames(id string, names []string, op updateNameOperati
 	for _, name := range oldNames {
 		delete(r.byname, name)
 	}
+	modifiedImages := []*Image{image}
 	for _, name := range names {
 		if otherImage, ok := r.byname[name]; ok {
 			r.removeName(otherImage, name)
+			modifiedImages = append(modifiedImages, otherImage)
 		}
 		r.byname[name] = image
 		image.addNameToHistory(name)
 	}
 	image.Names = names
-	return r.Save()
+	return r.saveFor(modifiedImages...)
 }
 
 // Requires startWriting.
@@ -884,7 +974,7 @@ func (r *imageStore) Delete(id string) error {
 	r.images = slices.DeleteFunc(r.images, func(candidate *Image) bool {
 		return candidate.ID == id
 	})
-	if err := r.Save(); err != nil {
+	if err := r.saveFor(image); err != nil {
 		return err
 	}
 	if err := os.RemoveAll(r.datadir(id)); err != nil {
@@ -909,7 +999,14 @@ func (r *imageStore) Exists(id string) bool {
 
 // Requires startReading or startWriting.
 func (r *imageStore) ByDigest(d digest.Digest) ([]*Image, error) {
-	if images, ok := r.bydigest[d]; ok {
+	images, indexed, err := r.lookupIndexed(metadataKeyDigest, d.String())
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		images = r.bydigest[d]
+	}
+	if len(images) != 0 {
 		return copyImageSlice(images), nil
 	}
 	return nil, fmt.Errorf("locating image with digest %q: %w", d, ErrImageUnknown)
@@ -1047,7 +1144,7 @@ func (r *imageStore) setBigData(image *Image, key string, data []byte, newDigest
 			}
 		}
 		if save {
-			err = r.Save()
+			err = r.saveFor(image)
 		}
 	}
 	return err
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/internal/staging_lockfile"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/lockfile"
+	"go.podman.io/storage/pkg/stringid"
+	"go.podman.io/storage/pkg/system"
+)
+
+// storeIntent describes an operation which modifies more than one of the layer, image and container stores.
+//
+// All such operations can be made consistent after an interruption by removing the objects they affect:
+// operations which create objects are rolled back by removing them, and operations which delete objects
+// are completed by removing whatever is left of them.
+// So, an intent only lists the objects to remove.
+type storeIntent struct {
+	// ID identifies the intent in the journal.
+	ID string `json:"id"`
+	// Operation is a human-readable description of the operation, used in log messages.
+	Operation string `json:"operation"`
+	// Time is the time when the operation started.
+	Time time.Time `json:"time"`
+	// Containers are IDs of containers to remove. Containers which use any of Layers are removed as well.
+	Containers []string `json:"containers,omitempty"`
+	// Images are IDs of images to remove.
+	Images []string `json:"images,omitempty"`
+	// Layers are IDs of layers to remove, in order, i.e. child layers before their parents.
+	// References to them in MappedTopLayers of images are removed as well.
+	Layers []string `json:"layers,omitempty"`
+	// LockFile, if set, is the name of a lock file in the journal’s transactions directory, held by the process
+	// running a Transaction; the intent is only recovered if the lock is not held.
+	LockFile string `json:"lockFile,omitempty"`
+}
+
+// intentJournal is a write-ahead log of operations which modify more than one of the layer, image and container
+// stores, so that each of them is either fully done, or not done at all, even if the process crashes.
+//
+// An operation records an intent before making any changes, and removes it after all changes have been made
+// (or undone after a failure). Intents left in the journal are handled by store.recoverIntents when the store
+// is next opened.
+//
+// Single store operations which record intents hold the primary layer store locked for writing for their whole
+// duration; a Transaction spans several operations, and instead holds a lock file recorded in its intent.
+// Modifications of the journal are serialized by a separate lock.
+type intentJournal struct {
+	path            string
+	transactionsDir string
+	lock            *lockfile.LockFile
+}
+
+// newIntentJournal returns an intentJournal stored in dir.
+func newIntentJournal(dir string) (*intentJournal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, err := lockfile.GetLockFile(filepath.Join(dir, "intents.lock"))
+	if err != nil {
+		return nil, err
+	}
+	return &intentJournal{
+		path:            filepath.Join(dir, "intents.json"),
+		transactionsDir: filepath.Join(dir, "transactions"),
+		lock:            lock,
+	}, nil
+}
+
+// load returns the intents recorded in the journal.
+func (j *intentJournal) load() ([]storeIntent, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	intents := []storeIntent{}
+	if err := json.Unmarshal(data, &intents); err != nil {
+		return nil, fmt.Errorf("parsing intent journal %q: %w", j.path, err)
+	}
+	return intents, nil
+}
+
+// save replaces the contents of the journal with intents.
+func (j *intentJournal) save(intents []storeIntent) error {
+	if len(intents) == 0 {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(intents)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(j.path, data, 0o600)
+}
+
+// begin records intent in the journal, and returns its ID.
+func (j *intentJournal) begin(intent storeIntent) (string, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	intents, err := j.load()
+	if err != nil {
+		return "", err
+	}
+	intent.ID = stringid.GenerateRandomID()
+	intent.Time = time.Now().UTC()
+	if err := j.save(append(intents, intent)); err != nil {
+		return "", fmt.Errorf("recording intent to %s: %w", intent.Operation, err)
+	}
+	return intent.ID, nil
+}
+
+// end removes the intent with the specified ID from the journal.
+func (j *intentJournal) end(id string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	intents, err := j.load()
+	if err != nil {
+		return err
+	}
+	return j.save(slices.DeleteFunc(intents, func(intent storeIntent) bool {
+		return intent.ID == id
+	}))
+}
+
+// update modifies the intent with the specified ID in the journal using fn.
+func (j *intentJournal) update(id string, fn func(intent *storeIntent)) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	intents, err := j.load()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(intents, func(intent storeIntent) bool {
+		return intent.ID == id
+	})
+	if i == -1 {
+		return fmt.Errorf("intent %q not found in the journal", id)
+	}
+	fn(&intents[i])
+	return j.save(intents)
+}
+
+// get returns the intent with the specified ID.
+func (j *intentJournal) get(id string) (storeIntent, error) {
+	intents, err := j.load()
+	if err != nil {
+		return storeIntent{}, err
+	}
+	i := slices.IndexFunc(intents, func(intent storeIntent) bool {
+		return intent.ID == id
+	})
+	if i == -1 {
+		return storeIntent{}, fmt.Errorf("intent %q not found in the journal", id)
+	}
+	return intents[i], nil
+}
+
+// withIntent records intent, runs fn which makes the changes it describes, and removes the intent.
+// If fn fails, it must undo its changes before returning, as far as possible.
+// If the intent can not be removed, the operation fails, and it is rolled back or completed when the store
+// is next opened.
+// On entry:
+// - the primary layer store must be locked for writing
+func (s *store) withIntent(intent storeIntent, fn func() error) error {
+	id, err := s.intents.begin(intent)
+	if err != nil {
+		return err
+	}
+	fnErr := fn()
+	if err := s.intents.end(id); err != nil {
+		if fnErr != nil {
+			logrus.Warnf("Removing intent to %s: %v", intent.Operation, err)
+			return fnErr
+		}
+		return fmt.Errorf("removing intent to %s: %w", intent.Operation, err)
+	}
+	return fnErr
+}
+
+// recoverIntents rolls back or completes operations which were interrupted before removing their intents.
+func (s *store) recoverIntents() error {
+	// Avoid locking all of the stores in the usual case, when there is nothing to do.
+	// The journal is replaced atomically, so reading it without holding the lock is safe.
+	intents, err := s.intents.load()
+	if err != nil || len(intents) == 0 {
+		return err
+	}
+	return s.writeToAllStores(func(rlstore rwLayerStore) error {
+		intents, err := s.intents.load()
+		if err != nil {
+			return err
+		}
+		for _, intent := range intents {
+			var transactionLock *staging_lockfile.StagingLockFile
+			if intent.LockFile != "" {
+				transactionLock, err = staging_lockfile.TryLockPath(filepath.Join(s.intents.transactionsDir, intent.LockFile))
+				if err != nil {
+					// The transaction is still running.
+					continue
+				}
+			}
+			logrus.Infof("Recovering from an interrupted operation to %s started at %s", intent.Operation, intent.Time)
+			err := s.recoverIntent(rlstore, intent)
+			if err == nil {
+				err = s.intents.end(intent.ID)
+			}
+			if transactionLock != nil {
+				if err2 := transactionLock.UnlockAndDelete(); err2 != nil && err == nil {
+					err = err2
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("recovering from an interrupted operation to %s: %w", intent.Operation, err)
+			}
+		}
+		return nil
+	})
+}
+
+// recoverIntent removes objects listed in intent, if they still exist.
+// It is safe to call recoverIntent on an intent which was already (partially) recovered.
+// On entry:
+// - rlstore, s.imageStore and s.containerStore must be locked for writing
+func (s *store) recoverIntent(rlstore rwLayerStore, intent storeIntent) error {
+	events := []Event{}
+	defer func() { s.recordEvents(events...) }()
+
+	containers, err := s.containerStore.Containers()
+	if err != nil {
+		return err
+	}
+	middleDir := s.graphDriverName + "-containers"
+	layers := slices.Clone(intent.Layers)
+	for _, container := range containers {
+		if !slices.Contains(intent.Containers, container.ID) && !slices.Contains(intent.Layers, container.LayerID) {
+			continue
+		}
+		// The layer of a container is not used by anything else; it is a child of the image layers, so remove it first.
+		if !slices.Contains(layers, container.LayerID) {
+			layers = slices.Insert(layers, 0, container.LayerID)
+		}
+		if err := s.containerStore.Delete(container.ID); err != nil {
+			return err
+		}
+		events = append(events, Event{Type: EventDeleted, ObjectType: EventObjectContainer, ID: container.ID})
+		for _, dir := range []string{filepath.Join(s.GraphRoot(), middleDir, container.ID), filepath.Join(s.RunRoot(), middleDir, container.ID)} {
+			if err := system.EnsureRemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, is := range s.rwImageStores {
+		if is != s.imageStore {
+			if err := is.startWriting(); err != nil {
+				return err
+			}
+			defer is.stopWriting()
+		}
+		for _, id := range intent.Images {
+			if image, err := is.Get(id); err != nil || image.ID != id {
+				continue
+			}
+			if err := is.Delete(id); err != nil {
+				return err
+			}
+			events = append(events, Event{Type: EventDeleted, ObjectType: EventObjectImage, ID: id})
+		}
+	}
+
+	images, err := s.imageStore.Images()
+	if err != nil {
+		return err
+	}
+	for _, id := range layers {
+		for _, image := range images {
+			if slices.Contains(image.MappedTopLayers, id) {
+				if err := s.imageStore.removeMappedTopLayer(image.ID, id); err != nil {
+					return err
+				}
+			}
+		}
+		if layer, err := rlstore.Get(id); err != nil || layer.ID != id {
+			continue
+		}
+		if user, err := s.layerUser(rlstore, images, id); err != nil {
+			return err
+		} else if user != "" {
+			// Operations only record layers which are not used by anything else, but while a Transaction is running,
+			// other processes may start using the layers it has created.
+			logrus.Warnf("Not removing layer %q which is used by %s", id, user)
+			continue
+		}
+		if err := rlstore.deleteWhileHoldingLock(id); err != nil {
+			return err
+		}
+		events = append(events, Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: id})
+	}
+	return nil
+}
+
+// layerUser returns a description of an object which uses the layer with the specified ID,
+// or "" if the layer is not used.
+// On entry:
+// - rlstore and s.containerStore must be locked for reading or writing
+func (s *store) layerUser(rlstore rwLayerStore, images []Image, id string) (string, error) {
+	for _, image := range images {
+		if image.TopLayer == id {
+			return fmt.Sprintf("image %q", image.ID), nil
+		}
+	}
+	containers, err := s.containerStore.Containers()
+	if err != nil {
+		return "", err
+	}
+	for _, container := range containers {
+		if container.LayerID == id {
+			return fmt.Sprintf("container %q", container.ID), nil
+		}
+	}
+	layers, err := rlstore.Layers()
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range layers {
+		if layer.Parent == id {
+			return fmt.Sprintf("layer %q", layer.ID), nil
+		}
+	}
+	return "", nil
+}
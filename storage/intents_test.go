@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+func TestIntentJournal(t *testing.T) {
+	j, err := newIntentJournal(t.TempDir())
+	require.NoError(t, err)
+	intents, err := j.load()
+	require.NoError(t, err)
+	assert.Empty(t, intents)
+
+	id1, err := j.begin(storeIntent{Operation: "first", Layers: []string{"l1"}})
+	require.NoError(t, err)
+	id2, err := j.begin(storeIntent{Operation: "second", Images: []string{"i2"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+	intents, err = j.load()
+	require.NoError(t, err)
+	require.Len(t, intents, 2)
+	assert.Equal(t, id1, intents[0].ID)
+	assert.Equal(t, []string{"l1"}, intents[0].Layers)
+	assert.False(t, intents[0].Time.IsZero())
+
+	require.NoError(t, j.end(id1))
+	intents, err = j.load()
+	require.NoError(t, err)
+	require.Len(t, intents, 1)
+	assert.Equal(t, id2, intents[0].ID)
+
+	require.NoError(t, j.end(id2))
+	assert.NoFileExists(t, j.path)
+}
+
+// storeIntents returns the intents recorded in the journal of s.
+func storeIntents(t *testing.T, s Store) []storeIntent {
+	intents, err := s.(*store).intents.load()
+	require.NoError(t, err)
+	return intents
+}
+
+// recordIntent adds intent to the journal of s, as if an operation was interrupted.
+func recordIntent(t *testing.T, s Store, intent storeIntent) {
+	_, err := s.(*store).intents.begin(intent)
+	require.NoError(t, err)
+}
+
+func TestStoreRecoverIntents(t *testing.T) {
+	reexec.Init()
+
+	options := StoreOptions{
+		GraphRoot: filepath.Join(t.TempDir(), "root"),
+		RunRoot:   filepath.Join(t.TempDir(), "run"),
+	}
+	store := newTestStore(t, options)
+
+	// Objects which are not affected by the recovery
+	_, err := store.CreateLayer("Base", "", nil, "", false, nil)
+	require.NoError(t, err)
+	_, err = store.CreateImage("Image", nil, "Base", "", nil)
+	require.NoError(t, err)
+	_, err = store.CreateContainer("Container", nil, "Image", "", "", nil)
+	require.NoError(t, err)
+	// Successful operations don't leave intents behind
+	assert.Empty(t, storeIntents(t, store))
+
+	// Simulate an interrupted container creation, and an interrupted image deletion.
+	_, err = store.CreateLayer("Partial", "Base", nil, "", true, nil)
+	require.NoError(t, err)
+	_, err = store.CreateLayer("Deleted", "", nil, "", false, nil)
+	require.NoError(t, err)
+	_, err = store.CreateImage("DeletedImage", nil, "Deleted", "", nil)
+	require.NoError(t, err)
+	recordIntent(t, store, storeIntent{Operation: "create a container", Layers: []string{"Partial"}})
+	recordIntent(t, store, storeIntent{Operation: "delete image DeletedImage", Images: []string{"DeletedImage"}, Layers: []string{"Deleted"}})
+	_, err = store.Shutdown(true)
+	require.NoError(t, err)
+	store.Free()
+
+	store = newTestStore(t, options)
+	defer func() {
+		_, err := store.Shutdown(true)
+		require.NoError(t, err)
+		store.Free()
+	}()
+	for _, id := range []string{"Base", "Image", "Container"} {
+		assert.True(t, store.Exists(id), id)
+	}
+	for _, id := range []string{"Partial", "Deleted", "DeletedImage"} {
+		assert.False(t, store.Exists(id), id)
+	}
+	assert.Empty(t, storeIntents(t, store))
+}
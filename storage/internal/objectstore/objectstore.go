@@ -0,0 +1,52 @@
+// Package objectstore keeps the regular files of read-only layers in a
+// content-addressed directory of objects shared by all the layers, which
+// reference the objects by hard link or reflink.
+package objectstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Method is how the files of the layers reference the objects.
+type Method int
+
+const (
+	// MethodHardlink replaces the files of the layers with hard links to
+	// the objects.  As hard links share the inode, files share an object
+	// only if they have the same content and the same metadata.
+	MethodHardlink Method = iota + 1
+	// MethodReflink shares the data of the objects with the files of the
+	// layers, which keep their own inode.  Files share an object if they
+	// have the same content.
+	MethodReflink
+)
+
+// ErrNotSupported is returned by New when the object store cannot be used on
+// this platform or file system.
+var ErrNotSupported = errors.New("the object store is not supported")
+
+// ParseMethod parses the name of a Method, as used in the configuration.
+func ParseMethod(value string) (Method, error) {
+	switch value {
+	case "hardlink":
+		return MethodHardlink, nil
+	case "reflink":
+		return MethodReflink, nil
+	}
+	return 0, fmt.Errorf("invalid object store method %q, must be \"hardlink\" or \"reflink\"", value)
+}
+
+func (m Method) String() string {
+	switch m {
+	case MethodHardlink:
+		return "hardlink"
+	case MethodReflink:
+		return "reflink"
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// DirName is the name of the object store directory in the home directory of
+// a driver.
+const DirName = "objects"
@@ -0,0 +1,444 @@
+package objectstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/stringid"
+	"go.podman.io/storage/pkg/system"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	objectsDir = "objects"
+	layersDir  = "layers"
+	// tempPrefix is the prefix of the temporary files created in the
+	// object store and in the layers.
+	tempPrefix = ".containers-objectstore-tmp-"
+)
+
+// Store is a content-addressed store of file objects shared by the layers of a
+// driver.
+//
+// The objects a layer references are recorded in a list per layer.  With
+// MethodHardlink the link count of an object is its reference count, with
+// MethodReflink the lists of the other layers are searched.  Deleting an
+// object never affects the layers, which keep their data when it is removed.
+type Store struct {
+	root   string
+	method Method
+}
+
+// New returns the object store in the directory root, creating it if needed.
+// It fails with an error wrapping ErrNotSupported if root is on a file system
+// which does not support method.
+func New(root string, method Method) (*Store, error) {
+	for _, dir := range []string{root, filepath.Join(root, objectsDir), filepath.Join(root, layersDir)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	s := &Store{root: root, method: method}
+	if method == MethodReflink {
+		if err := s.probeReflink(); err != nil {
+			return nil, fmt.Errorf("using reflinks in %q: %v: %w", root, err, ErrNotSupported)
+		}
+	}
+	return s, nil
+}
+
+// probeReflink checks whether the file system of the store supports reflinks.
+func (s *Store) probeReflink() error {
+	src, err := os.CreateTemp(s.root, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	if _, err := src.WriteString("probe"); err != nil {
+		return err
+	}
+	dst, err := os.CreateTemp(s.root, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
+
+func (s *Store) objectPath(name string) string {
+	return filepath.Join(s.root, objectsDir, name[:2], name[2:])
+}
+
+func (s *Store) layerRefsPath(id string) string {
+	return filepath.Join(s.root, layersDir, id)
+}
+
+func (s *Store) readLayerRefs(id string) ([]string, error) {
+	data, err := os.ReadFile(s.layerRefsPath(id))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// sha256File returns the hex encoded sha256 checksum of the content of a file.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// objectName returns the name of the object for the file.  With
+// MethodHardlink it covers the metadata of the file too, as it is shared by
+// all the files linking to the object.
+func (s *Store) objectName(path string, st *unix.Stat_t) (string, error) {
+	content, err := sha256File(path)
+	if err != nil {
+		return "", err
+	}
+	if s.method != MethodHardlink {
+		return content, nil
+	}
+	xattrs, err := system.Llistxattr(path)
+	if err != nil && !errors.Is(err, unix.ENOTSUP) {
+		return "", err
+	}
+	slices.Sort(xattrs)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%o\x00%d\x00%d\x00%d.%09d\x00", content, st.Mode, st.Uid, st.Gid, st.Mtim.Sec, st.Mtim.Nsec)
+	for _, name := range xattrs {
+		value, err := system.Lgetxattr(path, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%x\x00", name, value)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AddLayer makes the regular files in dir, the content of the layer id,
+// reference objects in the store, adding the objects that are missing.  The
+// files of the layer must never be modified afterwards.
+func (s *Store) AddLayer(id, dir string) error {
+	var rootSt, storeSt unix.Stat_t
+	if err := unix.Stat(dir, &rootSt); err != nil {
+		return &os.PathError{Op: "stat", Path: dir, Err: err}
+	}
+	if err := unix.Stat(s.root, &storeSt); err != nil {
+		return &os.PathError{Op: "stat", Path: s.root, Err: err}
+	}
+	if rootSt.Dev != storeSt.Dev {
+		logrus.Debugf("Layer %s is not on the file system of the object store %s, not adding it", id, s.root)
+		return nil
+	}
+
+	refs := make(map[string]struct{})
+	// inodes records the object of the files with more than one link,
+	// so that they are read only once.
+	inodes := make(map[uint64]string)
+	// dirTimes records the times of the directories modified by
+	// replacing a file, to restore them at the end.
+	dirTimes := make(map[string][]unix.Timespec)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			return &os.PathError{Op: "lstat", Path: path, Err: err}
+		}
+		if st.Size == 0 {
+			return nil
+		}
+		name, found := inodes[st.Ino]
+		if !found {
+			if name, err = s.objectName(path, &st); err != nil {
+				return err
+			}
+			if st.Nlink > 1 {
+				inodes[st.Ino] = name
+			}
+		}
+		var added bool
+		if s.method == MethodHardlink {
+			added, err = s.linkFile(path, &st, name, dirTimes)
+		} else {
+			added, err = s.reflinkFile(path, &st, name)
+		}
+		if err != nil {
+			return err
+		}
+		if added {
+			refs[name] = struct{}{}
+		}
+		return nil
+	})
+
+	for dir, times := range dirTimes {
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, dir, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			logrus.Warnf("Failed to restore the times of %q: %v", dir, err)
+		}
+	}
+
+	// Record the references that were added, even on failure, so that
+	// the objects are released with the layer.
+	var list bytes.Buffer
+	w := bufio.NewWriter(&list)
+	for name := range refs {
+		fmt.Fprintln(w, name)
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return errors.Join(err, flushErr)
+	}
+	if writeErr := ioutils.AtomicWriteFile(s.layerRefsPath(id), list.Bytes(), 0o600); writeErr != nil {
+		return errors.Join(err, writeErr)
+	}
+	return err
+}
+
+// linkFile makes the file at path a hard link to the object name, creating
+// the object from the file if it is missing.  It returns false if the file
+// could not be linked.
+func (s *Store) linkFile(path string, st *unix.Stat_t, name string, dirTimes map[string][]unix.Timespec) (bool, error) {
+	obj := s.objectPath(name)
+	for {
+		var objSt unix.Stat_t
+		err := unix.Lstat(obj, &objSt)
+		if err == nil {
+			if objSt.Dev == st.Dev && objSt.Ino == st.Ino {
+				return true, nil
+			}
+			return s.replaceWithLink(obj, path, dirTimes)
+		}
+		if !errors.Is(err, unix.ENOENT) {
+			return false, &os.PathError{Op: "lstat", Path: obj, Err: err}
+		}
+		if err := os.MkdirAll(filepath.Dir(obj), 0o700); err != nil {
+			return false, err
+		}
+		err = unix.Link(path, obj)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, unix.EEXIST):
+			// Added concurrently, link to it.
+			continue
+		case errors.Is(err, unix.EMLINK):
+			logrus.Debugf("Too many links to %q, not adding it to the object store", path)
+			return false, nil
+		}
+		return false, &os.LinkError{Op: "link", Old: path, New: obj, Err: err}
+	}
+}
+
+// replaceWithLink atomically replaces the file at path with a hard link to obj.
+func (s *Store) replaceWithLink(obj, path string, dirTimes map[string][]unix.Timespec) (bool, error) {
+	parent := filepath.Dir(path)
+	if _, found := dirTimes[parent]; !found {
+		var parentSt unix.Stat_t
+		if err := unix.Lstat(parent, &parentSt); err != nil {
+			return false, &os.PathError{Op: "lstat", Path: parent, Err: err}
+		}
+		dirTimes[parent] = []unix.Timespec{parentSt.Atim, parentSt.Mtim}
+	}
+	tmp := filepath.Join(parent, tempPrefix+stringid.GenerateRandomID()[:12])
+	if err := unix.Link(obj, tmp); err != nil {
+		if errors.Is(err, unix.EMLINK) || errors.Is(err, unix.ENOENT) {
+			// Too many links to the object, or removed concurrently.
+			return false, nil
+		}
+		return false, &os.LinkError{Op: "link", Old: obj, New: tmp, Err: err}
+	}
+	if err := unix.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return false, &os.LinkError{Op: "rename", Old: tmp, New: path, Err: err}
+	}
+	return true, nil
+}
+
+// reflinkFile shares the data of the object name with the file at path,
+// creating the object as a clone of the file if it is missing.
+func (s *Store) reflinkFile(path string, st *unix.Stat_t, name string) (bool, error) {
+	obj := s.objectPath(name)
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	objFile, err := os.Open(obj)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(obj), 0o700); err != nil {
+			return false, err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(obj), tempPrefix)
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if err := unix.IoctlFileClone(int(tmp.Fd()), int(file.Fd())); err != nil {
+			return false, fmt.Errorf("cloning %q: %w", path, err)
+		}
+		if err := os.Rename(tmp.Name(), obj); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer objFile.Close()
+
+	// Deduplicating, unlike cloning, keeps the times and the
+	// capabilities of the file.
+	for offset := int64(0); offset < st.Size; {
+		r := unix.FileDedupeRange{
+			Src_offset: uint64(offset),
+			Src_length: uint64(st.Size - offset),
+			Info: []unix.FileDedupeRangeInfo{
+				{Dest_fd: int64(file.Fd()), Dest_offset: uint64(offset)},
+			},
+		}
+		if err := unix.IoctlFileDedupeRange(int(objFile.Fd()), &r); err != nil {
+			return false, fmt.Errorf("deduplicating %q: %w", path, err)
+		}
+		switch status := r.Info[0].Status; {
+		case status < 0:
+			return false, fmt.Errorf("deduplicating %q: %w", path, unix.Errno(-status))
+		case status == unix.FILE_DEDUPE_RANGE_DIFFERS:
+			return false, fmt.Errorf("deduplicating %q: the content of object %s differs", path, name)
+		}
+		if r.Info[0].Bytes_deduped == 0 {
+			break
+		}
+		offset += int64(r.Info[0].Bytes_deduped)
+	}
+	return true, nil
+}
+
+// RemoveLayer releases the objects referenced by the layer id, and deletes
+// the ones that are not referenced anymore.  With MethodHardlink, it must be
+// called after the files of the layer were deleted.
+func (s *Store) RemoveLayer(id string) error {
+	names, err := s.readLayerRefs(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(s.layerRefsPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.prune(names)
+}
+
+// GarbageCollect releases the objects of the layers not in layers, and
+// deletes all the objects that are not referenced.
+func (s *Store) GarbageCollect(layers []string) error {
+	entries, err := os.ReadDir(filepath.Join(s.root, layersDir))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !slices.Contains(layers, e.Name()) {
+			logrus.Debugf("Removing the object references of unknown layer %s", e.Name())
+			if err := os.Remove(filepath.Join(s.root, layersDir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	var names []string
+	objects := filepath.Join(s.root, objectsDir)
+	if err := filepath.WalkDir(objects, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), tempPrefix) {
+			return os.Remove(path)
+		}
+		rel, err := filepath.Rel(objects, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, strings.ReplaceAll(rel, string(filepath.Separator), ""))
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.prune(names)
+}
+
+// prune deletes the objects in names that are not referenced by any layer.
+func (s *Store) prune(names []string) error {
+	var referenced map[string]struct{}
+	if s.method == MethodReflink {
+		referenced = make(map[string]struct{})
+		entries, err := os.ReadDir(filepath.Join(s.root, layersDir))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			refs, err := s.readLayerRefs(e.Name())
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			for _, name := range refs {
+				referenced[name] = struct{}{}
+			}
+		}
+	}
+	for _, name := range names {
+		obj := s.objectPath(name)
+		if s.method == MethodReflink {
+			if _, found := referenced[name]; found {
+				continue
+			}
+		} else {
+			var st unix.Stat_t
+			if err := unix.Lstat(obj, &st); err != nil {
+				if errors.Is(err, unix.ENOENT) {
+					continue
+				}
+				return &os.PathError{Op: "lstat", Path: obj, Err: err}
+			}
+			if st.Nlink > 1 {
+				continue
+			}
+		}
+		if err := os.Remove(obj); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package objectstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+var testTime = time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+// createLayer creates a layer directory with the specified files, all with the
+// same times.
+func createLayer(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+		require.NoError(t, os.Chtimes(p, testTime, testTime))
+	}
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			err = os.Chtimes(path, testTime, testTime)
+		}
+		return err
+	}))
+}
+
+func inode(t *testing.T, path string) (uint64, uint64) {
+	var st unix.Stat_t
+	require.NoError(t, unix.Lstat(path, &st))
+	return st.Ino, uint64(st.Nlink)
+}
+
+func countObjects(t *testing.T, s *Store) int {
+	n := 0
+	require.NoError(t, filepath.WalkDir(filepath.Join(s.root, objectsDir), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	}))
+	return n
+}
+
+func TestHardlinkStore(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := New(filepath.Join(tmp, "objects"), MethodHardlink)
+	require.NoError(t, err)
+
+	layer1 := filepath.Join(tmp, "layer1")
+	layer2 := filepath.Join(tmp, "layer2")
+	createLayer(t, layer1, map[string]string{"etc/hostname": "host\n", "bin/tool": "tool", "empty": ""})
+	createLayer(t, layer2, map[string]string{"etc/hostname": "host\n", "bin/tool": "tool", "bin/other": "tool"})
+	// Same content, different metadata.
+	require.NoError(t, os.Chmod(filepath.Join(layer2, "bin/other"), 0o755))
+
+	require.NoError(t, s.AddLayer("layer1", layer1))
+	require.NoError(t, s.AddLayer("layer2", layer2))
+	assert.Equal(t, 3, countObjects(t, s))
+
+	ino1, nlink := inode(t, filepath.Join(layer1, "etc/hostname"))
+	ino2, _ := inode(t, filepath.Join(layer2, "etc/hostname"))
+	assert.Equal(t, ino1, ino2)
+	assert.Equal(t, uint64(3), nlink)
+	toolIno, _ := inode(t, filepath.Join(layer2, "bin/tool"))
+	otherIno, _ := inode(t, filepath.Join(layer2, "bin/other"))
+	assert.NotEqual(t, toolIno, otherIno)
+	_, nlink = inode(t, filepath.Join(layer1, "empty"))
+	assert.Equal(t, uint64(1), nlink)
+
+	// The content and the times of the layers are unchanged.
+	data, err := os.ReadFile(filepath.Join(layer2, "etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, "host\n", string(data))
+	for _, p := range []string{"etc", "etc/hostname", "bin"} {
+		fi, err := os.Lstat(filepath.Join(layer2, p))
+		require.NoError(t, err)
+		assert.True(t, fi.ModTime().Equal(testTime), p)
+	}
+	entries, err := os.ReadDir(filepath.Join(layer2, "bin"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	// Adding a layer again is a no-op.
+	require.NoError(t, s.AddLayer("layer2", layer2))
+	assert.Equal(t, 3, countObjects(t, s))
+
+	// Objects are deleted when the last layer using them is removed.
+	require.NoError(t, os.RemoveAll(layer1))
+	require.NoError(t, s.RemoveLayer("layer1"))
+	assert.Equal(t, 3, countObjects(t, s))
+	require.NoError(t, os.RemoveAll(layer2))
+	require.NoError(t, s.RemoveLayer("layer2"))
+	assert.Equal(t, 0, countObjects(t, s))
+	require.NoError(t, s.RemoveLayer("layer2"))
+
+	// The garbage collection drops the references of unknown layers.
+	layer3 := filepath.Join(tmp, "layer3")
+	createLayer(t, layer3, map[string]string{"file": "data"})
+	require.NoError(t, s.AddLayer("layer3", layer3))
+	require.NoError(t, os.RemoveAll(layer3))
+	assert.Equal(t, 1, countObjects(t, s))
+	require.NoError(t, s.GarbageCollect(nil))
+	assert.Equal(t, 0, countObjects(t, s))
+	assert.NoFileExists(t, s.layerRefsPath("layer3"))
+}
+
+func TestReflinkStore(t *testing.T) {
+	tmp := t.TempDir()
+	s, err := New(filepath.Join(tmp, "objects"), MethodReflink)
+	if errors.Is(err, ErrNotSupported) {
+		t.Skipf("reflinks are not supported: %v", err)
+	}
+	require.NoError(t, err)
+
+	layer1 := filepath.Join(tmp, "layer1")
+	layer2 := filepath.Join(tmp, "layer2")
+	createLayer(t, layer1, map[string]string{"file": "data"})
+	createLayer(t, layer2, map[string]string{"file": "data"})
+	require.NoError(t, os.Chmod(filepath.Join(layer2, "file"), 0o600))
+
+	require.NoError(t, s.AddLayer("layer1", layer1))
+	require.NoError(t, s.AddLayer("layer2", layer2))
+	assert.Equal(t, 1, countObjects(t, s))
+	fi, err := os.Lstat(filepath.Join(layer2, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode())
+	assert.True(t, fi.ModTime().Equal(testTime))
+
+	require.NoError(t, s.RemoveLayer("layer1"))
+	assert.Equal(t, 1, countObjects(t, s))
+	require.NoError(t, s.RemoveLayer("layer2"))
+	assert.Equal(t, 0, countObjects(t, s))
+}
+
+func TestParseMethod(t *testing.T) {
+	for _, m := range []Method{MethodHardlink, MethodReflink} {
+		parsed, err := ParseMethod(m.String())
+		require.NoError(t, err)
+		assert.Equal(t, m, parsed)
+	}
+	_, err := ParseMethod("copy")
+	assert.Error(t, err)
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package objectstore
+
+// Store is a content-addressed store of file objects shared by the layers of a
+// driver.
+type Store struct{}
+
+// New returns the object store in the directory root, creating it if needed.
+func New(root string, method Method) (*Store, error) {
+	return nil, ErrNotSupported
+}
+
+// AddLayer makes the regular files in dir, the content of the layer id,
+// reference objects in the store.
+func (s *Store) AddLayer(id, dir string) error {
+	return ErrNotSupported
+}
+
+// RemoveLayer releases the objects referenced by the layer id.
+func (s *Store) RemoveLayer(id string) error {
+	return ErrNotSupported
+}
+
+// GarbageCollect deletes all the objects that are not referenced.
+func (s *Store) GarbageCollect(layers []string) error {
+	return ErrNotSupported
+}
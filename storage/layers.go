@@ -27,6 +27,7 @@ import (
 	drivers "go.podman.io/storage/drivers"
 	"go.podman.io/storage/internal/tempdir"
 	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/directory"
 	"go.podman.io/storage/pkg/idtools"
 	"go.podman.io/storage/pkg/ioutils"
 	"go.podman.io/storage/pkg/lockfile"
@@ -43,6 +44,9 @@ const (
 	// tempDirPath is the subdirectory name used for storing temporary directories during layer deletion
 	tempDirPath    = "tmp"
 	incompleteFlag = "incomplete"
+	// objectStoreFlag marks read-only layers whose files are kept in the driver's object store,
+	// so that diffs applied to them after they are created are added to it as well.
+	objectStoreFlag = "object-store"
 	// maxLayerStoreCleanupIterations is the number of times we try to clean up inconsistent layer store state
 	// in readers (which, for implementation reasons, gives other writers the opportunity to create more inconsistent state)
 	// until we just give up.
@@ -208,6 +212,9 @@ type layerCreationContents struct {
 
 	// stagedLayerExtraction is used by the normal tar layer extraction.
 	stagedLayerExtraction *maybeStagedLayerExtraction
+
+	// cloneSource is a layer whose changes are copied by the driver, which must implement drivers.LayerCloner.
+	cloneSource *Layer
 }
 
 // maybeStagedLayerExtraction is a helper to encapsulate details around extracting
@@ -294,6 +301,10 @@ type roLayerStore interface {
 	// produced by Diff.
 	DiffSize(from, to string) (int64, error)
 
+	// uncompressedDiffSize returns the size of the uncompressed diff which Diff returns for the layer
+	// relative to its parent, if it is known without reading the diff, or -1.
+	uncompressedDiffSize(id string) (int64, error)
+
 	// Size produces a cached value for the uncompressed size of the layer,
 	// if one is known, or -1 if it is not known.  If the layer can not be
 	// found, it returns an error.
@@ -406,6 +417,16 @@ type rwLayerStore interface {
 	// Dedup deduplicates layers in the store.
 	dedup(drivers.DedupArgs) (drivers.DedupResult, error)
 
+	// restoreDigests sets the digests, sizes and compression type of a layer to the values recorded
+	// in original, e.g. after the layer was recreated from its uncompressed diff.
+	restoreDigests(id string, original *Layer) error
+
+	// setQuota sets, changes or removes the limits on the disk space used by a writable layer.
+	setQuota(id string, quota drivers.LayerQuota) error
+
+	// quota returns the limits on the disk space used by a writable layer, and its current usage.
+	quota(id string) (drivers.LayerQuota, *directory.DiskUsage, error)
+
 	// newMaybeStagedLayerExtraction initializes a new maybeStagedLayerExtraction. The caller
 	// must call maybeStagedLayerExtraction.cleanup() to remove any temporary files.
 	newMaybeStagedLayerExtraction(diff io.Reader) *maybeStagedLayerExtraction
@@ -483,6 +504,7 @@ type layerStore struct {
 	mountsLockfile *lockfile.LockFile // Can _only_ be obtained with inProcessLock held.
 	rundir         string
 	jsonPath       [numLayerLocationIndex]string
+	backend        metadataBackend
 	layerdir       string
 
 	inProcessLock sync.RWMutex // Can _only_ be obtained with lockfile held.
@@ -879,6 +901,16 @@ func (r *layerStore) GarbageCollect() error {
 		}
 	}
 
+	if osd, ok := r.driver.(drivers.ObjectStoreDriver); ok {
+		ids := make([]string, 0, len(r.layers))
+		for _, layer := range r.layers {
+			ids = append(ids, layer.ID)
+		}
+		if moreErr := osd.GarbageCollectObjects(ids); moreErr != nil && err == nil {
+			err = moreErr
+		}
+	}
+
 	return err
 }
 
@@ -930,16 +962,9 @@ func (r *layerStore) load(lockedForWriting bool) (bool, error) {
 		} else {
 			r.layerspathsModified[locationIndex] = info.ModTime()
 		}
-		data, err := os.ReadFile(rpath)
-		if err != nil && !os.IsNotExist(err) {
-			return false, err
-		}
-
 		locationLayers := []*Layer{}
-		if len(data) != 0 {
-			if err := json.Unmarshal(data, &locationLayers); err != nil {
-				return false, fmt.Errorf("loading %q: %w", rpath, err)
-			}
+		if err := r.backend.read(rpath, &locationLayers); err != nil {
+			return false, err
 		}
 
 		for _, layer := range locationLayers {
@@ -1074,7 +1099,7 @@ func (r *layerStore) load(lockedForWriting bool) (bool, error) {
 			}
 			modifiedLocations |= layer.location
 		}
-		if err := r.saveLayers(modifiedLocations, false); err != nil {
+		if err := r.saveLayers(modifiedLocations, nil, false); err != nil {
 			return false, err
 		}
 		if incompleteDeletionErrors != nil {
@@ -1126,36 +1151,48 @@ func (r *layerStore) loadMounts() error {
 }
 
 // save saves the contents of the store to disk.
+// If modifiedIDs is not nil, it contains the IDs of all layers which were added,
+// modified or removed since the store was last loaded or saved.
 // If needsSyncfs is true, all pending writes are flushed to disk before
 // saving the layer metadata.  Set it to false for metadata-only changes
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for WRITING.
-func (r *layerStore) save(saveLocations layerLocations, needsSyncfs bool) error {
+func (r *layerStore) save(saveLocations layerLocations, modifiedIDs []string, needsSyncfs bool) error {
 	r.mountsLockfile.Lock()
 	defer r.mountsLockfile.Unlock()
-	if err := r.saveLayers(saveLocations, needsSyncfs); err != nil {
+	if err := r.saveLayers(saveLocations, modifiedIDs, needsSyncfs); err != nil {
 		return err
 	}
 	return r.saveMounts()
 }
 
-// saveFor saves the contents of the store relevant for modifiedLayer to disk.
+// saveFor saves the contents of the store relevant for modifiedLayers to disk.
+// modifiedLayers must include all layers which were added, modified or removed
+// since the store was last loaded or saved.
 // If needsSyncfs is true, all pending writes are flushed to disk before
 // saving the layer metadata.  Set it to false for metadata-only changes
 // (e.g. setting a flag, changing names).
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for WRITING.
-func (r *layerStore) saveFor(modifiedLayer *Layer, needsSyncfs bool) error {
-	return r.save(modifiedLayer.location, needsSyncfs)
+func (r *layerStore) saveFor(needsSyncfs bool, modifiedLayers ...*Layer) error {
+	var saveLocations layerLocations
+	modifiedIDs := make([]string, 0, len(modifiedLayers))
+	for _, layer := range modifiedLayers {
+		saveLocations |= layer.location
+		modifiedIDs = append(modifiedIDs, layer.ID)
+	}
+	return r.save(saveLocations, modifiedIDs, needsSyncfs)
 }
 
 // saveLayers writes the layer metadata to disk.
+// If modifiedIDs is not nil, it contains the IDs of all layers which were added,
+// modified or removed since the store was last loaded or saved.
 // If needsSyncfs is true, all pending writes are flushed to disk before
 // saving the layer metadata.  Set it to false for metadata-only changes
 // (e.g. setting a flag, changing names).
 // The caller must hold r.lockfile locked for writing.
 // The caller must hold r.inProcessLock for WRITING.
-func (r *layerStore) saveLayers(saveLocations layerLocations, needsSyncfs bool) error {
+func (r *layerStore) saveLayers(saveLocations layerLocations, modifiedIDs []string, needsSyncfs bool) error {
 	if !r.lockfile.IsReadWrite() {
 		return fmt.Errorf("not allowed to modify the layer store at %q: %w", r.layerdir, ErrStoreIsReadOnly)
 	}
@@ -1188,14 +1225,6 @@ func (r *layerStore) saveLayers(saveLocations layerLocations, needsSyncfs bool)
 			}
 		}
 
-		jldata, err := json.Marshal(&subsetLayers)
-		if err != nil {
-			return err
-		}
-		opts := ioutils.AtomicFileWriterOptions{}
-		if location == volatileLayerLocation {
-			opts.NoSync = true
-		}
 		// If the underlying storage driver is using sync and we are writing data (not just metadata),
 		// make sure we sync everything before saving the layer data, this ensures that all
 		// files/directories are properly created and written.
@@ -1211,10 +1240,11 @@ func (r *layerStore) saveLayers(saveLocations layerLocations, needsSyncfs bool)
 				return fmt.Errorf("unknown sync mode: %q", r.driver.SyncMode().String())
 			}
 		}
-		if err := ioutils.AtomicWriteFileWithOpts(rpath, jldata, 0o600, &opts); err != nil {
+		modTime, err := r.backend.write(rpath, subsetLayers, modifiedIDs, location == volatileLayerLocation)
+		if err != nil {
 			return err
 		}
-		r.layerspathsModified[locationIndex] = opts.ModTime
+		r.layerspathsModified[locationIndex] = modTime
 	}
 	return nil
 }
@@ -1311,6 +1341,7 @@ func (s *store) newLayerStore(rundir, layerdir, imagedir string, driver drivers.
 			layersImageDir,
 			filepath.Join(volatileDir, "volatile-layers.json"),
 		},
+		backend:  s.metadataBackend,
 		layerdir: layerdir,
 
 		byid:    make(map[string]*Layer),
@@ -1332,10 +1363,37 @@ func (s *store) newLayerStore(rundir, layerdir, imagedir string, driver drivers.
 	if _, err := rlstore.load(true); err != nil {
 		return nil, err
 	}
+	if err := rlstore.migrateMetadata(); err != nil {
+		return nil, err
+	}
 	return &rlstore, nil
 }
 
-func newROLayerStore(rundir string, layerdir string, driver drivers.Driver) (roLayerStore, error) {
+// migrateMetadata writes records which are not stored using r.backend again, moving them to r.backend.
+// The caller must hold r.lockfile locked for writing.
+// The caller must hold r.inProcessLock for WRITING.
+func (r *layerStore) migrateMetadata() error {
+	var migrateLocations layerLocations
+	for locationIndex := range numLayerLocationIndex {
+		rpath := r.jsonPath[locationIndex]
+		if rpath == "" {
+			continue
+		}
+		needed, err := r.backend.needsMigration(rpath)
+		if err != nil {
+			return err
+		}
+		if needed {
+			migrateLocations |= layerLocationFromIndex(locationIndex)
+		}
+	}
+	if migrateLocations == 0 {
+		return nil
+	}
+	return r.saveLayers(migrateLocations, nil, false)
+}
+
+func newROLayerStore(rundir string, layerdir string, driver drivers.Driver, backend metadataBackend) (roLayerStore, error) {
 	lockfile, err := lockfile.GetROLockFile(filepath.Join(layerdir, "layers.lock"))
 	if err != nil {
 		return nil, err
@@ -1349,6 +1407,7 @@ func newROLayerStore(rundir string, layerdir string, driver drivers.Driver) (roL
 			"",
 			filepath.Join(layerdir, "volatile-layers.json"),
 		},
+		backend:  backend,
 		layerdir: layerdir,
 
 		byid:    make(map[string]*Layer),
@@ -1376,7 +1435,7 @@ func newROLayerStore(rundir string, layerdir string, driver drivers.Driver) (roL
 func (r *layerStore) lookup(id string) (*Layer, bool) {
 	if layer, ok := r.byid[id]; ok {
 		return layer, ok
-	} else if layer, ok := r.byname[id]; ok {
+	} else if layer, ok := r.lookupName(id); ok {
 		return layer, ok
 	} else if longid, err := r.idindex.Get(id); err == nil {
 		layer, ok := r.byid[longid]
@@ -1385,6 +1444,48 @@ func (r *layerStore) lookup(id string) (*Layer, bool) {
 	return nil, false
 }
 
+// lookupIndexed returns the layers which have key of kind, and true, if the records of all locations are indexed
+// by the metadata backend.
+// Requires startReading or startWriting.
+func (r *layerStore) lookupIndexed(kind metadataKeyKind, key string) ([]*Layer, bool, error) {
+	var layers []*Layer
+	for locationIndex := range numLayerLocationIndex {
+		rpath := r.jsonPath[locationIndex]
+		if rpath == "" {
+			continue
+		}
+		ids, indexed, err := lookupMetadata(rpath, kind, key)
+		if err != nil || !indexed {
+			return nil, false, err
+		}
+		location := layerLocationFromIndex(locationIndex)
+		for _, id := range ids {
+			// Layers with duplicate IDs in other locations are ignored by load(), ignore them here as well.
+			if layer, ok := r.byid[id]; ok && layer.location == location {
+				layers = append(layers, layer)
+			}
+		}
+	}
+	return layers, true, nil
+}
+
+// lookupName returns the layer with name, using the index of the metadata backend if possible.
+// Requires startReading or startWriting.
+func (r *layerStore) lookupName(name string) (*Layer, bool) {
+	layers, indexed, err := r.lookupIndexed(metadataKeyName, name)
+	if err != nil {
+		logrus.Debugf("Looking up layer name %q: %v", name, err)
+	} else if indexed {
+		if len(layers) == 0 {
+			return nil, false
+		}
+		// Consistent with load(), if the name is not unique, the last layer wins.
+		return layers[len(layers)-1], true
+	}
+	layer, ok := r.byname[name]
+	return layer, ok
+}
+
 // Requires startReading or startWriting.
 func (r *layerStore) Size(name string) (int64, error) {
 	layer, ok := r.lookup(name)
@@ -1410,7 +1511,7 @@ func (r *layerStore) ClearFlag(id string, flag string) error {
 		return ErrLayerUnknown
 	}
 	delete(layer.Flags, flag)
-	return r.saveFor(layer, false)
+	return r.saveFor(false, layer)
 }
 
 // Requires startWriting.
@@ -1426,7 +1527,7 @@ func (r *layerStore) SetFlag(id string, flag string, value any) error {
 		layer.Flags = make(map[string]any)
 	}
 	layer.Flags[flag] = value
-	return r.saveFor(layer, false)
+	return r.saveFor(false, layer)
 }
 
 func (r *layerStore) Status() ([][2]string, error) {
@@ -1481,7 +1582,7 @@ func (r *layerStore) PutAdditionalLayer(id string, parentLayer *Layer, names []s
 	if layer.TOCDigest != "" {
 		r.bytocsum[layer.TOCDigest] = append(r.bytocsum[layer.TOCDigest], layer.ID)
 	}
-	if err := r.saveFor(layer, true); err != nil {
+	if err := r.saveFor(true, layer); err != nil {
 		if e := r.deleteWhileHoldingLock(layer.ID); e != nil {
 			logrus.Errorf("While recovering from a failure to save layers, error deleting layer %#v: %v", id, e)
 		}
@@ -1547,6 +1648,13 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 	if parentLayer != nil {
 		parent = parentLayer.ID
 	}
+	var cloner drivers.LayerCloner
+	if contents != nil && contents.cloneSource != nil {
+		var ok bool
+		if cloner, ok = r.driver.(drivers.LayerCloner); !ok || writeable || moreOptions.TemplateLayer != "" || contents.cloneSource.Parent != parent {
+			return nil, -1, fmt.Errorf("cloning layer %q: %w", contents.cloneSource.ID, ErrNotSupported)
+		}
+	}
 	var (
 		templateIDMappings         *idtools.IDMappings
 		templateMetadata           string
@@ -1609,6 +1717,9 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 		location:           r.pickStoreLocation(moreOptions.Volatile, writeable),
 	}
 	layer.Flags[incompleteFlag] = true
+	if _, ok := r.driver.(drivers.ObjectStoreDriver); ok && !writeable {
+		layer.Flags[objectStoreFlag] = true
+	}
 
 	r.layers = append(r.layers, layer)
 	// This can only fail if the ID is already missing, which shouldn’t
@@ -1636,7 +1747,7 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 		}
 	}()
 
-	if err = r.saveFor(layer, false); err != nil {
+	if err = r.saveFor(false, layer); err != nil {
 		cleanupFailureContext = "saving incomplete layer metadata"
 		return nil, -1, err
 	}
@@ -1670,6 +1781,12 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 			return nil, -1, fmt.Errorf("creating copy of template layer %q with ID %q: %w", moreOptions.TemplateLayer, id, err)
 		}
 		oldMappings = templateIDMappings
+	} else if cloner != nil {
+		if err = cloner.CloneLayer(id, parent, contents.cloneSource.ID, &opts); err != nil {
+			cleanupFailureContext = fmt.Sprintf("cloning layer %q", contents.cloneSource.ID)
+			return nil, -1, fmt.Errorf("creating copy of layer %q with ID %q: %w", contents.cloneSource.ID, id, err)
+		}
+		// The copy already has the ID mappings of cloneSource.
 	} else {
 		if writeable {
 			if err = r.driver.CreateReadWrite(id, parent, &opts); err != nil {
@@ -1708,7 +1825,12 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 
 	size = -1
 	if contents != nil {
-		if contents.stagedLayerExtraction != nil {
+		if contents.cloneSource != nil {
+			if err := r.recordDiffOfCopy(layer, parentLayer); err != nil {
+				cleanupFailureContext = "recording diff of a copy of a layer"
+				return nil, -1, err
+			}
+		} else if contents.stagedLayerExtraction != nil {
 			if contents.stagedLayerExtraction.result != nil {
 				// The layer is staged, just commit it and update the metadata.
 				if err := contents.stagedLayerExtraction.commitLayer(r, layer.ID); err != nil {
@@ -1746,8 +1868,13 @@ func (r *layerStore) create(id string, parentLayer *Layer, names []string, mount
 		}
 	}
 
+	if err = r.addToObjectStore(layer); err != nil {
+		cleanupFailureContext = "adding the layer to the object store"
+		return nil, -1, err
+	}
+
 	delete(layer.Flags, incompleteFlag)
-	if err = r.saveFor(layer, true); err != nil {
+	if err = r.saveFor(true, layer); err != nil {
 		cleanupFailureContext = "saving finished layer metadata"
 		return nil, -1, err
 	}
@@ -1974,14 +2101,16 @@ func (r *layerStore) updateNames(id string, names []string, op updateNameOperati
 	for _, name := range oldNames {
 		delete(r.byname, name)
 	}
+	modifiedLayers := []*Layer{layer}
 	for _, name := range names {
 		if otherLayer, ok := r.byname[name]; ok {
 			r.removeName(otherLayer, name)
+			modifiedLayers = append(modifiedLayers, otherLayer)
 		}
 		r.byname[name] = layer
 	}
 	layer.Names = names
-	return r.saveFor(layer, false)
+	return r.saveFor(false, modifiedLayers...)
 }
 
 func (r *layerStore) datadir(id string) string {
@@ -2044,7 +2173,7 @@ func (r *layerStore) setBigData(layer *Layer, key string, data io.Reader) error
 
 	if !slices.Contains(layer.BigDataNames, key) {
 		layer.BigDataNames = append(layer.BigDataNames, key)
-		return r.saveFor(layer, false)
+		return r.saveFor(false, layer)
 	}
 	return nil
 }
@@ -2073,11 +2202,32 @@ func (r *layerStore) SetMetadata(id, metadata string) error {
 	}
 	if layer, ok := r.lookup(id); ok {
 		layer.Metadata = metadata
-		return r.saveFor(layer, false)
+		return r.saveFor(false, layer)
 	}
 	return ErrLayerUnknown
 }
 
+// Requires startWriting.
+func (r *layerStore) restoreDigests(id string, original *Layer) error {
+	if !r.lockfile.IsReadWrite() {
+		return fmt.Errorf("not allowed to modify layer digests at %q: %w", r.layerdir, ErrStoreIsReadOnly)
+	}
+	layer, ok := r.lookup(id)
+	if !ok {
+		return ErrLayerUnknown
+	}
+	updateDigestMap(&r.bycompressedsum, layer.CompressedDigest, original.CompressedDigest, layer.ID)
+	layer.CompressedDigest = original.CompressedDigest
+	layer.CompressedSize = original.CompressedSize
+	updateDigestMap(&r.byuncompressedsum, layer.UncompressedDigest, original.UncompressedDigest, layer.ID)
+	layer.UncompressedDigest = original.UncompressedDigest
+	layer.UncompressedSize = original.UncompressedSize
+	updateDigestMap(&r.bytocsum, layer.TOCDigest, original.TOCDigest, layer.ID)
+	layer.TOCDigest = original.TOCDigest
+	layer.CompressionType = original.CompressionType
+	return r.saveFor(false, layer)
+}
+
 func (r *layerStore) tspath(id string) string {
 	return filepath.Join(r.layerdir, id+tarSplitSuffix)
 }
@@ -2113,7 +2263,7 @@ func (r *layerStore) internalDelete(id string) ([]tempdir.CleanupTempDirFunc, er
 			layer.Flags = make(map[string]any)
 		}
 		layer.Flags[incompleteFlag] = true
-		if err := r.saveFor(layer, false); err != nil {
+		if err := r.saveFor(false, layer); err != nil {
 			return nil, err
 		}
 	}
@@ -2212,7 +2362,7 @@ func (r *layerStore) deferredDelete(id string) ([]tempdir.CleanupTempDirFunc, er
 	if err != nil {
 		return cleanFunctions, err
 	}
-	return cleanFunctions, r.saveFor(layer, false)
+	return cleanFunctions, r.saveFor(false, layer)
 }
 
 // Requires startReading or startWriting.
@@ -2816,11 +2966,73 @@ func (r *layerStore) applyDiffWithOptions(to string, layerOptions *LayerOptions,
 
 	r.applyDiffResultToLayer(layer, result)
 
-	err = r.saveFor(layer, true)
+	// While the layer is being created, create adds it to the object store once it is complete.
+	if !layerHasIncompleteFlag(layer) {
+		if err := r.addToObjectStore(layer); err != nil {
+			return -1, err
+		}
+	}
+
+	err = r.saveFor(true, layer)
 
 	return result.size, err
 }
 
+// addToObjectStore makes the files of layer reference objects in the object store of the driver,
+// if layer is a read-only layer created while the driver supported one.
+//
+// Requires startWriting.
+func (r *layerStore) addToObjectStore(layer *Layer) error {
+	if flagValue, ok := layer.Flags[objectStoreFlag].(bool); !ok || !flagValue {
+		return nil
+	}
+	osd, ok := r.driver.(drivers.ObjectStoreDriver)
+	if !ok {
+		return nil
+	}
+	if err := osd.AddLayerToObjectStore(layer.ID); err != nil {
+		return fmt.Errorf("adding layer %q to the object store: %w", layer.ID, err)
+	}
+	return nil
+}
+
+// recordDiffOfCopy computes the digests and tar-split data of layer, which was created with the
+// contents of another layer by drivers.LayerCloner, from an archive of its changes produced by the driver.
+//
+// Requires startWriting.
+func (r *layerStore) recordDiffOfCopy(layer, parentLayer *Layer) (retErr error) {
+	parentMappings := &idtools.IDMappings{}
+	if parentLayer != nil {
+		parentMappings = r.layerMappings(parentLayer)
+	}
+	diff, err := r.driver.Diff(layer.ID, r.layerMappings(layer), layer.Parent, parentMappings, layer.MountLabel)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+
+	tarSplitFile, err := createTarSplitFile(r, layer.ID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := tarSplitFile.Close()
+		if retErr == nil {
+			retErr = closeErr
+		}
+	}()
+
+	// The contents are already in place, so there is nothing to apply; applyDiff consumes the archive.
+	result, err := applyDiff(nil, diff, tarSplitFile, func(io.Reader) (int64, error) {
+		return -1, nil
+	})
+	if err != nil {
+		return err
+	}
+	r.applyDiffResultToLayer(layer, result)
+	return nil
+}
+
 // Requires startWriting.
 func (r *layerStore) applyDiffResultToLayer(layer *Layer, result *applyDiffResult) {
 	updateDigestMap(&r.bycompressedsum, layer.CompressedDigest, result.compressedDigest, layer.ID)
@@ -2937,7 +3149,13 @@ func (r *layerStore) applyDiffFromStagingDirectory(id string, diffOutput *driver
 		}
 	}
 
-	if err = r.saveFor(layer, true); err != nil {
+	// While the layer is being created, create adds it to the object store once it is complete.
+	if !layerHasIncompleteFlag(layer) {
+		if err := r.addToObjectStore(layer); err != nil {
+			return err
+		}
+	}
+	if err = r.saveFor(true, layer); err != nil {
 		return err
 	}
 	return err
@@ -2963,7 +3181,18 @@ func (r *layerStore) CleanupStagingDirectory(stagingDirectory string) error {
 }
 
 // Requires startReading or startWriting.
-func (r *layerStore) layersByDigestMap(m map[digest.Digest][]string, d digest.Digest) ([]Layer, error) {
+func (r *layerStore) layersByDigestMap(kind metadataKeyKind, m map[digest.Digest][]string, d digest.Digest) ([]Layer, error) {
+	indexedLayers, indexed, err := r.lookupIndexed(kind, d.String())
+	if err != nil {
+		return nil, err
+	}
+	if indexed {
+		var layers []Layer
+		for _, layer := range indexedLayers {
+			layers = append(layers, *copyLayer(layer))
+		}
+		return layers, nil
+	}
 	var layers []Layer
 	for _, layerID := range m[d] {
 		layer, ok := r.lookup(layerID)
@@ -2977,17 +3206,17 @@ func (r *layerStore) layersByDigestMap(m map[digest.Digest][]string, d digest.Di
 
 // Requires startReading or startWriting.
 func (r *layerStore) LayersByCompressedDigest(d digest.Digest) ([]Layer, error) {
-	return r.layersByDigestMap(r.bycompressedsum, d)
+	return r.layersByDigestMap(metadataKeyCompressedDigest, r.bycompressedsum, d)
 }
 
 // Requires startReading or startWriting.
 func (r *layerStore) LayersByUncompressedDigest(d digest.Digest) ([]Layer, error) {
-	return r.layersByDigestMap(r.byuncompressedsum, d)
+	return r.layersByDigestMap(metadataKeyUncompressedDigest, r.byuncompressedsum, d)
 }
 
 // Requires startReading or startWriting.
 func (r *layerStore) LayersByTOCDigest(d digest.Digest) ([]Layer, error) {
-	return r.layersByDigestMap(r.bytocsum, d)
+	return r.layersByDigestMap(metadataKeyTOCDigest, r.bytocsum, d)
 }
 
 // Requires startWriting.
@@ -2995,6 +3224,67 @@ func (r *layerStore) dedup(req drivers.DedupArgs) (drivers.DedupResult, error) {
 	return r.driver.Dedup(req)
 }
 
+// quotaDriver returns the driver of the store, if it supports limiting the disk space used by layers.
+func (r *layerStore) quotaDriver() (drivers.QuotaDriver, error) {
+	quotaDriver, ok := r.driver.(drivers.QuotaDriver)
+	if !ok {
+		return nil, fmt.Errorf("limiting the disk space used by layers with the %q driver: %w", r.driver.String(), ErrNotSupported)
+	}
+	return quotaDriver, nil
+}
+
+// Requires startWriting.
+func (r *layerStore) setQuota(id string, quota drivers.LayerQuota) error {
+	layer, ok := r.lookup(id)
+	if !ok {
+		return ErrLayerUnknown
+	}
+	quotaDriver, err := r.quotaDriver()
+	if err != nil {
+		return err
+	}
+	return quotaDriver.SetLayerQuota(layer.ID, quota)
+}
+
+// Requires startReading or startWriting.
+func (r *layerStore) quota(id string) (drivers.LayerQuota, *directory.DiskUsage, error) {
+	layer, ok := r.lookup(id)
+	if !ok {
+		return drivers.LayerQuota{}, nil, ErrLayerUnknown
+	}
+	quotaDriver, err := r.quotaDriver()
+	if err != nil {
+		return drivers.LayerQuota{}, nil, err
+	}
+	return quotaDriver.LayerQuota(layer.ID)
+}
+
+// Requires startReading or startWriting.
+func (r *layerStore) uncompressedDiffSize(id string) (int64, error) {
+	layer, ok := r.lookup(id)
+	if !ok {
+		return -1, ErrLayerUnknown
+	}
+	if layer.UncompressedDigest == "" || layer.UncompressedSize < 0 {
+		return -1, nil
+	}
+	// Diff reproduces the original diff only if the tar-split data is available, and the layer
+	// is not in an additional layer store; otherwise, the diff is generated again by the driver.
+	if ad, ok := r.driver.(drivers.AdditionalLayerStoreDriver); ok {
+		if aLayer, err := ad.LookupAdditionalLayerByID(layer.ID); err == nil {
+			aLayer.Release()
+			return -1, nil
+		}
+	}
+	if _, err := os.Stat(r.tspath(layer.ID)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return -1, nil
+		}
+		return -1, err
+	}
+	return layer.UncompressedSize, nil
+}
+
 func closeAll(closes ...func() error) (rErr error) {
 	for _, f := range closes {
 		if err := f(); err != nil {
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+const (
+	// metadataBackendJSON stores records in JSON files, e.g. layers.json. This is the default.
+	metadataBackendJSON = "json"
+	// metadataBackendSQLite stores records in a SQLite database, allowing incremental updates.
+	metadataBackendSQLite = "sqlite"
+)
+
+// metadataKeyKind is a kind of key by which records can be looked up, see lookupMetadata.
+type metadataKeyKind string
+
+const (
+	// metadataKeyName looks up layers, images and containers by their names.
+	metadataKeyName metadataKeyKind = "name"
+	// metadataKeyCompressedDigest looks up layers by Layer.CompressedDigest.
+	metadataKeyCompressedDigest metadataKeyKind = "compressed-digest"
+	// metadataKeyUncompressedDigest looks up layers by Layer.UncompressedDigest.
+	metadataKeyUncompressedDigest metadataKeyKind = "uncompressed-digest"
+	// metadataKeyTOCDigest looks up layers by Layer.TOCDigest.
+	metadataKeyTOCDigest metadataKeyKind = "toc-digest"
+	// metadataKeyDigest looks up images by Image.Digests.
+	metadataKeyDigest metadataKeyKind = "digest"
+)
+
+// metadataBackend stores the records of layer, image and container stores, i.e. the contents of layers.json,
+// images.json, containers.json and their variants, each identified by the path of the JSON file.
+//
+// Records are stored either in the JSON file, or in a SQLite database next to it (see sqliteMetadataPath); if
+// the database contains records for a path, they take precedence over the JSON file. All backends read records the same way,
+// and only differ in where they write them; so, a store is migrated from one backend to another just by writing
+// its records again, and processes configured to use different backends still see the same data.
+type metadataBackend interface {
+	// read decodes the records stored for path into records, a pointer to a slice of pointers to records.
+	// records is not modified if nothing is stored for path.
+	read(path string, records any) error
+	// write replaces the records stored for path with records, a slice of pointers to records.
+	// If modified is not nil, it contains the IDs of all records which were added, modified or removed since
+	// records were last read or written, and the backend may ignore other records.
+	// If noSync, the records need not be preserved if the system crashes.
+	// It returns the modification time of the JSON file at path, or the zero time if records are not stored in it.
+	write(path string, records any, modified []string, noSync bool) (time.Time, error)
+	// needsMigration returns true if records for path are not stored where the backend writes them.
+	needsMigration(path string) (bool, error)
+}
+
+// newMetadataBackend returns a metadataBackend for name, as set in StoreOptions.MetadataBackend.
+func newMetadataBackend(name string) (metadataBackend, error) {
+	switch name {
+	case "", metadataBackendJSON:
+		return jsonMetadataBackend{}, nil
+	case metadataBackendSQLite:
+		return newSQLiteMetadataBackend()
+	default:
+		return nil, fmt.Errorf("unknown metadata backend %q", name)
+	}
+}
+
+// sqliteMetadataPath returns the path of the SQLite database used by metadataBackendSQLite to store
+// records for the JSON file at path, e.g. layers.sqlite for layers.json.
+func sqliteMetadataPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".sqlite"
+}
+
+// readMetadata decodes the records stored for path, either in the SQLite database or in the JSON file, into records.
+func readMetadata(path string, records any) error {
+	found, err := readSQLiteMetadata(path, records)
+	if err != nil || found {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, records); err != nil {
+			return fmt.Errorf("loading %q: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// lookupMetadata returns the IDs of records stored for path which have key of kind, in the order of the records,
+// and true, if the records are indexed. It returns false if the records are stored in the JSON file; the caller
+// must then search the records it has read.
+func lookupMetadata(path string, kind metadataKeyKind, key string) ([]string, bool, error) {
+	ids, found, err := lookupSQLiteMetadata(path, kind, key)
+	if err != nil || found {
+		return ids, found, err
+	}
+	// If there are no records at all, there is nothing to search.
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return nil, false, nil
+}
+
+// jsonMetadataBackend is a metadataBackend which writes records to JSON files.
+type jsonMetadataBackend struct{}
+
+func (jsonMetadataBackend) read(path string, records any) error {
+	return readMetadata(path, records)
+}
+
+func (jsonMetadataBackend) write(path string, records any, _ []string, noSync bool) (time.Time, error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return time.Time{}, err
+	}
+	opts := ioutils.AtomicFileWriterOptions{NoSync: noSync}
+	if err := ioutils.AtomicWriteFileWithOpts(path, data, 0o600, &opts); err != nil {
+		return time.Time{}, err
+	}
+	// Only stop using records in the database after the JSON file is complete, so that the records
+	// are not lost if we are interrupted.
+	if err := deleteSQLiteMetadata(path); err != nil {
+		return time.Time{}, err
+	}
+	return opts.ModTime, nil
+}
+
+func (jsonMetadataBackend) needsMigration(path string) (bool, error) {
+	return sqliteMetadataExists(path)
+}
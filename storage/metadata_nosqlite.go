@@ -0,0 +1,44 @@
+//go:build !cgo || exclude_metadata_sqlite
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errNoSQLiteMetadata is returned when a SQLite metadata database is used in a build without SQLite support.
+var errNoSQLiteMetadata = errors.New("the sqlite metadata backend is not supported in this build")
+
+func newSQLiteMetadataBackend() (metadataBackend, error) {
+	return nil, errNoSQLiteMetadata
+}
+
+// checkNoSQLiteMetadataDB fails if the SQLite database for path exists, because this build can not read it.
+func checkNoSQLiteMetadataDB(path string) error {
+	dbPath := sqliteMetadataPath(path)
+	if _, err := os.Stat(dbPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("reading %q: %w", dbPath, errNoSQLiteMetadata)
+}
+
+func readSQLiteMetadata(path string, _ any) (bool, error) {
+	return false, checkNoSQLiteMetadataDB(path)
+}
+
+func lookupSQLiteMetadata(path string, _ metadataKeyKind, _ string) ([]string, bool, error) {
+	return nil, false, checkNoSQLiteMetadataDB(path)
+}
+
+func sqliteMetadataExists(path string) (bool, error) {
+	return false, checkNoSQLiteMetadataDB(path)
+}
+
+func deleteSQLiteMetadata(path string) error {
+	return checkNoSQLiteMetadataDB(path)
+}
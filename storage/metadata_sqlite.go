@@ -0,0 +1,736 @@
+//go:build cgo && !exclude_metadata_sqlite
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" backend for database/sql
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// sqliteMetadataOptions are options used when opening a database.
+//
+// Obtain a write lock at the start of every transaction, instead of upgrading a read lock, which can fail
+// if there are multiple readers; see the more detailed discussion in c/image/pkg/blobinfocache/sqlite.
+// Readers don't need transactions, because the store's lock files prevent concurrent modifications
+// of records they read.
+// The synchronous mode is set by each writer, see sqliteMetadataDB.transaction.
+const sqliteMetadataOptions = "?_txlock=exclusive"
+
+// sqliteMetadataDeletionsKept is the minimum number of generations for which deletions are recorded.
+// Readers with a cache older than that read all records again.
+const sqliteMetadataDeletionsKept = 1000
+
+// sqliteMetadataSchema creates the database schema.
+//
+// state contains a single row, recording whether the database replaces the JSON file (i.e. it is active),
+// and a generation number incremented on every write. records contains the JSON-encoded records, with the
+// generation in which they were last modified, and deletions contains the IDs of deleted records, with the
+// generation in which they were deleted; so, a cached copy of the records is updated by querying only the
+// changes since the cached generation. Only deletions after state.deletionsSince are recorded.
+// keys contains the names and digests of records, so that records can be looked up without reading all of them.
+var sqliteMetadataSchema = []string{
+	`CREATE TABLE IF NOT EXISTS state(
+		singleton		INTEGER PRIMARY KEY NOT NULL CHECK (singleton = 0),
+		active			INTEGER NOT NULL,
+		generation		INTEGER NOT NULL,
+		deletionsSince	INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS records(
+		id			TEXT PRIMARY KEY NOT NULL,
+		generation	INTEGER NOT NULL,
+		data		BLOB NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS recordsByGeneration ON records(generation)`,
+	`CREATE TABLE IF NOT EXISTS deletions(
+		id			TEXT PRIMARY KEY NOT NULL,
+		generation	INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS deletionsByGeneration ON deletions(generation)`,
+	`CREATE TABLE IF NOT EXISTS keys(
+		id		TEXT NOT NULL,
+		kind	TEXT NOT NULL,
+		key		TEXT NOT NULL,
+		PRIMARY KEY (id, kind, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS keysByKey ON keys(kind, key)`,
+}
+
+// sqliteMetadataBackend is a metadataBackend which writes records to SQLite databases, one for each JSON file.
+// Only records which were modified are written, and only records modified by other processes are reread.
+//
+// This uses the same SQLite driver as the blob info cache of go.podman.io/image, so most users of this package
+// already link it; the driver requires cgo, and builds without cgo, or with the exclude_metadata_sqlite build tag,
+// only support the JSON backend.
+type sqliteMetadataBackend struct{}
+
+func newSQLiteMetadataBackend() (metadataBackend, error) {
+	return sqliteMetadataBackend{}, nil
+}
+
+func (sqliteMetadataBackend) read(path string, records any) error {
+	return readMetadata(path, records)
+}
+
+func (sqliteMetadataBackend) write(path string, records any, modified []string, noSync bool) (time.Time, error) {
+	db, err := openSQLiteMetadataDB(path, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := db.write(records, modified, noSync); err != nil {
+		return time.Time{}, fmt.Errorf("writing records for %q: %w", path, err)
+	}
+	// The JSON file, if any, is now ignored; remove it to avoid confusion.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logrus.Debugf("Removing obsolete %q: %v", path, err)
+	}
+	return time.Time{}, nil
+}
+
+func (sqliteMetadataBackend) needsMigration(path string) (bool, error) {
+	exists, err := sqliteMetadataExists(path)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// sqliteMetadataCache is a copy of the records in a database.
+type sqliteMetadataCache struct {
+	generation int64
+	ids        []string          // In the order of records in the database
+	data       map[string][]byte // Keyed by ID
+	// decoded contains records decoded from data, keyed by ID; it may be nil, and it may not contain all records.
+	// The records are never modified, callers get copies.
+	decoded map[string]any
+}
+
+// sqliteMetadataDB is an open database.
+type sqliteMetadataDB struct {
+	db   *sql.DB
+	file os.FileInfo // Used to detect that the database file has been removed or replaced.
+
+	lock sync.Mutex
+	// The following fields can only be accessed with lock held.
+	cache *sqliteMetadataCache // nil if not known; may be outdated.
+}
+
+var (
+	sqliteMetadataDBsLock sync.Mutex
+	sqliteMetadataDBs     = map[string]*sqliteMetadataDB{} // Keyed by database path
+)
+
+// openSQLiteMetadataDB returns the database storing records for the JSON file at path.
+// If it does not exist, it is created if create is true, otherwise openSQLiteMetadataDB returns nil.
+// The database stays open for the lifetime of the process.
+func openSQLiteMetadataDB(path string, create bool) (*sqliteMetadataDB, error) {
+	sqliteMetadataDBsLock.Lock()
+	defer sqliteMetadataDBsLock.Unlock()
+
+	dbPath := sqliteMetadataPath(path)
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if db, ok := sqliteMetadataDBs[dbPath]; ok {
+		if fi != nil && os.SameFile(fi, db.file) {
+			return db, nil
+		}
+		db.db.Close()
+		delete(sqliteMetadataDBs, dbPath)
+	}
+	if fi == nil && !create {
+		return nil, nil
+	}
+	sqlDB, err := sql.Open("sqlite3", dbPath+sqliteMetadataOptions)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", dbPath, err)
+	}
+	db := &sqliteMetadataDB{db: sqlDB}
+	if create {
+		if err := db.transaction(false, func(tx *sql.Tx) error {
+			for _, stmt := range sqliteMetadataSchema {
+				if _, err := tx.Exec(stmt); err != nil {
+					return fmt.Errorf("creating database schema: %w", err)
+				}
+			}
+			return nil
+		}); err != nil {
+			sqlDB.Close()
+			return nil, fmt.Errorf("initializing %q: %w", dbPath, err)
+		}
+	}
+	if fi == nil {
+		if fi, err = os.Stat(dbPath); err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+	}
+	db.file = fi
+	sqliteMetadataDBs[dbPath] = db
+	return db, nil
+}
+
+// transaction calls fn within a read-write transaction in db.
+// If noSync, the transaction is not flushed to disk, and the database may be lost if the system crashes.
+func (db *sqliteMetadataDB) transaction(noSync bool, fn func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Force an fsync after each transaction, unless noSync (https://www.sqlite.org/pragma.html#pragma_synchronous).
+	// This is a property of the connection, so set it for every transaction.
+	synchronous := "FULL"
+	if noSync {
+		synchronous = "OFF"
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA synchronous = "+synchronous); err != nil {
+		return fmt.Errorf("setting synchronous mode: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			if err := tx.Rollback(); err != nil {
+				logrus.Errorf("Rolling back transaction: %v", err)
+			}
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	succeeded = true
+	return nil
+}
+
+// sqlQuerier is implemented by *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// sqliteMetadataState is the contents of the state table.
+type sqliteMetadataState struct {
+	active bool
+	// generation never decreases, even if records are deleted and written again, so that caches of deleted
+	// records are never mistaken for current ones. It is -1 if nothing was written yet.
+	generation     int64
+	deletionsSince int64 // Deletions in generations after deletionsSince are recorded.
+}
+
+// readSQLiteMetadataState returns the state of the database.
+func readSQLiteMetadataState(q sqlQuerier) (sqliteMetadataState, error) {
+	state := sqliteMetadataState{}
+	if err := q.QueryRow(`SELECT active, generation, deletionsSince FROM state WHERE singleton = 0`).Scan(&state.active, &state.generation, &state.deletionsSince); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sqliteMetadataState{active: false, generation: -1, deletionsSince: -1}, nil
+		}
+		return sqliteMetadataState{}, err
+	}
+	return state, nil
+}
+
+// refreshLocked returns an up-to-date cache of records in state, reading only records modified since
+// the previous cached generation, if possible.
+// The caller must hold db.lock.
+func (db *sqliteMetadataDB) refreshLocked(q sqlQuerier, state sqliteMetadataState) (*sqliteMetadataCache, error) {
+	cache := db.cache
+	if cache != nil && cache.generation == state.generation {
+		return cache, nil
+	}
+	// db.cache is modified in place, so drop it if we fail.
+	db.cache = nil
+	if cache == nil || cache.generation > state.generation || cache.generation < state.deletionsSince {
+		cache = &sqliteMetadataCache{data: map[string][]byte{}}
+		if err := cache.addRecords(q, `SELECT id, data FROM records ORDER BY rowid`); err != nil {
+			return nil, err
+		}
+	} else {
+		deleted, err := queryStrings(q, `SELECT id FROM deletions WHERE generation > ?`, cache.generation)
+		if err != nil {
+			return nil, err
+		}
+		cache.remove(deleted)
+		// Records modified in place keep their rowid, and new records have a larger rowid than all existing
+		// ones, so this keeps cache.ids in the order of the records in the database.
+		if err := cache.addRecords(q, `SELECT id, data FROM records WHERE generation > ? ORDER BY rowid`, cache.generation); err != nil {
+			return nil, err
+		}
+	}
+	cache.generation = state.generation
+	db.cache = cache
+	return cache, nil
+}
+
+// addRecords adds records returned by query, which must return the ID and data of records, to cache.
+func (cache *sqliteMetadataCache) addRecords(q sqlQuerier, query string, args ...any) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			return err
+		}
+		cache.set(id, data)
+	}
+	return rows.Err()
+}
+
+// set adds or updates a record in cache.
+func (cache *sqliteMetadataCache) set(id string, data []byte) {
+	if _, ok := cache.data[id]; !ok {
+		cache.ids = append(cache.ids, id)
+	}
+	cache.data[id] = data
+	delete(cache.decoded, id)
+}
+
+// remove removes records with ids from cache.
+func (cache *sqliteMetadataCache) remove(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		delete(cache.data, id)
+		delete(cache.decoded, id)
+	}
+	cache.ids = slices.DeleteFunc(cache.ids, func(id string) bool {
+		_, ok := cache.data[id]
+		return !ok
+	})
+}
+
+// queryStrings returns the single-column results of query.
+func queryStrings(q sqlQuerier, query string, args ...any) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// read decodes the records into records, and returns true, if the database is active.
+func (db *sqliteMetadataDB) read(records any) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	state, err := readSQLiteMetadataState(db.db)
+	if err != nil || !state.active {
+		return false, err
+	}
+	cache, err := db.refreshLocked(db.db, state)
+	if err != nil {
+		return false, err
+	}
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Slice || v.Elem().Type().Elem().Kind() != reflect.Pointer {
+		return false, fmt.Errorf("internal error: unexpected records type %T", records)
+	}
+	sliceType := v.Elem().Type()
+	recordType := sliceType.Elem() // A pointer type
+	if cache.decoded == nil {
+		cache.decoded = map[string]any{}
+	}
+	res := reflect.MakeSlice(sliceType, 0, len(cache.ids))
+	// Only records modified since the previous read are decoded; the others are copied from the cache.
+	for _, id := range cache.ids {
+		record, ok := cache.decoded[id]
+		if !ok || reflect.TypeOf(record) != recordType {
+			decoded := reflect.New(recordType.Elem())
+			if err := json.Unmarshal(cache.data[id], decoded.Interface()); err != nil {
+				return false, fmt.Errorf("decoding record %q: %w", id, err)
+			}
+			record = decoded.Interface()
+			cache.decoded[id] = record
+		}
+		recordCopy, err := sqliteMetadataCopy(record)
+		if err != nil {
+			return false, err
+		}
+		res = reflect.Append(res, reflect.ValueOf(recordCopy))
+	}
+	v.Elem().Set(res)
+	return true, nil
+}
+
+// sqliteMetadataCopy returns a copy of record, a pointer to a record.
+func sqliteMetadataCopy(record any) (any, error) {
+	switch r := record.(type) {
+	case *Layer:
+		return copyLayer(r), nil
+	case *Image:
+		return copyImage(r), nil
+	case *Container:
+		return copyContainer(r), nil
+	default:
+		return nil, fmt.Errorf("internal error: unexpected record type %T", record)
+	}
+}
+
+// sqliteMetadataID returns the ID of a record.
+func sqliteMetadataID(record any) (string, error) {
+	switch r := record.(type) {
+	case *Layer:
+		return r.ID, nil
+	case *Image:
+		return r.ID, nil
+	case *Container:
+		return r.ID, nil
+	default:
+		return "", fmt.Errorf("internal error: unexpected record type %T", record)
+	}
+}
+
+// sqliteMetadataKeys returns the keys by which record can be looked up, keyed by kind.
+func sqliteMetadataKeys(record any) (map[metadataKeyKind][]string, error) {
+	switch r := record.(type) {
+	case *Layer:
+		keys := map[metadataKeyKind][]string{metadataKeyName: r.Names}
+		for kind, d := range map[metadataKeyKind]digest.Digest{
+			metadataKeyCompressedDigest:   r.CompressedDigest,
+			metadataKeyUncompressedDigest: r.UncompressedDigest,
+			metadataKeyTOCDigest:          r.TOCDigest,
+		} {
+			if d != "" {
+				keys[kind] = []string{d.String()}
+			}
+		}
+		return keys, nil
+	case *Image:
+		keys := map[metadataKeyKind][]string{metadataKeyName: r.Names}
+		// r.Digests is computed by the image store, and includes r.Digest.
+		for _, d := range r.Digests {
+			keys[metadataKeyDigest] = append(keys[metadataKeyDigest], d.String())
+		}
+		if r.Digest != "" && !slices.Contains(r.Digests, r.Digest) {
+			keys[metadataKeyDigest] = append(keys[metadataKeyDigest], r.Digest.String())
+		}
+		return keys, nil
+	case *Container:
+		return map[metadataKeyKind][]string{metadataKeyName: r.Names}, nil
+	default:
+		return nil, fmt.Errorf("internal error: unexpected record type %T", record)
+	}
+}
+
+// write updates the records in the database to match records, a slice of pointers to records.
+// If modified is not nil, it contains the IDs of all records which were added, modified or removed
+// since records were last read or written, and only those records are written.
+// If noSync, the records need not be preserved if the system crashes.
+func (db *sqliteMetadataDB) write(records any, modified []string, noSync bool) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("internal error: unexpected records type %T", records)
+	}
+	var newCache *sqliteMetadataCache
+	err := db.transaction(noSync, func(tx *sql.Tx) error {
+		state, err := readSQLiteMetadataState(tx)
+		if err != nil {
+			return err
+		}
+		generation := state.generation + 1
+		if state.active && modified != nil {
+			newCache, err = db.writeModifiedLocked(tx, state, generation, v, modified)
+		} else {
+			newCache, err = db.writeAllLocked(tx, state, generation, v)
+		}
+		if err != nil {
+			return err
+		}
+
+		deletionsSince := state.deletionsSince
+		if generation-deletionsSince > 2*sqliteMetadataDeletionsKept {
+			deletionsSince = generation - sqliteMetadataDeletionsKept
+			if _, err := tx.Exec(`DELETE FROM deletions WHERE generation <= ?`, deletionsSince); err != nil {
+				return fmt.Errorf("pruning deletions: %w", err)
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO state(singleton, active, generation, deletionsSince) VALUES (0, 1, ?, ?) `+
+			`ON CONFLICT(singleton) DO UPDATE SET active = 1, generation = excluded.generation, deletionsSince = excluded.deletionsSince`,
+			generation, deletionsSince); err != nil {
+			return fmt.Errorf("updating generation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		db.cache = nil // The cache might have been updated to a state which was rolled back.
+		return err
+	}
+	db.cache = newCache
+	return nil
+}
+
+// writeAllLocked writes all records in v which differ from the database, removes records which are not in v,
+// and returns a cache of the records at generation.
+// The caller must hold db.lock.
+func (db *sqliteMetadataDB) writeAllLocked(tx *sql.Tx, state sqliteMetadataState, generation int64, v reflect.Value) (*sqliteMetadataCache, error) {
+	cache := &sqliteMetadataCache{data: map[string][]byte{}}
+	if state.active {
+		var err error
+		if cache, err = db.refreshLocked(tx, state); err != nil {
+			return nil, err
+		}
+	}
+	newCache := &sqliteMetadataCache{generation: generation, data: map[string][]byte{}}
+	for i := range v.Len() {
+		record := v.Index(i).Interface()
+		id, err := sqliteMetadataID(record)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := newCache.data[id]; ok {
+			continue // Should never happen, but don't let a duplicate break the database constraints.
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		newCache.set(id, data)
+		if old, ok := cache.data[id]; ok && bytes.Equal(old, data) {
+			if decoded, ok := cache.decoded[id]; ok {
+				if newCache.decoded == nil {
+					newCache.decoded = map[string]any{}
+				}
+				newCache.decoded[id] = decoded
+			}
+			continue
+		}
+		if err := putSQLiteMetadataRecord(tx, id, generation, record, data); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range cache.ids {
+		if _, ok := newCache.data[id]; !ok {
+			if err := deleteSQLiteMetadataRecord(tx, id, generation); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return newCache, nil
+}
+
+// writeModifiedLocked writes records in v with IDs in modified, removes records with IDs in modified which
+// are not in v, and returns an updated cache of the records at generation, or nil if the cache was outdated.
+// The caller must hold db.lock.
+func (db *sqliteMetadataDB) writeModifiedLocked(tx *sql.Tx, state sqliteMetadataState, generation int64, v reflect.Value, modified []string) (*sqliteMetadataCache, error) {
+	cache := db.cache // Modified in place; the caller drops it if we fail.
+	if cache != nil && cache.generation != state.generation {
+		cache = nil
+	}
+	pending := make(map[string]struct{}, len(modified))
+	for _, id := range modified {
+		pending[id] = struct{}{}
+	}
+	for i := range v.Len() {
+		record := v.Index(i).Interface()
+		id, err := sqliteMetadataID(record)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := pending[id]; !ok {
+			continue
+		}
+		delete(pending, id)
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		if err := putSQLiteMetadataRecord(tx, id, generation, record, data); err != nil {
+			return nil, err
+		}
+		if cache != nil {
+			cache.set(id, data)
+		}
+	}
+	deleted := make([]string, 0, len(pending))
+	for id := range pending {
+		if err := deleteSQLiteMetadataRecord(tx, id, generation); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	if cache != nil {
+		cache.remove(deleted)
+		cache.generation = generation
+	}
+	return cache, nil
+}
+
+// putSQLiteMetadataRecord adds or updates record id, with data encoding record, and its keys.
+func putSQLiteMetadataRecord(tx *sql.Tx, id string, generation int64, record any, data []byte) error {
+	// Use an upsert instead of INSERT OR REPLACE, to preserve the rowid, and thus the order of records.
+	if _, err := tx.Exec(`INSERT INTO records(id, generation, data) VALUES (?, ?, ?) `+
+		`ON CONFLICT(id) DO UPDATE SET generation = excluded.generation, data = excluded.data`,
+		id, generation, data); err != nil {
+		return fmt.Errorf("updating record %q: %w", id, err)
+	}
+	keys, err := sqliteMetadataKeys(record)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM keys WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("updating keys of record %q: %w", id, err)
+	}
+	for kind, values := range keys {
+		for _, key := range values {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO keys(id, kind, key) VALUES (?, ?, ?)`, id, string(kind), key); err != nil {
+				return fmt.Errorf("updating keys of record %q: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteSQLiteMetadataRecord removes record id, if it exists, and records the deletion.
+func deleteSQLiteMetadataRecord(tx *sql.Tx, id string, generation int64) error {
+	res, err := tx.Exec(`DELETE FROM records WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("deleting record %q: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting record %q: %w", id, err)
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`DELETE FROM keys WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("deleting keys of record %q: %w", id, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO deletions(id, generation) VALUES (?, ?) `+
+		`ON CONFLICT(id) DO UPDATE SET generation = excluded.generation`, id, generation); err != nil {
+		return fmt.Errorf("recording deletion of %q: %w", id, err)
+	}
+	return nil
+}
+
+// delete removes all records, and marks the database as inactive.
+func (db *sqliteMetadataDB) delete() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.cache = nil
+	return db.transaction(false, func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`DELETE FROM records`,
+			`DELETE FROM keys`,
+			`DELETE FROM deletions`,
+			// Deletions are no longer recorded, so force all readers to read the database again.
+			`UPDATE state SET active = 0, generation = generation + 1, deletionsSince = generation + 1`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// readSQLiteMetadata decodes the records stored for path in the SQLite database into records, and returns true,
+// if the database exists and contains records for path.
+func readSQLiteMetadata(path string, records any) (bool, error) {
+	db, err := openSQLiteMetadataDB(path, false)
+	if err != nil || db == nil {
+		return false, err
+	}
+	found, err := db.read(records)
+	if err != nil {
+		return false, fmt.Errorf("reading records for %q: %w", path, err)
+	}
+	return found, nil
+}
+
+// lookupSQLiteMetadata returns the IDs of records stored for path in the SQLite database which have key of kind,
+// in the order of the records, and true, if the database exists and contains records for path.
+func lookupSQLiteMetadata(path string, kind metadataKeyKind, key string) ([]string, bool, error) {
+	db, err := openSQLiteMetadataDB(path, false)
+	if err != nil || db == nil {
+		return nil, false, err
+	}
+	state, err := readSQLiteMetadataState(db.db)
+	if err != nil || !state.active {
+		return nil, false, err
+	}
+	ids, err := queryStrings(db.db, `SELECT keys.id FROM keys JOIN records USING (id) WHERE kind = ? AND key = ? ORDER BY records.rowid`,
+		string(kind), key)
+	if err != nil {
+		return nil, false, fmt.Errorf("looking up records for %q: %w", path, err)
+	}
+	return ids, true, nil
+}
+
+// sqliteMetadataExists returns true if the SQLite database exists and contains records for path.
+func sqliteMetadataExists(path string) (bool, error) {
+	db, err := openSQLiteMetadataDB(path, false)
+	if err != nil || db == nil {
+		return false, err
+	}
+	state, err := readSQLiteMetadataState(db.db)
+	return state.active, err
+}
+
+// deleteSQLiteMetadata removes records for path from the SQLite database, if any.
+func deleteSQLiteMetadata(path string) error {
+	if exists, err := sqliteMetadataExists(path); err != nil || !exists {
+		return err
+	}
+	db, err := openSQLiteMetadataDB(path, false)
+	if err != nil {
+		return err
+	}
+	if err := db.delete(); err != nil {
+		return fmt.Errorf("removing records for %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+//go:build cgo && !exclude_metadata_sqlite
+
+package storage
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+func TestSQLiteMetadataDB(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "images.json")
+
+	found, err := readSQLiteMetadata(path, &[]*Image{})
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.NoFileExists(t, filepath.Join(dir, "images.sqlite"))
+
+	db, err := openSQLiteMetadataDB(path, true)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "images.sqlite"))
+	images := []*Image{{ID: "a", Names: []string{"a1"}}, {ID: "b"}, {ID: "c"}}
+	require.NoError(t, db.write(images, nil, false))
+	state, err := readSQLiteMetadataState(db.db)
+	require.NoError(t, err)
+	assert.True(t, state.active)
+	staleCache := *db.cache
+	staleCache.ids = slices.Clone(staleCache.ids)
+	staleCache.data = maps.Clone(staleCache.data)
+	// staleCacheCopy returns a copy of staleCache, as if it were the cache of another process.
+	staleCacheCopy := func() *sqliteMetadataCache {
+		c := staleCache
+		c.ids = slices.Clone(c.ids)
+		c.data = maps.Clone(c.data)
+		c.decoded = nil
+		return &c
+	}
+	readAndCheck := func(expected []*Image) {
+		var read []*Image
+		found, err := readSQLiteMetadata(path, &read)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, expected, read)
+	}
+
+	// Records are updated in place, and keep their order.
+	a := &Image{ID: "a", Names: []string{"a2"}}
+	c := &Image{ID: "c", Metadata: "m"}
+	d := &Image{ID: "d"}
+	require.NoError(t, db.write([]*Image{a, c, d}, nil, false))
+	readAndCheck([]*Image{a, c, d})
+
+	// With a list of modified records, only those are written.
+	a.Metadata = "written"
+	b := &Image{ID: "b", Metadata: "new"}
+	c.Metadata = "not written"
+	require.NoError(t, db.write([]*Image{a, b, c, d}, []string{"a", "b"}, true))
+	c.Metadata = "m"
+	readAndCheck([]*Image{a, c, d, b}) // b was added again, after the other records
+	require.NoError(t, db.write([]*Image{a, b, c}, []string{"d"}, false))
+	readAndCheck([]*Image{a, c, b})
+
+	// Records are looked up by the index.
+	lookupAndCheck := func(kind metadataKeyKind, key string, expected []string) {
+		ids, found, err := lookupSQLiteMetadata(path, kind, key)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, expected, ids)
+	}
+	lookupAndCheck(metadataKeyName, "a2", []string{"a"})
+	lookupAndCheck(metadataKeyName, "a1", []string{})
+	b.Names = []string{"b1"}
+	b.Digests = []digest.Digest{digest.FromString("b")}
+	require.NoError(t, db.write([]*Image{a, c, b}, []string{"b"}, false))
+	lookupAndCheck(metadataKeyName, "b1", []string{"b"})
+	lookupAndCheck(metadataKeyDigest, digest.FromString("b").String(), []string{"b"})
+	b.Names = nil
+	require.NoError(t, db.write([]*Image{a, c, b}, nil, false))
+	lookupAndCheck(metadataKeyName, "b1", []string{})
+	lookupAndCheck(metadataKeyDigest, digest.FromString("b").String(), []string{"b"})
+	b.Digests = nil // Not stored, and recomputed by the image store.
+
+	// Callers get copies of the cached records.
+	var read []*Image
+	_, err = readSQLiteMetadata(path, &read)
+	require.NoError(t, err)
+	read[0].Names[0] = "modified"
+	readAndCheck([]*Image{a, c, b})
+
+	// Records read by another process are not affected by our cache, and another process with an older
+	// cache reads only the records modified since.
+	for _, cache := range []*sqliteMetadataCache{nil, staleCacheCopy()} {
+		db.cache = cache
+		readAndCheck([]*Image{a, c, b})
+	}
+
+	// Caches older than the recorded deletions are read again.
+	_, err = db.db.Exec(`UPDATE state SET deletionsSince = generation`)
+	require.NoError(t, err)
+	db.cache = staleCacheCopy()
+	db.cache.ids = append(db.cache.ids, "x")
+	db.cache.data["x"] = []byte(`{"id":"x"}`)
+	readAndCheck([]*Image{a, c, b})
+
+	require.NoError(t, deleteSQLiteMetadata(path))
+	exists, err := sqliteMetadataExists(path)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	newState, err := readSQLiteMetadataState(db.db)
+	require.NoError(t, err)
+	assert.False(t, newState.active)
+	assert.Greater(t, newState.generation, state.generation)
+	var count int
+	require.NoError(t, db.db.QueryRow(`SELECT COUNT(*) FROM records`).Scan(&count))
+	assert.Zero(t, count)
+	require.NoError(t, db.db.QueryRow(`SELECT COUNT(*) FROM keys`).Scan(&count))
+	assert.Zero(t, count)
+	_, found, err = lookupSQLiteMetadata(path, metadataKeyName, "a2")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestSQLiteMetadataMigration(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "images.json")
+
+	store, err := newImageStore(dir, jsonMetadataBackend{})
+	require.NoError(t, err)
+	addTestImage(t, store, "a", []string{"name-a"})
+	assert.FileExists(t, jsonPath)
+
+	// Opening the store with the sqlite backend moves the records to the database.
+	store, err = newImageStore(dir, sqliteMetadataBackend{})
+	require.NoError(t, err)
+	assert.NoFileExists(t, jsonPath)
+	exists, err := sqliteMetadataExists(jsonPath)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	addTestImage(t, store, "b", []string{"name-b"})
+	images, err := store.Images()
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+
+	// A store using the JSON backend still sees the records, and moves them back.
+	store, err = newImageStore(dir, jsonMetadataBackend{})
+	require.NoError(t, err)
+	assert.FileExists(t, jsonPath)
+	exists, err = sqliteMetadataExists(jsonPath)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	migrated, err := store.Images()
+	require.NoError(t, err)
+	require.Len(t, migrated, len(images))
+	for i := range images {
+		assert.Equal(t, images[i].ID, migrated[i].ID)
+		assert.Equal(t, images[i].Names, migrated[i].Names)
+		assert.True(t, images[i].Created.Equal(migrated[i].Created))
+	}
+}
+
+// reopenTestStore shuts down s, and returns a new store using options.
+func reopenTestStore(t *testing.T, s Store, options StoreOptions) Store {
+	_, err := s.Shutdown(true)
+	require.NoError(t, err)
+	s.Free()
+	return newTestStore(t, options)
+}
+
+func TestStoreSQLiteMetadata(t *testing.T) {
+	reexec.Init()
+
+	options := StoreOptions{
+		GraphRoot:       filepath.Join(t.TempDir(), "root"),
+		RunRoot:         filepath.Join(t.TempDir(), "run"),
+		MetadataBackend: metadataBackendSQLite,
+	}
+	s := newTestStore(t, options)
+	_, err := s.CreateLayer("Base", "", nil, "", false, nil)
+	require.NoError(t, err)
+	imageDigest := digest.FromString("image")
+	_, err = s.CreateImage("Image", []string{"image-name"}, "Base", "", &ImageOptions{Digest: imageDigest})
+	require.NoError(t, err)
+	_, err = s.CreateContainer("Container", []string{"container-name"}, "Image", "", "", nil)
+	require.NoError(t, err)
+	// Moving a name to another image modifies both records.
+	_, err = s.CreateImage("Image2", nil, "Base", "", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.AddNames("Image2", []string{"image-name"}))
+	var images []*Image
+	found, err := readSQLiteMetadata(filepath.Join(options.GraphRoot, "vfs-images", "images.json"), &images)
+	require.NoError(t, err)
+	assert.True(t, found)
+	names := map[string][]string{}
+	for _, image := range images {
+		names[image.ID] = image.Names
+	}
+	assert.Equal(t, map[string][]string{"Image": nil, "Image2": {"image-name"}}, names)
+	layersPath := filepath.Join(options.GraphRoot, "vfs-layers", "layers.json")
+	assert.NoFileExists(t, layersPath)
+	assert.NoFileExists(t, filepath.Join(options.GraphRoot, "vfs-images", "images.json"))
+	assert.NoFileExists(t, filepath.Join(options.GraphRoot, "vfs-containers", "containers.json"))
+
+	s = reopenTestStore(t, s, options)
+	for _, id := range []string{"Base", "Image", "Container", "image-name", "container-name"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	assert.False(t, s.Exists("no-such-name"))
+	byDigest, err := s.ImagesByDigest(imageDigest)
+	require.NoError(t, err)
+	require.Len(t, byDigest, 1)
+	assert.Equal(t, "Image", byDigest[0].ID)
+	ids, indexed, err := lookupMetadata(layersPath, metadataKeyName, "no-such-name")
+	require.NoError(t, err)
+	assert.True(t, indexed)
+	assert.Empty(t, ids)
+
+	options.MetadataBackend = ""
+	s = reopenTestStore(t, s, options)
+	defer func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	}()
+	for _, id := range []string{"Base", "Image", "Container", "image-name", "container-name"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	assert.FileExists(t, layersPath)
+	byDigest, err = s.ImagesByDigest(imageDigest)
+	require.NoError(t, err)
+	require.Len(t, byDigest, 1)
+	_, indexed, err = lookupMetadata(layersPath, metadataKeyName, "Base")
+	require.NoError(t, err)
+	assert.False(t, indexed)
+}
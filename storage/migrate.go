@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/types"
+)
+
+// MigrateOptions is used for passing options to a Store's MigrateGraphDriver() method.
+type MigrateOptions struct {
+	// GraphDriverOptions are the options used for the new graph driver.
+	GraphDriverOptions []string
+	// Check selects the checks run on the migrated store before the migration is considered complete.
+	// If nil, the digests of layer diffs and the data items of layers, images and containers are checked.
+	Check *CheckOptions
+	// WipeSource causes all layers, images and containers to be removed from the original graph driver
+	// once the migrated store has been checked, and all of them have been found in it.
+	WipeSource bool
+}
+
+// driverMigrationState records the progress of Store.MigrateGraphDriver, so that an interrupted
+// migration can be resumed.  Objects which exist in the destination store but are not recorded
+// as completed were being created when the migration was interrupted, and are created again.
+type driverMigrationState struct {
+	// Source is the name of the graph driver the store is migrated from.
+	Source     string          `json:"source"`
+	Layers     map[string]bool `json:"layers,omitempty"`
+	Images     map[string]bool `json:"images,omitempty"`
+	Containers map[string]bool `json:"containers,omitempty"`
+}
+
+// driverMigration is a migration in progress.
+type driverMigration struct {
+	source    *store
+	dest      *store
+	statePath string
+	state     driverMigrationState
+}
+
+// saveState records the progress of the migration.
+func (m *driverMigration) saveState() error {
+	data, err := json.Marshal(&m.state)
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(m.statePath, data, 0o600)
+}
+
+// loadState reads the progress of an interrupted migration, if any, or starts a new one.
+func (m *driverMigration) loadState() error {
+	data, err := os.ReadFile(m.statePath)
+	if err == nil {
+		if err := json.Unmarshal(data, &m.state); err != nil {
+			return fmt.Errorf("decoding %q: %w", m.statePath, err)
+		}
+		if m.state.Source != m.source.graphDriverName {
+			return fmt.Errorf("a migration from graph driver %q to %q is in progress", m.state.Source, m.dest.graphDriverName)
+		}
+		logrus.Debugf("Resuming migration from graph driver %q to %q", m.state.Source, m.dest.graphDriverName)
+	} else {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// Objects in the destination store which we didn't create can't be told apart from the ones we are
+		// about to create, so refuse to start unless it is empty.
+		layers, err := m.dest.Layers()
+		if err != nil {
+			return err
+		}
+		images, err := m.dest.Images()
+		if err != nil {
+			return err
+		}
+		containers, err := m.dest.Containers()
+		if err != nil {
+			return err
+		}
+		if len(layers) != 0 || len(images) != 0 || len(containers) != 0 {
+			return fmt.Errorf("the store already contains data for graph driver %q", m.dest.graphDriverName)
+		}
+		m.state.Source = m.source.graphDriverName
+	}
+	if m.state.Layers == nil {
+		m.state.Layers = make(map[string]bool)
+	}
+	if m.state.Images == nil {
+		m.state.Images = make(map[string]bool)
+	}
+	if m.state.Containers == nil {
+		m.state.Containers = make(map[string]bool)
+	}
+	return m.saveState()
+}
+
+// migrateLayer creates layer, and its data items, in the destination store.
+func (m *driverMigration) migrateLayer(layer *Layer) error {
+	if m.state.Layers[layer.ID] {
+		return nil
+	}
+	if m.dest.Exists(layer.ID) {
+		if err := m.dest.DeleteLayer(layer.ID); err != nil {
+			return fmt.Errorf("removing partially migrated layer %q: %w", layer.ID, err)
+		}
+	}
+	uncompressed := archive.Uncompressed
+	rc, err := m.source.Diff("", layer.ID, &DiffOptions{Compression: &uncompressed})
+	if err != nil {
+		return fmt.Errorf("reading diff of layer %q: %w", layer.ID, err)
+	}
+	err = m.dest.recreateLayer(layer, rc)
+	if err2 := rc.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range layer.BigDataNames {
+		if err := func() error {
+			rc, err := m.source.LayerBigData(layer.ID, key)
+			if err != nil {
+				return fmt.Errorf("reading data item %q of layer %q: %w", key, layer.ID, err)
+			}
+			defer rc.Close()
+			return m.dest.SetLayerBigData(layer.ID, key, rc)
+		}(); err != nil {
+			return err
+		}
+	}
+	m.state.Layers[layer.ID] = true
+	return m.saveState()
+}
+
+// migrateImage creates image, and its data items, in the destination store.
+func (m *driverMigration) migrateImage(image *Image) error {
+	if m.state.Images[image.ID] {
+		return nil
+	}
+	if _, err := m.dest.Image(image.ID); err == nil {
+		if _, err := writeToImageStore(m.dest, func() (struct{}, error) {
+			return struct{}{}, m.dest.imageStore.Delete(image.ID)
+		}); err != nil {
+			return fmt.Errorf("removing partially migrated image %q: %w", image.ID, err)
+		}
+	}
+	options := ImageOptions{
+		CreationDate: image.Created,
+		Digest:       image.Digest,
+		NamesHistory: image.NamesHistory,
+		Flags:        image.Flags,
+	}
+	for _, key := range image.BigDataNames {
+		data, err := m.source.ImageBigData(image.ID, key)
+		if err != nil {
+			return fmt.Errorf("reading data item %q of image %q: %w", key, image.ID, err)
+		}
+		options.BigData = append(options.BigData, ImageBigDataOption{Key: key, Data: data, Digest: image.BigDataDigests[key]})
+	}
+	if _, err := m.dest.CreateImage(image.ID, image.Names, image.TopLayer, image.Metadata, &options); err != nil {
+		return fmt.Errorf("creating image %q: %w", image.ID, err)
+	}
+	m.state.Images[image.ID] = true
+	return m.saveState()
+}
+
+// migrateContainer creates container, the contents of its layer, and its data items, in the destination store.
+func (m *driverMigration) migrateContainer(container *Container) error {
+	if m.state.Containers[container.ID] {
+		return nil
+	}
+	if _, err := m.dest.Container(container.ID); err == nil {
+		if err := m.dest.DeleteContainer(container.ID); err != nil {
+			return fmt.Errorf("removing partially migrated container %q: %w", container.ID, err)
+		}
+	}
+	options := ContainerOptions{
+		IDMappingOptions: types.IDMappingOptions{
+			HostUIDMapping: len(container.UIDMap) == 0,
+			HostGIDMapping: len(container.GIDMap) == 0,
+			UIDMap:         container.UIDMap,
+			GIDMap:         container.GIDMap,
+		},
+		Flags: container.Flags,
+	}
+	for _, key := range container.BigDataNames {
+		data, err := m.source.ContainerBigData(container.ID, key)
+		if err != nil {
+			return fmt.Errorf("reading data item %q of container %q: %w", key, container.ID, err)
+		}
+		options.BigData = append(options.BigData, ContainerBigDataOption{Key: key, Data: data})
+	}
+	created, err := m.dest.CreateContainer(container.ID, container.Names, container.ImageID, container.LayerID, container.Metadata, &options)
+	if err != nil {
+		return fmt.Errorf("creating container %q: %w", container.ID, err)
+	}
+	uncompressed := archive.Uncompressed
+	rc, err := m.source.Diff("", container.LayerID, &DiffOptions{Compression: &uncompressed})
+	if err != nil {
+		return fmt.Errorf("reading diff of the layer of container %q: %w", container.ID, err)
+	}
+	_, err = m.dest.ApplyDiff(created.LayerID, rc)
+	if err2 := rc.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("applying diff to the layer of container %q: %w", container.ID, err)
+	}
+	m.state.Containers[container.ID] = true
+	return m.saveState()
+}
+
+// check runs options on the destination store, and returns an error if it finds any problem.
+func (m *driverMigration) check(options *CheckOptions) error {
+	if options == nil {
+		options = &CheckOptions{
+			LayerDigests:  true,
+			LayerData:     true,
+			ImageData:     true,
+			ContainerData: true,
+		}
+	}
+	report, err := m.dest.Check(options)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, kind := range []struct {
+		name   string
+		damage map[string][]error
+	}{
+		{"layer", report.Layers},
+		{"image", report.Images},
+		{"container", report.Containers},
+	} {
+		for id, damage := range kind.damage {
+			if len(damage) > 0 {
+				errs = append(errs, fmt.Errorf("%s %q: %w", kind.name, id, errors.Join(damage...)))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("checking migrated store: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// verify returns an error if any of images or containers does not exist in the destination store.
+func (m *driverMigration) verify(images []Image, containers []Container) error {
+	for _, image := range images {
+		if _, err := m.dest.Image(image.ID); err != nil {
+			return fmt.Errorf("image %q was not migrated: %w", image.ID, err)
+		}
+	}
+	for _, container := range containers {
+		if _, err := m.dest.Container(container.ID); err != nil {
+			return fmt.Errorf("container %q was not migrated: %w", container.ID, err)
+		}
+	}
+	return nil
+}
+
+// migrationSelection returns the layers, images and containers of the read-write stores of s.
+// Layers are returned parents first, and exclude layers of containers and mapped top layers of
+// images, which are recreated when containers are.
+func (s *store) migrationSelection() ([]*Layer, []Image, []Container, error) {
+	rlstore, err := s.getLayerStore()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := rlstore.startReading(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer rlstore.stopReading()
+	if err := s.imageStore.startReading(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer s.imageStore.stopReading()
+	if err := s.containerStore.startReading(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer s.containerStore.stopReading()
+
+	images, err := s.imageStore.Images()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	containers, err := s.containerStore.Containers()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	all, err := rlstore.Layers()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	excluded := make(map[string]struct{})
+	for _, container := range containers {
+		excluded[container.LayerID] = struct{}{}
+	}
+	for _, image := range images {
+		for _, id := range image.MappedTopLayers {
+			excluded[id] = struct{}{}
+		}
+	}
+	byID := make(map[string]*Layer)
+	for i := range all {
+		byID[all[i].ID] = &all[i]
+	}
+	var layers []*Layer
+	added := make(map[string]struct{})
+	for i := range all {
+		// Collect the layer and its parents top to bottom, and add the ones we don't have yet bottom to top.
+		var chain []*Layer
+		for layer := &all[i]; layer != nil; layer = byID[layer.Parent] {
+			if _, ok := added[layer.ID]; ok {
+				break
+			}
+			chain = append(chain, layer)
+		}
+		for _, layer := range slices.Backward(chain) {
+			added[layer.ID] = struct{}{}
+			if _, ok := excluded[layer.ID]; !ok {
+				layers = append(layers, layer)
+			}
+		}
+	}
+	return layers, images, containers, nil
+}
+
+// migrationStoreOptions returns the options of a store sharing the locations and settings of s,
+// using driver with driverOptions.
+func (s *store) migrationStoreOptions(driver string, driverOptions []string) types.StoreOptions {
+	options := types.StoreOptions{
+		RunRoot:            s.runRoot,
+		GraphRoot:          s.graphRoot,
+		ImageStore:         s.imageStoreDir,
+		TransientStore:     s.transientStore,
+		GraphDriverName:    driver,
+		GraphDriverOptions: driverOptions,
+		UIDMap:             s.uidMap,
+		GIDMap:             s.gidMap,
+		RootAutoNsUser:     s.autoUsernsUser,
+		AutoNsMinSize:      s.autoNsMinSize,
+		AutoNsMaxSize:      s.autoNsMaxSize,
+		PullOptions:        s.pullOptions,
+		DisableVolatile:    s.disableVolatile,
+		// ImageEvictionMaxSize and ImageEvictionMaxAge are left unset, so that images being migrated
+		// are never pruned from the destination store.
+	}
+	if _, ok := s.metadataBackend.(jsonMetadataBackend); !ok {
+		options.MetadataBackend = metadataBackendSQLite
+	}
+	return options
+}
+
+func (s *store) MigrateGraphDriver(driver string, options *MigrateOptions) (_ Store, retErr error) {
+	if options == nil {
+		options = &MigrateOptions{}
+	}
+	if driver == "" || driver == s.graphDriverName {
+		return nil, fmt.Errorf("migrating to graph driver %q: the store already uses graph driver %q", driver, s.graphDriverName)
+	}
+	destStore, err := GetStore(s.migrationStoreOptions(driver, options.GraphDriverOptions))
+	if err != nil {
+		return nil, fmt.Errorf("initializing graph driver %q: %w", driver, err)
+	}
+	dest, ok := destStore.(*store)
+	if !ok || dest == s {
+		return nil, fmt.Errorf("migrating to graph driver %q: the store already uses graph driver %q", driver, s.graphDriverName)
+	}
+	defer func() {
+		if retErr != nil {
+			if _, err := dest.Shutdown(false); err != nil {
+				logrus.Debugf("Shutting down graph driver %q: %v", dest.graphDriverName, err)
+			}
+			dest.Free()
+		}
+	}()
+
+	m := driverMigration{
+		source:    s,
+		dest:      dest,
+		statePath: filepath.Join(s.graphRoot, dest.graphDriverName+"-migration.json"),
+	}
+	if err := m.loadState(); err != nil {
+		return nil, err
+	}
+	layers, images, containers, err := s.migrationSelection()
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		if err := m.migrateLayer(layer); err != nil {
+			return nil, err
+		}
+	}
+	for i := range images {
+		if err := m.migrateImage(&images[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i := range containers {
+		if err := m.migrateContainer(&containers[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.check(options.Check); err != nil {
+		return nil, err
+	}
+	if options.WipeSource {
+		if err := m.verify(images, containers); err != nil {
+			return nil, err
+		}
+		if err := s.Wipe(); err != nil {
+			return nil, fmt.Errorf("removing data of graph driver %q: %w", s.graphDriverName, err)
+		}
+	}
+	if err := os.Remove(m.statePath); err != nil {
+		return nil, err
+	}
+	return dest, nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+func TestMigrateGraphDriver(t *testing.T) {
+	reexec.Init()
+
+	src := newTestStore(t, StoreOptions{})
+	shutdownTestStore(t, src)
+
+	_, _, err := src.PutLayer("Base", "", []string{"base"}, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "src\n")))
+	require.NoError(t, err)
+	base, err := src.Layer("Base")
+	require.NoError(t, err)
+	_, _, err = src.PutLayer("Top", "Base", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "bin/true", "#!/bin/sh\n")))
+	require.NoError(t, err)
+	require.NoError(t, src.SetLayerBigData("Top", "layer-data", bytes.NewReader([]byte("layer data"))))
+	// The top layer was pulled as a zstd:chunked layer, and is identified by its TOC digest.
+	top, err := src.Layer("Top")
+	require.NoError(t, err)
+	top.TOCDigest = digest.FromString("toc")
+	top.CompressionType = archive.Zstd
+	_, err = writeToLayerStore(src.(*store), func(rlstore rwLayerStore) (struct{}, error) {
+		return struct{}{}, rlstore.restoreDigests("Top", top)
+	})
+	require.NoError(t, err)
+	_, err = src.CreateImage("Image", []string{"example.com/image:latest"}, "Top", "image metadata", &ImageOptions{
+		BigData: []ImageBigDataOption{{Key: "config", Data: []byte(`{"config":{}}`)}},
+	})
+	require.NoError(t, err)
+	_, err = src.CreateContainer("Container", []string{"container"}, "Image", "", "container metadata", nil)
+	require.NoError(t, err)
+	container, err := src.Container("Container")
+	require.NoError(t, err)
+	_, err = src.ApplyDiff(container.LayerID, bytes.NewReader(generateTestDiff(t, "run/state", "running\n")))
+	require.NoError(t, err)
+	require.NoError(t, src.SetContainerBigData("Container", "container-data", []byte("container data")))
+
+	// Migrating to the driver in use is refused.
+	_, err = src.MigrateGraphDriver("vfs", nil)
+	assert.Error(t, err)
+
+	// Images being migrated are never pruned.
+	src.(*store).imageEviction = PruneImagesOptions{MaxSize: 1}
+	migrationOptions := src.(*store).migrationStoreOptions("overlay", nil)
+	assert.Zero(t, migrationOptions.ImageEvictionMaxSize)
+	assert.Zero(t, migrationOptions.ImageEvictionMaxAge)
+
+	// Simulate an interrupted migration which was creating the base layer.
+	partial, err := GetStore(migrationOptions)
+	if errors.Is(err, graphdriver.ErrNotSupported) || errors.Is(err, graphdriver.ErrIncompatibleFS) {
+		t.Skipf("overlay is not supported: %v", err)
+	}
+	require.NoError(t, err)
+	_, _, err = partial.PutLayer("Base", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "partial\n")))
+	require.NoError(t, err)
+	statePath := filepath.Join(src.GraphRoot(), partial.GraphDriverName()+"-migration.json")
+	require.NoError(t, os.WriteFile(statePath, []byte(`{"source":"vfs"}`), 0o600))
+	_, err = partial.Shutdown(true)
+	require.NoError(t, err)
+	partial.Free()
+
+	dest, err := src.MigrateGraphDriver("overlay", nil)
+	require.NoError(t, err)
+	shutdownTestStore(t, dest)
+	assert.Equal(t, "overlay", dest.GraphDriverName())
+	assert.NoFileExists(t, statePath)
+
+	destBase, err := dest.Layer("Base")
+	require.NoError(t, err)
+	assert.Equal(t, base.Names, destBase.Names)
+	assert.Equal(t, base.UncompressedDigest, destBase.UncompressedDigest)
+	destTop, err := dest.Layer("Top")
+	require.NoError(t, err)
+	assert.Equal(t, top.TOCDigest, destTop.TOCDigest)
+	assert.Equal(t, top.UncompressedDigest, destTop.UncompressedDigest)
+	assert.Equal(t, top.CompressedDigest, destTop.CompressedDigest)
+	assert.Equal(t, top.CompressionType, destTop.CompressionType)
+	data, err := dest.LayerBigData("Top", "layer-data")
+	require.NoError(t, err)
+	defer data.Close()
+	image, err := dest.Image("example.com/image:latest")
+	require.NoError(t, err)
+	assert.Equal(t, "Image", image.ID)
+	assert.Equal(t, "Top", image.TopLayer)
+	config, err := dest.ImageBigData("Image", "config")
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"config":{}}`), config)
+	destContainer, err := dest.Container("container")
+	require.NoError(t, err)
+	assert.Equal(t, "Image", destContainer.ImageID)
+	assert.Equal(t, container.LayerID, destContainer.LayerID)
+	containerData, err := dest.ContainerBigData("Container", "container-data")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("container data"), containerData)
+	changes, err := dest.Changes("", destContainer.LayerID)
+	require.NoError(t, err)
+	assert.Contains(t, changes, archive.Change{Path: "/run/state", Kind: archive.ChangeAdd})
+
+	// The data of the original driver is kept unless WipeSource is set.
+	_, err = src.Layer("Base")
+	assert.NoError(t, err)
+}
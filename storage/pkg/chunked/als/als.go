@@ -0,0 +1,644 @@
+// Package als is a reference implementation of an Additional Layer Store
+// (see the additionallayerstore option of the overlay graph driver) for zstd:chunked layers.
+//
+// Unlike production Additional Layer Stores, this implementation does not use FUSE.
+// Prepare only fetches the TOC of a layer; the contents of individual files can then be read
+// on demand using Open, which fetches only the byte ranges containing the requested file.
+// The layer is visible to the overlay driver right after Prepare. Without a filesystem server,
+// accesses by the graph driver can’t be intercepted, so the driver calls this package (via a
+// graphdriver.AdditionalLayerMaterializer) before first using the layer contents; that, or an
+// explicit Materialize call, fetches the parts of the blob not yet available locally, and creates
+// the layer contents using the chunked TOC. Afterwards, the layer is served from the store directory
+// to every consumer (and every image) that refers to the same TOC, without any further network access.
+//
+// To fetch the blob on first use, possibly in a different process, a layer prepared from a HTTPBlobSource
+// is fetched again from the same URL; other layers are fetched using the function set by SetSourceOpener.
+//
+// This API is experimental and can be changed without bumping the major version number.
+package als
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/internal/driver"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chunked"
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+	storagePath "go.podman.io/storage/pkg/chunked/internal/path"
+	"go.podman.io/storage/pkg/chunked/toc"
+	"go.podman.io/storage/pkg/fileutils"
+	"go.podman.io/storage/pkg/ioutils"
+	"go.podman.io/storage/pkg/lockfile"
+)
+
+const (
+	// maxTOCSize is the maximum size of a TOC we are willing to process in memory.
+	maxTOCSize = (1 << 20) * 150
+
+	// The names of the per-layer items the overlay driver expects.
+	diffName = "diff"
+	infoName = "info"
+	blobName = "blob"
+
+	// The names of the per-layer items used by this implementation.
+	tocName         = "toc.json"     // The TOC, verified against the TOC digest.
+	partialBlobName = "blob.partial" // A sparse copy of the blob, with the ranges listed in rangesName; renamed to blobName by Materialize.
+	rangesName      = "ranges.json"  // The byte ranges of partialBlobName which have been fetched.
+	sourceName      = "source.json"  // The layerSource to fetch the blob from when the layer is first used.
+	lockName        = "lock"         // Serializes Open and Materialize.
+)
+
+// ErrUnsupportedLayer is returned by Prepare if the layer is not in a format this store can serve.
+var ErrUnsupportedLayer = errors.New("layer is not a zstd:chunked layer")
+
+// layerSource records where the blob of a layer can be fetched from when the layer is first used.
+type layerSource struct {
+	Ref string `json:"ref"`           // The image reference passed to Prepare.
+	URL string `json:"url,omitempty"` // Set if the layer was prepared from a HTTPBlobSource.
+}
+
+// SourceOpener returns a source for the blob blobDigest, of size blobSize, of the image ref,
+// and a function to close the source.
+type SourceOpener func(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64) (chunked.ImageSourceSeekable, func() error, error)
+
+var sourceOpener SourceOpener
+
+// SetSourceOpener sets the function used to fetch the blobs of layers which were not prepared from a HTTPBlobSource,
+// when they are materialized on first use by the graph driver.
+// It is suitable for package’s init() sections.
+func SetSourceOpener(opener SourceOpener) {
+	sourceOpener = opener
+}
+
+func init() {
+	graphdriver.RegisterAdditionalLayerMaterializer(materializer{})
+}
+
+// materializer is a graphdriver.AdditionalLayerMaterializer for layers prepared by Store.Prepare.
+type materializer struct{}
+
+// Pending implements graphdriver.AdditionalLayerMaterializer.
+func (materializer) Pending(path string) bool {
+	if err := fileutils.Exists(filepath.Join(path, sourceName)); err != nil {
+		return false
+	}
+	return layerPrepared(path) && !layerExists(path)
+}
+
+// Materialize implements graphdriver.AdditionalLayerMaterializer.
+func (materializer) Materialize(path string) error {
+	data, err := os.ReadFile(filepath.Join(path, sourceName))
+	if err != nil {
+		return err
+	}
+	var source layerSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return fmt.Errorf("parsing %q: %w", filepath.Join(path, sourceName), err)
+	}
+	_, info, err := readLayerMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var iss chunked.ImageSourceSeekable
+	switch {
+	case source.URL != "":
+		iss = NewHTTPBlobSource(ctx, nil, source.URL)
+	case sourceOpener != nil:
+		s, closeSource, err := sourceOpener(ctx, source.Ref, info.CompressedDigest, info.CompressedSize)
+		if err != nil {
+			return fmt.Errorf("opening source of blob %q: %w", info.CompressedDigest, err)
+		}
+		defer func() {
+			if err := closeSource(); err != nil {
+				logrus.Warnf("Closing source of blob %q: %v", info.CompressedDigest, err)
+			}
+		}()
+		iss = s
+	default:
+		return fmt.Errorf("no source for blob %q of %q is available", info.CompressedDigest, source.Ref)
+	}
+	return materializeLayer(ctx, path, iss)
+}
+
+// Store is an Additional Layer Store rooted at a directory.
+type Store struct {
+	root          string
+	withReference bool
+}
+
+// New returns a Store rooted at root, which must match a path configured in the
+// additionallayerstore option of the overlay driver; withReference must match
+// the presence of the “:ref” suffix of that option.
+func New(root string, withReference bool) (*Store, error) {
+	root = filepath.Clean(root)
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("additional layer store path %q is not absolute", root)
+	}
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("additional layer store path %q is not a directory", root)
+	}
+	return &Store{root: root, withReference: withReference}, nil
+}
+
+// FromGraphOptions returns a Store for the first additionallayerstore configured in graphOptions
+// for the overlay driver, or nil if there is none.
+func FromGraphOptions(graphOptions []string) (*Store, error) {
+	for _, option := range graphOptions {
+		driverName, key, val, err := driver.ParseDriverOption(option)
+		if err != nil {
+			return nil, err
+		}
+		if (driverName != "" && driverName != "overlay" && driverName != "overlay2") || key != "additionallayerstore" {
+			continue
+		}
+		for lstore := range strings.SplitSeq(val, ",") {
+			elems := strings.Split(lstore, ":")
+			if elems[0] == "" {
+				continue
+			}
+			return New(elems[0], slices.Contains(elems[1:], "ref"))
+		}
+	}
+	return nil, nil
+}
+
+// LayerPath returns the path of the layer with tocDigest, as looked up for an image ref.
+func (s *Store) LayerPath(tocDigest digest.Digest, ref string) string {
+	refElem := ""
+	if s.withReference {
+		refElem = base64.StdEncoding.EncodeToString([]byte(ref))
+	}
+	return filepath.Join(s.root, refElem, tocDigest.String())
+}
+
+// layerPrepared returns true if the layer at path has been registered by Prepare.
+func layerPrepared(path string) bool {
+	for _, name := range []string{infoName, tocName} {
+		if err := fileutils.Exists(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// layerExists returns true if the layer at path has been fully materialized.
+func layerExists(path string) bool {
+	for _, name := range []string{diffName, infoName, blobName} {
+		if err := fileutils.Exists(filepath.Join(path, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Prepare registers the zstd:chunked layer blobDigest, described by annotations, and available from iss,
+// in the store for an image ref, fetching only its TOC if necessary.
+// blobDigest must not be empty, and blobSize must be known.
+// It returns the TOC digest identifying the layer; the caller can then use Open to read individual files,
+// and storage.Store.LookupAdditionalLayer with the returned value and ref to use the layer, which is
+// materialized when the graph driver first uses its contents.
+func (s *Store) Prepare(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64, annotations map[string]string, iss chunked.ImageSourceSeekable) (digest.Digest, error) {
+	if _, ok := annotations[minimal.ManifestInfoKey]; !ok {
+		return "", ErrUnsupportedLayer
+	}
+	tocDigest, err := toc.GetTOCDigest(annotations)
+	if err != nil {
+		return "", err
+	}
+	if tocDigest == nil {
+		return "", ErrUnsupportedLayer
+	}
+	if err := blobDigest.Validate(); err != nil {
+		return "", fmt.Errorf("invalid blob digest %q: %w", blobDigest, err)
+	}
+	if blobSize < 0 {
+		return "", fmt.Errorf("size of blob %q is unknown", blobDigest)
+	}
+	tocRange, tocLengthUncompressed, err := tocLocation(annotations)
+	if err != nil {
+		return "", err
+	}
+	if tocRange.end() > blobSize {
+		return "", fmt.Errorf("TOC at %d-%d is outside of blob %q of size %d", tocRange.Offset, tocRange.end(), blobDigest, blobSize)
+	}
+
+	layerPath := s.LayerPath(*tocDigest, ref)
+	if layerPrepared(layerPath) {
+		return *tocDigest, nil
+	}
+	parent := filepath.Dir(layerPath)
+	if err := os.MkdirAll(parent, 0o700); err != nil {
+		return "", err
+	}
+	tmpDir, err := os.MkdirTemp(parent, ".tmp-layer-")
+	if err != nil {
+		return "", err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			_ = os.RemoveAll(tmpDir)
+		}
+	}()
+
+	blob, err := os.OpenFile(filepath.Join(tmpDir, partialBlobName), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	if err := blob.Truncate(blobSize); err != nil {
+		return "", err
+	}
+	if err := fetchRanges(blob, iss, []byteRange{tocRange}); err != nil {
+		return "", fmt.Errorf("fetching TOC of blob %q: %w", blobDigest, err)
+	}
+	layerTOC, err := readTOC(blob, *tocDigest, tocRange, tocLengthUncompressed)
+	if err != nil {
+		return "", fmt.Errorf("reading TOC of blob %q: %w", blobDigest, err)
+	}
+	if err := writeRanges(tmpDir, []byteRange{tocRange}); err != nil {
+		return "", err
+	}
+	tocBytes, err := json.Marshal(layerTOC)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutils.AtomicWriteFile(filepath.Join(tmpDir, tocName), tocBytes, 0o600); err != nil {
+		return "", err
+	}
+
+	uids, gids := layerIDs(layerTOC)
+	info, err := json.Marshal(storage.Layer{
+		CompressedDigest: blobDigest,
+		CompressedSize:   blobSize,
+		TOCDigest:        *tocDigest,
+		CompressionType:  archive.Zstd,
+		UIDs:             uids,
+		GIDs:             gids,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutils.AtomicWriteFile(filepath.Join(tmpDir, infoName), info, 0o600); err != nil {
+		return "", err
+	}
+	source := layerSource{Ref: ref}
+	if httpSource, ok := iss.(*HTTPBlobSource); ok {
+		source.URL = httpSource.url
+	}
+	sourceBytes, err := json.Marshal(source)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutils.AtomicWriteFile(filepath.Join(tmpDir, sourceName), sourceBytes, 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmpDir, 0o755); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpDir, layerPath); err != nil {
+		// Another process might have prepared the same layer concurrently.
+		if layerPrepared(layerPath) {
+			return *tocDigest, nil
+		}
+		return "", err
+	}
+	succeeded = true
+	return *tocDigest, nil
+}
+
+// lockLayer locks the layer at layerPath, which must have been registered by Prepare, and returns a function to unlock it.
+func lockLayer(layerPath string) (func(), error) {
+	if !layerPrepared(layerPath) {
+		return nil, fmt.Errorf("layer %q has not been prepared: %w", layerPath, os.ErrNotExist)
+	}
+	lock, err := lockfile.GetLockFile(filepath.Join(layerPath, lockName))
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	return lock.Unlock, nil
+}
+
+// readLayerMetadata returns the TOC and the info of the layer at layerPath.
+func readLayerMetadata(layerPath string) (*minimal.TOC, *storage.Layer, error) {
+	tocBytes, err := os.ReadFile(filepath.Join(layerPath, tocName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var layerTOC minimal.TOC
+	if err := json.Unmarshal(tocBytes, &layerTOC); err != nil {
+		return nil, nil, fmt.Errorf("parsing TOC of layer %q: %w", layerPath, err)
+	}
+	infoBytes, err := os.ReadFile(filepath.Join(layerPath, infoName))
+	if err != nil {
+		return nil, nil, err
+	}
+	var info storage.Layer
+	if err := json.Unmarshal(infoBytes, &info); err != nil {
+		return nil, nil, fmt.Errorf("parsing info of layer %q: %w", layerPath, err)
+	}
+	return &layerTOC, &info, nil
+}
+
+// Open returns the contents of the regular file at name in the layer registered by Prepare for tocDigest and ref.
+// Only the byte ranges of the blob containing the file, if not available locally, are fetched from iss.
+// The contents are verified against the TOC while reading; the caller must read until io.EOF to detect invalid data.
+func (s *Store) Open(tocDigest digest.Digest, ref string, name string, iss chunked.ImageSourceSeekable) (io.ReadCloser, error) {
+	layerPath := s.LayerPath(tocDigest, ref)
+	unlock, err := lockLayer(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	layerTOC, _, err := readLayerMetadata(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := findRegularFile(layerTOC, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Size == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	expected, err := digest.Parse(entry.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest %q of %q: %w", entry.Digest, entry.Name, err)
+	}
+	if entry.Offset < 0 || entry.EndOffset <= entry.Offset {
+		return nil, fmt.Errorf("invalid range %d-%d of %q", entry.Offset, entry.EndOffset, entry.Name)
+	}
+	fileRange := byteRange{Offset: entry.Offset, Length: entry.EndOffset - entry.Offset}
+
+	blobPath := filepath.Join(layerPath, blobName)
+	if !layerExists(layerPath) {
+		blobPath = filepath.Join(layerPath, partialBlobName)
+	}
+	blob, err := os.OpenFile(blobPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			blob.Close()
+		}
+	}()
+	if blobPath != filepath.Join(layerPath, blobName) {
+		if err := ensureRanges(layerPath, blob, iss, fileRange); err != nil {
+			return nil, fmt.Errorf("fetching %q: %w", entry.Name, err)
+		}
+	}
+	decoder, err := zstd.NewReader(io.NewSectionReader(blob, fileRange.Offset, fileRange.Length))
+	if err != nil {
+		return nil, err
+	}
+	succeeded = true
+	return &fileReader{
+		blob:     blob,
+		decoder:  decoder,
+		reader:   io.LimitReader(decoder, entry.Size+1),
+		entry:    entry,
+		expected: expected,
+		digester: expected.Algorithm().Digester(),
+	}, nil
+}
+
+// findRegularFile returns the TOC entry of the regular file at name in layerTOC, following hard links.
+func findRegularFile(layerTOC *minimal.TOC, name string) (*minimal.FileMetadata, error) {
+	name = storagePath.CleanAbsPath(name)
+	for range 2 { // A hard link must refer to a regular file, so at most one link is followed.
+		i := slices.IndexFunc(layerTOC.Entries, func(e minimal.FileMetadata) bool {
+			return e.Type != minimal.TypeChunk && storagePath.CleanAbsPath(e.Name) == name
+		})
+		if i == -1 {
+			return nil, fmt.Errorf("%q not found in layer: %w", name, os.ErrNotExist)
+		}
+		entry := &layerTOC.Entries[i]
+		switch entry.Type {
+		case minimal.TypeReg:
+			return entry, nil
+		case minimal.TypeLink:
+			name = storagePath.CleanAbsPath(entry.Linkname)
+		default:
+			return nil, fmt.Errorf("%q is not a regular file", name)
+		}
+	}
+	return nil, fmt.Errorf("%q is a hard link to a hard link", name)
+}
+
+// fileReader is an io.ReadCloser returning the decompressed contents of a TOC entry, verifying them against the TOC.
+type fileReader struct {
+	blob     *os.File
+	decoder  *zstd.Decoder
+	reader   io.Reader // Reads at most entry.Size+1 bytes from decoder.
+	entry    *minimal.FileMetadata
+	expected digest.Digest
+	digester digest.Digester
+	size     int64
+}
+
+// Read implements io.Reader.
+func (r *fileReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	r.digester.Hash().Write(p[:n])
+	if r.size > r.entry.Size {
+		return n, fmt.Errorf("size mismatch for %q, expected %d, got more", r.entry.Name, r.entry.Size)
+	}
+	if err == io.EOF {
+		if r.size != r.entry.Size {
+			return n, fmt.Errorf("size mismatch for %q, expected %d, got %d", r.entry.Name, r.entry.Size, r.size)
+		}
+		if r.digester.Digest() != r.expected {
+			return n, fmt.Errorf("digest mismatch for %q, expected %s, got %s", r.entry.Name, r.expected, r.digester.Digest())
+		}
+	}
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *fileReader) Close() error {
+	r.decoder.Close()
+	return r.blob.Close()
+}
+
+// Materialize creates the contents of the layer registered by Prepare for tocDigest and ref,
+// fetching from iss the parts of the blob which are not available locally.
+// This is not necessary to use the layer, it only avoids the delay (and network access) on first use.
+func (s *Store) Materialize(ctx context.Context, tocDigest digest.Digest, ref string, iss chunked.ImageSourceSeekable) error {
+	return materializeLayer(ctx, s.LayerPath(tocDigest, ref), iss)
+}
+
+// materializeLayer creates the contents of the layer at layerPath, which must have been registered by Prepare,
+// fetching from iss the parts of the blob which are not available locally.
+func materializeLayer(ctx context.Context, layerPath string, iss chunked.ImageSourceSeekable) error {
+	if layerExists(layerPath) {
+		return nil
+	}
+	unlock, err := lockLayer(layerPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if layerExists(layerPath) { // Materialized by another process while we were waiting for the lock.
+		return nil
+	}
+
+	layerTOC, info, err := readLayerMetadata(layerPath)
+	if err != nil {
+		return err
+	}
+	partialBlobPath := filepath.Join(layerPath, partialBlobName)
+	blob, err := os.OpenFile(partialBlobPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	if err := ensureRanges(layerPath, blob, iss, byteRange{Offset: 0, Length: info.CompressedSize}); err != nil {
+		return fmt.Errorf("fetching blob %q: %w", info.CompressedDigest, err)
+	}
+	digester := info.CompressedDigest.Algorithm().Digester()
+	if _, err := io.Copy(digester.Hash(), io.NewSectionReader(blob, 0, info.CompressedSize)); err != nil {
+		return err
+	}
+	if digester.Digest() != info.CompressedDigest {
+		// Either some of the fetched data, or the blob digest recorded by Prepare, is invalid, and we can’t tell which;
+		// drop the layer, so that it is prepared from scratch next time.
+		if err := os.RemoveAll(layerPath); err != nil {
+			return err
+		}
+		return fmt.Errorf("blob digest mismatch, expected %s, got %s", info.CompressedDigest, digester.Digest())
+	}
+
+	tmpDiff, err := os.MkdirTemp(layerPath, ".tmp-diff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDiff)
+	if err := os.Chmod(tmpDiff, 0o755); err != nil {
+		return err
+	}
+	if err := applyTOC(ctx, tmpDiff, blob, layerTOC); err != nil {
+		return fmt.Errorf("extracting blob %q: %w", info.CompressedDigest, err)
+	}
+	diffPath := filepath.Join(layerPath, diffName)
+	// A previous attempt might have been interrupted after creating diffPath.
+	if err := os.RemoveAll(diffPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDiff, diffPath); err != nil {
+		return err
+	}
+	// This makes the layer visible to the overlay driver, so it must happen last.
+	if err := os.Rename(partialBlobPath, filepath.Join(layerPath, blobName)); err != nil {
+		return err
+	}
+	for _, name := range []string{rangesName, sourceName} {
+		if err := os.Remove(filepath.Join(layerPath, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// tocLocation returns the byte range of the TOC of a zstd:chunked blob described by annotations,
+// and the uncompressed size of the TOC.
+func tocLocation(annotations map[string]string) (byteRange, int64, error) {
+	var offset, length, lengthUncompressed, manifestType uint64
+	if _, err := fmt.Sscanf(annotations[minimal.ManifestInfoKey], "%d:%d:%d:%d", &offset, &length, &lengthUncompressed, &manifestType); err != nil {
+		return byteRange{}, -1, err
+	}
+	if manifestType != minimal.ManifestTypeCRFS {
+		return byteRange{}, -1, errors.New("invalid manifest type")
+	}
+	if length == 0 || length > maxTOCSize || lengthUncompressed > maxTOCSize {
+		return byteRange{}, -1, fmt.Errorf("invalid TOC size (%d bytes compressed, %d uncompressed)", length, lengthUncompressed)
+	}
+	if offset > math.MaxInt64-length {
+		return byteRange{}, -1, fmt.Errorf("invalid TOC offset %d", offset)
+	}
+	return byteRange{Offset: int64(offset), Length: int64(length)}, int64(lengthUncompressed), nil
+}
+
+// readTOC reads the TOC at tocRange of the zstd:chunked blob, and validates it against tocDigest.
+func readTOC(blob io.ReaderAt, tocDigest digest.Digest, tocRange byteRange, lengthUncompressed int64) (*minimal.TOC, error) {
+	compressed := make([]byte, tocRange.Length)
+	if _, err := blob.ReadAt(compressed, tocRange.Offset); err != nil {
+		return nil, err
+	}
+	if err := tocDigest.Validate(); err != nil {
+		return nil, err
+	}
+	if actual := tocDigest.Algorithm().FromBytes(compressed); actual != tocDigest {
+		return nil, fmt.Errorf("invalid TOC checksum, expected %s, got %s", tocDigest, actual)
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer decoder.Close()
+	decoded, err := decoder.DecodeAll(compressed, make([]byte, 0, lengthUncompressed))
+	if err != nil {
+		return nil, err
+	}
+	var res minimal.TOC
+	if err := json.Unmarshal(decoded, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// layerIDs returns the sorted sets of UIDs and GIDs used by the layer described by layerTOC.
+func layerIDs(layerTOC *minimal.TOC) ([]uint32, []uint32) {
+	uidSet := map[uint32]struct{}{}
+	gidSet := map[uint32]struct{}{}
+	for i := range layerTOC.Entries {
+		entry := &layerTOC.Entries[i]
+		if entry.Type == minimal.TypeChunk {
+			continue
+		}
+		// Whiteouts don’t create files with the entry’s ownership.
+		if strings.HasPrefix(filepath.Base(storagePath.CleanAbsPath(entry.Name)), archive.WhiteoutPrefix) {
+			continue
+		}
+		uidSet[uint32(entry.UID)] = struct{}{}
+		gidSet[uint32(entry.GID)] = struct{}{}
+	}
+	return sortedSet(uidSet), sortedSet(gidSet)
+}
+
+// sortedSet returns the members of set in ascending order.
+func sortedSet(set map[uint32]struct{}) []uint32 {
+	res := make([]uint32, 0, len(set))
+	for v := range set {
+		res = append(res, v)
+	}
+	slices.Sort(res)
+	return res
+}
@@ -0,0 +1,416 @@
+package als
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vbatts/tar-split/archive/tar"
+	"go.podman.io/storage"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chunked"
+	"go.podman.io/storage/pkg/chunked/compressor"
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+	"golang.org/x/sys/unix"
+)
+
+type testEntry struct {
+	hdr  tar.Header
+	data []byte
+}
+
+// makeChunkedBlob returns a zstd:chunked blob with entries, and its annotations.
+func makeChunkedBlob(t *testing.T, entries []testEntry) ([]byte, map[string]string) {
+	var blob bytes.Buffer
+	annotations := map[string]string{}
+	w, err := compressor.ZstdCompressor(&blob, annotations, nil)
+	require.NoError(t, err)
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.data))
+		if hdr.ModTime.IsZero() {
+			hdr.ModTime = time.Unix(1700000000, 0)
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write(e.data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, w.Close())
+	return blob.Bytes(), annotations
+}
+
+// serveBlob serves blob over HTTP, and returns its URL and a counter of requests.
+func serveBlob(t *testing.T, blob []byte) (string, *atomic.Int64) {
+	requests := &atomic.Int64{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/blob" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(blob))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/blob", requests
+}
+
+func TestFromGraphOptions(t *testing.T) {
+	root := t.TempDir()
+
+	s, err := FromGraphOptions([]string{"overlay.mountopt=nodev"})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = FromGraphOptions([]string{"vfs.ignore_chown_errors=true", "overlay.additionallayerstore=" + root + ":ref"})
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.Equal(t, root, s.root)
+	assert.True(t, s.withReference)
+	d := digest.FromString("toc")
+	assert.Equal(t, filepath.Join(root, base64.StdEncoding.EncodeToString([]byte("example.com/a:b")), d.String()), s.LayerPath(d, "example.com/a:b"))
+
+	s, err = FromGraphOptions([]string{".additionallayerstore=" + root})
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	assert.False(t, s.withReference)
+	assert.Equal(t, filepath.Join(root, d.String()), s.LayerPath(d, "example.com/a:b"))
+
+	_, err = FromGraphOptions([]string{"overlay.additionallayerstore=relative/path"})
+	assert.Error(t, err)
+}
+
+func TestPrepare(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	ctx := context.Background()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<14)
+	large = append(large, make([]byte, 1<<16)...)
+	blob, annotations := makeChunkedBlob(t, []testEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0o750, Uid: 1, Gid: 2}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "dir/file", Mode: 0o644, Uid: 3, Gid: 4}, data: []byte("contents")},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "dir/large", Mode: 0o600}, data: large},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "empty", Mode: 0o644}},
+		{hdr: tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "dir/file"}},
+		{hdr: tar.Header{Typeflag: tar.TypeLink, Name: "hardlink", Linkname: "dir/file"}},
+		{hdr: tar.Header{Typeflag: tar.TypeDir, Name: "opaque/", Mode: 0o755}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "opaque/" + archive.WhiteoutOpaqueDir, Mode: 0o644}},
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: archive.WhiteoutPrefix + "deleted", Mode: 0o644}},
+	})
+	blobDigest := digest.FromBytes(blob)
+	url, requests := serveBlob(t, blob)
+
+	s, err := New(t.TempDir(), true)
+	require.NoError(t, err)
+	const ref = "example.com/repo:tag"
+	iss := NewHTTPBlobSource(ctx, nil, url)
+	tocDigest, err := s.Prepare(ctx, ref, blobDigest, int64(len(blob)), annotations, iss)
+	require.NoError(t, err)
+	assert.Equal(t, annotations["io.github.containers.zstd-chunked.manifest-checksum"], tocDigest.String())
+	layerPath := s.LayerPath(tocDigest, ref)
+	// Only the TOC has been fetched, and the layer contents have not been created yet.
+	assert.Equal(t, int64(1), requests.Load())
+	assert.False(t, layerExists(layerPath))
+	_, err = os.Lstat(filepath.Join(layerPath, "diff"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Files are fetched on demand, and only once.
+	for _, c := range []struct {
+		name             string
+		expected         []byte
+		expectedRequests int64
+	}{
+		{"dir/file", []byte("contents"), 2},
+		{"/dir/file", []byte("contents"), 2},
+		{"hardlink", []byte("contents"), 2},
+		{"empty", []byte{}, 2},
+		{"dir/large", large, 3},
+	} {
+		r, err := s.Open(tocDigest, ref, c.name, iss)
+		require.NoError(t, err, c.name)
+		contents, err := io.ReadAll(r)
+		require.NoError(t, err, c.name)
+		require.NoError(t, r.Close())
+		assert.Equal(t, c.expected, contents, c.name)
+		assert.Equal(t, c.expectedRequests, requests.Load(), c.name)
+	}
+	for _, name := range []string{"dir", "link", "missing"} {
+		_, err := s.Open(tocDigest, ref, name, iss)
+		assert.Error(t, err, name)
+	}
+
+	// Preparing the same layer again does not access the source.
+	requestsSoFar := requests.Load()
+	tocDigest2, err := s.Prepare(ctx, ref, blobDigest, int64(len(blob)), annotations, iss)
+	require.NoError(t, err)
+	assert.Equal(t, tocDigest, tocDigest2)
+	assert.Equal(t, requestsSoFar, requests.Load())
+
+	// Materialize only fetches the ranges which were not fetched yet.
+	err = s.Materialize(ctx, tocDigest, ref, iss)
+	require.NoError(t, err)
+	assert.Greater(t, requests.Load(), requestsSoFar)
+	assert.True(t, layerExists(layerPath))
+
+	storedBlob, err := os.ReadFile(filepath.Join(layerPath, "blob"))
+	require.NoError(t, err)
+	assert.Equal(t, blob, storedBlob)
+
+	infoBytes, err := os.ReadFile(filepath.Join(layerPath, "info"))
+	require.NoError(t, err)
+	var info storage.Layer
+	require.NoError(t, json.Unmarshal(infoBytes, &info))
+	assert.Equal(t, blobDigest, info.CompressedDigest)
+	assert.Equal(t, int64(len(blob)), info.CompressedSize)
+	assert.Equal(t, tocDigest, info.TOCDigest)
+	assert.Equal(t, archive.Zstd, info.CompressionType)
+	assert.Equal(t, digest.Digest(""), info.UncompressedDigest)
+	assert.Equal(t, []uint32{0, 1, 3}, info.UIDs)
+	assert.Equal(t, []uint32{0, 2, 4}, info.GIDs)
+
+	diff := filepath.Join(layerPath, "diff")
+	contents, err := os.ReadFile(filepath.Join(diff, "dir/file"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("contents"), contents)
+	contents, err = os.ReadFile(filepath.Join(diff, "dir/large"))
+	require.NoError(t, err)
+	assert.Equal(t, large, contents)
+	st, err := os.Stat(filepath.Join(diff, "empty"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), st.Size())
+
+	var fileStat, dirStat, hardlinkStat unix.Stat_t
+	require.NoError(t, unix.Lstat(filepath.Join(diff, "dir/file"), &fileStat))
+	assert.Equal(t, uint32(0o644), fileStat.Mode&0o7777)
+	assert.Equal(t, uint32(3), fileStat.Uid)
+	assert.Equal(t, uint32(4), fileStat.Gid)
+	assert.Equal(t, int64(1700000000), fileStat.Mtim.Sec)
+	require.NoError(t, unix.Lstat(filepath.Join(diff, "dir"), &dirStat))
+	assert.Equal(t, uint32(0o750), dirStat.Mode&0o7777)
+	assert.Equal(t, uint32(1), dirStat.Uid)
+	assert.Equal(t, int64(1700000000), dirStat.Mtim.Sec)
+	require.NoError(t, unix.Lstat(filepath.Join(diff, "hardlink"), &hardlinkStat))
+	assert.Equal(t, fileStat.Ino, hardlinkStat.Ino)
+	target, err := os.Readlink(filepath.Join(diff, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "dir/file", target)
+
+	var whiteoutStat unix.Stat_t
+	require.NoError(t, unix.Lstat(filepath.Join(diff, "deleted"), &whiteoutStat))
+	assert.Equal(t, uint32(unix.S_IFCHR), whiteoutStat.Mode&unix.S_IFMT)
+	assert.Equal(t, uint64(0), whiteoutStat.Rdev)
+	_, err = os.Lstat(filepath.Join(diff, archive.WhiteoutPrefix+"deleted"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Lstat(filepath.Join(diff, "opaque", archive.WhiteoutOpaqueDir))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	opaque := make([]byte, 1)
+	_, err = unix.Lgetxattr(filepath.Join(diff, "opaque"), archive.GetOverlayXattrName("opaque"), opaque)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("y"), opaque)
+
+	// Materializing and reading the layer again does not access the source.
+	requestsSoFar = requests.Load()
+	err = s.Materialize(ctx, tocDigest, ref, iss)
+	require.NoError(t, err)
+	r, err := s.Open(tocDigest, ref, "dir/large", iss)
+	require.NoError(t, err)
+	contents, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, large, contents)
+	assert.Equal(t, requestsSoFar, requests.Load())
+}
+
+func TestPrepareErrors(t *testing.T) {
+	ctx := context.Background()
+	blob, annotations := makeChunkedBlob(t, []testEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644}, data: []byte("contents")},
+	})
+	blobDigest := digest.FromBytes(blob)
+	url, _ := serveBlob(t, blob)
+	root := t.TempDir()
+	s, err := New(root, false)
+	require.NoError(t, err)
+
+	// Not a zstd:chunked layer
+	_, err = s.Prepare(ctx, "", blobDigest, int64(len(blob)), map[string]string{}, NewHTTPBlobSource(ctx, nil, url))
+	assert.ErrorIs(t, err, ErrUnsupportedLayer)
+
+	// Blob digest mismatch: detected when materializing the layer, which is then dropped.
+	tocDigest, err := s.Prepare(ctx, "", digest.FromString("other"), int64(len(blob)), annotations, NewHTTPBlobSource(ctx, nil, url))
+	require.NoError(t, err)
+	err = s.Materialize(ctx, tocDigest, "", NewHTTPBlobSource(ctx, nil, url))
+	assert.ErrorContains(t, err, "digest mismatch")
+	_, err = s.Open(tocDigest, "", "file", NewHTTPBlobSource(ctx, nil, url))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// TOC digest mismatch
+	badAnnotations := map[string]string{}
+	for k, v := range annotations {
+		badAnnotations[k] = v
+	}
+	badAnnotations["io.github.containers.zstd-chunked.manifest-checksum"] = digest.FromString("other").String()
+	_, err = s.Prepare(ctx, "", blobDigest, int64(len(blob)), badAnnotations, NewHTTPBlobSource(ctx, nil, url))
+	assert.ErrorContains(t, err, "invalid TOC checksum")
+
+	// HTTP failure
+	_, err = s.Prepare(ctx, "", blobDigest, int64(len(blob)), annotations, NewHTTPBlobSource(ctx, nil, strings.TrimSuffix(url, "/blob")+"/missing"))
+	assert.ErrorContains(t, err, "404")
+
+	// Nothing is left behind after failures.
+	dirEntries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, dirEntries)
+}
+
+func TestMaterializeXattrs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	ctx := context.Background()
+	s, err := New(t.TempDir(), false)
+	require.NoError(t, err)
+
+	blob, annotations := makeChunkedBlob(t, []testEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, PAXRecords: map[string]string{
+			"SCHILY.xattr.user.test":                "value",
+			"SCHILY.xattr.trusted.overlay.redirect": "/etc",
+			"SCHILY.xattr.security.selinux":         "system_u:object_r:shadow_t:s0",
+		}}, data: []byte("contents")},
+	})
+	url, _ := serveBlob(t, blob)
+	iss := NewHTTPBlobSource(ctx, nil, url)
+	tocDigest, err := s.Prepare(ctx, "", digest.FromBytes(blob), int64(len(blob)), annotations, iss)
+	require.NoError(t, err)
+	require.NoError(t, s.Materialize(ctx, tocDigest, "", iss))
+	path := filepath.Join(s.LayerPath(tocDigest, ""), "diff", "file")
+
+	value := make([]byte, 64)
+	n, err := unix.Lgetxattr(path, "user.test", value)
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(value[:n]))
+	// overlay attributes are escaped, and not interpreted by overlay.
+	_, err = unix.Lgetxattr(path, "trusted.overlay.redirect", value)
+	assert.ErrorIs(t, err, unix.ENODATA)
+	n, err = unix.Lgetxattr(path, "trusted.overlay.overlay.redirect", value)
+	require.NoError(t, err)
+	assert.Equal(t, "/etc", string(value[:n]))
+
+	// Unknown namespaces are rejected.
+	blob, annotations = makeChunkedBlob(t, []testEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644, PAXRecords: map[string]string{
+			"SCHILY.xattr.system.nfs4_acl": "value",
+		}}, data: []byte("contents")},
+	})
+	url, _ = serveBlob(t, blob)
+	iss = NewHTTPBlobSource(ctx, nil, url)
+	tocDigest, err = s.Prepare(ctx, "", digest.FromBytes(blob), int64(len(blob)), annotations, iss)
+	require.NoError(t, err)
+	err = s.Materialize(ctx, tocDigest, "", iss)
+	assert.ErrorContains(t, err, "unsupported extended attribute")
+}
+
+func TestMaterializeOnFirstUse(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	ctx := context.Background()
+	s, err := New(t.TempDir(), true)
+	require.NoError(t, err)
+	blob, annotations := makeChunkedBlob(t, []testEntry{
+		{hdr: tar.Header{Typeflag: tar.TypeReg, Name: "file", Mode: 0o644}, data: []byte("contents")},
+	})
+	url, requests := serveBlob(t, blob)
+	const ref = "example.com/repo:tag"
+
+	// A layer prepared from a HTTPBlobSource is fetched from the same URL.
+	tocDigest, err := s.Prepare(ctx, ref, digest.FromBytes(blob), int64(len(blob)), annotations, NewHTTPBlobSource(ctx, nil, url))
+	require.NoError(t, err)
+	layerPath := s.LayerPath(tocDigest, ref)
+	assert.True(t, graphdriver.AdditionalLayerPending(layerPath))
+	assert.Equal(t, int64(1), requests.Load())
+	require.NoError(t, graphdriver.MaterializeAdditionalLayer(layerPath))
+	assert.Greater(t, requests.Load(), int64(1))
+	assert.False(t, graphdriver.AdditionalLayerPending(layerPath))
+	contents, err := os.ReadFile(filepath.Join(layerPath, "diff", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("contents"), contents)
+	_, err = os.Stat(filepath.Join(layerPath, sourceName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Other layers are fetched using the source opener.
+	const otherRef = "example.com/other:tag"
+	iss := struct{ chunked.ImageSourceSeekable }{NewHTTPBlobSource(ctx, nil, url)}
+	tocDigest, err = s.Prepare(ctx, otherRef, digest.FromBytes(blob), int64(len(blob)), annotations, iss)
+	require.NoError(t, err)
+	layerPath = s.LayerPath(tocDigest, otherRef)
+	err = graphdriver.MaterializeAdditionalLayer(layerPath)
+	assert.ErrorContains(t, err, "no source")
+	assert.True(t, graphdriver.AdditionalLayerPending(layerPath))
+
+	var openedRef string
+	closed := false
+	SetSourceOpener(func(ctx context.Context, ref string, blobDigest digest.Digest, blobSize int64) (chunked.ImageSourceSeekable, func() error, error) {
+		openedRef = ref
+		assert.Equal(t, digest.FromBytes(blob), blobDigest)
+		assert.Equal(t, int64(len(blob)), blobSize)
+		return NewHTTPBlobSource(ctx, nil, url), func() error {
+			closed = true
+			return nil
+		}, nil
+	})
+	t.Cleanup(func() { SetSourceOpener(nil) })
+	require.NoError(t, graphdriver.MaterializeAdditionalLayer(layerPath))
+	assert.Equal(t, otherRef, openedRef)
+	assert.True(t, closed)
+	contents, err = os.ReadFile(filepath.Join(layerPath, "diff", "file"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("contents"), contents)
+}
+
+func TestApplyTOCHostile(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	outside := t.TempDir()
+	require.NoError(t, os.Chmod(outside, 0o700))
+	checkOutside := func() {
+		var st unix.Stat_t
+		require.NoError(t, unix.Stat(outside, &st))
+		assert.Equal(t, uint32(0o700), st.Mode&0o7777)
+		assert.Equal(t, uint32(0), st.Uid)
+		assert.Equal(t, uint32(0), st.Gid)
+	}
+
+	// A directory entry must not apply its metadata through an earlier symlink.
+	dest := t.TempDir()
+	err := applyTOC(context.Background(), dest, bytes.NewReader(nil), &minimal.TOC{Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeSymlink, Name: "a", Linkname: outside},
+		{Type: minimal.TypeDir, Name: "a", Mode: 0o777, UID: 1, GID: 1},
+	}})
+	assert.ErrorContains(t, err, "conflicts with an earlier entry")
+	checkOutside()
+
+	// Metadata is never applied to the target of a symlink.
+	link := filepath.Join(dest, "a")
+	err = setMetadata(link, &minimal.FileMetadata{Type: minimal.TypeDir, Name: "a", Mode: 0o777, UID: 1, GID: 1})
+	assert.ErrorContains(t, err, "does not match the type")
+	checkOutside()
+}
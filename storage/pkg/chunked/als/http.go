@@ -0,0 +1,83 @@
+package als
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.podman.io/storage/pkg/chunked"
+)
+
+// HTTPBlobSource is a chunked.ImageSourceSeekable which reads a single blob from a plain HTTP(S) URL
+// using range requests, e.g. from a static file server standing in for a registry.
+type HTTPBlobSource struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+}
+
+// NewHTTPBlobSource returns a HTTPBlobSource reading from url using client (or http.DefaultClient, if nil).
+func NewHTTPBlobSource(ctx context.Context, client *http.Client, url string) *HTTPBlobSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPBlobSource{
+		ctx:    ctx,
+		client: client,
+		url:    url,
+	}
+}
+
+// GetBlobAt implements chunked.ImageSourceSeekable.
+func (s *HTTPBlobSource) GetBlobAt(chunks []chunked.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
+	streams := make(chan io.ReadCloser)
+	errs := make(chan error)
+	go func() {
+		defer close(streams)
+		defer close(errs)
+		for _, chunk := range chunks {
+			stream, err := s.getRange(chunk)
+			if err != nil {
+				errs <- err
+				return
+			}
+			streams <- stream
+		}
+	}()
+	return streams, errs, nil
+}
+
+// getRange returns a stream with the contents of chunk.
+func (s *HTTPBlobSource) getRange(chunk chunked.ImageSourceChunk) (io.ReadCloser, error) {
+	if chunk.Length == 0 {
+		return nil, chunked.ErrBadRequest{}
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", chunk.Offset, chunk.Offset+chunk.Length-1))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusPartialContent:
+	case res.StatusCode == http.StatusOK && chunk.Offset == 0:
+		// The server does not support ranges, but returned data starting at the desired offset.
+	case res.StatusCode == http.StatusOK || res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return nil, chunked.ErrBadRequest{}
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", s.url, res.Status)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.LimitReader(res.Body, int64(chunk.Length)),
+		Closer: res.Body,
+	}, nil
+}
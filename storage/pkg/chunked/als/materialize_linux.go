@@ -0,0 +1,272 @@
+package als
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+	storagePath "go.podman.io/storage/pkg/chunked/internal/path"
+	"golang.org/x/sys/unix"
+)
+
+// xattrsToIgnore are extended attributes which are never applied from a layer, as in pkg/archive and pkg/chunked.
+var xattrsToIgnore = map[string]any{
+	"security.selinux": true,
+}
+
+// overlayXattrPrefixes are the prefixes of extended attributes interpreted by the overlay filesystem.
+var overlayXattrPrefixes = []string{"trusted.overlay.", "user.overlay."}
+
+// pendingMetadata is an entry whose metadata must be applied after all of the layer contents has been created.
+type pendingMetadata struct {
+	path  string
+	entry *minimal.FileMetadata
+}
+
+// applyTOC creates the contents of layerTOC, with file contents read from blob, in dest,
+// using the overlay whiteout format.
+func applyTOC(ctx context.Context, dest string, blob io.ReaderAt, layerTOC *minimal.TOC) error {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	hardLinks := []*minimal.FileMetadata{}
+	dirs := []pendingMetadata{}
+	for i := range layerTOC.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry := &layerTOC.Entries[i]
+		if entry.Type == minimal.TypeChunk {
+			continue
+		}
+		name := storagePath.CleanAbsPath(entry.Name)
+		if name == "/" && entry.Type != minimal.TypeDir {
+			return fmt.Errorf("invalid TOC entry %q of type %q", entry.Name, entry.Type)
+		}
+		parent, base := filepath.Split(name)
+		parentPath, err := securejoin.SecureJoin(dest, parent)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parentPath, 0o755); err != nil {
+			return err
+		}
+
+		if base == archive.WhiteoutOpaqueDir {
+			if err := unix.Lsetxattr(parentPath, archive.GetOverlayXattrName("opaque"), []byte{'y'}, 0); err != nil {
+				return &os.PathError{Op: "lsetxattr", Path: parentPath, Err: err}
+			}
+			continue
+		}
+		if originalBase, ok := strings.CutPrefix(base, archive.WhiteoutPrefix); ok {
+			whiteoutPath := filepath.Join(parentPath, originalBase)
+			if err := unix.Mknod(whiteoutPath, unix.S_IFCHR, 0); err != nil {
+				return &os.PathError{Op: "mknod", Path: whiteoutPath, Err: err}
+			}
+			if err := os.Lchown(whiteoutPath, entry.UID, entry.GID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		path := filepath.Join(parentPath, base)
+		switch entry.Type {
+		case minimal.TypeDir:
+			if err := os.Mkdir(path, 0o755); err != nil {
+				if !errors.Is(err, os.ErrExist) {
+					return err
+				}
+				// The directory might have been created as a parent of an earlier entry,
+				// but anything else (notably a symlink) must not be reused.
+				st, err := os.Lstat(path)
+				if err != nil {
+					return err
+				}
+				if !st.IsDir() {
+					return fmt.Errorf("TOC entry %q of type %q conflicts with an earlier entry", entry.Name, entry.Type)
+				}
+			}
+			dirs = append(dirs, pendingMetadata{path: path, entry: entry})
+			continue
+		case minimal.TypeReg:
+			if err := writeRegularFile(path, blob, decoder, entry); err != nil {
+				return err
+			}
+		case minimal.TypeSymlink:
+			if err := os.Symlink(entry.Linkname, path); err != nil {
+				return err
+			}
+		case minimal.TypeLink:
+			hardLinks = append(hardLinks, entry)
+			continue
+		case minimal.TypeChar, minimal.TypeBlock, minimal.TypeFifo:
+			mode := map[string]uint32{
+				minimal.TypeChar:  unix.S_IFCHR,
+				minimal.TypeBlock: unix.S_IFBLK,
+				minimal.TypeFifo:  unix.S_IFIFO,
+			}[entry.Type]
+			dev := unix.Mkdev(uint32(entry.Devmajor), uint32(entry.Devminor))
+			if err := unix.Mknod(path, mode|uint32(entry.Mode&0o7777), int(dev)); err != nil {
+				return &os.PathError{Op: "mknod", Path: path, Err: err}
+			}
+		default:
+			return fmt.Errorf("unsupported type %q of TOC entry %q", entry.Type, entry.Name)
+		}
+		if err := setMetadata(path, entry); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range hardLinks {
+		path, err := securejoin.SecureJoin(dest, entry.Name)
+		if err != nil {
+			return err
+		}
+		target, err := securejoin.SecureJoin(dest, entry.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+	}
+	// Set the metadata of directories last, both so that creating their contents
+	// does not modify the timestamps, and so that read-only directories can be populated.
+	for _, dir := range slices.Backward(dirs) {
+		if err := setMetadata(dir.path, dir.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRegularFile creates a regular file at path, with the contents of entry decompressed from blob.
+func writeRegularFile(path string, blob io.ReaderAt, decoder *zstd.Decoder, entry *minimal.FileMetadata) (retErr error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	if entry.Size == 0 {
+		return nil
+	}
+	expected, err := digest.Parse(entry.Digest)
+	if err != nil {
+		return fmt.Errorf("invalid digest %q of %q: %w", entry.Digest, entry.Name, err)
+	}
+	if entry.EndOffset <= entry.Offset {
+		return fmt.Errorf("invalid range %d-%d of %q", entry.Offset, entry.EndOffset, entry.Name)
+	}
+	if err := decoder.Reset(io.NewSectionReader(blob, entry.Offset, entry.EndOffset-entry.Offset)); err != nil {
+		return err
+	}
+	digester := expected.Algorithm().Digester()
+	n, err := io.Copy(io.MultiWriter(f, digester.Hash()), io.LimitReader(decoder, entry.Size+1))
+	if err != nil {
+		return fmt.Errorf("decompressing %q: %w", entry.Name, err)
+	}
+	if n != entry.Size {
+		return fmt.Errorf("size mismatch for %q, expected %d, got %d", entry.Name, entry.Size, n)
+	}
+	if digester.Digest() != expected {
+		return fmt.Errorf("digest mismatch for %q, expected %s, got %s", entry.Name, expected, digester.Digest())
+	}
+	return nil
+}
+
+// diffXattrName returns the name to use in the diff directory for an extended attribute name of a layer entry,
+// or "" if the attribute should be ignored.
+func diffXattrName(name string) (string, error) {
+	if _, found := xattrsToIgnore[name]; found {
+		return "", nil
+	}
+	for _, prefix := range overlayXattrPrefixes {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			// Escape the attribute, so that overlay presents it to the container unmodified
+			// instead of interpreting it; see “Nesting overlayfs mounts” in the kernel documentation.
+			return prefix + "overlay." + rest, nil
+		}
+	}
+	if name == "system.posix_acl_access" || name == "system.posix_acl_default" {
+		return name, nil
+	}
+	for _, namespace := range []string{"user.", "trusted.", "security."} {
+		if strings.HasPrefix(name, namespace) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported extended attribute %q", name)
+}
+
+// setMetadata applies the ownership, extended attributes, permissions and timestamps of entry to path,
+// without following path if it is a symbolic link.
+func setMetadata(path string, entry *minimal.FileMetadata) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "fstat", Path: path, Err: err}
+	}
+	if isSymlink := st.Mode&unix.S_IFMT == unix.S_IFLNK; isSymlink != (entry.Type == minimal.TypeSymlink) {
+		return fmt.Errorf("%q does not match the type %q of TOC entry %q", path, entry.Type, entry.Name)
+	}
+
+	if err := unix.Fchownat(fd, "", entry.UID, entry.GID, unix.AT_EMPTY_PATH); err != nil {
+		return &os.PathError{Op: "fchownat", Path: path, Err: err}
+	}
+	for k, v := range entry.Xattrs {
+		name, err := diffXattrName(k)
+		if err != nil {
+			return fmt.Errorf("%q: %w", entry.Name, err)
+		}
+		if name == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("decode xattr %q: %w", v, err)
+		}
+		if err := unix.Lsetxattr(path, name, data, 0); err != nil && !errors.Is(err, unix.ENOTSUP) {
+			return &os.PathError{Op: "lsetxattr", Path: path, Err: err}
+		}
+	}
+	if entry.Type != minimal.TypeSymlink {
+		// fchmod does not work on O_PATH file descriptors, and fchmodat does not support AT_SYMLINK_NOFOLLOW;
+		// the magic link refers to the (verified above) non-symlink opened by fd.
+		if err := unix.Chmod(fmt.Sprintf("/proc/self/fd/%d", fd), uint32(entry.Mode&0o7777)); err != nil {
+			return &os.PathError{Op: "chmod", Path: path, Err: err}
+		}
+	}
+	if entry.ModTime != nil {
+		atime := entry.ModTime
+		if entry.AccessTime != nil {
+			atime = entry.AccessTime
+		}
+		ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(entry.ModTime.UnixNano())}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return &os.PathError{Op: "utimensat", Path: path, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package als
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+)
+
+// applyTOC creates the contents of layerTOC, with file contents read from blob, in dest,
+// using the overlay whiteout format.
+func applyTOC(ctx context.Context, dest string, blob io.ReaderAt, layerTOC *minimal.TOC) error {
+	return errors.New("additional layer stores are only supported on Linux")
+}
@@ -0,0 +1,167 @@
+package als
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"go.podman.io/storage/pkg/chunked"
+	"go.podman.io/storage/pkg/ioutils"
+)
+
+// byteRange is a range of bytes in a blob.
+type byteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// end returns the offset just after the end of r.
+func (r byteRange) end() int64 {
+	return r.Offset + r.Length
+}
+
+// addRange returns the sorted, non-overlapping, ranges covering both ranges and r.
+func addRange(ranges []byteRange, r byteRange) []byteRange {
+	all := append(slices.Clone(ranges), r)
+	slices.SortFunc(all, func(a, b byteRange) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	res := []byteRange{}
+	for _, r := range all {
+		if len(res) > 0 && r.Offset <= res[len(res)-1].end() {
+			last := &res[len(res)-1]
+			last.Length = max(last.end(), r.end()) - last.Offset
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// missingRanges returns the parts of needed which are not covered by the sorted, non-overlapping, ranges.
+func missingRanges(ranges []byteRange, needed byteRange) []byteRange {
+	res := []byteRange{}
+	offset := needed.Offset
+	for _, r := range ranges {
+		if r.end() <= offset {
+			continue
+		}
+		if r.Offset >= needed.end() {
+			break
+		}
+		if r.Offset > offset {
+			res = append(res, byteRange{Offset: offset, Length: r.Offset - offset})
+		}
+		offset = r.end()
+	}
+	if offset < needed.end() {
+		res = append(res, byteRange{Offset: offset, Length: needed.end() - offset})
+	}
+	return res
+}
+
+// readRanges returns the ranges of the partial blob of the layer at layerPath which have already been fetched.
+func readRanges(layerPath string) ([]byteRange, error) {
+	data, err := os.ReadFile(filepath.Join(layerPath, rangesName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []byteRange
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", filepath.Join(layerPath, rangesName), err)
+	}
+	return res, nil
+}
+
+// writeRanges records ranges as the ranges of the partial blob of the layer at layerPath which have already been fetched.
+func writeRanges(layerPath string, ranges []byteRange) error {
+	data, err := json.Marshal(ranges)
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(filepath.Join(layerPath, rangesName), data, 0o600)
+}
+
+// ensureRanges fetches the parts of needed which are not available in the partial blob of the layer at layerPath from iss,
+// and writes them to blob.
+// The caller must hold the layer lock.
+func ensureRanges(layerPath string, blob io.WriterAt, iss chunked.ImageSourceSeekable, needed byteRange) error {
+	ranges, err := readRanges(layerPath)
+	if err != nil {
+		return err
+	}
+	missing := missingRanges(ranges, needed)
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := fetchRanges(blob, iss, missing); err != nil {
+		return err
+	}
+	for _, r := range missing {
+		ranges = addRange(ranges, r)
+	}
+	return writeRanges(layerPath, ranges)
+}
+
+// fetchRanges reads ranges from iss, and writes them to the corresponding offsets of dest.
+func fetchRanges(dest io.WriterAt, iss chunked.ImageSourceSeekable, ranges []byteRange) error {
+	chunks := make([]chunked.ImageSourceChunk, 0, len(ranges))
+	for _, r := range ranges {
+		chunks = append(chunks, chunked.ImageSourceChunk{Offset: uint64(r.Offset), Length: uint64(r.Length)})
+	}
+	streams, errs, err := iss.GetBlobAt(chunks)
+	if err != nil {
+		return err
+	}
+	received := 0
+	var retErr error
+	for streams != nil || errs != nil {
+		select {
+		case stream, ok := <-streams:
+			if !ok {
+				streams = nil
+				continue
+			}
+			if received >= len(ranges) {
+				stream.Close()
+				if retErr == nil {
+					retErr = fmt.Errorf("expected %d streams, got more", len(ranges))
+				}
+				continue
+			}
+			r := ranges[received]
+			received++
+			n, err := io.Copy(io.NewOffsetWriter(dest, r.Offset), io.LimitReader(stream, r.Length))
+			stream.Close()
+			if err == nil && n != r.Length {
+				err = fmt.Errorf("size mismatch for range %d-%d, expected %d, got %d", r.Offset, r.end(), r.Length, n)
+			}
+			if err != nil && retErr == nil {
+				retErr = err
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if retErr == nil {
+				retErr = err
+			}
+		}
+	}
+	if retErr != nil {
+		return retErr
+	}
+	if received != len(ranges) {
+		return fmt.Errorf("expected %d streams, got %d", len(ranges), received)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package als
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRange(t *testing.T) {
+	for _, c := range []struct {
+		ranges   []byteRange
+		r        byteRange
+		expected []byteRange
+	}{
+		{nil, byteRange{10, 5}, []byteRange{{10, 5}}},
+		{[]byteRange{{10, 5}}, byteRange{0, 5}, []byteRange{{0, 5}, {10, 5}}},
+		{[]byteRange{{10, 5}}, byteRange{20, 5}, []byteRange{{10, 5}, {20, 5}}},
+		{[]byteRange{{10, 5}}, byteRange{15, 5}, []byteRange{{10, 10}}},
+		{[]byteRange{{10, 5}}, byteRange{5, 5}, []byteRange{{5, 10}}},
+		{[]byteRange{{10, 5}}, byteRange{12, 1}, []byteRange{{10, 5}}},
+		{[]byteRange{{0, 5}, {10, 5}, {20, 5}}, byteRange{3, 19}, []byteRange{{0, 25}}},
+	} {
+		res := addRange(c.ranges, c.r)
+		assert.Equal(t, c.expected, res, c)
+	}
+}
+
+func TestMissingRanges(t *testing.T) {
+	for _, c := range []struct {
+		ranges   []byteRange
+		needed   byteRange
+		expected []byteRange
+	}{
+		{nil, byteRange{10, 5}, []byteRange{{10, 5}}},
+		{[]byteRange{{10, 5}}, byteRange{10, 5}, []byteRange{}},
+		{[]byteRange{{0, 100}}, byteRange{10, 5}, []byteRange{}},
+		{[]byteRange{{10, 5}}, byteRange{0, 30}, []byteRange{{0, 10}, {15, 15}}},
+		{[]byteRange{{0, 5}, {10, 5}, {40, 5}}, byteRange{3, 30}, []byteRange{{5, 5}, {15, 18}}},
+		{[]byteRange{{50, 5}}, byteRange{0, 10}, []byteRange{{0, 10}}},
+	} {
+		res := missingRanges(c.ranges, c.needed)
+		assert.Equal(t, c.expected, res, c)
+	}
+}
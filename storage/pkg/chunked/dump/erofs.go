@@ -0,0 +1,305 @@
+//go:build unix
+
+package dump
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+	storagePath "go.podman.io/storage/pkg/chunked/internal/path"
+	"go.podman.io/storage/pkg/erofs"
+)
+
+const (
+	// composefsMagic and composefsVersion identify the header composefs
+	// writes at the start of its EROFS images.
+	composefsMagic      = 0xd078629a
+	composefsVersion    = 1
+	composefsFlagHasACL = 1 << 0
+
+	overlayXattrPrefix = "trusted.overlay."
+	// fsverityHashAlgSHA256 is FS_VERITY_HASH_ALG_SHA256.
+	fsverityHashAlgSHA256 = 1
+)
+
+// fileMode converts the mode of a TOC entry to a fs.FileMode.
+func fileMode(entry *minimal.FileMetadata) (fs.FileMode, error) {
+	mode := fs.FileMode(entry.Mode) & fs.ModePerm
+	if entry.Mode&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if entry.Mode&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if entry.Mode&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch entry.Type {
+	case minimal.TypeReg, minimal.TypeLink:
+	case minimal.TypeChar:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case minimal.TypeBlock:
+		mode |= fs.ModeDevice
+	case minimal.TypeDir:
+		mode |= fs.ModeDir
+	case minimal.TypeFifo:
+		mode |= fs.ModeNamedPipe
+	case minimal.TypeSymlink:
+		mode |= fs.ModeSymlink
+	default:
+		return 0, fmt.Errorf("unknown type %s", entry.Type)
+	}
+	return mode, nil
+}
+
+// payloadPath returns the path of the file holding the data of a regular file
+// in the composefs backing store.
+func payloadPath(entry *minimal.FileMetadata) (string, error) {
+	d, err := digest.Parse(entry.Digest)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest %q for %q: %w", entry.Digest, entry.Name, err)
+	}
+	p, err := storagePath.RegularFilePathForValidatedDigest(d)
+	if err != nil {
+		return "", fmt.Errorf("determining physical file path for %q: %w", entry.Name, err)
+	}
+	return "/" + p, nil
+}
+
+// erofsEntry converts a TOC entry to an EROFS image entry.
+func erofsEntry(entry *minimal.FileMetadata, verityDigests map[string]string) (erofs.Entry, error) {
+	mode, err := fileMode(entry)
+	if err != nil {
+		return erofs.Entry{}, err
+	}
+	e := erofs.Entry{
+		Path:     storagePath.CleanAbsPath(entry.Name),
+		Mode:     mode,
+		UID:      uint32(entry.UID),
+		GID:      uint32(entry.GID),
+		Devmajor: uint32(entry.Devmajor),
+		Devminor: uint32(entry.Devminor),
+		Xattrs:   make(map[string][]byte, len(entry.Xattrs)),
+	}
+	if entry.ModTime != nil {
+		e.ModTime = *entry.ModTime
+	}
+	for k, vEncoded := range entry.Xattrs {
+		v, err := base64.StdEncoding.DecodeString(vEncoded)
+		if err != nil {
+			return erofs.Entry{}, fmt.Errorf("decode xattr %q: %w", k, err)
+		}
+		// Escape the overlay attributes of the file, so that overlay
+		// does not interpret them.
+		if strings.HasPrefix(k, overlayXattrPrefix) {
+			k = overlayXattrPrefix + "overlay." + strings.TrimPrefix(k, overlayXattrPrefix)
+		}
+		e.Xattrs[k] = v
+	}
+
+	switch entry.Type {
+	case minimal.TypeLink:
+		e.HardLink = storagePath.CleanAbsPath(entry.Linkname)
+	case minimal.TypeSymlink:
+		e.Linkname = entry.Linkname
+	case minimal.TypeReg:
+		e.Size = entry.Size
+		if entry.Size == 0 {
+			break
+		}
+		payload, err := payloadPath(entry)
+		if err != nil {
+			return erofs.Entry{}, err
+		}
+		metacopy := []byte{}
+		if verityDigest := verityDigests[payload]; verityDigest != "" {
+			d, err := hex.DecodeString(verityDigest)
+			if err != nil {
+				return erofs.Entry{}, fmt.Errorf("invalid fs-verity digest for %q: %w", entry.Name, err)
+			}
+			// struct ovl_metacopy: version, length, flags, digest algorithm and digest.
+			metacopy = append([]byte{0, byte(4 + len(d)), 0, fsverityHashAlgSHA256}, d...)
+		}
+		e.Xattrs[overlayXattrPrefix+"redirect"] = []byte(payload)
+		e.Xattrs[overlayXattrPrefix+"metacopy"] = metacopy
+	}
+	return e, nil
+}
+
+// GenerateComposefsImage writes a composefs EROFS image of the TOC to w, the
+// same way mkcomposefs would from the output of GenerateDump.  Whiteouts are
+// converted to the overlay format, so that the image can be used directly as
+// an overlay lower layer.
+func GenerateComposefsImage(w io.Writer, tocI any, verityDigests map[string]string) error {
+	toc, ok := tocI.(*minimal.TOC)
+	if !ok {
+		return fmt.Errorf("invalid TOC type")
+	}
+
+	var entries []erofs.Entry
+	indexes := make(map[string]int)
+	var opaqueDirs []string
+	hasACL := false
+	for i := range toc.Entries {
+		entry := &toc.Entries[i]
+		if entry.Type == minimal.TypeChunk {
+			continue
+		}
+		name := storagePath.CleanAbsPath(entry.Name)
+		base := path.Base(name)
+		switch {
+		case base == archive.WhiteoutOpaqueDir:
+			opaqueDirs = append(opaqueDirs, path.Dir(name))
+			continue
+		case strings.HasPrefix(base, archive.WhiteoutPrefix):
+			whiteout := *entry
+			whiteout.Name = path.Join(path.Dir(name), strings.TrimPrefix(base, archive.WhiteoutPrefix))
+			whiteout.Type = minimal.TypeChar
+			whiteout.Mode = 0
+			whiteout.Devmajor, whiteout.Devminor = 0, 0
+			entry = &whiteout
+		}
+		e, err := erofsEntry(entry, verityDigests)
+		if err != nil {
+			return err
+		}
+		for k := range e.Xattrs {
+			if strings.HasPrefix(k, "system.posix_acl_") {
+				hasACL = true
+			}
+		}
+		indexes[e.Path] = len(entries)
+		entries = append(entries, e)
+	}
+	for _, dir := range opaqueDirs {
+		i, found := indexes[dir]
+		if !found {
+			i = len(entries)
+			indexes[dir] = i
+			entries = append(entries, erofs.Entry{Path: dir, Mode: fs.ModeDir | 0o755, Xattrs: map[string][]byte{}})
+		}
+		entries[i].Xattrs[overlayXattrPrefix+"opaque"] = []byte("y")
+	}
+
+	header := make([]byte, 32)
+	binary.LittleEndian.PutUint32(header[0:], composefsMagic)
+	binary.LittleEndian.PutUint32(header[4:], composefsVersion)
+	if hasACL {
+		binary.LittleEndian.PutUint32(header[8:], composefsFlagHasACL)
+	}
+	return erofs.Write(w, entries, &erofs.Options{Header: header})
+}
+
+// FlattenedLayer holds the files visible in a layer stacked on top of all of
+// its parents, and the fs-verity digests of their data.  It has no whiteouts
+// and no hard links, which are stored as independent copies of the file.
+type FlattenedLayer struct {
+	TOC           *minimal.TOC      `json:"toc"`
+	VerityDigests map[string]string `json:"verityDigests,omitempty"`
+}
+
+// Flatten returns the FlattenedLayer for a layer with TOC tocI and the
+// specified fs-verity digests, stacked on top of parent, which can be nil for
+// a base layer.
+func Flatten(parent *FlattenedLayer, tocI any, verityDigests map[string]string) (*FlattenedLayer, error) {
+	toc, ok := tocI.(*minimal.TOC)
+	if !ok {
+		return nil, fmt.Errorf("invalid TOC type")
+	}
+
+	files := make(map[string]minimal.FileMetadata)
+	digests := make(map[string]string)
+	if parent != nil {
+		for _, e := range parent.TOC.Entries {
+			files[e.Name] = e
+		}
+		for k, v := range parent.VerityDigests {
+			digests[k] = v
+		}
+	}
+	for k, v := range verityDigests {
+		digests[k] = v
+	}
+	removeChildren := func(dir string) {
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		for name := range files {
+			if strings.HasPrefix(name, prefix) {
+				delete(files, name)
+			}
+		}
+	}
+
+	// Apply the whiteouts first, they only hide the content of the parents.
+	for _, e := range toc.Entries {
+		name := storagePath.CleanAbsPath(e.Name)
+		base := path.Base(name)
+		switch {
+		case base == archive.WhiteoutOpaqueDir:
+			removeChildren(path.Dir(name))
+		case strings.HasPrefix(base, archive.WhiteoutPrefix):
+			removed := path.Join(path.Dir(name), strings.TrimPrefix(base, archive.WhiteoutPrefix))
+			delete(files, removed)
+			removeChildren(removed)
+		}
+	}
+	for _, e := range toc.Entries {
+		name := storagePath.CleanAbsPath(e.Name)
+		if e.Type == minimal.TypeChunk || strings.HasPrefix(path.Base(name), archive.WhiteoutPrefix) {
+			continue
+		}
+		if e.Type == minimal.TypeLink {
+			target, found := files[storagePath.CleanAbsPath(e.Linkname)]
+			if !found || target.Type != minimal.TypeReg {
+				return nil, fmt.Errorf("invalid hard link target %q for %q", e.Linkname, e.Name)
+			}
+			e = target
+		}
+		if existing, found := files[name]; found && existing.Type == minimal.TypeDir && e.Type != minimal.TypeDir {
+			removeChildren(name)
+		}
+		e.Name = name
+		if e.Type != minimal.TypeSymlink {
+			e.Linkname = ""
+		}
+		e.Offset, e.EndOffset = 0, 0
+		e.ChunkSize, e.ChunkOffset, e.ChunkDigest, e.ChunkType = 0, 0, "", ""
+		files[name] = e
+	}
+
+	flattened := &FlattenedLayer{
+		TOC:           &minimal.TOC{Version: toc.Version},
+		VerityDigests: make(map[string]string),
+	}
+	for _, e := range files {
+		if e.Type == minimal.TypeReg && e.Size > 0 {
+			payload, err := payloadPath(&e)
+			if err != nil {
+				return nil, err
+			}
+			if d, found := digests[payload]; found {
+				flattened.VerityDigests[payload] = d
+			}
+		}
+		flattened.TOC.Entries = append(flattened.TOC.Entries, e)
+	}
+	// Sorting by path keeps directories before their content.
+	slices.SortFunc(flattened.TOC.Entries, func(a, b minimal.FileMetadata) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return flattened, nil
+}
+
+// WriteImage writes a composefs EROFS image with the content of the layer to w.
+func (l *FlattenedLayer) WriteImage(w io.Writer) error {
+	return GenerateComposefsImage(w, l.TOC, l.VerityDigests)
+}
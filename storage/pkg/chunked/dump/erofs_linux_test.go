@@ -0,0 +1,171 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/chunked/internal/minimal"
+	"go.podman.io/storage/pkg/loopback"
+	"golang.org/x/sys/unix"
+)
+
+// addObject stores content in the composefs backing store dataDir, and returns its digest.
+func addObject(t *testing.T, dataDir, content string) string {
+	d := digest.FromString(content)
+	p := filepath.Join(dataDir, d.Encoded()[:2], d.Encoded()[2:])
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	return d.String()
+}
+
+// mountErofs mounts the EROFS image written by writeImage.
+func mountErofs(t *testing.T, dir string, writeImage func(w *bytes.Buffer) error) string {
+	var buf bytes.Buffer
+	require.NoError(t, writeImage(&buf))
+	assert.Equal(t, uint32(composefsMagic), binary.LittleEndian.Uint32(buf.Bytes()))
+	imagePath := dir + ".erofs"
+	require.NoError(t, os.WriteFile(imagePath, buf.Bytes(), 0o600))
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	loop, err := loopback.AttachLoopDeviceRO(imagePath)
+	if err != nil {
+		t.Skipf("cannot attach a loop device: %v", err)
+	}
+	defer loop.Close()
+	if err := unix.Mount(loop.Name(), dir, "erofs", unix.MS_RDONLY, ""); err != nil {
+		if errors.Is(err, unix.ENODEV) {
+			t.Skip("the kernel does not support EROFS")
+		}
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, unix.Unmount(dir, unix.MNT_DETACH))
+	})
+	return dir
+}
+
+// mountOverlay mounts lowers on top of the data-only layer dataDir.
+func mountOverlay(t *testing.T, dir string, lowers []string, dataDir string) string {
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	opts := fmt.Sprintf("lowerdir=%s::%s,metacopy=on,redirect_dir=on", strings.Join(lowers, ":"), dataDir)
+	if err := unix.Mount("overlay", dir, "overlay", unix.MS_RDONLY, opts); err != nil {
+		if errors.Is(err, unix.EINVAL) {
+			t.Skip("the kernel does not support data-only overlay layers")
+		}
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, unix.Unmount(dir, unix.MNT_DETACH))
+	})
+	return dir
+}
+
+func TestComposefsImage(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+
+	base := &minimal.TOC{Version: 1, Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeDir, Name: "etc/", Mode: 0o755},
+		{Type: minimal.TypeReg, Name: "etc/hostname", Mode: 0o644, Size: 5, Digest: addObject(t, dataDir, "base\n")},
+		{Type: minimal.TypeReg, Name: "etc/removed", Mode: 0o644, Size: 8, Digest: addObject(t, dataDir, "removed\n")},
+		{Type: minimal.TypeReg, Name: "opt/dir/a", Mode: 0o600, Size: 2, Digest: addObject(t, dataDir, "a\n")},
+		{Type: minimal.TypeSymlink, Name: "bin", Linkname: "usr/bin", Mode: 0o777},
+		{Type: minimal.TypeReg, Name: "empty", Mode: 0o644},
+	}}
+	layer := &minimal.TOC{Version: 1, Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeReg, Name: "etc/.wh.removed"},
+		{Type: minimal.TypeReg, Name: "opt/dir/.wh..wh..opq"},
+		{Type: minimal.TypeReg, Name: "opt/dir/b", Mode: 0o600, Size: 2, Digest: addObject(t, dataDir, "b\n")},
+		{Type: minimal.TypeReg, Name: "etc/hostname", Mode: 0o644, Size: 6, Digest: addObject(t, dataDir, "layer\n"),
+			Xattrs: map[string]string{"user.comment": "dGVzdA=="}},
+		{Type: minimal.TypeLink, Name: "etc/hosts", Linkname: "etc/hostname"},
+	}}
+
+	checkContent := func(t *testing.T, root string) {
+		for p, content := range map[string]string{
+			"etc/hostname": "layer\n",
+			"etc/hosts":    "layer\n",
+			"opt/dir/b":    "b\n",
+			"empty":        "",
+		} {
+			data, err := os.ReadFile(filepath.Join(root, p))
+			require.NoError(t, err, p)
+			assert.Equal(t, content, string(data), p)
+		}
+		_, err := os.Lstat(filepath.Join(root, "etc/removed"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = os.Lstat(filepath.Join(root, "opt/dir/a"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		target, err := os.Readlink(filepath.Join(root, "bin"))
+		require.NoError(t, err)
+		assert.Equal(t, "usr/bin", target)
+		value := make([]byte, 16)
+		n, err := unix.Lgetxattr(filepath.Join(root, "etc/hostname"), "user.comment", value)
+		require.NoError(t, err)
+		assert.Equal(t, "test", string(value[:n]))
+	}
+
+	t.Run("layers", func(t *testing.T) {
+		baseMount := mountErofs(t, filepath.Join(tmp, "base"), func(w *bytes.Buffer) error {
+			return GenerateComposefsImage(w, base, nil)
+		})
+		layerMount := mountErofs(t, filepath.Join(tmp, "layer"), func(w *bytes.Buffer) error {
+			return GenerateComposefsImage(w, layer, nil)
+		})
+		checkContent(t, mountOverlay(t, filepath.Join(tmp, "merged"), []string{layerMount, baseMount}, dataDir))
+	})
+
+	t.Run("flattened", func(t *testing.T) {
+		flattenedBase, err := Flatten(nil, base, nil)
+		require.NoError(t, err)
+		flattened, err := Flatten(flattenedBase, layer, nil)
+		require.NoError(t, err)
+		for _, e := range flattened.TOC.Entries {
+			assert.NotContains(t, e.Name, ".wh.")
+			assert.NotEqual(t, minimal.TypeLink, e.Type, e.Name)
+		}
+		flattenedMount := mountErofs(t, filepath.Join(tmp, "flattened"), func(w *bytes.Buffer) error {
+			return flattened.WriteImage(w)
+		})
+		checkContent(t, mountOverlay(t, filepath.Join(tmp, "flattened-merged"), []string{flattenedMount}, dataDir))
+	})
+}
+
+func TestFlatten(t *testing.T) {
+	d := digest.FromString("data").String()
+	parent, err := Flatten(nil, &minimal.TOC{Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeDir, Name: "dir/"},
+		{Type: minimal.TypeReg, Name: "dir/file", Size: 4, Digest: d},
+		{Type: minimal.TypeReg, Name: "file", Size: 4, Digest: d},
+	}}, map[string]string{"/" + d[7:9] + "/" + d[9:]: "0123"})
+	require.NoError(t, err)
+	assert.Len(t, parent.VerityDigests, 1)
+
+	// Replacing a directory with a file hides its content, and digests are
+	// only kept for the files still in use.
+	flattened, err := Flatten(parent, &minimal.TOC{Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeSymlink, Name: "dir", Linkname: "file"},
+		{Type: minimal.TypeReg, Name: ".wh.file"},
+	}}, nil)
+	require.NoError(t, err)
+	require.Len(t, flattened.TOC.Entries, 1)
+	assert.Equal(t, "/dir", flattened.TOC.Entries[0].Name)
+	assert.Empty(t, flattened.VerityDigests)
+
+	_, err = Flatten(nil, &minimal.TOC{Entries: []minimal.FileMetadata{
+		{Type: minimal.TypeLink, Name: "link", Linkname: "missing"},
+	}}, nil)
+	assert.Error(t, err)
+}
@@ -30,11 +30,17 @@ type OverlayOptionsConfig struct {
 	SkipMountHome string `toml:"skip_mount_home,omitempty"`
 	// Specify whether composefs must be used to mount the data layers
 	UseComposefs string `toml:"use_composefs,omitempty"`
+	// Specify whether the composefs image of a layer must include the
+	// files of all its parents
+	ComposefsFlatten string `toml:"composefs_flatten,omitempty"`
 	// ForceMask indicates the permissions mask (e.g. "0755") to use for new
 	// files and directories
 	ForceMask string `toml:"force_mask,omitempty"`
 	// Sync controls filesystem sync during layer creation
 	Sync string `toml:"sync,omitempty"`
+	// ObjectStore is how read-only layers share identical files
+	// ("hardlink" or "reflink")
+	ObjectStore string `toml:"object_store,omitempty"`
 }
 
 type VfsOptionsConfig struct {
@@ -43,6 +49,9 @@ type VfsOptionsConfig struct {
 	IgnoreChownErrors string `toml:"ignore_chown_errors,omitempty"`
 	// Sync controls filesystem sync during layer creation
 	Sync string `toml:"sync,omitempty"`
+	// ObjectStore is how read-only layers share identical files
+	// ("hardlink" or "reflink")
+	ObjectStore string `toml:"object_store,omitempty"`
 }
 
 type ZfsOptionsConfig struct {
@@ -163,9 +172,15 @@ func GetGraphDriverOptions(options OptionsConfig) []string {
 	if options.Overlay.UseComposefs != "" {
 		doptions = append(doptions, fmt.Sprintf("overlay.use_composefs=%s", options.Overlay.UseComposefs))
 	}
+	if options.Overlay.ComposefsFlatten != "" {
+		doptions = append(doptions, fmt.Sprintf("overlay.composefs_flatten=%s", options.Overlay.ComposefsFlatten))
+	}
 	if options.Overlay.Sync != "" {
 		doptions = append(doptions, fmt.Sprintf("overlay.sync=%s", options.Overlay.Sync))
 	}
+	if options.Overlay.ObjectStore != "" {
+		doptions = append(doptions, fmt.Sprintf("overlay.object_store=%s", options.Overlay.ObjectStore))
+	}
 
 	if options.Vfs.IgnoreChownErrors != "" {
 		doptions = append(doptions, fmt.Sprintf("vfs.ignore_chown_errors=%s", options.Vfs.IgnoreChownErrors))
@@ -173,6 +188,9 @@ func GetGraphDriverOptions(options OptionsConfig) []string {
 	if options.Vfs.Sync != "" {
 		doptions = append(doptions, fmt.Sprintf("vfs.sync=%s", options.Vfs.Sync))
 	}
+	if options.Vfs.ObjectStore != "" {
+		doptions = append(doptions, fmt.Sprintf("vfs.object_store=%s", options.Vfs.ObjectStore))
+	}
 
 	if options.Zfs.Name != "" {
 		doptions = append(doptions, fmt.Sprintf("zfs.fsname=%s", options.Zfs.Name))
@@ -109,6 +109,12 @@ func TestOverlayOptions(t *testing.T) {
 		t.Fatalf("Expected to find 'use_composefs' options, got %v", doptions)
 	}
 
+	options.Overlay.ComposefsFlatten = "true"
+	doptions = GetGraphDriverOptions(options)
+	if !searchOptions(doptions, "composefs_flatten") {
+		t.Fatalf("Expected to find 'composefs_flatten' options, got %v", doptions)
+	}
+
 	// Make sure Overlay.Size takes precedence
 	options.Overlay.Size = s100
 	doptions = GetGraphDriverOptions(options)
@@ -126,6 +132,12 @@ func TestOverlayOptions(t *testing.T) {
 	if !searchOptions(doptions, "overlay.sync=filesystem") {
 		t.Fatalf("Expected to find overlay sync option in %v", doptions)
 	}
+
+	options.Overlay.ObjectStore = "hardlink"
+	doptions = GetGraphDriverOptions(options)
+	if !searchOptions(doptions, "overlay.object_store=hardlink") {
+		t.Fatalf("Expected to find overlay object_store option in %v", doptions)
+	}
 }
 
 func TestVfsOptions(t *testing.T) {
@@ -155,6 +167,12 @@ func TestVfsOptions(t *testing.T) {
 	if !searchOptions(doptions, "vfs.sync=filesystem") {
 		t.Fatalf("Expected to find vfs sync option in %v", doptions)
 	}
+
+	options.Vfs.ObjectStore = "reflink"
+	doptions = GetGraphDriverOptions(options)
+	if !searchOptions(doptions, "vfs.object_store=reflink") {
+		t.Fatalf("Expected to find vfs object_store option in %v", doptions)
+	}
 }
 
 func TestZfsOptions(t *testing.T) {
@@ -0,0 +1,595 @@
+// Package erofs writes metadata-only EROFS file system images.
+//
+// The images contain the complete directory tree, inode metadata, symbolic
+// link targets and extended attributes, but no regular file data: regular
+// files are stored sparse, and are expected to carry overlay metacopy and
+// redirect extended attributes which point overlay at the file contents in a
+// data-only lower layer, as done by composefs.
+package erofs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// On-disk format constants, see fs/erofs/erofs_fs.h in the Linux kernel.
+const (
+	superBlockOffset = 1024
+	superBlockMagic  = 0xe0f5e1e2
+
+	blockSizeBits = 12
+	blockSize     = 1 << blockSizeBits
+
+	inodeSlotSize     = 32
+	extendedInodeSize = 64
+	xattrHeaderSize   = 12
+	xattrEntrySize    = 4
+	direntSize        = 12
+	blockMapEntrySize = 4
+	maxNameLen        = 255
+
+	nullAddr = 0xffffffff
+
+	featureIncompatChunkedFile = 0x00000004
+	chunkFormatBlockBitsMask   = 0x1f
+
+	inodeVersionExtended = 1
+	layoutFlatPlain      = 0
+	layoutFlatInline     = 2
+	layoutChunkBased     = 4
+
+	ftUnknown = 0
+	ftRegular = 1
+	ftDir     = 2
+	ftChrdev  = 3
+	ftBlkdev  = 4
+	ftFifo    = 5
+	ftSocket  = 6
+	ftSymlink = 7
+
+	xattrIndexUser            = 1
+	xattrIndexPosixACLAccess  = 2
+	xattrIndexPosixACLDefault = 3
+	xattrIndexTrusted         = 4
+	xattrIndexSecurity        = 6
+)
+
+// MaxHeaderSize is the maximum length of Options.Header.
+const MaxHeaderSize = superBlockOffset
+
+// Entry describes a single file in the image.
+type Entry struct {
+	// Path is the absolute path of the file in the image.  Parent
+	// directories that have no entry of their own are created with mode
+	// 0755, owned by root.
+	Path string
+	// Mode holds the file type and permission bits.
+	Mode fs.FileMode
+	UID  uint32
+	GID  uint32
+	// ModTime is the modification time of the file.  The zero value is
+	// stored as the epoch.
+	ModTime time.Time
+	// Size is the size of a regular file.  The data itself is not stored.
+	Size int64
+	// Devmajor and Devminor are the device numbers of device nodes.
+	Devmajor uint32
+	Devminor uint32
+	// Linkname is the target of a symbolic link.
+	Linkname string
+	// HardLink, if set, is the path of an earlier regular file entry this
+	// entry is a hard link to; all the other fields are ignored.
+	HardLink string
+	// Xattrs are the extended attributes of the file.  Only the "user.",
+	// "trusted.", "security." and POSIX ACL attributes can be stored.
+	Xattrs map[string][]byte
+}
+
+// Options controls how the image is written.
+type Options struct {
+	// Header is written at the start of the image, in the area the EROFS
+	// superblock leaves unused.  It must not be longer than MaxHeaderSize.
+	Header []byte
+}
+
+type xattr struct {
+	index uint8
+	name  string
+	value []byte
+}
+
+type node struct {
+	mode     fs.FileMode
+	uid      uint32
+	gid      uint32
+	modTime  time.Time
+	size     uint64
+	devmajor uint32
+	devminor uint32
+	linkname string
+	xattrs   []xattr
+
+	parent   *node
+	children map[string]*node
+	names    []string // sorted directory entries, including "." and ".."
+	nlink    uint32
+
+	// Layout information.
+	ino        uint32
+	nid        uint64
+	layout     uint16
+	xattrBody  []byte
+	dataSize   uint64 // size of the directory or symbolic link data
+	blkaddr    uint32 // first data block of a flat plain inode
+	chunkBits  uint
+	chunkCount uint64
+}
+
+func (n *node) isDir() bool {
+	return n.mode.IsDir()
+}
+
+func newDirNode(parent *node) *node {
+	n := &node{
+		mode:     fs.ModeDir | 0o755,
+		children: make(map[string]*node),
+	}
+	n.parent = parent
+	if parent == nil {
+		n.parent = n
+	}
+	return n
+}
+
+func splitXattr(key string) (uint8, string, error) {
+	switch {
+	case key == "system.posix_acl_access":
+		return xattrIndexPosixACLAccess, "", nil
+	case key == "system.posix_acl_default":
+		return xattrIndexPosixACLDefault, "", nil
+	case strings.HasPrefix(key, "user."):
+		return xattrIndexUser, strings.TrimPrefix(key, "user."), nil
+	case strings.HasPrefix(key, "trusted."):
+		return xattrIndexTrusted, strings.TrimPrefix(key, "trusted."), nil
+	case strings.HasPrefix(key, "security."):
+		return xattrIndexSecurity, strings.TrimPrefix(key, "security."), nil
+	}
+	return 0, "", fmt.Errorf("extended attribute %q cannot be stored in an EROFS image", key)
+}
+
+func (n *node) setAttributes(e *Entry) error {
+	n.mode = e.Mode
+	n.uid = e.UID
+	n.gid = e.GID
+	n.modTime = e.ModTime
+	n.devmajor = e.Devmajor
+	n.devminor = e.Devminor
+	n.linkname = e.Linkname
+	n.size = 0
+	switch {
+	case e.Mode.IsRegular():
+		if e.Size < 0 {
+			return fmt.Errorf("invalid size %d for %q", e.Size, e.Path)
+		}
+		n.size = uint64(e.Size)
+	case e.Mode&fs.ModeSymlink != 0:
+		if e.Linkname == "" || len(e.Linkname) >= blockSize {
+			return fmt.Errorf("invalid symbolic link target for %q", e.Path)
+		}
+		n.size = uint64(len(e.Linkname))
+	}
+	n.xattrs = n.xattrs[:0]
+	for key, value := range e.Xattrs {
+		index, name, err := splitXattr(key)
+		if err != nil {
+			return fmt.Errorf("%q: %w", e.Path, err)
+		}
+		if len(name) > maxNameLen || len(value) > 0xffff {
+			return fmt.Errorf("extended attribute %q of %q is too long", key, e.Path)
+		}
+		n.xattrs = append(n.xattrs, xattr{index: index, name: name, value: value})
+	}
+	slices.SortFunc(n.xattrs, func(a, b xattr) int {
+		if a.index != b.index {
+			return int(a.index) - int(b.index)
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	return nil
+}
+
+type tree struct {
+	root *node
+}
+
+func (t *tree) lookup(p string) *node {
+	n := t.root
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if n = n.children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func (t *tree) mkdirAll(p string) (*node, error) {
+	n := t.root
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		child := n.children[name]
+		if child == nil {
+			child = newDirNode(n)
+			n.children[name] = child
+		} else if !child.isDir() {
+			return nil, fmt.Errorf("%q is not a directory", p)
+		}
+		n = child
+	}
+	return n, nil
+}
+
+func (t *tree) add(e *Entry) error {
+	if !strings.HasPrefix(e.Path, "/") {
+		return fmt.Errorf("path %q is not absolute", e.Path)
+	}
+	p := path.Clean(e.Path)
+	if p == "/" {
+		if e.HardLink != "" || !e.Mode.IsDir() {
+			return errors.New("the root of the image must be a directory")
+		}
+		return t.root.setAttributes(e)
+	}
+	parent, err := t.mkdirAll(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	name := path.Base(p)
+	if len(name) > maxNameLen {
+		return fmt.Errorf("file name %q is too long", name)
+	}
+	if e.HardLink != "" {
+		target := t.lookup(path.Clean("/" + e.HardLink))
+		if target == nil || target.isDir() {
+			return fmt.Errorf("invalid hard link target %q for %q", e.HardLink, e.Path)
+		}
+		parent.children[name] = target
+		return nil
+	}
+	n := parent.children[name]
+	if n == nil || !n.isDir() || !e.Mode.IsDir() {
+		if e.Mode.IsDir() {
+			n = newDirNode(parent)
+		} else {
+			n = &node{parent: parent}
+		}
+		parent.children[name] = n
+	}
+	return n.setAttributes(e)
+}
+
+// stMode returns the mode of the node in the format of st_mode.
+func (n *node) stMode() uint16 {
+	mode := uint16(n.mode.Perm())
+	if n.mode&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if n.mode&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if n.mode&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	switch n.fileType() {
+	case ftDir:
+		mode |= 0o040000
+	case ftSymlink:
+		mode |= 0o120000
+	case ftChrdev:
+		mode |= 0o020000
+	case ftBlkdev:
+		mode |= 0o060000
+	case ftFifo:
+		mode |= 0o010000
+	case ftSocket:
+		mode |= 0o140000
+	default:
+		mode |= 0o100000
+	}
+	return mode
+}
+
+func (n *node) fileType() uint8 {
+	switch {
+	case n.mode.IsDir():
+		return ftDir
+	case n.mode&fs.ModeSymlink != 0:
+		return ftSymlink
+	case n.mode&fs.ModeCharDevice != 0:
+		return ftChrdev
+	case n.mode&fs.ModeDevice != 0:
+		return ftBlkdev
+	case n.mode&fs.ModeNamedPipe != 0:
+		return ftFifo
+	case n.mode&fs.ModeSocket != 0:
+		return ftSocket
+	case n.mode.IsRegular():
+		return ftRegular
+	}
+	return ftUnknown
+}
+
+// dirBlocks splits the sorted directory entries in groups which fit in a
+// directory block, and returns the groups and the size of the directory.
+func dirBlocks(names []string) ([][]string, uint64) {
+	var groups [][]string
+	used := blockSize
+	for _, name := range names {
+		need := direntSize + len(name)
+		if used+need > blockSize {
+			groups = append(groups, nil)
+			used = 0
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], name)
+		used += need
+	}
+	return groups, uint64(len(groups)-1)*blockSize + uint64(used)
+}
+
+func (n *node) buildXattrBody() {
+	if len(n.xattrs) == 0 {
+		n.xattrBody = nil
+		return
+	}
+	body := make([]byte, xattrHeaderSize)
+	for _, x := range n.xattrs {
+		entry := make([]byte, xattrEntrySize, xattrEntrySize+len(x.name)+len(x.value)+3)
+		entry[0] = uint8(len(x.name))
+		entry[1] = x.index
+		binary.LittleEndian.PutUint16(entry[2:], uint16(len(x.value)))
+		entry = append(entry, x.name...)
+		entry = append(entry, x.value...)
+		for len(entry)%xattrEntrySize != 0 {
+			entry = append(entry, 0)
+		}
+		body = append(body, entry...)
+	}
+	n.xattrBody = body
+}
+
+// xattrICount returns the value of i_xattr_icount for the node.
+func (n *node) xattrICount() uint16 {
+	if len(n.xattrBody) == 0 {
+		return 0
+	}
+	return uint16((len(n.xattrBody)-xattrHeaderSize)/xattrEntrySize + 1)
+}
+
+// encodeDev encodes the device numbers the way the kernel's new_encode_dev() does.
+func encodeDev(major, minor uint32) uint32 {
+	return (minor & 0xff) | (major << 8) | ((minor &^ 0xff) << 12)
+}
+
+func alignUp(v, alignment uint64) uint64 {
+	return (v + alignment - 1) / alignment * alignment
+}
+
+// Write writes an EROFS image containing entries to w.
+func Write(w io.Writer, entries []Entry, options *Options) error {
+	if options == nil {
+		options = &Options{}
+	}
+	if len(options.Header) > MaxHeaderSize {
+		return fmt.Errorf("header of %d bytes is longer than %d bytes", len(options.Header), MaxHeaderSize)
+	}
+
+	t := &tree{root: newDirNode(nil)}
+	for i := range entries {
+		if err := t.add(&entries[i]); err != nil {
+			return err
+		}
+	}
+
+	// Visit the tree breadth first, so that the root directory gets the
+	// first inode: the superblock has only 16 bits for its nid.
+	var nodes []*node
+	visited := make(map[*node]bool)
+	queue := []*node{t.root}
+	visited[t.root] = true
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		nodes = append(nodes, n)
+		if !n.isDir() {
+			continue
+		}
+		n.names = []string{".", ".."}
+		n.nlink = 2
+		for name := range n.children {
+			n.names = append(n.names, name)
+		}
+		slices.Sort(n.names)
+		for _, name := range n.names {
+			child := n.children[name]
+			if child == nil {
+				continue
+			}
+			if child.isDir() {
+				n.nlink++
+			} else {
+				child.nlink++
+			}
+			if !visited[child] {
+				visited[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	// Lay out the inodes in the metadata area, which starts right after
+	// the superblock.  The metadata of an inode is kept in a single block
+	// whenever it fits.
+	const metaBlkaddr = 1
+	var metaSize uint64
+	incompatFeatures := uint32(0)
+	for i, n := range nodes {
+		n.ino = uint32(i + 1)
+		n.buildXattrBody()
+		inodeSize := uint64(extendedInodeSize + len(n.xattrBody))
+		tailSize := uint64(0)
+
+		switch n.fileType() {
+		case ftDir:
+			_, n.dataSize = dirBlocks(n.names)
+			n.size = n.dataSize
+		case ftSymlink:
+			n.dataSize = n.size
+		}
+		switch {
+		case n.fileType() == ftRegular && n.size > 0:
+			n.layout = layoutChunkBased
+			n.chunkBits = blockSizeBits
+			if b := uint(bits.Len64(n.size - 1)); b > n.chunkBits {
+				n.chunkBits = min(b, blockSizeBits+chunkFormatBlockBitsMask)
+			}
+			n.chunkCount = (n.size + (1 << n.chunkBits) - 1) >> n.chunkBits
+			tailSize = n.chunkCount * blockMapEntrySize
+			incompatFeatures |= featureIncompatChunkedFile
+		case n.dataSize > 0 && inodeSize+n.dataSize <= blockSize:
+			n.layout = layoutFlatInline
+			tailSize = n.dataSize
+		default:
+			n.layout = layoutFlatPlain
+		}
+
+		metaSize = alignUp(metaSize, inodeSlotSize)
+		total := inodeSize + tailSize
+		if offset := metaSize % blockSize; total <= blockSize && offset+total > blockSize {
+			metaSize = alignUp(metaSize, blockSize)
+		}
+		n.nid = metaSize / inodeSlotSize
+		metaSize += total
+	}
+	if t.root.nid > 0xffff {
+		return errors.New("internal error: the root directory nid does not fit in the superblock")
+	}
+
+	// Directories and symbolic links that were not inlined are stored in
+	// the data area, after the metadata.
+	nextBlock := metaBlkaddr + alignUp(metaSize, blockSize)/blockSize
+	for _, n := range nodes {
+		if n.layout == layoutFlatPlain && n.dataSize > 0 {
+			n.blkaddr = uint32(nextBlock)
+			nextBlock += alignUp(n.dataSize, blockSize) / blockSize
+		}
+	}
+	if nextBlock > nullAddr {
+		return errors.New("the image is too large")
+	}
+
+	image := make([]byte, nextBlock*blockSize)
+	copy(image, options.Header)
+
+	sb := image[superBlockOffset:]
+	binary.LittleEndian.PutUint32(sb[0:], superBlockMagic)
+	sb[12] = blockSizeBits
+	binary.LittleEndian.PutUint16(sb[14:], uint16(t.root.nid))
+	binary.LittleEndian.PutUint64(sb[16:], uint64(len(nodes)))
+	binary.LittleEndian.PutUint32(sb[36:], uint32(nextBlock))
+	binary.LittleEndian.PutUint32(sb[40:], metaBlkaddr)
+	binary.LittleEndian.PutUint32(sb[80:], incompatFeatures)
+
+	for _, n := range nodes {
+		pos := metaBlkaddr*blockSize + n.nid*inodeSlotSize
+		inode := image[pos:]
+		binary.LittleEndian.PutUint16(inode[0:], inodeVersionExtended|n.layout<<1)
+		binary.LittleEndian.PutUint16(inode[2:], n.xattrICount())
+		binary.LittleEndian.PutUint16(inode[4:], n.stMode())
+		binary.LittleEndian.PutUint64(inode[8:], n.size)
+		switch {
+		case n.layout == layoutChunkBased:
+			binary.LittleEndian.PutUint16(inode[16:], uint16(n.chunkBits-blockSizeBits))
+		case n.layout == layoutFlatPlain && n.dataSize > 0:
+			binary.LittleEndian.PutUint32(inode[16:], n.blkaddr)
+		case n.fileType() == ftChrdev || n.fileType() == ftBlkdev:
+			binary.LittleEndian.PutUint32(inode[16:], encodeDev(n.devmajor, n.devminor))
+		}
+		binary.LittleEndian.PutUint32(inode[20:], n.ino)
+		binary.LittleEndian.PutUint32(inode[24:], n.uid)
+		binary.LittleEndian.PutUint32(inode[28:], n.gid)
+		var sec int64
+		var nsec uint32
+		if !n.modTime.IsZero() {
+			sec, nsec = n.modTime.Unix(), uint32(n.modTime.Nanosecond())
+		}
+		binary.LittleEndian.PutUint64(inode[32:], uint64(sec))
+		binary.LittleEndian.PutUint32(inode[40:], nsec)
+		binary.LittleEndian.PutUint32(inode[44:], max(n.nlink, 1))
+		tail := inode[extendedInodeSize:]
+		tail = tail[copy(tail, n.xattrBody):]
+
+		var data []byte
+		switch n.fileType() {
+		case ftDir:
+			data = n.dirData()
+		case ftSymlink:
+			data = []byte(n.linkname)
+		}
+		switch n.layout {
+		case layoutChunkBased:
+			for i := range n.chunkCount {
+				binary.LittleEndian.PutUint32(tail[i*blockMapEntrySize:], nullAddr)
+			}
+		case layoutFlatInline:
+			copy(tail, data)
+		case layoutFlatPlain:
+			copy(image[uint64(n.blkaddr)*blockSize:], data)
+		}
+	}
+
+	_, err := w.Write(image)
+	return err
+}
+
+// dirData returns the contents of a directory inode, after its children
+// were assigned a nid.
+func (n *node) dirData() []byte {
+	groups, size := dirBlocks(n.names)
+	data := make([]byte, 0, alignUp(size, blockSize))
+	for i, group := range groups {
+		block := make([]byte, direntSize*len(group), blockSize)
+		for j, name := range group {
+			var child *node
+			switch name {
+			case ".":
+				child = n
+			case "..":
+				child = n.parent
+			default:
+				child = n.children[name]
+			}
+			dirent := block[j*direntSize:]
+			binary.LittleEndian.PutUint64(dirent[0:], child.nid)
+			binary.LittleEndian.PutUint16(dirent[8:], uint16(len(block)))
+			dirent[10] = child.fileType()
+			block = append(block, name...)
+		}
+		if i < len(groups)-1 {
+			block = block[:blockSize]
+		}
+		data = append(data, block...)
+	}
+	return data
+}
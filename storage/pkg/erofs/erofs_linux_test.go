@@ -0,0 +1,161 @@
+package erofs
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/loopback"
+	"golang.org/x/sys/unix"
+)
+
+// mountImage writes the image to a file and mounts it read-only, skipping the
+// test if the kernel or the privileges to do so are missing.
+func mountImage(t *testing.T, entries []Entry) string {
+	if os.Geteuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "image.erofs")
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, entries, &Options{Header: []byte("header")}))
+	require.NoError(t, os.WriteFile(imagePath, buf.Bytes(), 0o600))
+
+	mountPoint := filepath.Join(dir, "mnt")
+	require.NoError(t, os.Mkdir(mountPoint, 0o700))
+	loop, err := loopback.AttachLoopDeviceRO(imagePath)
+	if err != nil {
+		t.Skipf("cannot attach a loop device: %v", err)
+	}
+	defer loop.Close()
+	if err := unix.Mount(loop.Name(), mountPoint, "erofs", unix.MS_RDONLY, ""); err != nil {
+		if err == unix.ENODEV {
+			t.Skip("the kernel does not support EROFS")
+		}
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		assert.NoError(t, unix.Unmount(mountPoint, unix.MNT_DETACH))
+	})
+	return mountPoint
+}
+
+func TestWrite(t *testing.T) {
+	mtime := time.Unix(1700000000, 123)
+	manyFiles := make([]Entry, 0, 400)
+	for i := range 400 {
+		manyFiles = append(manyFiles, Entry{
+			Path: fmt.Sprintf("/many/file-with-a-rather-long-name-%04d", i),
+			Mode: 0o600,
+		})
+	}
+	entries := append([]Entry{
+		{Path: "/", Mode: fs.ModeDir | 0o711, ModTime: mtime},
+		{Path: "/etc/hostname", Mode: 0o644, UID: 1000, GID: 100000, ModTime: mtime, Size: 10,
+			Xattrs: map[string][]byte{
+				"trusted.overlay.redirect": []byte("/ab/cdef"),
+				"trusted.overlay.metacopy": nil,
+				"user.comment":             []byte("a comment"),
+				"security.test":            {1, 2, 3},
+			}},
+		{Path: "/etc/hosts", HardLink: "/etc/hostname"},
+		{Path: "/usr/bin/tool", Mode: fs.ModeSetuid | 0o755, Size: 1 << 33},
+		{Path: "/bin", Mode: fs.ModeSymlink | 0o777, Linkname: "usr/bin"},
+		{Path: "/dev/null", Mode: fs.ModeDevice | fs.ModeCharDevice | 0o666, Devmajor: 1, Devminor: 3},
+		{Path: "/dev/sda", Mode: fs.ModeDevice | 0o660, Devmajor: 8, Devminor: 300},
+		{Path: "/dev/fifo", Mode: fs.ModeNamedPipe | 0o600},
+		{Path: "/empty", Mode: 0o644},
+		{Path: "/tmp", Mode: fs.ModeDir | fs.ModeSticky | 0o777},
+	}, manyFiles...)
+	mnt := mountImage(t, entries)
+
+	var st unix.Stat_t
+	require.NoError(t, unix.Lstat(mnt, &st))
+	assert.Equal(t, uint32(unix.S_IFDIR|0o711), st.Mode)
+	assert.Equal(t, mtime.Unix(), st.Mtim.Sec)
+
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "etc/hostname"), &st))
+	assert.Equal(t, uint32(unix.S_IFREG|0o644), st.Mode)
+	assert.Equal(t, int64(10), st.Size)
+	assert.Equal(t, uint32(1000), st.Uid)
+	assert.Equal(t, uint32(100000), st.Gid)
+	assert.Equal(t, uint64(2), uint64(st.Nlink))
+	assert.Equal(t, int64(123), st.Mtim.Nsec)
+	hostnameIno := st.Ino
+	data, err := os.ReadFile(filepath.Join(mnt, "etc/hostname"))
+	require.NoError(t, err)
+	assert.Equal(t, make([]byte, 10), data)
+
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "etc/hosts"), &st))
+	assert.Equal(t, hostnameIno, st.Ino)
+
+	for name, value := range map[string]string{
+		"trusted.overlay.redirect": "/ab/cdef",
+		"trusted.overlay.metacopy": "",
+		"user.comment":             "a comment",
+		"security.test":            "\x01\x02\x03",
+	} {
+		buf := make([]byte, 64)
+		n, err := unix.Lgetxattr(filepath.Join(mnt, "etc/hostname"), name, buf)
+		require.NoError(t, err, name)
+		assert.Equal(t, value, string(buf[:n]), name)
+	}
+
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "usr/bin/tool"), &st))
+	assert.Equal(t, uint32(unix.S_IFREG|unix.S_ISUID|0o755), st.Mode)
+	assert.Equal(t, int64(1<<33), st.Size)
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "usr"), &st))
+	assert.Equal(t, uint32(unix.S_IFDIR|0o755), st.Mode)
+
+	target, err := os.Readlink(filepath.Join(mnt, "bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "usr/bin", target)
+
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "dev/null"), &st))
+	assert.Equal(t, uint32(unix.S_IFCHR|0o666), st.Mode)
+	assert.Equal(t, unix.Mkdev(1, 3), uint64(st.Rdev))
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "dev/sda"), &st))
+	assert.Equal(t, uint32(unix.S_IFBLK|0o660), st.Mode)
+	assert.Equal(t, unix.Mkdev(8, 300), uint64(st.Rdev))
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "dev/fifo"), &st))
+	assert.Equal(t, uint32(unix.S_IFIFO|0o600), st.Mode)
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "empty"), &st))
+	assert.Equal(t, int64(0), st.Size)
+	require.NoError(t, unix.Lstat(filepath.Join(mnt, "tmp"), &st))
+	assert.Equal(t, uint32(unix.S_IFDIR|unix.S_ISVTX|0o777), st.Mode)
+
+	// A directory spanning several blocks.
+	dirEntries, err := os.ReadDir(filepath.Join(mnt, "many"))
+	require.NoError(t, err)
+	require.Len(t, dirEntries, len(manyFiles))
+	for i, e := range dirEntries {
+		assert.Equal(t, filepath.Base(manyFiles[i].Path), e.Name())
+	}
+	for _, e := range manyFiles {
+		_, err := os.Lstat(filepath.Join(mnt, e.Path))
+		assert.NoError(t, err)
+	}
+	_, err = os.Lstat(filepath.Join(mnt, "many/missing"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestWriteErrors(t *testing.T) {
+	for _, entries := range [][]Entry{
+		{{Path: "relative", Mode: 0o644}},
+		{{Path: "/", Mode: 0o644}},
+		{{Path: "/file", Mode: 0o644}, {Path: "/file/child", Mode: 0o644}},
+		{{Path: "/link", HardLink: "/missing"}},
+		{{Path: "/file", Mode: 0o644, Xattrs: map[string][]byte{"system.unknown": nil}}},
+	} {
+		err := Write(&bytes.Buffer{}, entries, nil)
+		assert.Error(t, err, entries)
+	}
+	err := Write(&bytes.Buffer{}, nil, &Options{Header: make([]byte, MaxHeaderSize+1)})
+	assert.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	_ "embed"
 	"encoding/base64"
 	"errors"
@@ -32,6 +33,7 @@ import (
 	"go.podman.io/storage/pkg/idtools"
 	"go.podman.io/storage/pkg/ioutils"
 	"go.podman.io/storage/pkg/lockfile"
+	"go.podman.io/storage/pkg/stringid"
 	"go.podman.io/storage/pkg/stringutils"
 	"go.podman.io/storage/pkg/system"
 	"go.podman.io/storage/types"
@@ -188,6 +190,25 @@ type DedupArgs struct {
 	Options DedupOptions
 }
 
+// DiskQuota contains the limits on the disk space used by a container's
+// read-write layer.  A value of zero means no limit.
+type DiskQuota struct {
+	// Size is the maximum number of bytes the layer can use.
+	Size uint64
+	// Inodes is the maximum number of inodes the layer can use.
+	Inodes uint64
+}
+
+// DiskQuotaOptions is used for passing options to a Store's SetContainerQuota()
+// method.  Limits which are nil are left unchanged, and limits which are zero
+// are removed.
+type DiskQuotaOptions struct {
+	// Size is the maximum number of bytes the layer can use.
+	Size *uint64
+	// Inodes is the maximum number of inodes the layer can use.
+	Inodes *uint64
+}
+
 // Store wraps up the various types of file-based stores that we use into a
 // singleton object that initializes and manages them all together.
 type Store interface {
@@ -226,6 +247,15 @@ type Store interface {
 	//   }
 	PutLayer(id, parent string, names []string, mountLabel string, writeable bool, options *LayerOptions, diff io.Reader) (*Layer, int64, error)
 
+	// CommitContainerLayer creates a new read-only layer, optionally
+	// having the specified ID, with the contents of the container's
+	// read-write layer and the same parent.  If the graph driver can copy
+	// the layer directly, e.g. using reflinks or snapshots, no archive of
+	// its changes is applied; otherwise, the changes are read using Diff()
+	// and applied using PutLayer().  The container should not be modified
+	// while this is in progress.
+	CommitContainerLayer(containerID string, options *CommitLayerOptions) (*Layer, error)
+
 	// CreateImage creates a new image, optionally with the specified ID
 	// (one will be assigned if none is specified), with optional names,
 	// referring to a specified image, and with optional metadata.  An
@@ -243,6 +273,11 @@ type Store interface {
 	// which the library stores for the convenience of its caller.
 	CreateContainer(id string, names []string, image, layer, metadata string, options *ContainerOptions) (*Container, error)
 
+	// BeginTransaction starts a Transaction, which groups operations creating layers, images and containers
+	// so that either all of the created objects persist, or none of them do, even if the process is interrupted.
+	// operation is a human-readable description of the transaction, used in log messages.
+	BeginTransaction(operation string) (*Transaction, error)
+
 	// Metadata retrieves the metadata which is associated with a layer,
 	// image, or container (whichever the passed-in ID refers to).
 	Metadata(id string) (string, error)
@@ -503,6 +538,19 @@ type Store interface {
 	// data.  Warning:  this is a potentially expensive operation.
 	ContainerSize(id string) (int64, error)
 
+	// SetContainerQuota sets, changes or removes the limits on the disk space
+	// used by the container's read-write layer, as described by options;
+	// limits which options does not mention are left unchanged.  It returns
+	// an error wrapping ErrNotSupported if the graph driver can't enforce them,
+	// and an error wrapping drivers.ErrLayerInUse if the change can only be
+	// made while the container's layer is not mounted.
+	SetContainerQuota(id string, options *DiskQuotaOptions) error
+
+	// ContainerQuota returns the limits on the disk space used by the
+	// container's read-write layer, and the space it currently uses, which,
+	// unlike ContainerSize, is usually obtained without walking the layer.
+	ContainerQuota(id string) (DiskQuota, *directory.DiskUsage, error)
+
 	// Layer returns a specific layer.
 	Layer(id string) (*Layer, error)
 
@@ -595,6 +643,44 @@ type Store interface {
 	// shutdowns or regular restarts in transient store mode.
 	GarbageCollect() error
 
+	// PruneImages removes images which no container uses and which are not
+	// pinned using ImagePinnedFlag or listed in options.Keep, least recently
+	// used first, as long as images use more disk space than options.MaxSize,
+	// as recorded when their layers were created, or have not been used for
+	// options.MaxAge.  If options is nil, or sets neither MaxSize nor MaxAge,
+	// the values configured using StoreOptions.ImageEvictionMaxSize and
+	// ImageEvictionMaxAge are used.  No other Store method removes images this
+	// way; the containers-storage transport of go.podman.io/image calls this
+	// after each pull, keeping the pulled image.
+	// It returns the IDs of the removed images.
+	PruneImages(options *PruneImagesOptions) ([]string, error)
+
+	// SetImagePinned sets or clears ImagePinnedFlag on an image, preventing
+	// or allowing its removal by PruneImages.
+	SetImagePinned(id string, pinned bool) error
+
+	// Export writes the images selected by filter, their layers, and optionally
+	// containers based on them, to w as a tar archive which can be read by Import.
+	// Layers are written as uncompressed diffs which, if the layers' tar-split
+	// data is available, are identical to the diffs originally applied to them.
+	// If filter is nil, all images, and no containers, are exported.
+	Export(w io.Writer, filter *ExportFilter) error
+
+	// Import recreates the layers, images and containers in an archive written
+	// by Export, with the same IDs, names and digests, on any graph driver.
+	// Layers and images which already exist are not modified.  If Import fails,
+	// the objects which were already imported are not removed.
+	Import(r io.Reader) error
+
+	// MigrateGraphDriver recreates all layers, images and containers of the
+	// store, with the same IDs, names and digests, using another graph
+	// driver in the same locations, checks the result, and returns a Store
+	// using the new driver.  The new driver must be configured in
+	// storage.conf for it to be used afterwards.  If MigrateGraphDriver is
+	// interrupted or fails, calling it again resumes the migration.  The
+	// returned Store does not use the image eviction limits of the store.
+	MigrateGraphDriver(driver string, options *MigrateOptions) (Store, error)
+
 	// Check returns a report of things that look wrong in the store.
 	Check(options *CheckOptions) (CheckReport, error)
 	// Repair attempts to remediate problems mentioned in the CheckReport,
@@ -610,6 +696,12 @@ type Store interface {
 
 	// Dedup deduplicates layers in the store.
 	Dedup(DedupArgs) (drivers.DedupResult, error)
+
+	// Watch returns a channel reporting changes to layers, images and containers (creation, deletion,
+	// changes of names, and setting of big data items) made after Watch was called, by this or other
+	// processes using the same store.  The channel is closed when ctx is canceled.
+	// Changes are detected by polling a small on-disk event journal, so they are reported with a delay.
+	Watch(ctx context.Context) (<-chan Event, error)
 }
 
 // AdditionalLayer represents a layer that is contained in the additional layer store
@@ -698,6 +790,16 @@ type LayerOptions struct {
 	Flags map[string]any
 }
 
+// CommitLayerOptions is used for passing options to a Store's CommitContainerLayer() method.
+type CommitLayerOptions struct {
+	// ID is the ID of the new layer.  If empty, a random ID is assigned.
+	ID string
+	// Names are optional names for the new layer.
+	Names []string
+	// Flags is a set of named flags and their values to store with the layer.
+	Flags map[string]any
+}
+
 type LayerBigDataOption struct {
 	Key  string
 	Data io.Reader
@@ -809,6 +911,11 @@ type store struct {
 	disableVolatile bool
 	transientStore  bool
 
+	eventJournal    *eventJournal // nil if events can not be recorded
+	intents         *intentJournal
+	metadataBackend metadataBackend
+	imageEviction   PruneImagesOptions
+
 	// The following fields can only be accessed with graphLock held.
 	graphLockLastWrite lockfile.LastWrite
 	// FIXME: This field is only set when holding graphLock, but locking rules of the driver
@@ -914,6 +1021,10 @@ func GetStore(options types.StoreOptions) (Store, error) {
 	if autoNsMaxSize == 0 {
 		autoNsMaxSize = AutoUserNsMaxSize
 	}
+	backend, err := newMetadataBackend(options.MetadataBackend)
+	if err != nil {
+		return nil, err
+	}
 	s := &store{
 		runRoot:             options.RunRoot,
 		graphDriverName:     options.GraphDriverName,
@@ -931,6 +1042,11 @@ func GetStore(options types.StoreOptions) (Store, error) {
 		autoNsMaxSize:       autoNsMaxSize,
 		disableVolatile:     options.DisableVolatile,
 		transientStore:      options.TransientStore,
+		metadataBackend:     backend,
+		imageEviction: PruneImagesOptions{
+			MaxSize: options.ImageEvictionMaxSize,
+			MaxAge:  options.ImageEvictionMaxAge,
+		},
 
 		additionalUIDs: nil,
 		additionalGIDs: nil,
@@ -1023,7 +1139,7 @@ func (s *store) load() error {
 	if err := os.MkdirAll(gipath, 0o700); err != nil {
 		return err
 	}
-	imageStore, err := newImageStore(gipath)
+	imageStore, err := newImageStore(gipath, s.metadataBackend)
 	if err != nil {
 		return err
 	}
@@ -1040,7 +1156,7 @@ func (s *store) load() error {
 		return err
 	}
 
-	rcs, err := newContainerStore(gcpath, rcpath, s.transientStore)
+	rcs, err := newContainerStore(gcpath, rcpath, s.transientStore, s.metadataBackend)
 	if err != nil {
 		return err
 	}
@@ -1057,14 +1173,14 @@ func (s *store) load() error {
 		var ris roImageStore
 		// both the graphdriver and the imagestore must be used read-write.
 		if store == s.imageStoreDir || store == s.graphRoot {
-			imageStore, err := newImageStore(gipath)
+			imageStore, err := newImageStore(gipath, s.metadataBackend)
 			if err != nil {
 				return err
 			}
 			s.rwImageStores = append(s.rwImageStores, imageStore)
 			ris = imageStore
 		} else {
-			ris, err = newROImageStore(gipath)
+			ris, err = newROImageStore(gipath, s.metadataBackend)
 			if err != nil {
 				if errors.Is(err, syscall.EROFS) {
 					logrus.Debugf("Ignoring creation of lockfiles on read-only file systems %q, %v", gipath, err)
@@ -1081,7 +1197,21 @@ func (s *store) load() error {
 		return err
 	}
 
-	return nil
+	eventJournal, err := newEventJournal(filepath.Join(s.graphRoot, driverPrefix+"events"))
+	if err != nil {
+		if !errors.Is(err, syscall.EROFS) {
+			return err
+		}
+		logrus.Debugf("Not recording events on a read-only file system: %v", err)
+	} else {
+		s.eventJournal = eventJournal
+	}
+
+	s.intents, err = newIntentJournal(filepath.Join(s.graphRoot, driverPrefix+"layers"))
+	if err != nil {
+		return err
+	}
+	return s.recoverIntents()
 }
 
 // GetDigestLock returns a digest-specific Locker.
@@ -1214,7 +1344,7 @@ func (s *store) getROLayerStoresLocked() ([]roLayerStore, error) {
 	for _, store := range s.graphDriver.AdditionalImageStores() {
 		glpath := filepath.Join(store, driverPrefix+"layers")
 
-		rls, err := newROLayerStore(rlpath, glpath, s.graphDriver)
+		rls, err := newROLayerStore(rlpath, glpath, s.graphDriver, s.metadataBackend)
 		if err != nil {
 			return nil, err
 		}
@@ -1697,7 +1827,11 @@ func (s *store) PutLayer(id, parent string, names []string, mountLabel string, w
 			return nil, -1, fmt.Errorf("error during staged layer apply, parent layer %q changed id mappings while the content was extracted, must retry layer creation", parent)
 		}
 	}
-	return rlstore.create(id, parentLayer, names, mountLabel, nil, options, writeable, contents)
+	layer, size, err := rlstore.create(id, parentLayer, names, mountLabel, nil, options, writeable, contents)
+	if err == nil {
+		s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: layer.ID, Names: layer.Names})
+	}
+	return layer, size, err
 }
 
 func (s *store) CreateLayer(id, parent string, names []string, mountLabel string, writeable bool, options *LayerOptions) (*Layer, error) {
@@ -1705,6 +1839,72 @@ func (s *store) CreateLayer(id, parent string, names []string, mountLabel string
 	return layer, err
 }
 
+func (s *store) CommitContainerLayer(containerID string, options *CommitLayerOptions) (*Layer, error) {
+	if options == nil {
+		options = &CommitLayerOptions{}
+	}
+	container, err := s.Container(containerID)
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.Layer(container.LayerID)
+	if err != nil {
+		return nil, err
+	}
+	layerOptions := LayerOptions{
+		IDMappingOptions: LayerIDMappingOptions{
+			HostUIDMapping: len(source.UIDMap) == 0,
+			HostGIDMapping: len(source.GIDMap) == 0,
+			UIDMap:         source.UIDMap,
+			GIDMap:         source.GIDMap,
+		},
+		Flags: options.Flags,
+	}
+
+	rlstore, rlstores, err := s.bothLayerStoreKinds()
+	if err != nil {
+		return nil, err
+	}
+	layer, err := func() (*Layer, error) {
+		if err := rlstore.startWriting(); err != nil {
+			return nil, err
+		}
+		defer rlstore.stopWriting()
+		source, err := rlstore.Get(container.LayerID)
+		if err != nil {
+			return nil, err
+		}
+		var parentLayer *Layer
+		if source.Parent != "" {
+			var unlockLayerStores func()
+			parentLayer, unlockLayerStores, err = getParentLayer(rlstore, rlstores, source.Parent)
+			defer unlockLayerStores()
+			if err != nil {
+				return nil, err
+			}
+		}
+		layer, _, err := rlstore.create(options.ID, parentLayer, options.Names, "", nil, &layerOptions, false, &layerCreationContents{cloneSource: source})
+		return layer, err
+	}()
+	if err == nil {
+		s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: layer.ID, Names: layer.Names})
+		return layer, nil
+	}
+	if !errors.Is(err, ErrNotSupported) && !errors.Is(err, drivers.ErrNotSupported) {
+		return nil, err
+	}
+	logrus.Debugf("Committing layer of container %q using a diff: %v", container.ID, err)
+
+	uncompressed := archive.Uncompressed
+	diff, err := s.Diff("", source.ID, &DiffOptions{Compression: &uncompressed})
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Close()
+	layer, _, err = s.PutLayer(options.ID, source.Parent, options.Names, "", false, &layerOptions, diff)
+	return layer, err
+}
+
 func (s *store) CreateImage(id string, names []string, layer, metadata string, iOptions *ImageOptions) (*Image, error) {
 	if layer != "" {
 		layerStores, err := s.allLayerStores()
@@ -1807,6 +2007,13 @@ func (s *store) CreateImage(id string, names []string, layer, metadata string, i
 			// triggering a duplicate names error
 			err = s.imageStore.updateNames(res.ID, namesToAddAfterCreating, addNames)
 		}
+		if err == nil {
+			eventNames := res.Names
+			if image, err := s.imageStore.Get(res.ID); err == nil {
+				eventNames = image.Names
+			}
+			s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectImage, ID: res.ID, Names: eventNames})
+		}
 		return res, err
 	})
 }
@@ -1905,17 +2112,26 @@ func (s *store) imageTopLayerForMapping(image *Image, ristore roImageStore, rlst
 		}
 	}
 	layerOptions.TemplateLayer = layer.ID
-	mappedLayer, _, err := rlstore.create("", parentLayer, nil, layer.MountLabel, nil, &layerOptions, false, nil)
-	if err != nil {
-		return nil, fmt.Errorf("creating an ID-mapped copy of layer %q: %w", layer.ID, err)
-	}
-	// By construction, createMappedLayer can only be true if ristore == s.imageStore.
-	if err = s.imageStore.addMappedTopLayer(image.ID, mappedLayer.ID); err != nil {
-		if err2 := rlstore.deleteWhileHoldingLock(mappedLayer.ID); err2 != nil {
-			err = fmt.Errorf("deleting layer %q: %v: %w", mappedLayer.ID, err2, err)
+	mappedLayerID := stringid.GenerateRandomID()
+	var mappedLayer *Layer
+	if err := s.withIntent(storeIntent{Operation: "create an ID-mapped copy of layer " + layer.ID, Layers: []string{mappedLayerID}}, func() error {
+		var err error
+		mappedLayer, _, err = rlstore.create(mappedLayerID, parentLayer, nil, layer.MountLabel, nil, &layerOptions, false, nil)
+		if err != nil {
+			return fmt.Errorf("creating an ID-mapped copy of layer %q: %w", layer.ID, err)
+		}
+		// By construction, createMappedLayer can only be true if ristore == s.imageStore.
+		if err = s.imageStore.addMappedTopLayer(image.ID, mappedLayer.ID); err != nil {
+			if err2 := rlstore.deleteWhileHoldingLock(mappedLayer.ID); err2 != nil {
+				err = fmt.Errorf("deleting layer %q: %v: %w", mappedLayer.ID, err2, err)
+			}
+			return fmt.Errorf("registering ID-mapped layer with image %q: %w", image.ID, err)
 		}
-		return nil, fmt.Errorf("registering ID-mapped layer with image %q: %w", image.ID, err)
+		return nil
+	}); err != nil {
+		return nil, err
 	}
+	s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: mappedLayer.ID})
 	return mappedLayer, nil
 }
 
@@ -2068,36 +2284,61 @@ func (s *store) CreateContainer(id string, names []string, image, layer, metadat
 		options.Flags[mountLabelFlag] = mountLabel
 	}
 
-	clayer, _, err := rlstore.create(layer, imageTopLayer, nil, mlabel, options.StorageOpt, layerOptions, true, nil)
-	if err != nil {
-		return nil, err
+	// The layer ID must be known before creating the layer, so that the intent can refer to it.
+	// The intent causes the layer, and any container using it, to be removed after a crash, so make sure
+	// that the layer does not exist yet.
+	if layer == "" {
+		layer = stringid.GenerateRandomID()
+	} else if l, err := rlstore.Get(layer); err == nil && l.ID == layer {
+		return nil, ErrDuplicateID
 	}
-	layer = clayer.ID
 
 	// Normally only `--rm` containers are volatile, but in transient store mode all containers are volatile
 	if s.transientStore {
 		options.Volatile = true
 	}
 
-	return writeToContainerStore(s, func() (*Container, error) {
-		options.IDMappingOptions = types.IDMappingOptions{
-			HostUIDMapping: len(options.UIDMap) == 0,
-			HostGIDMapping: len(options.GIDMap) == 0,
-			UIDMap:         copySlicePreferringNil(options.UIDMap),
-			GIDMap:         copySlicePreferringNil(options.GIDMap),
+	var container *Container
+	if err := s.withIntent(storeIntent{Operation: "create a container", Layers: []string{layer}}, func() error {
+		clayer, _, err := rlstore.create(layer, imageTopLayer, nil, mlabel, options.StorageOpt, layerOptions, true, nil)
+		if err != nil {
+			return err
 		}
-		container, err := s.containerStore.create(id, names, imageID, layer, &options)
-		if err != nil || container == nil {
-			if err2 := rlstore.deleteWhileHoldingLock(layer); err2 != nil {
-				if err == nil {
-					err = fmt.Errorf("deleting layer %#v: %w", layer, err2)
-				} else {
-					logrus.Errorf("While recovering from a failure to create a container, error deleting layer %#v: %v", layer, err2)
+		layer = clayer.ID
+
+		container, err = writeToContainerStore(s, func() (*Container, error) {
+			options.IDMappingOptions = types.IDMappingOptions{
+				HostUIDMapping: len(options.UIDMap) == 0,
+				HostGIDMapping: len(options.GIDMap) == 0,
+				UIDMap:         copySlicePreferringNil(options.UIDMap),
+				GIDMap:         copySlicePreferringNil(options.GIDMap),
+			}
+			container, err := s.containerStore.create(id, names, imageID, layer, &options)
+			if err != nil || container == nil {
+				if err2 := rlstore.deleteWhileHoldingLock(layer); err2 != nil {
+					if err == nil {
+						err = fmt.Errorf("deleting layer %#v: %w", layer, err2)
+					} else {
+						logrus.Errorf("While recovering from a failure to create a container, error deleting layer %#v: %v", layer, err2)
+					}
 				}
 			}
+			return container, err
+		})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if container != nil {
+		s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: layer},
+			Event{Type: EventCreated, ObjectType: EventObjectContainer, ID: container.ID, Names: container.Names})
+	}
+	if imageHomeStore != nil && imageHomeStore == roImageStore(s.imageStore) {
+		if err := s.imageStore.recordUse(imageID); err != nil {
+			logrus.Warnf("Recording use of image %q: %v", imageID, err)
 		}
-		return container, err
-	})
+	}
+	return container, nil
 }
 
 func (s *store) SetMetadata(id, metadata string) error {
@@ -2259,14 +2500,26 @@ func (s *store) LayerBigData(id, key string) (io.ReadCloser, error) {
 // associated with a layer.
 func (s *store) SetLayerBigData(id, key string, data io.Reader) error {
 	_, err := writeToLayerStore(s, func(store rwLayerStore) (struct{}, error) {
-		return struct{}{}, store.SetBigData(id, key, data)
+		if err := store.SetBigData(id, key, data); err != nil {
+			return struct{}{}, err
+		}
+		if layer, err := store.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventBigDataChanged, ObjectType: EventObjectLayer, ID: layer.ID, Key: key})
+		}
+		return struct{}{}, nil
 	})
 	return err
 }
 
 func (s *store) SetImageBigData(id, key string, data []byte, digestManifest func([]byte) (digest.Digest, error)) error {
 	_, err := writeToImageStore(s, func() (struct{}, error) {
-		return struct{}{}, s.imageStore.SetBigData(id, key, data, digestManifest)
+		if err := s.imageStore.SetBigData(id, key, data, digestManifest); err != nil {
+			return struct{}{}, err
+		}
+		if image, err := s.imageStore.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventBigDataChanged, ObjectType: EventObjectImage, ID: image.ID, Key: key})
+		}
+		return struct{}{}, nil
 	})
 	return err
 }
@@ -2441,6 +2694,53 @@ func (s *store) ContainerSize(id string) (int64, error) {
 	})
 }
 
+func (s *store) SetContainerQuota(id string, options *DiskQuotaOptions) error {
+	if options == nil || (options.Size == nil && options.Inodes == nil) {
+		return nil
+	}
+	container, err := s.Container(id)
+	if err != nil {
+		return err
+	}
+	_, err = writeToLayerStore(s, func(rlstore rwLayerStore) (struct{}, error) {
+		// Drivers replace all of the limits, so start from the current ones if only some of them change.
+		var limits drivers.LayerQuota
+		if options.Size == nil || options.Inodes == nil {
+			current, _, err := rlstore.quota(container.LayerID)
+			if err != nil {
+				return struct{}{}, err
+			}
+			limits = current
+		}
+		if options.Size != nil {
+			limits.Size = *options.Size
+		}
+		if options.Inodes != nil {
+			limits.Inodes = *options.Inodes
+		}
+		return struct{}{}, rlstore.setQuota(container.LayerID, limits)
+	})
+	return err
+}
+
+func (s *store) ContainerQuota(id string) (DiskQuota, *directory.DiskUsage, error) {
+	container, err := s.Container(id)
+	if err != nil {
+		return DiskQuota{}, nil, err
+	}
+	var usage *directory.DiskUsage
+	limits, err := readPrimaryLayerStore(s, func(rlstore rwLayerStore) (drivers.LayerQuota, error) {
+		var limits drivers.LayerQuota
+		var err error
+		limits, usage, err = rlstore.quota(container.LayerID)
+		return limits, err
+	})
+	if err != nil {
+		return DiskQuota{}, nil, err
+	}
+	return DiskQuota{Size: limits.Size, Inodes: limits.Inodes}, usage, nil
+}
+
 func (s *store) ListContainerBigData(id string) ([]string, error) {
 	res, _, err := readContainerStore(s, func() ([]string, bool, error) {
 		res, err := s.containerStore.BigDataNames(id)
@@ -2471,7 +2771,13 @@ func (s *store) ContainerBigData(id, key string) ([]byte, error) {
 
 func (s *store) SetContainerBigData(id, key string, data []byte) error {
 	_, err := writeToContainerStore(s, func() (struct{}, error) {
-		return struct{}{}, s.containerStore.SetBigData(id, key, data)
+		if err := s.containerStore.SetBigData(id, key, data); err != nil {
+			return struct{}{}, err
+		}
+		if container, err := s.containerStore.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventBigDataChanged, ObjectType: EventObjectContainer, ID: container.ID, Key: key})
+		}
+		return struct{}{}, nil
 	})
 	return err
 }
@@ -2556,7 +2862,13 @@ func (s *store) updateNames(id string, names []string, op updateNameOperation) e
 		if !rlstore.Exists(id) {
 			return false, nil
 		}
-		return true, rlstore.updateNames(id, deduped, op)
+		if err := rlstore.updateNames(id, deduped, op); err != nil {
+			return true, err
+		}
+		if layer, err := rlstore.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventRenamed, ObjectType: EventObjectLayer, ID: layer.ID, Names: layer.Names})
+		}
+		return true, nil
 	}); err != nil || found {
 		return err
 	}
@@ -2565,8 +2877,17 @@ func (s *store) updateNames(id string, names []string, op updateNameOperation) e
 		return err
 	}
 	defer s.imageStore.stopWriting()
+	recordImageRenamed := func() {
+		if image, err := s.imageStore.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventRenamed, ObjectType: EventObjectImage, ID: image.ID, Names: image.Names})
+		}
+	}
 	if s.imageStore.Exists(id) {
-		return s.imageStore.updateNames(id, deduped, op)
+		if err := s.imageStore.updateNames(id, deduped, op); err != nil {
+			return err
+		}
+		recordImageRenamed()
+		return nil
 	}
 
 	// Check if the id refers to a read-only image store -- we want to allow images in
@@ -2607,7 +2928,11 @@ func (s *store) updateNames(id string, names []string, op updateNameOperation) e
 				return err
 			}
 			// now make the changes to the writeable image record's names list
-			return s.imageStore.updateNames(id, deduped, op)
+			if err := s.imageStore.updateNames(id, deduped, op); err != nil {
+				return err
+			}
+			recordImageRenamed()
+			return nil
 		}
 	}
 
@@ -2615,7 +2940,13 @@ func (s *store) updateNames(id string, names []string, op updateNameOperation) e
 		if !s.containerStore.Exists(id) {
 			return false, nil
 		}
-		return true, s.containerStore.updateNames(id, deduped, op)
+		if err := s.containerStore.updateNames(id, deduped, op); err != nil {
+			return true, err
+		}
+		if container, err := s.containerStore.Get(id); err == nil {
+			s.recordEvents(Event{Type: EventRenamed, ObjectType: EventObjectContainer, ID: container.ID, Names: container.Names})
+		}
+		return true, nil
 	}); err != nil || found {
 		return err
 	}
@@ -2725,19 +3056,25 @@ func (s *store) DeleteLayer(id string) (retErr error) {
 					return fmt.Errorf("layer %v used by container %v: %w", id, container.ID, ErrLayerUsedByContainer)
 				}
 			}
-			cf, err := rlstore.deferredDelete(id)
-			cleanupFunctions = append(cleanupFunctions, cf...)
-			if err != nil {
-				return fmt.Errorf("delete layer %v: %w", id, err)
-			}
+			if err := s.withIntent(storeIntent{Operation: "delete layer " + id, Layers: []string{id}}, func() error {
+				cf, err := rlstore.deferredDelete(id)
+				cleanupFunctions = append(cleanupFunctions, cf...)
+				if err != nil {
+					return fmt.Errorf("delete layer %v: %w", id, err)
+				}
 
-			for _, image := range images {
-				if stringutils.InSlice(image.MappedTopLayers, id) {
-					if err = s.imageStore.removeMappedTopLayer(image.ID, id); err != nil {
-						return fmt.Errorf("remove mapped top layer %v from image %v: %w", id, image.ID, err)
+				for _, image := range images {
+					if stringutils.InSlice(image.MappedTopLayers, id) {
+						if err = s.imageStore.removeMappedTopLayer(image.ID, id); err != nil {
+							return fmt.Errorf("remove mapped top layer %v from image %v: %w", id, image.ID, err)
+						}
 					}
 				}
+				return nil
+			}); err != nil {
+				return err
 			}
+			s.recordEvents(Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: id})
 			return nil
 		}
 		return ErrNotALayer
@@ -2745,6 +3082,12 @@ func (s *store) DeleteLayer(id string) (retErr error) {
 }
 
 func (s *store) DeleteImage(id string, commit bool) (layers []string, retErr error) {
+	return s.deleteImage(id, commit, nil)
+}
+
+// deleteImage is DeleteImage; if check is not nil, it is called, with the store locked, for each record
+// of the image, and the image is not deleted if it fails.
+func (s *store) deleteImage(id string, commit bool, check func(image *Image) error) (layers []string, retErr error) {
 	layersToRemove := []string{}
 	cleanupFunctions := []tempdir.CleanupTempDirFunc{}
 	defer func() {
@@ -2755,6 +3098,7 @@ func (s *store) DeleteImage(id string, commit bool) (layers []string, retErr err
 	if err := s.writeToAllStores(func(rlstore rwLayerStore) error {
 		// Delete image from all available imagestores configured to be used.
 		imageFound := false
+		imageHomeStores := []rwImageStore{}
 		for _, is := range s.rwImageStores {
 			if is != s.imageStore {
 				// This is an additional writeable image store
@@ -2773,6 +3117,11 @@ func (s *store) DeleteImage(id string, commit bool) (layers []string, retErr err
 				return err
 			}
 			id = image.ID
+			if check != nil {
+				if err := check(image); err != nil {
+					return err
+				}
+			}
 			containers, err := s.containerStore.Containers()
 			if err != nil {
 				return err
@@ -2805,11 +3154,7 @@ func (s *store) DeleteImage(id string, commit bool) (layers []string, retErr err
 					}
 				}
 			}
-			if commit {
-				if err = is.Delete(id); err != nil {
-					return err
-				}
-			}
+			imageHomeStores = append(imageHomeStores, is)
 			layer := image.TopLayer
 			layersToRemoveMap := make(map[string]struct{})
 			layersToRemove = append(layersToRemove, image.MappedTopLayers...)
@@ -2856,18 +3201,33 @@ func (s *store) DeleteImage(id string, commit bool) (layers []string, retErr err
 			return ErrNotAnImage
 		}
 		if commit {
-			for _, layer := range layersToRemove {
-				cf, err := rlstore.deferredDelete(layer)
-				cleanupFunctions = append(cleanupFunctions, cf...)
-				if err != nil {
-					return err
+			return s.withIntent(storeIntent{Operation: "delete image " + id, Images: []string{id}, Layers: layersToRemove}, func() error {
+				for _, is := range imageHomeStores {
+					if err := is.Delete(id); err != nil {
+						return err
+					}
 				}
-			}
+				for _, layer := range layersToRemove {
+					cf, err := rlstore.deferredDelete(layer)
+					cleanupFunctions = append(cleanupFunctions, cf...)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
 		}
 		return nil
 	}); err != nil {
 		return nil, err
 	}
+	if commit {
+		events := []Event{{Type: EventDeleted, ObjectType: EventObjectImage, ID: id}}
+		for _, layer := range layersToRemove {
+			events = append(events, Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: layer})
+		}
+		s.recordEvents(events...)
+	}
 	return layersToRemove, nil
 }
 
@@ -2888,36 +3248,43 @@ func (s *store) DeleteContainer(id string) (retErr error) {
 			return ErrNotAContainer
 		}
 
-		// delete the layer first, separately, so that if we get an
-		// error while trying to do so, we don't go ahead and delete
-		// the container record that refers to it, effectively losing
-		// track of it
-		if rlstore.Exists(container.LayerID) {
-			cf, err := rlstore.deferredDelete(container.LayerID)
-			cleanupFunctions = append(cleanupFunctions, cf...)
-			if err != nil {
-				return err
+		if err := s.withIntent(storeIntent{Operation: "delete container " + container.ID, Containers: []string{container.ID}, Layers: []string{container.LayerID}}, func() error {
+			// delete the layer first, separately, so that if we get an
+			// error while trying to do so, we don't go ahead and delete
+			// the container record that refers to it, effectively losing
+			// track of it
+			if rlstore.Exists(container.LayerID) {
+				cf, err := rlstore.deferredDelete(container.LayerID)
+				cleanupFunctions = append(cleanupFunctions, cf...)
+				if err != nil {
+					return err
+				}
 			}
-		}
 
-		var wg errgroup.Group
+			var wg errgroup.Group
 
-		middleDir := s.graphDriverName + "-containers"
+			middleDir := s.graphDriverName + "-containers"
 
-		wg.Go(func() error {
-			gcpath := filepath.Join(s.GraphRoot(), middleDir, container.ID)
-			return system.EnsureRemoveAll(gcpath)
-		})
+			wg.Go(func() error {
+				gcpath := filepath.Join(s.GraphRoot(), middleDir, container.ID)
+				return system.EnsureRemoveAll(gcpath)
+			})
 
-		wg.Go(func() error {
-			rcpath := filepath.Join(s.RunRoot(), middleDir, container.ID)
-			return system.EnsureRemoveAll(rcpath)
-		})
+			wg.Go(func() error {
+				rcpath := filepath.Join(s.RunRoot(), middleDir, container.ID)
+				return system.EnsureRemoveAll(rcpath)
+			})
 
-		if multierr := wg.Wait(); multierr != nil {
-			return multierr
+			if multierr := wg.Wait(); multierr != nil {
+				return multierr
+			}
+			return s.containerStore.Delete(id)
+		}); err != nil {
+			return err
 		}
-		return s.containerStore.Delete(id)
+		s.recordEvents(Event{Type: EventDeleted, ObjectType: EventObjectContainer, ID: container.ID},
+			Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: container.LayerID})
+		return nil
 	})
 }
 
@@ -2932,35 +3299,55 @@ func (s *store) Delete(id string) (retErr error) {
 		if s.containerStore.Exists(id) {
 			if container, err := s.containerStore.Get(id); err == nil {
 				if rlstore.Exists(container.LayerID) {
-					cf, err := rlstore.deferredDelete(container.LayerID)
-					cleanupFunctions = append(cleanupFunctions, cf...)
-					if err != nil {
-						return err
-					}
-					if err = s.containerStore.Delete(id); err != nil {
-						return err
-					}
-					middleDir := s.graphDriverName + "-containers"
-					gcpath := filepath.Join(s.GraphRoot(), middleDir, container.ID, "userdata")
-					if err = os.RemoveAll(gcpath); err != nil {
-						return err
-					}
-					rcpath := filepath.Join(s.RunRoot(), middleDir, container.ID, "userdata")
-					if err = os.RemoveAll(rcpath); err != nil {
+					if err := s.withIntent(storeIntent{Operation: "delete container " + container.ID, Containers: []string{container.ID}, Layers: []string{container.LayerID}}, func() error {
+						cf, err := rlstore.deferredDelete(container.LayerID)
+						cleanupFunctions = append(cleanupFunctions, cf...)
+						if err != nil {
+							return err
+						}
+						if err = s.containerStore.Delete(id); err != nil {
+							return err
+						}
+						middleDir := s.graphDriverName + "-containers"
+						gcpath := filepath.Join(s.GraphRoot(), middleDir, container.ID, "userdata")
+						if err = os.RemoveAll(gcpath); err != nil {
+							return err
+						}
+						rcpath := filepath.Join(s.RunRoot(), middleDir, container.ID, "userdata")
+						return os.RemoveAll(rcpath)
+					}); err != nil {
 						return err
 					}
+					s.recordEvents(Event{Type: EventDeleted, ObjectType: EventObjectContainer, ID: container.ID},
+						Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: container.LayerID})
 					return nil
 				}
 				return ErrNotALayer
 			}
 		}
 		if s.imageStore.Exists(id) {
-			return s.imageStore.Delete(id)
+			image, err := s.imageStore.Get(id)
+			if err != nil {
+				return err
+			}
+			if err := s.imageStore.Delete(id); err != nil {
+				return err
+			}
+			s.recordEvents(Event{Type: EventDeleted, ObjectType: EventObjectImage, ID: image.ID})
+			return nil
 		}
 		if rlstore.Exists(id) {
+			layer, err := rlstore.Get(id)
+			if err != nil {
+				return err
+			}
 			cf, err := rlstore.deferredDelete(id)
 			cleanupFunctions = append(cleanupFunctions, cf...)
-			return err
+			if err != nil {
+				return err
+			}
+			s.recordEvents(Event{Type: EventDeleted, ObjectType: EventObjectLayer, ID: layer.ID})
+			return nil
 		}
 		return ErrLayerUnknown
 	})
@@ -3061,6 +3448,11 @@ func (s *store) MountImage(id string, mountOpts []string, mountLabel string) (st
 	if len(ilayer.UIDMap) > 0 || len(ilayer.GIDMap) > 0 {
 		return "", fmt.Errorf("cannot create an image with canonical UID/GID mappings in a read-only store")
 	}
+	if imageHomeStore == roImageStore(s.imageStore) {
+		if err := s.imageStore.recordUse(cimage.ID); err != nil {
+			logrus.Warnf("Recording use of image %q: %v", cimage.ID, err)
+		}
+	}
 
 	options := drivers.MountOpts{
 		MountLabel: mountLabel,
@@ -3325,6 +3717,9 @@ func (s *store) ApplyStagedLayer(args ApplyStagedLayerOptions) (*Layer, error) {
 		return nil, err
 	}
 	layer, _, err = rlstore.create(args.ID, parentLayer, args.Names, args.MountLabel, nil, options, args.Writeable, &contents)
+	if err == nil {
+		s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: layer.ID, Names: layer.Names})
+	}
 	return layer, err
 }
 
@@ -3605,7 +4000,11 @@ func (al *additionalLayer) PutAs(id, parent string, names []string) (*Layer, err
 		}
 	}
 
-	return rlstore.PutAdditionalLayer(id, parentLayer, names, al.handler)
+	layer, err := rlstore.PutAdditionalLayer(id, parentLayer, names, al.handler)
+	if err == nil {
+		al.s.recordEvents(Event{Type: EventCreated, ObjectType: EventObjectLayer, ID: layer.ID, Names: layer.Names})
+	}
+	return layer, err
 }
 
 func (al *additionalLayer) Release() {
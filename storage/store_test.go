@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -8,8 +11,11 @@ import (
 	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	graphdriver "go.podman.io/storage/drivers"
+	"go.podman.io/storage/pkg/archive"
 	"go.podman.io/storage/pkg/idtools"
 	"go.podman.io/storage/pkg/reexec"
+	"go.podman.io/storage/pkg/system"
 )
 
 func newTestStore(t *testing.T, testOptions StoreOptions) Store {
@@ -629,3 +635,235 @@ func TestStoreDelete(t *testing.T) {
 
 	store.Free()
 }
+
+func TestCommitContainerLayer(t *testing.T) {
+	reexec.Init()
+
+	for _, driver := range []string{"vfs", "overlay"} {
+		t.Run(driver, func(t *testing.T) {
+			store := newTestStore(t, StoreOptions{GraphDriverName: driver})
+			shutdownTestStore(t, store)
+
+			_, _, err := store.PutLayer("Base", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "base\n", "etc/motd", "hello\n")))
+			require.NoError(t, err)
+			_, err = store.CreateImage("Image", nil, "Base", "", nil)
+			require.NoError(t, err)
+			container, err := store.CreateContainer("Container", nil, "Image", "", "", nil)
+			require.NoError(t, err)
+			mountPoint, err := store.Mount(container.ID, "")
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(mountPoint, "etc", "hostname"), []byte("container\n"), 0o644))
+			require.NoError(t, os.Remove(filepath.Join(mountPoint, "etc", "motd")))
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+
+			layer, err := store.CommitContainerLayer("Container", &CommitLayerOptions{ID: "Committed", Names: []string{"committed"}})
+			require.NoError(t, err)
+			assert.Equal(t, "Committed", layer.ID)
+			containerLayer, err := store.Layer(container.LayerID)
+			require.NoError(t, err)
+			assert.Equal(t, containerLayer.Parent, layer.Parent)
+			assert.Equal(t, []string{"committed"}, layer.Names)
+			assert.NotEmpty(t, layer.UncompressedDigest)
+
+			// The recorded digest matches the diff reconstructed from the layer's tar-split data.
+			diff, err := store.Diff("", "Committed", nil)
+			require.NoError(t, err)
+			digester := digest.Canonical.Digester()
+			_, err = io.Copy(digester.Hash(), diff)
+			require.NoError(t, err)
+			require.NoError(t, diff.Close())
+			assert.Equal(t, layer.UncompressedDigest, digester.Digest())
+
+			changes, err := store.Changes("", "Committed")
+			require.NoError(t, err)
+			assert.Contains(t, changes, archive.Change{Path: "/etc/hostname", Kind: archive.ChangeModify})
+			assert.Contains(t, changes, archive.Change{Path: "/etc/motd", Kind: archive.ChangeDelete})
+
+			// The committed layer is not affected by later changes to the container.
+			mountPoint, err = store.Mount(container.ID, "")
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(mountPoint, "etc", "hostname"), []byte("changed\n"), 0o644))
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+			mountPoint, err = store.Mount("Committed", "")
+			require.NoError(t, err)
+			data, err := os.ReadFile(filepath.Join(mountPoint, "etc", "hostname"))
+			require.NoError(t, err)
+			assert.Equal(t, "container\n", string(data))
+			_, err = store.Unmount("Committed", true)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// sizeQuota returns options which set, or with zero remove, the size limit of a container.
+func sizeQuota(size uint64) *DiskQuotaOptions {
+	return &DiskQuotaOptions{Size: &size}
+}
+
+func TestContainerQuota(t *testing.T) {
+	reexec.Init()
+
+	for _, driver := range []string{"vfs", "overlay"} {
+		t.Run(driver, func(t *testing.T) {
+			store := newTestStore(t, StoreOptions{GraphDriverName: driver})
+			shutdownTestStore(t, store)
+
+			_, _, err := store.PutLayer("Base", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "base\n")))
+			require.NoError(t, err)
+			_, err = store.CreateImage("Image", nil, "Base", "", nil)
+			require.NoError(t, err)
+			container, err := store.CreateContainer("Container", nil, "Image", "", "", nil)
+			require.NoError(t, err)
+			mountPoint, err := store.Mount(container.ID, "")
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(mountPoint, "etc", "hostname"), []byte("container\n"), 0o644))
+			// Moving the contents to and from a loopback image preserves all extended attributes.
+			hasXattr := system.Lsetxattr(filepath.Join(mountPoint, "etc", "hostname"), "trusted.quota-test", []byte("value"), 0) == nil
+			// Setting the first limit with a loopback image requires the layer not to be mounted.
+			err = store.SetContainerQuota("Container", sizeQuota(64<<20))
+			if err != nil && !errors.Is(err, ErrNotSupported) && !errors.Is(err, graphdriver.ErrNotSupported) {
+				assert.ErrorIs(t, err, graphdriver.ErrLayerInUse)
+			}
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+
+			err = store.SetContainerQuota("Container", sizeQuota(64<<20))
+			if errors.Is(err, ErrNotSupported) || errors.Is(err, graphdriver.ErrNotSupported) {
+				t.Skipf("%s can't limit the size of layers here: %v", driver, err)
+			}
+			require.NoError(t, err)
+			quota, usage, err := store.ContainerQuota("Container")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(64<<20), quota.Size)
+			assert.Positive(t, usage.Size)
+
+			// Writing more than the limit fails.
+			mountPoint, err = store.Mount(container.ID, "")
+			require.NoError(t, err)
+			big := filepath.Join(mountPoint, "big")
+			err = os.WriteFile(big, make([]byte, 80<<20), 0o644)
+			assert.Error(t, err)
+			require.NoError(t, os.Remove(big))
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+
+			require.NoError(t, store.SetContainerQuota("Container", sizeQuota(128<<20)))
+			mountPoint, err = store.Mount(container.ID, "")
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(big, make([]byte, 80<<20), 0o644))
+			quota, usage, err = store.ContainerQuota("Container")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(128<<20), quota.Size)
+			assert.GreaterOrEqual(t, usage.Size, int64(80<<20))
+			require.NoError(t, os.Remove(big))
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+
+			require.NoError(t, store.SetContainerQuota("Container", sizeQuota(32<<20)))
+			require.NoError(t, store.SetContainerQuota("Container", sizeQuota(0)))
+			quota, _, err = store.ContainerQuota("Container")
+			require.NoError(t, err)
+			assert.Zero(t, quota.Size)
+			mountPoint, err = store.Mount(container.ID, "")
+			require.NoError(t, err)
+			data, err := os.ReadFile(filepath.Join(mountPoint, "etc", "hostname"))
+			require.NoError(t, err)
+			assert.Equal(t, "container\n", string(data))
+			if hasXattr {
+				value, err := system.Lgetxattr(filepath.Join(mountPoint, "etc", "hostname"), "trusted.quota-test")
+				require.NoError(t, err)
+				assert.Equal(t, []byte("value"), value)
+			}
+			_, err = store.Unmount(container.ID, true)
+			require.NoError(t, err)
+
+			// Changing only the size keeps the inode limit, if the driver can set one.
+			inodes := uint64(100000)
+			err = store.SetContainerQuota("Container", &DiskQuotaOptions{Inodes: &inodes})
+			if err == nil {
+				require.NoError(t, store.SetContainerQuota("Container", sizeQuota(96<<20)))
+				quota, _, err = store.ContainerQuota("Container")
+				require.NoError(t, err)
+				assert.Equal(t, uint64(96<<20), quota.Size)
+				assert.Equal(t, inodes, quota.Inodes)
+			} else {
+				assert.ErrorIs(t, err, graphdriver.ErrNotSupported)
+			}
+
+			require.NoError(t, store.SetContainerQuota("Container", sizeQuota(64<<20)))
+			require.NoError(t, store.DeleteContainer("Container"))
+		})
+	}
+}
+
+func TestObjectStore(t *testing.T) {
+	reexec.Init()
+
+	for _, driver := range []string{"vfs", "overlay"} {
+		t.Run(driver, func(t *testing.T) {
+			store := newTestStore(t, StoreOptions{
+				GraphDriverName:    driver,
+				GraphDriverOptions: []string{driver + ".object_store=hardlink"},
+			})
+			shutdownTestStore(t, store)
+			layerFile := func(id, name string) string {
+				if driver == "overlay" {
+					return filepath.Join(store.GraphRoot(), driver, id, "diff", name)
+				}
+				return filepath.Join(store.GraphRoot(), driver, "dir", id, name)
+			}
+			objectsDir := filepath.Join(store.GraphRoot(), driver, "objects", "objects")
+			countObjects := func() int {
+				n := 0
+				require.NoError(t, filepath.WalkDir(objectsDir, func(path string, d os.DirEntry, err error) error {
+					if err == nil && !d.IsDir() {
+						n++
+					}
+					return err
+				}))
+				return n
+			}
+
+			_, _, err := store.PutLayer("Layer1", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "shared\n", "etc/motd", "one\n")))
+			require.NoError(t, err)
+			_, _, err = store.PutLayer("Layer2", "", nil, "", false, nil, bytes.NewReader(generateTestDiff(t, "etc/hostname", "shared\n", "etc/motd", "two\n")))
+			require.NoError(t, err)
+			assert.Equal(t, 3, countObjects())
+
+			fi1, err := os.Lstat(layerFile("Layer1", "etc/hostname"))
+			require.NoError(t, err)
+			fi2, err := os.Lstat(layerFile("Layer2", "etc/hostname"))
+			require.NoError(t, err)
+			assert.True(t, os.SameFile(fi1, fi2))
+
+			// Diffs applied to read-only layers after they are created are added too.
+			_, err = store.CreateLayer("Layer3", "", nil, "", false, nil)
+			require.NoError(t, err)
+			_, err = store.ApplyDiff("Layer3", bytes.NewReader(generateTestDiff(t, "etc/hostname", "shared\n", "etc/motd", "three\n")))
+			require.NoError(t, err)
+			assert.Equal(t, 4, countObjects())
+			fi3, err := os.Lstat(layerFile("Layer3", "etc/hostname"))
+			require.NoError(t, err)
+			assert.True(t, os.SameFile(fi1, fi3))
+			require.NoError(t, store.DeleteLayer("Layer3"))
+			assert.Equal(t, 3, countObjects())
+
+			// Container layers are not added to the object store.
+			_, err = store.CreateImage("Image", nil, "Layer1", "", nil)
+			require.NoError(t, err)
+			_, err = store.CreateContainer("Container", nil, "Image", "", "", nil)
+			require.NoError(t, err)
+			assert.Equal(t, 3, countObjects())
+			require.NoError(t, store.DeleteContainer("Container"))
+			// Deleting the image deletes Layer1, releasing its own object.
+			_, err = store.DeleteImage("Image", true)
+			require.NoError(t, err)
+			assert.Equal(t, 2, countObjects())
+			require.NoError(t, store.DeleteLayer("Layer2"))
+			assert.Equal(t, 0, countObjects())
+			require.NoError(t, store.GarbageCollect())
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+	"go.podman.io/storage/internal/staging_lockfile"
+	"go.podman.io/storage/pkg/stringid"
+)
+
+// Transaction groups operations which create layers, images and containers, so that either all of the
+// created objects persist, or none of them do: objects created in a transaction which is neither committed
+// nor rolled back, e.g. because the process was interrupted, are removed when the store is next opened.
+//
+// Only objects which did not exist before are removed; if an object with a caller-specified ID is created
+// concurrently by another process while the transaction is running, it might be removed as well.
+//
+// A Transaction must not be used concurrently from several goroutines, and it must be ended by calling
+// either Commit or Rollback.
+type Transaction struct {
+	s        *store
+	intentID string                            // The ID of the transaction’s intent in s.intents, or "" if the transaction has ended.
+	lock     *staging_lockfile.StagingLockFile // Held while the transaction is running, to prevent recovering it.
+}
+
+// BeginTransaction starts a Transaction, which groups operations creating layers, images and containers
+// so that either all of the created objects persist, or none of them do, even if the process is interrupted.
+// operation is a human-readable description of the transaction, used in log messages.
+func (s *store) BeginTransaction(operation string) (*Transaction, error) {
+	if err := os.MkdirAll(s.intents.transactionsDir, 0o700); err != nil {
+		return nil, err
+	}
+	lock, lockFile, err := staging_lockfile.CreateAndLock(s.intents.transactionsDir, "transaction-")
+	if err != nil {
+		return nil, err
+	}
+	intentID, err := s.intents.begin(storeIntent{Operation: operation, LockFile: lockFile})
+	if err != nil {
+		if err2 := lock.UnlockAndDelete(); err2 != nil {
+			logrus.Warnf("Removing transaction lock file: %v", err2)
+		}
+		return nil, err
+	}
+	return &Transaction{
+		s:        s,
+		intentID: intentID,
+		lock:     lock,
+	}, nil
+}
+
+// recordObject assigns an ID to a new object if id is empty, and, if the object does not exist yet,
+// records it in the transaction’s intent using add, so that it is removed if the transaction is not committed.
+// It returns the ID, and a function which should be called if creating the object fails because the ID is already used.
+func (t *Transaction) recordObject(id string, exists func(id string) bool, add func(intent *storeIntent, id string)) (string, func(), error) {
+	if t.intentID == "" {
+		return "", nil, errors.New("internal error: using a transaction which has already ended")
+	}
+	if id == "" {
+		id = stringid.GenerateRandomID()
+	} else if exists(id) {
+		// Creating the object will fail, or, for images in read-only stores, not remove anything on rollback.
+		return id, func() {}, nil
+	}
+	if err := t.s.intents.update(t.intentID, func(intent *storeIntent) { add(intent, id) }); err != nil {
+		return "", nil, err
+	}
+	forget := func() {
+		if err := t.s.intents.update(t.intentID, func(intent *storeIntent) {
+			intent.Layers = slices.DeleteFunc(intent.Layers, func(v string) bool { return v == id })
+			intent.Images = slices.DeleteFunc(intent.Images, func(v string) bool { return v == id })
+			intent.Containers = slices.DeleteFunc(intent.Containers, func(v string) bool { return v == id })
+		}); err != nil {
+			logrus.Warnf("Removing %q from the intent of a transaction: %v", id, err)
+		}
+	}
+	return id, forget, nil
+}
+
+// layerExists returns true if a layer with id exists in any of the layer stores.
+func (t *Transaction) layerExists(id string) bool {
+	l, err := t.s.Layer(id)
+	return err == nil && l.ID == id
+}
+
+// addLayer records a layer to remove in intent.
+// Layers created later in a transaction may be children of earlier ones, so they are removed first.
+func addLayer(intent *storeIntent, id string) {
+	intent.Layers = slices.Insert(intent.Layers, 0, id)
+}
+
+// PutLayer is store.PutLayer, with the created layer being a part of the transaction.
+func (t *Transaction) PutLayer(id, parent string, names []string, mountLabel string, writeable bool, options *LayerOptions, diff io.Reader) (*Layer, int64, error) {
+	id, forget, err := t.recordObject(id, t.layerExists, addLayer)
+	if err != nil {
+		return nil, -1, err
+	}
+	layer, size, err := t.s.PutLayer(id, parent, names, mountLabel, writeable, options, diff)
+	if errors.Is(err, ErrDuplicateID) {
+		forget()
+	}
+	return layer, size, err
+}
+
+// CreateImage is store.CreateImage, with the created image being a part of the transaction.
+func (t *Transaction) CreateImage(id string, names []string, layer, metadata string, options *ImageOptions) (*Image, error) {
+	exists := func(id string) bool {
+		i, err := t.s.Image(id)
+		return err == nil && i.ID == id
+	}
+	id, forget, err := t.recordObject(id, exists, func(intent *storeIntent, id string) {
+		intent.Images = append(intent.Images, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	image, err := t.s.CreateImage(id, names, layer, metadata, options)
+	if errors.Is(err, ErrDuplicateID) {
+		forget()
+	}
+	return image, err
+}
+
+// CreateContainer is store.CreateContainer, with the created container and its layer being a part of the transaction.
+func (t *Transaction) CreateContainer(id string, names []string, image, layer, metadata string, options *ContainerOptions) (*Container, error) {
+	layer, forgetLayer, err := t.recordObject(layer, t.layerExists, addLayer)
+	if err != nil {
+		return nil, err
+	}
+	exists := func(id string) bool {
+		c, err := t.s.Container(id)
+		return err == nil && c.ID == id
+	}
+	id, forgetContainer, err := t.recordObject(id, exists, func(intent *storeIntent, id string) {
+		intent.Containers = append(intent.Containers, id)
+	})
+	if err != nil {
+		forgetLayer()
+		return nil, err
+	}
+	container, err := t.s.CreateContainer(id, names, image, layer, metadata, options)
+	if errors.Is(err, ErrDuplicateID) {
+		forgetLayer()
+		forgetContainer()
+	}
+	return container, err
+}
+
+// end removes the transaction’s intent, and releases its lock.
+func (t *Transaction) end() error {
+	err := t.s.intents.end(t.intentID)
+	t.intentID = ""
+	if err2 := t.lock.UnlockAndDelete(); err2 != nil && err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Commit ends the transaction, keeping all objects created in it.
+// If it fails, the objects may be removed when the store is next opened.
+func (t *Transaction) Commit() error {
+	if t.intentID == "" {
+		return errors.New("internal error: committing a transaction which has already ended")
+	}
+	return t.end()
+}
+
+// Rollback ends the transaction, removing all objects created in it.
+// It does nothing if the transaction has already ended, so it can be deferred unconditionally.
+func (t *Transaction) Rollback() error {
+	if t.intentID == "" {
+		return nil
+	}
+	err := t.s.writeToAllStores(func(rlstore rwLayerStore) error {
+		intent, err := t.s.intents.get(t.intentID)
+		if err != nil {
+			return err
+		}
+		return t.s.recoverIntent(rlstore, intent)
+	})
+	if err != nil {
+		// Keep the intent, so that the rollback is completed when the store is next opened.
+		t.intentID = ""
+		if err2 := t.lock.UnlockAndDelete(); err2 != nil {
+			logrus.Warnf("Removing transaction lock file: %v", err2)
+		}
+		return fmt.Errorf("rolling back a transaction: %w", err)
+	}
+	return t.end()
+}
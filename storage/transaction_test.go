@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.podman.io/storage/pkg/archive"
+	"go.podman.io/storage/pkg/reexec"
+)
+
+// putImageInTransaction creates a layer, an image using it, and a container using the image, in tx.
+func putImageInTransaction(t *testing.T, tx *Transaction, prefix string) {
+	layerContents, err := archive.Generate("file", "contents")
+	require.NoError(t, err)
+	_, _, err = tx.PutLayer(prefix+"Layer", "", nil, "", false, nil, layerContents)
+	require.NoError(t, err)
+	_, err = tx.CreateImage(prefix+"Image", nil, prefix+"Layer", "", nil)
+	require.NoError(t, err)
+	_, err = tx.CreateContainer(prefix+"Container", nil, prefix+"Image", prefix+"ContainerLayer", "", nil)
+	require.NoError(t, err)
+}
+
+func TestTransaction(t *testing.T) {
+	reexec.Init()
+
+	options := StoreOptions{
+		GraphRoot: filepath.Join(t.TempDir(), "root"),
+		RunRoot:   filepath.Join(t.TempDir(), "run"),
+	}
+	s := newTestStore(t, options)
+
+	// Committed
+	tx, err := s.BeginTransaction("commit")
+	require.NoError(t, err)
+	putImageInTransaction(t, tx, "Committed")
+	require.NoError(t, tx.Commit())
+	assert.Error(t, tx.Commit())
+	require.NoError(t, tx.Rollback()) // Does nothing after Commit
+	for _, id := range []string{"CommittedLayer", "CommittedImage", "CommittedContainer", "CommittedContainerLayer"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	assert.Empty(t, storeIntents(t, s))
+
+	// Rolled back; objects which existed before are not affected, even if the transaction tried to create them.
+	tx, err = s.BeginTransaction("roll back")
+	require.NoError(t, err)
+	putImageInTransaction(t, tx, "RolledBack")
+	_, err = tx.CreateImage("CommittedImage", nil, "CommittedLayer", "", nil)
+	assert.ErrorIs(t, err, ErrDuplicateID)
+	_, _, err = tx.PutLayer("CommittedLayer", "", nil, "", false, nil, bytes.NewReader(nil))
+	assert.Error(t, err)
+	require.NoError(t, tx.Rollback())
+	for _, id := range []string{"RolledBackLayer", "RolledBackImage", "RolledBackContainer", "RolledBackContainerLayer"} {
+		assert.False(t, s.Exists(id), id)
+	}
+	for _, id := range []string{"CommittedLayer", "CommittedImage", "CommittedContainer", "CommittedContainerLayer"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	assert.Empty(t, storeIntents(t, s))
+
+	// A running transaction is not rolled back when the store is opened.
+	running, err := s.BeginTransaction("running")
+	require.NoError(t, err)
+	putImageInTransaction(t, running, "Running")
+	// A transaction interrupted by a crash is rolled back when the store is opened.
+	interrupted, err := s.BeginTransaction("interrupted")
+	require.NoError(t, err)
+	putImageInTransaction(t, interrupted, "Interrupted")
+	require.NoError(t, interrupted.lock.UnlockAndDelete()) // As if the process had exited
+	_, err = s.Shutdown(true)
+	require.NoError(t, err)
+	s.Free()
+
+	s = newTestStore(t, options)
+	defer func() {
+		_, err := s.Shutdown(true)
+		require.NoError(t, err)
+		s.Free()
+	}()
+	for _, id := range []string{"InterruptedLayer", "InterruptedImage", "InterruptedContainer", "InterruptedContainerLayer"} {
+		assert.False(t, s.Exists(id), id)
+	}
+	for _, id := range []string{"RunningLayer", "RunningImage", "RunningContainer", "RunningContainerLayer", "CommittedImage"} {
+		assert.True(t, s.Exists(id), id)
+	}
+	intents := storeIntents(t, s)
+	require.Len(t, intents, 1)
+	assert.Equal(t, "running", intents[0].Operation)
+	assert.Equal(t, []string{"RunningContainerLayer", "RunningLayer"}, intents[0].Layers)
+	assert.Equal(t, []string{"RunningImage"}, intents[0].Images)
+	assert.Equal(t, []string{"RunningContainer"}, intents[0].Containers)
+
+	running.s = s.(*store) // The transaction was started using the previous instance of the store.
+	require.NoError(t, running.Rollback())
+	for _, id := range []string{"RunningLayer", "RunningImage", "RunningContainer", "RunningContainerLayer"} {
+		assert.False(t, s.Exists(id), id)
+	}
+	assert.Empty(t, storeIntents(t, s))
+}
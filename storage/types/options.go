@@ -7,7 +7,9 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	units "github.com/docker/go-units"
 	"github.com/sirupsen/logrus"
 	cfg "go.podman.io/storage/pkg/config"
 	"go.podman.io/storage/pkg/configfile"
@@ -26,6 +28,9 @@ type TomlConfig struct {
 		GraphRoot           string            `toml:"graphroot,omitempty"`
 		RootlessStoragePath string            `toml:"rootless_storage_path,omitempty"`
 		TransientStore      bool              `toml:"transient_store,omitempty"`
+		MetadataBackend     string            `toml:"metadata_backend,omitempty"`
+		ImageEvictionSize   string            `toml:"image_eviction_max_size,omitempty"`
+		ImageEvictionAge    string            `toml:"image_eviction_max_age,omitempty"`
 		Options             cfg.OptionsConfig `toml:"options,omitempty"`
 	} `toml:"storage"`
 }
@@ -101,6 +106,16 @@ type StoreOptions struct {
 	DisableVolatile bool `json:"disable-volatile,omitempty"`
 	// If transient, don't persist containers over boot (stores db in runroot)
 	TransientStore bool `json:"transient_store,omitempty"`
+	// MetadataBackend selects how records of layers, images and containers are stored:
+	// "json" (the default) or "sqlite". Existing records are migrated automatically.
+	MetadataBackend string `json:"metadata_backend,omitempty"`
+	// ImageEvictionMaxSize, if positive, is the disk space images may use before the least
+	// recently used ones which no container uses are removed by Store.PruneImages, e.g. after
+	// an image is pulled.
+	ImageEvictionMaxSize int64 `json:"image_eviction_max_size,omitempty"`
+	// ImageEvictionMaxAge, if positive, is how long an image which no container uses may
+	// remain unused before it is removed by Store.PruneImages, e.g. after an image is pulled.
+	ImageEvictionMaxAge time.Duration `json:"image_eviction_max_age,omitempty"`
 }
 
 // setDefaultRootlessStoreOptions sets the storage opts for containers running as non root
@@ -211,6 +226,21 @@ func LoadStoreOptions(opts LoadOptions) (StoreOptions, error) {
 
 	storeOptions.DisableVolatile = config.Storage.Options.DisableVolatile
 	storeOptions.TransientStore = config.Storage.TransientStore
+	storeOptions.MetadataBackend = config.Storage.MetadataBackend
+	if config.Storage.ImageEvictionSize != "" {
+		size, err := units.RAMInBytes(config.Storage.ImageEvictionSize)
+		if err != nil {
+			return storeOptions, fmt.Errorf("parsing image_eviction_max_size: %w", err)
+		}
+		storeOptions.ImageEvictionMaxSize = size
+	}
+	if config.Storage.ImageEvictionAge != "" {
+		age, err := time.ParseDuration(config.Storage.ImageEvictionAge)
+		if err != nil {
+			return storeOptions, fmt.Errorf("parsing image_eviction_max_age: %w", err)
+		}
+		storeOptions.ImageEvictionMaxAge = age
+	}
 
 	storeOptions.GraphDriverOptions = append(storeOptions.GraphDriverOptions, cfg.GetGraphDriverOptions(config.Storage.Options)...)
 